@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"user-management/internal/audit"
+	"user-management/internal/config"
+	"user-management/internal/database"
+	"user-management/internal/repository"
+)
+
+const pageSize = 1000
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s verify [-env local] [-public-key base64]\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "verify" {
+		usage()
+	}
+
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	env := flags.String("env", "local", "config environment")
+	publicKeyFlag := flags.String("public-key", "", "base64 Ed25519 public key for checkpoints; derived from the configured signing key when empty")
+	_ = flags.Parse(os.Args[2:])
+
+	conf, err := config.GetConfig(*env)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	publicKey, err := checkpointPublicKey(*publicKeyFlag, conf.Audit)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx := context.Background()
+	db, err := database.NewDatabase(ctx, conf.Postgres)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := verify(ctx, repository.NewAuditRepository(db), publicKey); err != nil {
+		var broken *audit.BrokenLinkError
+		if errors.As(err, &broken) {
+			fmt.Printf("FAIL: %v\n", broken)
+			db.Close()
+			os.Exit(1)
+		}
+		log.Fatalf("verification failed: %v", err)
+	}
+}
+
+func verify(ctx context.Context, repo repository.AuditRepository, publicKey ed25519.PublicKey) error {
+	checkpoints, err := repo.ListCheckpoints(ctx)
+	if err != nil {
+		return err
+	}
+	if publicKey == nil && len(checkpoints) > 0 {
+		return errors.New("checkpoints exist but no public key is available to verify them")
+	}
+
+	verifier, err := audit.NewChainVerifier(publicKey, checkpoints)
+	if err != nil {
+		return err
+	}
+
+	var afterID int64
+	for {
+		events, err := repo.ListAfter(ctx, afterID, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range events {
+			if err := verifier.Add(&events[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < pageSize {
+			break
+		}
+		afterID = events[len(events)-1].ID
+	}
+
+	if err := verifier.Finish(); err != nil {
+		return err
+	}
+
+	fmt.Printf("OK: %d events and %d checkpoints verified\n", verifier.Verified(), len(checkpoints))
+	return nil
+}
+
+func checkpointPublicKey(flagValue string, conf config.AuditConfig) (ed25519.PublicKey, error) {
+	if flagValue != "" {
+		raw, err := base64.StdEncoding.DecodeString(flagValue)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid public key")
+		}
+		return raw, nil
+	}
+
+	if conf.CheckpointSigningKey == "" {
+		return nil, nil
+	}
+
+	signer, err := audit.NewSigner(conf.CheckpointSigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+	"user-management/internal/config"
+	"user-management/internal/database"
+	"user-management/internal/repository"
+	"user-management/internal/secrets"
+	"user-management/internal/service"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s rotate|list [-env local]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s encryption-key\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command := os.Args[1]
+	switch command {
+	case "rotate", "list":
+	case "encryption-key":
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println(key)
+		return
+	default:
+		usage()
+	}
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	env := flags.String("env", "local", "config environment")
+	_ = flags.Parse(os.Args[2:])
+
+	conf, err := config.GetConfig(*env)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx := context.Background()
+	db, err := database.NewDatabase(ctx, conf.Postgres)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	keys, err := service.NewSigningKeyService(conf.SigningKeys, repository.NewSigningKeyRepository(db))
+	if err != nil {
+		db.Close()
+		log.Fatalf("failed to set up signing keys: %v", err)
+	}
+
+	if command == "rotate" {
+		// Running instances pick up the new key when they next reload.
+		if _, err := keys.Rotate(ctx, true); err != nil {
+			db.Close()
+			log.Fatalf("failed to rotate signing keys: %v", err)
+		}
+	}
+	if err := list(ctx, keys); err != nil {
+		db.Close()
+		log.Fatalf("failed to list signing keys: %v", err)
+	}
+}
+
+func list(ctx context.Context, keys *service.SigningKeyService) error {
+	valid, err := keys.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSTATE\tCREATED\tEXPIRES")
+	for _, key := range valid {
+		expires := "-"
+		if key.ExpiresAt != nil {
+			expires = key.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.State, key.CreatedAt.Format(time.RFC3339), expires)
+	}
+	return w.Flush()
+}
@@ -1,7 +1,286 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"net"
+	"os"
+	"time"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/api/route"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/cache"
+	"user-management/internal/changefeed"
+	"user-management/internal/config"
+	"user-management/internal/database"
+	"user-management/internal/gateway"
+	"user-management/internal/mail"
+	"user-management/internal/outbox"
+	"user-management/internal/ratelimit"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+	"user-management/internal/siem"
+	"user-management/internal/webhook"
+)
 
 func main() {
-	fmt.Println("Salam")
+	env := os.Getenv("APP_ENV")
+	if env == "" {
+		env = "local"
+	}
+
+	conf, err := config.GetConfig(env)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := database.NewDatabase(ctx, conf.Postgres)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	validate := validator.New()
+
+	var roleRepo repository.RoleRepository = repository.NewRoleRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	var userRoleRepo repository.UserRoleRepository = repository.NewUserRoleRepository(db)
+
+	changeFeed := changefeed.NewListener(database.ConnectionString(conf.Postgres))
+
+	var redisClient *redis.Client
+	if conf.Cache.Enabled || conf.RateLimit.Enabled {
+		redisClient, err = database.NewRedis(ctx, conf.Redis)
+		if err != nil {
+			log.Printf("redis unavailable, permission cache disabled and rate limits kept in memory: %v\n", err)
+		} else {
+			defer redisClient.Close()
+		}
+	}
+
+	if conf.Cache.Enabled && redisClient != nil {
+		permissionCache := cache.NewPermissionCache(redisClient, conf.Cache.PermissionTTL)
+		changeFeed.Subscribe(cache.NewChangeHandler(permissionCache, roleRepo, userRoleRepo))
+		roleRepo = cache.NewRoleRepository(roleRepo, userRoleRepo, permissionCache)
+		userRoleRepo = cache.NewUserRoleRepository(userRoleRepo, permissionCache)
+	}
+
+	go func() {
+		if err := changeFeed.Run(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("change feed stopped: %v\n", err)
+		}
+	}()
+
+	signingKeys, err := service.NewSigningKeyService(conf.SigningKeys, repository.NewSigningKeyRepository(db))
+	if err != nil {
+		log.Fatalf("failed to set up signing keys: %v", err)
+	}
+	go signingKeys.Run(ctx, time.Minute)
+
+	tokenManager := auth.NewTokenManager(conf.JWT)
+	tokenManager.SetKeySource(signingKeys)
+	gatewayRules, err := gateway.NewRuleSet(conf.Gateway.Rules)
+	if err != nil {
+		log.Fatalf("invalid gateway rules: %v", err)
+	}
+	gatewayAuthorizer := gateway.NewAuthorizer(tokenManager, gatewayRules, userRoleRepo)
+
+	authzService := service.NewAuthzService(userRoleRepo)
+	mfaRepo := repository.NewMFARepository(db)
+	userRepo := repository.NewUserRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	if conf.Cache.Enabled && redisClient != nil {
+		sessionRepo = cache.NewSessionRepository(sessionRepo, redisClient, conf.Cache.SessionTTL)
+	}
+	sessionService := service.NewSessionService(conf.Sessions, sessionRepo, userRepo, userRoleRepo, tokenManager)
+	tokenManager.SetSessionValidator(sessionService)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	apiKeyService := service.NewAPIKeyService(conf.APIKeys, apiKeyRepo, userRoleRepo)
+	tokenManager.SetAPIKeyValidator(apiKeyService)
+	revokedTokenRepo := repository.NewRevokedTokenRepository(db)
+	if conf.Cache.Enabled && redisClient != nil {
+		revokedTokenRepo = cache.NewRevokedTokenRepository(revokedTokenRepo, redisClient, conf.Cache.SessionTTL)
+	}
+	tokenManager.SetRevocationList(revokedTokenRepo)
+	loginThrottle := service.NewLoginThrottle(conf.LoginProtection, repository.NewLoginAttemptRepository(db))
+	loginPolicyRepo := repository.NewLoginPolicyRepository(db)
+	authService := service.NewAuthService(userRepo, userRoleRepo, mfaRepo, loginPolicyRepo, tokenManager, loginThrottle, sessionService)
+	tokenManager.SetOrganizationPolicy(authService)
+	webauthnRepo := repository.NewWebAuthnRepository(db)
+	passkeyService, err := service.NewPasskeyService(conf.WebAuthn, userRepo, userRoleRepo, webauthnRepo, loginPolicyRepo, tokenManager, sessionService, authService)
+	if err != nil {
+		log.Fatalf("invalid webauthn config: %v", err)
+	}
+	identityRepo := repository.NewIdentityRepository(db)
+	federationService, err := service.NewFederationService(conf.Federation, identityRepo, userRepo, webauthnRepo, authService)
+	if err != nil {
+		log.Fatalf("invalid federation config: %v", err)
+	}
+	samlService := service.NewSAMLService(conf.SAML, repository.NewSAMLRepository(db), identityRepo, userRepo, userRoleRepo, authService)
+
+	mailer, err := mail.NewMailer(conf.Mail)
+	if err != nil {
+		log.Fatalf("invalid mail config: %v", err)
+	}
+	mailTemplates, err := mail.LoadTemplates()
+	if err != nil {
+		log.Fatalf("failed to load mail templates: %v", err)
+	}
+	accountService := service.NewAccountService(conf.Account, userRepo, repository.NewUserTokenRepository(db), sessionRepo, apiKeyRepo, mailer, mailTemplates)
+	defer accountService.Wait()
+	passwordlessService := service.NewPasswordlessService(conf.Passwordless, repository.NewPasswordlessRepository(db), userRepo, authService, mailer, mailTemplates)
+	defer passwordlessService.Wait()
+
+	if conf.Audit.CheckpointSigningKey != "" {
+		signer, err := audit.NewSigner(conf.Audit.CheckpointSigningKey)
+		if err != nil {
+			log.Fatalf("invalid audit config: %v", err)
+		}
+		go service.NewAuditCheckpointService(auditRepo, signer).Run(ctx, conf.Audit.CheckpointInterval)
+	}
+
+	if conf.Audit.Export.Enabled {
+		shipper, err := newAuditShipper(conf.Audit.Export, auditRepo)
+		if err != nil {
+			log.Fatalf("invalid audit export config: %v", err)
+		}
+		go shipper.Run(ctx, conf.Audit.Export.Interval)
+	}
+
+	webhookRepo := repository.NewWebhookRepository(db)
+	webhookDeliverer := webhook.NewDeliverer(webhookRepo, webhook.DelivererOptions{
+		MaxAttempts:          conf.Webhooks.MaxAttempts,
+		DisableAfter:         conf.Webhooks.DisableAfter,
+		BatchSize:            conf.Webhooks.BatchSize,
+		Timeout:              conf.Webhooks.Timeout,
+		AllowPrivateNetworks: conf.Webhooks.AllowPrivateNetworks,
+	})
+
+	var publishers outbox.Fanout
+	if conf.Outbox.Publisher != "" {
+		publisher, err := outbox.NewPublisher(conf.Outbox.Publisher, conf.Outbox.URL, conf.Outbox.Target)
+		if err != nil {
+			log.Fatalf("invalid outbox config: %v", err)
+		}
+		publishers = append(publishers, publisher)
+	}
+	if conf.Webhooks.Enabled {
+		publishers = append(publishers, webhook.NewDispatcher(webhookRepo))
+		go webhookDeliverer.Run(ctx, conf.Webhooks.Interval)
+	}
+	if conf.Outbox.Enabled && len(publishers) > 0 {
+		relay := outbox.NewRelay(repository.NewOutboxRepository(db), publishers, conf.Outbox.BatchSize, conf.Outbox.Retention)
+		go relay.Run(ctx, conf.Outbox.Interval)
+	}
+
+	authzHandler := handler.NewAuthzHandler(validate, authzService)
+	forwardAuthHandler := handler.NewForwardAuthHandler(gatewayAuthorizer)
+	auditHandler := handler.NewAuditHandler(validate, auditRepo)
+	webhookHandler := handler.NewWebhookHandler(validate, webhookRepo, webhookDeliverer)
+	authHandler := handler.NewAuthHandler(validate, authService, tokenManager)
+	mfaHandler := handler.NewMFAHandler(validate, authService, mfaRepo, tokenManager)
+	accountHandler := handler.NewAccountHandler(validate, accountService)
+	passwordlessHandler := handler.NewPasswordlessHandler(validate, passwordlessService, tokenManager)
+	sessionHandler := handler.NewSessionHandler(validate, sessionService, tokenManager)
+	passkeyHandler := handler.NewPasskeyHandler(validate, passkeyService, webauthnRepo, tokenManager)
+	federationHandler := handler.NewFederationHandler(validate, federationService, identityRepo, tokenManager)
+	samlHandler := handler.NewSAMLHandler(validate, samlService, tokenManager)
+	scimRepo := repository.NewSCIMRepository(db)
+	scimHandler := handler.NewSCIMHandler(validate, scimRepo)
+
+	if conf.Gateway.GRPCPort != "" {
+		listener, err := net.Listen("tcp", ":"+conf.Gateway.GRPCPort)
+		if err != nil {
+			log.Fatalf("failed to listen for ext_authz: %v", err)
+		}
+		grpcServer := gateway.NewGRPCServer(gatewayAuthorizer)
+		defer grpcServer.GracefulStop()
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Printf("ext_authz server stopped: %v\n", err)
+			}
+		}()
+	}
+
+	router, err := route.NewRouter(conf.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("failed to set up router: %v", err)
+	}
+	router.Use(middleware.AuditContext())
+	var authLimits []gin.HandlerFunc
+	if conf.RateLimit.Enabled {
+		router.Use(middleware.RateLimit(ratelimit.New(redisClient, "default", newRate(conf.RateLimit.Default)), middleware.KeyByIP))
+		authLimits = append(authLimits, middleware.RateLimit(ratelimit.New(redisClient, "auth", newRate(conf.RateLimit.Auth)), middleware.KeyByIP))
+		router.Use(middleware.PrincipalRateLimit(newLimiter(redisClient, "user", conf.RateLimit.User), newLimiter(redisClient, "api_key", conf.RateLimit.APIKey)))
+	}
+	api := router.Group("/api")
+	route.SetupAuthRoutes(api, authHandler, mfaHandler, accountHandler, tokenManager, userRoleRepo, authLimits...)
+	route.SetupPasswordlessRoutes(api, passwordlessHandler, authLimits...)
+	route.SetupPasskeyRoutes(api, passkeyHandler, tokenManager, authLimits...)
+	route.SetupFederationRoutes(api, federationHandler, tokenManager, authLimits...)
+	route.SetupSAMLRoutes(router, api, samlHandler, tokenManager, userRoleRepo, authLimits...)
+	route.SetupSessionRoutes(api, sessionHandler, tokenManager, userRoleRepo, authLimits...)
+	route.SetupAuthzRoutes(api, authzHandler, tokenManager)
+	route.SetupGatewayRoutes(api, forwardAuthHandler)
+	route.SetupAuditRoutes(api, auditHandler, tokenManager, userRoleRepo)
+	route.SetupWebhookRoutes(api, webhookHandler, tokenManager, userRoleRepo)
+	route.SetupSCIMRoutes(router, api, scimHandler, scimRepo, tokenManager, userRoleRepo)
+
+	route.SetupAPIKeyRoutes(api, handler.NewAPIKeyHandler(validate, apiKeyService), tokenManager)
+
+	serviceAccountService := service.NewServiceAccountService(conf.ServiceAccounts, repository.NewServiceAccountRepository(db), roleRepo, userRoleRepo, tokenManager)
+	route.SetupServiceAccountRoutes(api, handler.NewServiceAccountHandler(validate, serviceAccountService), tokenManager, userRoleRepo)
+
+	var oauthService *service.OAuthService
+	if conf.OIDC.Enabled {
+		oauthService = service.NewOAuthService(conf.OIDC, repository.NewOAuthRepository(db), userRepo, userRoleRepo, sessionService, signingKeys)
+	}
+	introspectionService := service.NewIntrospectionService(tokenManager, sessionService, apiKeyService, serviceAccountService, oauthService, userRoleRepo, revokedTokenRepo)
+	route.SetupOAuthRoutes(router, api, handler.NewOAuthHandler(validate, oauthService, signingKeys, serviceAccountService, introspectionService), tokenManager, userRoleRepo)
+
+	if err := router.Run(":" + conf.Server.Port); err != nil {
+		log.Fatalf("server stopped: %v", err)
+	}
+}
+
+func newRate(rule config.RateLimitRule) ratelimit.Rate {
+	return ratelimit.Rate{Requests: rule.Requests, Period: rule.Period, Burst: rule.Burst}
+}
+
+// newLimiter returns the limiter of rule, or nil when it allows no requests.
+func newLimiter(client *redis.Client, name string, rule config.RateLimitRule) ratelimit.Limiter {
+	if rule.Requests <= 0 {
+		return nil
+	}
+	return ratelimit.New(client, name, newRate(rule))
+}
+
+func newAuditShipper(conf config.AuditExportConfig, auditRepo repository.AuditRepository) (*siem.Shipper, error) {
+	formatter, err := siem.NewFormatter(conf.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	var sink siem.Sink
+	switch conf.Sink {
+	case "socket":
+		sink, err = siem.NewSocketSink(conf.Network, conf.Address)
+	case "file":
+		sink, err = siem.NewRotatingFileSink(conf.FilePath, conf.MaxFileSize, conf.MaxBackups)
+	default:
+		err = fmt.Errorf("unknown sink %q", conf.Sink)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return siem.NewShipper(conf.Name, auditRepo, formatter, sink), nil
 }
@@ -0,0 +1,22 @@
+package dto
+
+import (
+	"time"
+	"user-management/internal/models"
+)
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+	// Scopes are permission names. Without any, the key carries every
+	// permission of the user in the organization.
+	Scopes     []string   `json:"scopes" validate:"max=100,dive,required,max=100"`
+	AllowedIPs []string   `json:"allowed_ips" validate:"max=50,dive,required,cidr|ip"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// APIKeySecretResponse is returned when a key is created. It is the only
+// time the key is shown.
+type APIKeySecretResponse struct {
+	models.APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,28 @@
+package dto
+
+import (
+	"time"
+	"user-management/internal/models"
+)
+
+type AuditListQuery struct {
+	ActorID    string     `form:"actor_id" validate:"omitempty,uuid"`
+	Action     string     `form:"action" validate:"omitempty,max=100"`
+	TargetType string     `form:"target_type" validate:"omitempty,max=50"`
+	TargetID   string     `form:"target_id" validate:"omitempty,max=100"`
+	From       *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor     string     `form:"cursor" validate:"omitempty,base64url"`
+	Limit      int        `form:"limit" validate:"omitempty,min=1,max=200"`
+}
+
+type AuditListResponse struct {
+	Events     []models.AuditEvent `json:"events"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type AuditExportQuery struct {
+	Format string     `form:"format" validate:"omitempty,oneof=jsonl cef syslog"`
+	From   *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To     *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+}
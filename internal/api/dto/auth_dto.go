@@ -0,0 +1,67 @@
+package dto
+
+import "github.com/google/uuid"
+
+type LoginRequest struct {
+	Email          string     `json:"email" validate:"required,email,max=255"`
+	Password       string     `json:"password" validate:"required,max=128"`
+	OrganizationID *uuid.UUID `json:"organization_id"`
+}
+
+// LoginResponse carries either an access token or, when the login needs a
+// second factor or MFA enrollment, the MFA token to continue with.
+type LoginResponse struct {
+	AccessToken           string `json:"access_token,omitempty"`
+	RefreshToken          string `json:"refresh_token,omitempty"`
+	TokenType             string `json:"token_type,omitempty"`
+	ExpiresIn             int    `json:"expires_in,omitempty"`
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string `json:"mfa_token,omitempty"`
+}
+
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	// Code is a TOTP code or a recovery code.
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	// QRCode is a data URI of a PNG encoding OTPAuthURI.
+	QRCode string `json:"qr_code"`
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+// RecoveryCodesResponse is the only time recovery codes are shown. Login is
+// set when enrollment completed a login held back by the MFA policy.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string       `json:"recovery_codes"`
+	Login         *LoginResponse `json:"login,omitempty"`
+}
+
+// ReauthenticateRequest confirms a sensitive MFA change with the password
+// and a TOTP or recovery code.
+type ReauthenticateRequest struct {
+	Password string `json:"password" validate:"required,max=128"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+type MFAStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+type UpdateMFAPolicyRequest struct {
+	RequiredRoleIDs []uuid.UUID `json:"required_role_ids" validate:"max=100,unique"`
+}
+
+// UpdateLoginPolicyRequest lists the login methods to allow: password,
+// magic_link, passkey and sso. ["sso"] makes an organization SSO only.
+type UpdateLoginPolicyRequest struct {
+	Methods []string `json:"methods" validate:"required,min=1,max=4,unique,dive,oneof=password magic_link passkey sso"`
+}
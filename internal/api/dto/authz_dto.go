@@ -0,0 +1,27 @@
+package dto
+
+import "github.com/google/uuid"
+
+type AuthzCheckItem struct {
+	UserID         uuid.UUID `json:"user_id" validate:"required" example:"123e4567-e89b-12d3-a456-426614174000"`
+	OrganizationID uuid.UUID `json:"organization_id" validate:"required" example:"123e4567-e89b-12d3-a456-426614174001"`
+	Permission     string    `json:"permission" validate:"required,max=100" example:"user:read"`
+	Resource       string    `json:"resource,omitempty" validate:"omitempty,max=100" example:"user"`
+}
+
+type AuthzCheckRequest struct {
+	Checks []AuthzCheckItem `json:"checks" validate:"required,min=1,max=100,dive"`
+}
+
+type AuthzDecisionDTO struct {
+	UserID         uuid.UUID `json:"user_id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Permission     string    `json:"permission"`
+	Resource       string    `json:"resource,omitempty"`
+	Allowed        bool      `json:"allowed" example:"true"`
+	Reason         string    `json:"reason" example:"granted"`
+}
+
+type AuthzCheckResponse struct {
+	Decisions []AuthzDecisionDTO `json:"decisions"`
+}
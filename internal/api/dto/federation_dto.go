@@ -0,0 +1,27 @@
+package dto
+
+import "github.com/google/uuid"
+
+type FederationProviderResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+type BeginFederatedLoginRequest struct {
+	OrganizationID *uuid.UUID `json:"organization_id"`
+}
+
+// FederationRedirectResponse starts a login at an upstream provider. The
+// frontend sends the user to AuthorizationURL and keeps State, to check
+// that the state the user returns with is the same.
+type FederationRedirectResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+// FederationCallbackRequest carries the code and state that the provider
+// sent the user back to the redirect URL with.
+type FederationCallbackRequest struct {
+	State string `json:"state" validate:"required,max=128"`
+	Code  string `json:"code" validate:"required,max=2048"`
+}
@@ -0,0 +1,96 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+)
+
+// AuthorizeRequest carries the parameters of an authorization request,
+// which the login page received on its query string.
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type" validate:"required,max=32"`
+	ClientID            string `json:"client_id" validate:"required,max=64"`
+	RedirectURI         string `json:"redirect_uri" validate:"required,max=2048"`
+	Scope               string `json:"scope" validate:"max=512"`
+	State               string `json:"state" validate:"max=512"`
+	Nonce               string `json:"nonce" validate:"max=512"`
+	CodeChallenge       string `json:"code_challenge" validate:"max=128"`
+	CodeChallengeMethod string `json:"code_challenge_method" validate:"max=16"`
+	Prompt              string `json:"prompt" validate:"max=64"`
+}
+
+func (r *AuthorizeRequest) AuthorizationRequest() *oidc.AuthorizationRequest {
+	return &oidc.AuthorizationRequest{
+		ResponseType:        r.ResponseType,
+		ClientID:            r.ClientID,
+		RedirectURI:         r.RedirectURI,
+		Scope:               r.Scope,
+		State:               r.State,
+		Nonce:               r.Nonce,
+		CodeChallenge:       r.CodeChallenge,
+		CodeChallengeMethod: r.CodeChallengeMethod,
+		Prompt:              r.Prompt,
+	}
+}
+
+type ConsentRequest struct {
+	AuthorizeRequest
+	Approve *bool `json:"approve" validate:"required"`
+}
+
+// AuthorizeResponse either redirects the browser back to the client or
+// asks the user to consent to the client and scopes first.
+type AuthorizeResponse struct {
+	RedirectTo      string           `json:"redirect_to,omitempty"`
+	ConsentRequired bool             `json:"consent_required,omitempty"`
+	Client          *OAuthClientInfo `json:"client,omitempty"`
+	Scopes          []string         `json:"scopes,omitempty"`
+}
+
+// OAuthClientInfo is what the consent prompt shows about a client.
+type OAuthClientInfo struct {
+	ClientID uuid.UUID `json:"client_id"`
+	Name     string    `json:"name"`
+}
+
+// DeviceRequest carries the user code that a user entered on the
+// verification page of the device grant.
+type DeviceRequest struct {
+	UserCode string `json:"user_code" validate:"required,max=16"`
+}
+
+type DeviceConsentRequest struct {
+	DeviceRequest
+	Approve *bool `json:"approve" validate:"required"`
+}
+
+// DeviceResponse is what the verification page shows about a device
+// authorization request for the user to approve.
+type DeviceResponse struct {
+	Client OAuthClientInfo `json:"client"`
+	Scopes []string        `json:"scopes"`
+}
+
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=255"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris" validate:"max=20,dive,required,url,max=2048"`
+	Scopes       []string `json:"scopes" validate:"required,min=1,dive,oneof=openid profile email phone offline_access"`
+	SkipConsent  bool     `json:"skip_consent"`
+}
+
+type UpdateOAuthClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=255"`
+	RedirectURIs []string `json:"redirect_uris" validate:"max=20,dive,required,url,max=2048"`
+	Scopes       []string `json:"scopes" validate:"required,min=1,dive,oneof=openid profile email phone offline_access"`
+	SkipConsent  bool     `json:"skip_consent"`
+}
+
+// OAuthClientSecretResponse is returned when a confidential client is
+// created or its secret is rotated. It is the only time the secret is
+// shown.
+type OAuthClientSecretResponse struct {
+	models.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
@@ -0,0 +1,41 @@
+package dto
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+)
+
+// PasskeyOptionsResponse starts a passkey ceremony. Options is passed to
+// navigator.credentials.create() or .get(), and SessionID is sent back with
+// the result.
+type PasskeyOptionsResponse struct {
+	SessionID uuid.UUID   `json:"session_id"`
+	Options   interface{} `json:"options"`
+}
+
+// BeginPasskeyRegistrationRequest confirms that the user is present before
+// a passkey is added: with the SessionID of a passkey login ceremony and the
+// Credential returned by navigator.credentials.get() for it, or with the
+// password and, when MFA is enabled, a TOTP or recovery Code.
+type BeginPasskeyRegistrationRequest struct {
+	Password   string          `json:"password" validate:"required_without=Credential,max=128"`
+	Code       string          `json:"code" validate:"max=64"`
+	SessionID  uuid.UUID       `json:"session_id" validate:"required_with=Credential"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+// FinishPasskeyRegistrationRequest carries the PublicKeyCredential returned
+// by navigator.credentials.create(), serialized as JSON.
+type FinishPasskeyRegistrationRequest struct {
+	SessionID  uuid.UUID       `json:"session_id" validate:"required"`
+	Name       string          `json:"name" validate:"max=100"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+// FinishPasskeyLoginRequest carries the PublicKeyCredential returned by
+// navigator.credentials.get(), serialized as JSON.
+type FinishPasskeyLoginRequest struct {
+	SessionID      uuid.UUID       `json:"session_id" validate:"required"`
+	OrganizationID *uuid.UUID      `json:"organization_id"`
+	Credential     json.RawMessage `json:"credential" validate:"required"`
+}
@@ -0,0 +1,26 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type StartPasswordlessLoginRequest struct {
+	Email          string     `json:"email" validate:"required,email,max=255"`
+	OrganizationID *uuid.UUID `json:"organization_id"`
+}
+
+// PasswordlessLoginResponse carries the device token that the browser keeps
+// and sends back with the mailed code or link.
+type PasswordlessLoginResponse struct {
+	DeviceToken string    `json:"device_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// VerifyPasswordlessLoginRequest completes a login with either the mailed
+// Code or the Token of the mailed link.
+type VerifyPasswordlessLoginRequest struct {
+	DeviceToken string `json:"device_token" validate:"required,max=128"`
+	Code        string `json:"code" validate:"required_without=Token,omitempty,numeric,len=6"`
+	Token       string `json:"token" validate:"required_without=Code,omitempty,max=128"`
+}
@@ -0,0 +1,45 @@
+package dto
+
+import "github.com/google/uuid"
+
+// SAMLConnectionRequest configures the SAML connection of the current
+// organization. IdPMetadata is the metadata XML of the IdP.
+type SAMLConnectionRequest struct {
+	IdPMetadata       string                      `json:"idp_metadata" validate:"required,max=262144"`
+	AttributeMapping  SAMLAttributeMappingRequest `json:"attribute_mapping"`
+	RoleMappings      []SAMLRoleMappingRequest    `json:"role_mappings" validate:"max=100,dive"`
+	DefaultRoleID     *uuid.UUID                  `json:"default_role_id"`
+	AllowIdPInitiated bool                        `json:"allow_idp_initiated"`
+	CreateUsers       bool                        `json:"create_users"`
+	Enabled           *bool                       `json:"enabled" validate:"required"`
+}
+
+type SAMLAttributeMappingRequest struct {
+	Email     string `json:"email" validate:"max=255"`
+	FirstName string `json:"first_name" validate:"max=255"`
+	LastName  string `json:"last_name" validate:"max=255"`
+	Groups    string `json:"groups" validate:"max=255"`
+}
+
+type SAMLRoleMappingRequest struct {
+	Group  string    `json:"group" validate:"required,max=255"`
+	RoleID uuid.UUID `json:"role_id" validate:"required"`
+}
+
+// SAMLServiceProviderResponse has the values to register with the IdP of
+// the organization.
+type SAMLServiceProviderResponse struct {
+	EntityID    string `json:"entity_id"`
+	ACSURL      string `json:"acs_url"`
+	MetadataURL string `json:"metadata_url"`
+}
+
+type BeginSAMLLoginRequest struct {
+	OrganizationID uuid.UUID `json:"organization_id" validate:"required"`
+}
+
+// SAMLCallbackRequest carries the code that the assertion consumer service
+// sent the user to the redirect URL with.
+type SAMLCallbackRequest struct {
+	Code string `json:"code" validate:"required,max=128"`
+}
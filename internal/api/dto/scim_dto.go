@@ -0,0 +1,20 @@
+package dto
+
+import "user-management/internal/models"
+
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// SCIMTokenResponse is returned when a token is created. It is the only time
+// the token is shown.
+type SCIMTokenResponse struct {
+	models.SCIMToken
+	Token string `json:"token"`
+}
+
+type SCIMListQuery struct {
+	Filter     string `form:"filter"`
+	StartIndex int    `form:"startIndex"`
+	Count      *int   `form:"count"`
+}
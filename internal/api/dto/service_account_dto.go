@@ -0,0 +1,29 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"user-management/internal/models"
+)
+
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name" validate:"required,max=255"`
+	Description string `json:"description" validate:"max=1000"`
+}
+
+type UpdateServiceAccountRequest struct {
+	Name        string `json:"name" validate:"required,max=255"`
+	Description string `json:"description" validate:"max=1000"`
+	IsActive    *bool  `json:"is_active" validate:"required"`
+}
+
+// ServiceAccountSecretResponse is returned when a service account is
+// created or its secret is rotated. It is the only time the secret is
+// shown.
+type ServiceAccountSecretResponse struct {
+	models.ServiceAccount
+	ClientSecret string `json:"client_secret"`
+}
+
+type AssignServiceAccountRoleRequest struct {
+	RoleID uuid.UUID `json:"role_id" validate:"required"`
+}
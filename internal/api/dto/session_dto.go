@@ -0,0 +1,33 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=128"`
+}
+
+// SessionResponse describes a session. Current marks the session of the
+// token the request was made with.
+type SessionResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+	Device         string     `json:"device"`
+	UserAgent      string     `json:"user_agent"`
+	IPAddress      string     `json:"ip_address"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastSeenAt     time.Time  `json:"last_seen_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	Current        bool       `json:"current"`
+}
+
+type RevokedSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+type UpdateSessionPolicyRequest struct {
+	// MaxSessionsPerUser of 0 removes the limit.
+	MaxSessionsPerUser int `json:"max_sessions_per_user" validate:"min=0,max=1000"`
+}
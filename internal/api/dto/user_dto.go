@@ -31,3 +31,8 @@ type ErrorResponse struct {
 	Message string                 `json:"message" example:"Validation failed"`
 	Errors  map[string]interface{} `json:"errors,omitempty"`
 }
+
+type SuccessResponse struct {
+	Status string      `json:"status" example:"success"`
+	Data   interface{} `json:"data"`
+}
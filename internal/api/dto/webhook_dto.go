@@ -0,0 +1,34 @@
+package dto
+
+import "user-management/internal/models"
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url" validate:"required,http_url,max=2048"`
+	Description string   `json:"description" validate:"omitempty,max=500"`
+	EventTypes  []string `json:"event_types" validate:"omitempty,max=50,dive,required,max=100"`
+}
+
+type UpdateWebhookRequest struct {
+	URL         string   `json:"url" validate:"required,http_url,max=2048"`
+	Description string   `json:"description" validate:"omitempty,max=500"`
+	EventTypes  []string `json:"event_types" validate:"omitempty,max=50,dive,required,max=100"`
+	IsActive    *bool    `json:"is_active" validate:"required"`
+}
+
+// WebhookSecretResponse is returned when a subscription is created or its
+// secret is rotated. It is the only time the secret is shown.
+type WebhookSecretResponse struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+type WebhookDeliveryQuery struct {
+	Status string `form:"status" validate:"omitempty,oneof=pending succeeded failed"`
+	Cursor string `form:"cursor" validate:"omitempty,base64url"`
+	Limit  int    `form:"limit" validate:"omitempty,min=1,max=200"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
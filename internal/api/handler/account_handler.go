@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type AccountHandler struct {
+	validator *validator.Validate
+	accounts  *service.AccountService
+}
+
+func NewAccountHandler(validator *validator.Validate, accountService *service.AccountService) *AccountHandler {
+	return &AccountHandler{
+		validator: validator,
+		accounts:  accountService,
+	}
+}
+
+// ForgotPassword mails a reset link. It responds 202 whether or not the
+// address has an account.
+func (h *AccountHandler) ForgotPassword(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	if err := h.accounts.RequestPasswordReset(c.Request.Context(), req.Email, c.GetHeader("Accept-Language")); err != nil {
+		internalError(c, err, "Failed to request password reset")
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func (h *AccountHandler) ResetPassword(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	if err := h.accounts.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		h.error(c, err, "Failed to reset password")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SendVerificationEmail mails the current user a link to verify their
+// address.
+func (h *AccountHandler) SendVerificationEmail(c *gin.Context) {
+	err := h.accounts.SendVerificationEmail(c.Request.Context(), middleware.CurrentUserID(c), c.GetHeader("Accept-Language"))
+	if err != nil {
+		h.error(c, err, "Failed to send verification email")
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func (h *AccountHandler) VerifyEmail(c *gin.Context) {
+	var req dto.VerifyEmailRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	if err := h.accounts.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		h.error(c, err, "Failed to verify email")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AccountHandler) error(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrUserTokenInvalid):
+		errorResponse(c, http.StatusBadRequest, "Invalid or expired token")
+	case errors.Is(err, service.ErrEmailAlreadyVerified):
+		errorResponse(c, http.StatusConflict, "Email address is already verified")
+	case errors.Is(err, repository.ErrUserNotFound):
+		errorResponse(c, http.StatusNotFound, "User not found")
+	default:
+		internalError(c, err, message)
+	}
+}
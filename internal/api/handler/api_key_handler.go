@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type APIKeyHandler struct {
+	validator *validator.Validate
+	apiKeys   *service.APIKeyService
+}
+
+func NewAPIKeyHandler(validator *validator.Validate, apiKeys *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		validator: validator,
+		apiKeys:   apiKeys,
+	}
+}
+
+// Create issues a key for the current user in the current organization.
+// Only logins may create keys, so that a key cannot mint further keys.
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	if claims := middleware.CurrentClaims(c); claims == nil || claims.Principal != "" {
+		errorResponse(c, http.StatusForbidden, "API keys can only be created by a logged in user")
+		return
+	}
+	organizationID, ok := middleware.CurrentOrganizationID(c)
+	if !ok {
+		errorResponse(c, http.StatusForbidden, "Organization required")
+		return
+	}
+
+	var req dto.CreateAPIKeyRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	key := &models.APIKey{
+		UserID:         middleware.CurrentUserID(c),
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		Scopes:         req.Scopes,
+		AllowedIPs:     req.AllowedIPs,
+		ExpiresAt:      req.ExpiresAt,
+	}
+	secret, err := h.apiKeys.Create(c.Request.Context(), key)
+	if errors.Is(err, service.ErrScopeNotHeld) || errors.Is(err, service.ErrInvalidExpiry) {
+		errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(c, err, "Failed to create API key")
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.APIKeySecretResponse{APIKey: *key, Key: secret},
+	})
+}
+
+// List returns the current user's keys in every organization.
+func (h *APIKeyHandler) List(c *gin.Context) {
+	keys, err := h.apiKeys.List(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		internalError(c, err, "Failed to list API keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   keys,
+	})
+}
+
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	err = h.apiKeys.Revoke(c.Request.Context(), middleware.CurrentUserID(c), id)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		errorResponse(c, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		internalError(c, err, "Failed to revoke API key")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
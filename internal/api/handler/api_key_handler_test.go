@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/ratelimit"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type fakeAPIKeyRepository struct {
+	repository.APIKeyRepository
+	keys    map[uuid.UUID]*models.APIKey
+	touches int
+}
+
+func (f *fakeAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	key.ID = uuid.New()
+	key.CreatedAt = time.Now()
+	key.UserActive = true
+	copied := *key
+	f.keys[key.ID] = &copied
+	return nil
+}
+
+func (f *fakeAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	for _, key := range f.keys {
+		if key.Prefix == prefix {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrAPIKeyNotFound
+}
+
+func (f *fakeAPIKeyRepository) Touch(ctx context.Context, id uuid.UUID, lastUsedAt time.Time, ip string) error {
+	f.keys[id].LastUsedAt = &lastUsedAt
+	f.keys[id].LastUsedIP = ip
+	f.touches++
+	return nil
+}
+
+func (f *fakeAPIKeyRepository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	key, ok := f.keys[id]
+	if !ok || key.UserID != userID {
+		return repository.ErrAPIKeyNotFound
+	}
+	delete(f.keys, id)
+	return nil
+}
+
+func TestAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys := &fakeAPIKeyRepository{keys: map[uuid.UUID]*models.APIKey{}}
+	userRoles := &fakePermissionUserRoleRepository{permissions: []string{"users:read", "users:write"}}
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	tokens.SetAPIKeyValidator(service.NewAPIKeyService(config.APIKeyConfig{}, keys, userRoles))
+	h := NewAPIKeyHandler(validator.New(), service.NewAPIKeyService(config.APIKeyConfig{}, keys, userRoles))
+
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com"}
+	organizationID := uuid.New()
+	login, err := tokens.GenerateAccessToken(user, organizationID)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(middleware.AuditContext())
+	router.POST("/api-keys", middleware.Authenticate(tokens), h.Create)
+	router.DELETE("/api-keys/:id", middleware.Authenticate(tokens), h.Revoke)
+	for _, permission := range []string{"users:read", "users:write"} {
+		router.GET("/"+strings.ReplaceAll(permission, ":", "/"), middleware.Authenticate(tokens), middleware.RequirePermission(userRoles, permission), func(c *gin.Context) {
+			require.Equal(t, user.ID, middleware.CurrentUserID(c))
+			c.Status(http.StatusNoContent)
+		})
+	}
+
+	create := func(credential string, body map[string]interface{}) (int, map[string]interface{}) {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+credential)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp.Data
+	}
+	call := func(header, value, permission string) int {
+		req := httptest.NewRequest(http.MethodGet, "/"+strings.ReplaceAll(permission, ":", "/"), nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	status, created := create(login, map[string]interface{}{"name": "ci"})
+	require.Equal(t, http.StatusCreated, status)
+	key, id := created["key"].(string), created["id"].(string)
+	require.True(t, strings.HasPrefix(key, created["prefix"].(string)+"_"))
+	require.Len(t, created["prefix"], len(auth.APIKeyPrefix)+16)
+	require.Equal(t, http.StatusNoContent, call("Authorization", "Bearer "+key, "users:write"))
+	require.Equal(t, http.StatusNoContent, call(auth.HeaderAPIKey, key, "users:read"))
+	require.Equal(t, http.StatusUnauthorized, call(auth.HeaderAPIKey, key+"x", "users:read"))
+	require.Equal(t, http.StatusUnauthorized, call(auth.HeaderAPIKey, login, "users:read"))
+	require.Equal(t, 1, keys.touches)
+
+	// Keys created with the earlier 8 character prefixes keep working.
+	parsed, _ := uuid.Parse(id)
+	legacy := *keys.keys[parsed]
+	legacy.ID, legacy.Prefix = uuid.New(), auth.APIKeyPrefix+"1a2b3c4d"
+	legacyKey := legacy.Prefix + "_a_secret"
+	legacy.KeyHash = fmt.Sprintf("%x", sha256.Sum256([]byte(legacyKey)))
+	keys.keys[legacy.ID] = &legacy
+	require.Equal(t, http.StatusNoContent, call(auth.HeaderAPIKey, legacyKey, "users:read"))
+
+	// Keys cannot create keys.
+	status, _ = create(key, map[string]interface{}{"name": "nested"})
+	require.Equal(t, http.StatusForbidden, status)
+
+	// Scopes limit a key to a subset of the user's permissions.
+	status, created = create(login, map[string]interface{}{"name": "read-only", "scopes": []string{"users:read"}})
+	require.Equal(t, http.StatusCreated, status)
+	scoped := created["key"].(string)
+	require.Equal(t, http.StatusNoContent, call(auth.HeaderAPIKey, scoped, "users:read"))
+	require.Equal(t, http.StatusForbidden, call(auth.HeaderAPIKey, scoped, "users:write"))
+
+	status, _ = create(login, map[string]interface{}{"name": "admin", "scopes": []string{"roles:write"}})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// The allowlist is checked against the client address; httptest
+	// requests come from 192.0.2.1.
+	status, created = create(login, map[string]interface{}{"name": "office", "allowed_ips": []string{"192.0.2.0/24"}})
+	require.Equal(t, http.StatusCreated, status)
+	require.Equal(t, http.StatusNoContent, call(auth.HeaderAPIKey, created["key"].(string), "users:read"))
+	status, created = create(login, map[string]interface{}{"name": "elsewhere", "allowed_ips": []string{"198.51.100.7"}})
+	require.Equal(t, http.StatusCreated, status)
+	require.Equal(t, http.StatusUnauthorized, call(auth.HeaderAPIKey, created["key"].(string), "users:read"))
+	status, _ = create(login, map[string]interface{}{"name": "bad", "allowed_ips": []string{"not-an-ip"}})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = create(login, map[string]interface{}{"name": "past", "expires_at": time.Now().Add(-time.Hour)})
+	require.Equal(t, http.StatusBadRequest, status)
+	status, created = create(login, map[string]interface{}{"name": "short", "expires_at": time.Now().Add(time.Hour)})
+	require.Equal(t, http.StatusCreated, status)
+	expiring, _ := uuid.Parse(created["id"].(string))
+	expired := time.Now().Add(-time.Second)
+	keys.keys[expiring].ExpiresAt = &expired
+	require.Equal(t, http.StatusUnauthorized, call(auth.HeaderAPIKey, created["key"].(string), "users:read"))
+
+	// Revoked keys stop working at once.
+	req := httptest.NewRequest(http.MethodDelete, "/api-keys/"+id, nil)
+	req.Header.Set("Authorization", "Bearer "+login)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, http.StatusUnauthorized, call(auth.HeaderAPIKey, key, "users:read"))
+}
+
+func TestAPIKeysAreRateLimitedApartFromTheirUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys := &fakeAPIKeyRepository{keys: map[uuid.UUID]*models.APIKey{}}
+	userRoles := &fakePermissionUserRoleRepository{permissions: []string{"users:read"}}
+	apiKeys := service.NewAPIKeyService(config.APIKeyConfig{}, keys, userRoles)
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	tokens.SetAPIKeyValidator(apiKeys)
+
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com"}
+	organizationID := uuid.New()
+	login, err := tokens.GenerateAccessToken(user, organizationID)
+	require.NoError(t, err)
+	ctx := context.Background()
+	first, err := apiKeys.Create(ctx, &models.APIKey{UserID: user.ID, OrganizationID: organizationID, Name: "first"})
+	require.NoError(t, err)
+	second, err := apiKeys.Create(ctx, &models.APIKey{UserID: user.ID, OrganizationID: organizationID, Name: "second"})
+	require.NoError(t, err)
+
+	once := ratelimit.Rate{Requests: 1, Period: time.Hour}
+	router := gin.New()
+	router.Use(middleware.AuditContext(), middleware.PrincipalRateLimit(ratelimit.NewMemoryLimiter(once), ratelimit.NewMemoryLimiter(once)))
+	router.GET("/me", middleware.Authenticate(tokens), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	call := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Each key has a bucket of its own, apart from that of the user.
+	require.Equal(t, http.StatusNoContent, call("Authorization", "Bearer "+login))
+	require.Equal(t, http.StatusTooManyRequests, call("Authorization", "Bearer "+login))
+	require.Equal(t, http.StatusNoContent, call(auth.HeaderAPIKey, first))
+	require.Equal(t, http.StatusTooManyRequests, call("Authorization", "Bearer "+first))
+	require.Equal(t, http.StatusNoContent, call(auth.HeaderAPIKey, second))
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-management/internal/api/middleware"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeAuditRepository struct {
+	repository.AuditRepository
+	filter repository.AuditFilter
+}
+
+func (f *fakeAuditRepository) List(ctx context.Context, filter repository.AuditFilter) ([]models.AuditEvent, error) {
+	f.filter = filter
+	events := make([]models.AuditEvent, filter.Limit)
+	for i := range events {
+		events[i].ID = 100 - int64(i)
+	}
+	return events, nil
+}
+
+func TestAuditListScopesToOrganizationAndPaginates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := &fakeAuditRepository{}
+	h := NewAuditHandler(validator.New(), repo)
+	org := uuid.New()
+
+	router := gin.New()
+	router.GET("/audit", func(c *gin.Context) {
+		c.Set(middleware.ContextKeyOrganizationID, org)
+	}, h.List)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+		"/audit?limit=2&action=user.created&from=2026-01-01T00:00:00Z&cursor="+encodeAuditCursor(500), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Equal(t, org, *repo.filter.OrganizationID)
+	require.Equal(t, "user.created", repo.filter.Action)
+	require.Equal(t, int64(500), repo.filter.BeforeID)
+	require.Equal(t, 2026, repo.filter.From.Year())
+
+	var body struct {
+		Data struct {
+			NextCursor string `json:"next_cursor"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	next, ok := decodeAuditCursor(body.Data.NextCursor)
+	require.True(t, ok)
+	require.Equal(t, int64(99), next)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?actor_id=nope", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
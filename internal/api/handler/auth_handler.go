@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type AuthHandler struct {
+	validator *validator.Validate
+	auth      *service.AuthService
+	tokens    *auth.TokenManager
+}
+
+func NewAuthHandler(validator *validator.Validate, authService *service.AuthService, tokens *auth.TokenManager) *AuthHandler {
+	return &AuthHandler{
+		validator: validator,
+		auth:      authService,
+		tokens:    tokens,
+	}
+}
+
+// Login authenticates with email and password. When MFA is needed the
+// response has mfa_required and an mfa_token for VerifyMFA, or, when the
+// organization requires MFA the user has not enrolled in,
+// mfa_enrollment_required and a token for the enrollment endpoints.
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req dto.LoginRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID := uuid.Nil
+	if req.OrganizationID != nil {
+		organizationID = *req.OrganizationID
+	}
+
+	result, err := h.auth.Login(c.Request.Context(), req.Email, req.Password, organizationID)
+	if err != nil {
+		h.error(c, err, "Failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newLoginResponse(result, h.tokens),
+	})
+}
+
+// VerifyMFA completes a login with a TOTP or recovery code.
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req dto.VerifyMFARequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	result, err := h.auth.VerifyMFA(c.Request.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		h.error(c, err, "Failed to verify code")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newLoginResponse(result, h.tokens),
+	})
+}
+
+// UnlockUser lifts the lockout that failed logins put on the account of a
+// member of the current organization.
+func (h *AuthHandler) UnlockUser(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	if err := h.auth.UnlockUser(c.Request.Context(), organizationID, id); err != nil {
+		h.error(c, err, "Failed to unlock user")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) GetLoginPolicy(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	policy, err := h.auth.GetLoginPolicy(c.Request.Context(), organizationID)
+	if err != nil {
+		internalError(c, err, "Failed to get login policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   policy,
+	})
+}
+
+// UpdateLoginPolicy sets the login methods the members of the current
+// organization may log in to it with.
+func (h *AuthHandler) UpdateLoginPolicy(c *gin.Context) {
+	var req dto.UpdateLoginPolicyRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	policy := &models.LoginPolicy{OrganizationID: organizationID, Methods: req.Methods}
+	if err := h.auth.SetLoginPolicy(c.Request.Context(), policy); err != nil {
+		internalError(c, err, "Failed to update login policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   policy,
+	})
+}
+
+func (h *AuthHandler) error(c *gin.Context, err error, message string) {
+	var throttled *service.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		throttledResponse(c, throttled)
+	case errors.Is(err, service.ErrInvalidCredentials):
+		errorResponse(c, http.StatusUnauthorized, "Invalid email or password")
+	case errors.Is(err, service.ErrNotMember):
+		errorResponse(c, http.StatusForbidden, "Not a member of the organization")
+	case errors.Is(err, service.ErrLoginMethodNotAllowed):
+		errorResponse(c, http.StatusForbidden, "The organization does not allow this login method")
+	case errors.Is(err, auth.ErrInvalidToken):
+		errorResponse(c, http.StatusUnauthorized, "Invalid or expired MFA token")
+	case errors.Is(err, service.ErrInvalidMFACode):
+		errorResponse(c, http.StatusUnauthorized, "Invalid verification code")
+	case errors.Is(err, service.ErrMFANotEnabled):
+		errorResponse(c, http.StatusConflict, "MFA is not enabled")
+	case errors.Is(err, repository.ErrUserNotFound):
+		errorResponse(c, http.StatusNotFound, "User not found")
+	case errors.Is(err, repository.ErrUserNotLocked):
+		errorResponse(c, http.StatusConflict, "User is not locked out")
+	default:
+		internalError(c, err, message)
+	}
+}
+
+func newLoginResponse(result *service.LoginResult, tokens *auth.TokenManager) dto.LoginResponse {
+	if result.AccessToken == "" {
+		return dto.LoginResponse{
+			MFARequired:           !result.MFAEnrollmentRequired,
+			MFAEnrollmentRequired: result.MFAEnrollmentRequired,
+			MFAToken:              result.MFAToken,
+		}
+	}
+	return dto.LoginResponse{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokens.AccessTokenTTL().Seconds()),
+	}
+}
+
+// throttledResponse rejects a throttled login with 429 and the time to
+// retry after.
+func throttledResponse(c *gin.Context, throttled *service.LoginThrottledError) {
+	middleware.SetRetryAfter(c, throttled.RetryAfter.Seconds())
+	if throttled.Locked {
+		errorResponse(c, http.StatusTooManyRequests, "Too many failed login attempts, temporarily locked")
+	} else {
+		errorResponse(c, http.StatusTooManyRequests, "Too many failed login attempts, retry later")
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/service"
+)
+
+type AuthzHandler struct {
+	validator *validator.Validate
+	authz     *service.AuthzService
+}
+
+func NewAuthzHandler(validator *validator.Validate, authz *service.AuthzService) *AuthzHandler {
+	return &AuthzHandler{
+		validator: validator,
+		authz:     authz,
+	}
+}
+
+// Check evaluates a batch of checks for a service account. Every check must
+// be in the organization of the account.
+func (h *AuthzHandler) Check(c *gin.Context) {
+	var req dto.AuthzCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Validation failed",
+			Errors:  validationErrors(err),
+		})
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	checks := make([]service.AuthzCheck, len(req.Checks))
+	for i, item := range req.Checks {
+		if item.OrganizationID != organizationID {
+			errorResponse(c, http.StatusForbidden, "Checks are limited to the caller's organization")
+			return
+		}
+		checks[i] = service.AuthzCheck{
+			UserID:         item.UserID,
+			OrganizationID: item.OrganizationID,
+			Permission:     item.Permission,
+			Resource:       item.Resource,
+		}
+	}
+
+	decisions := h.authz.CheckBatch(c.Request.Context(), checks)
+
+	resp := dto.AuthzCheckResponse{Decisions: make([]dto.AuthzDecisionDTO, len(decisions))}
+	for i, decision := range decisions {
+		item := req.Checks[i]
+		resp.Decisions[i] = dto.AuthzDecisionDTO{
+			UserID:         item.UserID,
+			OrganizationID: item.OrganizationID,
+			Permission:     item.Permission,
+			Resource:       item.Resource,
+			Allowed:        decision.Allowed,
+			Reason:         decision.Reason,
+		}
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   resp,
+	})
+}
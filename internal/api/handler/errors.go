@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"log"
+	"net/http"
+	"user-management/internal/api/dto"
+)
+
+func validationErrors(err error) map[string]interface{} {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		result[fieldError.Namespace()] = fieldError.Tag()
+	}
+	return result
+}
+
+// bindJSON decodes and validates the request body, writing the error
+// response and returning false when either fails.
+func bindJSON(c *gin.Context, validate *validator.Validate, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Invalid request body",
+		})
+		return false
+	}
+
+	if err := validate.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Validation failed",
+			Errors:  validationErrors(err),
+		})
+		return false
+	}
+
+	return true
+}
+
+func errorResponse(c *gin.Context, status int, message string) {
+	c.JSON(status, dto.ErrorResponse{
+		Status:  "error",
+		Message: message,
+	})
+}
+
+func internalError(c *gin.Context, err error, message string) {
+	log.Printf("%v\n", err)
+	errorResponse(c, http.StatusInternalServerError, message)
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type FederationHandler struct {
+	validator  *validator.Validate
+	federation *service.FederationService
+	identities repository.IdentityRepository
+	tokens     *auth.TokenManager
+}
+
+func NewFederationHandler(validator *validator.Validate, federationService *service.FederationService, identityRepo repository.IdentityRepository, tokens *auth.TokenManager) *FederationHandler {
+	return &FederationHandler{
+		validator:  validator,
+		federation: federationService,
+		identities: identityRepo,
+		tokens:     tokens,
+	}
+}
+
+// Providers lists the upstream providers users can log in with.
+func (h *FederationHandler) Providers(c *gin.Context) {
+	providers := []dto.FederationProviderResponse{}
+	for _, provider := range h.federation.Providers() {
+		providers = append(providers, dto.FederationProviderResponse{
+			Name:        provider.Name,
+			DisplayName: provider.DisplayName,
+		})
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   providers,
+	})
+}
+
+// BeginLogin returns the URL of the provider to send the user to.
+func (h *FederationHandler) BeginLogin(c *gin.Context) {
+	var req dto.BeginFederatedLoginRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID := uuid.Nil
+	if req.OrganizationID != nil {
+		organizationID = *req.OrganizationID
+	}
+
+	redirect, err := h.federation.BeginLogin(c.Request.Context(), c.Param("provider"), organizationID)
+	if err != nil {
+		h.error(c, err, "Failed to start login")
+		return
+	}
+
+	h.redirect(c, redirect)
+}
+
+// FinishLogin completes the login the user returned from, and responds in
+// the same shape as the password login.
+func (h *FederationHandler) FinishLogin(c *gin.Context) {
+	var req dto.FederationCallbackRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	result, err := h.federation.FinishLogin(c.Request.Context(), req.State, req.Code)
+	if err != nil {
+		h.error(c, err, "Failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newLoginResponse(result, h.tokens),
+	})
+}
+
+func (h *FederationHandler) List(c *gin.Context) {
+	identities, err := h.identities.ListByUser(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		internalError(c, err, "Failed to list identities")
+		return
+	}
+	if identities == nil {
+		identities = []models.Identity{}
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   identities,
+	})
+}
+
+// BeginLink returns the URL of the provider to send the current user to,
+// to link their account there.
+func (h *FederationHandler) BeginLink(c *gin.Context) {
+	redirect, err := h.federation.BeginLink(c.Request.Context(), c.Param("provider"), middleware.CurrentUserID(c))
+	if err != nil {
+		h.error(c, err, "Failed to start linking")
+		return
+	}
+
+	h.redirect(c, redirect)
+}
+
+func (h *FederationHandler) FinishLink(c *gin.Context) {
+	var req dto.FederationCallbackRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	identity, err := h.federation.FinishLink(c.Request.Context(), req.State, req.Code, middleware.CurrentUserID(c))
+	if err != nil {
+		h.error(c, err, "Failed to link identity")
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.SuccessResponse{
+		Status: "success",
+		Data:   identity,
+	})
+}
+
+func (h *FederationHandler) Unlink(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid identity ID")
+		return
+	}
+
+	if err := h.federation.Unlink(c.Request.Context(), middleware.CurrentUserID(c), id); err != nil {
+		h.error(c, err, "Failed to unlink identity")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *FederationHandler) redirect(c *gin.Context, redirect *service.FederationRedirect) {
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.FederationRedirectResponse{
+			AuthorizationURL: redirect.URL,
+			State:            redirect.State,
+		},
+	})
+}
+
+func (h *FederationHandler) error(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider):
+		errorResponse(c, http.StatusNotFound, "Identity provider not found")
+	case errors.Is(err, repository.ErrFederationStateNotFound):
+		errorResponse(c, http.StatusBadRequest, "Login not found or expired")
+	case errors.Is(err, service.ErrFederatedLoginFailed):
+		errorResponse(c, http.StatusUnauthorized, "Login with the identity provider failed")
+	case errors.Is(err, service.ErrNoAccount):
+		errorResponse(c, http.StatusForbidden, "No account for this identity")
+	case errors.Is(err, service.ErrAccountNotLinked):
+		errorResponse(c, http.StatusConflict, "An account with this email address exists, log in to link the identity")
+	case errors.Is(err, repository.ErrIdentityExists):
+		errorResponse(c, http.StatusConflict, "Identity is already linked")
+	case errors.Is(err, repository.ErrIdentityNotFound):
+		errorResponse(c, http.StatusNotFound, "Identity not found")
+	case errors.Is(err, service.ErrLastLoginMethod):
+		errorResponse(c, http.StatusConflict, "The only way to log in cannot be removed")
+	case errors.Is(err, service.ErrNotMember):
+		errorResponse(c, http.StatusForbidden, "Not a member of the organization")
+	case errors.Is(err, service.ErrLoginMethodNotAllowed):
+		errorResponse(c, http.StatusForbidden, "The organization does not allow this login method")
+	default:
+		internalError(c, err, message)
+	}
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/dto"
+	"user-management/internal/auth"
+	"user-management/internal/gateway"
+)
+
+// ForwardAuthHandler serves nginx auth_request and Traefik ForwardAuth
+// subrequests. The original method and URI are taken from the headers set by
+// the proxy, falling back to the subrequest itself.
+type ForwardAuthHandler struct {
+	authorizer *gateway.Authorizer
+}
+
+func NewForwardAuthHandler(authorizer *gateway.Authorizer) *ForwardAuthHandler {
+	return &ForwardAuthHandler{authorizer: authorizer}
+}
+
+func (h *ForwardAuthHandler) Check(c *gin.Context) {
+	method := firstHeader(c, "X-Forwarded-Method", "X-Original-Method")
+	if method == "" {
+		method = c.Request.Method
+	}
+	uri := firstHeader(c, "X-Forwarded-Uri", "X-Original-URI")
+	if uri == "" {
+		uri = c.Request.URL.RequestURI()
+	}
+
+	decision := h.authorizer.Authorize(c.Request.Context(), gateway.Request{
+		Method:         method,
+		Path:           uri,
+		Authorization:  c.GetHeader("Authorization"),
+		APIKey:         c.GetHeader(auth.HeaderAPIKey),
+		OrganizationID: c.GetHeader(gateway.HeaderOrganizationID),
+	})
+
+	if !decision.Allowed {
+		c.JSON(decision.Status, dto.ErrorResponse{
+			Status:  "error",
+			Message: decision.Reason,
+		})
+		return
+	}
+
+	for name, value := range decision.Headers {
+		c.Header(name, value)
+	}
+	c.Status(decision.Status)
+}
+
+func firstHeader(c *gin.Context, names ...string) string {
+	for _, name := range names {
+		if value := c.GetHeader(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type MFAHandler struct {
+	validator *validator.Validate
+	auth      *service.AuthService
+	mfa       repository.MFARepository
+	tokens    *auth.TokenManager
+}
+
+func NewMFAHandler(validator *validator.Validate, authService *service.AuthService, mfaRepo repository.MFARepository, tokens *auth.TokenManager) *MFAHandler {
+	return &MFAHandler{
+		validator: validator,
+		auth:      authService,
+		mfa:       mfaRepo,
+		tokens:    tokens,
+	}
+}
+
+func (h *MFAHandler) Status(c *gin.Context) {
+	status, err := h.auth.MFAStatus(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		internalError(c, err, "Failed to get MFA status")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.MFAStatusResponse{
+			Enabled:                status.Enabled,
+			RecoveryCodesRemaining: status.RecoveryCodesRemaining,
+		},
+	})
+}
+
+// EnrollTOTP starts TOTP enrollment with a new secret, replacing any earlier
+// unconfirmed one.
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	enrollment, err := h.auth.BeginTOTPEnrollment(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		h.error(c, err, "Failed to start MFA enrollment")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.TOTPEnrollmentResponse{
+			Secret:     enrollment.Secret,
+			OTPAuthURI: enrollment.URI,
+			QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(enrollment.QRCode),
+		},
+	})
+}
+
+// ConfirmTOTP enables MFA with a code from the authenticator and returns the
+// recovery codes. Called with an enrollment token, it also completes the
+// login that the MFA policy held back.
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	var req dto.ConfirmTOTPRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	userID := middleware.CurrentUserID(c)
+	codes, err := h.auth.ConfirmTOTPEnrollment(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		h.error(c, err, "Failed to enable MFA")
+		return
+	}
+
+	resp := dto.RecoveryCodesResponse{RecoveryCodes: codes}
+	if c.GetBool(middleware.ContextKeyMFAEnrollment) {
+		organizationID, _ := middleware.CurrentOrganizationID(c)
+		amr := middleware.CurrentClaims(c).AMR
+		result, err := h.auth.CompleteLogin(c.Request.Context(), userID, organizationID, amr...)
+		if err != nil {
+			h.error(c, err, "Failed to complete login")
+			return
+		}
+		login := newLoginResponse(result, h.tokens)
+		resp.Login = &login
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   resp,
+	})
+}
+
+// DisableTOTP turns MFA off. It requires the password and a current TOTP or
+// recovery code.
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	var req dto.ReauthenticateRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	if err := h.auth.DisableMFA(c.Request.Context(), middleware.CurrentUserID(c), req.Password, req.Code); err != nil {
+		h.error(c, err, "Failed to disable MFA")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req dto.ReauthenticateRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	codes, err := h.auth.RegenerateRecoveryCodes(c.Request.Context(), middleware.CurrentUserID(c), req.Password, req.Code)
+	if err != nil {
+		h.error(c, err, "Failed to regenerate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.RecoveryCodesResponse{RecoveryCodes: codes},
+	})
+}
+
+func (h *MFAHandler) GetPolicy(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	policy, err := h.mfa.GetPolicy(c.Request.Context(), organizationID)
+	if err != nil {
+		internalError(c, err, "Failed to get MFA policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   policy,
+	})
+}
+
+// UpdatePolicy replaces the roles whose holders must sign in with MFA.
+func (h *MFAHandler) UpdatePolicy(c *gin.Context) {
+	var req dto.UpdateMFAPolicyRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	policy := &models.MFAPolicy{OrganizationID: organizationID, RequiredRoleIDs: req.RequiredRoleIDs}
+	if policy.RequiredRoleIDs == nil {
+		policy.RequiredRoleIDs = []uuid.UUID{}
+	}
+	err := h.mfa.SetPolicy(c.Request.Context(), policy)
+	if errors.Is(err, repository.ErrMFAInvalidRole) {
+		errorResponse(c, http.StatusBadRequest, "Roles must belong to the organization")
+		return
+	}
+	if err != nil {
+		internalError(c, err, "Failed to update MFA policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   policy,
+	})
+}
+
+func (h *MFAHandler) error(c *gin.Context, err error, message string) {
+	var throttled *service.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		throttledResponse(c, throttled)
+	case errors.Is(err, repository.ErrMFAAlreadyEnabled):
+		errorResponse(c, http.StatusConflict, "MFA is already enabled")
+	case errors.Is(err, service.ErrReauthenticationFailed):
+		errorResponse(c, http.StatusUnauthorized, "Invalid password or verification code")
+	case errors.Is(err, service.ErrInvalidMFACode):
+		errorResponse(c, http.StatusUnauthorized, "Invalid verification code")
+	case errors.Is(err, service.ErrMFANotEnabled):
+		errorResponse(c, http.StatusConflict, "MFA is not enabled")
+	default:
+		internalError(c, err, message)
+	}
+}
@@ -0,0 +1,597 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+// jwksMaxAge is how long relying parties may cache the key set. It is well
+// below the retention of retired keys, so that caches pick up a new key
+// before tokens signed with it are the only ones issued.
+const jwksMaxAge = "max-age=300"
+
+// OAuthHandler serves the token, introspection and revocation endpoints,
+// the key set and, when oauthService is not nil, the rest of the OpenID
+// Connect provider. Service accounts use the token endpoint with the
+// client_credentials grant either way.
+type OAuthHandler struct {
+	validator       *validator.Validate
+	oauth           *service.OAuthService
+	keys            *service.SigningKeyService
+	serviceAccounts *service.ServiceAccountService
+	introspection   *service.IntrospectionService
+}
+
+func NewOAuthHandler(validator *validator.Validate, oauthService *service.OAuthService, keys *service.SigningKeyService, serviceAccounts *service.ServiceAccountService, introspection *service.IntrospectionService) *OAuthHandler {
+	return &OAuthHandler{
+		validator:       validator,
+		oauth:           oauthService,
+		keys:            keys,
+		serviceAccounts: serviceAccounts,
+		introspection:   introspection,
+	}
+}
+
+// Provider reports whether the OpenID Connect provider is enabled.
+func (h *OAuthHandler) Provider() bool {
+	return h.oauth != nil
+}
+
+func (h *OAuthHandler) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, oidc.NewDiscovery(h.oauth.Issuer()))
+}
+
+// JWKS publishes the keys tokens are signed with, including retired keys
+// whose tokens may still be valid.
+func (h *OAuthHandler) JWKS(c *gin.Context) {
+	jwks, err := h.keys.JWKS(c.Request.Context())
+	if err != nil {
+		internalError(c, err, "Failed to load signing keys")
+		return
+	}
+
+	c.Header("Cache-Control", jwksMaxAge)
+	c.JSON(http.StatusOK, jwks)
+}
+
+// Authorize is the authorization endpoint. It checks the request and sends
+// the browser to the login page, which logs the user in and continues with
+// the authenticated Authorize API.
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	req := oidc.ParseAuthorizationRequest(c.Request.URL.Query())
+	_, _, err := h.oauth.CheckAuthorization(c.Request.Context(), req)
+	if err != nil {
+		h.authorizeError(c, req, err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.oauth.LoginRedirect(req))
+}
+
+// AuthorizeUser decides an authorization request for the logged in user.
+// The login page follows redirect_to, or shows the consent prompt.
+func (h *OAuthHandler) AuthorizeUser(c *gin.Context) {
+	var body dto.AuthorizeRequest
+	if !bindJSON(c, h.validator, &body) {
+		return
+	}
+
+	req := body.AuthorizationRequest()
+	claims := middleware.CurrentClaims(c)
+	decision, err := h.oauth.Authorize(c.Request.Context(), req, middleware.CurrentUserID(c), claims.AMR)
+	if err != nil {
+		h.authorizeError(c, req, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newAuthorizeResponse(decision),
+	})
+}
+
+// Consent records the user's answer to the consent prompt and returns the
+// redirect back to the client.
+func (h *OAuthHandler) Consent(c *gin.Context) {
+	var body dto.ConsentRequest
+	if !bindJSON(c, h.validator, &body) {
+		return
+	}
+
+	req := body.AuthorizationRequest()
+	claims := middleware.CurrentClaims(c)
+	decision, err := h.oauth.Consent(c.Request.Context(), req, middleware.CurrentUserID(c), claims.AMR, *body.Approve)
+	if err != nil {
+		h.authorizeError(c, req, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newAuthorizeResponse(decision),
+	})
+}
+
+// authorizeError reports a failed authorization request. Protocol errors
+// go back to the client through the redirect URI, which is only trusted
+// once the client and redirect URI are known to match.
+func (h *OAuthHandler) authorizeError(c *gin.Context, req *oidc.AuthorizationRequest, err error) {
+	var oauthErr *oidc.Error
+	switch {
+	case errors.Is(err, service.ErrInvalidClient):
+		errorResponse(c, http.StatusBadRequest, "Unknown client or redirect URI")
+	case errors.As(err, &oauthErr):
+		if c.Request.Method == http.MethodGet {
+			c.Redirect(http.StatusFound, req.ErrorRedirect(oauthErr))
+			return
+		}
+		c.JSON(http.StatusOK, dto.SuccessResponse{
+			Status: "success",
+			Data:   dto.AuthorizeResponse{RedirectTo: req.ErrorRedirect(oauthErr)},
+		})
+	default:
+		internalError(c, err, "Failed to authorize")
+	}
+}
+
+// Token is the token endpoint. Clients authenticate with HTTP Basic or
+// with client_id and client_secret in the form; for the client_credentials
+// grant, they are the credentials of a service account.
+func (h *OAuthHandler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	if err := c.Request.ParseForm(); err != nil {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidRequest, "invalid form body"))
+		return
+	}
+	form := c.Request.PostForm
+
+	clientID, secret, ok := clientCredentials(c)
+	if !ok {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidClient, "client authentication failed"))
+		return
+	}
+
+	grantType := form.Get("grant_type")
+	if grantType == oidc.GrantTypeClientCredentials {
+		resp, err := h.serviceAccounts.Token(c.Request.Context(), clientID, secret, form.Get("scope"))
+		if err != nil {
+			h.oauthError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if h.oauth == nil {
+		h.oauthError(c, oidc.NewError(oidc.ErrorUnsupportedGrantType, ""))
+		return
+	}
+
+	client, err := h.oauth.AuthenticateClient(c.Request.Context(), clientID, secret)
+	if err != nil {
+		h.oauthError(c, err)
+		return
+	}
+
+	var resp *oidc.TokenResponse
+	switch grantType {
+	case oidc.GrantTypeAuthorizationCode:
+		resp, err = h.oauth.Exchange(c.Request.Context(), client, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"))
+	case oidc.GrantTypeRefreshToken:
+		resp, err = h.oauth.Refresh(c.Request.Context(), client, form.Get("refresh_token"), form.Get("scope"))
+	case oidc.GrantTypeDeviceCode:
+		resp, err = h.oauth.DeviceToken(c.Request.Context(), client, form.Get("device_code"))
+	default:
+		err = oidc.NewError(oidc.ErrorUnsupportedGrantType, "")
+	}
+	if err != nil {
+		h.oauthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeviceAuthorization is the device authorization endpoint of RFC 8628.
+// Clients authenticate as at the token endpoint.
+func (h *OAuthHandler) DeviceAuthorization(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	if err := c.Request.ParseForm(); err != nil {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidRequest, "invalid form body"))
+		return
+	}
+	clientID, secret, ok := clientCredentials(c)
+	if !ok {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidClient, "client authentication failed"))
+		return
+	}
+	client, err := h.oauth.AuthenticateClient(c.Request.Context(), clientID, secret)
+	if err != nil {
+		h.oauthError(c, err)
+		return
+	}
+
+	resp, err := h.oauth.AuthorizeDevice(c.Request.Context(), client, c.Request.PostForm.Get("scope"))
+	if err != nil {
+		h.oauthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Device is the verification URI of the device grant. It sends the browser
+// to the verification page, which logs the user in and continues with the
+// authenticated Device API.
+func (h *OAuthHandler) Device(c *gin.Context) {
+	c.Redirect(http.StatusFound, h.oauth.DeviceRedirect(c.Query("user_code")))
+}
+
+// CheckDevice returns the client and scopes of the device authorization
+// request of a user code, for the logged in user to approve.
+func (h *OAuthHandler) CheckDevice(c *gin.Context) {
+	var req dto.DeviceRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	authorization, err := h.oauth.CheckDevice(c.Request.Context(), req.UserCode, middleware.CurrentUserID(c))
+	if err != nil {
+		h.deviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.DeviceResponse{
+			Client: dto.OAuthClientInfo{ClientID: authorization.Client.ID, Name: authorization.Client.Name},
+			Scopes: authorization.Scopes,
+		},
+	})
+}
+
+// DeviceConsent records the logged in user's answer to the device
+// authorization request of a user code.
+func (h *OAuthHandler) DeviceConsent(c *gin.Context) {
+	var req dto.DeviceConsentRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	claims := middleware.CurrentClaims(c)
+	err := h.oauth.DecideDevice(c.Request.Context(), req.UserCode, middleware.CurrentUserID(c), claims.AMR, *req.Approve)
+	if err != nil {
+		h.deviceError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *OAuthHandler) deviceError(c *gin.Context, err error) {
+	var oauthErr *oidc.Error
+	switch {
+	case errors.Is(err, repository.ErrDeviceCodeNotFound):
+		errorResponse(c, http.StatusNotFound, "Invalid or expired code")
+	case errors.As(err, &oauthErr) && oauthErr.Code == oidc.ErrorAccessDenied:
+		errorResponse(c, http.StatusForbidden, "You may not use this application")
+	default:
+		internalError(c, err, "Failed to authorize device")
+	}
+}
+
+// Introspect is the introspection endpoint of RFC 7662. Service accounts
+// and confidential clients authenticate as at the token endpoint.
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	client, token, ok := h.tokenRequest(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.introspection.Introspect(c.Request.Context(), client, token)
+	if err != nil {
+		h.oauthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Revoke is the revocation endpoint of RFC 7009. It succeeds for invalid
+// tokens too.
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	client, token, ok := h.tokenRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.introspection.Revoke(c.Request.Context(), client, token); err != nil {
+		h.oauthError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// tokenRequest authenticates the client of an introspection or revocation
+// request and returns the token it is about, writing the error response
+// and returning false when either fails. The token_type_hint parameter is
+// ignored, as tokens are recognized by their format.
+func (h *OAuthHandler) tokenRequest(c *gin.Context) (*service.TokenClient, string, bool) {
+	if err := c.Request.ParseForm(); err != nil {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidRequest, "invalid form body"))
+		return nil, "", false
+	}
+
+	clientID, secret, ok := clientCredentials(c)
+	if !ok {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidClient, "client authentication failed"))
+		return nil, "", false
+	}
+	client, err := h.introspection.AuthenticateClient(c.Request.Context(), clientID, secret)
+	if err != nil {
+		h.oauthError(c, err)
+		return nil, "", false
+	}
+
+	token := c.Request.PostForm.Get("token")
+	if token == "" {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidRequest, "token is required"))
+		return nil, "", false
+	}
+	return client, token, true
+}
+
+// UserInfo returns the claims about the user of an access token.
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		h.oauthError(c, oidc.NewError(oidc.ErrorInvalidToken, "missing access token"))
+		return
+	}
+
+	info, err := h.oauth.UserInfo(c.Request.Context(), token)
+	if err != nil {
+		h.oauthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// oauthError writes an error of the token or userinfo endpoint in the
+// OAuth 2.0 format.
+func (h *OAuthHandler) oauthError(c *gin.Context, err error) {
+	var oauthErr *oidc.Error
+	if !errors.As(err, &oauthErr) {
+		log.Printf("%v\n", err)
+		oauthErr = oidc.NewError(oidc.ErrorServerError, "")
+	}
+
+	switch oauthErr.Code {
+	case oidc.ErrorInvalidClient:
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	case oidc.ErrorInvalidToken, oidc.ErrorInsufficientScope:
+		c.Header("WWW-Authenticate", `Bearer error="`+oauthErr.Code+`"`)
+	}
+	c.JSON(oauthErr.StatusCode(), oauthErr)
+}
+
+func (h *OAuthHandler) CreateClient(c *gin.Context) {
+	var req dto.CreateOAuthClientRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	client := &models.OAuthClient{
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		RedirectURIs:   req.RedirectURIs,
+		Scopes:         req.Scopes,
+		SkipConsent:    req.SkipConsent,
+	}
+	secret, err := h.oauth.CreateClient(c.Request.Context(), client, req.Public)
+	if err != nil {
+		internalError(c, err, "Failed to create client")
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.OAuthClientSecretResponse{OAuthClient: *client, ClientSecret: secret},
+	})
+}
+
+func (h *OAuthHandler) ListClients(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	clients, err := h.oauth.ListClients(c.Request.Context(), organizationID)
+	if err != nil {
+		internalError(c, err, "Failed to list clients")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   clients,
+	})
+}
+
+func (h *OAuthHandler) GetClient(c *gin.Context) {
+	client, ok := h.client(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   client,
+	})
+}
+
+func (h *OAuthHandler) UpdateClient(c *gin.Context) {
+	client, ok := h.client(c)
+	if !ok {
+		return
+	}
+
+	var req dto.UpdateOAuthClientRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	client.Name = req.Name
+	client.RedirectURIs = req.RedirectURIs
+	client.Scopes = req.Scopes
+	client.SkipConsent = req.SkipConsent
+	if err := h.oauth.UpdateClient(c.Request.Context(), client); err != nil {
+		internalError(c, err, "Failed to update client")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   client,
+	})
+}
+
+func (h *OAuthHandler) RotateClientSecret(c *gin.Context) {
+	client, ok := h.client(c)
+	if !ok {
+		return
+	}
+
+	secret, err := h.oauth.RotateClientSecret(c.Request.Context(), client)
+	if errors.Is(err, service.ErrPublicClient) {
+		errorResponse(c, http.StatusConflict, "Public clients have no secret")
+		return
+	}
+	if err != nil {
+		internalError(c, err, "Failed to rotate client secret")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.OAuthClientSecretResponse{OAuthClient: *client, ClientSecret: secret},
+	})
+}
+
+// DeleteClient removes a client and ends the sessions holding its refresh
+// tokens.
+func (h *OAuthHandler) DeleteClient(c *gin.Context) {
+	client, ok := h.client(c)
+	if !ok {
+		return
+	}
+
+	if err := h.oauth.DeleteClient(c.Request.Context(), client); err != nil {
+		internalError(c, err, "Failed to delete client")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListConsents returns the clients the current user has consented to.
+func (h *OAuthHandler) ListConsents(c *gin.Context) {
+	consents, err := h.oauth.ListConsents(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		internalError(c, err, "Failed to list consents")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   consents,
+	})
+}
+
+// RevokeConsent withdraws the current user's consent to a client, which
+// also ends the client's sessions of the user.
+func (h *OAuthHandler) RevokeConsent(c *gin.Context) {
+	clientID, err := uuid.Parse(c.Param("client_id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	err = h.oauth.RevokeConsent(c.Request.Context(), middleware.CurrentUserID(c), clientID)
+	if errors.Is(err, repository.ErrOAuthConsentNotFound) {
+		errorResponse(c, http.StatusNotFound, "Consent not found")
+		return
+	}
+	if err != nil {
+		internalError(c, err, "Failed to revoke consent")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// client loads the client named in the path. Clients of other
+// organizations are reported as missing.
+func (h *OAuthHandler) client(c *gin.Context) (*models.OAuthClient, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid client ID")
+		return nil, false
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	client, err := h.oauth.GetClient(c.Request.Context(), organizationID, id)
+	if errors.Is(err, repository.ErrOAuthClientNotFound) {
+		errorResponse(c, http.StatusNotFound, "Client not found")
+		return nil, false
+	}
+	if err != nil {
+		internalError(c, err, "Failed to get client")
+		return nil, false
+	}
+
+	return client, true
+}
+
+// clientCredentials returns the client ID and secret of a token request.
+// HTTP Basic credentials are form-encoded, as RFC 6749 section 2.3.1
+// requires.
+func clientCredentials(c *gin.Context) (string, string, bool) {
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		clientID, err := url.QueryUnescape(username)
+		if err != nil {
+			return "", "", false
+		}
+		secret, err := url.QueryUnescape(password)
+		if err != nil {
+			return "", "", false
+		}
+		return clientID, secret, true
+	}
+
+	clientID := c.Request.PostForm.Get("client_id")
+	return clientID, c.Request.PostForm.Get("client_secret"), clientID != ""
+}
+
+func newAuthorizeResponse(decision *service.AuthorizationDecision) dto.AuthorizeResponse {
+	if !decision.ConsentRequired {
+		return dto.AuthorizeResponse{RedirectTo: decision.RedirectURI}
+	}
+	return dto.AuthorizeResponse{
+		ConsentRequired: true,
+		Client:          &dto.OAuthClientInfo{ClientID: decision.Client.ID, Name: decision.Client.Name},
+		Scopes:          decision.Scopes,
+	}
+}
@@ -0,0 +1,874 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+	"user-management/internal/secrets"
+	"user-management/internal/service"
+)
+
+type fakeOAuthRepository struct {
+	repository.OAuthRepository
+	clients  map[uuid.UUID]*models.OAuthClient
+	consents map[[2]uuid.UUID]*models.OAuthConsent
+	codes    map[string]*models.OAuthAuthorizationCode
+	devices  map[string]*models.OAuthDeviceCode
+}
+
+func (f *fakeOAuthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	client.ID = uuid.New()
+	client.Public = client.SecretHash == ""
+	copied := *client
+	f.clients[client.ID] = &copied
+	return nil
+}
+
+func (f *fakeOAuthRepository) GetClient(ctx context.Context, id uuid.UUID) (*models.OAuthClient, error) {
+	client, ok := f.clients[id]
+	if !ok {
+		return nil, repository.ErrOAuthClientNotFound
+	}
+	copied := *client
+	return &copied, nil
+}
+
+func (f *fakeOAuthRepository) GetConsent(ctx context.Context, userID, clientID uuid.UUID) (*models.OAuthConsent, error) {
+	consent, ok := f.consents[[2]uuid.UUID{userID, clientID}]
+	if !ok {
+		return nil, repository.ErrOAuthConsentNotFound
+	}
+	return consent, nil
+}
+
+func (f *fakeOAuthRepository) SaveConsent(ctx context.Context, consent *models.OAuthConsent) error {
+	f.consents[[2]uuid.UUID{consent.UserID, consent.ClientID}] = consent
+	return nil
+}
+
+func (f *fakeOAuthRepository) CreateAuthorizationCode(ctx context.Context, code *models.OAuthAuthorizationCode) error {
+	f.codes[code.CodeHash] = code
+	return nil
+}
+
+func (f *fakeOAuthRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string, sessionID uuid.UUID) (*models.OAuthAuthorizationCode, error) {
+	code, ok := f.codes[codeHash]
+	if !ok || code.ExpiresAt.Before(time.Now()) {
+		return nil, repository.ErrAuthorizationCodeInvalid
+	}
+	if code.UsedAt != nil {
+		return code, repository.ErrAuthorizationCodeReused
+	}
+	now := time.Now()
+	code.UsedAt = &now
+	code.SessionID = &sessionID
+	return code, nil
+}
+
+func (f *fakeOAuthRepository) CreateDeviceCode(ctx context.Context, code *models.OAuthDeviceCode) error {
+	code.Status = models.DeviceCodeStatusPending
+	copied := *code
+	f.devices[code.DeviceCodeHash] = &copied
+	return nil
+}
+
+func (f *fakeOAuthRepository) GetDeviceCode(ctx context.Context, userCodeHash string) (*models.OAuthDeviceCode, error) {
+	for _, code := range f.devices {
+		if code.UserCodeHash == userCodeHash && code.Status == models.DeviceCodeStatusPending && code.ExpiresAt.After(time.Now()) {
+			copied := *code
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrDeviceCodeNotFound
+}
+
+func (f *fakeOAuthRepository) DecideDeviceCode(ctx context.Context, userCodeHash string, userID uuid.UUID, amr []string, approved bool) (*models.OAuthDeviceCode, error) {
+	code, err := f.GetDeviceCode(ctx, userCodeHash)
+	if err != nil {
+		return nil, err
+	}
+	stored := f.devices[code.DeviceCodeHash]
+	stored.Status = models.DeviceCodeStatusDenied
+	if approved {
+		stored.Status = models.DeviceCodeStatusApproved
+	}
+	stored.UserID = &userID
+	stored.AMR = amr
+	return stored, nil
+}
+
+func (f *fakeOAuthRepository) PollDeviceCode(ctx context.Context, deviceCodeHash string, polledAt time.Time) (*models.OAuthDeviceCode, error) {
+	code, ok := f.devices[deviceCodeHash]
+	if !ok {
+		return nil, repository.ErrDeviceCodeNotFound
+	}
+	previous := *code
+	code.LastPolledAt = &polledAt
+	return &previous, nil
+}
+
+func (f *fakeOAuthRepository) SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, interval time.Duration) error {
+	f.devices[deviceCodeHash].Interval = interval
+	return nil
+}
+
+func (f *fakeOAuthRepository) UseDeviceCode(ctx context.Context, deviceCodeHash string) error {
+	code, ok := f.devices[deviceCodeHash]
+	if !ok || code.Status != models.DeviceCodeStatusApproved {
+		return repository.ErrDeviceCodeNotFound
+	}
+	code.Status = models.DeviceCodeStatusUsed
+	return nil
+}
+
+type fakeSigningKeyRepository struct {
+	repository.SigningKeyRepository
+	keys []models.SigningKey
+}
+
+func (f *fakeSigningKeyRepository) ListValid(ctx context.Context) ([]models.SigningKey, error) {
+	return f.keys, nil
+}
+
+func (f *fakeSigningKeyRepository) Rotate(ctx context.Context, key *models.SigningKey, activatedBefore time.Time, retention time.Duration) (bool, error) {
+	now := time.Now()
+	expiresAt := now.Add(retention)
+	key.State = models.SigningKeyStateActive
+	key.ActivatedAt = &now
+	for i := range f.keys {
+		switch f.keys[i].State {
+		case models.SigningKeyStateActive:
+			f.keys[i].State = models.SigningKeyStateRetired
+			f.keys[i].ExpiresAt = &expiresAt
+		case models.SigningKeyStateNext:
+			f.keys[i].State = models.SigningKeyStateActive
+			f.keys[i].ActivatedAt = &now
+			key.State = models.SigningKeyStateNext
+		}
+	}
+	f.keys = append([]models.SigningKey{*key}, f.keys...)
+	return true, nil
+}
+
+func (f *fakeSigningKeyRepository) CreateNext(ctx context.Context, key *models.SigningKey) (bool, error) {
+	for _, existing := range f.keys {
+		if existing.State == models.SigningKeyStateNext {
+			return false, nil
+		}
+	}
+	key.State = models.SigningKeyStateNext
+	f.keys = append([]models.SigningKey{*key}, f.keys...)
+	return true, nil
+}
+
+type fakeClientSessionRepository struct {
+	repository.SessionRepository
+	sessions map[uuid.UUID]*models.Session
+}
+
+func (f *fakeClientSessionRepository) Create(ctx context.Context, session *models.Session) ([]uuid.UUID, error) {
+	copied := *session
+	copied.CreatedAt = time.Now()
+	copied.LastSeenAt = copied.CreatedAt
+	f.sessions[session.ID] = &copied
+	return nil, nil
+}
+
+func (f *fakeClientSessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil, repository.ErrSessionNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (f *fakeClientSessionRepository) Rotate(ctx context.Context, session *models.Session, previousHash string) error {
+	stored, ok := f.sessions[session.ID]
+	if !ok || stored.RevokedAt != nil || stored.RefreshTokenHash != previousHash {
+		return repository.ErrSessionNotFound
+	}
+	copied := *session
+	f.sessions[session.ID] = &copied
+	return nil
+}
+
+func (f *fakeClientSessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	session, ok := f.sessions[id]
+	if !ok || session.UserID != userID || session.RevokedAt != nil {
+		return repository.ErrSessionNotFound
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	return nil
+}
+
+type fakeOAuthUserRepository struct {
+	repository.UserRepository
+	user *models.User
+}
+
+func (f *fakeOAuthUserRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	if id != f.user.ID {
+		return nil, repository.ErrUserNotFound
+	}
+	return f.user, nil
+}
+
+type fakeOAuthUserRoleRepository struct {
+	repository.UserRoleRepository
+	organizationID uuid.UUID
+}
+
+func (f *fakeOAuthUserRoleRepository) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]models.Organization, error) {
+	return []models.Organization{{ID: f.organizationID}}, nil
+}
+
+// relyingParty is an OpenID Connect client driving the provider over HTTP
+// the way a real one would.
+type relyingParty struct {
+	t           *testing.T
+	server      *httptest.Server
+	clientID    string
+	secret      string
+	redirectURI string
+	discovery   oidc.Discovery
+}
+
+func (rp *relyingParty) getJSON(url string, v interface{}) {
+	resp, err := rp.server.Client().Get(url)
+	require.NoError(rp.t, err)
+	defer resp.Body.Close()
+	require.Equal(rp.t, http.StatusOK, resp.StatusCode)
+	require.NoError(rp.t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func (rp *relyingParty) token(form url.Values) (int, map[string]interface{}) {
+	req, err := http.NewRequest(http.MethodPost, rp.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	require.NoError(rp.t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(rp.clientID), url.QueryEscape(rp.secret))
+	resp, err := rp.server.Client().Do(req)
+	require.NoError(rp.t, err)
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	require.NoError(rp.t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+// verifyIDToken checks an ID token against the published keys.
+func (rp *relyingParty) verifyIDToken(raw, nonce string) *oidc.IDTokenClaims {
+	var jwks oidc.JWKS
+	rp.getJSON(rp.discovery.JWKSURI, &jwks)
+
+	claims := &oidc.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		key, ok := jwks.Key(token.Header["kid"].(string))
+		require.True(rp.t, ok)
+		return key, nil
+	},
+		jwt.WithValidMethods(oidc.SigningAlgorithms),
+		jwt.WithIssuer(rp.discovery.Issuer),
+		jwt.WithAudience(rp.clientID),
+	)
+	require.NoError(rp.t, err)
+	require.Equal(rp.t, nonce, claims.Nonce)
+	return claims
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	org := uuid.New()
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace", EmailVerified: true, IsActive: true}
+	oauthRepo := &fakeOAuthRepository{
+		clients:  make(map[uuid.UUID]*models.OAuthClient),
+		consents: make(map[[2]uuid.UUID]*models.OAuthConsent),
+		codes:    make(map[string]*models.OAuthAuthorizationCode),
+	}
+	users := &fakeOAuthUserRepository{user: user}
+	userRoles := &fakeOAuthUserRoleRepository{organizationID: org}
+	sessionRepo := &fakeClientSessionRepository{sessions: make(map[uuid.UUID]*models.Session)}
+
+	server := httptest.NewUnstartedServer(nil)
+	issuer := "http://" + server.Listener.Addr().String()
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	sessions := service.NewSessionService(config.SessionConfig{RefreshTokenTTL: time.Hour, TouchInterval: time.Hour}, sessionRepo, users, userRoles, tokens)
+	encryptionKey, err := secrets.GenerateKey()
+	require.NoError(t, err)
+	keys, err := service.NewSigningKeyService(config.SigningKeysConfig{Algorithm: oidc.AlgorithmES256, EncryptionKey: encryptionKey}, &fakeSigningKeyRepository{})
+	require.NoError(t, err)
+	tokens.SetKeySource(keys)
+	oauthService := service.NewOAuthService(config.OIDCConfig{Issuer: issuer, LoginURL: "https://login.example/authorize"}, oauthRepo, users, userRoles, sessions, keys)
+	h := NewOAuthHandler(validator.New(), oauthService, keys, nil, nil)
+
+	client := &models.OAuthClient{
+		OrganizationID: org,
+		Name:           "Relying Party",
+		RedirectURIs:   []string{"https://rp.example/callback"},
+		Scopes:         []string{oidc.ScopeOpenID, oidc.ScopeProfile, oidc.ScopeEmail, oidc.ScopeOfflineAccess},
+	}
+	secret, err := oauthService.CreateClient(context.Background(), client, false)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(secret, "ocs_"))
+
+	router := gin.New()
+	router.GET(oidc.DiscoveryPath, h.Discovery)
+	router.GET(oidc.JWKSPath, h.JWKS)
+	router.GET(oidc.AuthorizePath, h.Authorize)
+	router.POST(oidc.TokenPath, h.Token)
+	router.GET(oidc.UserInfoPath, h.UserInfo)
+	router.POST("/api/oauth/authorize", middleware.Authenticate(tokens), h.AuthorizeUser)
+	router.POST("/api/oauth/consent", middleware.Authenticate(tokens), h.Consent)
+	server.Config.Handler = router
+	server.Start()
+	defer server.Close()
+
+	rp := &relyingParty{t: t, server: server, clientID: client.ID.String(), secret: secret, redirectURI: client.RedirectURIs[0]}
+	rp.getJSON(issuer+oidc.DiscoveryPath, &rp.discovery)
+	require.Equal(t, issuer, rp.discovery.Issuer)
+
+	// The relying party sends the browser to the authorization endpoint,
+	// which forwards it to the login page.
+	verifier := strings.Repeat("v", 43)
+	authorize := url.Values{
+		"response_type":         {oidc.ResponseTypeCode},
+		"client_id":             {rp.clientID},
+		"redirect_uri":          {rp.redirectURI},
+		"scope":                 {"openid profile email offline_access"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {oidc.CodeChallenge(verifier)},
+		"code_challenge_method": {oidc.CodeChallengeMethodS256},
+	}
+	noRedirects := *server.Client()
+	noRedirects.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := noRedirects.Get(rp.discovery.AuthorizationEndpoint + "?" + authorize.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	login, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "login.example", login.Host)
+	require.Equal(t, "xyz", login.Query().Get("state"))
+
+	// The login page continues the request for the logged in user, who is
+	// asked for consent first.
+	accessToken, err := tokens.GenerateAccessToken(user, org, auth.AMRPassword)
+	require.NoError(t, err)
+	loginAPI := func(path string, body map[string]interface{}) map[string]interface{} {
+		for name := range login.Query() {
+			body[name] = login.Query().Get(name)
+		}
+		encoded, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(encoded)))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Data
+	}
+
+	decision := loginAPI("/api/oauth/authorize", map[string]interface{}{})
+	require.Equal(t, true, decision["consent_required"])
+	require.Equal(t, "Relying Party", decision["client"].(map[string]interface{})["name"])
+
+	decision = loginAPI("/api/oauth/consent", map[string]interface{}{"approve": true})
+	callback, err := url.Parse(decision["redirect_to"].(string))
+	require.NoError(t, err)
+	require.Equal(t, rp.redirectURI, callback.Scheme+"://"+callback.Host+callback.Path)
+	require.Equal(t, "xyz", callback.Query().Get("state"))
+	code := callback.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	// Consent is remembered for later requests.
+	decision = loginAPI("/api/oauth/authorize", map[string]interface{}{})
+	require.Contains(t, decision["redirect_to"], "code=")
+
+	// A wrong verifier fails, and the code is spent by the attempt.
+	status, body := rp.token(url.Values{
+		"grant_type":    {oidc.GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {rp.redirectURI},
+		"code_verifier": {strings.Repeat("w", 43)},
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, oidc.ErrorInvalidGrant, body["error"])
+
+	decision = loginAPI("/api/oauth/authorize", map[string]interface{}{})
+	callback, err = url.Parse(decision["redirect_to"].(string))
+	require.NoError(t, err)
+	code = callback.Query().Get("code")
+
+	exchange := url.Values{
+		"grant_type":    {oidc.GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {rp.redirectURI},
+		"code_verifier": {verifier},
+	}
+	status, body = rp.token(exchange)
+	require.Equal(t, http.StatusOK, status, body)
+	require.Equal(t, "Bearer", body["token_type"])
+
+	claims := rp.verifyIDToken(body["id_token"].(string), "n-0S6")
+	require.Equal(t, user.ID.String(), claims.Subject)
+	require.Equal(t, "ada@example.com", claims.Email)
+	require.Equal(t, "Ada Lovelace", claims.Name)
+	require.Equal(t, []string{auth.AMRPassword}, claims.AMR)
+
+	userInfo := func(token string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(http.MethodGet, rp.discovery.UserInfoEndpoint, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := server.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+	status, info := userInfo(body["access_token"].(string))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, user.ID.String(), info["sub"])
+	require.Equal(t, true, info["email_verified"])
+
+	// Redeeming the code again fails and ends the session it opened.
+	status, _ = rp.token(exchange)
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = rp.token(url.Values{"grant_type": {oidc.GrantTypeRefreshToken}, "refresh_token": {body["refresh_token"].(string)}})
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = userInfo(body["access_token"].(string))
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	// A fresh grant refreshes with rotation, optionally narrowing scopes.
+	decision = loginAPI("/api/oauth/authorize", map[string]interface{}{})
+	callback, err = url.Parse(decision["redirect_to"].(string))
+	require.NoError(t, err)
+	exchange.Set("code", callback.Query().Get("code"))
+	status, body = rp.token(exchange)
+	require.Equal(t, http.StatusOK, status, body)
+
+	refresh := url.Values{"grant_type": {oidc.GrantTypeRefreshToken}, "refresh_token": {body["refresh_token"].(string)}, "scope": {"openid email"}}
+	status, refreshed := rp.token(refresh)
+	require.Equal(t, http.StatusOK, status, refreshed)
+	require.Equal(t, "openid email", refreshed["scope"])
+	require.NotEqual(t, body["refresh_token"], refreshed["refresh_token"])
+	require.Equal(t, claims.Subject, rp.verifyIDToken(refreshed["id_token"].(string), "").Subject)
+
+	refresh.Set("scope", "openid phone")
+	refresh.Set("refresh_token", refreshed["refresh_token"].(string))
+	status, body = rp.token(refresh)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, oidc.ErrorInvalidScope, body["error"])
+
+	// A wrong client secret is rejected.
+	rp.secret = "ocs_wrong"
+	status, body = rp.token(refresh)
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, oidc.ErrorInvalidClient, body["error"])
+}
+
+func TestOAuthAuthorizeErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := &models.OAuthClient{
+		ID:             uuid.New(),
+		OrganizationID: uuid.New(),
+		RedirectURIs:   []string{"https://rp.example/callback"},
+		Scopes:         []string{oidc.ScopeOpenID},
+		Public:         true,
+	}
+	oauthRepo := &fakeOAuthRepository{clients: map[uuid.UUID]*models.OAuthClient{client.ID: client}}
+	oauthService := service.NewOAuthService(config.OIDCConfig{Issuer: "https://id.example", LoginURL: "https://login.example"}, oauthRepo, nil, nil, nil, nil)
+	h := NewOAuthHandler(validator.New(), oauthService, nil, nil, nil)
+	router := gin.New()
+	router.GET(oidc.AuthorizePath, h.Authorize)
+
+	authorize := func(values url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, oidc.AuthorizePath+"?"+values.Encode(), nil))
+		return w
+	}
+	values := url.Values{
+		"response_type":         {oidc.ResponseTypeCode},
+		"client_id":             {client.ID.String()},
+		"redirect_uri":          {"https://evil.example/callback"},
+		"scope":                 {"openid"},
+		"state":                 {"s"},
+		"code_challenge":        {oidc.CodeChallenge(strings.Repeat("v", 43))},
+		"code_challenge_method": {oidc.CodeChallengeMethodS256},
+	}
+
+	// Unregistered redirect URIs are never redirected to.
+	w := authorize(values)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Empty(t, w.Header().Get("Location"))
+
+	values.Set("redirect_uri", client.RedirectURIs[0])
+	values.Set("scope", "openid email")
+	w = authorize(values)
+	require.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, oidc.ErrorInvalidScope, location.Query().Get("error"))
+	require.Equal(t, "s", location.Query().Get("state"))
+
+	values.Set("scope", "openid")
+	values.Del("code_challenge")
+	w = authorize(values)
+	location, err = url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, oidc.ErrorInvalidRequest, location.Query().Get("error"))
+	require.Equal(t, "rp.example", location.Host)
+}
+
+type fakeRevokedTokenRepository struct {
+	repository.RevokedTokenRepository
+	revoked map[uuid.UUID]*models.RevokedToken
+}
+
+func (f *fakeRevokedTokenRepository) Revoke(ctx context.Context, token *models.RevokedToken) error {
+	f.revoked[token.ID] = token
+	return nil
+}
+
+func (f *fakeRevokedTokenRepository) IsRevoked(ctx context.Context, id uuid.UUID) (bool, error) {
+	_, ok := f.revoked[id]
+	return ok, nil
+}
+
+// fakeMemberUserRoleRepository grants permissions per principal and makes
+// users members of one organization.
+type fakeMemberUserRoleRepository struct {
+	repository.UserRoleRepository
+	organizationID uuid.UUID
+	permissions    map[uuid.UUID][]string
+}
+
+func (f *fakeMemberUserRoleRepository) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]models.Organization, error) {
+	return []models.Organization{{ID: f.organizationID}}, nil
+}
+
+func (f *fakeMemberUserRoleRepository) HasPermission(ctx context.Context, userID uuid.UUID, organizationID uuid.UUID, permission string) (bool, error) {
+	for _, name := range f.permissions[userID] {
+		if name == permission && organizationID == f.organizationID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestTokenIntrospectionAndRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	org := uuid.New()
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", IsActive: true}
+
+	accounts := &fakeServiceAccountRepository{accounts: map[uuid.UUID]*models.ServiceAccount{}}
+	userRoles := &fakeMemberUserRoleRepository{organizationID: org, permissions: map[uuid.UUID][]string{}}
+	sessionRepo := &fakeClientSessionRepository{sessions: make(map[uuid.UUID]*models.Session)}
+	keys := &fakeAPIKeyRepository{keys: map[uuid.UUID]*models.APIKey{}}
+	revoked := &fakeRevokedTokenRepository{revoked: map[uuid.UUID]*models.RevokedToken{}}
+
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	sessions := service.NewSessionService(config.SessionConfig{RefreshTokenTTL: time.Hour, TouchInterval: time.Hour}, sessionRepo, &fakeOAuthUserRepository{user: user}, userRoles, tokens)
+	apiKeys := service.NewAPIKeyService(config.APIKeyConfig{}, keys, userRoles)
+	serviceAccounts := service.NewServiceAccountService(config.ServiceAccountConfig{}, accounts, nil, userRoles, tokens)
+	tokens.SetSessionValidator(sessions)
+	tokens.SetAPIKeyValidator(apiKeys)
+	tokens.SetRevocationList(revoked)
+	introspection := service.NewIntrospectionService(tokens, sessions, apiKeys, serviceAccounts, nil, userRoles, revoked)
+	h := NewOAuthHandler(validator.New(), nil, nil, serviceAccounts, introspection)
+
+	router := gin.New()
+	router.POST(oidc.IntrospectionPath, h.Introspect)
+	router.POST(oidc.RevocationPath, h.Revoke)
+	router.GET("/me", middleware.Authenticate(tokens), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	type credentials struct{ clientID, secret string }
+	newAccount := func(organizationID uuid.UUID, permissions ...string) credentials {
+		account := &models.ServiceAccount{OrganizationID: organizationID, Name: "resource-server", IsActive: true}
+		secret, err := serviceAccounts.Create(ctx, account)
+		require.NoError(t, err)
+		userRoles.permissions[account.ID] = permissions
+		return credentials{account.ClientID, secret}
+	}
+	resourceServer := newAccount(org)
+	admin := newAccount(org, service.PermissionRevokeTokens)
+	outsider := newAccount(uuid.New(), service.PermissionRevokeTokens)
+
+	post := func(path string, client credentials, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(url.Values{"token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(client.clientID), url.QueryEscape(client.secret))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	introspect := func(client credentials, token string) map[string]interface{} {
+		w := post(oidc.IntrospectionPath, client, token)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+	authenticate := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	login, err := sessions.Start(ctx, user, org, auth.AMRPassword)
+	require.NoError(t, err)
+	body := introspect(resourceServer, login.AccessToken)
+	require.Equal(t, true, body["active"])
+	require.Equal(t, user.ID.String(), body["sub"])
+	require.Equal(t, "ada@example.com", body["username"])
+	require.Equal(t, org.String(), body["org_id"])
+	require.Equal(t, map[string]interface{}{"active": false}, introspect(outsider, login.AccessToken))
+	require.Equal(t, map[string]interface{}{"active": false}, introspect(resourceServer, "not-a-token"))
+
+	w := post(oidc.IntrospectionPath, credentials{resourceServer.clientID, "sas_wrong"}, login.AccessToken)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), oidc.ErrorInvalidClient)
+
+	// Revoking an access token needs the permission, and takes effect
+	// immediately. Other organizations cannot revoke it and are not told.
+	w = post(oidc.RevocationPath, resourceServer, login.AccessToken)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), oidc.ErrorUnauthorizedClient)
+	require.Equal(t, http.StatusOK, post(oidc.RevocationPath, outsider, login.AccessToken).Code)
+	require.Equal(t, http.StatusNoContent, authenticate(login.AccessToken))
+
+	require.Equal(t, http.StatusOK, post(oidc.RevocationPath, admin, login.AccessToken).Code)
+	require.Equal(t, http.StatusUnauthorized, authenticate(login.AccessToken))
+	require.Equal(t, false, introspect(resourceServer, login.AccessToken)["active"])
+	require.Len(t, revoked.revoked, 1)
+
+	// Revoking a refresh token ends its session and the session's access
+	// tokens with it.
+	login, err = sessions.Start(ctx, user, uuid.Nil, auth.AMRPassword)
+	require.NoError(t, err)
+	body = introspect(resourceServer, login.RefreshToken)
+	require.Equal(t, true, body["active"])
+	require.NotEmpty(t, body["sid"])
+	require.Equal(t, http.StatusOK, post(oidc.RevocationPath, admin, login.RefreshToken).Code)
+	require.Equal(t, false, introspect(resourceServer, login.RefreshToken)["active"])
+	require.Equal(t, http.StatusUnauthorized, authenticate(login.AccessToken))
+
+	// API keys are deleted.
+	apiKey, err := apiKeys.Create(ctx, &models.APIKey{UserID: user.ID, OrganizationID: org, Name: "ci"})
+	require.NoError(t, err)
+	body = introspect(resourceServer, apiKey)
+	require.Equal(t, true, body["active"])
+	require.Equal(t, auth.PrincipalAPIKey, body["principal"])
+	require.Equal(t, http.StatusOK, post(oidc.RevocationPath, admin, apiKey).Code)
+	require.Empty(t, keys.keys)
+	require.Equal(t, http.StatusUnauthorized, authenticate(apiKey))
+
+	// Service accounts may revoke their own tokens without the permission.
+	grant, err := serviceAccounts.Token(ctx, resourceServer.clientID, resourceServer.secret, "")
+	require.NoError(t, err)
+	body = introspect(admin, grant.AccessToken)
+	require.Equal(t, true, body["active"])
+	require.Equal(t, resourceServer.clientID, body["client_id"])
+	require.Equal(t, auth.PrincipalServiceAccount, body["principal"])
+	require.Equal(t, http.StatusOK, post(oidc.RevocationPath, resourceServer, grant.AccessToken).Code)
+	require.Equal(t, http.StatusUnauthorized, authenticate(grant.AccessToken))
+
+	// Invalid tokens are ignored.
+	require.Equal(t, http.StatusOK, post(oidc.RevocationPath, resourceServer, "not-a-token").Code)
+}
+
+func TestOAuthDeviceGrant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	org := uuid.New()
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", IsActive: true}
+	oauthRepo := &fakeOAuthRepository{
+		clients:  make(map[uuid.UUID]*models.OAuthClient),
+		consents: make(map[[2]uuid.UUID]*models.OAuthConsent),
+		devices:  make(map[string]*models.OAuthDeviceCode),
+	}
+	users := &fakeOAuthUserRepository{user: user}
+	userRoles := &fakeOAuthUserRoleRepository{organizationID: org}
+	sessionRepo := &fakeClientSessionRepository{sessions: make(map[uuid.UUID]*models.Session)}
+
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	sessions := service.NewSessionService(config.SessionConfig{RefreshTokenTTL: time.Hour, TouchInterval: time.Hour}, sessionRepo, users, userRoles, tokens)
+	tokens.SetSessionValidator(sessions)
+	encryptionKey, err := secrets.GenerateKey()
+	require.NoError(t, err)
+	keys, err := service.NewSigningKeyService(config.SigningKeysConfig{Algorithm: oidc.AlgorithmES256, EncryptionKey: encryptionKey}, &fakeSigningKeyRepository{})
+	require.NoError(t, err)
+	tokens.SetKeySource(keys)
+	oauthService := service.NewOAuthService(config.OIDCConfig{
+		Issuer:                "https://id.example",
+		LoginURL:              "https://login.example/authorize",
+		DeviceVerificationURL: "https://login.example/device",
+	}, oauthRepo, users, userRoles, sessions, keys)
+	h := NewOAuthHandler(validator.New(), oauthService, keys, nil, nil)
+
+	// Command line tools are public clients without redirect URIs.
+	client := &models.OAuthClient{OrganizationID: org, Name: "deploy-cli", Scopes: []string{oidc.ScopeOpenID, oidc.ScopeOfflineAccess}}
+	_, err = oauthService.CreateClient(ctx, client, true)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST(oidc.DeviceAuthorizationPath, h.DeviceAuthorization)
+	router.GET(oidc.DevicePath, h.Device)
+	router.POST(oidc.TokenPath, h.Token)
+	router.POST("/api/oauth/device", middleware.Authenticate(tokens), middleware.RequireLogin(), h.CheckDevice)
+	router.POST("/api/oauth/device/consent", middleware.Authenticate(tokens), middleware.RequireLogin(), h.DeviceConsent)
+	router.GET("/me", middleware.Authenticate(tokens), func(c *gin.Context) {
+		require.Equal(t, org.String(), middleware.CurrentClaims(c).OrganizationID)
+		c.Status(http.StatusNoContent)
+	})
+
+	form := func(path string, values url.Values) (int, map[string]interface{}) {
+		values.Set("client_id", client.ID.String())
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+	userToken, err := tokens.GenerateAccessToken(user, org, auth.AMRPassword)
+	require.NoError(t, err)
+	api := func(path, bearer string, body map[string]interface{}) *httptest.ResponseRecorder {
+		encoded, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(encoded)))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	me := func(bearer string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	// waitInterval makes the next poll of every device code come after its
+	// interval.
+	waitInterval := func() {
+		for _, code := range oauthRepo.devices {
+			code.LastPolledAt = nil
+		}
+	}
+
+	status, device := form(oidc.DeviceAuthorizationPath, url.Values{"scope": {"openid offline_access"}})
+	require.Equal(t, http.StatusOK, status, device)
+	userCode := device["user_code"].(string)
+	require.Equal(t, "https://id.example"+oidc.DevicePath, device["verification_uri"])
+	require.Equal(t, float64(600), device["expires_in"])
+	require.Equal(t, float64(5), device["interval"])
+
+	status, body := form(oidc.DeviceAuthorizationPath, url.Values{"scope": {"openid email"}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, oidc.ErrorInvalidScope, body["error"])
+
+	// The device polls while the user has not decided, and is told to slow
+	// down when it polls too fast.
+	poll := url.Values{"grant_type": {oidc.GrantTypeDeviceCode}, "device_code": {device["device_code"].(string)}}
+	status, body = form(oidc.TokenPath, poll)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, oidc.ErrorAuthorizationPending, body["error"])
+	_, body = form(oidc.TokenPath, poll)
+	require.Equal(t, oidc.ErrorSlowDown, body["error"])
+	for _, code := range oauthRepo.devices {
+		require.Equal(t, 10*time.Second, code.Interval)
+	}
+
+	// The verification URI sends the user to the verification page, which
+	// shows the request and records the answer.
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, device["verification_uri_complete"].(string), nil))
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://login.example/device?user_code="+url.QueryEscape(userCode), w.Header().Get("Location"))
+
+	typed := strings.ToLower(strings.ReplaceAll(userCode, "-", " "))
+	w = api("/api/oauth/device", userToken, map[string]interface{}{"user_code": typed})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Contains(t, w.Body.String(), "deploy-cli")
+	require.Equal(t, http.StatusNotFound, api("/api/oauth/device", userToken, map[string]interface{}{"user_code": "BCDF-GHJK"}).Code)
+
+	w = api("/api/oauth/device/consent", userToken, map[string]interface{}{"user_code": userCode, "approve": true})
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	require.Contains(t, oauthRepo.consents, [2]uuid.UUID{user.ID, client.ID})
+	require.Equal(t, http.StatusNotFound, api("/api/oauth/device/consent", userToken, map[string]interface{}{"user_code": userCode, "approve": true}).Code)
+
+	// The device gets API tokens of the user in the client's organization,
+	// once.
+	waitInterval()
+	status, body = form(oidc.TokenPath, poll)
+	require.Equal(t, http.StatusOK, status, body)
+	require.NotEmpty(t, body["id_token"])
+	require.Equal(t, http.StatusNoContent, me(body["access_token"].(string)))
+	waitInterval()
+	status, replay := form(oidc.TokenPath, poll)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, oidc.ErrorInvalidGrant, replay["error"])
+
+	// Refreshing keeps issuing API tokens.
+	status, refreshed := form(oidc.TokenPath, url.Values{"grant_type": {oidc.GrantTypeRefreshToken}, "refresh_token": {body["refresh_token"].(string)}})
+	require.Equal(t, http.StatusOK, status, refreshed)
+	require.Equal(t, http.StatusNoContent, me(refreshed["access_token"].(string)))
+
+	// Only the user's own login approves devices, not the tokens of clients
+	// or service accounts.
+	_, other := form(oidc.DeviceAuthorizationPath, url.Values{"scope": {"openid"}})
+	serviceToken, err := tokens.GenerateServiceAccountToken(&models.ServiceAccount{ID: uuid.New(), OrganizationID: org}, nil, time.Minute)
+	require.NoError(t, err)
+	for _, bearer := range []string{refreshed["access_token"].(string), serviceToken} {
+		w = api("/api/oauth/device/consent", bearer, map[string]interface{}{"user_code": other["user_code"], "approve": true})
+		require.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		require.Equal(t, http.StatusForbidden, api("/api/oauth/device", bearer, map[string]interface{}{"user_code": other["user_code"]}).Code)
+	}
+
+	// Denied and expired requests fail for good.
+	_, device = form(oidc.DeviceAuthorizationPath, url.Values{"scope": {"openid"}})
+	poll.Set("device_code", device["device_code"].(string))
+	w = api("/api/oauth/device/consent", userToken, map[string]interface{}{"user_code": device["user_code"], "approve": false})
+	require.Equal(t, http.StatusNoContent, w.Code)
+	_, body = form(oidc.TokenPath, poll)
+	require.Equal(t, oidc.ErrorAccessDenied, body["error"])
+
+	_, device = form(oidc.DeviceAuthorizationPath, url.Values{})
+	poll.Set("device_code", device["device_code"].(string))
+	for _, code := range oauthRepo.devices {
+		code.ExpiresAt = time.Now().Add(-time.Second)
+	}
+	_, body = form(oidc.TokenPath, poll)
+	require.Equal(t, oidc.ErrorExpiredToken, body["error"])
+}
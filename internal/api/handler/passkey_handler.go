@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type PasskeyHandler struct {
+	validator *validator.Validate
+	passkeys  *service.PasskeyService
+	webauthn  repository.WebAuthnRepository
+	tokens    *auth.TokenManager
+}
+
+func NewPasskeyHandler(validator *validator.Validate, passkeyService *service.PasskeyService, webauthnRepo repository.WebAuthnRepository, tokens *auth.TokenManager) *PasskeyHandler {
+	return &PasskeyHandler{
+		validator: validator,
+		passkeys:  passkeyService,
+		webauthn:  webauthnRepo,
+		tokens:    tokens,
+	}
+}
+
+// BeginRegistration returns the options to create a passkey for the current
+// user, once they have confirmed that they are present.
+func (h *PasskeyHandler) BeginRegistration(c *gin.Context) {
+	var req dto.BeginPasskeyRegistrationRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	registration, err := h.passkeys.BeginRegistration(c.Request.Context(), middleware.CurrentUserID(c), service.PasskeyReauthentication{
+		Password:   req.Password,
+		Code:       req.Code,
+		SessionID:  req.SessionID,
+		Credential: req.Credential,
+	})
+	if err != nil {
+		h.error(c, err, "Failed to start passkey registration")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.PasskeyOptionsResponse{
+			SessionID: registration.SessionID,
+			Options:   registration.Options,
+		},
+	})
+}
+
+func (h *PasskeyHandler) FinishRegistration(c *gin.Context) {
+	var req dto.FinishPasskeyRegistrationRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	passkey, err := h.passkeys.FinishRegistration(c.Request.Context(), middleware.CurrentUserID(c), req.SessionID, req.Name, req.Credential)
+	if err != nil {
+		h.error(c, err, "Failed to register passkey")
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.SuccessResponse{
+		Status: "success",
+		Data:   passkey,
+	})
+}
+
+func (h *PasskeyHandler) List(c *gin.Context) {
+	passkeys, err := h.webauthn.ListCredentials(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		internalError(c, err, "Failed to list passkeys")
+		return
+	}
+	if passkeys == nil {
+		passkeys = []models.WebAuthnCredential{}
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   passkeys,
+	})
+}
+
+func (h *PasskeyHandler) Delete(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid passkey ID")
+		return
+	}
+
+	if err := h.webauthn.DeleteCredential(c.Request.Context(), middleware.CurrentUserID(c), id); err != nil {
+		h.error(c, err, "Failed to delete passkey")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// BeginLogin returns the options to sign in with a passkey. No user is
+// named; the authenticator offers the passkeys it holds.
+func (h *PasskeyHandler) BeginLogin(c *gin.Context) {
+	assertion, err := h.passkeys.BeginLogin(c.Request.Context())
+	if err != nil {
+		internalError(c, err, "Failed to start passkey login")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.PasskeyOptionsResponse{
+			SessionID: assertion.SessionID,
+			Options:   assertion.Options,
+		},
+	})
+}
+
+// FinishLogin verifies the assertion and returns an access token in the
+// same shape as the password login.
+func (h *PasskeyHandler) FinishLogin(c *gin.Context) {
+	var req dto.FinishPasskeyLoginRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID := uuid.Nil
+	if req.OrganizationID != nil {
+		organizationID = *req.OrganizationID
+	}
+
+	result, err := h.passkeys.FinishLogin(c.Request.Context(), req.SessionID, req.Credential, organizationID)
+	if err != nil {
+		h.error(c, err, "Failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newLoginResponse(result, h.tokens),
+	})
+}
+
+func (h *PasskeyHandler) error(c *gin.Context, err error, message string) {
+	var throttled *service.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		throttledResponse(c, throttled)
+	case errors.Is(err, service.ErrInvalidPasskey):
+		errorResponse(c, http.StatusUnauthorized, "Passkey verification failed")
+	case errors.Is(err, repository.ErrWebAuthnSessionNotFound):
+		errorResponse(c, http.StatusBadRequest, "Passkey session not found or expired")
+	case errors.Is(err, repository.ErrPasskeyExists):
+		errorResponse(c, http.StatusConflict, "Passkey is already registered")
+	case errors.Is(err, repository.ErrPasskeyNotFound):
+		errorResponse(c, http.StatusNotFound, "Passkey not found")
+	case errors.Is(err, service.ErrReauthenticationFailed):
+		errorResponse(c, http.StatusUnauthorized, "Invalid password or verification code")
+	case errors.Is(err, service.ErrMFANotEnabled):
+		errorResponse(c, http.StatusUnauthorized, "Confirm with one of your passkeys")
+	case errors.Is(err, service.ErrNotMember):
+		errorResponse(c, http.StatusForbidden, "Not a member of the organization")
+	case errors.Is(err, service.ErrLoginMethodNotAllowed):
+		errorResponse(c, http.StatusForbidden, "The organization does not allow this login method")
+	default:
+		internalError(c, err, message)
+	}
+}
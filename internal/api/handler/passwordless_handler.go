@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/auth"
+	"user-management/internal/service"
+)
+
+type PasswordlessHandler struct {
+	validator    *validator.Validate
+	passwordless *service.PasswordlessService
+	tokens       *auth.TokenManager
+}
+
+func NewPasswordlessHandler(validator *validator.Validate, passwordlessService *service.PasswordlessService, tokens *auth.TokenManager) *PasswordlessHandler {
+	return &PasswordlessHandler{
+		validator:    validator,
+		passwordless: passwordlessService,
+		tokens:       tokens,
+	}
+}
+
+// Start mails a login code and link. It responds 202 with a device token
+// whether or not the address has an account.
+func (h *PasswordlessHandler) Start(c *gin.Context) {
+	var req dto.StartPasswordlessLoginRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID := uuid.Nil
+	if req.OrganizationID != nil {
+		organizationID = *req.OrganizationID
+	}
+
+	login, err := h.passwordless.Start(c.Request.Context(), req.Email, organizationID, c.GetHeader("Accept-Language"))
+	if err != nil {
+		h.error(c, err, "Failed to start login")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.PasswordlessLoginResponse{
+			DeviceToken: login.DeviceToken,
+			ExpiresAt:   login.ExpiresAt,
+		},
+	})
+}
+
+// Verify completes a login with the mailed code or link token, and
+// responds in the same shape as the password login.
+func (h *PasswordlessHandler) Verify(c *gin.Context) {
+	var req dto.VerifyPasswordlessLoginRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	var result *service.LoginResult
+	var err error
+	if req.Token != "" {
+		result, err = h.passwordless.VerifyLink(c.Request.Context(), req.DeviceToken, req.Token)
+	} else {
+		result, err = h.passwordless.VerifyCode(c.Request.Context(), req.DeviceToken, req.Code)
+	}
+	if err != nil {
+		h.error(c, err, "Failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newLoginResponse(result, h.tokens),
+	})
+}
+
+func (h *PasswordlessHandler) error(c *gin.Context, err error, message string) {
+	var throttled *service.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		throttledResponse(c, throttled)
+	case errors.Is(err, service.ErrInvalidLoginCode):
+		errorResponse(c, http.StatusUnauthorized, "Invalid or expired login code")
+	case errors.Is(err, service.ErrNotMember):
+		errorResponse(c, http.StatusForbidden, "Not a member of the organization")
+	case errors.Is(err, service.ErrLoginMethodNotAllowed):
+		errorResponse(c, http.StatusForbidden, "The organization does not allow this login method")
+	default:
+		internalError(c, err, message)
+	}
+}
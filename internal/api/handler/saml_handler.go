@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/saml"
+	"user-management/internal/service"
+)
+
+// Error codes that the assertion consumer service sends the user to the
+// frontend with.
+const (
+	samlErrorInvalidRequest   = "invalid_request"
+	samlErrorNotConfigured    = "sso_not_configured"
+	samlErrorLoginFailed      = "login_failed"
+	samlErrorNoAccount        = "no_account"
+	samlErrorAccountNotLinked = "account_not_linked"
+	samlErrorMethodNotAllowed = "login_method_not_allowed"
+	samlErrorServerError      = "server_error"
+)
+
+type SAMLHandler struct {
+	validator *validator.Validate
+	saml      *service.SAMLService
+	tokens    *auth.TokenManager
+}
+
+func NewSAMLHandler(validator *validator.Validate, samlService *service.SAMLService, tokens *auth.TokenManager) *SAMLHandler {
+	return &SAMLHandler{
+		validator: validator,
+		saml:      samlService,
+		tokens:    tokens,
+	}
+}
+
+// Metadata serves the SP metadata of an organization, to register with its
+// IdP.
+func (h *SAMLHandler) Metadata(c *gin.Context) {
+	organizationID, err := uuid.Parse(c.Param("organization_id"))
+	if err != nil {
+		errorResponse(c, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	metadata, err := h.saml.Metadata(organizationID)
+	if err != nil {
+		internalError(c, err, "Failed to create SP metadata")
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// ACS is the assertion consumer service that IdPs post responses to. It
+// sends the user on to the frontend with a code to log in with, or with an
+// error code.
+func (h *SAMLHandler) ACS(c *gin.Context) {
+	organizationID, err := uuid.Parse(c.Param("organization_id"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, h.saml.ErrorRedirect(samlErrorInvalidRequest))
+		return
+	}
+
+	login, err := h.saml.HandleResponse(c.Request.Context(), organizationID, c.PostForm("SAMLResponse"), c.PostForm("RelayState"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, h.saml.ErrorRedirect(samlErrorCode(err)))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, h.saml.LoginRedirect(login))
+}
+
+// BeginLogin returns the URL of the organization's IdP to send the user to.
+func (h *SAMLHandler) BeginLogin(c *gin.Context) {
+	var req dto.BeginSAMLLoginRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	redirect, err := h.saml.BeginLogin(c.Request.Context(), req.OrganizationID)
+	if err != nil {
+		h.error(c, err, "Failed to start login")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.FederationRedirectResponse{
+			AuthorizationURL: redirect.URL,
+			State:            redirect.State,
+		},
+	})
+}
+
+// FinishLogin redeems the code of a login through the IdP, and responds in
+// the same shape as the password login.
+func (h *SAMLHandler) FinishLogin(c *gin.Context) {
+	var req dto.SAMLCallbackRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	result, err := h.saml.FinishLogin(c.Request.Context(), req.Code)
+	if err != nil {
+		h.error(c, err, "Failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newLoginResponse(result, h.tokens),
+	})
+}
+
+// ServiceProvider returns the SP values of the current organization.
+func (h *SAMLHandler) ServiceProvider(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data: dto.SAMLServiceProviderResponse{
+			EntityID:    h.saml.EntityID(organizationID),
+			ACSURL:      h.saml.ACSURL(organizationID),
+			MetadataURL: h.saml.EntityID(organizationID),
+		},
+	})
+}
+
+func (h *SAMLHandler) GetConnection(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	connection, err := h.saml.GetConnection(c.Request.Context(), organizationID)
+	if err != nil {
+		h.error(c, err, "Failed to get SAML connection")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   connection,
+	})
+}
+
+// UpdateConnection creates or replaces the SAML connection of the current
+// organization.
+func (h *SAMLHandler) UpdateConnection(c *gin.Context) {
+	var req dto.SAMLConnectionRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	connection := &models.SAMLConnection{
+		OrganizationID: organizationID,
+		IdPMetadata:    req.IdPMetadata,
+		AttributeMapping: models.SAMLAttributeMapping{
+			Email:     req.AttributeMapping.Email,
+			FirstName: req.AttributeMapping.FirstName,
+			LastName:  req.AttributeMapping.LastName,
+			Groups:    req.AttributeMapping.Groups,
+		},
+		DefaultRoleID:     req.DefaultRoleID,
+		AllowIdPInitiated: req.AllowIdPInitiated,
+		CreateUsers:       req.CreateUsers,
+		Enabled:           *req.Enabled,
+	}
+	for _, mapping := range req.RoleMappings {
+		connection.RoleMappings = append(connection.RoleMappings, models.SAMLRoleMapping{Group: mapping.Group, RoleID: mapping.RoleID})
+	}
+
+	if err := h.saml.SaveConnection(c.Request.Context(), connection); err != nil {
+		h.error(c, err, "Failed to update SAML connection")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   connection,
+	})
+}
+
+func (h *SAMLHandler) DeleteConnection(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	if err := h.saml.DeleteConnection(c.Request.Context(), organizationID); err != nil {
+		h.error(c, err, "Failed to delete SAML connection")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SAMLHandler) error(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrSAMLNotConfigured):
+		errorResponse(c, http.StatusNotFound, "SAML single sign-on is not configured for the organization")
+	case errors.Is(err, repository.ErrSAMLConnectionNotFound):
+		errorResponse(c, http.StatusNotFound, "SAML connection not found")
+	case errors.Is(err, saml.ErrInvalidMetadata):
+		errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, repository.ErrSAMLInvalidRole):
+		errorResponse(c, http.StatusBadRequest, "Roles must belong to the organization")
+	case errors.Is(err, repository.ErrFederationStateNotFound):
+		errorResponse(c, http.StatusBadRequest, "Login not found or expired")
+	case errors.Is(err, service.ErrFederatedLoginFailed):
+		errorResponse(c, http.StatusUnauthorized, "Login with the identity provider failed")
+	case errors.Is(err, service.ErrNotMember):
+		errorResponse(c, http.StatusForbidden, "Not a member of the organization")
+	case errors.Is(err, service.ErrLoginMethodNotAllowed):
+		errorResponse(c, http.StatusForbidden, "The organization does not allow this login method")
+	default:
+		internalError(c, err, message)
+	}
+}
+
+// samlErrorCode returns the error code to send the user to the frontend
+// with for an error of the assertion consumer service.
+func samlErrorCode(err error) string {
+	switch {
+	case errors.Is(err, service.ErrSAMLNotConfigured):
+		return samlErrorNotConfigured
+	case errors.Is(err, service.ErrFederatedLoginFailed):
+		return samlErrorLoginFailed
+	case errors.Is(err, service.ErrNoAccount):
+		return samlErrorNoAccount
+	case errors.Is(err, service.ErrAccountNotLinked):
+		return samlErrorAccountNotLinked
+	case errors.Is(err, service.ErrLoginMethodNotAllowed):
+		return samlErrorMethodNotAllowed
+	default:
+		log.Printf("%v\n", err)
+		return samlErrorServerError
+	}
+}
@@ -0,0 +1,565 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/scim"
+)
+
+const (
+	SCIMBasePath = "/scim/v2"
+
+	defaultSCIMPageSize = 100
+)
+
+type SCIMHandler struct {
+	validator *validator.Validate
+	scim      repository.SCIMRepository
+}
+
+func NewSCIMHandler(validator *validator.Validate, scimRepo repository.SCIMRepository) *SCIMHandler {
+	return &SCIMHandler{
+		validator: validator,
+		scim:      scimRepo,
+	}
+}
+
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	h.respond(c, http.StatusOK, scim.NewServiceProviderConfig(scimBaseURL(c)))
+}
+
+func (h *SCIMHandler) ResourceTypes(c *gin.Context) {
+	var resources []interface{}
+	for _, resourceType := range scim.NewResourceTypes(scimBaseURL(c)) {
+		resources = append(resources, resourceType)
+	}
+	h.respond(c, http.StatusOK, scim.NewListResponse(resources, len(resources), 1))
+}
+
+func (h *SCIMHandler) Schemas(c *gin.Context) {
+	var resources []interface{}
+	for _, schema := range scim.NewSchemas(scimBaseURL(c)) {
+		resources = append(resources, schema)
+	}
+	h.respond(c, http.StatusOK, scim.NewListResponse(resources, len(resources), 1))
+}
+
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	filter, startIndex, count, ok := h.listQuery(c)
+	if !ok {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	members, total, err := h.scim.ListUsers(c.Request.Context(), organizationID, filter, startIndex-1, count)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	baseURL := scimBaseURL(c)
+	resources := make([]interface{}, 0, len(members))
+	for i := range members {
+		resources = append(resources, scim.NewUser(&members[i], baseURL))
+	}
+	h.respond(c, http.StatusOK, scim.NewListResponse(resources, total, startIndex))
+}
+
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	member, ok := h.user(c)
+	if !ok {
+		return
+	}
+
+	h.respond(c, http.StatusOK, scim.NewUser(member, scimBaseURL(c)))
+}
+
+// CreateUser provisions a new account and makes it a member of the
+// organization. An email address that already has an account is reported as
+// a uniqueness conflict.
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var resource scim.User
+	if !h.bind(c, &resource) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	member := &models.SCIMUser{OrganizationID: organizationID}
+	if !h.applyUser(c, &resource, member) {
+		return
+	}
+
+	if err := h.scim.CreateUser(c.Request.Context(), member); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	h.respond(c, http.StatusCreated, scim.NewUser(member, scimBaseURL(c)))
+}
+
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	member, ok := h.user(c)
+	if !ok {
+		return
+	}
+
+	var resource scim.User
+	if !h.bind(c, &resource) {
+		return
+	}
+
+	h.updateUser(c, &resource, member)
+}
+
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	member, ok := h.user(c)
+	if !ok {
+		return
+	}
+
+	var req scim.PatchRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	resource := scim.NewUser(member, scimBaseURL(c))
+	if err := resource.Patch(req.Operations); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	h.updateUser(c, resource, member)
+}
+
+// DeleteUser removes the user from the organization. The account is kept
+// because it may belong to other organizations.
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	id, ok := h.resourceID(c)
+	if !ok {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	if err := h.scim.DeleteUser(c.Request.Context(), organizationID, id); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups returns the organization's roles as groups. Clients that pass
+// excludedAttributes=members get the groups without loading their members.
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	filter, startIndex, count, ok := h.listQuery(c)
+	if !ok {
+		return
+	}
+
+	withMembers := true
+	for _, attribute := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if scim.NormalizePath(strings.TrimSpace(attribute)) == "members" {
+			withMembers = false
+		}
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	groups, total, err := h.scim.ListGroups(c.Request.Context(), organizationID, filter, startIndex-1, count, withMembers)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	baseURL := scimBaseURL(c)
+	resources := make([]interface{}, 0, len(groups))
+	for i := range groups {
+		resources = append(resources, scim.NewGroup(&groups[i], baseURL))
+	}
+	h.respond(c, http.StatusOK, scim.NewListResponse(resources, total, startIndex))
+}
+
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, ok := h.group(c)
+	if !ok {
+		return
+	}
+
+	h.respond(c, http.StatusOK, scim.NewGroup(group, scimBaseURL(c)))
+}
+
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var resource scim.Group
+	if !h.bind(c, &resource) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	group := &models.SCIMGroup{Role: models.Role{OrganizationID: organizationID}}
+	if !h.applyGroup(c, &resource, group) {
+		return
+	}
+
+	if err := h.scim.CreateGroup(c.Request.Context(), group); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	h.respond(c, http.StatusCreated, scim.NewGroup(group, scimBaseURL(c)))
+}
+
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	group, ok := h.group(c)
+	if !ok {
+		return
+	}
+
+	var resource scim.Group
+	if !h.bind(c, &resource) {
+		return
+	}
+
+	h.updateGroup(c, &resource, group)
+}
+
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	group, ok := h.group(c)
+	if !ok {
+		return
+	}
+
+	var req scim.PatchRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	resource := scim.NewGroup(group, scimBaseURL(c))
+	if err := resource.Patch(req.Operations); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	h.updateGroup(c, resource, group)
+}
+
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	id, ok := h.resourceID(c)
+	if !ok {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	if err := h.scim.DeleteGroup(c.Request.Context(), organizationID, id); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// CreateToken issues a SCIM token for the current organization. The token is
+// only returned in this response.
+func (h *SCIMHandler) CreateToken(c *gin.Context) {
+	var req dto.CreateSCIMTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Validation failed",
+			Errors:  validationErrors(err),
+		})
+		return
+	}
+
+	value, err := generateSCIMToken()
+	if err != nil {
+		h.internalError(c, err, "Failed to create SCIM token")
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	userID := middleware.CurrentUserID(c)
+	token := &models.SCIMToken{
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		TokenHash:      middleware.HashSCIMToken(value),
+		CreatedBy:      &userID,
+	}
+	if err := h.scim.CreateToken(c.Request.Context(), token); err != nil {
+		h.internalError(c, err, "Failed to create SCIM token")
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.SCIMTokenResponse{SCIMToken: *token, Token: value},
+	})
+}
+
+func (h *SCIMHandler) ListTokens(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	tokens, err := h.scim.ListTokens(c.Request.Context(), organizationID)
+	if err != nil {
+		h.internalError(c, err, "Failed to list SCIM tokens")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   tokens,
+	})
+}
+
+func (h *SCIMHandler) RevokeToken(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Invalid token ID",
+		})
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	err = h.scim.RevokeToken(c.Request.Context(), organizationID, id)
+	if errors.Is(err, repository.ErrSCIMNotFound) {
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Status:  "error",
+			Message: "SCIM token not found",
+		})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err, "Failed to revoke SCIM token")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) updateUser(c *gin.Context, resource *scim.User, member *models.SCIMUser) {
+	if !h.applyUser(c, resource, member) {
+		return
+	}
+
+	if err := h.scim.UpdateUser(c.Request.Context(), member); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	h.respond(c, http.StatusOK, scim.NewUser(member, scimBaseURL(c)))
+}
+
+// applyUser validates resource and copies it onto member, hashing the
+// password when one was sent. Without a password the stored one is kept,
+// and new users get none, so they can only sign in through their IdP.
+func (h *SCIMHandler) applyUser(c *gin.Context, resource *scim.User, member *models.SCIMUser) bool {
+	if err := h.validator.Var(resource.Email(), "required,email,max=255"); err != nil {
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue,
+			"userName or emails must contain an email address"))
+		return false
+	}
+
+	resource.Apply(member)
+	member.User.Password = ""
+	if resource.Password != "" {
+		hash, err := auth.HashPassword(resource.Password)
+		if err != nil {
+			h.error(c, err)
+			return false
+		}
+		member.User.Password = hash
+	}
+	return true
+}
+
+func (h *SCIMHandler) updateGroup(c *gin.Context, resource *scim.Group, group *models.SCIMGroup) {
+	if !h.applyGroup(c, resource, group) {
+		return
+	}
+
+	if err := h.scim.UpdateGroup(c.Request.Context(), group); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	h.respond(c, http.StatusOK, scim.NewGroup(group, scimBaseURL(c)))
+}
+
+func (h *SCIMHandler) applyGroup(c *gin.Context, resource *scim.Group, group *models.SCIMGroup) bool {
+	if err := h.validator.Var(resource.DisplayName, "required,max=100"); err != nil {
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue,
+			"displayName is required"))
+		return false
+	}
+
+	if invalid := resource.Apply(group); len(invalid) > 0 {
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue,
+			fmt.Sprintf("unknown members: %s", strings.Join(invalid, ", "))))
+		return false
+	}
+	return true
+}
+
+func (h *SCIMHandler) user(c *gin.Context) (*models.SCIMUser, bool) {
+	id, ok := h.resourceID(c)
+	if !ok {
+		return nil, false
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	member, err := h.scim.GetUser(c.Request.Context(), organizationID, id)
+	if err != nil {
+		h.error(c, err)
+		return nil, false
+	}
+	return member, true
+}
+
+func (h *SCIMHandler) group(c *gin.Context) (*models.SCIMGroup, bool) {
+	id, ok := h.resourceID(c)
+	if !ok {
+		return nil, false
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	group, err := h.scim.GetGroup(c.Request.Context(), organizationID, id)
+	if err != nil {
+		h.error(c, err)
+		return nil, false
+	}
+	return group, true
+}
+
+// resourceID parses the id path parameter. IDs that are not UUIDs cannot
+// name a resource, so they are reported as missing.
+func (h *SCIMHandler) resourceID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		h.error(c, repository.ErrSCIMNotFound)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// listQuery reads the filter and the 1-based pagination parameters of a list
+// request. A count of zero returns only totalResults.
+func (h *SCIMHandler) listQuery(c *gin.Context) (scim.Expr, int, int, bool) {
+	var query dto.SCIMListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue,
+			"startIndex and count must be integers"))
+		return nil, 0, 0, false
+	}
+
+	var filter scim.Expr
+	if strings.TrimSpace(query.Filter) != "" {
+		expr, err := scim.ParseFilter(query.Filter)
+		if err != nil {
+			h.error(c, err)
+			return nil, 0, 0, false
+		}
+		filter = expr
+	}
+
+	startIndex := max(query.StartIndex, 1)
+	count := defaultSCIMPageSize
+	if query.Count != nil {
+		count = min(max(*query.Count, 0), scim.MaxResults)
+	}
+	return filter, startIndex, count, true
+}
+
+func (h *SCIMHandler) bind(c *gin.Context, req interface{}) bool {
+	if err := json.NewDecoder(c.Request.Body).Decode(req); err != nil {
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax,
+			"Invalid request body"))
+		return false
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax,
+			"Validation failed"))
+		return false
+	}
+
+	return true
+}
+
+// error reports err in the SCIM error format.
+func (h *SCIMHandler) error(c *gin.Context, err error) {
+	var patchErr *scim.PatchError
+	switch {
+	case errors.As(err, &patchErr):
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, patchErr.Type, patchErr.Detail))
+	case errors.Is(err, scim.ErrInvalidFilter):
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidFilter, err.Error()))
+	case errors.Is(err, repository.ErrSCIMNotFound):
+		h.respond(c, http.StatusNotFound, scim.NewError(http.StatusNotFound, "", "Resource not found"))
+	case errors.Is(err, repository.ErrSCIMConflict):
+		h.respond(c, http.StatusConflict, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, err.Error()))
+	case errors.Is(err, repository.ErrSCIMNotManaged):
+		h.respond(c, http.StatusConflict, scim.NewError(http.StatusConflict, scim.ErrorMutability, err.Error()))
+	case errors.Is(err, repository.ErrSCIMInvalidMember):
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	case errors.Is(err, repository.ErrSCIMImmutable):
+		h.respond(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorMutability, err.Error()))
+	default:
+		log.Printf("%v\n", err)
+		h.respond(c, http.StatusInternalServerError, scim.NewError(http.StatusInternalServerError, "", "Internal server error"))
+	}
+}
+
+func (h *SCIMHandler) respond(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, body)
+}
+
+func (h *SCIMHandler) internalError(c *gin.Context, err error, message string) {
+	log.Printf("%v\n", err)
+	c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+		Status:  "error",
+		Message: message,
+	})
+}
+
+// scimBaseURL returns the absolute SCIM root of the request, honouring the
+// scheme set by a TLS-terminating proxy.
+func scimBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host + SCIMBasePath
+}
+
+func generateSCIMToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate SCIM token: %w", err)
+	}
+	return "scim_" + base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/scim"
+)
+
+type fakeSCIMRepository struct {
+	repository.SCIMRepository
+	tokens  map[string]*models.SCIMToken
+	members map[uuid.UUID]*models.SCIMUser
+	// shared holds the members that also belong to other organizations.
+	shared map[uuid.UUID]bool
+}
+
+func (f *fakeSCIMRepository) AuthenticateToken(ctx context.Context, tokenHash string) (*models.SCIMToken, error) {
+	token, ok := f.tokens[tokenHash]
+	if !ok {
+		return nil, repository.ErrSCIMNotFound
+	}
+	return token, nil
+}
+
+func (f *fakeSCIMRepository) CreateUser(ctx context.Context, member *models.SCIMUser) error {
+	for _, existing := range f.members {
+		if existing.User.Email == member.User.Email {
+			return repository.ErrSCIMConflict
+		}
+	}
+	member.User.ID = uuid.New()
+	copied := *member
+	f.members[member.User.ID] = &copied
+	return nil
+}
+
+func (f *fakeSCIMRepository) GetUser(ctx context.Context, organizationID, id uuid.UUID) (*models.SCIMUser, error) {
+	member, ok := f.members[id]
+	if !ok || member.OrganizationID != organizationID {
+		return nil, repository.ErrSCIMNotFound
+	}
+	copied := *member
+	return &copied, nil
+}
+
+func (f *fakeSCIMRepository) UpdateUser(ctx context.Context, member *models.SCIMUser) error {
+	if f.shared[member.User.ID] {
+		if member.User.Email != f.members[member.User.ID].User.Email || member.User.Password != "" {
+			return repository.ErrSCIMNotManaged
+		}
+	}
+	copied := *member
+	if copied.User.Password == "" {
+		copied.User.Password = f.members[member.User.ID].User.Password
+	}
+	f.members[member.User.ID] = &copied
+	return nil
+}
+
+func TestSCIMUserProvisioning(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	org := uuid.New()
+	repo := &fakeSCIMRepository{
+		tokens:  map[string]*models.SCIMToken{middleware.HashSCIMToken("scim_org"): {ID: uuid.New(), OrganizationID: org}},
+		members: make(map[uuid.UUID]*models.SCIMUser),
+	}
+	other := uuid.New()
+	repo.tokens[middleware.HashSCIMToken("scim_other")] = &models.SCIMToken{ID: uuid.New(), OrganizationID: other}
+
+	router := gin.New()
+	scimRoutes := router.Group(SCIMBasePath, middleware.SCIMAuthenticate(repo))
+	h := NewSCIMHandler(validator.New(), repo)
+	scimRoutes.GET("/Users", h.ListUsers)
+	scimRoutes.POST("/Users", h.CreateUser)
+	scimRoutes.GET("/Users/:id", h.GetUser)
+	scimRoutes.PATCH("/Users/:id", h.PatchUser)
+
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, SCIMBasePath+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodGet, "/Users/"+uuid.NewString(), "", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, scim.ContentType, w.Header().Get("Content-Type"))
+
+	body := `{"schemas":["` + scim.UserSchema + `"],"userName":"Jane@Example.com","externalId":"00u1",
+		"name":{"givenName":"Jane","familyName":"Doe"},"password":"s3cret-pass"}`
+	w = send(http.MethodPost, "/Users", "scim_org", body)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, scim.ContentType, w.Header().Get("Content-Type"))
+	require.NotContains(t, w.Body.String(), "password")
+
+	var created scim.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	require.Equal(t, "jane@example.com", created.UserName)
+	require.True(t, *created.Active)
+	require.Equal(t, "http://example.com/scim/v2/Users/"+created.ID, created.Meta.Location)
+
+	stored := repo.members[uuid.MustParse(created.ID)]
+	require.Equal(t, org, stored.OrganizationID)
+	require.True(t, auth.CheckPassword(stored.User.Password, "s3cret-pass"))
+
+	w = send(http.MethodPost, "/Users", "scim_org", body)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), scim.ErrorUniqueness)
+
+	w = send(http.MethodGet, "/Users/"+created.ID, "scim_other", "")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send(http.MethodPatch, "/Users/"+created.ID, "scim_org",
+		`{"schemas":["`+scim.PatchOpSchema+`"],"Operations":[{"op":"Replace","path":"active","value":"False"}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.False(t, repo.members[uuid.MustParse(created.ID)].Active)
+	require.True(t, auth.CheckPassword(repo.members[uuid.MustParse(created.ID)].User.Password, "s3cret-pass"))
+
+	repo.shared = map[uuid.UUID]bool{uuid.MustParse(created.ID): true}
+	w = send(http.MethodPatch, "/Users/"+created.ID, "scim_org",
+		`{"schemas":["`+scim.PatchOpSchema+`"],"Operations":[{"op":"replace","path":"userName","value":"mallory@example.com"}]}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), scim.ErrorMutability)
+	require.Equal(t, "jane@example.com", repo.members[uuid.MustParse(created.ID)].User.Email)
+
+	w = send(http.MethodGet, "/Users?filter=userName+eq", "scim_org", "")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), scim.ErrorInvalidFilter)
+}
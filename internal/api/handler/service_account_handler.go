@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type ServiceAccountHandler struct {
+	validator       *validator.Validate
+	serviceAccounts *service.ServiceAccountService
+}
+
+func NewServiceAccountHandler(validator *validator.Validate, serviceAccounts *service.ServiceAccountService) *ServiceAccountHandler {
+	return &ServiceAccountHandler{
+		validator:       validator,
+		serviceAccounts: serviceAccounts,
+	}
+}
+
+func (h *ServiceAccountHandler) Create(c *gin.Context) {
+	var req dto.CreateServiceAccountRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	createdBy := middleware.CurrentUserID(c)
+	account := &models.ServiceAccount{
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		Description:    req.Description,
+		IsActive:       true,
+		CreatedBy:      &createdBy,
+	}
+	secret, err := h.serviceAccounts.Create(c.Request.Context(), account)
+	if err != nil {
+		internalError(c, err, "Failed to create service account")
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.ServiceAccountSecretResponse{ServiceAccount: *account, ClientSecret: secret},
+	})
+}
+
+func (h *ServiceAccountHandler) List(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	accounts, err := h.serviceAccounts.List(c.Request.Context(), organizationID)
+	if err != nil {
+		internalError(c, err, "Failed to list service accounts")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   accounts,
+	})
+}
+
+func (h *ServiceAccountHandler) Get(c *gin.Context) {
+	account, ok := h.account(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   account,
+	})
+}
+
+func (h *ServiceAccountHandler) Update(c *gin.Context) {
+	account, ok := h.account(c)
+	if !ok {
+		return
+	}
+
+	var req dto.UpdateServiceAccountRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	account.Name = req.Name
+	account.Description = req.Description
+	account.IsActive = *req.IsActive
+	if err := h.serviceAccounts.Update(c.Request.Context(), account); err != nil {
+		internalError(c, err, "Failed to update service account")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   account,
+	})
+}
+
+func (h *ServiceAccountHandler) Delete(c *gin.Context) {
+	account, ok := h.account(c)
+	if !ok {
+		return
+	}
+
+	if err := h.serviceAccounts.Delete(c.Request.Context(), account); err != nil {
+		internalError(c, err, "Failed to delete service account")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RotateSecret issues a new secret. The previous one keeps working for the
+// configured grace period, so that deployments can switch over.
+func (h *ServiceAccountHandler) RotateSecret(c *gin.Context) {
+	account, ok := h.account(c)
+	if !ok {
+		return
+	}
+
+	secret, err := h.serviceAccounts.RotateSecret(c.Request.Context(), account)
+	if err != nil {
+		internalError(c, err, "Failed to rotate service account secret")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.ServiceAccountSecretResponse{ServiceAccount: *account, ClientSecret: secret},
+	})
+}
+
+func (h *ServiceAccountHandler) ListRoles(c *gin.Context) {
+	account, ok := h.account(c)
+	if !ok {
+		return
+	}
+
+	roles, err := h.serviceAccounts.ListRoles(c.Request.Context(), account)
+	if err != nil {
+		internalError(c, err, "Failed to list roles")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   roles,
+	})
+}
+
+func (h *ServiceAccountHandler) AssignRole(c *gin.Context) {
+	account, ok := h.account(c)
+	if !ok {
+		return
+	}
+
+	var req dto.AssignServiceAccountRoleRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	err := h.serviceAccounts.AssignRole(c.Request.Context(), account, req.RoleID, middleware.CurrentUserID(c))
+	if errors.Is(err, repository.ErrRoleNotFound) || errors.Is(err, service.ErrRoleNotInOrganization) {
+		errorResponse(c, http.StatusNotFound, "Role not found")
+		return
+	}
+	if err != nil {
+		internalError(c, err, "Failed to assign role")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ServiceAccountHandler) RemoveRole(c *gin.Context) {
+	account, ok := h.account(c)
+	if !ok {
+		return
+	}
+
+	roleID, err := uuid.Parse(c.Param("role_id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid role ID")
+		return
+	}
+
+	err = h.serviceAccounts.RemoveRole(c.Request.Context(), account, roleID)
+	if errors.Is(err, repository.ErrUserRoleNotFound) {
+		errorResponse(c, http.StatusNotFound, "Role not assigned")
+		return
+	}
+	if err != nil {
+		internalError(c, err, "Failed to remove role")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// account loads the service account named in the path. Accounts of other
+// organizations are reported as missing.
+func (h *ServiceAccountHandler) account(c *gin.Context) (*models.ServiceAccount, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid service account ID")
+		return nil, false
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	account, err := h.serviceAccounts.Get(c.Request.Context(), organizationID, id)
+	if errors.Is(err, repository.ErrServiceAccountNotFound) {
+		errorResponse(c, http.StatusNotFound, "Service account not found")
+		return nil, false
+	}
+	if err != nil {
+		internalError(c, err, "Failed to get service account")
+		return nil, false
+	}
+
+	return account, true
+}
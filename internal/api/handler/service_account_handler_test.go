@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type fakeServiceAccountRepository struct {
+	repository.ServiceAccountRepository
+	accounts map[uuid.UUID]*models.ServiceAccount
+	used     int
+}
+
+func (f *fakeServiceAccountRepository) Create(ctx context.Context, account *models.ServiceAccount) error {
+	account.ID = uuid.New()
+	copied := *account
+	f.accounts[account.ID] = &copied
+	return nil
+}
+
+func (f *fakeServiceAccountRepository) GetByClientID(ctx context.Context, clientID string) (*models.ServiceAccount, error) {
+	for _, account := range f.accounts {
+		if account.ClientID == clientID {
+			copied := *account
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrServiceAccountNotFound
+}
+
+func (f *fakeServiceAccountRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.ServiceAccount, error) {
+	account, ok := f.accounts[id]
+	if !ok {
+		return nil, repository.ErrServiceAccountNotFound
+	}
+	copied := *account
+	return &copied, nil
+}
+
+func (f *fakeServiceAccountRepository) RotateSecret(ctx context.Context, id uuid.UUID, secretHash string, previousExpiresAt time.Time) error {
+	account := f.accounts[id]
+	account.PreviousSecretHash = account.SecretHash
+	account.PreviousSecretExpiresAt = &previousExpiresAt
+	account.SecretHash = secretHash
+	return nil
+}
+
+func (f *fakeServiceAccountRepository) RecordUse(ctx context.Context, id uuid.UUID, usedAt time.Time, ip string) error {
+	f.used++
+	return nil
+}
+
+type fakePermissionUserRoleRepository struct {
+	repository.UserRoleRepository
+	permissions []string
+}
+
+func (f *fakePermissionUserRoleRepository) GetUserPermissions(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, error) {
+	permissions := make([]models.Permission, len(f.permissions))
+	for i, name := range f.permissions {
+		permissions[i] = models.Permission{Name: name}
+	}
+	return permissions, nil
+}
+
+func (f *fakePermissionUserRoleRepository) HasPermission(ctx context.Context, userID uuid.UUID, organizationID uuid.UUID, permission string) (bool, error) {
+	for _, name := range f.permissions {
+		if name == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestServiceAccountClientCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	accounts := &fakeServiceAccountRepository{accounts: map[uuid.UUID]*models.ServiceAccount{}}
+	userRoles := &fakePermissionUserRoleRepository{permissions: []string{"users:read", "users:write"}}
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	serviceAccounts := service.NewServiceAccountService(config.ServiceAccountConfig{}, accounts, nil, userRoles, tokens)
+	h := NewOAuthHandler(validator.New(), nil, nil, serviceAccounts, nil)
+
+	account := &models.ServiceAccount{OrganizationID: uuid.New(), Name: "billing-sync", IsActive: true}
+	secret, err := serviceAccounts.Create(context.Background(), account)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(account.ClientID, "sa_"))
+	require.True(t, strings.HasPrefix(secret, "sas_"))
+
+	router := gin.New()
+	router.POST(oidc.TokenPath, h.Token)
+	for _, permission := range []string{"users:read", "users:write"} {
+		router.GET("/"+strings.ReplaceAll(permission, ":", "/"), middleware.Authenticate(tokens), middleware.RequirePermission(userRoles, permission), func(c *gin.Context) {
+			require.Equal(t, account.ID, middleware.CurrentUserID(c))
+			c.Status(http.StatusNoContent)
+		})
+	}
+
+	token := func(clientID, secret string, form url.Values) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, oidc.TokenPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+	call := func(accessToken, permission string) int {
+		req := httptest.NewRequest(http.MethodGet, "/"+strings.ReplaceAll(permission, ":", "/"), nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	grant := url.Values{"grant_type": {oidc.GrantTypeClientCredentials}}
+
+	// Without a scope, the token carries all of the account's permissions.
+	status, body := token(account.ClientID, secret, grant)
+	require.Equal(t, http.StatusOK, status, body)
+	require.Equal(t, "Bearer", body["token_type"])
+	require.Equal(t, float64(3600), body["expires_in"])
+	require.Equal(t, http.StatusNoContent, call(body["access_token"].(string), "users:read"))
+	require.Equal(t, http.StatusNoContent, call(body["access_token"].(string), "users:write"))
+	require.Equal(t, 1, accounts.used)
+
+	// A scoped token is limited to the requested permissions.
+	status, body = token(account.ClientID, secret, url.Values{"grant_type": grant["grant_type"], "scope": {"users:read"}})
+	require.Equal(t, http.StatusOK, status, body)
+	require.Equal(t, "users:read", body["scope"])
+	require.Equal(t, http.StatusNoContent, call(body["access_token"].(string), "users:read"))
+	require.Equal(t, http.StatusForbidden, call(body["access_token"].(string), "users:write"))
+
+	status, body = token(account.ClientID, secret, url.Values{"grant_type": grant["grant_type"], "scope": {"users:read roles:write"}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, oidc.ErrorInvalidScope, body["error"])
+
+	status, body = token(account.ClientID, "sas_wrong", grant)
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, oidc.ErrorInvalidClient, body["error"])
+
+	// After a rotation, the previous secret works until its grace period
+	// ends.
+	rotated, err := serviceAccounts.RotateSecret(context.Background(), account)
+	require.NoError(t, err)
+	status, _ = token(account.ClientID, rotated, grant)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = token(account.ClientID, secret, grant)
+	require.Equal(t, http.StatusOK, status)
+
+	expired := time.Now().Add(-time.Second)
+	accounts.accounts[account.ID].PreviousSecretExpiresAt = &expired
+	status, _ = token(account.ClientID, secret, grant)
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	accounts.accounts[account.ID].IsActive = false
+	status, _ = token(account.ClientID, rotated, grant)
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	// Without the OpenID Connect provider, other grants are unsupported.
+	status, body = token(account.ClientID, rotated, url.Values{"grant_type": {oidc.GrantTypeAuthorizationCode}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, oidc.ErrorUnsupportedGrantType, body["error"])
+}
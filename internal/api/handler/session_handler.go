@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"net/http"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+)
+
+type SessionHandler struct {
+	validator *validator.Validate
+	sessions  *service.SessionService
+	tokens    *auth.TokenManager
+}
+
+func NewSessionHandler(validator *validator.Validate, sessionService *service.SessionService, tokens *auth.TokenManager) *SessionHandler {
+	return &SessionHandler{
+		validator: validator,
+		sessions:  sessionService,
+		tokens:    tokens,
+	}
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh
+// token.
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	var req dto.RefreshTokenRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	result, err := h.sessions.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		h.error(c, err, "Failed to refresh token")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   newLoginResponse(result, h.tokens),
+	})
+}
+
+// Logout ends the session of the current token.
+func (h *SessionHandler) Logout(c *gin.Context) {
+	if err := h.sessions.Logout(c.Request.Context(), middleware.CurrentClaims(c)); err != nil {
+		h.error(c, err, "Failed to log out")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// List returns the current user's active sessions.
+func (h *SessionHandler) List(c *gin.Context) {
+	sessions, err := h.sessions.List(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		h.error(c, err, "Failed to list sessions")
+		return
+	}
+
+	current := middleware.CurrentSessionID(c)
+	resp := make([]dto.SessionResponse, len(sessions))
+	for i, session := range sessions {
+		resp[i] = newSessionResponse(&session, current)
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   resp,
+	})
+}
+
+// Revoke ends one of the current user's sessions.
+func (h *SessionHandler) Revoke(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	if err := h.sessions.Revoke(c.Request.Context(), middleware.CurrentUserID(c), id); err != nil {
+		h.error(c, err, "Failed to revoke session")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeOthers ends every session of the current user except the one of
+// the current token.
+func (h *SessionHandler) RevokeOthers(c *gin.Context) {
+	revoked, err := h.sessions.RevokeOthers(c.Request.Context(), middleware.CurrentUserID(c), middleware.CurrentSessionID(c))
+	if err != nil {
+		h.error(c, err, "Failed to revoke sessions")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.RevokedSessionsResponse{Revoked: revoked},
+	})
+}
+
+// ForceLogout ends every session of a member of the current organization.
+func (h *SessionHandler) ForceLogout(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	revoked, err := h.sessions.ForceLogout(c.Request.Context(), organizationID, id)
+	if err != nil {
+		h.error(c, err, "Failed to log out user")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.RevokedSessionsResponse{Revoked: revoked},
+	})
+}
+
+func (h *SessionHandler) GetPolicy(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	policy, err := h.sessions.GetPolicy(c.Request.Context(), organizationID)
+	if err != nil {
+		internalError(c, err, "Failed to get session policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   policy,
+	})
+}
+
+// UpdatePolicy sets how many sessions each member may hold in the current
+// organization at once.
+func (h *SessionHandler) UpdatePolicy(c *gin.Context) {
+	var req dto.UpdateSessionPolicyRequest
+	if !bindJSON(c, h.validator, &req) {
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	policy := &models.SessionPolicy{OrganizationID: organizationID, MaxSessionsPerUser: req.MaxSessionsPerUser}
+	if err := h.sessions.SetPolicy(c.Request.Context(), policy); err != nil {
+		internalError(c, err, "Failed to update session policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   policy,
+	})
+}
+
+func (h *SessionHandler) error(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidRefreshToken):
+		errorResponse(c, http.StatusUnauthorized, "Invalid or expired refresh token")
+	case errors.Is(err, repository.ErrSessionNotFound):
+		errorResponse(c, http.StatusNotFound, "Session not found")
+	case errors.Is(err, repository.ErrUserNotFound):
+		errorResponse(c, http.StatusNotFound, "User not found")
+	default:
+		internalError(c, err, message)
+	}
+}
+
+func newSessionResponse(session *models.Session, current uuid.UUID) dto.SessionResponse {
+	return dto.SessionResponse{
+		ID:             session.ID,
+		OrganizationID: session.OrganizationID,
+		Device:         session.Device,
+		UserAgent:      session.UserAgent,
+		IPAddress:      session.IPAddress,
+		CreatedAt:      session.CreatedAt,
+		LastSeenAt:     session.LastSeenAt,
+		ExpiresAt:      session.ExpiresAt,
+		Current:        session.ID == current,
+	}
+}
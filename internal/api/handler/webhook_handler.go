@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"user-management/internal/api/dto"
+	"user-management/internal/api/middleware"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/webhook"
+)
+
+const defaultWebhookDeliveryPageSize = 50
+
+type WebhookHandler struct {
+	validator *validator.Validate
+	webhooks  repository.WebhookRepository
+	deliverer *webhook.Deliverer
+}
+
+func NewWebhookHandler(validator *validator.Validate, webhooks repository.WebhookRepository, deliverer *webhook.Deliverer) *WebhookHandler {
+	return &WebhookHandler{
+		validator: validator,
+		webhooks:  webhooks,
+		deliverer: deliverer,
+	}
+}
+
+func (h *WebhookHandler) Create(c *gin.Context) {
+	var req dto.CreateWebhookRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		h.internalError(c, err, "Failed to create webhook")
+		return
+	}
+
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	subscription := &models.WebhookSubscription{
+		OrganizationID: organizationID,
+		URL:            req.URL,
+		Description:    req.Description,
+		EventTypes:     req.EventTypes,
+		Secret:         secret,
+		IsActive:       true,
+	}
+	if err := h.webhooks.Create(c.Request.Context(), subscription); err != nil {
+		h.internalError(c, err, "Failed to create webhook")
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.WebhookSecretResponse{WebhookSubscription: *subscription, Secret: secret},
+	})
+}
+
+func (h *WebhookHandler) List(c *gin.Context) {
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	subscriptions, err := h.webhooks.List(c.Request.Context(), organizationID)
+	if err != nil {
+		h.internalError(c, err, "Failed to list webhooks")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   subscriptions,
+	})
+}
+
+func (h *WebhookHandler) Get(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   subscription,
+	})
+}
+
+// Update replaces the subscription settings. Setting is_active re-enables a
+// subscription that was disabled after repeated failures.
+func (h *WebhookHandler) Update(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	var req dto.UpdateWebhookRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	subscription.URL = req.URL
+	subscription.Description = req.Description
+	subscription.EventTypes = req.EventTypes
+	if *req.IsActive != subscription.IsActive {
+		subscription.IsActive = *req.IsActive
+		if !subscription.IsActive {
+			now := time.Now()
+			subscription.DisabledAt = &now
+			subscription.DisabledReason = "disabled by user"
+		}
+	}
+
+	if err := h.webhooks.Update(c.Request.Context(), subscription); err != nil {
+		h.internalError(c, err, "Failed to update webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   subscription,
+	})
+}
+
+func (h *WebhookHandler) RotateSecret(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		h.internalError(c, err, "Failed to rotate webhook secret")
+		return
+	}
+	subscription.Secret = secret
+
+	if err := h.webhooks.Update(c.Request.Context(), subscription); err != nil {
+		h.internalError(c, err, "Failed to rotate webhook secret")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   dto.WebhookSecretResponse{WebhookSubscription: *subscription, Secret: secret},
+	})
+}
+
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhooks.Delete(c.Request.Context(), subscription.ID); err != nil {
+		h.internalError(c, err, "Failed to delete webhook")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Deliveries returns the delivery history of a subscription, newest first.
+func (h *WebhookHandler) Deliveries(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	var query dto.WebhookDeliveryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Invalid query parameters",
+		})
+		return
+	}
+
+	if err := h.validator.Struct(query); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Validation failed",
+			Errors:  validationErrors(err),
+		})
+		return
+	}
+
+	filter := repository.WebhookDeliveryFilter{
+		SubscriptionID: subscription.ID,
+		Status:         query.Status,
+		Limit:          query.Limit,
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultWebhookDeliveryPageSize
+	}
+	if query.Cursor != "" {
+		createdAt, id, ok := decodeDeliveryCursor(query.Cursor)
+		if !ok {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Status:  "error",
+				Message: "Invalid cursor",
+			})
+			return
+		}
+		filter.BeforeCreatedAt = &createdAt
+		filter.BeforeID = id
+	}
+
+	deliveries, err := h.webhooks.ListDeliveries(c.Request.Context(), filter)
+	if err != nil {
+		h.internalError(c, err, "Failed to list webhook deliveries")
+		return
+	}
+
+	resp := dto.WebhookDeliveryListResponse{Deliveries: deliveries}
+	if len(deliveries) == filter.Limit {
+		last := deliveries[len(deliveries)-1]
+		resp.NextCursor = encodeDeliveryCursor(last.CreatedAt, last.ID)
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   resp,
+	})
+}
+
+// Test sends a webhook.test event to the subscription right away and
+// returns the recorded delivery.
+func (h *WebhookHandler) Test(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.deliverer.SendTest(c.Request.Context(), subscription)
+	if err != nil {
+		h.internalError(c, err, "Failed to send test event")
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Status: "success",
+		Data:   delivery,
+	})
+}
+
+// subscription loads the subscription named in the path. Subscriptions of
+// other organizations are reported as missing.
+func (h *WebhookHandler) subscription(c *gin.Context) (*models.WebhookSubscription, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Invalid webhook ID",
+		})
+		return nil, false
+	}
+
+	subscription, err := h.webhooks.GetByID(c.Request.Context(), id)
+	organizationID, _ := middleware.CurrentOrganizationID(c)
+	if errors.Is(err, repository.ErrWebhookNotFound) || (err == nil && subscription.OrganizationID != organizationID) {
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Webhook not found",
+		})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err, "Failed to get webhook")
+		return nil, false
+	}
+
+	return subscription, true
+}
+
+func (h *WebhookHandler) bind(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Invalid request body",
+		})
+		return false
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Status:  "error",
+			Message: "Validation failed",
+			Errors:  validationErrors(err),
+		})
+		return false
+	}
+
+	return true
+}
+
+func (h *WebhookHandler) internalError(c *gin.Context, err error, message string) {
+	log.Printf("%v\n", err)
+	c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+		Status:  "error",
+		Message: message,
+	})
+}
+
+func encodeDeliveryCursor(createdAt time.Time, id uuid.UUID) string {
+	return base64.URLEncoding.EncodeToString([]byte(createdAt.Format(time.RFC3339Nano) + "|" + id.String()))
+}
+
+func decodeDeliveryCursor(cursor string) (time.Time, uuid.UUID, bool) {
+	raw, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, false
+	}
+	value, idValue, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, false
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, uuid.Nil, false
+	}
+	id, err := uuid.Parse(idValue)
+	if err != nil {
+		return time.Time{}, uuid.Nil, false
+	}
+	return createdAt, id, true
+}
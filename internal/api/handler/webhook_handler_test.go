@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user-management/internal/api/middleware"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/webhook"
+)
+
+type fakeWebhookRepository struct {
+	repository.WebhookRepository
+	subscriptions map[uuid.UUID]*models.WebhookSubscription
+}
+
+func (f *fakeWebhookRepository) Create(ctx context.Context, subscription *models.WebhookSubscription) error {
+	subscription.ID = uuid.New()
+	f.subscriptions[subscription.ID] = subscription
+	return nil
+}
+
+func (f *fakeWebhookRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	subscription, ok := f.subscriptions[id]
+	if !ok {
+		return nil, repository.ErrWebhookNotFound
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func TestWebhookCreateReturnsSecretOnceAndScopesToOrganization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := &fakeWebhookRepository{subscriptions: make(map[uuid.UUID]*models.WebhookSubscription)}
+	h := NewWebhookHandler(validator.New(), repo, webhook.NewDeliverer(repo, webhook.DelivererOptions{}))
+	org := uuid.New()
+
+	router := gin.New()
+	setOrg := func(c *gin.Context) {
+		if value := c.GetHeader(middleware.HeaderOrganizationID); value != "" {
+			c.Set(middleware.ContextKeyOrganizationID, uuid.MustParse(value))
+		}
+	}
+	router.POST("/webhooks", setOrg, h.Create)
+	router.GET("/webhooks/:id", setOrg, h.Get)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks",
+		strings.NewReader(`{"url":"https://example.com/hook","event_types":["user.*"]}`))
+	req.Header.Set(middleware.HeaderOrganizationID, org.String())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		Data struct {
+			ID     uuid.UUID `json:"id"`
+			Secret string    `json:"secret"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	require.True(t, strings.HasPrefix(created.Data.Secret, "whsec_"))
+	require.Equal(t, org, repo.subscriptions[created.Data.ID].OrganizationID)
+
+	req = httptest.NewRequest(http.MethodGet, "/webhooks/"+created.Data.ID.String(), nil)
+	req.Header.Set(middleware.HeaderOrganizationID, org.String())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), "whsec_")
+
+	req = httptest.NewRequest(http.MethodGet, "/webhooks/"+created.Data.ID.String(), nil)
+	req.Header.Set(middleware.HeaderOrganizationID, uuid.NewString())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"ftp://example.com"}`))
+	req.Header.Set(middleware.HeaderOrganizationID, org.String())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
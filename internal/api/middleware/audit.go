@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/audit"
+)
+
+// AuditContext records the client address and user agent in the request
+// context so repositories can attach them to audit events.
+func AuditContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := audit.ActorFrom(c.Request.Context())
+		actor.IPAddress = c.ClientIP()
+		actor.UserAgent = c.Request.UserAgent()
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+
+		c.Next()
+	}
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"user-management/internal/api/dto"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+const (
+	HeaderOrganizationID = "X-Organization-ID"
+
+	ContextKeyClaims         = "claims"
+	ContextKeyUserID         = "user_id"
+	ContextKeyOrganizationID = "organization_id"
+	ContextKeyMFAEnrollment  = "mfa_enrollment"
+)
+
+// Authenticate validates the bearer access token, or the API key of the
+// X-API-Key header, and stores the caller's identity in the gin context and
+// in the audit actor of the request context. The organization comes from
+// the token, or from the X-Organization-ID header for tokens that are not
+// scoped to one. Requests are then subject to the limits of
+// PrincipalRateLimit.
+func Authenticate(tokens *auth.TokenManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			token, ok = apiKey(c)
+		}
+		if !ok {
+			abort(c, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+
+		claims, err := tokens.ValidateAccessToken(c.Request.Context(), token)
+		if err != nil {
+			tokenError(c, err)
+			return
+		}
+
+		if setIdentity(c, tokens, claims) && limitPrincipal(c) {
+			c.Next()
+		}
+	}
+}
+
+// AuthenticateMFAEnrollment is Authenticate for the MFA enrollment
+// endpoints. It also accepts the enrollment token of a login that the
+// organization's MFA policy holds back, and marks the request with
+// ContextKeyMFAEnrollment so that completing enrollment can finish the login.
+func AuthenticateMFAEnrollment(tokens *auth.TokenManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			abort(c, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+
+		claims, err := tokens.ValidateAccessToken(c.Request.Context(), token)
+		if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+			tokenError(c, err)
+			return
+		}
+		if err != nil {
+			claims, err = tokens.ParseMFAToken(token, auth.TokenUseMFAEnrollment)
+			if err != nil {
+				abort(c, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+			c.Set(ContextKeyMFAEnrollment, true)
+		}
+
+		if setIdentity(c, tokens, claims) && limitPrincipal(c) {
+			c.Next()
+		}
+	}
+}
+
+// tokenError rejects a request whose token did not validate. Errors other
+// than ErrInvalidToken come from checking the session.
+func tokenError(c *gin.Context, err error) {
+	if errors.Is(err, auth.ErrInvalidToken) {
+		abort(c, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+	log.Printf("failed to validate token: %v\n", err)
+	abort(c, http.StatusInternalServerError, "Failed to validate token")
+}
+
+// apiKey returns the API key of the X-API-Key header. Only API keys are
+// accepted there, not JWTs.
+func apiKey(c *gin.Context) (string, bool) {
+	key := strings.TrimSpace(c.GetHeader(auth.HeaderAPIKey))
+	return key, auth.IsAPIKey(key)
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// setIdentity stores the identity of claims. An organization selected with
+// the X-Organization-ID header must have the caller as a member and accept
+// the login of the token, or the request is rejected and setIdentity
+// returns false.
+func setIdentity(c *gin.Context, tokens *auth.TokenManager, claims *auth.Claims) bool {
+	userID, _ := claims.UserID()
+
+	orgValue := claims.OrganizationID
+	if orgValue == "" {
+		orgValue = c.GetHeader(HeaderOrganizationID)
+	}
+
+	c.Set(ContextKeyClaims, claims)
+	c.Set(ContextKeyUserID, userID)
+
+	actor := audit.ActorFrom(c.Request.Context())
+	actor.UserID = &userID
+	if organizationID, err := uuid.Parse(orgValue); err == nil {
+		if err := tokens.CheckOrganization(c.Request.Context(), claims, organizationID); err != nil {
+			organizationError(c, err)
+			return false
+		}
+		c.Set(ContextKeyOrganizationID, organizationID)
+	}
+	c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+	return true
+}
+
+// organizationError rejects a request whose organization does not accept
+// the caller's login.
+func organizationError(c *gin.Context, err error) {
+	if errors.Is(err, auth.ErrNotOrganizationMember) {
+		abort(c, http.StatusForbidden, "Not a member of the organization")
+		return
+	}
+	if errors.Is(err, auth.ErrOrganizationPolicy) {
+		abort(c, http.StatusForbidden, "Log in to the organization to meet its policy")
+		return
+	}
+	log.Printf("failed to check organization policy: %v\n", err)
+	abort(c, http.StatusInternalServerError, "Failed to check organization policy")
+}
+
+// RequirePermission must run after Authenticate. It rejects callers that do
+// not hold permission in their current organization, or whose token is
+// scoped to other permissions.
+func RequirePermission(userRoles repository.UserRoleRepository, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		organizationID, ok := CurrentOrganizationID(c)
+		if !ok {
+			abort(c, http.StatusForbidden, "Organization required")
+			return
+		}
+		if claims := CurrentClaims(c); claims != nil && !claims.AllowsPermission(permission) {
+			abort(c, http.StatusForbidden, "Insufficient scope")
+			return
+		}
+
+		allowed, err := userRoles.HasPermission(c.Request.Context(), CurrentUserID(c), organizationID, permission)
+		if err != nil {
+			log.Printf("failed to check permission %s: %v\n", permission, err)
+			abort(c, http.StatusInternalServerError, "Failed to check permission")
+			return
+		}
+		if !allowed {
+			abort(c, http.StatusForbidden, "Permission denied")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireServiceAccount must run after Authenticate. It rejects callers
+// other than service accounts, whose tokens are bound to their
+// organization.
+func RequireServiceAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := CurrentClaims(c); claims == nil || claims.Principal != auth.PrincipalServiceAccount {
+			abort(c, http.StatusForbidden, "Service account credentials required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireLogin must run after Authenticate. It rejects tokens that are not
+// the user's own login: those of service accounts, API keys and OAuth
+// clients.
+func RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := CurrentClaims(c); claims == nil || claims.Principal != "" || claims.ClientID != "" {
+			abort(c, http.StatusForbidden, "Only a logged in user can do this")
+			return
+		}
+		c.Next()
+	}
+}
+
+func CurrentUserID(c *gin.Context) uuid.UUID {
+	userID, _ := c.Get(ContextKeyUserID)
+	id, _ := userID.(uuid.UUID)
+	return id
+}
+
+// CurrentClaims returns the claims of the caller's token, or nil before
+// Authenticate.
+func CurrentClaims(c *gin.Context) *auth.Claims {
+	claims, _ := c.Get(ContextKeyClaims)
+	current, _ := claims.(*auth.Claims)
+	return current
+}
+
+// CurrentSessionID returns the session of the caller's token, or uuid.Nil
+// for tokens without one.
+func CurrentSessionID(c *gin.Context) uuid.UUID {
+	claims := CurrentClaims(c)
+	if claims == nil {
+		return uuid.Nil
+	}
+	id, _ := uuid.Parse(claims.SessionID)
+	return id
+}
+
+func CurrentOrganizationID(c *gin.Context) (uuid.UUID, bool) {
+	organizationID, ok := c.Get(ContextKeyOrganizationID)
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, ok := organizationID.(uuid.UUID)
+	return id, ok
+}
+
+func abort(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, dto.ErrorResponse{
+		Status:  "error",
+		Message: message,
+	})
+}
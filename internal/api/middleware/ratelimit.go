@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"user-management/internal/auth"
+	"user-management/internal/ratelimit"
+)
+
+const contextKeyPrincipalLimits = "principal_limits"
+
+// KeyFunc returns the key whose bucket a request takes a token from.
+type KeyFunc func(c *gin.Context) string
+
+// KeyByIP limits each client address.
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByUser limits each authenticated user, and clients that are not
+// authenticated by address. It must run after Authenticate.
+func KeyByUser(c *gin.Context) string {
+	if _, ok := c.Get(ContextKeyUserID); ok {
+		return "user:" + CurrentUserID(c).String()
+	}
+	return KeyByIP(c)
+}
+
+// KeyByAPIKey limits each API key by its ID, and clients that are not
+// authenticated with one by address. It must run after Authenticate.
+func KeyByAPIKey(c *gin.Context) string {
+	if claims := CurrentClaims(c); claims != nil && claims.Principal == auth.PrincipalAPIKey {
+		return "api_key:" + claims.ID
+	}
+	return KeyByIP(c)
+}
+
+// RateLimit rejects requests with 429 once the bucket of their key is
+// empty. When the limiter fails the request is let through, since an
+// outage of the limiter must not take the API down with it.
+func RateLimit(limiter ratelimit.Limiter, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if allow(c, limiter, key) {
+			c.Next()
+		}
+	}
+}
+
+// principalLimits are the limiters of authenticated requests. Either may
+// be nil.
+type principalLimits struct {
+	users   ratelimit.Limiter
+	apiKeys ratelimit.Limiter
+}
+
+// PrincipalRateLimit makes Authenticate and AuthenticateMFAEnrollment limit
+// the requests of each API key with apiKeys, and those of other tokens per
+// user with users, once they know who the caller is. Either may be nil to
+// not limit those requests.
+func PrincipalRateLimit(users, apiKeys ratelimit.Limiter) gin.HandlerFunc {
+	limits := &principalLimits{users: users, apiKeys: apiKeys}
+	return func(c *gin.Context) {
+		c.Set(contextKeyPrincipalLimits, limits)
+		c.Next()
+	}
+}
+
+// limitPrincipal applies the limits of PrincipalRateLimit to an
+// authenticated request. It returns false when the request was rejected.
+func limitPrincipal(c *gin.Context) bool {
+	value, ok := c.Get(contextKeyPrincipalLimits)
+	if !ok {
+		return true
+	}
+	limits := value.(*principalLimits)
+
+	limiter, key := limits.users, KeyFunc(KeyByUser)
+	if claims := CurrentClaims(c); claims != nil && claims.Principal == auth.PrincipalAPIKey {
+		limiter, key = limits.apiKeys, KeyByAPIKey
+	}
+	if limiter == nil {
+		return true
+	}
+	return allow(c, limiter, key)
+}
+
+// allow takes a token from the bucket of the request's key, and rejects
+// the request when there is none. It returns false when it did.
+func allow(c *gin.Context, limiter ratelimit.Limiter, key KeyFunc) bool {
+	result, err := limiter.Allow(c.Request.Context(), key(c))
+	if err != nil {
+		log.Printf("failed to check rate limit: %v\n", err)
+		return true
+	}
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	if !result.Allowed {
+		SetRetryAfter(c, result.RetryAfter.Seconds())
+		abort(c, http.StatusTooManyRequests, "Too many requests")
+		return false
+	}
+	return true
+}
+
+// SetRetryAfter sets the Retry-After header to seconds, rounded up.
+func SetRetryAfter(c *gin.Context, seconds float64) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(seconds)))))
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+	"user-management/internal/repository"
+	"user-management/internal/scim"
+)
+
+const ContextKeySCIMTokenID = "scim_token_id"
+
+// SCIMAuthenticate accepts the bearer tokens issued to an organization for
+// provisioning. The organization of the token becomes the current
+// organization; errors are reported in the SCIM error format.
+func SCIMAuthenticate(scimRepo repository.SCIMRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+			abortSCIM(c, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+
+		token, err := scimRepo.AuthenticateToken(c.Request.Context(), HashSCIMToken(strings.TrimSpace(header[7:])))
+		if errors.Is(err, repository.ErrSCIMNotFound) {
+			abortSCIM(c, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		if err != nil {
+			log.Printf("failed to authenticate SCIM token: %v\n", err)
+			abortSCIM(c, http.StatusInternalServerError, "Failed to authenticate")
+			return
+		}
+
+		c.Set(ContextKeyOrganizationID, token.OrganizationID)
+		c.Set(ContextKeySCIMTokenID, token.ID)
+
+		c.Next()
+	}
+}
+
+// HashSCIMToken returns the value stored for a SCIM token.
+func HashSCIMToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func abortSCIM(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", scim.ContentType)
+	c.AbortWithStatusJSON(status, scim.NewError(status, "", detail))
+}
@@ -0,0 +1,15 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+)
+
+func SetupAPIKeyRoutes(router *gin.RouterGroup, apiKeyHandler *handler.APIKeyHandler, tokens *auth.TokenManager) {
+	mine := router.Group("/users/me/api-keys", middleware.Authenticate(tokens))
+	mine.POST("", apiKeyHandler.Create)
+	mine.GET("", apiKeyHandler.List)
+	mine.DELETE("/:id", apiKeyHandler.Revoke)
+}
@@ -0,0 +1,16 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+func SetupAuditRoutes(router *gin.RouterGroup, auditHandler *handler.AuditHandler, tokens *auth.TokenManager, userRoles repository.UserRoleRepository) {
+	audit := router.Group("/audit", middleware.Authenticate(tokens))
+
+	audit.GET("", middleware.RequirePermission(userRoles, "audit:read"), auditHandler.List)
+	audit.GET("/export", middleware.RequirePermission(userRoles, "audit:export"), auditHandler.Export)
+}
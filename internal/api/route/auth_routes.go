@@ -0,0 +1,49 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+func SetupAuthRoutes(router *gin.RouterGroup, authHandler *handler.AuthHandler, mfaHandler *handler.MFAHandler, accountHandler *handler.AccountHandler, tokens *auth.TokenManager, userRoles repository.UserRoleRepository, limits ...gin.HandlerFunc) {
+	authGroup := router.Group("/auth", limits...)
+	authGroup.POST("/login", authHandler.Login)
+	authGroup.POST("/login/mfa", authHandler.VerifyMFA)
+	authGroup.POST("/forgot-password", accountHandler.ForgotPassword)
+	authGroup.POST("/reset-password", accountHandler.ResetPassword)
+	authGroup.POST("/verify-email", accountHandler.VerifyEmail)
+	authGroup.POST("/verify-email/send", middleware.Authenticate(tokens), accountHandler.SendVerificationEmail)
+
+	mfa := router.Group("/mfa")
+	mfa.GET("", middleware.Authenticate(tokens), mfaHandler.Status)
+
+	// Changes take codes or the password, so they get the auth limits.
+	mfaChanges := mfa.Group("", limits...)
+	mfaChanges.POST("/totp", middleware.AuthenticateMFAEnrollment(tokens), mfaHandler.EnrollTOTP)
+	mfaChanges.POST("/totp/confirm", middleware.AuthenticateMFAEnrollment(tokens), mfaHandler.ConfirmTOTP)
+	mfaChanges.DELETE("/totp", middleware.Authenticate(tokens), mfaHandler.DisableTOTP)
+	mfaChanges.POST("/recovery-codes", middleware.Authenticate(tokens), mfaHandler.RegenerateRecoveryCodes)
+
+	policy := mfa.Group("/policy",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "mfa:manage"),
+	)
+	policy.GET("", mfaHandler.GetPolicy)
+	policy.PUT("", mfaHandler.UpdatePolicy)
+
+	loginPolicy := router.Group("/auth/policy",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "login_policy:manage"),
+	)
+	loginPolicy.GET("", authHandler.GetLoginPolicy)
+	loginPolicy.PUT("", authHandler.UpdateLoginPolicy)
+
+	router.POST("/users/:id/unlock",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "user:unlock"),
+		authHandler.UnlockUser,
+	)
+}
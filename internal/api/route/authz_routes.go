@@ -0,0 +1,19 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+)
+
+// SetupAuthzRoutes mounts the batch check for service accounts, which may
+// only check permissions in their own organization.
+func SetupAuthzRoutes(router *gin.RouterGroup, authzHandler *handler.AuthzHandler, tokens *auth.TokenManager) {
+	authz := router.Group("/authz",
+		middleware.Authenticate(tokens),
+		middleware.RequireServiceAccount(),
+	)
+
+	authz.POST("/check", authzHandler.Check)
+}
@@ -0,0 +1,21 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+)
+
+func SetupFederationRoutes(router *gin.RouterGroup, federationHandler *handler.FederationHandler, tokens *auth.TokenManager, limits ...gin.HandlerFunc) {
+	login := router.Group("/auth/federated", limits...)
+	login.GET("", federationHandler.Providers)
+	login.POST("/callback", federationHandler.FinishLogin)
+	login.POST("/:provider/begin", federationHandler.BeginLogin)
+
+	identities := router.Group("/users/me/identities", middleware.Authenticate(tokens))
+	identities.GET("", federationHandler.List)
+	identities.POST("/callback", federationHandler.FinishLink)
+	identities.POST("/:provider/begin", federationHandler.BeginLink)
+	identities.DELETE("/:id", federationHandler.Unlink)
+}
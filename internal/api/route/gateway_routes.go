@@ -0,0 +1,12 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+)
+
+func SetupGatewayRoutes(router *gin.RouterGroup, forwardAuthHandler *handler.ForwardAuthHandler) {
+	authz := router.Group("/authz")
+
+	authz.Any("/forward", forwardAuthHandler.Check)
+}
@@ -0,0 +1,54 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+// SetupOAuthRoutes mounts the token, introspection and revocation endpoints
+// and the key set on router.
+// When the OpenID Connect provider is enabled, it also mounts the provider
+// endpoints on router and the endpoints used by the login page and for
+// client management on api.
+func SetupOAuthRoutes(router gin.IRouter, api *gin.RouterGroup, oauthHandler *handler.OAuthHandler, tokens *auth.TokenManager, userRoles repository.UserRoleRepository) {
+	router.POST(oidc.TokenPath, oauthHandler.Token)
+	router.POST(oidc.IntrospectionPath, oauthHandler.Introspect)
+	router.POST(oidc.RevocationPath, oauthHandler.Revoke)
+	router.GET(oidc.JWKSPath, oauthHandler.JWKS)
+	if !oauthHandler.Provider() {
+		return
+	}
+
+	router.GET(oidc.DiscoveryPath, oauthHandler.Discovery)
+	router.GET(oidc.AuthorizePath, oauthHandler.Authorize)
+	router.POST(oidc.DeviceAuthorizationPath, oauthHandler.DeviceAuthorization)
+	router.GET(oidc.DevicePath, oauthHandler.Device)
+	router.GET(oidc.UserInfoPath, oauthHandler.UserInfo)
+	router.POST(oidc.UserInfoPath, oauthHandler.UserInfo)
+
+	// Only the user's own login can grant clients access on their behalf.
+	authorize := api.Group("/oauth", middleware.Authenticate(tokens), middleware.RequireLogin())
+	authorize.POST("/authorize", oauthHandler.AuthorizeUser)
+	authorize.POST("/consent", oauthHandler.Consent)
+	authorize.POST("/device", oauthHandler.CheckDevice)
+	authorize.POST("/device/consent", oauthHandler.DeviceConsent)
+
+	clients := api.Group("/oauth/clients",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "oauth_clients:manage"),
+	)
+	clients.POST("", oauthHandler.CreateClient)
+	clients.GET("", oauthHandler.ListClients)
+	clients.GET("/:id", oauthHandler.GetClient)
+	clients.PUT("/:id", oauthHandler.UpdateClient)
+	clients.DELETE("/:id", oauthHandler.DeleteClient)
+	clients.POST("/:id/secret", oauthHandler.RotateClientSecret)
+
+	consents := api.Group("/users/me/consents", middleware.Authenticate(tokens))
+	consents.GET("", oauthHandler.ListConsents)
+	consents.DELETE("/:client_id", oauthHandler.RevokeConsent)
+}
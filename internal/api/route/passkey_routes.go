@@ -0,0 +1,24 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+)
+
+func SetupPasskeyRoutes(router *gin.RouterGroup, passkeyHandler *handler.PasskeyHandler, tokens *auth.TokenManager, limits ...gin.HandlerFunc) {
+	login := router.Group("/auth/passkey", limits...)
+	login.POST("/begin", passkeyHandler.BeginLogin)
+	login.POST("/finish", passkeyHandler.FinishLogin)
+
+	passkeys := router.Group("/passkeys", middleware.Authenticate(tokens))
+	passkeys.GET("", passkeyHandler.List)
+	passkeys.DELETE("/:id", passkeyHandler.Delete)
+
+	// Registering takes the password or a passkey, so it gets the auth
+	// limits.
+	register := passkeys.Group("/register", append([]gin.HandlerFunc{middleware.RequireLogin()}, limits...)...)
+	register.POST("/begin", passkeyHandler.BeginRegistration)
+	register.POST("/finish", passkeyHandler.FinishRegistration)
+}
@@ -0,0 +1,12 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+)
+
+func SetupPasswordlessRoutes(router *gin.RouterGroup, passwordlessHandler *handler.PasswordlessHandler, limits ...gin.HandlerFunc) {
+	passwordless := router.Group("/auth/passwordless", limits...)
+	passwordless.POST("", passwordlessHandler.Start)
+	passwordless.POST("/verify", passwordlessHandler.Verify)
+}
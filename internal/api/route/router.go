@@ -0,0 +1,19 @@
+package route
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter creates the engine with gin's logger and recovery. Client
+// addresses, which rate limits, login throttling and the audit log key on,
+// are only taken from X-Forwarded-For and X-Real-IP on requests from
+// trustedProxies (addresses or CIDR ranges); with none, they are the address
+// of the connection.
+func NewRouter(trustedProxies []string) (*gin.Engine, error) {
+	router := gin.Default()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	return router, nil
+}
@@ -0,0 +1,35 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewRouterTrustsOnlyConfiguredProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clientIP := func(trustedProxies []string, remoteAddr string) string {
+		router, err := NewRouter(trustedProxies)
+		require.NoError(t, err)
+		router.GET("/ip", func(c *gin.Context) {
+			c.String(http.StatusOK, c.ClientIP())
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req.Header.Set("X-Real-IP", "203.0.113.8")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	require.Equal(t, "198.51.100.1", clientIP(nil, "198.51.100.1:4000"), "no proxy is trusted by default")
+	require.Equal(t, "198.51.100.1", clientIP([]string{"10.0.0.0/8"}, "198.51.100.1:4000"))
+	require.Equal(t, "203.0.113.7", clientIP([]string{"10.0.0.0/8"}, "10.1.2.3:4000"))
+
+	_, err := NewRouter([]string{"not-an-address"})
+	require.Error(t, err)
+}
@@ -0,0 +1,30 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+	"user-management/internal/saml"
+)
+
+// SetupSAMLRoutes mounts the SP endpoints of organizations on router, where
+// IdPs reach them, and the login and connection management endpoints on api.
+func SetupSAMLRoutes(router gin.IRouter, api *gin.RouterGroup, samlHandler *handler.SAMLHandler, tokens *auth.TokenManager, userRoles repository.UserRoleRepository, limits ...gin.HandlerFunc) {
+	router.GET(saml.MetadataPath, samlHandler.Metadata)
+	router.Group("", limits...).POST(saml.ACSPath, samlHandler.ACS)
+
+	login := api.Group("/auth/saml", limits...)
+	login.POST("/begin", samlHandler.BeginLogin)
+	login.POST("/callback", samlHandler.FinishLogin)
+
+	connection := api.Group("/saml",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "saml:manage"),
+	)
+	connection.GET("/service-provider", samlHandler.ServiceProvider)
+	connection.GET("/connection", samlHandler.GetConnection)
+	connection.PUT("/connection", samlHandler.UpdateConnection)
+	connection.DELETE("/connection", samlHandler.DeleteConnection)
+}
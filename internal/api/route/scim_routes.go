@@ -0,0 +1,42 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+// SetupSCIMRoutes mounts the SCIM service on router under /scim/v2 and the
+// token management endpoints on api.
+func SetupSCIMRoutes(router gin.IRouter, api *gin.RouterGroup, scimHandler *handler.SCIMHandler, scimRepo repository.SCIMRepository, tokens *auth.TokenManager, userRoles repository.UserRoleRepository) {
+	scim := router.Group(handler.SCIMBasePath, middleware.SCIMAuthenticate(scimRepo))
+
+	scim.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+	scim.GET("/ResourceTypes", scimHandler.ResourceTypes)
+	scim.GET("/Schemas", scimHandler.Schemas)
+
+	scim.GET("/Users", scimHandler.ListUsers)
+	scim.POST("/Users", scimHandler.CreateUser)
+	scim.GET("/Users/:id", scimHandler.GetUser)
+	scim.PUT("/Users/:id", scimHandler.ReplaceUser)
+	scim.PATCH("/Users/:id", scimHandler.PatchUser)
+	scim.DELETE("/Users/:id", scimHandler.DeleteUser)
+
+	scim.GET("/Groups", scimHandler.ListGroups)
+	scim.POST("/Groups", scimHandler.CreateGroup)
+	scim.GET("/Groups/:id", scimHandler.GetGroup)
+	scim.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+	scim.PATCH("/Groups/:id", scimHandler.PatchGroup)
+	scim.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+
+	scimTokens := api.Group("/scim/tokens",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "scim:manage"),
+	)
+
+	scimTokens.POST("", scimHandler.CreateToken)
+	scimTokens.GET("", scimHandler.ListTokens)
+	scimTokens.DELETE("/:id", scimHandler.RevokeToken)
+}
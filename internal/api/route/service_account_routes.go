@@ -0,0 +1,25 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+func SetupServiceAccountRoutes(api *gin.RouterGroup, serviceAccountHandler *handler.ServiceAccountHandler, tokens *auth.TokenManager, userRoles repository.UserRoleRepository) {
+	accounts := api.Group("/service-accounts",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "service_accounts:manage"),
+	)
+	accounts.POST("", serviceAccountHandler.Create)
+	accounts.GET("", serviceAccountHandler.List)
+	accounts.GET("/:id", serviceAccountHandler.Get)
+	accounts.PUT("/:id", serviceAccountHandler.Update)
+	accounts.DELETE("/:id", serviceAccountHandler.Delete)
+	accounts.POST("/:id/secret", serviceAccountHandler.RotateSecret)
+	accounts.GET("/:id/roles", serviceAccountHandler.ListRoles)
+	accounts.POST("/:id/roles", serviceAccountHandler.AssignRole)
+	accounts.DELETE("/:id/roles/:role_id", serviceAccountHandler.RemoveRole)
+}
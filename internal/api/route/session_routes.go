@@ -0,0 +1,32 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+func SetupSessionRoutes(router *gin.RouterGroup, sessionHandler *handler.SessionHandler, tokens *auth.TokenManager, userRoles repository.UserRoleRepository, limits ...gin.HandlerFunc) {
+	router.Group("/auth", limits...).POST("/refresh", sessionHandler.Refresh)
+	router.POST("/auth/logout", middleware.Authenticate(tokens), sessionHandler.Logout)
+
+	mine := router.Group("/users/me/sessions", middleware.Authenticate(tokens))
+	mine.GET("", sessionHandler.List)
+	mine.DELETE("", sessionHandler.RevokeOthers)
+	mine.DELETE("/:id", sessionHandler.Revoke)
+
+	router.POST("/users/:id/logout",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "user:logout"),
+		sessionHandler.ForceLogout,
+	)
+
+	policy := router.Group("/sessions/policy",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "sessions:manage"),
+	)
+	policy.GET("", sessionHandler.GetPolicy)
+	policy.PUT("", sessionHandler.UpdatePolicy)
+}
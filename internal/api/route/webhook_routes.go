@@ -0,0 +1,25 @@
+package route
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/middleware"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+func SetupWebhookRoutes(router *gin.RouterGroup, webhookHandler *handler.WebhookHandler, tokens *auth.TokenManager, userRoles repository.UserRoleRepository) {
+	webhooks := router.Group("/webhooks",
+		middleware.Authenticate(tokens),
+		middleware.RequirePermission(userRoles, "webhooks:manage"),
+	)
+
+	webhooks.POST("", webhookHandler.Create)
+	webhooks.GET("", webhookHandler.List)
+	webhooks.GET("/:id", webhookHandler.Get)
+	webhooks.PUT("/:id", webhookHandler.Update)
+	webhooks.DELETE("/:id", webhookHandler.Delete)
+	webhooks.POST("/:id/secret", webhookHandler.RotateSecret)
+	webhooks.GET("/:id/deliveries", webhookHandler.Deliveries)
+	webhooks.POST("/:id/test", webhookHandler.Test)
+}
@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"reflect"
+)
+
+const (
+	ActionUserCreated            = "user.created"
+	ActionUserUpdated            = "user.updated"
+	ActionUserDeleted            = "user.deleted"
+	ActionRoleCreated            = "role.created"
+	ActionRoleUpdated            = "role.updated"
+	ActionRoleDeleted            = "role.deleted"
+	ActionRolePermissionsGranted = "role.permissions_granted"
+	ActionRolePermissionsRevoked = "role.permissions_revoked"
+	ActionPermissionCreated      = "permission.created"
+	ActionPermissionUpdated      = "permission.updated"
+	ActionPermissionDeleted      = "permission.deleted"
+	ActionRoleAssigned           = "role.assigned"
+	ActionRoleUnassigned         = "role.unassigned"
+	ActionWebhookCreated         = "webhook.created"
+	ActionWebhookUpdated         = "webhook.updated"
+	ActionWebhookDeleted         = "webhook.deleted"
+	ActionWebhookDisabled        = "webhook.disabled"
+	ActionMemberAdded            = "organization.member_added"
+	ActionMemberUpdated          = "organization.member_updated"
+	ActionMemberRemoved          = "organization.member_removed"
+	ActionSCIMTokenCreated       = "scim_token.created"
+	ActionSCIMTokenRevoked       = "scim_token.revoked"
+	ActionMFAEnabled             = "mfa.enabled"
+	ActionMFADisabled            = "mfa.disabled"
+	ActionMFARecoveryCodeUsed    = "mfa.recovery_code_used"
+	ActionMFARecoveryCodesReset  = "mfa.recovery_codes_regenerated"
+	ActionMFAPolicyUpdated       = "mfa_policy.updated"
+	ActionPasskeyRegistered      = "passkey.registered"
+	ActionPasskeyDeleted         = "passkey.deleted"
+	ActionUserEmailVerified      = "user.email_verified"
+	ActionUserPasswordChanged    = "user.password_changed"
+	ActionUserLocked             = "user.locked"
+	ActionUserUnlocked           = "user.unlocked"
+	ActionIPAddressLocked        = "ip_address.locked"
+	ActionSessionRevoked         = "session.revoked"
+	ActionSessionPolicyUpdated   = "session_policy.updated"
+	ActionOAuthClientCreated     = "oauth_client.created"
+	ActionOAuthClientUpdated     = "oauth_client.updated"
+	ActionOAuthClientDeleted     = "oauth_client.deleted"
+	ActionOAuthConsentGranted    = "oauth_consent.granted"
+	ActionOAuthConsentRevoked    = "oauth_consent.revoked"
+	ActionSigningKeyRotated      = "signing_key.rotated"
+	ActionSigningKeyCreated      = "signing_key.created"
+	ActionServiceAccountCreated  = "service_account.created"
+	ActionServiceAccountUpdated  = "service_account.updated"
+	ActionServiceAccountDeleted  = "service_account.deleted"
+	ActionServiceAccountRotated  = "service_account.secret_rotated"
+	ActionAPIKeyCreated          = "api_key.created"
+	ActionAPIKeyRevoked          = "api_key.revoked"
+	ActionTokenRevoked           = "token.revoked"
+	ActionIdentityLinked         = "identity.linked"
+	ActionIdentityUnlinked       = "identity.unlinked"
+	ActionSAMLConnectionCreated  = "saml_connection.created"
+	ActionSAMLConnectionUpdated  = "saml_connection.updated"
+	ActionSAMLConnectionDeleted  = "saml_connection.deleted"
+	ActionLoginPolicyUpdated     = "login_policy.updated"
+)
+
+const (
+	TargetUser           = "user"
+	TargetRole           = "role"
+	TargetPermission     = "permission"
+	TargetWebhook        = "webhook"
+	TargetSCIMToken      = "scim_token"
+	TargetOrganization   = "organization"
+	TargetPasskey        = "passkey"
+	TargetIPAddress      = "ip_address"
+	TargetSession        = "session"
+	TargetOAuthClient    = "oauth_client"
+	TargetSigningKey     = "signing_key"
+	TargetServiceAccount = "service_account"
+	TargetAPIKey         = "api_key"
+	TargetToken          = "token"
+	TargetIdentity       = "identity"
+)
+
+// Actor identifies who performed a mutation and from where. It travels in
+// the request context from the HTTP layer down to the repositories.
+type Actor struct {
+	UserID    *uuid.UUID
+	IPAddress string
+	UserAgent string
+}
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
+
+type FieldChange struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// Diff returns the fields that differ between the JSON representations of
+// before and after. Either side may be nil for creations and deletions.
+// Fields hidden from JSON, such as password hashes, are never included.
+func Diff(before, after interface{}) (json.RawMessage, error) {
+	oldFields, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]FieldChange)
+	for name, oldValue := range oldFields {
+		newValue, ok := newFields[name]
+		if !ok || !reflect.DeepEqual(oldValue, newValue) {
+			changes[name] = FieldChange{Old: oldValue, New: newValue}
+		}
+	}
+	for name, newValue := range newFields {
+		if _, ok := oldFields[name]; !ok {
+			changes[name] = FieldChange{New: newValue}
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
+
+func fields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit state: %w", err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode audit state: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"user-management/internal/models"
+)
+
+func TestDiff(t *testing.T) {
+	before := &models.User{ID: uuid.New(), Email: "a@example.com", Password: "hash", FirstName: "Ali"}
+	after := *before
+	after.FirstName = "Reza"
+	after.Password = "other-hash"
+
+	raw, err := Diff(before, &after)
+	require.NoError(t, err)
+
+	var changes map[string]FieldChange
+	require.NoError(t, json.Unmarshal(raw, &changes))
+	require.Equal(t, map[string]FieldChange{"first_name": {Old: "Ali", New: "Reza"}}, changes)
+
+	raw, err = Diff(nil, before)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, &changes))
+	require.Equal(t, "a@example.com", changes["email"].New)
+	require.NotContains(t, changes, "password")
+
+	raw, err = Diff(before, before)
+	require.NoError(t, err)
+	require.Nil(t, raw)
+
+	var nilUser *models.User
+	raw, err = Diff(nilUser, nilUser)
+	require.NoError(t, err)
+	require.Nil(t, raw)
+}
+
+func TestActorContext(t *testing.T) {
+	require.Equal(t, Actor{}, ActorFrom(context.Background()))
+
+	id := uuid.New()
+	ctx := WithActor(context.Background(), Actor{UserID: &id, IPAddress: "10.0.0.1"})
+	require.Equal(t, id, *ActorFrom(ctx).UserID)
+	require.Equal(t, "10.0.0.1", ActorFrom(ctx).IPAddress)
+}
@@ -0,0 +1,235 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"strconv"
+	"time"
+	"user-management/internal/models"
+)
+
+// GlobalChain is the chain key of events that are not scoped to an
+// organization.
+const GlobalChain = "global"
+
+// ChainKey returns the key of the hash chain an event belongs to. Each
+// organization has its own chain.
+func ChainKey(organizationID *uuid.UUID) string {
+	if organizationID == nil {
+		return GlobalChain
+	}
+	return organizationID.String()
+}
+
+// NormalizeTime returns t as stored by Postgres, so that hashes computed
+// before insertion match hashes computed from the stored row.
+func NormalizeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// CanonicalJSON re-encodes raw with sorted keys and no insignificant
+// whitespace. JSONB does not preserve the original text, so hashes are
+// always computed over the canonical form.
+func CanonicalJSON(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode audit changes: %w", err)
+	}
+
+	return json.Marshal(value)
+}
+
+// ComputeHash returns the hex encoded SHA-256 of the event content chained to
+// prevHash. The ID is not part of the hash since it is assigned on insert.
+func ComputeHash(prevHash string, event *models.AuditEvent) (string, error) {
+	changes, err := CanonicalJSON(event.Changes)
+	if err != nil {
+		return "", err
+	}
+
+	content := struct {
+		PrevHash       string          `json:"prev_hash"`
+		OrganizationID string          `json:"organization_id"`
+		ActorID        string          `json:"actor_id"`
+		Action         string          `json:"action"`
+		TargetType     string          `json:"target_type"`
+		TargetID       string          `json:"target_id"`
+		Changes        json.RawMessage `json:"changes,omitempty"`
+		IPAddress      string          `json:"ip_address"`
+		UserAgent      string          `json:"user_agent"`
+		CreatedAt      string          `json:"created_at"`
+	}{
+		PrevHash:       prevHash,
+		OrganizationID: ChainKey(event.OrganizationID),
+		ActorID:        ChainKey(event.ActorID),
+		Action:         event.Action,
+		TargetType:     event.TargetType,
+		TargetID:       event.TargetID,
+		Changes:        changes,
+		IPAddress:      event.IPAddress,
+		UserAgent:      event.UserAgent,
+		CreatedAt:      NormalizeTime(event.CreatedAt).Format(time.RFC3339Nano),
+	}
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Signer signs audit checkpoints with an Ed25519 key.
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner creates a signer from a base64 encoded 32 byte Ed25519 seed.
+func NewSigner(seed string) (*Signer, error) {
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint signing key: %w", err)
+	}
+	if len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("checkpoint signing key must be %d bytes", ed25519.SeedSize)
+	}
+	return &Signer{key: ed25519.NewKeyFromSeed(raw)}, nil
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+func (s *Signer) Sign(checkpoint *models.AuditCheckpoint) {
+	checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, checkpointMessage(checkpoint)))
+}
+
+func VerifyCheckpoint(publicKey ed25519.PublicKey, checkpoint *models.AuditCheckpoint) bool {
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(publicKey, checkpointMessage(checkpoint), signature)
+}
+
+func checkpointMessage(checkpoint *models.AuditCheckpoint) []byte {
+	return []byte(ChainKey(checkpoint.OrganizationID) + "\n" +
+		strconv.FormatInt(checkpoint.EventID, 10) + "\n" +
+		checkpoint.Hash)
+}
+
+// BrokenLinkError describes the first point at which a chain fails to verify.
+type BrokenLinkError struct {
+	Chain   string
+	EventID int64
+	Reason  string
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("chain %s broken at event %d: %s", e.Chain, e.EventID, e.Reason)
+}
+
+// ChainVerifier checks events fed to it in ascending ID order against their
+// stored hashes and predecessors, and checks checkpoints against the events
+// they reference.
+type ChainVerifier struct {
+	publicKey   ed25519.PublicKey
+	heads       map[string]string
+	checkpoints map[int64][]models.AuditCheckpoint
+	verified    int
+}
+
+func NewChainVerifier(publicKey ed25519.PublicKey, checkpoints []models.AuditCheckpoint) (*ChainVerifier, error) {
+	v := &ChainVerifier{
+		publicKey:   publicKey,
+		heads:       make(map[string]string),
+		checkpoints: make(map[int64][]models.AuditCheckpoint),
+	}
+
+	for _, checkpoint := range checkpoints {
+		if !VerifyCheckpoint(publicKey, &checkpoint) {
+			return nil, &BrokenLinkError{
+				Chain:   ChainKey(checkpoint.OrganizationID),
+				EventID: checkpoint.EventID,
+				Reason:  fmt.Sprintf("checkpoint %d has an invalid signature", checkpoint.ID),
+			}
+		}
+		v.checkpoints[checkpoint.EventID] = append(v.checkpoints[checkpoint.EventID], checkpoint)
+	}
+
+	return v, nil
+}
+
+func (v *ChainVerifier) Add(event *models.AuditEvent) error {
+	chain := ChainKey(event.OrganizationID)
+	broken := func(reason string) error {
+		return &BrokenLinkError{Chain: chain, EventID: event.ID, Reason: reason}
+	}
+
+	// Events recorded before hash chaining was introduced carry no hash and
+	// can only appear before the first chained event.
+	if event.Hash == "" && event.PrevHash == "" && v.heads[chain] == "" {
+		return nil
+	}
+
+	if event.PrevHash != v.heads[chain] {
+		return broken("previous hash does not match the preceding event; an event was removed or reordered")
+	}
+
+	hash, err := ComputeHash(event.PrevHash, event)
+	if err != nil {
+		return broken(err.Error())
+	}
+	if hash != event.Hash {
+		return broken("content hash mismatch; the event was altered")
+	}
+
+	for _, checkpoint := range v.checkpoints[event.ID] {
+		if ChainKey(checkpoint.OrganizationID) != chain || checkpoint.Hash != event.Hash {
+			return broken(fmt.Sprintf("does not match checkpoint %d", checkpoint.ID))
+		}
+	}
+	delete(v.checkpoints, event.ID)
+
+	v.heads[chain] = hash
+	v.verified++
+	return nil
+}
+
+// Finish reports checkpoints that reference events which were never seen,
+// which indicates the tail of a chain was removed.
+func (v *ChainVerifier) Finish() error {
+	var first int64
+	for eventID := range v.checkpoints {
+		if first == 0 || eventID < first {
+			first = eventID
+		}
+	}
+	if first == 0 {
+		return nil
+	}
+
+	checkpoint := v.checkpoints[first][0]
+	return &BrokenLinkError{
+		Chain:   ChainKey(checkpoint.OrganizationID),
+		EventID: first,
+		Reason:  fmt.Sprintf("event referenced by checkpoint %d is missing", checkpoint.ID),
+	}
+}
+
+func (v *ChainVerifier) Verified() int {
+	return v.verified
+}
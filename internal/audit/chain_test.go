@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"user-management/internal/models"
+)
+
+func buildChain(t *testing.T, orgs ...*uuid.UUID) []models.AuditEvent {
+	heads := map[string]string{}
+	events := make([]models.AuditEvent, len(orgs))
+	for i, org := range orgs {
+		event := models.AuditEvent{
+			ID:             int64(i + 1),
+			OrganizationID: org,
+			Action:         ActionUserUpdated,
+			TargetType:     TargetUser,
+			TargetID:       uuid.NewString(),
+			Changes:        json.RawMessage(`{"first_name":{"new":"Reza","old":"Ali"}}`),
+			CreatedAt:      time.Now(),
+		}
+		event.PrevHash = heads[ChainKey(org)]
+		hash, err := ComputeHash(event.PrevHash, &event)
+		require.NoError(t, err)
+		event.Hash = hash
+		heads[ChainKey(org)] = hash
+		events[i] = event
+	}
+	return events
+}
+
+func verifyAll(t *testing.T, signer *Signer, events []models.AuditEvent, checkpoints ...models.AuditCheckpoint) error {
+	verifier, err := NewChainVerifier(signer.PublicKey(), checkpoints)
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		if err := verifier.Add(&events[i]); err != nil {
+			return err
+		}
+	}
+	return verifier.Finish()
+}
+
+func newTestSigner(t *testing.T) *Signer {
+	signer, err := NewSigner(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+	return signer
+}
+
+func TestHashIgnoresJSONBFormatting(t *testing.T) {
+	event := &models.AuditEvent{Action: ActionRoleCreated, Changes: json.RawMessage(`{"b":1,"a":{"new":"x"}}`)}
+	stored := *event
+	stored.Changes = json.RawMessage(`{"a": {"new": "x"}, "b": 1}`)
+
+	first, err := ComputeHash("", event)
+	require.NoError(t, err)
+	second, err := ComputeHash("", &stored)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+}
+
+func TestChainVerifier(t *testing.T) {
+	signer := newTestSigner(t)
+	orgA, orgB := uuid.New(), uuid.New()
+
+	events := buildChain(t, &orgA, &orgB, &orgA, nil, &orgA)
+	checkpoint := models.AuditCheckpoint{ID: 1, OrganizationID: &orgA, EventID: 3, Hash: events[2].Hash}
+	signer.Sign(&checkpoint)
+	require.NoError(t, verifyAll(t, signer, events, checkpoint))
+
+	var broken *BrokenLinkError
+
+	altered := buildChain(t, &orgA, &orgA, &orgA)
+	altered[1].TargetID = "someone-else"
+	require.True(t, errors.As(verifyAll(t, signer, altered), &broken))
+	require.Equal(t, int64(2), broken.EventID)
+
+	removed := buildChain(t, &orgA, &orgA, &orgA)
+	removed = append(removed[:1], removed[2:]...)
+	require.True(t, errors.As(verifyAll(t, signer, removed), &broken))
+	require.Equal(t, int64(3), broken.EventID)
+
+	require.True(t, errors.As(verifyAll(t, signer, events[:2], checkpoint), &broken))
+	require.Equal(t, int64(3), broken.EventID)
+
+	forged := checkpoint
+	forged.Hash = events[0].Hash
+	require.True(t, errors.As(verifyAll(t, signer, events, forged), &broken))
+}
+
+func TestLegacyEventsBeforeChain(t *testing.T) {
+	signer := newTestSigner(t)
+	org := uuid.New()
+	legacy := models.AuditEvent{ID: 1, OrganizationID: &org, Action: ActionUserCreated}
+	events := append([]models.AuditEvent{legacy}, buildChain(t, &org)...)
+	events[1].ID = 2
+	require.NoError(t, verifyAll(t, signer, events))
+
+	events = append(events, models.AuditEvent{ID: 3, OrganizationID: &org, Action: ActionUserCreated})
+	require.Error(t, verifyAll(t, signer, events))
+}
@@ -0,0 +1,425 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"time"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrOrganizationPolicy is returned for logins that do not meet the
+// policies of the organization they are used in.
+var ErrOrganizationPolicy = errors.New("login does not meet the organization's policy")
+
+// ErrNotOrganizationMember is returned for logins used in an organization
+// that the user is not a member of.
+var ErrNotOrganizationMember = errors.New("not a member of the organization")
+
+// Authentication method references of RFC 8176 recorded in the amr claim.
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+	// AMRHardwareKey is recorded for passkey logins.
+	AMRHardwareKey = "hwk"
+	// AMRFederated is recorded for logins with an upstream identity
+	// provider. RFC 8176 registers no value for it.
+	AMRFederated = "fed"
+	// AMREmail is recorded for logins with a code or link mailed to the
+	// user. RFC 8176 registers no value for it either.
+	AMREmail = "email"
+)
+
+// Token uses of partially authenticated logins. Access tokens have no
+// token_use claim; tokens with one are rejected by ParseAccessToken.
+const (
+	// TokenUseMFA is issued after the password when a second factor is due.
+	TokenUseMFA = "mfa"
+	// TokenUseMFAEnrollment is issued after the password when the
+	// organization requires MFA that the user has not enrolled in yet. It
+	// only grants access to enrollment.
+	TokenUseMFAEnrollment = "mfa_enrollment"
+)
+
+// Principal claims of tokens that do not come from a user's login.
+// Tokens of logins have no principal claim.
+const (
+	// PrincipalServiceAccount is set on tokens issued to service accounts.
+	PrincipalServiceAccount = "service_account"
+	// PrincipalAPIKey is set on the claims of API keys, whose ID is the ID
+	// of the key.
+	PrincipalAPIKey = "api_key"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs.
+const APIKeyPrefix = "pat_"
+
+// HeaderAPIKey carries an API key as an alternative to a bearer token.
+const HeaderAPIKey = "X-API-Key"
+
+// IsAPIKey reports whether token is an API key rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+type Claims struct {
+	jwt.RegisteredClaims
+	Email          string   `json:"email,omitempty"`
+	OrganizationID string   `json:"org_id,omitempty"`
+	AMR            []string `json:"amr,omitempty"`
+	TokenUse       string   `json:"token_use,omitempty"`
+	// SessionID is the session an access token was refreshed from. Tokens
+	// with one stop being accepted once the session is revoked.
+	SessionID string `json:"sid,omitempty"`
+	Principal string `json:"principal,omitempty"`
+	// ClientID is the OAuth client a token was issued to. Such tokens act
+	// for the user but are not the user's own login.
+	ClientID string `json:"client_id,omitempty"`
+	// Scope lists the permissions a token is limited to, space separated.
+	// Tokens without one carry every permission of their subject's roles.
+	Scope string `json:"scope,omitempty"`
+}
+
+// UserID returns the subject of the token as a UUID.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// AllowsPermission reports whether the scope of the token admits
+// permission. The subject must still hold it through its roles.
+func (c *Claims) AllowsPermission(permission string) bool {
+	return c.Scope == "" || slices.Contains(strings.Fields(c.Scope), permission)
+}
+
+// SessionValidator checks that the session of an access token is still
+// active, returning an error matching ErrInvalidToken when it is not.
+type SessionValidator interface {
+	ValidateSession(ctx context.Context, claims *Claims) error
+}
+
+// APIKeyValidator checks an API key and returns the claims it stands for,
+// returning an error matching ErrInvalidToken when it is not accepted.
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key string) (*Claims, error)
+}
+
+// KeySource provides the asymmetric keys tokens are signed with.
+type KeySource interface {
+	SigningKey(ctx context.Context) (*oidc.SigningKey, error)
+	PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error)
+}
+
+// RevocationList tells whether an access token, by its ID, has been
+// revoked before it expires.
+type RevocationList interface {
+	IsRevoked(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+// OrganizationPolicy checks a login against the membership and policies of
+// an organization, returning an error matching ErrNotOrganizationMember or
+// ErrOrganizationPolicy when the organization does not accept it.
+type OrganizationPolicy interface {
+	CheckOrganizationPolicy(ctx context.Context, claims *Claims, organizationID uuid.UUID) error
+}
+
+// TokenManager issues and verifies the tokens of logins and service
+// accounts. Tokens are signed with the keys of a KeySource once one is set,
+// and with the HMAC secret of the configuration before.
+type TokenManager struct {
+	secret         []byte
+	issuer         string
+	accessTokenTTL time.Duration
+	mfaTokenTTL    time.Duration
+	sessions       SessionValidator
+	apiKeys        APIKeyValidator
+	keys           KeySource
+	revoked        RevocationList
+	organizations  OrganizationPolicy
+}
+
+const defaultMFATokenTTL = 5 * time.Minute
+
+func NewTokenManager(config config.JWTConfig) *TokenManager {
+	mfaTokenTTL := config.MFATokenTTL
+	if mfaTokenTTL <= 0 {
+		mfaTokenTTL = defaultMFATokenTTL
+	}
+
+	return &TokenManager{
+		secret:         []byte(config.Secret),
+		issuer:         config.Issuer,
+		accessTokenTTL: config.AccessTokenTTL,
+		mfaTokenTTL:    mfaTokenTTL,
+	}
+}
+
+// SetSessionValidator makes ValidateAccessToken check the sessions of
+// tokens with v. It must be called before the manager is used.
+func (m *TokenManager) SetSessionValidator(v SessionValidator) {
+	m.sessions = v
+}
+
+// SetKeySource makes the manager sign tokens with the keys of keys and
+// accept only tokens signed with them. It must be called before the
+// manager is used.
+func (m *TokenManager) SetKeySource(keys KeySource) {
+	m.keys = keys
+}
+
+// SetAPIKeyValidator makes ValidateAccessToken accept API keys, checked
+// by v. It must be called before the manager is used.
+func (m *TokenManager) SetAPIKeyValidator(v APIKeyValidator) {
+	m.apiKeys = v
+}
+
+// SetRevocationList makes ValidateAccessToken reject the tokens listed in
+// revoked. It must be called before the manager is used.
+func (m *TokenManager) SetRevocationList(revoked RevocationList) {
+	m.revoked = revoked
+}
+
+// SetOrganizationPolicy makes CheckOrganization check logins with p. It
+// must be called before the manager is used.
+func (m *TokenManager) SetOrganizationPolicy(p OrganizationPolicy) {
+	m.organizations = p
+}
+
+// CheckOrganization checks that the token of claims may be used in
+// organizationID. Logins scoped to an organization were checked against its
+// membership and policies when they were issued; logins that are not, and
+// select an organization with each request, are checked with the
+// OrganizationPolicy every time. Tokens of service accounts and API keys are not logins and
+// are not checked.
+func (m *TokenManager) CheckOrganization(ctx context.Context, claims *Claims, organizationID uuid.UUID) error {
+	if m.organizations == nil || claims.OrganizationID != "" || claims.Principal != "" {
+		return nil
+	}
+	return m.organizations.CheckOrganizationPolicy(ctx, claims, organizationID)
+}
+
+func (m *TokenManager) Issuer() string {
+	return m.issuer
+}
+
+func (m *TokenManager) AccessTokenTTL() time.Duration {
+	return m.accessTokenTTL
+}
+
+// GenerateAccessToken issues an access token for user. organizationID may be
+// uuid.Nil for tokens that are not scoped to an organization. amr lists the
+// methods the user authenticated with.
+func (m *TokenManager) GenerateAccessToken(user *models.User, organizationID uuid.UUID, amr ...string) (string, error) {
+	return m.generate(user, organizationID, amr, "", uuid.Nil, m.accessTokenTTL)
+}
+
+// GenerateSessionAccessToken is GenerateAccessToken for a token bound to a
+// session.
+func (m *TokenManager) GenerateSessionAccessToken(user *models.User, organizationID, sessionID uuid.UUID, amr ...string) (string, error) {
+	return m.generate(user, organizationID, amr, "", sessionID, m.accessTokenTTL)
+}
+
+// GenerateClientAccessToken issues the access token of an OAuth grant of
+// client for user, in the client's organization. sessionID is uuid.Nil for
+// grants without a session.
+func (m *TokenManager) GenerateClientAccessToken(user *models.User, client *models.OAuthClient, sessionID uuid.UUID, amr ...string) (string, error) {
+	claims := m.newClaims(user, client.OrganizationID, amr, "", sessionID, m.accessTokenTTL)
+	claims.ClientID = client.ID.String()
+	return m.sign(claims)
+}
+
+// GenerateMFAToken issues the short-lived token of a login that still needs
+// a second factor (TokenUseMFA) or MFA enrollment (TokenUseMFAEnrollment).
+// amr lists the methods of the first factor, the password when empty.
+func (m *TokenManager) GenerateMFAToken(user *models.User, organizationID uuid.UUID, use string, amr ...string) (string, error) {
+	if len(amr) == 0 {
+		amr = []string{AMRPassword}
+	}
+	return m.generate(user, organizationID, amr, use, uuid.Nil, m.mfaTokenTTL)
+}
+
+// GenerateServiceAccountToken issues the access token of the
+// client_credentials grant, bound to the organization of the account. When
+// scopes is not empty, the token is limited to those permissions.
+func (m *TokenManager) GenerateServiceAccountToken(account *models.ServiceAccount, scopes []string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return m.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   account.ID.String(),
+			Issuer:    m.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		OrganizationID: account.OrganizationID.String(),
+		Principal:      PrincipalServiceAccount,
+		Scope:          strings.Join(scopes, " "),
+	})
+}
+
+func (m *TokenManager) generate(user *models.User, organizationID uuid.UUID, amr []string, use string, sessionID uuid.UUID, ttl time.Duration) (string, error) {
+	return m.sign(m.newClaims(user, organizationID, amr, use, sessionID, ttl))
+}
+
+func (m *TokenManager) newClaims(user *models.User, organizationID uuid.UUID, amr []string, use string, sessionID uuid.UUID, ttl time.Duration) Claims {
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   user.ID.String(),
+			Issuer:    m.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Email:    user.Email,
+		AMR:      amr,
+		TokenUse: use,
+	}
+	if organizationID != uuid.Nil {
+		claims.OrganizationID = organizationID.String()
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+	return claims
+}
+
+// sign signs claims. Keys are cached by the key source, so signing rarely
+// waits for the database and has no request context to pass.
+func (m *TokenManager) sign(claims Claims) (string, error) {
+	if m.keys != nil {
+		key, err := m.keys.SigningKey(context.Background())
+		if err != nil {
+			return "", err
+		}
+		return key.Sign(claims, "")
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return token, nil
+}
+
+func (m *TokenManager) ParseAccessToken(tokenString string) (*Claims, error) {
+	return m.parseAccessToken(context.Background(), tokenString)
+}
+
+func (m *TokenManager) parseAccessToken(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := m.parse(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenUse != "" {
+		return nil, fmt.Errorf("%w: not an access token", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// ValidateAccessToken is ParseAccessToken that also rejects revoked tokens
+// and tokens whose session has been revoked. API keys are accepted too, once an
+// APIKeyValidator is set.
+func (m *TokenManager) ValidateAccessToken(ctx context.Context, tokenString string) (*Claims, error) {
+	if IsAPIKey(tokenString) {
+		if m.apiKeys == nil {
+			return nil, fmt.Errorf("%w: api keys are not accepted", ErrInvalidToken)
+		}
+		return m.apiKeys.ValidateAPIKey(ctx, tokenString)
+	}
+
+	claims, err := m.parseAccessToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.CheckRevoked(ctx, claims.ID); err != nil {
+		return nil, err
+	}
+	if claims.SessionID != "" && m.sessions != nil {
+		if err := m.sessions.ValidateSession(ctx, claims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// CheckRevoked returns an error matching ErrInvalidToken when the token
+// with the given ID is on the revocation list.
+func (m *TokenManager) CheckRevoked(ctx context.Context, tokenID string) error {
+	if m.revoked == nil {
+		return nil
+	}
+	id, err := uuid.Parse(tokenID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid token ID", ErrInvalidToken)
+	}
+	revoked, err := m.revoked.IsRevoked(ctx, id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("%w: token revoked", ErrInvalidToken)
+	}
+	return nil
+}
+
+// ParseMFAToken parses a token issued by GenerateMFAToken for the given use.
+func (m *TokenManager) ParseMFAToken(tokenString, use string) (*Claims, error) {
+	claims, err := m.parse(context.Background(), tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenUse != use {
+		return nil, fmt.Errorf("%w: unexpected token use", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// parse verifies a token issued by the manager. The keys may be shared
+// with the OpenID Connect provider, whose tokens carry a type header or an
+// audience; those are rejected, so that they cannot stand in for a login.
+func (m *TokenManager) parse(ctx context.Context, tokenString string) (*Claims, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	}
+	methods := []string{jwt.SigningMethodHS256.Alg()}
+	if m.keys != nil {
+		keyFunc = func(token *jwt.Token) (interface{}, error) {
+			keyID, _ := token.Header["kid"].(string)
+			return m.keys.PublicKey(ctx, keyID)
+		}
+		methods = oidc.SigningAlgorithms
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc,
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if typ, ok := token.Header["typ"]; ok && typ != "JWT" {
+		return nil, fmt.Errorf("%w: unexpected token type", ErrInvalidToken)
+	}
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	if _, err := claims.UserID(); err != nil {
+		return nil, fmt.Errorf("%w: invalid subject", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"user-management/internal/changefeed"
+	"user-management/internal/repository"
+)
+
+// NewChangeHandler returns a change feed handler that keeps the permission
+// cache coherent with writes made by other replicas or directly in the
+// database. roles and userRoles should be the undecorated repositories.
+func NewChangeHandler(cache *PermissionCache, roles repository.RoleRepository, userRoles repository.UserRoleRepository) changefeed.Handler {
+	return func(ctx context.Context, change changefeed.Change) {
+		var err error
+
+		switch change.Table {
+		case "user_roles", "user_organizations":
+			if change.UserID != nil && change.OrganizationID != nil {
+				err = cache.Invalidate(ctx, *change.OrganizationID, *change.UserID)
+			}
+		case "role_permissions":
+			if change.RoleID != nil {
+				err = invalidateRoleHolders(ctx, cache, roles, userRoles, change)
+			}
+		case "users":
+			if change.ID != nil && change.Operation != changefeed.OperationInsert {
+				err = cache.InvalidateUser(ctx, *change.ID)
+			}
+		}
+
+		if change.Operation == changefeed.OperationResync {
+			err = cache.Flush(ctx)
+		}
+
+		if err != nil {
+			log.Printf("failed to apply %s %s change to permission cache: %v\n", change.Operation, change.Table, err)
+		}
+	}
+}
+
+func invalidateRoleHolders(ctx context.Context, cache *PermissionCache, roles repository.RoleRepository, userRoles repository.UserRoleRepository, change changefeed.Change) error {
+	role, err := roles.GetByID(ctx, *change.RoleID)
+	if err != nil {
+		// The role itself was deleted; its user_roles rows cascade and are
+		// delivered as separate changes.
+		return nil
+	}
+
+	holders, err := userRoles.GetRoleHolderIDs(ctx, role.ID)
+	if err != nil {
+		return err
+	}
+	return cache.Invalidate(ctx, role.OrganizationID, holders...)
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"time"
+	"user-management/internal/models"
+)
+
+const permissionKeyPrefix = "authz:permissions:"
+
+// PermissionCache stores the effective permission set of a user within an
+// organization. Redis failures are reported to the caller, which is expected
+// to fall back to the database.
+type PermissionCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewPermissionCache(client *redis.Client, ttl time.Duration) *PermissionCache {
+	return &PermissionCache{client: client, ttl: ttl}
+}
+
+// Get returns the cached permissions and whether they were present.
+func (c *PermissionCache) Get(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, bool, error) {
+	data, err := c.client.Get(ctx, permissionKey(userID, organizationID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read permission cache: %w", err)
+	}
+
+	var permissions []models.Permission
+	if err := json.Unmarshal(data, &permissions); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached permissions: %w", err)
+	}
+
+	return permissions, true, nil
+}
+
+func (c *PermissionCache) Set(ctx context.Context, userID, organizationID uuid.UUID, permissions []models.Permission) error {
+	if permissions == nil {
+		permissions = []models.Permission{}
+	}
+
+	data, err := json.Marshal(permissions)
+	if err != nil {
+		return fmt.Errorf("failed to encode permissions: %w", err)
+	}
+
+	if err := c.client.Set(ctx, permissionKey(userID, organizationID), data, c.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to write permission cache: %w", err)
+	}
+
+	return nil
+}
+
+// Invalidate removes the cached entries for every given user in organizationID.
+func (c *PermissionCache) Invalidate(ctx context.Context, organizationID uuid.UUID, userIDs ...uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = permissionKey(userID, organizationID)
+	}
+
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to invalidate permission cache: %w", err)
+	}
+
+	return nil
+}
+
+// InvalidateUser removes the cached entries for userID in every organization.
+func (c *PermissionCache) InvalidateUser(ctx context.Context, userID uuid.UUID) error {
+	return c.deleteMatching(ctx, permissionKeyPrefix+userID.String()+":*")
+}
+
+// Flush removes every cached permission set.
+func (c *PermissionCache) Flush(ctx context.Context) error {
+	return c.deleteMatching(ctx, permissionKeyPrefix+"*")
+}
+
+func (c *PermissionCache) deleteMatching(ctx context.Context, pattern string) error {
+	iter := c.client.Scan(ctx, 0, pattern, 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan permission cache: %w", err)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to invalidate permission cache: %w", err)
+	}
+
+	return nil
+}
+
+func (c *PermissionCache) invalidate(ctx context.Context, organizationID uuid.UUID, userIDs ...uuid.UUID) {
+	if err := c.Invalidate(ctx, organizationID, userIDs...); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+func permissionKey(userID, organizationID uuid.UUID) string {
+	return permissionKeyPrefix + userID.String() + ":" + organizationID.String()
+}
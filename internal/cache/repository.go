@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type userRoleRepository struct {
+	repository.UserRoleRepository
+	cache *PermissionCache
+}
+
+// NewUserRoleRepository wraps next so that effective permission lookups are
+// served from cache, and role assignments invalidate the affected entry.
+func NewUserRoleRepository(next repository.UserRoleRepository, cache *PermissionCache) repository.UserRoleRepository {
+	return &userRoleRepository{UserRoleRepository: next, cache: cache}
+}
+
+func (r *userRoleRepository) AssignRole(ctx context.Context, userRole *models.UserRole) error {
+	if err := r.UserRoleRepository.AssignRole(ctx, userRole); err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, userRole.OrganizationID, userRole.UserID)
+	return nil
+}
+
+func (r *userRoleRepository) RemoveRole(ctx context.Context, userID, roleID, organizationID uuid.UUID) error {
+	if err := r.UserRoleRepository.RemoveRole(ctx, userID, roleID, organizationID); err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, organizationID, userID)
+	return nil
+}
+
+func (r *userRoleRepository) GetUserPermissions(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, error) {
+	permissions, ok, err := r.cache.Get(ctx, userID, organizationID)
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+	if ok {
+		return permissions, nil
+	}
+
+	permissions, err = r.UserRoleRepository.GetUserPermissions(ctx, userID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.cache.Set(ctx, userID, organizationID, permissions); err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	return permissions, nil
+}
+
+func (r *userRoleRepository) HasPermission(ctx context.Context, userID uuid.UUID, organizationID uuid.UUID, permission string) (bool, error) {
+	permissions, err := r.GetUserPermissions(ctx, userID, organizationID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range permissions {
+		if p.Name == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type roleRepository struct {
+	repository.RoleRepository
+	userRoles repository.UserRoleRepository
+	cache     *PermissionCache
+}
+
+// NewRoleRepository wraps next so that changes to a role's permissions, or
+// deleting the role, invalidate the cached permissions of every holder.
+// userRoles is used to find the holders and should be the undecorated
+// repository.
+func NewRoleRepository(next repository.RoleRepository, userRoles repository.UserRoleRepository, cache *PermissionCache) repository.RoleRepository {
+	return &roleRepository{RoleRepository: next, userRoles: userRoles, cache: cache}
+}
+
+func (r *roleRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	role, holders, err := r.holders(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.RoleRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, role.OrganizationID, holders...)
+	return nil
+}
+
+func (r *roleRepository) AssignPermissions(ctx context.Context, roleID uuid.UUID, permissionIDs []uuid.UUID) error {
+	if err := r.RoleRepository.AssignPermissions(ctx, roleID, permissionIDs); err != nil {
+		return err
+	}
+	return r.invalidateHolders(ctx, roleID)
+}
+
+func (r *roleRepository) RemovePermissions(ctx context.Context, roleID uuid.UUID, permissionIDs []uuid.UUID) error {
+	if err := r.RoleRepository.RemovePermissions(ctx, roleID, permissionIDs); err != nil {
+		return err
+	}
+	return r.invalidateHolders(ctx, roleID)
+}
+
+func (r *roleRepository) invalidateHolders(ctx context.Context, roleID uuid.UUID) error {
+	role, holders, err := r.holders(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, role.OrganizationID, holders...)
+	return nil
+}
+
+func (r *roleRepository) holders(ctx context.Context, roleID uuid.UUID) (*models.Role, []uuid.UUID, error) {
+	role, err := r.RoleRepository.GetByID(ctx, roleID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids, err := r.userRoles.GetRoleHolderIDs(ctx, roleID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find role holders: %w", err)
+	}
+	return role, ids, nil
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeUserRoleRepository struct {
+	repository.UserRoleRepository
+	permissions map[uuid.UUID][]models.Permission
+	holders     map[uuid.UUID][]uuid.UUID
+	calls       int
+}
+
+func (f *fakeUserRoleRepository) GetUserPermissions(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, error) {
+	f.calls++
+	return f.permissions[userID], nil
+}
+
+func (f *fakeUserRoleRepository) AssignRole(ctx context.Context, userRole *models.UserRole) error {
+	return nil
+}
+
+func (f *fakeUserRoleRepository) GetRoleHolderIDs(ctx context.Context, roleID uuid.UUID) ([]uuid.UUID, error) {
+	return f.holders[roleID], nil
+}
+
+type fakeRoleRepository struct {
+	repository.RoleRepository
+	roles map[uuid.UUID]*models.Role
+}
+
+func (f *fakeRoleRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Role, error) {
+	return f.roles[id], nil
+}
+
+func (f *fakeRoleRepository) AssignPermissions(ctx context.Context, roleID uuid.UUID, permissionIDs []uuid.UUID) error {
+	return nil
+}
+
+func (f *fakeRoleRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+type fixture struct {
+	redis     *miniredis.Miniredis
+	inner     *fakeUserRoleRepository
+	userRoles repository.UserRoleRepository
+	roles     repository.RoleRepository
+	userID    uuid.UUID
+	orgID     uuid.UUID
+	roleID    uuid.UUID
+}
+
+func newFixture(t *testing.T) *fixture {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	userID, orgID, roleID := uuid.New(), uuid.New(), uuid.New()
+	inner := &fakeUserRoleRepository{
+		permissions: map[uuid.UUID][]models.Permission{
+			userID: {{Name: "user:read", Resource: "user", Action: "read"}},
+		},
+		holders: map[uuid.UUID][]uuid.UUID{roleID: {userID}},
+	}
+	roles := &fakeRoleRepository{roles: map[uuid.UUID]*models.Role{
+		roleID: {ID: roleID, OrganizationID: orgID},
+	}}
+
+	permissionCache := NewPermissionCache(client, time.Minute)
+	return &fixture{
+		redis:     mr,
+		inner:     inner,
+		userRoles: NewUserRoleRepository(inner, permissionCache),
+		roles:     NewRoleRepository(roles, inner, permissionCache),
+		userID:    userID,
+		orgID:     orgID,
+		roleID:    roleID,
+	}
+}
+
+func TestGetUserPermissionsIsCached(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		allowed, err := f.userRoles.HasPermission(ctx, f.userID, f.orgID, "user:read")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	require.Equal(t, 1, f.inner.calls)
+	require.Equal(t, time.Minute, f.redis.TTL(permissionKey(f.userID, f.orgID)))
+}
+
+func TestMutationsInvalidate(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	otherOrg := uuid.New()
+
+	warm := func() {
+		_, err := f.userRoles.GetUserPermissions(ctx, f.userID, f.orgID)
+		require.NoError(t, err)
+		_, err = f.userRoles.GetUserPermissions(ctx, f.userID, otherOrg)
+		require.NoError(t, err)
+	}
+
+	warm()
+	require.NoError(t, f.userRoles.AssignRole(ctx, &models.UserRole{UserID: f.userID, RoleID: f.roleID, OrganizationID: f.orgID}))
+	require.False(t, f.redis.Exists(permissionKey(f.userID, f.orgID)))
+	require.True(t, f.redis.Exists(permissionKey(f.userID, otherOrg)))
+
+	warm()
+	require.NoError(t, f.roles.AssignPermissions(ctx, f.roleID, []uuid.UUID{uuid.New()}))
+	require.False(t, f.redis.Exists(permissionKey(f.userID, f.orgID)))
+
+	warm()
+	require.NoError(t, f.roles.Delete(ctx, f.roleID))
+	require.False(t, f.redis.Exists(permissionKey(f.userID, f.orgID)))
+	require.True(t, f.redis.Exists(permissionKey(f.userID, otherOrg)))
+}
+
+func TestFallsBackWhenRedisIsDown(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.redis.Close()
+
+	for i := 0; i < 2; i++ {
+		allowed, err := f.userRoles.HasPermission(ctx, f.userID, f.orgID, "user:read")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	require.Equal(t, 2, f.inner.calls)
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+const revokedTokenKeyPrefix = "revoked_tokens:"
+
+type revokedTokenRepository struct {
+	repository.RevokedTokenRepository
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRevokedTokenRepository wraps next so that the revocation list, which
+// is checked on every request with an access token, is looked up in Redis
+// and read from next while Redis is unavailable. Revocations go to next and
+// invalidate the cached answer; one made while Redis cannot be reached
+// takes up to ttl to apply.
+func NewRevokedTokenRepository(next repository.RevokedTokenRepository, client *redis.Client, ttl time.Duration) repository.RevokedTokenRepository {
+	return &revokedTokenRepository{RevokedTokenRepository: next, client: client, ttl: ttl}
+}
+
+func (r *revokedTokenRepository) IsRevoked(ctx context.Context, id uuid.UUID) (bool, error) {
+	revoked, cached, err := r.get(ctx, id)
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+	if cached {
+		return revoked, nil
+	}
+
+	revoked, err = r.RevokedTokenRepository.IsRevoked(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.client.Set(ctx, revokedTokenKey(id), revoked, r.ttl).Err(); err != nil {
+		log.Printf("failed to write revoked token cache: %v\n", err)
+	}
+	return revoked, nil
+}
+
+func (r *revokedTokenRepository) Revoke(ctx context.Context, token *models.RevokedToken) error {
+	if err := r.RevokedTokenRepository.Revoke(ctx, token); err != nil {
+		return err
+	}
+	if err := r.client.Del(ctx, revokedTokenKey(token.ID)).Err(); err != nil {
+		log.Printf("failed to invalidate revoked token cache: %v\n", err)
+	}
+	return nil
+}
+
+// get returns the cached answer for a token, and whether there is one.
+func (r *revokedTokenRepository) get(ctx context.Context, id uuid.UUID) (bool, bool, error) {
+	revoked, err := r.client.Get(ctx, revokedTokenKey(id)).Bool()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to read revoked token cache: %w", err)
+	}
+	return revoked, true, nil
+}
+
+func revokedTokenKey(id uuid.UUID) string {
+	return revokedTokenKeyPrefix + id.String()
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeRevokedTokenRepository struct {
+	repository.RevokedTokenRepository
+	revoked map[uuid.UUID]bool
+	calls   int
+}
+
+func (f *fakeRevokedTokenRepository) Revoke(ctx context.Context, token *models.RevokedToken) error {
+	f.revoked[token.ID] = true
+	return nil
+}
+
+func (f *fakeRevokedTokenRepository) IsRevoked(ctx context.Context, id uuid.UUID) (bool, error) {
+	f.calls++
+	return f.revoked[id], nil
+}
+
+func TestRevokedTokensAreCachedUntilRevoked(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	inner := &fakeRevokedTokenRepository{revoked: make(map[uuid.UUID]bool)}
+	tokens := NewRevokedTokenRepository(inner, client, time.Minute)
+	id := uuid.New()
+
+	for i := 0; i < 3; i++ {
+		revoked, err := tokens.IsRevoked(ctx, id)
+		require.NoError(t, err)
+		require.False(t, revoked)
+	}
+	require.Equal(t, 1, inner.calls)
+	require.Equal(t, time.Minute, mr.TTL(revokedTokenKey(id)))
+
+	require.NoError(t, tokens.Revoke(ctx, &models.RevokedToken{ID: id, ExpiresAt: time.Now().Add(time.Hour)}))
+	for i := 0; i < 3; i++ {
+		revoked, err := tokens.IsRevoked(ctx, id)
+		require.NoError(t, err)
+		require.True(t, revoked)
+	}
+	require.Equal(t, 2, inner.calls)
+
+	// The list is read from the repository while Redis is down.
+	mr.Close()
+	revoked, err := tokens.IsRevoked(ctx, id)
+	require.NoError(t, err)
+	require.True(t, revoked)
+	require.Equal(t, 3, inner.calls)
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+const sessionKeyPrefix = "sessions:"
+
+type sessionRepository struct {
+	repository.SessionRepository
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewSessionRepository wraps next so that sessions are looked up in Redis,
+// which every request with a session bound access token does, and read
+// from next while Redis is unavailable. Changes go to next and invalidate
+// the cached session; a revocation made while Redis cannot be reached takes
+// up to ttl to apply.
+func NewSessionRepository(next repository.SessionRepository, client *redis.Client, ttl time.Duration) repository.SessionRepository {
+	return &sessionRepository{SessionRepository: next, client: client, ttl: ttl}
+}
+
+func (r *sessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	session, err := r.get(ctx, id)
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+	if session != nil {
+		return session, nil
+	}
+
+	session, err = r.SessionRepository.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.set(ctx, session); err != nil {
+		log.Printf("%v\n", err)
+	}
+	return session, nil
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *models.Session) ([]uuid.UUID, error) {
+	evicted, err := r.SessionRepository.Create(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidate(ctx, evicted...)
+	return evicted, nil
+}
+
+func (r *sessionRepository) Rotate(ctx context.Context, session *models.Session, previousHash string) error {
+	if err := r.SessionRepository.Rotate(ctx, session, previousHash); err != nil {
+		return err
+	}
+	r.invalidate(ctx, session.ID)
+	return nil
+}
+
+func (r *sessionRepository) Touch(ctx context.Context, id uuid.UUID, lastSeenAt time.Time, ipAddress string) error {
+	if err := r.SessionRepository.Touch(ctx, id, lastSeenAt, ipAddress); err != nil {
+		return err
+	}
+	r.invalidate(ctx, id)
+	return nil
+}
+
+func (r *sessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	if err := r.SessionRepository.Revoke(ctx, userID, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, id)
+	return nil
+}
+
+func (r *sessionRepository) RevokeAll(ctx context.Context, userID, except uuid.UUID) ([]uuid.UUID, error) {
+	revoked, err := r.SessionRepository.RevokeAll(ctx, userID, except)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidate(ctx, revoked...)
+	return revoked, nil
+}
+
+func (r *sessionRepository) RevokeClient(ctx context.Context, clientID, userID uuid.UUID) ([]uuid.UUID, error) {
+	revoked, err := r.SessionRepository.RevokeClient(ctx, clientID, userID)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidate(ctx, revoked...)
+	return revoked, nil
+}
+
+// get returns the cached session, or nil when it is not cached.
+func (r *sessionRepository) get(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	data, err := r.client.Get(ctx, sessionKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read session cache: %w", err)
+	}
+
+	var session cachedSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode cached session: %w", err)
+	}
+	session.Session.RefreshTokenHash = session.RefreshTokenHash
+	return &session.Session, nil
+}
+
+func (r *sessionRepository) set(ctx context.Context, session *models.Session) error {
+	data, err := json.Marshal(cachedSession{Session: *session, RefreshTokenHash: session.RefreshTokenHash})
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	if err := r.client.Set(ctx, sessionKey(session.ID), data, r.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to write session cache: %w", err)
+	}
+	return nil
+}
+
+func (r *sessionRepository) invalidate(ctx context.Context, ids ...uuid.UUID) {
+	if len(ids) == 0 {
+		return
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionKey(id)
+	}
+	if err := r.client.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("failed to invalidate session cache: %v\n", err)
+	}
+}
+
+// cachedSession keeps the refresh token hash that models.Session leaves
+// out of its JSON.
+type cachedSession struct {
+	models.Session
+	RefreshTokenHash string `json:"refresh_token_hash"`
+}
+
+func sessionKey(id uuid.UUID) string {
+	return sessionKeyPrefix + id.String()
+}
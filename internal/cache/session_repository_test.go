@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeSessionRepository struct {
+	repository.SessionRepository
+	sessions map[uuid.UUID]*models.Session
+	calls    int
+}
+
+func (f *fakeSessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	f.calls++
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil, repository.ErrSessionNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (f *fakeSessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	now := time.Now()
+	f.sessions[id].RevokedAt = &now
+	return nil
+}
+
+func TestSessionsAreCachedUntilRevoked(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	session := &models.Session{ID: uuid.New(), UserID: uuid.New(), RefreshTokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+	inner := &fakeSessionRepository{sessions: map[uuid.UUID]*models.Session{session.ID: session}}
+	sessions := NewSessionRepository(inner, client, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		cached, err := sessions.GetByID(ctx, session.ID)
+		require.NoError(t, err)
+		require.Equal(t, "hash", cached.RefreshTokenHash)
+		require.True(t, cached.Active(time.Now()))
+	}
+	require.Equal(t, 1, inner.calls)
+	require.Equal(t, time.Minute, mr.TTL(sessionKey(session.ID)))
+
+	require.NoError(t, sessions.Revoke(ctx, session.UserID, session.ID))
+	revoked, err := sessions.GetByID(ctx, session.ID)
+	require.NoError(t, err)
+	require.False(t, revoked.Active(time.Now()))
+
+	// Lookups fall back to the repository while Redis is down.
+	mr.Close()
+	revoked, err = sessions.GetByID(ctx, session.ID)
+	require.NoError(t, err)
+	require.False(t, revoked.Active(time.Now()))
+
+	_, err = sessions.GetByID(ctx, uuid.New())
+	require.ErrorIs(t, err, repository.ErrSessionNotFound)
+}
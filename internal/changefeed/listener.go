@@ -0,0 +1,160 @@
+package changefeed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"sync"
+	"time"
+)
+
+const Channel = "user_management_changes"
+
+const (
+	OperationInsert = "INSERT"
+	OperationUpdate = "UPDATE"
+	OperationDelete = "DELETE"
+
+	// OperationResync is delivered after the listener reconnects. Changes
+	// made while it was disconnected are lost, so subscribers should drop
+	// everything they have cached.
+	OperationResync = "RESYNC"
+)
+
+// Change describes a single row change as published by the notify_change
+// trigger. Only the identifiers present on the changed table are set.
+type Change struct {
+	Table          string     `json:"table"`
+	Operation      string     `json:"op"`
+	ID             *uuid.UUID `json:"id,omitempty"`
+	UserID         *uuid.UUID `json:"user_id,omitempty"`
+	RoleID         *uuid.UUID `json:"role_id,omitempty"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+}
+
+type Handler func(ctx context.Context, change Change)
+
+// Listener holds a dedicated connection LISTENing on Channel and fans
+// notifications out to subscribers. It reconnects with exponential backoff
+// when the connection drops.
+type Listener struct {
+	connString string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu          sync.RWMutex
+	subscribers map[int]Handler
+	nextID      int
+}
+
+func NewListener(connString string) *Listener {
+	return &Listener{
+		connString:  connString,
+		minBackoff:  500 * time.Millisecond,
+		maxBackoff:  30 * time.Second,
+		subscribers: make(map[int]Handler),
+	}
+}
+
+// Subscribe registers handler for every change and returns a function that
+// removes it. Handlers run on the listener goroutine and must not block.
+func (l *Listener) Subscribe(handler Handler) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := l.nextID
+	l.nextID++
+	l.subscribers[id] = handler
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subscribers, id)
+	}
+}
+
+// Run listens until ctx is cancelled, reconnecting on failure.
+func (l *Listener) Run(ctx context.Context) error {
+	backoff := l.minBackoff
+	connected := false
+
+	for {
+		err := l.listen(ctx, func() {
+			if connected {
+				l.dispatch(ctx, Change{Operation: OperationResync})
+			}
+			connected = true
+			backoff = l.minBackoff
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Printf("change feed listener disconnected, retrying in %s: %v\n", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > l.maxBackoff {
+			backoff = l.maxBackoff
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context, onConnected func()) error {
+	conn, err := pgx.Connect(ctx, l.connString)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{Channel}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	onConnected()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+
+		change, err := ParseChange(notification.Payload)
+		if err != nil {
+			log.Printf("%v\n", err)
+			continue
+		}
+		l.dispatch(ctx, change)
+	}
+}
+
+func (l *Listener) dispatch(ctx context.Context, change Change) {
+	l.mu.RLock()
+	handlers := make([]Handler, 0, len(l.subscribers))
+	for _, handler := range l.subscribers {
+		handlers = append(handlers, handler)
+	}
+	l.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(ctx, change)
+	}
+}
+
+func ParseChange(payload string) (Change, error) {
+	var change Change
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return Change{}, fmt.Errorf("failed to decode change notification: %w", err)
+	}
+	if change.Table == "" || change.Operation == "" {
+		return Change{}, errors.New("change notification is missing table or operation")
+	}
+	return change, nil
+}
@@ -10,13 +10,37 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Postgres PostgresConfig `mapstructure:"postgres"`
+	Server          ServerConfig          `mapstructure:"server"`
+	Postgres        PostgresConfig        `mapstructure:"postgres"`
+	Redis           RedisConfig           `mapstructure:"redis"`
+	Cache           CacheConfig           `mapstructure:"cache"`
+	JWT             JWTConfig             `mapstructure:"jwt"`
+	Gateway         GatewayConfig         `mapstructure:"gateway"`
+	Audit           AuditConfig           `mapstructure:"audit"`
+	Outbox          OutboxConfig          `mapstructure:"outbox"`
+	Webhooks        WebhooksConfig        `mapstructure:"webhooks"`
+	WebAuthn        WebAuthnConfig        `mapstructure:"webauthn"`
+	Mail            MailConfig            `mapstructure:"mail"`
+	Account         AccountConfig         `mapstructure:"account"`
+	Passwordless    PasswordlessConfig    `mapstructure:"passwordless"`
+	RateLimit       RateLimitConfig       `mapstructure:"rate_limit"`
+	LoginProtection LoginProtectionConfig `mapstructure:"login_protection"`
+	Sessions        SessionConfig         `mapstructure:"sessions"`
+	OIDC            OIDCConfig            `mapstructure:"oidc"`
+	Federation      FederationConfig      `mapstructure:"federation"`
+	SAML            SAMLConfig            `mapstructure:"saml"`
+	ServiceAccounts ServiceAccountConfig  `mapstructure:"service_accounts"`
+	APIKeys         APIKeyConfig          `mapstructure:"api_keys"`
+	SigningKeys     SigningKeysConfig     `mapstructure:"signing_keys"`
 }
 
 type ServerConfig struct {
 	Port    string `mapstructure:"port"`
 	RunMode string `mapstructure:"run_mode"`
+	// TrustedProxies are the addresses and CIDR ranges of the proxies whose
+	// X-Forwarded-For header gives the client address. None are trusted by
+	// default.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
 type PostgresConfig struct {
@@ -33,6 +57,278 @@ type PostgresConfig struct {
 	HealthCheck time.Duration `mapstructure:"health_check"`
 }
 
+type RedisConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Password string `mapstructure:"password"`
+	DB       int    `mapstructure:"db"`
+}
+
+type CacheConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	PermissionTTL time.Duration `mapstructure:"permission_ttl"`
+	// SessionTTL also bounds how long a revocation made while Redis is
+	// unreachable takes to apply.
+	SessionTTL time.Duration `mapstructure:"session_ttl"`
+}
+
+type JWTConfig struct {
+	Secret         string        `mapstructure:"secret"`
+	Issuer         string        `mapstructure:"issuer"`
+	AccessTokenTTL time.Duration `mapstructure:"access_token_ttl"`
+	// MFATokenTTL bounds the time between the password and the second
+	// factor of a login.
+	MFATokenTTL time.Duration `mapstructure:"mfa_token_ttl"`
+}
+
+// GatewayConfig configures the Envoy ext_authz server and the HTTP
+// forward-auth endpoint used by reverse proxies.
+type GatewayConfig struct {
+	GRPCPort string              `mapstructure:"grpc_port"`
+	Rules    []GatewayRuleConfig `mapstructure:"rules"`
+}
+
+type GatewayRuleConfig struct {
+	Path       string   `mapstructure:"path"`
+	Methods    []string `mapstructure:"methods"`
+	Permission string   `mapstructure:"permission"`
+	Public     bool     `mapstructure:"public"`
+}
+
+type AuditConfig struct {
+	// CheckpointSigningKey is a base64 encoded Ed25519 seed. Checkpoints are
+	// disabled when it is empty.
+	CheckpointSigningKey string            `mapstructure:"checkpoint_signing_key"`
+	CheckpointInterval   time.Duration     `mapstructure:"checkpoint_interval"`
+	Export               AuditExportConfig `mapstructure:"export"`
+}
+
+// AuditExportConfig configures the shipper that forwards audit events to a
+// SIEM. Sink is either "socket", using Network and Address, or "file", using
+// FilePath with size based rotation.
+type AuditExportConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	Name        string        `mapstructure:"name"`
+	Format      string        `mapstructure:"format"`
+	Sink        string        `mapstructure:"sink"`
+	Network     string        `mapstructure:"network"`
+	Address     string        `mapstructure:"address"`
+	FilePath    string        `mapstructure:"file_path"`
+	MaxFileSize int64         `mapstructure:"max_file_size"`
+	MaxBackups  int           `mapstructure:"max_backups"`
+	Interval    time.Duration `mapstructure:"interval"`
+}
+
+// OutboxConfig configures the relay that publishes domain events from the
+// transactional outbox. Publisher is "memory", "webhook", "nats" or "kafka",
+// or empty to only feed organization webhooks.
+// Target is the NATS subject prefix or the Kafka topic.
+type OutboxConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Publisher string        `mapstructure:"publisher"`
+	URL       string        `mapstructure:"url"`
+	Target    string        `mapstructure:"target"`
+	Interval  time.Duration `mapstructure:"interval"`
+	BatchSize int           `mapstructure:"batch_size"`
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// WebhooksConfig configures delivery of domain events to the webhook
+// subscriptions of organizations. Events reach subscriptions through the
+// outbox relay, so it requires outbox.enabled.
+type WebhooksConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	Interval     time.Duration `mapstructure:"interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+	DisableAfter int           `mapstructure:"disable_after"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	// AllowPrivateNetworks lets subscriptions deliver to loopback, private
+	// and other non-public addresses.
+	AllowPrivateNetworks bool `mapstructure:"allow_private_networks"`
+}
+
+// WebAuthnConfig configures passkeys. RPID is the domain passkeys are bound
+// to and RPOrigins the origins of the pages that may use them. Timeout
+// bounds the time between the begin and finish requests of a ceremony.
+type WebAuthnConfig struct {
+	RPID          string        `mapstructure:"rp_id"`
+	RPDisplayName string        `mapstructure:"rp_display_name"`
+	RPOrigins     []string      `mapstructure:"rp_origins"`
+	Timeout       time.Duration `mapstructure:"timeout"`
+}
+
+// MailConfig configures outgoing email. Driver is "smtp", using Host, Port
+// and the optional Username and Password, "file", writing each message to
+// Dir, or "memory".
+type MailConfig struct {
+	Driver   string `mapstructure:"driver"`
+	From     string `mapstructure:"from"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Dir      string `mapstructure:"dir"`
+}
+
+// AccountConfig configures email verification and password reset. The URLs
+// are the frontend pages the mailed links point to; the token is appended
+// as the token query parameter.
+type AccountConfig struct {
+	VerifyEmailURL       string        `mapstructure:"verify_email_url"`
+	ResetPasswordURL     string        `mapstructure:"reset_password_url"`
+	VerificationTokenTTL time.Duration `mapstructure:"verification_token_ttl"`
+	ResetTokenTTL        time.Duration `mapstructure:"reset_token_ttl"`
+}
+
+// PasswordlessConfig configures logins with a code or link mailed to the
+// user. LoginURL is the frontend page the link points to, with the link
+// token appended as the token query parameter. Both expire after CodeTTL
+// and MaxAttempts codes or links may be tried per mail. An address is
+// mailed at most MaxMails times per MailWindow.
+type PasswordlessConfig struct {
+	LoginURL    string        `mapstructure:"login_url"`
+	CodeTTL     time.Duration `mapstructure:"code_ttl"`
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	MaxMails    int           `mapstructure:"max_mails"`
+	MailWindow  time.Duration `mapstructure:"mail_window"`
+}
+
+// RateLimitConfig configures the request rate limits. Buckets are kept in
+// Redis, falling back to process memory while Redis is unavailable. Default
+// applies to each client address across the API, Auth to each address on
+// the unauthenticated auth endpoints, User to each user and APIKey to each
+// API key on the authenticated endpoints. User and APIKey are not enforced
+// while they allow no requests.
+type RateLimitConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Default RateLimitRule `mapstructure:"default"`
+	Auth    RateLimitRule `mapstructure:"auth"`
+	User    RateLimitRule `mapstructure:"user"`
+	APIKey  RateLimitRule `mapstructure:"api_key"`
+}
+
+// RateLimitRule allows Requests per Period on average and bursts of up to
+// Burst, which defaults to Requests.
+type RateLimitRule struct {
+	Requests int           `mapstructure:"requests"`
+	Period   time.Duration `mapstructure:"period"`
+	Burst    int           `mapstructure:"burst"`
+}
+
+// LoginProtectionConfig configures the throttling of failed logins. Once an
+// account or a client address has failed DelayAfter times within Window,
+// each further attempt must wait BaseDelay, doubling with every failure up
+// to MaxDelay. At the lockout thresholds it is locked out for
+// LockoutDuration, doubling with every consecutive lockout up to
+// MaxLockoutDuration. A threshold of 0 disables that lockout.
+type LoginProtectionConfig struct {
+	Enabled                 bool          `mapstructure:"enabled"`
+	Window                  time.Duration `mapstructure:"window"`
+	DelayAfter              int           `mapstructure:"delay_after"`
+	BaseDelay               time.Duration `mapstructure:"base_delay"`
+	MaxDelay                time.Duration `mapstructure:"max_delay"`
+	AccountLockoutThreshold int           `mapstructure:"account_lockout_threshold"`
+	IPLockoutThreshold      int           `mapstructure:"ip_lockout_threshold"`
+	LockoutDuration         time.Duration `mapstructure:"lockout_duration"`
+	MaxLockoutDuration      time.Duration `mapstructure:"max_lockout_duration"`
+}
+
+// SessionConfig configures login sessions. RefreshTokenTTL is the lifetime
+// of a session, after which the user logs in again. Last-seen times are
+// written at most once per TouchInterval.
+type SessionConfig struct {
+	RefreshTokenTTL time.Duration `mapstructure:"refresh_token_ttl"`
+	TouchInterval   time.Duration `mapstructure:"touch_interval"`
+}
+
+// OIDCConfig configures the OpenID Connect provider. Issuer is the public
+// base URL of the service, and LoginURL the frontend page that the
+// authorization endpoint sends users to, with the authorization request in
+// its query, to log in and consent. DeviceVerificationURL is the frontend
+// page where users enter the user codes of the device grant, LoginURL when
+// empty. Tokens are signed with the keys of SigningKeysConfig.
+type OIDCConfig struct {
+	Enabled               bool          `mapstructure:"enabled"`
+	Issuer                string        `mapstructure:"issuer"`
+	LoginURL              string        `mapstructure:"login_url"`
+	DeviceVerificationURL string        `mapstructure:"device_verification_url"`
+	AuthorizationCodeTTL  time.Duration `mapstructure:"authorization_code_ttl"`
+	DeviceCodeTTL         time.Duration `mapstructure:"device_code_ttl"`
+	DevicePollInterval    time.Duration `mapstructure:"device_poll_interval"`
+	AccessTokenTTL        time.Duration `mapstructure:"access_token_ttl"`
+	IDTokenTTL            time.Duration `mapstructure:"id_token_ttl"`
+}
+
+// FederationConfig configures login with upstream OpenID Connect providers,
+// such as Google or a corporate SSO. RedirectURL is the frontend page the
+// providers send users back to, which passes the code and state on to the
+// callback endpoint; it must be registered with each provider. StateTTL
+// bounds the time a user has to log in at the provider.
+type FederationConfig struct {
+	RedirectURL string                     `mapstructure:"redirect_url"`
+	StateTTL    time.Duration              `mapstructure:"state_ttl"`
+	Providers   []FederationProviderConfig `mapstructure:"providers"`
+}
+
+// FederationProviderConfig configures an upstream provider. Name is its
+// identifier in the API and in linked identities. The provider must
+// publish a discovery document under Issuer.
+//
+// CreateUsers creates an account on the first login of an identity that no
+// account matches, and LinkByEmail links an identity to the account with
+// its email address; both need the provider to assert the address is
+// verified, and linking also needs the account to have verified it.
+type FederationProviderConfig struct {
+	Name         string   `mapstructure:"name"`
+	DisplayName  string   `mapstructure:"display_name"`
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	Scopes       []string `mapstructure:"scopes"`
+	CreateUsers  bool     `mapstructure:"create_users"`
+	LinkByEmail  bool     `mapstructure:"link_by_email"`
+}
+
+// SAMLConfig configures the SAML single sign-on of organizations. BaseURL
+// is the public URL of the service, under which each organization's SP
+// metadata and assertion consumer service are published. RedirectURL is
+// the frontend page the assertion consumer service sends users to, with a
+// code to redeem at the callback endpoint or an error. RequestTTL bounds
+// the time a user has to log in at the IdP.
+type SAMLConfig struct {
+	BaseURL     string        `mapstructure:"base_url"`
+	RedirectURL string        `mapstructure:"redirect_url"`
+	RequestTTL  time.Duration `mapstructure:"request_ttl"`
+}
+
+// ServiceAccountConfig configures service accounts. TokenTTL is the
+// lifetime of client_credentials access tokens, and SecretGracePeriod how
+// long a rotated secret keeps working.
+type ServiceAccountConfig struct {
+	TokenTTL          time.Duration `mapstructure:"token_ttl"`
+	SecretGracePeriod time.Duration `mapstructure:"secret_grace_period"`
+}
+
+// APIKeyConfig configures personal API keys. Last-used times are written
+// at most once per TouchInterval.
+type APIKeyConfig struct {
+	TouchInterval time.Duration `mapstructure:"touch_interval"`
+}
+
+// SigningKeysConfig configures the keys that access, MFA and ID tokens are
+// signed with. New keys use Algorithm: RS256, ES256 or EdDSA. A key is
+// published as the next key for RotationInterval before it signs, signs for
+// RotationInterval, and stays published for Retention after, which must
+// exceed the token lifetimes. EncryptionKey is the base64 encoded 32-byte
+// AES key that private keys are encrypted with at rest.
+type SigningKeysConfig struct {
+	Algorithm        string        `mapstructure:"algorithm"`
+	RotationInterval time.Duration `mapstructure:"rotation_interval"`
+	Retention        time.Duration `mapstructure:"retention"`
+	EncryptionKey    string        `mapstructure:"encryption_key"`
+}
+
 func parseConfig(v *viper.Viper) (*Config, error) {
 	var config Config
 	err := v.Unmarshal(&config)
@@ -85,7 +381,7 @@ func GetConfig(env string) (*Config, error) {
 	loader, err := localConfig(configPath, "yaml")
 	if err != nil {
 		log.Printf("unable to finc config, %v\n", err)
-		errors.New("no config found")
+		return nil, errors.New("no config found")
 	}
 	return parseConfig(loader)
 }
@@ -7,9 +7,13 @@ import (
 	"user-management/internal/config"
 )
 
-func NewDatabase(ctx context.Context, config config.PostgresConfig) (*pgxpool.Pool, error) {
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
+func ConnectionString(config config.PostgresConfig) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
 		config.Username, config.Password, config.Host, config.Port, config.Database, config.SSLMode)
+}
+
+func NewDatabase(ctx context.Context, config config.PostgresConfig) (*pgxpool.Pool, error) {
+	connStr := ConnectionString(config)
 
 	poolConfig, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS audit_export_cursors (
+    name VARCHAR(100) PRIMARY KEY,
+    last_event_id BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT NOW()
+    );
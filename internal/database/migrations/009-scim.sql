@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS scim_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_scim_tokens_org ON scim_tokens(organization_id);
+
+-- External IDs are assigned by the provisioning client and are unique per
+-- organization and resource type.
+CREATE TABLE IF NOT EXISTS scim_external_ids (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    resource_type VARCHAR(20) NOT NULL,
+    resource_id UUID NOT NULL,
+    external_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (organization_id, resource_type, resource_id),
+    UNIQUE (organization_id, resource_type, external_id)
+    );
+
+-- Suspending a membership revokes the permissions it grants, so membership
+-- changes are published on the change feed as well.
+DROP TRIGGER IF EXISTS user_organizations_notify_change ON user_organizations;
+CREATE TRIGGER user_organizations_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON user_organizations
+    FOR EACH ROW EXECUTE FUNCTION notify_change();
@@ -0,0 +1,16 @@
+-- Failed logins, tracked per account and per client address. key is
+-- "email:<SHA-256 of the address>", also for addresses without an account,
+-- or "ip:<address>". failures counts the failures since the last success or
+-- lockout within the failure window, lockouts the lockouts that followed
+-- each other without a success in between.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key VARCHAR(320) PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    failures INTEGER NOT NULL DEFAULT 0,
+    lockouts INTEGER NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts(user_id);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_last_failed_at ON login_attempts(last_failed_at);
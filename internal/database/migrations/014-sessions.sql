@@ -0,0 +1,27 @@
+-- A session is a refresh token family: each refresh replaces
+-- refresh_token_hash, and presenting a replaced token revokes the session.
+-- device is derived from the user agent of the login.
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    refresh_token_hash VARCHAR(64) NOT NULL,
+    device VARCHAR(255) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    amr TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+-- The number of sessions each member of an organization may hold in it at
+-- once. Logging in beyond it ends the least recently used session.
+CREATE TABLE IF NOT EXISTS session_policies (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    max_sessions_per_user INTEGER NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW()
+    );
@@ -0,0 +1,28 @@
+-- Service accounts are non-human principals owned by an organization. Each
+-- has a users row with the same ID, so that roles are assigned and
+-- resolved through user_roles as for people; that row has no password and
+-- principal_type 'service_account', which keeps it out of logins and user
+-- listings.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS principal_type VARCHAR(20) NOT NULL DEFAULT 'user';
+
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    client_id VARCHAR(64) UNIQUE NOT NULL,
+    secret_hash VARCHAR(64) NOT NULL,
+    -- The secret replaced by the last rotation keeps working until
+    -- previous_secret_expires_at, so that callers can switch without
+    -- downtime.
+    previous_secret_hash VARCHAR(64),
+    previous_secret_expires_at TIMESTAMP,
+    secret_rotated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP,
+    last_used_ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_service_accounts_organization ON service_accounts(organization_id);
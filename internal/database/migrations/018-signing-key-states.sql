@@ -0,0 +1,13 @@
+-- Signing keys move through the states next, active and retired. The next
+-- key is published ahead of signing, so that verifiers caching the key set
+-- know it before tokens signed with it appear. At most one key is next and
+-- one active. Private keys written from now on are encrypted; older rows
+-- hold plain PEM and are read as such.
+ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS state VARCHAR(16) NOT NULL DEFAULT 'active';
+ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS activated_at TIMESTAMP;
+
+UPDATE signing_keys SET state = 'retired' WHERE retired_at IS NOT NULL;
+UPDATE signing_keys SET activated_at = created_at WHERE state = 'active' AND activated_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_next ON signing_keys(state) WHERE state = 'next';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_active ON signing_keys(state) WHERE state = 'active';
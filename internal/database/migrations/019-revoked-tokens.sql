@@ -0,0 +1,12 @@
+-- Access tokens revoked before they expire, by their jti. Authentication
+-- rejects the tokens listed here; rows are only needed until the token
+-- would have expired anyway and are deleted after that.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id UUID PRIMARY KEY,
+    subject_id UUID NOT NULL,
+    organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
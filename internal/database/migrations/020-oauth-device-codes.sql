@@ -0,0 +1,24 @@
+-- Device authorization requests of RFC 8628. Devices poll with the device
+-- code while a user enters the user code on another device; only the
+-- hashes of both are stored. last_polled_at and interval_seconds enforce
+-- the polling interval, which grows each time a device polls too fast.
+CREATE TABLE IF NOT EXISTS oauth_device_codes (
+    device_code_hash VARCHAR(64) PRIMARY KEY,
+    user_code_hash VARCHAR(64) NOT NULL UNIQUE,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    amr TEXT[] NOT NULL DEFAULT '{}',
+    interval_seconds INTEGER NOT NULL,
+    last_polled_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_oauth_device_codes_expires_at ON oauth_device_codes(expires_at);
+
+-- The grant that opened a session of a client. Sessions opened by the
+-- device grant refresh to access tokens of the API rather than of the
+-- client.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS grant_type VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,34 @@
+-- SAML identity providers of organizations, one per organization. Users
+-- who log in through one are linked in identities with the provider
+-- 'saml:<organization ID>' and their NameID as subject.
+CREATE TABLE IF NOT EXISTS saml_connections (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    idp_metadata TEXT NOT NULL,
+    idp_entity_id VARCHAR(1024) NOT NULL,
+    attribute_mapping JSONB NOT NULL DEFAULT '{}',
+    default_role_id UUID REFERENCES roles(id) ON DELETE SET NULL,
+    allow_idp_initiated BOOLEAN NOT NULL DEFAULT FALSE,
+    create_users BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+    );
+
+-- Roles granted to the users an organization's IdP asserts a group for.
+CREATE TABLE IF NOT EXISTS saml_role_mappings (
+    organization_id UUID NOT NULL REFERENCES saml_connections(organization_id) ON DELETE CASCADE,
+    group_name VARCHAR(255) NOT NULL,
+    role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (organization_id, group_name, role_id)
+    );
+
+-- IDs of accepted assertions until they expire, so that a response the IdP
+-- initiated cannot be posted twice.
+CREATE TABLE IF NOT EXISTS saml_assertions (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    assertion_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (organization_id, assertion_id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_saml_assertions_expires_at ON saml_assertions(expires_at);
@@ -0,0 +1,27 @@
+-- Passwordless logins in progress: a code and a link mailed to the user,
+-- both usable only together with the device token the requesting browser
+-- was given. Only SHA-256 hashes are stored; the code is hashed with the
+-- challenge ID. attempts counts the codes and links tried.
+CREATE TABLE IF NOT EXISTS passwordless_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    device_hash VARCHAR(64) NOT NULL UNIQUE,
+    code_hash VARCHAR(64) NOT NULL,
+    link_hash VARCHAR(64) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_passwordless_challenges_user_id ON passwordless_challenges(user_id);
+
+-- The login methods an organization allows its members, among 'password',
+-- 'magic_link', 'passkey' and 'sso'. Organizations without a row allow
+-- all of them.
+CREATE TABLE IF NOT EXISTS login_policies (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    methods TEXT[] NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW()
+    );
@@ -0,0 +1,9 @@
+-- Exports follow the transactions that recorded events rather than their
+-- IDs, which are assigned before commit. Existing events keep transaction 0,
+-- so that existing cursors continue where they are.
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS transaction_id xid8 NOT NULL DEFAULT '0';
+ALTER TABLE audit_events ALTER COLUMN transaction_id SET DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_transaction ON audit_events (transaction_id, id);
+
+ALTER TABLE audit_export_cursors ADD COLUMN IF NOT EXISTS last_transaction_id xid8 NOT NULL DEFAULT '0';
@@ -0,0 +1,3 @@
+-- Response bodies of webhook endpoints are no longer kept, so that endpoints
+-- cannot be read through the delivery history. Drop the ones kept so far.
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS response_body;
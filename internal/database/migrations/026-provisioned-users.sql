@@ -0,0 +1,5 @@
+-- The organization whose provisioning client created an account. Only that
+-- organization manages the account's email address and password, and only
+-- while the account belongs to no other organization. Accounts created
+-- before this column existed are not managed by any organization.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS provisioned_by UUID REFERENCES organizations(id) ON DELETE SET NULL;
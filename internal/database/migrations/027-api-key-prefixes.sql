@@ -0,0 +1,3 @@
+-- API key prefixes grow from 8 to 16 random hex characters so that they
+-- stay unique as keys accumulate. Existing keys keep their shorter prefix.
+ALTER TABLE api_keys ALTER COLUMN prefix TYPE VARCHAR(32);
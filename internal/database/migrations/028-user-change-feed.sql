@@ -0,0 +1,18 @@
+-- Only whether a user is active affects their effective permissions, so
+-- the users change feed skips inserts and other updates, such as recording
+-- a login. Deleting a user that is already inactive changes nothing either;
+-- the user_roles and user_organizations rows that cascade are published on
+-- their own.
+DROP TRIGGER IF EXISTS users_notify_change ON users;
+CREATE TRIGGER users_notify_change
+    AFTER UPDATE OF is_active ON users
+    FOR EACH ROW
+    WHEN (OLD.is_active IS DISTINCT FROM NEW.is_active)
+    EXECUTE FUNCTION notify_change();
+
+DROP TRIGGER IF EXISTS users_notify_delete ON users;
+CREATE TRIGGER users_notify_delete
+    AFTER DELETE ON users
+    FOR EACH ROW
+    WHEN (OLD.is_active)
+    EXECUTE FUNCTION notify_change();
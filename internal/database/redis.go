@@ -0,0 +1,23 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"user-management/internal/config"
+)
+
+func NewRedis(ctx context.Context, config config.RedisConfig) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", config.Host, config.Port),
+		Password: config.Password,
+		DB:       config.DB,
+	})
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,329 @@
+// Package federation is the OpenID Connect client of logins with upstream
+// identity providers: discovery, the authorization code flow with PKCE and
+// the verification of ID tokens.
+package federation
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"user-management/internal/config"
+	"user-management/internal/oidc"
+)
+
+// ErrInvalidIDToken is returned for ID tokens that do not verify.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+const (
+	defaultTimeout = 10 * time.Second
+	// keySetTTL is how long the key set is cached, and
+	// minKeyRefreshInterval limits fetches of it on ID tokens signed with
+	// a key that is not cached.
+	keySetTTL             = time.Hour
+	minKeyRefreshInterval = time.Minute
+	// leeway is the clock skew tolerated between the provider and us.
+	leeway = time.Minute
+)
+
+var defaultScopes = []string{oidc.ScopeOpenID, oidc.ScopeEmail, oidc.ScopeProfile}
+
+// Identity is what a provider asserts about a user in an ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider is an upstream OpenID Connect provider. Its discovery document
+// is fetched on first use and its keys are cached. It is safe for
+// concurrent use.
+type Provider struct {
+	name         string
+	displayName  string
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	redirectURL  string
+	http         *http.Client
+	now          func() time.Time
+
+	mu            sync.Mutex
+	metadata      *oidc.Discovery
+	keys          *oidc.JWKS
+	keysFetchedAt time.Time
+}
+
+// NewProvider returns the provider of conf. redirectURL is the redirect
+// URI of the authorization requests.
+func NewProvider(conf config.FederationProviderConfig, redirectURL string) (*Provider, error) {
+	if conf.Name == "" {
+		return nil, errors.New("provider name is required")
+	}
+	if conf.Issuer == "" || conf.ClientID == "" {
+		return nil, fmt.Errorf("provider %s: issuer and client ID are required", conf.Name)
+	}
+	if redirectURL == "" {
+		return nil, errors.New("redirect URL is required")
+	}
+
+	displayName := conf.DisplayName
+	if displayName == "" {
+		displayName = conf.Name
+	}
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+
+	return &Provider{
+		name:         conf.Name,
+		displayName:  displayName,
+		issuer:       conf.Issuer,
+		clientID:     conf.ClientID,
+		clientSecret: conf.ClientSecret,
+		scopes:       scopes,
+		redirectURL:  redirectURL,
+		http:         &http.Client{Timeout: defaultTimeout},
+		now:          time.Now,
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) DisplayName() string {
+	return p.displayName
+}
+
+// NewNonce returns a random value for the state, nonce and PKCE code
+// verifier of an authorization request.
+func NewNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthorizationURL returns the URL to send the user to, to log in at the
+// provider. The provider redirects back with a code and state, and the code
+// is redeemed with Exchange.
+func (p *Provider) AuthorizationURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authorize, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint of %s: %w", p.name, err)
+	}
+	query := authorize.Query()
+	query.Set("response_type", oidc.ResponseTypeCode)
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", oidc.FormatScope(p.scopes))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", oidc.CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", oidc.CodeChallengeMethodS256)
+	authorize.RawQuery = query.Encode()
+	return authorize.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the identity of the
+// verified ID token. nonce and codeVerifier are those of the authorization
+// request. The provider's refusal is returned as an *oidc.Error.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {oidc.GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem code at %s: %w", p.name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr oidc.Error
+		if err := json.NewDecoder(resp.Body).Decode(&oauthErr); err != nil || oauthErr.Code == "" {
+			return nil, fmt.Errorf("failed to redeem code at %s: status %d", p.name, resp.StatusCode)
+		}
+		return nil, &oauthErr
+	}
+
+	var tokens oidc.TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response of %s: %w", p.name, err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in the token response", ErrInvalidIDToken)
+	}
+	return p.verify(ctx, metadata, tokens.IDToken, nonce)
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token.
+func (p *Provider) verify(ctx context.Context, metadata *oidc.Discovery, idToken, nonce string) (*Identity, error) {
+	var keyErr error
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, metadata, keyID, token.Method.Alg())
+		if err != nil {
+			keyErr = err
+		}
+		return key, err
+	}
+
+	claims := &oidc.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, keyFunc,
+		jwt.WithValidMethods(oidc.SigningAlgorithms),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+		jwt.WithTimeFunc(p.now),
+	)
+	if keyErr != nil && !errors.Is(keyErr, ErrInvalidIDToken) {
+		return nil, keyErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID {
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	identity := &Identity{
+		Subject:    claims.Subject,
+		Email:      strings.ToLower(strings.TrimSpace(claims.Email)),
+		GivenName:  claims.GivenName,
+		FamilyName: claims.FamilyName,
+	}
+	identity.EmailVerified = identity.Email != "" && claims.EmailVerified != nil && *claims.EmailVerified
+	return identity, nil
+}
+
+// discover returns the provider's discovery document, fetching it on first
+// use. A failed fetch is retried on the next call.
+func (p *Provider) discover(ctx context.Context) (*oidc.Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata oidc.Discovery
+	if err := p.get(ctx, strings.TrimSuffix(p.issuer, "/")+oidc.DiscoveryPath, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", p.name, err)
+	}
+	if metadata.Issuer != p.issuer {
+		return nil, fmt.Errorf("failed to discover %s: issuer %q does not match", p.name, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("failed to discover %s: missing endpoints", p.name)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// key returns the public key with keyID for algorithm. The key set is
+// fetched again once it is older than keySetTTL, and on a key ID it does
+// not know, at most once per minKeyRefreshInterval.
+func (p *Provider) key(ctx context.Context, metadata *oidc.Discovery, keyID, algorithm string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	age := p.now().Sub(p.keysFetchedAt)
+	_, found := findKey(p.keys, keyID, algorithm)
+	if p.keys == nil || age >= keySetTTL || (!found && age >= minKeyRefreshInterval) {
+		var keys oidc.JWKS
+		if err := p.get(ctx, metadata.JWKSURI, &keys); err != nil {
+			return nil, fmt.Errorf("failed to fetch the keys of %s: %w", p.name, err)
+		}
+		p.keys = &keys
+		p.keysFetchedAt = p.now()
+	}
+
+	key, ok := findKey(p.keys, keyID, algorithm)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, keyID)
+	}
+	return key, nil
+}
+
+// findKey returns the key with keyID in keys, unless it is for another
+// algorithm than the token's.
+func findKey(keys *oidc.JWKS, keyID, algorithm string) (crypto.PublicKey, bool) {
+	if keys == nil {
+		return nil, false
+	}
+	for _, key := range keys.Keys {
+		if key.KeyID != keyID || (key.Algorithm != "" && key.Algorithm != algorithm) {
+			continue
+		}
+		publicKey, err := key.PublicKey()
+		return publicKey, err == nil
+	}
+	return nil, false
+}
+
+func (p *Provider) get(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
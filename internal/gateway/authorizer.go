@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"user-management/internal/auth"
+	"user-management/internal/repository"
+)
+
+const (
+	HeaderUserID         = "X-User-ID"
+	HeaderUserEmail      = "X-User-Email"
+	HeaderOrganizationID = "X-Organization-ID"
+)
+
+// IdentityHeaders are the headers the gateway injects on allowed requests.
+// Proxies must strip client-supplied copies so they cannot be spoofed.
+var IdentityHeaders = []string{HeaderUserID, HeaderUserEmail, HeaderOrganizationID}
+
+type Request struct {
+	Method        string
+	Path          string
+	Authorization string
+	// APIKey is the X-API-Key header, used when Authorization is empty.
+	APIKey         string
+	OrganizationID string
+}
+
+type Decision struct {
+	Allowed bool
+	Status  int
+	Reason  string
+	Headers map[string]string
+}
+
+type Authorizer struct {
+	tokens    *auth.TokenManager
+	rules     *RuleSet
+	userRoles repository.UserRoleRepository
+}
+
+func NewAuthorizer(tokens *auth.TokenManager, rules *RuleSet, userRoles repository.UserRoleRepository) *Authorizer {
+	return &Authorizer{
+		tokens:    tokens,
+		rules:     rules,
+		userRoles: userRoles,
+	}
+}
+
+// Authorize decides whether req may reach the upstream application. Requests
+// that match no rule are denied.
+func (a *Authorizer) Authorize(ctx context.Context, req Request) Decision {
+	rule, ok := a.rules.Match(req.Method, req.Path)
+	if !ok {
+		return deny(http.StatusForbidden, "no matching rule")
+	}
+	if rule.Public {
+		return Decision{Allowed: true, Status: http.StatusOK, Reason: "public"}
+	}
+
+	token, ok := bearerToken(req.Authorization)
+	if !ok && auth.IsAPIKey(req.APIKey) {
+		token, ok = req.APIKey, true
+	}
+	if !ok {
+		return deny(http.StatusUnauthorized, "missing bearer token")
+	}
+
+	claims, err := a.tokens.ValidateAccessToken(ctx, token)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return deny(http.StatusUnauthorized, "invalid token")
+	}
+	if err != nil {
+		log.Printf("failed to validate token: %v\n", err)
+		return deny(http.StatusServiceUnavailable, "token validation failed")
+	}
+	userID, _ := claims.UserID()
+
+	orgValue := claims.OrganizationID
+	if orgValue == "" {
+		orgValue = req.OrganizationID
+	}
+	organizationID, err := uuid.Parse(orgValue)
+	if err != nil {
+		return deny(http.StatusForbidden, "organization required")
+	}
+	err = a.tokens.CheckOrganization(ctx, claims, organizationID)
+	if errors.Is(err, auth.ErrNotOrganizationMember) {
+		return deny(http.StatusForbidden, "not a member of the organization")
+	}
+	if errors.Is(err, auth.ErrOrganizationPolicy) {
+		return deny(http.StatusForbidden, "organization policy not met")
+	}
+	if err != nil {
+		log.Printf("failed to check organization policy for user %s: %v\n", userID, err)
+		return deny(http.StatusServiceUnavailable, "organization policy check failed")
+	}
+
+	if !claims.AllowsPermission(rule.Permission) {
+		return deny(http.StatusForbidden, "insufficient scope")
+	}
+
+	allowed, err := a.userRoles.HasPermission(ctx, userID, organizationID, rule.Permission)
+	if err != nil {
+		log.Printf("failed to check permission %s for user %s: %v\n", rule.Permission, userID, err)
+		return deny(http.StatusServiceUnavailable, "permission check failed")
+	}
+	if !allowed {
+		return deny(http.StatusForbidden, "permission denied")
+	}
+
+	return Decision{
+		Allowed: true,
+		Status:  http.StatusOK,
+		Reason:  "granted",
+		Headers: map[string]string{
+			HeaderUserID:         userID.String(),
+			HeaderUserEmail:      claims.Email,
+			HeaderOrganizationID: organizationID.String(),
+		},
+	}
+}
+
+func deny(status int, reason string) Decision {
+	return Decision{Allowed: false, Status: status, Reason: reason}
+}
+
+func bearerToken(header string) (string, bool) {
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"context"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"testing"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeUserRoleRepository struct {
+	repository.UserRoleRepository
+	granted map[string]bool
+}
+
+func (f *fakeUserRoleRepository) HasPermission(ctx context.Context, userID, organizationID uuid.UUID, permission string) (bool, error) {
+	return f.granted[permission], nil
+}
+
+type fakeOrganizationPolicy struct {
+	denied map[uuid.UUID]bool
+}
+
+func (f *fakeOrganizationPolicy) CheckOrganizationPolicy(ctx context.Context, claims *auth.Claims, organizationID uuid.UUID) error {
+	if f.denied[organizationID] {
+		return auth.ErrOrganizationPolicy
+	}
+	return nil
+}
+
+func newTestAuthorizer(t *testing.T, granted ...string) (*Authorizer, *auth.TokenManager) {
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "secret", Issuer: "test", AccessTokenTTL: time.Minute})
+	rules, err := NewRuleSet([]config.GatewayRuleConfig{
+		{Path: "/health", Public: true},
+		{Path: "/public/**", Public: true},
+		{Path: "/orders/*", Methods: []string{"DELETE"}, Permission: "order:delete"},
+		{Path: "/orders/**", Permission: "order:read"},
+	})
+	require.NoError(t, err)
+
+	repo := &fakeUserRoleRepository{granted: map[string]bool{}}
+	for _, permission := range granted {
+		repo.granted[permission] = true
+	}
+	return NewAuthorizer(tokens, rules, repo), tokens
+}
+
+func TestRuleSetMatch(t *testing.T) {
+	rules, err := NewRuleSet([]config.GatewayRuleConfig{
+		{Path: "/orders/*", Methods: []string{"delete"}, Permission: "order:delete"},
+		{Path: "/orders/**", Permission: "order:read"},
+	})
+	require.NoError(t, err)
+
+	rule, ok := rules.Match("DELETE", "/orders/42?force=true")
+	require.True(t, ok)
+	require.Equal(t, "order:delete", rule.Permission)
+
+	rule, ok = rules.Match("GET", "/orders/42/items")
+	require.True(t, ok)
+	require.Equal(t, "order:read", rule.Permission)
+
+	_, ok = rules.Match("GET", "/invoices")
+	require.False(t, ok)
+
+	rule, ok = rules.Match("GET", "/%6Frders/42/")
+	require.True(t, ok, "paths are matched decoded")
+	require.Equal(t, "order:read", rule.Permission)
+
+	_, err = NewRuleSet([]config.GatewayRuleConfig{{Path: "/a/**/b", Permission: "x"}})
+	require.Error(t, err)
+}
+
+func TestAuthorize(t *testing.T) {
+	authorizer, tokens := newTestAuthorizer(t, "order:read")
+	user := &models.User{ID: uuid.New(), Email: "ali@example.com"}
+	org := uuid.New()
+	token, err := tokens.GenerateAccessToken(user, org)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	decision := authorizer.Authorize(ctx, Request{Method: "GET", Path: "/health"})
+	require.True(t, decision.Allowed)
+
+	decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: "/public/docs/"})
+	require.True(t, decision.Allowed)
+
+	decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: "/orders/1"})
+	require.Equal(t, http.StatusUnauthorized, decision.Status)
+
+	decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: "/orders/1", Authorization: "Bearer " + token})
+	require.True(t, decision.Allowed)
+	require.Equal(t, user.ID.String(), decision.Headers[HeaderUserID])
+	require.Equal(t, org.String(), decision.Headers[HeaderOrganizationID])
+
+	decision = authorizer.Authorize(ctx, Request{Method: "DELETE", Path: "/orders/1", Authorization: "Bearer " + token})
+	require.Equal(t, http.StatusForbidden, decision.Status)
+
+	// Logins that select the organization with the header must meet its
+	// policy; logins to the organization met it when they were issued.
+	tokens.SetOrganizationPolicy(&fakeOrganizationPolicy{denied: map[uuid.UUID]bool{org: true}})
+	unscoped, err := tokens.GenerateAccessToken(user, uuid.Nil)
+	require.NoError(t, err)
+	decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: "/orders/1", Authorization: "Bearer " + unscoped, OrganizationID: org.String()})
+	require.Equal(t, http.StatusForbidden, decision.Status)
+	require.Equal(t, "organization policy not met", decision.Reason)
+	decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: "/orders/1", Authorization: "Bearer " + unscoped, OrganizationID: uuid.NewString()})
+	require.True(t, decision.Allowed)
+	decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: "/orders/1", Authorization: "Bearer " + token})
+	require.True(t, decision.Allowed)
+
+	decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: "/unknown", Authorization: "Bearer " + token})
+	require.Equal(t, http.StatusForbidden, decision.Status)
+
+	// Paths an upstream could normalize into another route are denied,
+	// even under a public rule.
+	for _, path := range []string{
+		"/public/../orders/1",
+		"/public/%2e%2e/orders/1",
+		"/public%2F..%2Forders/1",
+		"/public%2Fx",
+		"/public%2fx",
+		"/public/x%5Cy",
+		"/public/./x",
+		"//public/x",
+		"/public//x",
+		"/public/..\\orders",
+		"/public/%zz",
+	} {
+		decision = authorizer.Authorize(ctx, Request{Method: "GET", Path: path})
+		require.False(t, decision.Allowed, path)
+		require.Equal(t, http.StatusForbidden, decision.Status, path)
+	}
+}
+
+func TestEnvoyCheck(t *testing.T) {
+	authorizer, tokens := newTestAuthorizer(t, "order:read")
+	token, err := tokens.GenerateAccessToken(&models.User{ID: uuid.New()}, uuid.Nil)
+	require.NoError(t, err)
+	server := NewEnvoyServer(authorizer)
+
+	check := func(headers map[string]string) *authv3.CheckResponse {
+		resp, err := server.Check(context.Background(), &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{Method: "GET", Path: "/orders/7", Headers: headers},
+				},
+			},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := check(map[string]string{"authorization": "Bearer " + token})
+	require.Equal(t, int32(codes.PermissionDenied), resp.GetStatus().GetCode())
+
+	org := uuid.New()
+	resp = check(map[string]string{"authorization": "Bearer " + token, "x-organization-id": org.String()})
+	require.Equal(t, int32(codes.OK), resp.GetStatus().GetCode())
+	require.Contains(t, resp.GetOkResponse().GetHeadersToRemove(), "x-user-email")
+	require.Len(t, resp.GetOkResponse().GetHeaders(), 2)
+}
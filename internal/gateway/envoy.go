@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"strings"
+	"user-management/internal/auth"
+)
+
+// EnvoyServer implements the Envoy external authorization gRPC API.
+type EnvoyServer struct {
+	authv3.UnimplementedAuthorizationServer
+	authorizer *Authorizer
+}
+
+func NewEnvoyServer(authorizer *Authorizer) *EnvoyServer {
+	return &EnvoyServer{authorizer: authorizer}
+}
+
+func NewGRPCServer(authorizer *Authorizer) *grpc.Server {
+	server := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(server, NewEnvoyServer(authorizer))
+	return server
+}
+
+func (s *EnvoyServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	headers := httpReq.GetHeaders()
+
+	decision := s.authorizer.Authorize(ctx, Request{
+		Method:         httpReq.GetMethod(),
+		Path:           httpReq.GetPath(),
+		Authorization:  headers["authorization"],
+		APIKey:         headers[strings.ToLower(auth.HeaderAPIKey)],
+		OrganizationID: headers[strings.ToLower(HeaderOrganizationID)],
+	})
+
+	if !decision.Allowed {
+		return deniedResponse(decision), nil
+	}
+	return okResponse(decision), nil
+}
+
+func okResponse(decision Decision) *authv3.CheckResponse {
+	var set []*corev3.HeaderValueOption
+	var remove []string
+	for _, name := range IdentityHeaders {
+		value, ok := decision.Headers[name]
+		if !ok || value == "" {
+			remove = append(remove, strings.ToLower(name))
+			continue
+		}
+		set = append(set, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: name, Value: value},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers:         set,
+				HeadersToRemove: remove,
+			},
+		},
+	}
+}
+
+func deniedResponse(decision Decision) *authv3.CheckResponse {
+	code := codes.PermissionDenied
+	if decision.Status == http.StatusUnauthorized {
+		code = codes.Unauthenticated
+	} else if decision.Status == http.StatusServiceUnavailable {
+		code = codes.Unavailable
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"status":  "error",
+		"message": decision.Reason,
+	})
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: decision.Reason},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode(decision.Status)},
+				Headers: []*corev3.HeaderValueOption{{
+					Header:       &corev3.HeaderValue{Key: "Content-Type", Value: "application/json"},
+					AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+				}},
+				Body: string(body),
+			},
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"user-management/internal/config"
+)
+
+// Rule maps a request path pattern and set of methods onto the permission a
+// caller must hold. Patterns are matched segment by segment: "*" matches a
+// single segment and a trailing "**" matches any remainder, including none.
+type Rule struct {
+	Pattern    string
+	Permission string
+	Public     bool
+	segments   []string
+	methods    map[string]struct{}
+}
+
+type RuleSet struct {
+	rules []Rule
+}
+
+func NewRuleSet(configs []config.GatewayRuleConfig) (*RuleSet, error) {
+	rules := make([]Rule, 0, len(configs))
+	for i, c := range configs {
+		if !strings.HasPrefix(c.Path, "/") {
+			return nil, fmt.Errorf("gateway rule %d: path must start with /", i)
+		}
+		if !c.Public && c.Permission == "" {
+			return nil, fmt.Errorf("gateway rule %d: permission is required for non-public rules", i)
+		}
+
+		segments := splitPath(c.Path)
+		for j, segment := range segments {
+			if segment == "**" && j != len(segments)-1 {
+				return nil, fmt.Errorf("gateway rule %d: ** is only allowed as the last segment", i)
+			}
+		}
+
+		methods := make(map[string]struct{}, len(c.Methods))
+		for _, method := range c.Methods {
+			methods[strings.ToUpper(method)] = struct{}{}
+		}
+
+		rules = append(rules, Rule{
+			Pattern:    c.Path,
+			Permission: c.Permission,
+			Public:     c.Public,
+			segments:   segments,
+			methods:    methods,
+		})
+	}
+
+	return &RuleSet{rules: rules}, nil
+}
+
+// Match returns the first rule, in configuration order, matching method and
+// path. Any query string on path is ignored. Percent-encoded characters are
+// decoded before matching, and paths that are not canonical once decoded,
+// with "." or ".." segments, empty segments or backslashes, match no rule:
+// upstreams that normalize them could serve another route than the one
+// matched.
+func (s *RuleSet) Match(method, path string) (*Rule, bool) {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	segments, ok := canonicalSegments(path)
+	if !ok {
+		return nil, false
+	}
+	method = strings.ToUpper(method)
+
+	for i := range s.rules {
+		rule := &s.rules[i]
+		if len(rule.methods) > 0 {
+			if _, ok := rule.methods[method]; !ok {
+				continue
+			}
+		}
+		if matchSegments(rule.segments, segments) {
+			return rule, true
+		}
+	}
+
+	return nil, false
+}
+
+func matchSegments(pattern, path []string) bool {
+	for i, segment := range pattern {
+		if segment == "**" {
+			return true
+		}
+		if i >= len(path) {
+			return false
+		}
+		if segment != "*" && segment != path[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(path)
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// canonicalSegments decodes requestPath and splits it into segments, unless
+// it is not canonical. A trailing slash is allowed. Encoded slashes and
+// backslashes are not canonical either, since upstreams disagree on whether
+// they separate segments.
+func canonicalSegments(requestPath string) ([]string, bool) {
+	lower := strings.ToLower(requestPath)
+	if strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c") {
+		return nil, false
+	}
+	decoded, err := url.PathUnescape(requestPath)
+	if err != nil || !strings.HasPrefix(decoded, "/") || strings.Contains(decoded, "\\") {
+		return nil, false
+	}
+	if decoded != "/" && path.Clean(decoded) != strings.TrimSuffix(decoded, "/") {
+		return nil, false
+	}
+	return splitPath(decoded), true
+}
@@ -0,0 +1,172 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/config"
+)
+
+func TestRenderPicksLocale(t *testing.T) {
+	templates, err := LoadTemplates()
+	require.NoError(t, err)
+
+	require.Equal(t, "de", templates.Locale("de-AT,de;q=0.9,en;q=0.8"))
+	require.Equal(t, "es", templates.Locale("es-MX"))
+	require.Equal(t, "en", templates.Locale("fr-FR,fr;q=0.9"))
+	require.Equal(t, "en", templates.Locale(""))
+
+	data := TemplateData{Name: "<Ada>", Link: "https://example.com/reset?token=abc", ExpiresIn: time.Hour}
+	msg, err := templates.Render("de", TemplateResetPassword, data)
+	require.NoError(t, err)
+	require.Equal(t, "Setze dein Passwort zurück", msg.Subject)
+	require.Contains(t, msg.Text, "Hallo <Ada>,")
+	require.Contains(t, msg.Text, "1 Stunde gültig")
+	require.Contains(t, msg.HTML, "Hallo &lt;Ada&gt;,")
+	require.Contains(t, msg.HTML, `href="https://example.com/reset?token=abc"`)
+
+	data.ExpiresIn = 24 * time.Hour
+	msg, err = templates.Render("", TemplateVerifyEmail, data)
+	require.NoError(t, err)
+	require.Equal(t, "Verify your email address", msg.Subject)
+	require.Contains(t, msg.Text, "expires in 24 hours")
+
+	data.ExpiresIn = 90 * time.Minute
+	msg, err = templates.Render("es", TemplateVerifyEmail, data)
+	require.NoError(t, err)
+	require.Contains(t, msg.Text, "caduca en 90 minutos")
+}
+
+func TestEncodeMultipart(t *testing.T) {
+	from := &mail.Address{Name: "User Management", Address: "no-reply@example.com"}
+	data, err := encode(from, &Message{
+		To:      "ada@example.com",
+		Subject: "Bestätige deine E-Mail-Adresse",
+		Text:    "Hallo Ada,\n",
+		HTML:    "<p>Hallo Ada,</p>",
+	}, time.Now())
+	require.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, "Bestätige deine E-Mail-Adresse", subject)
+	require.Equal(t, "<ada@example.com>", parsed.Header.Get("To"))
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+	}
+	// Quoted-printable text uses CRLF line breaks.
+	require.Equal(t, []string{"Hallo Ada,\r\n", "<p>Hallo Ada,</p>"}, bodies)
+
+	_, err = encode(from, &Message{To: "ada@example.com", Subject: "Hi\r\nBcc: eve@example.com"}, time.Now())
+	require.Error(t, err)
+	_, err = encode(from, &Message{To: "ada@example.com\r\nBcc: eve@example.com", Subject: "Hi"}, time.Now())
+	require.Error(t, err)
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewMailer(configFor("file", dir))
+	require.NoError(t, err)
+
+	require.NoError(t, mailer.Send(context.Background(), &Message{To: "ada@example.com", Subject: "Hi", Text: "Hello\n"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Contains(t, string(data), "Subject: Hi\r\n")
+}
+
+// TestSMTPMailer delivers to a minimal SMTP server that records the
+// envelope and the message.
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 OK")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case line == "QUIT":
+				reply("221 Bye")
+				received <- lines
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	conf := configFor("smtp", "")
+	conf.Host, conf.Port = "127.0.0.1", addr.Port
+	mailer, err := NewMailer(conf)
+	require.NoError(t, err)
+
+	err = mailer.Send(context.Background(), &Message{To: "Ada <ada@example.com>", Subject: "Hi", Text: "Hello\n"})
+	require.NoError(t, err)
+
+	lines := <-received
+	require.Contains(t, lines, "MAIL FROM:<no-reply@example.com>")
+	require.Contains(t, lines, "RCPT TO:<ada@example.com>")
+	require.Contains(t, lines, "Subject: Hi")
+}
+
+func configFor(driver, dir string) config.MailConfig {
+	return config.MailConfig{Driver: driver, From: "User Management <no-reply@example.com>", Dir: dir}
+}
@@ -0,0 +1,142 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"golang.org/x/text/language"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Template names.
+const (
+	TemplateVerifyEmail   = "verify_email"
+	TemplateResetPassword = "reset_password"
+	// TemplatePasswordlessLogin carries a login Code as well as a Link.
+	TemplatePasswordlessLogin = "passwordless_login"
+)
+
+// DefaultLocale is used when none of the requested languages is available.
+const DefaultLocale = "en"
+
+//go:embed templates
+var templateFS embed.FS
+
+// TemplateData is passed to every template.
+type TemplateData struct {
+	Name      string
+	Link      string
+	Code      string
+	ExpiresIn time.Duration
+}
+
+// Templates renders the emails in the locales found under templates/. Each
+// locale has a common.tmpl with shared blocks and, per template, a
+// <name>.txt defining "subject" and "text" and a <name>.html for the HTML
+// part.
+type Templates struct {
+	locales []string
+	matcher language.Matcher
+	text    map[string]*texttemplate.Template
+	html    map[string]*htmltemplate.Template
+}
+
+var templateFuncs = map[string]interface{}{
+	// hours returns the whole number of hours of d, or 0 when d is not a
+	// whole number of hours.
+	"hours": func(d time.Duration) int {
+		if d%time.Hour != 0 {
+			return 0
+		}
+		return int(d / time.Hour)
+	},
+	"minutes": func(d time.Duration) int {
+		return int(d / time.Minute)
+	},
+}
+
+func LoadTemplates() (*Templates, error) {
+	entries, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+	// The default locale comes first so the matcher falls back to it.
+	t.locales = []string{DefaultLocale}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != DefaultLocale {
+			t.locales = append(t.locales, entry.Name())
+		}
+	}
+
+	tags := make([]language.Tag, 0, len(t.locales))
+	for _, locale := range t.locales {
+		tags = append(tags, language.Make(locale))
+		for _, name := range []string{TemplateVerifyEmail, TemplateResetPassword, TemplatePasswordlessLogin} {
+			common := path.Join("templates", locale, "common.tmpl")
+			text, err := texttemplate.New(name).Funcs(templateFuncs).ParseFS(templateFS, common, path.Join("templates", locale, name+".txt"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s/%s: %w", locale, name, err)
+			}
+			html, err := htmltemplate.New(name).Funcs(templateFuncs).ParseFS(templateFS, common, path.Join("templates", locale, name+".html"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s/%s: %w", locale, name, err)
+			}
+			t.text[locale+"/"+name] = text
+			t.html[locale+"/"+name] = html
+		}
+	}
+	t.matcher = language.NewMatcher(tags)
+
+	return t, nil
+}
+
+// Locale picks the available locale that best matches acceptLanguage, a
+// language tag or an Accept-Language header value.
+func (t *Templates) Locale(acceptLanguage string) string {
+	requested, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(requested) == 0 {
+		return DefaultLocale
+	}
+	_, index, confidence := t.matcher.Match(requested...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+	return t.locales[index]
+}
+
+// Render renders the named template for the best match of acceptLanguage.
+// The returned message has no recipient.
+func (t *Templates) Render(acceptLanguage, name string, data TemplateData) (*Message, error) {
+	locale := t.Locale(acceptLanguage)
+	text, ok := t.text[locale+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	var subject, body, html bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := text.ExecuteTemplate(&body, "text", data); err != nil {
+		return nil, err
+	}
+	if err := t.html[locale+"/"+name].ExecuteTemplate(&html, "html", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"net"
+	"slices"
+	"time"
+)
+
+// APIKey is a personal access token of a user in one organization. It is
+// identified by Prefix, the start of the key, which is safe to display.
+type APIKey struct {
+	ID             uuid.UUID `json:"id"`
+	UserID         uuid.UUID `json:"user_id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Name           string    `json:"name"`
+	Prefix         string    `json:"prefix"`
+	KeyHash        string    `json:"-"`
+	// Scopes lists the permissions the key is limited to. An empty list
+	// carries every permission of the user.
+	Scopes []string `json:"scopes"`
+	// AllowedIPs lists the addresses and CIDR ranges the key may be used
+	// from. An empty list allows any address.
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// UserActive reports whether the owner of the key is active.
+	UserActive bool `json:"-"`
+}
+
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// AllowsIP reports whether the key may be used from ip.
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	return slices.ContainsFunc(k.AllowedIPs, func(allowed string) bool {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			return network.Contains(addr)
+		}
+		return addr.Equal(net.ParseIP(allowed))
+	})
+}
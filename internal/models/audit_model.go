@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+type AuditEvent struct {
+	ID             int64           `db:"id" json:"id"`
+	OrganizationID *uuid.UUID      `db:"organization_id" json:"organization_id,omitempty"`
+	ActorID        *uuid.UUID      `db:"actor_id" json:"actor_id,omitempty"`
+	Action         string          `db:"action" json:"action"`
+	TargetType     string          `db:"target_type" json:"target_type"`
+	TargetID       string          `db:"target_id" json:"target_id"`
+	Changes        json.RawMessage `db:"changes" json:"changes,omitempty"`
+	IPAddress      string          `db:"ip_address" json:"ip_address,omitempty"`
+	UserAgent      string          `db:"user_agent" json:"user_agent,omitempty"`
+	PrevHash       string          `db:"prev_hash" json:"prev_hash"`
+	Hash           string          `db:"hash" json:"hash"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+}
+
+// AuditCheckpoint is a signed statement that Hash was the head of the audit
+// chain of an organization at EventID.
+type AuditCheckpoint struct {
+	ID             int64      `db:"id" json:"id"`
+	OrganizationID *uuid.UUID `db:"organization_id" json:"organization_id,omitempty"`
+	EventID        int64      `db:"event_id" json:"event_id"`
+	Hash           string     `db:"hash" json:"hash"`
+	Signature      string     `db:"signature" json:"signature"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+}
+
+// AuditExportCursor is the position of an export of the audit log. Events
+// are exported in the order of the transactions that recorded them, and of
+// their IDs within a transaction, since IDs are assigned before commit and
+// transactions commit out of ID order.
+type AuditExportCursor struct {
+	TransactionID uint64 `db:"last_transaction_id"`
+	EventID       int64  `db:"last_event_id"`
+}
+
+func UUIDPtr(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
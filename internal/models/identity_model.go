@@ -0,0 +1,45 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Purposes of a FederationState.
+const (
+	FederationPurposeLogin = "login"
+	FederationPurposeLink  = "link"
+	// FederationPurposeSAMLRequest holds the ID of a SAML authentication
+	// request in Nonce, and FederationPurposeSAMLLogin the user a verified
+	// SAML response logs in, until the frontend redeems its code.
+	FederationPurposeSAMLRequest = "saml_request"
+	FederationPurposeSAMLLogin   = "saml_login"
+)
+
+// Identity is an account of a user at an upstream identity provider,
+// which the user can log in with. Subject is the provider's identifier of
+// the account, and Email the address it last asserted.
+type Identity struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Provider    string     `json:"provider" db:"provider"`
+	Subject     string     `json:"subject" db:"subject"`
+	Email       string     `json:"email" db:"email"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// FederationState holds an authorization request sent to an upstream
+// provider until the user returns with its code. Only the hash of the
+// state is stored. UserID is set when a logged in user links an identity,
+// and OrganizationID when a login is scoped to an organization.
+type FederationState struct {
+	StateHash      string     `json:"-" db:"state_hash"`
+	Provider       string     `json:"provider" db:"provider"`
+	Purpose        string     `json:"purpose" db:"purpose"`
+	Nonce          string     `json:"-" db:"nonce"`
+	CodeVerifier   string     `json:"-" db:"code_verifier"`
+	UserID         *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty" db:"organization_id"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// LoginAttempt holds the failed logins of an account or a client address.
+// UserID is set for accounts that exist.
+type LoginAttempt struct {
+	Key          string     `json:"key" db:"key"`
+	UserID       *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	Failures     int        `json:"failures" db:"failures"`
+	Lockouts     int        `json:"lockouts" db:"lockouts"`
+	LastFailedAt time.Time  `json:"last_failed_at" db:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+// Locked reports whether the key is locked out at now.
+func (a *LoginAttempt) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(now)
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// UserMFA holds a user's TOTP enrollment. It is pending until EnabledAt is
+// set.
+type UserMFA struct {
+	UserID       uuid.UUID  `json:"user_id" db:"user_id"`
+	TOTPSecret   string     `json:"-" db:"totp_secret"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty" db:"enabled_at"`
+	LastUsedStep int64      `json:"-" db:"last_used_step"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+func (m *UserMFA) Enabled() bool {
+	return m.EnabledAt != nil
+}
+
+// MFAPolicy lists the roles of an organization whose holders must use MFA.
+type MFAPolicy struct {
+	OrganizationID  uuid.UUID   `json:"organization_id"`
+	RequiredRoleIDs []uuid.UUID `json:"required_role_ids"`
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// OAuthClient is an application that logs users in through the OpenID
+// Connect provider. Only members of its organization can authorize it.
+// Public clients have no secret.
+type OAuthClient struct {
+	ID             uuid.UUID `json:"client_id" db:"id"`
+	OrganizationID uuid.UUID `json:"organization_id" db:"organization_id"`
+	Name           string    `json:"name" db:"name"`
+	SecretHash     string    `json:"-" db:"secret_hash"`
+	Public         bool      `json:"public" db:"-"`
+	RedirectURIs   []string  `json:"redirect_uris" db:"redirect_uris"`
+	Scopes         []string  `json:"scopes" db:"scopes"`
+	// SkipConsent is for first-party applications, which users are not
+	// asked to allow.
+	SkipConsent bool      `json:"skip_consent" db:"skip_consent"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OAuthConsent records the scopes a user has allowed a client.
+type OAuthConsent struct {
+	UserID     uuid.UUID `json:"user_id" db:"user_id"`
+	ClientID   uuid.UUID `json:"client_id" db:"client_id"`
+	ClientName string    `json:"client_name" db:"-"`
+	Scopes     []string  `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OAuthAuthorizationCode is an issued authorization code. Only its hash is
+// stored.
+type OAuthAuthorizationCode struct {
+	CodeHash      string     `json:"-" db:"code_hash"`
+	ClientID      uuid.UUID  `json:"client_id" db:"client_id"`
+	UserID        uuid.UUID  `json:"user_id" db:"user_id"`
+	RedirectURI   string     `json:"redirect_uri" db:"redirect_uri"`
+	Scopes        []string   `json:"scopes" db:"scopes"`
+	Nonce         string     `json:"-" db:"nonce"`
+	CodeChallenge string     `json:"-" db:"code_challenge"`
+	AMR           []string   `json:"amr" db:"amr"`
+	SessionID     *uuid.UUID `json:"session_id,omitempty" db:"session_id"`
+	ExpiresAt     time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+// Statuses of a device code. A pending code is approved or denied by the
+// user who enters its user code, and an approved one is used by the first
+// token request after that.
+const (
+	DeviceCodeStatusPending  = "pending"
+	DeviceCodeStatusApproved = "approved"
+	DeviceCodeStatusDenied   = "denied"
+	DeviceCodeStatusUsed     = "used"
+)
+
+// OAuthDeviceCode is a device authorization request of RFC 8628. Only the
+// hashes of its device code and user code are stored. UserID and AMR are
+// those of the user who decided it.
+type OAuthDeviceCode struct {
+	DeviceCodeHash string        `json:"-" db:"device_code_hash"`
+	UserCodeHash   string        `json:"-" db:"user_code_hash"`
+	ClientID       uuid.UUID     `json:"client_id" db:"client_id"`
+	Scopes         []string      `json:"scopes" db:"scopes"`
+	Status         string        `json:"status" db:"status"`
+	UserID         *uuid.UUID    `json:"user_id,omitempty" db:"user_id"`
+	AMR            []string      `json:"amr" db:"amr"`
+	Interval       time.Duration `json:"interval" db:"interval_seconds"`
+	LastPolledAt   *time.Time    `json:"last_polled_at,omitempty" db:"last_polled_at"`
+	ExpiresAt      time.Time     `json:"expires_at" db:"expires_at"`
+	CreatedAt      time.Time     `json:"created_at" db:"created_at"`
+}
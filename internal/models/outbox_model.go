@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// DomainEvent is the message published to other systems when an aggregate
+// changes. Consumers must tolerate duplicates and can deduplicate on ID.
+type DomainEvent struct {
+	ID             uuid.UUID       `json:"id"`
+	Type           string          `json:"type"`
+	AggregateType  string          `json:"aggregate_type"`
+	AggregateID    string          `json:"aggregate_id"`
+	OrganizationID *uuid.UUID      `json:"organization_id,omitempty"`
+	ActorID        *uuid.UUID      `json:"actor_id,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+}
+
+type OutboxEvent struct {
+	ID            int64       `db:"id" json:"id"`
+	Event         DomainEvent `db:"payload" json:"event"`
+	Attempts      int         `db:"attempts" json:"attempts"`
+	LastError     string      `db:"last_error" json:"last_error,omitempty"`
+	NextAttemptAt time.Time   `db:"next_attempt_at" json:"next_attempt_at"`
+	PublishedAt   *time.Time  `db:"published_at" json:"published_at,omitempty"`
+}
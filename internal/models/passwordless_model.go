@@ -0,0 +1,48 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+// Login methods an organization can allow.
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodMagicLink = "magic_link"
+	LoginMethodPasskey   = "passkey"
+	// LoginMethodSSO covers upstream OpenID Connect providers and the
+	// organization's SAML IdP.
+	LoginMethodSSO = "sso"
+)
+
+// LoginMethods are all login methods, which organizations allow unless
+// their policy says otherwise.
+var LoginMethods = []string{LoginMethodPassword, LoginMethodMagicLink, LoginMethodPasskey, LoginMethodSSO}
+
+// LoginPolicy lists the login methods the members of an organization may
+// log in to it with.
+type LoginPolicy struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Methods        []string  `json:"methods"`
+}
+
+func (p *LoginPolicy) Allows(method string) bool {
+	return slices.Contains(p.Methods, method)
+}
+
+// PasswordlessChallenge is a passwordless login in progress. The user
+// proves they received the mail with either its code or its link, from the
+// device that holds the device token.
+type PasswordlessChallenge struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	UserID         uuid.UUID  `json:"user_id" db:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty" db:"organization_id"`
+	DeviceHash     string     `json:"-" db:"device_hash"`
+	CodeHash       string     `json:"-" db:"code_hash"`
+	LinkHash       string     `json:"-" db:"link_hash"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt         *time.Time `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// RevokedToken lists an access token, by its ID, that is no longer
+// accepted although it has not expired.
+type RevokedToken struct {
+	ID             uuid.UUID  `json:"id"`
+	SubjectID      uuid.UUID  `json:"subject_id"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	RevokedAt      time.Time  `json:"revoked_at"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// SAMLConnection is an organization's SAML identity provider. IdPMetadata
+// is the metadata XML of the IdP, and IdPEntityID the entity ID read from
+// it.
+//
+// AllowIdPInitiated accepts responses that no authentication request of
+// ours asked for, which IdP dashboards send. CreateUsers creates an account
+// on the first login of a user that no account matches. Members who first
+// log in through the connection get DefaultRoleID, and RoleMappings grant
+// roles for the groups the IdP asserts.
+type SAMLConnection struct {
+	OrganizationID    uuid.UUID            `json:"organization_id" db:"organization_id"`
+	IdPMetadata       string               `json:"idp_metadata" db:"idp_metadata"`
+	IdPEntityID       string               `json:"idp_entity_id" db:"idp_entity_id"`
+	AttributeMapping  SAMLAttributeMapping `json:"attribute_mapping" db:"attribute_mapping"`
+	RoleMappings      []SAMLRoleMapping    `json:"role_mappings"`
+	DefaultRoleID     *uuid.UUID           `json:"default_role_id,omitempty" db:"default_role_id"`
+	AllowIdPInitiated bool                 `json:"allow_idp_initiated" db:"allow_idp_initiated"`
+	CreateUsers       bool                 `json:"create_users" db:"create_users"`
+	Enabled           bool                 `json:"enabled" db:"enabled"`
+	CreatedAt         time.Time            `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time            `json:"updated_at" db:"updated_at"`
+}
+
+// SAMLAttributeMapping names the assertion attributes that user fields are
+// read from, by name or friendly name. Empty fields are not read, except
+// that without an email attribute the NameID is the email address.
+type SAMLAttributeMapping struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Groups    string `json:"groups"`
+}
+
+// SAMLRoleMapping grants RoleID to the users the IdP asserts Group for, and
+// revokes it from those it no longer does.
+type SAMLRoleMapping struct {
+	Group  string    `json:"group"`
+	RoleID uuid.UUID `json:"role_id"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// SCIMToken authenticates a provisioning client of one organization. Only
+// the SHA-256 of the token is stored.
+type SCIMToken struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id" db:"organization_id"`
+	Name           string     `json:"name" db:"name"`
+	TokenHash      string     `json:"-" db:"token_hash"`
+	CreatedBy      *uuid.UUID `json:"created_by,omitempty" db:"created_by"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// SCIMUser is a user as seen by the provisioning client of an organization:
+// the user plus their membership of that organization.
+type SCIMUser struct {
+	User           User      `json:"user"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	ExternalID     string    `json:"external_id,omitempty"`
+	Active         bool      `json:"active"`
+}
+
+// SCIMGroup is a role of an organization exposed as a SCIM group; its
+// members are the users holding the role.
+type SCIMGroup struct {
+	Role       Role              `json:"role"`
+	ExternalID string            `json:"external_id,omitempty"`
+	Members    []SCIMGroupMember `json:"members"`
+}
+
+type SCIMGroupMember struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Display string    `json:"display,omitempty"`
+}
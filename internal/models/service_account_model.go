@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ServiceAccount is a non-human principal owned by an organization. It
+// authenticates with ClientID and a secret through the client_credentials
+// grant and holds roles like a user, through the users row sharing its ID.
+type ServiceAccount struct {
+	ID             uuid.UUID `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	ClientID       string    `json:"client_id"`
+	SecretHash     string    `json:"-"`
+	// PreviousSecretHash is the secret replaced by the last rotation, valid
+	// until PreviousSecretExpiresAt.
+	PreviousSecretHash      string     `json:"-"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	IsActive                bool       `json:"is_active"`
+	SecretRotatedAt         time.Time  `json:"secret_rotated_at"`
+	LastUsedAt              *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP              string     `json:"last_used_ip,omitempty"`
+	CreatedBy               *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+	UpdatedAt               time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Session is a login of a user on a device, from which access tokens are
+// refreshed. Only the hash of the current refresh token is stored. Sessions
+// of an OAuth client hold the client's refresh tokens, limited to Scopes;
+// GrantType is set for those opened by the device grant.
+type Session struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	UserID           uuid.UUID  `json:"user_id" db:"user_id"`
+	OrganizationID   *uuid.UUID `json:"organization_id,omitempty" db:"organization_id"`
+	RefreshTokenHash string     `json:"-" db:"refresh_token_hash"`
+	Device           string     `json:"device" db:"device"`
+	UserAgent        string     `json:"user_agent" db:"user_agent"`
+	IPAddress        string     `json:"ip_address" db:"ip_address"`
+	AMR              []string   `json:"amr" db:"amr"`
+	ClientID         *uuid.UUID `json:"client_id,omitempty" db:"client_id"`
+	Scopes           []string   `json:"scopes,omitempty" db:"scopes"`
+	GrantType        string     `json:"grant_type,omitempty" db:"grant_type"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	LastSeenAt       time.Time  `json:"last_seen_at" db:"last_seen_at"`
+	ExpiresAt        time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Active reports whether the session can still be used at now.
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(now)
+}
+
+// SessionPolicy limits the sessions each member of an organization may hold
+// in it at once. 0 means no limit.
+type SessionPolicy struct {
+	OrganizationID     uuid.UUID `json:"organization_id"`
+	MaxSessionsPerUser int       `json:"max_sessions_per_user"`
+}
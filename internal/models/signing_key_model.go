@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// States of a signing key. The next key is published before it signs, so
+// that verifiers caching the key set know it by the time it is active. The
+// active key signs, and retired keys are published until ExpiresAt so that
+// the tokens they signed verify until they expire.
+const (
+	SigningKeyStateNext    = "next"
+	SigningKeyStateActive  = "active"
+	SigningKeyStateRetired = "retired"
+)
+
+// SigningKey is a key tokens are signed with. PrivateKey is the PEM
+// encoded key, encrypted at rest.
+type SigningKey struct {
+	ID          string     `json:"kid" db:"id"`
+	Algorithm   string     `json:"alg" db:"algorithm"`
+	State       string     `json:"state" db:"state"`
+	PrivateKey  string     `json:"-" db:"private_key"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty" db:"activated_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty" db:"retired_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+}
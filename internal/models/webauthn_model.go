@@ -0,0 +1,42 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Ceremonies of a WebAuthnSession.
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+// WebAuthnCredential is a passkey registered by a user.
+type WebAuthnCredential struct {
+	ID                uuid.UUID  `json:"id" db:"id"`
+	UserID            uuid.UUID  `json:"user_id" db:"user_id"`
+	CredentialID      []byte     `json:"credential_id" db:"credential_id"`
+	PublicKey         []byte     `json:"-" db:"public_key"`
+	AttestationType   string     `json:"attestation_type" db:"attestation_type"`
+	AttestationFormat string     `json:"attestation_format" db:"attestation_format"`
+	Transports        []string   `json:"transports" db:"transports"`
+	AAGUID            []byte     `json:"aaguid,omitempty" db:"aaguid"`
+	SignCount         uint32     `json:"sign_count" db:"sign_count"`
+	CloneWarning      bool       `json:"clone_warning" db:"clone_warning"`
+	BackupEligible    bool       `json:"backup_eligible" db:"backup_eligible"`
+	BackupState       bool       `json:"backup_state" db:"backup_state"`
+	Name              string     `json:"name" db:"name"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+}
+
+// WebAuthnSession holds the challenge of a ceremony until it is finished.
+// UserID is nil for passkey logins, where the user is not known until the
+// assertion is received.
+type WebAuthnSession struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	UserID    *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	Ceremony  string     `json:"ceremony" db:"ceremony"`
+	Data      []byte     `json:"-" db:"data"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookSubscription is an endpoint of an organization that receives the
+// domain events matching EventTypes. An empty EventTypes matches every event.
+type WebhookSubscription struct {
+	ID                  uuid.UUID  `json:"id" db:"id"`
+	OrganizationID      uuid.UUID  `json:"organization_id" db:"organization_id"`
+	URL                 string     `json:"url" db:"url"`
+	Description         string     `json:"description" db:"description"`
+	EventTypes          []string   `json:"event_types" db:"event_types"`
+	Secret              string     `json:"-" db:"secret"`
+	IsActive            bool       `json:"is_active" db:"is_active"`
+	ConsecutiveFailures int        `json:"consecutive_failures" db:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	DisabledReason      string     `json:"disabled_reason,omitempty" db:"disabled_reason"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id" db:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id" db:"subscription_id"`
+	EventID        uuid.UUID       `json:"event_id" db:"event_id"`
+	EventType      string          `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty" db:"last_attempt_at"`
+	ResponseStatus int             `json:"response_status,omitempty" db:"response_status"`
+	Error          string          `json:"error,omitempty" db:"error"`
+	DurationMs     int64           `json:"duration_ms" db:"duration_ms"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+}
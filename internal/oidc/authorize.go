@@ -0,0 +1,115 @@
+package oidc
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Values of the prompt parameter that are honoured. Others are ignored.
+const (
+	PromptNone    = "none"
+	PromptConsent = "consent"
+)
+
+// AuthorizationRequest holds the parameters of an authorization code
+// request.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+func ParseAuthorizationRequest(values url.Values) *AuthorizationRequest {
+	return &AuthorizationRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Prompt:              values.Get("prompt"),
+	}
+}
+
+// Values returns the request as query parameters, leaving out empty ones.
+func (r *AuthorizationRequest) Values() url.Values {
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"response_type":         r.ResponseType,
+		"client_id":             r.ClientID,
+		"redirect_uri":          r.RedirectURI,
+		"scope":                 r.Scope,
+		"state":                 r.State,
+		"nonce":                 r.Nonce,
+		"code_challenge":        r.CodeChallenge,
+		"code_challenge_method": r.CodeChallengeMethod,
+		"prompt":                r.Prompt,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	return values
+}
+
+// Validate checks the parameters that do not depend on the client. The
+// error is returned to the client through its redirect URI.
+func (r *AuthorizationRequest) Validate() *Error {
+	if r.ResponseType != ResponseTypeCode {
+		return NewError(ErrorUnsupportedResponseType, "response_type must be code")
+	}
+	if r.CodeChallenge == "" {
+		return NewError(ErrorInvalidRequest, "code_challenge is required")
+	}
+	if r.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return NewError(ErrorInvalidRequest, "code_challenge_method must be S256")
+	}
+	if !validCodeChallenge(r.CodeChallenge) {
+		return NewError(ErrorInvalidRequest, "invalid code_challenge")
+	}
+	if len(ParseScope(r.Scope)) == 0 {
+		return NewError(ErrorInvalidScope, "scope is required")
+	}
+	return nil
+}
+
+func (r *AuthorizationRequest) HasPrompt(prompt string) bool {
+	return slices.Contains(strings.Fields(r.Prompt), prompt)
+}
+
+// Redirect returns the redirect URI with params and the request's state
+// added to its query.
+func (r *AuthorizationRequest) Redirect(params url.Values) string {
+	if r.State != "" {
+		params.Set("state", r.State)
+	}
+
+	redirect, err := url.Parse(r.RedirectURI)
+	if err != nil {
+		return r.RedirectURI
+	}
+	query := redirect.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+// ErrorRedirect returns the redirect URI reporting err to the client.
+func (r *AuthorizationRequest) ErrorRedirect(err *Error) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	return r.Redirect(params)
+}
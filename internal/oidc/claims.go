@@ -0,0 +1,83 @@
+package oidc
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"strings"
+	"user-management/internal/models"
+)
+
+// TokenTypeAccessToken is the typ header of access tokens, as in RFC 9068.
+const TokenTypeAccessToken = "at+jwt"
+
+// AccessTokenClaims are the claims of the access tokens issued to clients.
+// They are accepted by the userinfo endpoint, not by the API.
+type AccessTokenClaims struct {
+	jwt.RegisteredClaims
+	ClientID       string `json:"client_id"`
+	Scope          string `json:"scope"`
+	OrganizationID string `json:"org_id,omitempty"`
+	SessionID      string `json:"sid,omitempty"`
+}
+
+// IDTokenClaims are the claims of an ID token. The profile claims are
+// included as the granted scopes allow.
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Profile
+	Nonce           string   `json:"nonce,omitempty"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	AMR             []string `json:"amr,omitempty"`
+	SessionID       string   `json:"sid,omitempty"`
+	OrganizationID  string   `json:"org_id,omitempty"`
+}
+
+// Profile holds the standard claims about the user.
+type Profile struct {
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	UpdatedAt     int64  `json:"updated_at,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	PhoneNumber   string `json:"phone_number,omitempty"`
+}
+
+// UserInfo is the response of the userinfo endpoint.
+type UserInfo struct {
+	Subject string `json:"sub"`
+	Profile
+}
+
+// NewProfile returns the claims about user that scopes grant: the names
+// for profile, the address for email and the phone number for phone.
+func NewProfile(user *models.User, scopes []string) Profile {
+	var profile Profile
+	if slices.Contains(scopes, ScopeProfile) {
+		profile.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		profile.GivenName = user.FirstName
+		profile.FamilyName = user.LastName
+		if !user.UpdatedAt.IsZero() {
+			profile.UpdatedAt = user.UpdatedAt.Unix()
+		}
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		verified := user.EmailVerified
+		profile.Email = user.Email
+		profile.EmailVerified = &verified
+	}
+	if slices.Contains(scopes, ScopePhone) {
+		profile.PhoneNumber = models.StringValue(user.PhoneNumber)
+	}
+	return profile
+}
+
+// TokenResponse is the successful response of the token endpoint.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// DeviceAuthorizationPath is the device authorization endpoint of RFC 8628,
+// and DevicePath the verification URI users are asked to visit, relative to
+// the issuer.
+const (
+	DeviceAuthorizationPath = "/oauth/device_authorization"
+	DevicePath              = "/device"
+)
+
+// GrantTypeDeviceCode is the grant type devices poll the token endpoint
+// with.
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceSlowDown is how much the polling interval of a device code grows
+// each time its client polls too fast, as RFC 8628 section 3.5 requires.
+const DeviceSlowDown = 5 * time.Second
+
+// User codes are made of consonants, so that they neither spell words nor
+// contain characters that are easily mixed up, and shown as XXXX-XXXX.
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// DeviceAuthorizationResponse is the response of the device authorization
+// endpoint.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// NewUserCode returns a random user code in the form it is shown to users.
+func NewUserCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// NormalizeUserCode returns a user code as typed by a user in canonical
+// form, upper case and without separators, or false when it cannot be a
+// user code.
+func NormalizeUserCode(code string) (string, bool) {
+	var normalized strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		switch {
+		case r == '-' || r == ' ':
+			continue
+		case strings.ContainsRune(userCodeAlphabet, r):
+			normalized.WriteRune(r)
+		default:
+			return "", false
+		}
+	}
+	if normalized.Len() != userCodeLength {
+		return "", false
+	}
+	return normalized.String(), true
+}
@@ -0,0 +1,36 @@
+package oidc
+
+// Paths of the introspection (RFC 7662) and revocation (RFC 7009)
+// endpoints, relative to the issuer.
+const (
+	IntrospectionPath = "/oauth/introspect"
+	RevocationPath    = "/oauth/revoke"
+)
+
+// Values of the token_type_hint parameter. The hint is optional, as every
+// kind of token is recognized by its format.
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// Introspection is the response of the introspection endpoint. Inactive
+// tokens only have Active set, whatever the reason.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	TokenID   string   `json:"jti,omitempty"`
+	// OrganizationID, SessionID and Principal extend RFC 7662 with the
+	// claims of the same names in access tokens.
+	OrganizationID string `json:"org_id,omitempty"`
+	SessionID      string `json:"sid,omitempty"`
+	Principal      string `json:"principal,omitempty"`
+}
@@ -0,0 +1,273 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+)
+
+// SigningAlgorithms are the algorithms keys can be generated for.
+var SigningAlgorithms = []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}
+
+// JWK is the public half of a signing key in JSON Web Key format. RSA keys
+// use N and E, EC and OKP keys Crv and X, and EC keys Y.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is the key set served at JWKSPath.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Key returns the public key with the given ID.
+func (s *JWKS) Key(keyID string) (crypto.PublicKey, bool) {
+	for _, key := range s.Keys {
+		if key.KeyID == keyID {
+			publicKey, err := key.PublicKey()
+			return publicKey, err == nil
+		}
+	}
+	return nil, false
+}
+
+// NewJWK returns the JWK of an RSA, P-256 or Ed25519 public key.
+func NewJWK(keyID string, key crypto.PublicKey) (JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: AlgorithmRS256,
+			KeyID:     keyID,
+			N:         encode(key.N.Bytes()),
+			E:         encode(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return JWK{}, errors.New("unsupported curve")
+		}
+		raw, err := key.Bytes()
+		if err != nil {
+			return JWK{}, fmt.Errorf("invalid EC key: %w", err)
+		}
+		// raw is the uncompressed point: 0x04, X and Y of 32 bytes each.
+		return JWK{
+			KeyType:   "EC",
+			Use:       "sig",
+			Algorithm: AlgorithmES256,
+			KeyID:     keyID,
+			Curve:     "P-256",
+			X:         encode(raw[1:33]),
+			Y:         encode(raw[33:]),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			Use:       "sig",
+			Algorithm: AlgorithmEdDSA,
+			KeyID:     keyID,
+			Curve:     "Ed25519",
+			X:         encode(key),
+		}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != 32 {
+			return nil, errors.New("invalid x coordinate")
+		}
+		y, err := decode(k.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errors.New("invalid y coordinate")
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+		return key, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+// KeyID returns the RFC 7638 thumbprint of a public key, which is used as
+// its kid.
+func KeyID(key crypto.PublicKey) (string, error) {
+	jwk, err := NewJWK("", key)
+	if err != nil {
+		return "", err
+	}
+
+	// The required members in lexicographic order, as the thumbprint
+	// requires.
+	var members interface{}
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// SigningKey is a private key tokens are signed with.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Key       crypto.Signer
+}
+
+// GenerateSigningKey creates a key for one of SigningAlgorithms.
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	id, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, Algorithm: algorithm, Key: key}, nil
+}
+
+// Public returns the public half of the key.
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.Key.Public()
+}
+
+// Sign returns claims as a JWT signed with the key. typ sets the type
+// header when it is not empty.
+func (k *SigningKey) Sign(claims jwt.Claims, typ string) (string, error) {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.Algorithm), claims)
+	token.Header["kid"] = k.ID
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+
+	signed, err := token.SignedString(k.Key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// EncodePrivateKey returns the PKCS #8 PEM encoding of the key.
+func (k *SigningKey) EncodePrivateKey() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// DecodeSigningKey parses a key encoded by EncodePrivateKey and checks
+// that it suits algorithm.
+func DecodeSigningKey(id, algorithm, encoded string) (*SigningKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid signing key PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signing key: %w", err)
+	}
+
+	var ok bool
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		ok = algorithm == AlgorithmRS256
+	case *ecdsa.PrivateKey:
+		ok = algorithm == AlgorithmES256 && key.Curve == elliptic.P256()
+	case ed25519.PrivateKey:
+		ok = algorithm == AlgorithmEdDSA
+	}
+	if !ok {
+		return nil, fmt.Errorf("signing key is not a %s key", algorithm)
+	}
+	return &SigningKey{ID: id, Algorithm: algorithm, Key: parsed.(crypto.Signer)}, nil
+}
@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"crypto"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"strings"
+	"testing"
+	"user-management/internal/models"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	require.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallenge(verifier))
+	require.True(t, VerifyCodeChallenge(verifier, CodeChallenge(verifier)))
+	require.False(t, VerifyCodeChallenge(verifier, CodeChallenge(verifier+"x")))
+	require.False(t, VerifyCodeChallenge("short", CodeChallenge("short")))
+	require.False(t, VerifyCodeChallenge(strings.Repeat("a", 42)+"!", CodeChallenge(strings.Repeat("a", 42)+"!")))
+}
+
+func TestScopes(t *testing.T) {
+	scopes := ParseScope(" openid  email openid ")
+	require.Equal(t, []string{ScopeOpenID, ScopeEmail}, scopes)
+	require.Equal(t, "openid email", FormatScope(scopes))
+	require.True(t, Subset(scopes, SupportedScopes))
+	require.False(t, Subset([]string{"admin"}, SupportedScopes))
+	require.True(t, Subset(nil, nil))
+}
+
+func TestSigningKeyRoundTrip(t *testing.T) {
+	for _, algorithm := range SigningAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm)
+			require.NoError(t, err)
+
+			encoded, err := key.EncodePrivateKey()
+			require.NoError(t, err)
+			decoded, err := DecodeSigningKey(key.ID, algorithm, encoded)
+			require.NoError(t, err)
+			require.True(t, decoded.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()))
+			for _, other := range SigningAlgorithms {
+				if other != algorithm {
+					_, err = DecodeSigningKey(key.ID, other, encoded)
+					require.Error(t, err)
+				}
+			}
+
+			jwk, err := NewJWK(key.ID, key.Public())
+			require.NoError(t, err)
+			require.Equal(t, algorithm, jwk.Algorithm)
+			data, err := json.Marshal(JWKS{Keys: []JWK{jwk}})
+			require.NoError(t, err)
+			var jwks JWKS
+			require.NoError(t, json.Unmarshal(data, &jwks))
+			public, ok := jwks.Key(key.ID)
+			require.True(t, ok)
+			require.True(t, public.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()))
+			id, err := KeyID(public)
+			require.NoError(t, err)
+			require.Equal(t, key.ID, id)
+
+			token, err := key.Sign(jwt.RegisteredClaims{Subject: "s"}, TokenTypeAccessToken)
+			require.NoError(t, err)
+			claims := &jwt.RegisteredClaims{}
+			_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+				return public, nil
+			}, jwt.WithValidMethods([]string{algorithm}))
+			require.NoError(t, err)
+			require.Equal(t, "s", claims.Subject)
+
+			_, ok = jwks.Key("unknown")
+			require.False(t, ok)
+		})
+	}
+}
+
+func TestKeyIDThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1.
+	jwk := JWK{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+	}
+	public, err := jwk.PublicKey()
+	require.NoError(t, err)
+	id, err := KeyID(public)
+	require.NoError(t, err)
+	require.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", id)
+}
+
+func TestNewProfileFiltersByScope(t *testing.T) {
+	phone := "+15550100"
+	user := &models.User{Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace", PhoneNumber: &phone}
+
+	profile := NewProfile(user, []string{ScopeOpenID})
+	require.Empty(t, profile.Name)
+	require.Empty(t, profile.Email)
+	require.Nil(t, profile.EmailVerified)
+
+	profile = NewProfile(user, []string{ScopeOpenID, ScopeProfile, ScopeEmail})
+	require.Equal(t, "Ada Lovelace", profile.Name)
+	require.Equal(t, "ada@example.com", profile.Email)
+	require.False(t, *profile.EmailVerified)
+	require.Empty(t, profile.PhoneNumber)
+}
+
+func TestAuthorizationRequest(t *testing.T) {
+	req := ParseAuthorizationRequest(url.Values{
+		"response_type":         {ResponseTypeCode},
+		"client_id":             {"client"},
+		"redirect_uri":          {"https://rp.example/callback?tenant=1"},
+		"scope":                 {"openid"},
+		"state":                 {"a b"},
+		"code_challenge":        {CodeChallenge(strings.Repeat("v", 43))},
+		"code_challenge_method": {CodeChallengeMethodS256},
+		"prompt":                {"login consent"},
+	})
+	require.Nil(t, req.Validate())
+	require.True(t, req.HasPrompt(PromptConsent))
+	require.False(t, req.HasPrompt(PromptNone))
+
+	redirect, err := url.Parse(req.Redirect(url.Values{"code": {"c"}}))
+	require.NoError(t, err)
+	require.Equal(t, "1", redirect.Query().Get("tenant"))
+	require.Equal(t, "c", redirect.Query().Get("code"))
+	require.Equal(t, "a b", redirect.Query().Get("state"))
+
+	req.CodeChallengeMethod = "plain"
+	require.Equal(t, ErrorInvalidRequest, req.Validate().Code)
+	req.CodeChallengeMethod = CodeChallengeMethodS256
+	req.ResponseType = "token"
+	require.Equal(t, ErrorUnsupportedResponseType, req.Validate().Code)
+}
+
+func TestUserCode(t *testing.T) {
+	code, err := NewUserCode()
+	require.NoError(t, err)
+	require.Len(t, code, 9)
+	require.Equal(t, byte('-'), code[4])
+
+	normalized, ok := NormalizeUserCode(strings.ToLower(code))
+	require.True(t, ok)
+	require.Equal(t, strings.Replace(code, "-", "", 1), normalized)
+
+	normalized, ok = NormalizeUserCode(" bcdf ghjk ")
+	require.True(t, ok)
+	require.Equal(t, "BCDFGHJK", normalized)
+
+	_, ok = NormalizeUserCode("BCDF-GHJ")
+	require.False(t, ok)
+	_, ok = NormalizeUserCode("ABCD-EFGH")
+	require.False(t, ok, "vowels are not used")
+}
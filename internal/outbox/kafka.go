@@ -0,0 +1,98 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"user-management/internal/models"
+)
+
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// KafkaPublisher produces events through a Kafka REST Proxy (v2 API). Records
+// are keyed by aggregate ID so all events of an aggregate land on the same
+// partition and keep their order.
+type KafkaPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaPublisher creates a publisher for topic on the REST proxy at
+// baseURL. A client with a ten second timeout is used when client is nil.
+func NewKafkaPublisher(baseURL, topic string, client *http.Client) *KafkaPublisher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &KafkaPublisher{
+		url:    strings.TrimRight(baseURL, "/") + "/topics/" + url.PathEscape(topic),
+		client: client,
+	}
+}
+
+type kafkaRecord struct {
+	Key   string             `json:"key"`
+	Value models.DomainEvent `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition *int   `json:"partition"`
+		Offset    *int64 `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, event models.DomainEvent) error {
+	body, err := json.Marshal(kafkaProduceRequest{
+		Records: []kafkaRecord{{Key: event.AggregateType + ":" + event.AggregateID, Value: event}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create produce request: %w", err)
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to produce to Kafka: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result kafkaProduceResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if decodeErr == nil && result.Message != "" {
+			return fmt.Errorf("kafka proxy responded with status %d: %s", resp.StatusCode, result.Message)
+		}
+		return fmt.Errorf("kafka proxy responded with status %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to decode produce response: %w", decodeErr)
+	}
+
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil || offset.Error != "" {
+			return fmt.Errorf("kafka rejected record: %s", offset.Error)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"time"
+	"user-management/internal/models"
+)
+
+const natsFlushTimeout = 5 * time.Second
+
+// NATSPublisher publishes each event on "<prefix>.<event type>". The event ID
+// is sent as Nats-Msg-Id so JetStream streams drop redeliveries within their
+// duplicate window.
+type NATSPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func NewNATSPublisher(url, prefix string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("user-management-outbox"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &NATSPublisher{conn: conn, prefix: prefix}, nil
+}
+
+func (p *NATSPublisher) Subject(event models.DomainEvent) string {
+	if p.prefix == "" {
+		return event.Type
+	}
+	return p.prefix + "." + event.Type
+}
+
+// Publish returns once the server has received the message. Core NATS does
+// not persist messages, so subscribers that need every event should consume
+// the subject through a JetStream stream.
+func (p *NATSPublisher) Publish(ctx context.Context, event models.DomainEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	msg := nats.NewMsg(p.Subject(event))
+	msg.Data = data
+	msg.Header.Set(nats.MsgIdHdr, event.ID.String())
+
+	if err := p.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, natsFlushTimeout)
+		defer cancel()
+	}
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("failed to flush NATS connection: %w", err)
+	}
+
+	return nil
+}
+
+func (p *NATSPublisher) Close() {
+	p.conn.Close()
+}
@@ -0,0 +1,191 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+func testEvent(aggregateID string) models.DomainEvent {
+	return models.DomainEvent{
+		ID:            uuid.New(),
+		Type:          "user.updated",
+		AggregateType: "user",
+		AggregateID:   aggregateID,
+		Data:          json.RawMessage(`{"email":"a@example.com"}`),
+		OccurredAt:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	event := testEvent("u1")
+	var received models.DomainEvent
+	status := http.StatusAccepted
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, event.ID.String(), r.Header.Get(HeaderEventID))
+		require.Equal(t, "user.updated", r.Header.Get(HeaderEventType))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	publisher := NewWebhookPublisher(server.URL, nil)
+	require.NoError(t, publisher.Publish(context.Background(), event))
+	require.Equal(t, event.ID, received.ID)
+
+	status = http.StatusBadGateway
+	require.Error(t, publisher.Publish(context.Background(), event))
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	event := testEvent("u1")
+	var request struct {
+		Records []struct {
+			Key   string             `json:"key"`
+			Value models.DomainEvent `json:"value"`
+		} `json:"records"`
+	}
+	response := `{"offsets":[{"partition":0,"offset":42}]}`
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/topics/user-events", r.URL.Path)
+		require.Equal(t, kafkaContentType, r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		_, _ = io.WriteString(w, response)
+	}))
+	defer proxy.Close()
+
+	publisher := NewKafkaPublisher(proxy.URL+"/", "user-events", nil)
+	require.NoError(t, publisher.Publish(context.Background(), event))
+	require.Len(t, request.Records, 1)
+	require.Equal(t, "user:u1", request.Records[0].Key)
+	require.Equal(t, event.ID, request.Records[0].Value.ID)
+
+	response = `{"offsets":[{"partition":null,"offset":null,"error_code":50003,"error":"broker unavailable"}]}`
+	require.ErrorContains(t, publisher.Publish(context.Background(), event), "broker unavailable")
+}
+
+func TestNATSPublisher(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	server := natsserver.RunServer(&opts)
+	defer server.Shutdown()
+
+	subscriber, err := nats.Connect(server.ClientURL())
+	require.NoError(t, err)
+	defer subscriber.Close()
+	messages, err := subscriber.SubscribeSync("events.>")
+	require.NoError(t, err)
+	require.NoError(t, subscriber.Flush())
+
+	publisher, err := NewNATSPublisher(server.ClientURL(), "events")
+	require.NoError(t, err)
+	defer publisher.Close()
+
+	event := testEvent("u1")
+	require.NoError(t, publisher.Publish(context.Background(), event))
+
+	msg, err := messages.NextMsg(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "events.user.updated", msg.Subject)
+	require.Equal(t, event.ID.String(), msg.Header.Get(nats.MsgIdHdr))
+
+	var received models.DomainEvent
+	require.NoError(t, json.Unmarshal(msg.Data, &received))
+	require.Equal(t, event.ID, received.ID)
+}
+
+// fakeOutboxRepository mirrors the ordering rules of the Postgres
+// implementation: a failed event holds back the rest of its aggregate.
+type fakeOutboxRepository struct {
+	repository.OutboxRepository
+	pending []models.OutboxEvent
+}
+
+func (f *fakeOutboxRepository) ProcessPending(ctx context.Context, limit int, retryDelay func(int) time.Duration, handle repository.OutboxHandler) (int, error) {
+	published := 0
+	blocked := make(map[string]bool)
+	var remaining []models.OutboxEvent
+	for i, event := range f.pending {
+		if i >= limit || blocked[event.Event.AggregateID] {
+			remaining = append(remaining, event)
+			continue
+		}
+		if err := handle(ctx, event); err != nil {
+			event.Attempts++
+			blocked[event.Event.AggregateID] = true
+			remaining = append(remaining, event)
+			continue
+		}
+		published++
+	}
+	f.pending = remaining
+	return published, nil
+}
+
+func TestRelayKeepsAggregateOrder(t *testing.T) {
+	repo := &fakeOutboxRepository{}
+	for i, aggregate := range []string{"a", "b", "a", "b"} {
+		repo.pending = append(repo.pending, models.OutboxEvent{ID: int64(i + 1), Event: testEvent(aggregate)})
+	}
+	failA := repo.pending[0].Event.ID
+
+	publisher := &flakyPublisher{MemoryPublisher: NewMemoryPublisher(), fail: failA}
+	relay := NewRelay(repo, publisher, 10, 0)
+
+	published, err := relay.Relay(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, published)
+	require.Len(t, repo.pending, 2)
+
+	publisher.fail = uuid.Nil
+	published, err = relay.Relay(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, published)
+	require.Empty(t, repo.pending)
+
+	var order []string
+	for _, event := range publisher.Events() {
+		order = append(order, event.AggregateID)
+	}
+	require.Equal(t, []string{"b", "b", "a", "a"}, order)
+}
+
+type flakyPublisher struct {
+	*MemoryPublisher
+	fail uuid.UUID
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event models.DomainEvent) error {
+	if event.ID == p.fail {
+		return errors.New("unavailable")
+	}
+	return p.MemoryPublisher.Publish(ctx, event)
+}
+
+func TestMemoryPublisherFail(t *testing.T) {
+	publisher := NewMemoryPublisher()
+	publisher.Fail(errors.New("down"))
+	require.Error(t, publisher.Publish(context.Background(), testEvent("a")))
+	publisher.Fail(nil)
+	require.NoError(t, publisher.Publish(context.Background(), testEvent("a")))
+	require.Len(t, publisher.Events(), 1)
+}
+
+func TestRetryDelay(t *testing.T) {
+	require.Equal(t, time.Second, RetryDelay(1))
+	require.Equal(t, 8*time.Second, RetryDelay(4))
+	require.Equal(t, 10*time.Minute, RetryDelay(50))
+}
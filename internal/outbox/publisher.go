@@ -0,0 +1,84 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"user-management/internal/models"
+)
+
+// EventPublisher delivers a domain event to an external system. A nil error
+// means the system has accepted the event; any error causes it to be
+// retried, so delivery is at least once and consumers must deduplicate on
+// the event ID.
+type EventPublisher interface {
+	Publish(ctx context.Context, event models.DomainEvent) error
+}
+
+// NewPublisher creates the publisher named by kind: "memory", "webhook",
+// "nats" or "kafka". For "nats" target is the subject prefix and for
+// "kafka" the topic; it is ignored otherwise.
+func NewPublisher(kind, url, target string) (EventPublisher, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryPublisher(), nil
+	case "webhook":
+		return NewWebhookPublisher(url, nil), nil
+	case "nats":
+		return NewNATSPublisher(url, target)
+	case "kafka":
+		return NewKafkaPublisher(url, target, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown outbox publisher %q", kind)
+	}
+}
+
+// Fanout publishes each event to every publisher in order. An error from any
+// of them fails the whole publish, so the ones before it see the event again
+// when it is retried.
+type Fanout []EventPublisher
+
+func (f Fanout) Publish(ctx context.Context, event models.DomainEvent) error {
+	for _, publisher := range f {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryPublisher keeps published events in memory. It is meant for local
+// development and tests.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []models.DomainEvent
+	err    error
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, event models.DomainEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Fail makes every following Publish return err until it is called with nil.
+func (p *MemoryPublisher) Fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *MemoryPublisher) Events() []models.DomainEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]models.DomainEvent(nil), p.events...)
+}
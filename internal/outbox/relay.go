@@ -0,0 +1,90 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 10 * time.Minute
+)
+
+// RetryDelay returns how long to wait before the given attempt of a failed
+// event: one second doubling up to ten minutes.
+func RetryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// Relay moves committed events from the outbox to a publisher. Events are
+// marked published only after the publisher accepts them, so a crash in
+// between leads to a redelivery rather than a lost event.
+type Relay struct {
+	outbox    repository.OutboxRepository
+	publisher EventPublisher
+	batchSize int
+	retention time.Duration
+}
+
+// NewRelay creates a relay that processes up to batchSize events per pass
+// and deletes published events older than retention. A zero retention keeps
+// them forever.
+func NewRelay(outbox repository.OutboxRepository, publisher EventPublisher, batchSize int, retention time.Duration) *Relay {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		batchSize: batchSize,
+		retention: retention,
+	}
+}
+
+// Relay publishes pending events until the outbox is drained or a pass
+// publishes nothing, and returns how many were published.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		published, err := r.outbox.ProcessPending(ctx, r.batchSize, RetryDelay, func(ctx context.Context, event models.OutboxEvent) error {
+			return r.publisher.Publish(ctx, event.Event)
+		})
+		total += published
+		if err != nil || published < r.batchSize {
+			return total, err
+		}
+	}
+}
+
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Relay(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to relay outbox events: %v\n", err)
+		}
+
+		if r.retention > 0 {
+			if _, err := r.outbox.DeletePublished(ctx, time.Now().Add(-r.retention)); err != nil && ctx.Err() == nil {
+				log.Printf("failed to clean up outbox: %v\n", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
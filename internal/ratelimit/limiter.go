@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"math"
+	"time"
+)
+
+// Rate is a token bucket: it holds up to Burst tokens and refills Requests
+// tokens every Period. Each request takes one token.
+type Rate struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// perSecond is the refill rate in tokens per second.
+func (r Rate) perSecond() float64 {
+	return float64(r.Requests) / r.Period.Seconds()
+}
+
+func (r Rate) burst() int {
+	if r.Burst <= 0 {
+		return r.Requests
+	}
+	return r.Burst
+}
+
+// Result is the outcome of taking a token. RetryAfter is set when the
+// request is not allowed.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket of key.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// take applies the token bucket algorithm to a bucket holding tokens that
+// was last updated elapsed ago.
+func take(rate Rate, tokens float64, elapsed time.Duration) (float64, Result) {
+	burst := float64(rate.burst())
+	tokens = math.Min(burst, tokens+elapsed.Seconds()*rate.perSecond())
+
+	result := Result{Limit: rate.burst()}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - tokens) / rate.perSecond() * float64(time.Second)))
+	}
+	result.Remaining = int(tokens)
+	return tokens, result
+}
+
+// Fallback uses Primary and switches to Secondary for requests where Primary
+// fails, so that an unavailable Redis neither blocks nor unthrottles
+// traffic.
+type Fallback struct {
+	Primary   Limiter
+	Secondary Limiter
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string) (Result, error) {
+	result, err := f.Primary.Allow(ctx, key)
+	if err == nil {
+		return result, nil
+	}
+	log.Printf("rate limiter falling back to memory: %v\n", err)
+	return f.Secondary.Allow(ctx, key)
+}
+
+// New returns a limiter backed by Redis that falls back to memory, or a
+// memory limiter when client is nil.
+func New(client *redis.Client, name string, rate Rate) Limiter {
+	memory := NewMemoryLimiter(rate)
+	if client == nil {
+		return memory
+	}
+	return &Fallback{Primary: NewRedisLimiter(client, name, rate), Secondary: memory}
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterRefillsTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := NewMemoryLimiter(Rate{Requests: 1, Period: time.Second, Burst: 2})
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "a")
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+	}
+	result, err := limiter.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, time.Second, result.RetryAfter)
+
+	result, err = limiter.Allow(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, result.Allowed, "keys have separate buckets")
+
+	now = now.Add(500 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+
+	now = now.Add(time.Minute)
+	_, err = limiter.Allow(ctx, "c")
+	require.NoError(t, err)
+	require.Len(t, limiter.buckets, 1, "refilled buckets are dropped")
+}
+
+func TestRedisLimiterSharesBuckets(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	rate := Rate{Requests: 2, Period: time.Minute}
+	first := NewRedisLimiter(client, "login", rate)
+	second := NewRedisLimiter(client, "login", rate)
+
+	result, err := first.Allow(ctx, "1.2.3.4")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 1, result.Remaining)
+
+	result, err = second.Allow(ctx, "1.2.3.4")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	result, err = first.Allow(ctx, "1.2.3.4")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.InDelta(t, 30*time.Second, result.RetryAfter, float64(time.Second))
+
+	result, err = NewRedisLimiter(client, "api", rate).Allow(ctx, "1.2.3.4")
+	require.NoError(t, err)
+	require.True(t, result.Allowed, "limiters are namespaced by name")
+
+	require.Greater(t, mr.TTL("ratelimit:login:1.2.3.4"), time.Duration(0))
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string) (Result, error) {
+	return Result{}, errors.New("connection refused")
+}
+
+func TestFallbackUsesSecondaryOnError(t *testing.T) {
+	limiter := &Fallback{
+		Primary:   failingLimiter{},
+		Secondary: NewMemoryLimiter(Rate{Requests: 1, Period: time.Minute}),
+	}
+
+	result, err := limiter.Allow(context.Background(), "a")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	result, err = limiter.Allow(context.Background(), "a")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryLimiter keeps buckets in process memory. Limits are per instance,
+// so it is used on its own only for single instance deployments and as the
+// fallback of RedisLimiter.
+type MemoryLimiter struct {
+	rate    Rate
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func NewMemoryLimiter(rate Rate) *MemoryLimiter {
+	return &MemoryLimiter{
+		rate:    rate,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, key string) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.burst()), updated: now}
+		l.buckets[key] = b
+	}
+
+	var result Result
+	b.tokens, result = take(l.rate, b.tokens, now.Sub(b.updated))
+	b.updated = now
+	return result, nil
+}
+
+// sweep drops buckets that have refilled completely, at most once per
+// period, so that memory is bounded by the keys seen within one.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	full := time.Duration(float64(l.rate.burst()) / l.rate.perSecond() * float64(time.Second))
+	if now.Sub(l.swept) < full {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
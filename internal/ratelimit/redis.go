@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const keyPrefix = "ratelimit:"
+
+// tokenBucketScript takes a token from the bucket in KEYS[1] using the
+// clock of the Redis server, so that instances with skewed clocks share
+// buckets correctly. ARGV is the refill rate in tokens per second and the
+// burst. It returns whether the request is allowed, the remaining tokens
+// and, when not allowed, the milliseconds until a token is available.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil then
+	tokens = burst
+	updated = now
+end
+
+tokens = math.min(burst, tokens + (now - updated) / 1000 * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, math.floor(tokens), retry}
+`)
+
+// RedisLimiter keeps buckets in Redis so that limits hold across instances.
+type RedisLimiter struct {
+	client *redis.Client
+	rate   Rate
+	name   string
+}
+
+// NewRedisLimiter creates a limiter whose keys are namespaced by name, so
+// that limiters with different rates do not share buckets.
+func NewRedisLimiter(client *redis.Client, name string, rate Rate) *RedisLimiter {
+	return &RedisLimiter{client: client, rate: rate, name: name}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	values, err := tokenBucketScript.Run(ctx, l.client,
+		[]string{keyPrefix + l.name + ":" + key},
+		l.rate.perSecond(), l.rate.burst(),
+	).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      l.rate.burst(),
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
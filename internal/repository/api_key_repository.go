@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+const apiKeyColumns = `k.id, k.user_id, k.organization_id, k.name, k.prefix, k.key_hash, k.scopes, k.allowed_ips,
+			k.expires_at, k.last_used_at, k.last_used_ip, k.created_at, u.is_active`
+
+const apiKeyFrom = `FROM api_keys k INNER JOIN users u ON u.id = k.user_id`
+
+type apiKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(db *pgxpool.Pool) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	if key.AllowedIPs == nil {
+		key.AllowedIPs = []string{}
+	}
+	key.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO api_keys (id, user_id, organization_id, name, prefix, key_hash, scopes, allowed_ips, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			key.ID,
+			key.UserID,
+			key.OrganizationID,
+			key.Name,
+			key.Prefix,
+			key.KeyHash,
+			key.Scopes,
+			key.AllowedIPs,
+			key.ExpiresAt,
+			key.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create api key: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionAPIKeyCreated, audit.TargetAPIKey, key.ID.String(), key.OrganizationID, nil, key)
+	})
+}
+
+func (r *apiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " " + apiKeyFrom + " WHERE k.prefix = $1"
+	return scanAPIKey(r.db.QueryRow(ctx, query, prefix))
+}
+
+// ListByUser returns the keys of a user, newest first.
+func (r *apiKeyRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " " + apiKeyFrom + " WHERE k.user_id = $1 ORDER BY k.created_at DESC"
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Touch records that a key was used. It is not audited.
+func (r *apiKeyRepository) Touch(ctx context.Context, id uuid.UUID, lastUsedAt time.Time, ip string) error {
+	_, err := r.db.Exec(ctx,
+		"UPDATE api_keys SET last_used_at = $2, last_used_ip = $3 WHERE id = $1",
+		id, lastUsedAt, ip)
+	if err != nil {
+		return fmt.Errorf("failed to update api key: %w", err)
+	}
+	return nil
+}
+
+// Delete revokes a key of the user.
+func (r *apiKeyRepository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	query := "DELETE FROM api_keys k USING users u WHERE u.id = k.user_id AND k.id = $1 AND k.user_id = $2 RETURNING " + apiKeyColumns
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := scanAPIKey(tx.QueryRow(ctx, query, id, userID))
+		if err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionAPIKeyRevoked, audit.TargetAPIKey, id.String(), before.OrganizationID, before, nil)
+	})
+}
+
+// DeleteAll revokes every key of the user and returns how many there were.
+func (r *apiKeyRepository) DeleteAll(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := "DELETE FROM api_keys k USING users u WHERE u.id = k.user_id AND k.user_id = $1 RETURNING " + apiKeyColumns
+
+	var deleted int
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, query, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete api keys: %w", err)
+		}
+		keys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*models.APIKey, error) {
+			return scanAPIKey(row)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			err := recordChange(ctx, tx, audit.ActionAPIKeyRevoked, audit.TargetAPIKey, key.ID.String(), key.OrganizationID, key, nil)
+			if err != nil {
+				return err
+			}
+		}
+		deleted = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.OrganizationID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&key.Scopes,
+		&key.AllowedIPs,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+		&key.CreatedAt,
+		&key.UserActive,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return key, nil
+}
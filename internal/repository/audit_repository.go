@@ -0,0 +1,432 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strconv"
+	"strings"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx so that helpers can
+// run inside or outside a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type AuditFilter struct {
+	OrganizationID *uuid.UUID
+	ActorID        *uuid.UUID
+	Action         string
+	TargetType     string
+	TargetID       string
+	From           *time.Time
+	To             *time.Time
+	// BeforeID restricts results to events older than the given ID. Events
+	// are returned newest first, so the ID of the last event of a page is
+	// the cursor for the next one.
+	BeforeID int64
+	// AfterID restricts results to events newer than the given ID. It is
+	// used with Ascending to stream events oldest first.
+	AfterID   int64
+	Ascending bool
+	Limit     int
+}
+
+type auditRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditRepository(db *pgxpool.Pool) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		return insertAuditEvent(ctx, tx, event)
+	})
+}
+
+func (r *auditRepository) List(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.OrganizationID != nil {
+		addCondition("organization_id = $%d", *filter.OrganizationID)
+	}
+	if filter.ActorID != nil {
+		addCondition("actor_id = $%d", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.TargetType != "" {
+		addCondition("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		addCondition("target_id = $%d", filter.TargetID)
+	}
+	if filter.From != nil {
+		addCondition("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("created_at < $%d", *filter.To)
+	}
+	if filter.BeforeID > 0 {
+		addCondition("id < $%d", filter.BeforeID)
+	}
+	if filter.AfterID > 0 {
+		addCondition("id > $%d", filter.AfterID)
+	}
+
+	query := `
+		SELECT id, organization_id, actor_id, action, target_type, target_id,
+		       changes, ip_address, user_agent, prev_hash, hash, created_at
+		FROM audit_events
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id %s LIMIT $%d", order, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	return collectAuditEvents(rows)
+}
+
+// ListAfter returns events of every organization with an ID greater than
+// afterID in ascending order, which is the order their chains were built in.
+func (r *auditRepository) ListAfter(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error) {
+	query := `
+		SELECT id, organization_id, actor_id, action, target_type, target_id,
+		       changes, ip_address, user_agent, prev_hash, hash, created_at
+		FROM audit_events
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	return collectAuditEvents(rows)
+}
+
+// ChainHeads returns the latest event of every chain that has grown since
+// its last checkpoint.
+func (r *auditRepository) ChainHeads(ctx context.Context) ([]models.AuditEvent, error) {
+	query := `
+		SELECT DISTINCT ON (e.organization_id)
+		       e.id, e.organization_id, e.actor_id, e.action, e.target_type, e.target_id,
+		       e.changes, e.ip_address, e.user_agent, e.prev_hash, e.hash, e.created_at
+		FROM audit_events e
+		WHERE e.hash <> ''
+		  AND e.id > COALESCE((
+		      SELECT MAX(c.event_id) FROM audit_checkpoints c
+		      WHERE c.organization_id IS NOT DISTINCT FROM e.organization_id
+		  ), 0)
+		ORDER BY e.organization_id, e.id DESC
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit chain heads: %w", err)
+	}
+
+	return collectAuditEvents(rows)
+}
+
+func (r *auditRepository) CreateCheckpoint(ctx context.Context, checkpoint *models.AuditCheckpoint) error {
+	if checkpoint.CreatedAt.IsZero() {
+		checkpoint.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO audit_checkpoints (organization_id, event_id, hash, signature, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := r.db.QueryRow(ctx, query,
+		checkpoint.OrganizationID,
+		checkpoint.EventID,
+		checkpoint.Hash,
+		checkpoint.Signature,
+		checkpoint.CreatedAt,
+	).Scan(&checkpoint.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to create audit checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+func (r *auditRepository) ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error) {
+	query := `
+		SELECT id, organization_id, event_id, hash, signature, created_at
+		FROM audit_checkpoints
+		ORDER BY id
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var checkpoints []models.AuditCheckpoint
+	for rows.Next() {
+		var checkpoint models.AuditCheckpoint
+		err := rows.Scan(
+			&checkpoint.ID,
+			&checkpoint.OrganizationID,
+			&checkpoint.EventID,
+			&checkpoint.Hash,
+			&checkpoint.Signature,
+			&checkpoint.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit checkpoint: %w", err)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate audit checkpoints: %w", err)
+	}
+
+	return checkpoints, nil
+}
+
+// ListForExport returns events after cursor in export order, and the cursor
+// of the last. Only events of transactions older than every transaction
+// still in progress are returned: those are all committed or rolled back,
+// and transactions committing later have higher transaction IDs, so no
+// event is passed over.
+func (r *auditRepository) ListForExport(ctx context.Context, after models.AuditExportCursor, limit int) ([]models.AuditEvent, models.AuditExportCursor, error) {
+	query := `
+		SELECT id, organization_id, actor_id, action, target_type, target_id,
+		       changes, ip_address, user_agent, prev_hash, hash, created_at,
+		       transaction_id::text
+		FROM audit_events
+		WHERE (transaction_id, id) > ($1::text::xid8, $2)
+		  AND transaction_id < pg_snapshot_xmin(pg_current_snapshot())
+		ORDER BY transaction_id, id
+		LIMIT $3
+	`
+
+	rows, err := r.db.Query(ctx, query, strconv.FormatUint(after.TransactionID, 10), after.EventID, limit)
+	if err != nil {
+		return nil, after, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	cursor := after
+	for rows.Next() {
+		var event models.AuditEvent
+		var transactionID string
+		if err := rows.Scan(append(auditEventFields(&event), &transactionID)...); err != nil {
+			return nil, after, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		cursor.TransactionID, err = strconv.ParseUint(transactionID, 10, 64)
+		if err != nil {
+			return nil, after, fmt.Errorf("invalid transaction ID %q: %w", transactionID, err)
+		}
+		cursor.EventID = event.ID
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, after, fmt.Errorf("failed to iterate audit events: %w", err)
+	}
+
+	return events, cursor, nil
+}
+
+func (r *auditRepository) GetExportCursor(ctx context.Context, name string) (models.AuditExportCursor, error) {
+	var cursor models.AuditExportCursor
+	var transactionID string
+	err := r.db.QueryRow(ctx, "SELECT last_transaction_id::text, last_event_id FROM audit_export_cursors WHERE name = $1", name).
+		Scan(&transactionID, &cursor.EventID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return cursor, nil
+		}
+		return cursor, fmt.Errorf("failed to get export cursor: %w", err)
+	}
+
+	cursor.TransactionID, err = strconv.ParseUint(transactionID, 10, 64)
+	if err != nil {
+		return cursor, fmt.Errorf("invalid transaction ID %q: %w", transactionID, err)
+	}
+	return cursor, nil
+}
+
+func (r *auditRepository) SaveExportCursor(ctx context.Context, name string, cursor models.AuditExportCursor) error {
+	query := `
+		INSERT INTO audit_export_cursors (name, last_transaction_id, last_event_id, updated_at)
+		VALUES ($1, $2::text::xid8, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET
+			last_transaction_id = EXCLUDED.last_transaction_id,
+			last_event_id = EXCLUDED.last_event_id,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	if _, err := r.db.Exec(ctx, query, name, strconv.FormatUint(cursor.TransactionID, 10), cursor.EventID, time.Now()); err != nil {
+		return fmt.Errorf("failed to save export cursor: %w", err)
+	}
+
+	return nil
+}
+
+func collectAuditEvents(rows pgx.Rows) ([]models.AuditEvent, error) {
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := rows.Scan(auditEventFields(&event)...); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+// auditEventFields returns the scan destinations of the columns of an audit
+// event, in the order the queries select them.
+func auditEventFields(event *models.AuditEvent) []any {
+	return []any{
+		&event.ID,
+		&event.OrganizationID,
+		&event.ActorID,
+		&event.Action,
+		&event.TargetType,
+		&event.TargetID,
+		&event.Changes,
+		&event.IPAddress,
+		&event.UserAgent,
+		&event.PrevHash,
+		&event.Hash,
+		&event.CreatedAt,
+	}
+}
+
+// insertAuditEvent appends event to the hash chain of its organization. It
+// must run inside a transaction: the advisory lock serialises appends to a
+// chain until commit, so every event links to the one committed before it.
+func insertAuditEvent(ctx context.Context, tx pgx.Tx, event *models.AuditEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	event.CreatedAt = audit.NormalizeTime(event.CreatedAt)
+
+	changes, err := audit.CanonicalJSON(event.Changes)
+	if err != nil {
+		return err
+	}
+	event.Changes = changes
+
+	chain := audit.ChainKey(event.OrganizationID)
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtextextended($1, 0))", "audit:"+chain); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT hash FROM audit_events
+		WHERE organization_id IS NOT DISTINCT FROM $1
+		ORDER BY id DESC
+		LIMIT 1
+	`, event.OrganizationID).Scan(&event.PrevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	event.Hash, err = audit.ComputeHash(event.PrevHash, event)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_events (organization_id, actor_id, action, target_type, target_id,
+		                          changes, ip_address, user_agent, prev_hash, hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`
+
+	err = tx.QueryRow(ctx, query,
+		event.OrganizationID,
+		event.ActorID,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		event.Changes,
+		event.IPAddress,
+		event.UserAgent,
+		event.PrevHash,
+		event.Hash,
+		event.CreatedAt,
+	).Scan(&event.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// recordAudit writes an audit event for a mutation performed by the actor
+// in ctx. When organizationID is uuid.Nil the event belongs to no
+// organization, as for changes users make to their own accounts.
+func recordAudit(ctx context.Context, tx pgx.Tx, action, targetType, targetID string, organizationID uuid.UUID, before, after interface{}) error {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		return err
+	}
+
+	actor := audit.ActorFrom(ctx)
+	event := &models.AuditEvent{
+		ActorID:    actor.UserID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    changes,
+		IPAddress:  actor.IPAddress,
+		UserAgent:  actor.UserAgent,
+	}
+	if organizationID != uuid.Nil {
+		event.OrganizationID = models.UUIDPtr(organizationID)
+	}
+
+	return insertAuditEvent(ctx, tx, event)
+}
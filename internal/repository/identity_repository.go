@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityExists is returned when the account at the provider is
+	// already linked, to the same user or another.
+	ErrIdentityExists = errors.New("identity is already linked")
+	// ErrEmailTaken is returned when an account with the email address of
+	// a new user already exists.
+	ErrEmailTaken = errors.New("email address is already taken")
+	// ErrFederationStateNotFound is returned for unknown, expired and
+	// already used states.
+	ErrFederationStateNotFound = errors.New("federation state not found or expired")
+)
+
+type identityRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentityRepository(db *pgxpool.Pool) IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+// Create links an identity to an existing user.
+func (r *identityRepository) Create(ctx context.Context, identity *models.Identity) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		return insertIdentity(ctx, tx, identity)
+	})
+}
+
+// CreateWithUser creates a user together with the identity they first
+// logged in with.
+func (r *identityRepository) CreateWithUser(ctx context.Context, user *models.User, identity *models.Identity) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := insertUser(ctx, tx, user, uuid.Nil); err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return insertIdentity(ctx, tx, identity)
+	})
+}
+
+// insertUser creates a user without a membership. When organizationID is
+// set, the user is audited for it and recorded as provisioned by it.
+func insertUser(ctx context.Context, tx pgx.Tx, user *models.User, organizationID uuid.UUID) error {
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	now := time.Now()
+	user.IsActive = true
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	var provisionedBy *uuid.UUID
+	if organizationID != uuid.Nil {
+		provisionedBy = &organizationID
+	}
+
+	query := `
+		INSERT INTO users (id, email, password, first_name, last_name, bio, phone_number, email_verified, is_active,
+		                   created_at, updated_at, provisioned_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	_, err := tx.Exec(ctx, query,
+		user.ID,
+		user.Email,
+		user.Password,
+		user.FirstName,
+		user.LastName,
+		user.Bio,
+		user.PhoneNumber,
+		user.EmailVerified,
+		user.IsActive,
+		user.CreatedAt,
+		user.UpdatedAt,
+		provisionedBy,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return recordChange(ctx, tx, audit.ActionUserCreated, audit.TargetUser, user.ID.String(), organizationID, nil, user)
+}
+
+func insertIdentity(ctx context.Context, tx pgx.Tx, identity *models.Identity) error {
+	if identity.ID == uuid.Nil {
+		identity.ID = uuid.New()
+	}
+	identity.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO identities (id, user_id, provider, subject, email, last_login_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := tx.Exec(ctx, query,
+		identity.ID,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+		identity.LastLoginAt,
+		identity.CreatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrIdentityExists
+		}
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	return recordChange(ctx, tx, audit.ActionIdentityLinked, audit.TargetIdentity, identity.ID.String(), uuid.Nil, nil, identity)
+}
+
+func (r *identityRepository) GetBySubject(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	query := `
+		SELECT ` + identityColumns + `
+		FROM identities
+		WHERE provider = $1 AND subject = $2
+	`
+
+	return scanIdentity(r.db.QueryRow(ctx, query, provider, subject))
+}
+
+func (r *identityRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Identity, error) {
+	query := `
+		SELECT ` + identityColumns + `
+		FROM identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []models.Identity
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+// RecordLogin stores the time of a login with an identity and the email
+// address the provider asserted with it.
+func (r *identityRepository) RecordLogin(ctx context.Context, id uuid.UUID, email string) error {
+	query := `
+		UPDATE identities
+		SET email = $2, last_login_at = $3
+		WHERE id = $1
+	`
+
+	result, err := r.db.Exec(ctx, query, id, email, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (r *identityRepository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	query := `
+		DELETE FROM identities
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + identityColumns
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		identity, err := scanIdentity(tx.QueryRow(ctx, query, id, userID))
+		if err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionIdentityUnlinked, audit.TargetIdentity, id.String(), uuid.Nil, identity, nil)
+	})
+}
+
+// SaveState stores an authorization request and removes expired ones.
+func (r *identityRepository) SaveState(ctx context.Context, state *models.FederationState) error {
+	query := `
+		INSERT INTO federation_states (state_hash, provider, purpose, nonce, code_verifier, user_id, organization_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		now := time.Now()
+		if _, err := tx.Exec(ctx, "DELETE FROM federation_states WHERE expires_at < $1", now); err != nil {
+			return fmt.Errorf("failed to delete expired federation states: %w", err)
+		}
+
+		_, err := tx.Exec(ctx, query,
+			state.StateHash,
+			state.Provider,
+			state.Purpose,
+			state.Nonce,
+			state.CodeVerifier,
+			state.UserID,
+			state.OrganizationID,
+			state.ExpiresAt,
+			now,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save federation state: %w", err)
+		}
+		return nil
+	})
+}
+
+// ConsumeState deletes and returns the unexpired state with the given hash
+// and purpose, so that each state is used only once.
+func (r *identityRepository) ConsumeState(ctx context.Context, stateHash, purpose string) (*models.FederationState, error) {
+	query := `
+		DELETE FROM federation_states
+		WHERE state_hash = $1 AND purpose = $2 AND expires_at > $3
+		RETURNING state_hash, provider, purpose, nonce, code_verifier, user_id, organization_id, expires_at
+	`
+
+	var state models.FederationState
+	err := r.db.QueryRow(ctx, query, stateHash, purpose, time.Now()).Scan(
+		&state.StateHash,
+		&state.Provider,
+		&state.Purpose,
+		&state.Nonce,
+		&state.CodeVerifier,
+		&state.UserID,
+		&state.OrganizationID,
+		&state.ExpiresAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrFederationStateNotFound
+		}
+		return nil, fmt.Errorf("failed to get federation state: %w", err)
+	}
+
+	return &state, nil
+}
+
+const identityColumns = `id, user_id, provider, subject, email, last_login_at, created_at`
+
+func scanIdentity(row pgx.Row) (*models.Identity, error) {
+	identity := &models.Identity{}
+	err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.LastLoginAt,
+		&identity.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	return identity, nil
+}
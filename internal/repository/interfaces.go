@@ -3,7 +3,9 @@ package repository
 import (
 	"context"
 	"github.com/google/uuid"
+	"time"
 	"user-management/internal/models"
+	"user-management/internal/scim"
 )
 
 type UserRepository interface {
@@ -13,6 +15,8 @@ type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id uuid.UUID) error
+	SetPassword(ctx context.Context, id uuid.UUID, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, id uuid.UUID, email string) error
 }
 
 type RoleRepository interface {
@@ -42,7 +46,196 @@ type UserRoleRepository interface {
 	RemoveRole(ctx context.Context, userID, roleID, organizationID uuid.UUID) error
 	GetUserRoles(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Role, error)
 	GetRoleUsers(ctx context.Context, roleID uuid.UUID) ([]models.User, error)
+	// GetRoleHolderIDs returns every user holding the role, including
+	// deactivated ones, for invalidating what is cached about them.
+	GetRoleHolderIDs(ctx context.Context, roleID uuid.UUID) ([]uuid.UUID, error)
 	GetUserPermissions(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, error)
 	HasPermission(ctx context.Context, userID uuid.UUID, organizationID uuid.UUID, permission string) (bool, error)
 	ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]models.Organization, error)
 }
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *models.AuditEvent) error
+	List(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, error)
+	ListAfter(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error)
+	ChainHeads(ctx context.Context) ([]models.AuditEvent, error)
+	CreateCheckpoint(ctx context.Context, checkpoint *models.AuditCheckpoint) error
+	ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error)
+	ListForExport(ctx context.Context, after models.AuditExportCursor, limit int) ([]models.AuditEvent, models.AuditExportCursor, error)
+	GetExportCursor(ctx context.Context, name string) (models.AuditExportCursor, error)
+	SaveExportCursor(ctx context.Context, name string, cursor models.AuditExportCursor) error
+}
+
+type OutboxRepository interface {
+	ProcessPending(ctx context.Context, limit int, retryDelay func(attempts int) time.Duration, handle OutboxHandler) (int, error)
+	DeletePublished(ctx context.Context, before time.Time) (int64, error)
+}
+
+type WebhookRepository interface {
+	Create(ctx context.Context, subscription *models.WebhookSubscription) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error)
+	List(ctx context.Context, organizationID uuid.UUID) ([]models.WebhookSubscription, error)
+	ListActive(ctx context.Context, organizationID uuid.UUID) ([]models.WebhookSubscription, error)
+	Update(ctx context.Context, subscription *models.WebhookSubscription) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	CompleteDelivery(ctx context.Context, delivery *models.WebhookDelivery, disableAfter int) (bool, error)
+	ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error)
+}
+
+type SCIMRepository interface {
+	CreateToken(ctx context.Context, token *models.SCIMToken) error
+	ListTokens(ctx context.Context, organizationID uuid.UUID) ([]models.SCIMToken, error)
+	RevokeToken(ctx context.Context, organizationID, id uuid.UUID) error
+	AuthenticateToken(ctx context.Context, tokenHash string) (*models.SCIMToken, error)
+	ListUsers(ctx context.Context, organizationID uuid.UUID, filter scim.Expr, offset, limit int) ([]models.SCIMUser, int, error)
+	GetUser(ctx context.Context, organizationID, id uuid.UUID) (*models.SCIMUser, error)
+	CreateUser(ctx context.Context, member *models.SCIMUser) error
+	UpdateUser(ctx context.Context, member *models.SCIMUser) error
+	DeleteUser(ctx context.Context, organizationID, id uuid.UUID) error
+	ListGroups(ctx context.Context, organizationID uuid.UUID, filter scim.Expr, offset, limit int, withMembers bool) ([]models.SCIMGroup, int, error)
+	GetGroup(ctx context.Context, organizationID, id uuid.UUID) (*models.SCIMGroup, error)
+	CreateGroup(ctx context.Context, group *models.SCIMGroup) error
+	UpdateGroup(ctx context.Context, group *models.SCIMGroup) error
+	DeleteGroup(ctx context.Context, organizationID, id uuid.UUID) error
+}
+
+type MFARepository interface {
+	Get(ctx context.Context, userID uuid.UUID) (*models.UserMFA, error)
+	SavePendingSecret(ctx context.Context, userID uuid.UUID, secret string) error
+	Enable(ctx context.Context, userID uuid.UUID, step int64, codeHashes []string) error
+	UseStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error)
+	Disable(ctx context.Context, userID uuid.UUID) error
+	GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.MFAPolicy, error)
+	SetPolicy(ctx context.Context, policy *models.MFAPolicy) error
+	IsRequired(ctx context.Context, userID, organizationID uuid.UUID) (bool, error)
+}
+
+type WebAuthnRepository interface {
+	CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	ListCredentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	GetCredential(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error)
+	UpdateCredentialUse(ctx context.Context, credential *models.WebAuthnCredential) error
+	DeleteCredential(ctx context.Context, userID, id uuid.UUID) error
+	SaveSession(ctx context.Context, session *models.WebAuthnSession) error
+	ConsumeSession(ctx context.Context, id uuid.UUID, ceremony string) (*models.WebAuthnSession, error)
+}
+
+type IdentityRepository interface {
+	Create(ctx context.Context, identity *models.Identity) error
+	CreateWithUser(ctx context.Context, user *models.User, identity *models.Identity) error
+	GetBySubject(ctx context.Context, provider, subject string) (*models.Identity, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Identity, error)
+	RecordLogin(ctx context.Context, id uuid.UUID, email string) error
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	SaveState(ctx context.Context, state *models.FederationState) error
+	ConsumeState(ctx context.Context, stateHash, purpose string) (*models.FederationState, error)
+}
+
+type SAMLRepository interface {
+	GetConnection(ctx context.Context, organizationID uuid.UUID) (*models.SAMLConnection, error)
+	SaveConnection(ctx context.Context, connection *models.SAMLConnection) error
+	DeleteConnection(ctx context.Context, organizationID uuid.UUID) error
+	UseAssertion(ctx context.Context, organizationID uuid.UUID, assertionID string, expiresAt time.Time) error
+	Provision(ctx context.Context, organizationID uuid.UUID, user *models.User, identity *models.Identity, groups []string) error
+}
+
+type UserTokenRepository interface {
+	Create(ctx context.Context, token *models.UserToken) error
+	Consume(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error)
+}
+
+type PasswordlessRepository interface {
+	Create(ctx context.Context, challenge *models.PasswordlessChallenge, since time.Time, maxMails int) error
+	Attempt(ctx context.Context, deviceHash string, maxAttempts int) (*models.PasswordlessChallenge, error)
+	Consume(ctx context.Context, id uuid.UUID) error
+}
+
+type LoginPolicyRepository interface {
+	GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.LoginPolicy, error)
+	SetPolicy(ctx context.Context, policy *models.LoginPolicy) error
+}
+
+type LoginAttemptRepository interface {
+	List(ctx context.Context, keys []string) ([]models.LoginAttempt, error)
+	RecordFailure(ctx context.Context, key string, userID *uuid.UUID, failuresSince, lockoutsSince time.Time) (*models.LoginAttempt, error)
+	Lock(ctx context.Context, key string, until time.Time) (*models.LoginAttempt, error)
+	Reset(ctx context.Context, key string) error
+	Unlock(ctx context.Context, userID uuid.UUID) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *models.Session) ([]uuid.UUID, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error)
+	ListActive(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	Rotate(ctx context.Context, session *models.Session, previousHash string) error
+	Touch(ctx context.Context, id uuid.UUID, lastSeenAt time.Time, ipAddress string) error
+	Revoke(ctx context.Context, userID, id uuid.UUID) error
+	RevokeAll(ctx context.Context, userID, except uuid.UUID) ([]uuid.UUID, error)
+	RevokeClient(ctx context.Context, clientID, userID uuid.UUID) ([]uuid.UUID, error)
+	GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.SessionPolicy, error)
+	SetPolicy(ctx context.Context, policy *models.SessionPolicy) error
+}
+
+type OAuthRepository interface {
+	CreateClient(ctx context.Context, client *models.OAuthClient) error
+	GetClient(ctx context.Context, id uuid.UUID) (*models.OAuthClient, error)
+	ListClients(ctx context.Context, organizationID uuid.UUID) ([]models.OAuthClient, error)
+	UpdateClient(ctx context.Context, client *models.OAuthClient) error
+	DeleteClient(ctx context.Context, id uuid.UUID) error
+	GetConsent(ctx context.Context, userID, clientID uuid.UUID) (*models.OAuthConsent, error)
+	ListConsents(ctx context.Context, userID uuid.UUID) ([]models.OAuthConsent, error)
+	SaveConsent(ctx context.Context, consent *models.OAuthConsent) error
+	DeleteConsent(ctx context.Context, userID, clientID uuid.UUID) error
+	CreateAuthorizationCode(ctx context.Context, code *models.OAuthAuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string, sessionID uuid.UUID) (*models.OAuthAuthorizationCode, error)
+	CreateDeviceCode(ctx context.Context, code *models.OAuthDeviceCode) error
+	GetDeviceCode(ctx context.Context, userCodeHash string) (*models.OAuthDeviceCode, error)
+	DecideDeviceCode(ctx context.Context, userCodeHash string, userID uuid.UUID, amr []string, approved bool) (*models.OAuthDeviceCode, error)
+	PollDeviceCode(ctx context.Context, deviceCodeHash string, polledAt time.Time) (*models.OAuthDeviceCode, error)
+	SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, interval time.Duration) error
+	UseDeviceCode(ctx context.Context, deviceCodeHash string) error
+}
+
+// SigningKeyRepository stores the keys tokens are signed with. Changes
+// are serialized across instances.
+type SigningKeyRepository interface {
+	ListValid(ctx context.Context) ([]models.SigningKey, error)
+	Rotate(ctx context.Context, key *models.SigningKey, activatedBefore time.Time, retention time.Duration) (bool, error)
+	CreateNext(ctx context.Context, key *models.SigningKey) (bool, error)
+}
+
+// ServiceAccountRepository stores service accounts together with the users
+// rows that hold their roles.
+type ServiceAccountRepository interface {
+	Create(ctx context.Context, account *models.ServiceAccount) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.ServiceAccount, error)
+	GetByClientID(ctx context.Context, clientID string) (*models.ServiceAccount, error)
+	List(ctx context.Context, organizationID uuid.UUID) ([]models.ServiceAccount, error)
+	Update(ctx context.Context, account *models.ServiceAccount) error
+	RotateSecret(ctx context.Context, id uuid.UUID, secretHash string, previousExpiresAt time.Time) error
+	RecordUse(ctx context.Context, id uuid.UUID, usedAt time.Time, ip string) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// APIKeyRepository stores personal API keys.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error)
+	Touch(ctx context.Context, id uuid.UUID, lastUsedAt time.Time, ip string) error
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	DeleteAll(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// RevokedTokenRepository is the revocation list of access tokens. Revoke
+// also deletes the entries of tokens that have expired since.
+type RevokedTokenRepository interface {
+	Revoke(ctx context.Context, token *models.RevokedToken) error
+	IsRevoked(ctx context.Context, id uuid.UUID) (bool, error)
+}
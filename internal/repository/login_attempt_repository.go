@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var (
+	ErrLoginAttemptNotFound = errors.New("login attempt not found")
+	ErrUserNotLocked        = errors.New("user is not locked out")
+)
+
+type loginAttemptRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginAttemptRepository(db *pgxpool.Pool) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+func (r *loginAttemptRepository) List(ctx context.Context, keys []string) ([]models.LoginAttempt, error) {
+	query := `
+		SELECT ` + loginAttemptColumns + `
+		FROM login_attempts
+		WHERE key = ANY($1)
+	`
+
+	rows, err := r.db.Query(ctx, query, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list login attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		attempt, err := scanLoginAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, *attempt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate login attempts: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// RecordFailure counts a failed login of key. Failures before failuresSince
+// and lockouts of keys that have not failed since lockoutsSince are
+// forgotten; rows forgotten entirely are deleted.
+func (r *loginAttemptRepository) RecordFailure(ctx context.Context, key string, userID *uuid.UUID, failuresSince, lockoutsSince time.Time) (*models.LoginAttempt, error) {
+	query := `
+		INSERT INTO login_attempts (key, user_id, failures, lockouts, last_failed_at)
+		VALUES ($1, $2, 1, 0, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			user_id = COALESCE(EXCLUDED.user_id, login_attempts.user_id),
+			failures = CASE WHEN login_attempts.last_failed_at < $4 THEN 1 ELSE login_attempts.failures + 1 END,
+			lockouts = CASE WHEN login_attempts.last_failed_at < $5 THEN 0 ELSE login_attempts.lockouts END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING ` + loginAttemptColumns
+
+	var attempt *models.LoginAttempt
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		now := time.Now()
+		_, err := tx.Exec(ctx,
+			"DELETE FROM login_attempts WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < $2)",
+			lockoutsSince, now)
+		if err != nil {
+			return fmt.Errorf("failed to delete stale login attempts: %w", err)
+		}
+
+		attempt, err = scanLoginAttempt(tx.QueryRow(ctx, query, key, userID, now, failuresSince, lockoutsSince))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+// Lock locks key out until the given time and starts counting its failures
+// afresh. Locking an account or an address is audited.
+func (r *loginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) (*models.LoginAttempt, error) {
+	query := `
+		UPDATE login_attempts
+		SET failures = 0, lockouts = lockouts + 1, locked_until = $2
+		WHERE key = $1
+		RETURNING ` + loginAttemptColumns
+
+	var attempt *models.LoginAttempt
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var err error
+		attempt, err = scanLoginAttempt(tx.QueryRow(ctx, query, key, until))
+		if err != nil {
+			return err
+		}
+
+		if attempt.UserID != nil {
+			return recordChange(ctx, tx, audit.ActionUserLocked, audit.TargetUser, attempt.UserID.String(), uuid.Nil, nil, attempt)
+		}
+		if address, ok := strings.CutPrefix(key, "ip:"); ok {
+			return recordChange(ctx, tx, audit.ActionIPAddressLocked, audit.TargetIPAddress, address, uuid.Nil, nil, attempt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+// Reset forgets the failures and lockouts of key.
+func (r *loginAttemptRepository) Reset(ctx context.Context, key string) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM login_attempts WHERE key = $1", key); err != nil {
+		return fmt.Errorf("failed to reset login attempts: %w", err)
+	}
+	return nil
+}
+
+// Unlock lifts the lockout of a user's account and forgets its failures.
+// Lockouts of the addresses they logged in from are left in place.
+func (r *loginAttemptRepository) Unlock(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE user_id = $1
+		RETURNING ` + loginAttemptColumns
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, query, userID)
+		if err != nil {
+			return fmt.Errorf("failed to unlock user: %w", err)
+		}
+		defer rows.Close()
+
+		var locked *models.LoginAttempt
+		now := time.Now()
+		for rows.Next() {
+			attempt, err := scanLoginAttempt(rows)
+			if err != nil {
+				return err
+			}
+			if attempt.Locked(now) {
+				locked = attempt
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to unlock user: %w", err)
+		}
+		if locked == nil {
+			return ErrUserNotLocked
+		}
+
+		return recordChange(ctx, tx, audit.ActionUserUnlocked, audit.TargetUser, userID.String(), uuid.Nil, locked, nil)
+	})
+}
+
+const loginAttemptColumns = `key, user_id, failures, lockouts, last_failed_at, locked_until`
+
+func scanLoginAttempt(row pgx.Row) (*models.LoginAttempt, error) {
+	attempt := &models.LoginAttempt{}
+	err := row.Scan(
+		&attempt.Key,
+		&attempt.UserID,
+		&attempt.Failures,
+		&attempt.Lockouts,
+		&attempt.LastFailedAt,
+		&attempt.LockedUntil,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrLoginAttemptNotFound
+		}
+		return nil, fmt.Errorf("failed to get login attempt: %w", err)
+	}
+
+	return attempt, nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"slices"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+type loginPolicyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginPolicyRepository(db *pgxpool.Pool) LoginPolicyRepository {
+	return &loginPolicyRepository{db: db}
+}
+
+// GetPolicy returns the login methods the organization allows, all of them
+// when it has no policy.
+func (r *loginPolicyRepository) GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.LoginPolicy, error) {
+	return getLoginPolicy(ctx, r.db, organizationID)
+}
+
+func (r *loginPolicyRepository) SetPolicy(ctx context.Context, policy *models.LoginPolicy) error {
+	query := `
+		INSERT INTO login_policies (organization_id, methods, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			methods = EXCLUDED.methods,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := getLoginPolicy(ctx, tx, policy.OrganizationID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, query, policy.OrganizationID, policy.Methods, time.Now()); err != nil {
+			return fmt.Errorf("failed to update login policy: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionLoginPolicyUpdated, audit.TargetOrganization, policy.OrganizationID.String(),
+			policy.OrganizationID, before, policy)
+	})
+}
+
+func getLoginPolicy(ctx context.Context, q querier, organizationID uuid.UUID) (*models.LoginPolicy, error) {
+	policy := &models.LoginPolicy{OrganizationID: organizationID}
+	err := q.QueryRow(ctx,
+		"SELECT methods FROM login_policies WHERE organization_id = $1",
+		organizationID).Scan(&policy.Methods)
+	if errors.Is(err, pgx.ErrNoRows) {
+		policy.Methods = slices.Clone(models.LoginMethods)
+		return policy, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login policy: %w", err)
+	}
+	return policy, nil
+}
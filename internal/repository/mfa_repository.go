@@ -0,0 +1,309 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var (
+	ErrMFANotFound       = errors.New("MFA is not configured")
+	ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+	// ErrMFAInvalidRole is returned when a policy names a role of another
+	// organization.
+	ErrMFAInvalidRole = errors.New("role does not belong to the organization")
+)
+
+// mfaState is the audit snapshot of an enrollment. It never includes the
+// secret or the recovery codes.
+type mfaState struct {
+	Enabled       bool `json:"enabled"`
+	RecoveryCodes int  `json:"recovery_codes,omitempty"`
+}
+
+type mfaRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewMFARepository(db *pgxpool.Pool) MFARepository {
+	return &mfaRepository{db: db}
+}
+
+func (r *mfaRepository) Get(ctx context.Context, userID uuid.UUID) (*models.UserMFA, error) {
+	query := `
+		SELECT user_id, totp_secret, enabled_at, last_used_step, created_at, updated_at
+		FROM user_mfa
+		WHERE user_id = $1
+	`
+
+	var mfa models.UserMFA
+	err := r.db.QueryRow(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.TOTPSecret,
+		&mfa.EnabledAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+		&mfa.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMFANotFound
+		}
+		return nil, fmt.Errorf("failed to get MFA settings: %w", err)
+	}
+
+	return &mfa, nil
+}
+
+// SavePendingSecret starts or restarts an enrollment with a new secret. It
+// fails with ErrMFAAlreadyEnabled once MFA has been enabled.
+func (r *mfaRepository) SavePendingSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, totp_secret, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET totp_secret = EXCLUDED.totp_secret, last_used_step = 0, updated_at = EXCLUDED.updated_at
+		WHERE user_mfa.enabled_at IS NULL
+	`
+
+	result, err := r.db.Exec(ctx, query, userID, secret, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save MFA secret: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// Enable completes a pending enrollment: step is the time step of the code
+// that confirmed it, and codeHashes replace any previous recovery codes.
+func (r *mfaRepository) Enable(ctx context.Context, userID uuid.UUID, step int64, codeHashes []string) error {
+	query := `
+		UPDATE user_mfa
+		SET enabled_at = $2, last_used_step = $3, updated_at = $2
+		WHERE user_id = $1 AND enabled_at IS NULL AND last_used_step < $3
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, query, userID, time.Now(), step)
+		if err != nil {
+			return fmt.Errorf("failed to enable MFA: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrMFAAlreadyEnabled
+		}
+
+		if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionMFAEnabled, audit.TargetUser, userID.String(), uuid.Nil,
+			&mfaState{}, &mfaState{Enabled: true, RecoveryCodes: len(codeHashes)})
+	})
+}
+
+// UseStep records step as the last accepted TOTP code. It returns false when
+// MFA is not enabled or a code of this or a later step was already used, so
+// that concurrent logins cannot both accept the same code.
+func (r *mfaRepository) UseStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $2
+		WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_used_step < $2
+	`
+
+	result, err := r.db.Exec(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP use: %w", err)
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode consumes the unused recovery code with the given hash and
+// reports whether there was one.
+func (r *mfaRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		  AND EXISTS (SELECT 1 FROM user_mfa m WHERE m.user_id = $1 AND m.enabled_at IS NOT NULL)
+	`
+
+	used := false
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, query, userID, codeHash, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return nil
+		}
+		used = true
+
+		remaining, err := countRecoveryCodes(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, audit.ActionMFARecoveryCodeUsed, audit.TargetUser, userID.String(), uuid.Nil,
+			&mfaState{Enabled: true, RecoveryCodes: remaining + 1}, &mfaState{Enabled: true, RecoveryCodes: remaining})
+	})
+	return used, err
+}
+
+func (r *mfaRepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := countRecoveryCodes(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionMFARecoveryCodesReset, audit.TargetUser, userID.String(), uuid.Nil,
+			&mfaState{Enabled: true, RecoveryCodes: before}, &mfaState{Enabled: true, RecoveryCodes: len(codeHashes)})
+	})
+}
+
+func (r *mfaRepository) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	return countRecoveryCodes(ctx, r.db, userID)
+}
+
+// Disable removes the enrollment and the recovery codes of a user.
+func (r *mfaRepository) Disable(ctx context.Context, userID uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		remaining, err := countRecoveryCodes(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(ctx, "DELETE FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL", userID)
+		if err != nil {
+			return fmt.Errorf("failed to disable MFA: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrMFANotFound
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionMFADisabled, audit.TargetUser, userID.String(), uuid.Nil,
+			&mfaState{Enabled: true, RecoveryCodes: remaining}, &mfaState{})
+	})
+}
+
+func (r *mfaRepository) GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.MFAPolicy, error) {
+	return getMFAPolicy(ctx, r.db, organizationID)
+}
+
+// SetPolicy replaces the roles whose holders must use MFA.
+func (r *mfaRepository) SetPolicy(ctx context.Context, policy *models.MFAPolicy) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if len(policy.RequiredRoleIDs) > 0 {
+			var count int
+			err := tx.QueryRow(ctx,
+				"SELECT COUNT(*) FROM roles WHERE organization_id = $1 AND id = ANY($2)",
+				policy.OrganizationID, policy.RequiredRoleIDs).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("failed to check roles: %w", err)
+			}
+			if count != len(policy.RequiredRoleIDs) {
+				return ErrMFAInvalidRole
+			}
+		}
+
+		before, err := getMFAPolicy(ctx, tx, policy.OrganizationID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM mfa_required_roles WHERE organization_id = $1", policy.OrganizationID); err != nil {
+			return fmt.Errorf("failed to update MFA policy: %w", err)
+		}
+
+		now := time.Now()
+		for _, roleID := range policy.RequiredRoleIDs {
+			_, err := tx.Exec(ctx,
+				"INSERT INTO mfa_required_roles (organization_id, role_id, created_at) VALUES ($1, $2, $3)",
+				policy.OrganizationID, roleID, now)
+			if err != nil {
+				return fmt.Errorf("failed to update MFA policy: %w", err)
+			}
+		}
+
+		return recordChange(ctx, tx, audit.ActionMFAPolicyUpdated, audit.TargetOrganization, policy.OrganizationID.String(),
+			policy.OrganizationID, before, policy)
+	})
+}
+
+// IsRequired reports whether the user holds a role of the organization that
+// the organization's policy requires MFA for.
+func (r *mfaRepository) IsRequired(ctx context.Context, userID, organizationID uuid.UUID) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_roles ur
+			INNER JOIN mfa_required_roles m ON m.role_id = ur.role_id
+			WHERE ur.user_id = $1 AND ur.organization_id = $2
+		)
+	`
+
+	var required bool
+	if err := r.db.QueryRow(ctx, query, userID, organizationID).Scan(&required); err != nil {
+		return false, fmt.Errorf("failed to check MFA policy: %w", err)
+	}
+	return required, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	now := time.Now()
+	batch := &pgx.Batch{}
+	for _, hash := range codeHashes {
+		batch.Queue("INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)", userID, hash, now)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+	return nil
+}
+
+func countRecoveryCodes(ctx context.Context, q querier, userID uuid.UUID) (int, error) {
+	var count int
+	err := q.QueryRow(ctx, "SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+func getMFAPolicy(ctx context.Context, q querier, organizationID uuid.UUID) (*models.MFAPolicy, error) {
+	rows, err := q.Query(ctx,
+		"SELECT role_id FROM mfa_required_roles WHERE organization_id = $1 ORDER BY role_id", organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA policy: %w", err)
+	}
+	roleIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA policy: %w", err)
+	}
+	if roleIDs == nil {
+		roleIDs = []uuid.UUID{}
+	}
+
+	return &models.MFAPolicy{OrganizationID: organizationID, RequiredRoleIDs: roleIDs}, nil
+}
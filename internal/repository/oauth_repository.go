@@ -0,0 +1,569 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var (
+	ErrOAuthClientNotFound  = errors.New("oauth client not found")
+	ErrOAuthConsentNotFound = errors.New("oauth consent not found")
+	// ErrAuthorizationCodeInvalid is returned for unknown and expired codes.
+	ErrAuthorizationCodeInvalid = errors.New("invalid or expired authorization code")
+	// ErrAuthorizationCodeReused is returned with a code that was already
+	// exchanged.
+	ErrAuthorizationCodeReused = errors.New("authorization code already used")
+	// ErrDeviceCodeNotFound is returned for unknown device codes, and for
+	// user codes that are unknown, expired or already decided.
+	ErrDeviceCodeNotFound = errors.New("device code not found")
+)
+
+const oauthClientColumns = `id, organization_id, name, secret_hash, redirect_uris, scopes, skip_consent, created_at, updated_at`
+
+const authorizationCodeColumns = `code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, amr,
+			session_id, expires_at, used_at`
+
+const deviceCodeColumns = `device_code_hash, user_code_hash, client_id, scopes, status, user_id, amr,
+			interval_seconds, last_polled_at, expires_at, created_at`
+
+type oauthRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewOAuthRepository(db *pgxpool.Pool) OAuthRepository {
+	return &oauthRepository{db: db}
+}
+
+func (r *oauthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	if client.ID == uuid.Nil {
+		client.ID = uuid.New()
+	}
+	if client.OrganizationID == uuid.Nil {
+		return fmt.Errorf("organization ID is required")
+	}
+	normalizeOAuthClient(client)
+
+	now := time.Now()
+	client.CreatedAt = now
+	client.UpdatedAt = now
+
+	query := `
+		INSERT INTO oauth_clients (` + oauthClientColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			client.ID,
+			client.OrganizationID,
+			client.Name,
+			secretHashValue(client),
+			client.RedirectURIs,
+			client.Scopes,
+			client.SkipConsent,
+			client.CreatedAt,
+			client.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create oauth client: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionOAuthClientCreated, audit.TargetOAuthClient, client.ID.String(), client.OrganizationID, nil, client)
+	})
+}
+
+func (r *oauthRepository) GetClient(ctx context.Context, id uuid.UUID) (*models.OAuthClient, error) {
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients WHERE id = $1"
+	return scanOAuthClient(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *oauthRepository) ListClients(ctx context.Context, organizationID uuid.UUID) ([]models.OAuthClient, error) {
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients WHERE organization_id = $1 ORDER BY created_at"
+
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oauth clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []models.OAuthClient
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate oauth clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+// UpdateClient saves the name, secret, redirect URIs, scopes and consent
+// setting of a client.
+func (r *oauthRepository) UpdateClient(ctx context.Context, client *models.OAuthClient) error {
+	normalizeOAuthClient(client)
+	client.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE oauth_clients
+		SET name = $2, secret_hash = $3, redirect_uris = $4, scopes = $5, skip_consent = $6, updated_at = $7
+		WHERE id = $1
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := scanOAuthClient(tx.QueryRow(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE id = $1 FOR UPDATE", client.ID))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, query,
+			client.ID,
+			client.Name,
+			secretHashValue(client),
+			client.RedirectURIs,
+			client.Scopes,
+			client.SkipConsent,
+			client.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update oauth client: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionOAuthClientUpdated, audit.TargetOAuthClient, client.ID.String(), before.OrganizationID, before, client)
+	})
+}
+
+func (r *oauthRepository) DeleteClient(ctx context.Context, id uuid.UUID) error {
+	query := "DELETE FROM oauth_clients WHERE id = $1 RETURNING " + oauthClientColumns
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := scanOAuthClient(tx.QueryRow(ctx, query, id))
+		if err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionOAuthClientDeleted, audit.TargetOAuthClient, id.String(), before.OrganizationID, before, nil)
+	})
+}
+
+func (r *oauthRepository) GetConsent(ctx context.Context, userID, clientID uuid.UUID) (*models.OAuthConsent, error) {
+	query := `
+		SELECT c.user_id, c.client_id, oc.name, c.scopes, c.created_at, c.updated_at
+		FROM oauth_consents c
+		JOIN oauth_clients oc ON oc.id = c.client_id
+		WHERE c.user_id = $1 AND c.client_id = $2
+	`
+
+	return scanOAuthConsent(r.db.QueryRow(ctx, query, userID, clientID))
+}
+
+func (r *oauthRepository) ListConsents(ctx context.Context, userID uuid.UUID) ([]models.OAuthConsent, error) {
+	query := `
+		SELECT c.user_id, c.client_id, oc.name, c.scopes, c.created_at, c.updated_at
+		FROM oauth_consents c
+		JOIN oauth_clients oc ON oc.id = c.client_id
+		WHERE c.user_id = $1
+		ORDER BY c.updated_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oauth consents: %w", err)
+	}
+	defer rows.Close()
+
+	var consents []models.OAuthConsent
+	for rows.Next() {
+		consent, err := scanOAuthConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, *consent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate oauth consents: %w", err)
+	}
+
+	return consents, nil
+}
+
+// SaveConsent records the scopes the user allowed the client, replacing
+// what they allowed before.
+func (r *oauthRepository) SaveConsent(ctx context.Context, consent *models.OAuthConsent) error {
+	now := time.Now()
+	consent.UpdatedAt = now
+
+	query := `
+		INSERT INTO oauth_consents (user_id, client_id, scopes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET
+			scopes = EXCLUDED.scopes,
+			updated_at = EXCLUDED.updated_at
+		RETURNING created_at
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		client, err := scanOAuthClient(tx.QueryRow(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE id = $1", consent.ClientID))
+		if err != nil {
+			return err
+		}
+		consent.ClientName = client.Name
+
+		if err := tx.QueryRow(ctx, query, consent.UserID, consent.ClientID, consent.Scopes, now).Scan(&consent.CreatedAt); err != nil {
+			return fmt.Errorf("failed to save oauth consent: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionOAuthConsentGranted, audit.TargetOAuthClient, consent.ClientID.String(), client.OrganizationID, nil, consent)
+	})
+}
+
+func (r *oauthRepository) DeleteConsent(ctx context.Context, userID, clientID uuid.UUID) error {
+	query := `
+		DELETE FROM oauth_consents c
+		USING oauth_clients oc
+		WHERE c.user_id = $1 AND c.client_id = $2 AND oc.id = c.client_id
+		RETURNING c.user_id, c.client_id, oc.name, c.scopes, c.created_at, c.updated_at, oc.organization_id
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var consent models.OAuthConsent
+		var organizationID uuid.UUID
+		err := tx.QueryRow(ctx, query, userID, clientID).Scan(
+			&consent.UserID,
+			&consent.ClientID,
+			&consent.ClientName,
+			&consent.Scopes,
+			&consent.CreatedAt,
+			&consent.UpdatedAt,
+			&organizationID,
+		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOAuthConsentNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete oauth consent: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionOAuthConsentRevoked, audit.TargetOAuthClient, clientID.String(), organizationID, &consent, nil)
+	})
+}
+
+// CreateAuthorizationCode stores an issued code and deletes the expired
+// ones.
+func (r *oauthRepository) CreateAuthorizationCode(ctx context.Context, code *models.OAuthAuthorizationCode) error {
+	if code.Scopes == nil {
+		code.Scopes = []string{}
+	}
+	if code.AMR == nil {
+		code.AMR = []string{}
+	}
+
+	query := `
+		INSERT INTO oauth_authorization_codes (
+			code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, amr, expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM oauth_authorization_codes WHERE expires_at < $1", time.Now()); err != nil {
+			return fmt.Errorf("failed to delete expired authorization codes: %w", err)
+		}
+
+		_, err := tx.Exec(ctx, query,
+			code.CodeHash,
+			code.ClientID,
+			code.UserID,
+			code.RedirectURI,
+			code.Scopes,
+			code.Nonce,
+			code.CodeChallenge,
+			code.AMR,
+			code.ExpiresAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create authorization code: %w", err)
+		}
+		return nil
+	})
+}
+
+// ConsumeAuthorizationCode marks the unexpired, unused code with the given
+// hash as exchanged for sessionID and returns it. A code that was already
+// exchanged is returned with ErrAuthorizationCodeReused, so that the
+// session it opened can be revoked.
+func (r *oauthRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string, sessionID uuid.UUID) (*models.OAuthAuthorizationCode, error) {
+	query := `
+		UPDATE oauth_authorization_codes
+		SET used_at = $3, session_id = $2
+		WHERE code_hash = $1 AND used_at IS NULL AND expires_at > $3
+		RETURNING ` + authorizationCodeColumns
+
+	code, err := scanAuthorizationCode(r.db.QueryRow(ctx, query, codeHash, sessionID, time.Now()))
+	if !errors.Is(err, ErrAuthorizationCodeInvalid) {
+		return code, err
+	}
+
+	query = `
+		SELECT ` + authorizationCodeColumns + `
+		FROM oauth_authorization_codes
+		WHERE code_hash = $1 AND used_at IS NOT NULL
+	`
+
+	code, err = scanAuthorizationCode(r.db.QueryRow(ctx, query, codeHash))
+	if err != nil {
+		return nil, err
+	}
+	return code, ErrAuthorizationCodeReused
+}
+
+// CreateDeviceCode stores a pending device code and deletes the expired
+// ones.
+func (r *oauthRepository) CreateDeviceCode(ctx context.Context, code *models.OAuthDeviceCode) error {
+	if code.Scopes == nil {
+		code.Scopes = []string{}
+	}
+	code.AMR = []string{}
+	code.Status = models.DeviceCodeStatusPending
+	code.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO oauth_device_codes (
+			device_code_hash, user_code_hash, client_id, scopes, status, amr, interval_seconds, expires_at, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM oauth_device_codes WHERE expires_at < $1", code.CreatedAt); err != nil {
+			return fmt.Errorf("failed to delete expired device codes: %w", err)
+		}
+
+		_, err := tx.Exec(ctx, query,
+			code.DeviceCodeHash,
+			code.UserCodeHash,
+			code.ClientID,
+			code.Scopes,
+			code.Status,
+			code.AMR,
+			int(code.Interval.Seconds()),
+			code.ExpiresAt,
+			code.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create device code: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetDeviceCode returns the pending, unexpired device code of a user code.
+func (r *oauthRepository) GetDeviceCode(ctx context.Context, userCodeHash string) (*models.OAuthDeviceCode, error) {
+	query := `
+		SELECT ` + deviceCodeColumns + `
+		FROM oauth_device_codes
+		WHERE user_code_hash = $1 AND status = $2 AND expires_at > $3
+	`
+
+	return scanDeviceCode(r.db.QueryRow(ctx, query, userCodeHash, models.DeviceCodeStatusPending, time.Now()))
+}
+
+// DecideDeviceCode records the answer of the user to the pending,
+// unexpired device code of a user code and returns the code.
+func (r *oauthRepository) DecideDeviceCode(ctx context.Context, userCodeHash string, userID uuid.UUID, amr []string, approved bool) (*models.OAuthDeviceCode, error) {
+	if amr == nil {
+		amr = []string{}
+	}
+	status := models.DeviceCodeStatusDenied
+	if approved {
+		status = models.DeviceCodeStatusApproved
+	}
+
+	query := `
+		UPDATE oauth_device_codes
+		SET status = $2, user_id = $3, amr = $4
+		WHERE user_code_hash = $1 AND status = $5 AND expires_at > $6
+		RETURNING ` + deviceCodeColumns
+
+	return scanDeviceCode(r.db.QueryRow(ctx, query, userCodeHash, status, userID, amr, models.DeviceCodeStatusPending, time.Now()))
+}
+
+// PollDeviceCode records a token request for a device code at polledAt and
+// returns the code as it was before, so that LastPolledAt is the time of
+// the previous request. Expired codes are returned too.
+func (r *oauthRepository) PollDeviceCode(ctx context.Context, deviceCodeHash string, polledAt time.Time) (*models.OAuthDeviceCode, error) {
+	var code *models.OAuthDeviceCode
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var err error
+		code, err = scanDeviceCode(tx.QueryRow(ctx, "SELECT "+deviceCodeColumns+" FROM oauth_device_codes WHERE device_code_hash = $1 FOR UPDATE", deviceCodeHash))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE oauth_device_codes SET last_polled_at = $2 WHERE device_code_hash = $1", deviceCodeHash, polledAt)
+		if err != nil {
+			return fmt.Errorf("failed to record device code poll: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// SlowDownDeviceCode sets the polling interval of a device code.
+func (r *oauthRepository) SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, interval time.Duration) error {
+	_, err := r.db.Exec(ctx, "UPDATE oauth_device_codes SET interval_seconds = $2 WHERE device_code_hash = $1", deviceCodeHash, int(interval.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to update device code interval: %w", err)
+	}
+	return nil
+}
+
+// UseDeviceCode marks an approved device code as used. It fails with
+// ErrDeviceCodeNotFound unless the code is approved and unused, so that
+// concurrent token requests get tokens only once.
+func (r *oauthRepository) UseDeviceCode(ctx context.Context, deviceCodeHash string) error {
+	tag, err := r.db.Exec(ctx,
+		"UPDATE oauth_device_codes SET status = $2 WHERE device_code_hash = $1 AND status = $3",
+		deviceCodeHash, models.DeviceCodeStatusUsed, models.DeviceCodeStatusApproved)
+	if err != nil {
+		return fmt.Errorf("failed to use device code: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDeviceCodeNotFound
+	}
+	return nil
+}
+
+func normalizeOAuthClient(client *models.OAuthClient) {
+	if client.RedirectURIs == nil {
+		client.RedirectURIs = []string{}
+	}
+	if client.Scopes == nil {
+		client.Scopes = []string{}
+	}
+	client.Public = client.SecretHash == ""
+}
+
+func secretHashValue(client *models.OAuthClient) *string {
+	if client.SecretHash == "" {
+		return nil
+	}
+	return &client.SecretHash
+}
+
+func scanOAuthClient(row pgx.Row) (*models.OAuthClient, error) {
+	client := &models.OAuthClient{}
+	var secretHash *string
+	err := row.Scan(
+		&client.ID,
+		&client.OrganizationID,
+		&client.Name,
+		&secretHash,
+		&client.RedirectURIs,
+		&client.Scopes,
+		&client.SkipConsent,
+		&client.CreatedAt,
+		&client.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, fmt.Errorf("failed to get oauth client: %w", err)
+	}
+
+	client.SecretHash = models.StringValue(secretHash)
+	client.Public = client.SecretHash == ""
+	return client, nil
+}
+
+func scanOAuthConsent(row pgx.Row) (*models.OAuthConsent, error) {
+	consent := &models.OAuthConsent{}
+	err := row.Scan(
+		&consent.UserID,
+		&consent.ClientID,
+		&consent.ClientName,
+		&consent.Scopes,
+		&consent.CreatedAt,
+		&consent.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOAuthConsentNotFound
+		}
+		return nil, fmt.Errorf("failed to get oauth consent: %w", err)
+	}
+
+	return consent, nil
+}
+
+func scanAuthorizationCode(row pgx.Row) (*models.OAuthAuthorizationCode, error) {
+	code := &models.OAuthAuthorizationCode{}
+	err := row.Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scopes,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.AMR,
+		&code.SessionID,
+		&code.ExpiresAt,
+		&code.UsedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAuthorizationCodeInvalid
+		}
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+
+	return code, nil
+}
+
+func scanDeviceCode(row pgx.Row) (*models.OAuthDeviceCode, error) {
+	code := &models.OAuthDeviceCode{}
+	var intervalSeconds int
+	err := row.Scan(
+		&code.DeviceCodeHash,
+		&code.UserCodeHash,
+		&code.ClientID,
+		&code.Scopes,
+		&code.Status,
+		&code.UserID,
+		&code.AMR,
+		&intervalSeconds,
+		&code.LastPolledAt,
+		&code.ExpiresAt,
+		&code.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDeviceCodeNotFound
+		}
+		return nil, fmt.Errorf("failed to get device code: %w", err)
+	}
+
+	code.Interval = time.Duration(intervalSeconds) * time.Second
+	return code, nil
+}
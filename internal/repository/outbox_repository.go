@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+// OutboxHandler publishes a single outbox event. Returning an error leaves
+// the event pending for a later attempt.
+type OutboxHandler func(ctx context.Context, event models.OutboxEvent) error
+
+type outboxRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewOutboxRepository(db *pgxpool.Pool) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+// ProcessPending hands up to limit due events to handle in ID order and
+// records the outcome. Only one caller across all replicas processes the
+// outbox at a time; others return immediately. Once an event of an
+// aggregate fails or is waiting for its retry, later events of the same
+// aggregate are held back so consumers observe them in order, and events
+// that are held back are not selected, so that they cannot fill the batch.
+//
+// The lock is a session lock held on one connection rather than a
+// transaction, so that no transaction stays open while handle publishes
+// over the network.
+func (r *outboxRepository) ProcessPending(ctx context.Context, limit int, retryDelay func(attempts int) time.Duration, handle OutboxHandler) (int, error) {
+	conn, err := r.db.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	var locked bool
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtextextended('outbox-relay', 0))").Scan(&locked)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock outbox: %w", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+	defer func() {
+		if _, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtextextended('outbox-relay', 0))"); err != nil {
+			// Closing the connection ends the session and with it the lock.
+			_ = conn.Conn().Close(context.WithoutCancel(ctx))
+		}
+	}()
+
+	now := time.Now()
+	query := `
+		SELECT e.id, e.payload, e.attempts, e.last_error, e.next_attempt_at
+		FROM outbox_events e
+		WHERE e.published_at IS NULL
+		  AND e.next_attempt_at <= $2
+		  AND NOT EXISTS (
+		      SELECT 1 FROM outbox_events p
+		      WHERE p.aggregate_type = e.aggregate_type AND p.aggregate_id = e.aggregate_id
+		        AND p.id < e.id AND p.published_at IS NULL AND p.next_attempt_at > $2
+		  )
+		ORDER BY e.id
+		LIMIT $1
+	`
+
+	rows, err := conn.Query(ctx, query, limit, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list outbox events: %w", err)
+	}
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var event models.OutboxEvent
+		var payload []byte
+		if err := rows.Scan(&event.ID, &payload, &event.Attempts, &event.LastError, &event.NextAttemptAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		if err := json.Unmarshal(payload, &event.Event); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to decode outbox event %d: %w", event.ID, err)
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate outbox events: %w", err)
+	}
+
+	published := 0
+	blocked := make(map[string]bool)
+	for _, event := range events {
+		aggregate := event.Event.AggregateType + ":" + event.Event.AggregateID
+		if blocked[aggregate] {
+			continue
+		}
+
+		if err := handle(ctx, event); err != nil {
+			blocked[aggregate] = true
+			_, err = conn.Exec(ctx, `
+				UPDATE outbox_events
+				SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+				WHERE id = $1
+			`, event.ID, err.Error(), time.Now().Add(retryDelay(event.Attempts+1)))
+			if err != nil {
+				return published, fmt.Errorf("failed to record outbox failure: %w", err)
+			}
+			continue
+		}
+
+		_, err = conn.Exec(ctx, `
+			UPDATE outbox_events
+			SET published_at = $2, attempts = attempts + 1, last_error = ''
+			WHERE id = $1
+		`, event.ID, time.Now())
+		if err != nil {
+			return published, fmt.Errorf("failed to mark outbox event published: %w", err)
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// DeletePublished removes events published before the given time.
+func (r *outboxRepository) DeletePublished(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.Exec(ctx, "DELETE FROM outbox_events WHERE published_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete published outbox events: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+func enqueueOutboxEvent(ctx context.Context, tx pgx.Tx, event *models.DomainEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode domain event: %w", err)
+	}
+
+	query := `
+		INSERT INTO outbox_events (event_id, event_type, aggregate_type, aggregate_id, payload)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = tx.Exec(ctx, query, event.ID, event.Type, event.AggregateType, event.AggregateID, payload)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue domain event: %w", err)
+	}
+
+	return nil
+}
+
+// recordChange records a mutation in the audit log and queues the matching
+// domain event, both in tx so neither can exist without the other or
+// without the mutation itself. The event carries the state after the change,
+// or the state before it for deletions.
+func recordChange(ctx context.Context, tx pgx.Tx, action, targetType, targetID string, organizationID uuid.UUID, before, after interface{}) error {
+	if err := recordAudit(ctx, tx, action, targetType, targetID, organizationID, before, after); err != nil {
+		return err
+	}
+
+	state := after
+	if state == nil {
+		state = before
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode domain event data: %w", err)
+	}
+
+	actor := audit.ActorFrom(ctx)
+	event := &models.DomainEvent{
+		Type:          action,
+		AggregateType: targetType,
+		AggregateID:   targetID,
+		ActorID:       actor.UserID,
+		Data:          data,
+	}
+	if organizationID != uuid.Nil {
+		event.OrganizationID = models.UUIDPtr(organizationID)
+	}
+
+	return enqueueOutboxEvent(ctx, tx, event)
+}
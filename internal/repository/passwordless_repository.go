@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/models"
+)
+
+// ErrPasswordlessChallengeNotFound is returned for unknown, expired, used
+// and exhausted challenges alike.
+var ErrPasswordlessChallengeNotFound = errors.New("passwordless challenge not found")
+
+// ErrPasswordlessMailLimit is returned when a user was mailed as many
+// challenges as allowed within the window.
+var ErrPasswordlessMailLimit = errors.New("too many passwordless login mails")
+
+const passwordlessChallengeColumns = "id, user_id, organization_id, device_hash, code_hash, link_hash, attempts, expires_at, used_at, created_at"
+
+type passwordlessRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPasswordlessRepository(db *pgxpool.Pool) PasswordlessRepository {
+	return &passwordlessRepository{db: db}
+}
+
+// Create stores a challenge unless the user was mailed maxMails challenges
+// since the given time, in which case it returns ErrPasswordlessMailLimit.
+// The unused challenges the user had are expired, so that only the latest
+// mail works, and kept for counting until they fall out of the window.
+func (r *passwordlessRepository) Create(ctx context.Context, challenge *models.PasswordlessChallenge, since time.Time, maxMails int) error {
+	if challenge.ID == uuid.Nil {
+		challenge.ID = uuid.New()
+	}
+	challenge.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO passwordless_challenges (id, user_id, organization_id, device_hash, code_hash, link_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		// Concurrent starts of the user count one by one.
+		if _, err := lockUser(ctx, tx, challenge.UserID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx,
+			"DELETE FROM passwordless_challenges WHERE expires_at < $1",
+			since)
+		if err != nil {
+			return fmt.Errorf("failed to delete expired challenges: %w", err)
+		}
+
+		var mails int
+		err = tx.QueryRow(ctx,
+			"SELECT count(*) FROM passwordless_challenges WHERE user_id = $1 AND created_at >= $2",
+			challenge.UserID, since).Scan(&mails)
+		if err != nil {
+			return fmt.Errorf("failed to count challenges: %w", err)
+		}
+		if mails >= maxMails {
+			return ErrPasswordlessMailLimit
+		}
+
+		_, err = tx.Exec(ctx,
+			"UPDATE passwordless_challenges SET expires_at = $2 WHERE user_id = $1 AND used_at IS NULL AND expires_at > $2",
+			challenge.UserID, challenge.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to expire previous challenges: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, query,
+			challenge.ID,
+			challenge.UserID,
+			challenge.OrganizationID,
+			challenge.DeviceHash,
+			challenge.CodeHash,
+			challenge.LinkHash,
+			challenge.ExpiresAt,
+			challenge.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create challenge: %w", err)
+		}
+		return nil
+	})
+}
+
+// Attempt counts an attempt at the unexpired, unused challenge of the
+// device and returns it, unless maxAttempts were made already. Concurrent
+// attempts are counted one by one.
+func (r *passwordlessRepository) Attempt(ctx context.Context, deviceHash string, maxAttempts int) (*models.PasswordlessChallenge, error) {
+	query := `
+		UPDATE passwordless_challenges
+		SET attempts = attempts + 1
+		WHERE device_hash = $1 AND used_at IS NULL AND expires_at > $2 AND attempts < $3
+		RETURNING ` + passwordlessChallengeColumns
+
+	var challenge models.PasswordlessChallenge
+	err := r.db.QueryRow(ctx, query, deviceHash, time.Now(), maxAttempts).Scan(
+		&challenge.ID,
+		&challenge.UserID,
+		&challenge.OrganizationID,
+		&challenge.DeviceHash,
+		&challenge.CodeHash,
+		&challenge.LinkHash,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+		&challenge.UsedAt,
+		&challenge.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPasswordlessChallengeNotFound
+		}
+		return nil, fmt.Errorf("failed to attempt challenge: %w", err)
+	}
+
+	return &challenge, nil
+}
+
+// Consume marks a challenge as used. Concurrent calls succeed at most once.
+func (r *passwordlessRepository) Consume(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.db.Exec(ctx,
+		"UPDATE passwordless_challenges SET used_at = $2 WHERE id = $1 AND used_at IS NULL",
+		id, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to use challenge: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPasswordlessChallengeNotFound
+	}
+	return nil
+}
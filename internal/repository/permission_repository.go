@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+type permissionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPermissionRepository(db *pgxpool.Pool) PermissionRepository {
+	return &permissionRepository{db: db}
+}
+
+func (r *permissionRepository) Create(ctx context.Context, permission *models.Permission) error {
+	if permission.ID == uuid.Nil {
+		return fmt.Errorf("permission ID is required")
+	}
+	if permission.Name == "" {
+		return fmt.Errorf("permission name is required")
+	}
+
+	if permission.CreatedAt.IsZero() {
+		permission.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO permissions (id, name, resource, action, description, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			permission.ID,
+			permission.Name,
+			permission.Resource,
+			permission.Action,
+			permission.Description,
+			permission.CreatedAt,
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to create permission: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionPermissionCreated, audit.TargetPermission, permission.ID.String(), uuid.Nil, nil, permission)
+	})
+}
+
+func (r *permissionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Permission, error) {
+	query := `
+		SELECT id, name, resource, action, description, created_at
+		FROM permissions
+		WHERE id = $1
+	`
+
+	return scanPermission(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *permissionRepository) GetByName(ctx context.Context, name string) (*models.Permission, error) {
+	query := `
+		SELECT id, name, resource, action, description, created_at
+		FROM permissions
+		WHERE name = $1
+	`
+
+	return scanPermission(r.db.QueryRow(ctx, query, name))
+}
+
+func (r *permissionRepository) List(ctx context.Context, limit, offset int) ([]models.Permission, error) {
+	query := `
+		SELECT id, name, resource, action, description, created_at
+		FROM permissions
+		ORDER BY resource, action
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := r.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+
+	return collectPermissions(rows)
+}
+
+func (r *permissionRepository) ListByResource(ctx context.Context, resource string) ([]models.Permission, error) {
+	query := `
+		SELECT id, name, resource, action, description, created_at
+		FROM permissions
+		WHERE resource = $1
+		ORDER BY action
+	`
+
+	rows, err := r.db.Query(ctx, query, resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+
+	return collectPermissions(rows)
+}
+
+func (r *permissionRepository) Update(ctx context.Context, id uuid.UUID, updates *models.Permission) error {
+	query := `
+		UPDATE permissions
+		SET name = $2, resource = $3, action = $4, description = $5
+		WHERE id = $1
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := scanPermission(tx.QueryRow(ctx, `
+			SELECT id, name, resource, action, description, created_at
+			FROM permissions
+			WHERE id = $1
+			FOR UPDATE
+		`, id))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, query,
+			id,
+			updates.Name,
+			updates.Resource,
+			updates.Action,
+			updates.Description,
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to update permission: %w", err)
+		}
+
+		after := *before
+		after.Name = updates.Name
+		after.Resource = updates.Resource
+		after.Action = updates.Action
+		after.Description = updates.Description
+
+		return recordChange(ctx, tx, audit.ActionPermissionUpdated, audit.TargetPermission, id.String(), uuid.Nil, before, &after)
+	})
+}
+
+func (r *permissionRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := scanPermission(tx.QueryRow(ctx, `
+			DELETE FROM permissions
+			WHERE id = $1
+			RETURNING id, name, resource, action, description, created_at
+		`, id))
+		if err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionPermissionDeleted, audit.TargetPermission, id.String(), uuid.Nil, before, nil)
+	})
+}
+
+func scanPermission(row pgx.Row) (*models.Permission, error) {
+	permission := &models.Permission{}
+	err := row.Scan(
+		&permission.ID,
+		&permission.Name,
+		&permission.Resource,
+		&permission.Action,
+		&permission.Description,
+		&permission.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("permission not found")
+		}
+		return nil, fmt.Errorf("failed to get permission: %w", err)
+	}
+
+	return permission, nil
+}
+
+func collectPermissions(rows pgx.Rows) ([]models.Permission, error) {
+	defer rows.Close()
+
+	var permissions []models.Permission
+	for rows.Next() {
+		var permission models.Permission
+		err := rows.Scan(
+			&permission.ID,
+			&permission.Name,
+			&permission.Resource,
+			&permission.Action,
+			&permission.Description,
+			&permission.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate permissions: %w", err)
+	}
+
+	return permissions, nil
+}
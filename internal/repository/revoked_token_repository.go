@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+type revokedTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewRevokedTokenRepository(db *pgxpool.Pool) RevokedTokenRepository {
+	return &revokedTokenRepository{db: db}
+}
+
+// Revoke adds a token to the list. Revoking a listed token again changes
+// nothing.
+func (r *revokedTokenRepository) Revoke(ctx context.Context, token *models.RevokedToken) error {
+	token.RevokedAt = time.Now()
+
+	query := `
+		INSERT INTO revoked_tokens (id, subject_id, organization_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO NOTHING
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= $1", token.RevokedAt); err != nil {
+			return fmt.Errorf("failed to delete expired revoked tokens: %w", err)
+		}
+
+		tag, err := tx.Exec(ctx, query,
+			token.ID,
+			token.SubjectID,
+			token.OrganizationID,
+			token.ExpiresAt,
+			token.RevokedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to revoke token: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return nil
+		}
+
+		organizationID := uuid.Nil
+		if token.OrganizationID != nil {
+			organizationID = *token.OrganizationID
+		}
+		return recordChange(ctx, tx, audit.ActionTokenRevoked, audit.TargetToken, token.ID.String(), organizationID, nil, token)
+	})
+}
+
+func (r *revokedTokenRepository) IsRevoked(ctx context.Context, id uuid.UUID) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE id = $1)", id).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %w", err)
+	}
+	return revoked, nil
+}
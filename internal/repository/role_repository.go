@@ -5,12 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"time"
+	"user-management/internal/audit"
 	"user-management/internal/models"
 )
 
+var ErrRoleNotFound = errors.New("role not found")
+
 type roleRepository struct {
 	db *pgxpool.Pool
 }
@@ -42,21 +45,23 @@ func (r *roleRepository) Create(ctx context.Context, role *models.Role) error {
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 
-	_, err := r.db.Exec(ctx, query,
-		role.ID,
-		role.Name,
-		role.Description,
-		role.OrganizationID,
-		role.IsSystemRole,
-		role.CreatedAt,
-		role.UpdatedAt,
-	)
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			role.ID,
+			role.Name,
+			role.Description,
+			role.OrganizationID,
+			role.IsSystemRole,
+			role.CreatedAt,
+			role.UpdatedAt,
+		)
 
-	if err != nil {
-		return fmt.Errorf("failed to create role: %w", err)
-	}
+		if err != nil {
+			return fmt.Errorf("failed to create role: %w", err)
+		}
 
-	return nil
+		return recordChange(ctx, tx, audit.ActionRoleCreated, audit.TargetRole, role.ID.String(), role.OrganizationID, nil, role)
+	})
 }
 
 func (r *roleRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Role, error) {
@@ -80,7 +85,7 @@ func (r *roleRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Rol
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("role not found")
+			return nil, ErrRoleNotFound
 		}
 		return nil, fmt.Errorf("failed to get role: %w", err)
 	}
@@ -108,8 +113,8 @@ func (r *roleRepository) GetByName(ctx context.Context, name string, organizatio
 	)
 
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("role not found")
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRoleNotFound
 		}
 		return nil, fmt.Errorf("failed to get role: %w", err)
 	}
@@ -163,49 +168,55 @@ func (r *roleRepository) Update(ctx context.Context, role *models.Role) error {
 		WHERE id = $1
 	`
 
-	result, err := r.db.Exec(ctx, query,
-		role.ID,
-		role.Name,
-		role.Description,
-		role.UpdatedAt,
-	)
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockRole(ctx, tx, role.ID)
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return fmt.Errorf("failed to update role: %w", err)
-	}
+		_, err = tx.Exec(ctx, query,
+			role.ID,
+			role.Name,
+			role.Description,
+			role.UpdatedAt,
+		)
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("role not found")
-	}
+		if err != nil {
+			return fmt.Errorf("failed to update role: %w", err)
+		}
+
+		after := *before
+		after.Name = role.Name
+		after.Description = role.Description
+		after.UpdatedAt = role.UpdatedAt
 
-	return nil
+		return recordChange(ctx, tx, audit.ActionRoleUpdated, audit.TargetRole, role.ID.String(), before.OrganizationID, before, &after)
+	})
 }
 
 func (r *roleRepository) Delete(ctx context.Context, id uuid.UUID) error {
-	var isSystemRole bool
-	err := r.db.QueryRow(ctx, "SELECT is_system_role FROM roles WHERE id = $1", id).Scan(&isSystemRole)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return fmt.Errorf("role not found")
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockRole(ctx, tx, id)
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to check role: %w", err)
-	}
 
-	if isSystemRole {
-		return fmt.Errorf("cannot delete system role")
-	}
+		if before.IsSystemRole {
+			return fmt.Errorf("cannot delete system role")
+		}
 
-	query := "DELETE FROM roles WHERE id = $1"
-	result, err := r.db.Exec(ctx, query, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete role: %w", err)
-	}
+		query := "DELETE FROM roles WHERE id = $1"
+		_, err = tx.Exec(ctx, query, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("role not found")
-	}
+		return recordChange(ctx, tx, audit.ActionRoleDeleted, audit.TargetRole, id.String(), before.OrganizationID, before, nil)
+	})
+}
 
-	return nil
+type rolePermissionSet struct {
+	PermissionIDs []uuid.UUID `json:"permission_ids"`
 }
 
 func (r *roleRepository) AssignPermissions(ctx context.Context, roleID uuid.UUID, permissionIDs []uuid.UUID) error {
@@ -213,28 +224,34 @@ func (r *roleRepository) AssignPermissions(ctx context.Context, roleID uuid.UUID
 		return nil
 	}
 
-	tx, err := r.db.Begin(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		role, err := lockRole(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.Exec(ctx, "DELETE FROM role_permissions WHERE role_id = $1", roleID)
-	if err != nil {
-		_ = tx.Rollback(ctx)
-		return fmt.Errorf("failed to remove existing permissions: %w", err)
-	}
+		before, err := rolePermissionIDs(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
 
-	for _, permissionID := range permissionIDs {
-		_, err = tx.Exec(ctx,
-			"INSERT INTO role_permissions (role_id, permission_id, granted_at) VALUES ($1, $2, $3)",
-			roleID, permissionID, time.Now())
+		_, err = tx.Exec(ctx, "DELETE FROM role_permissions WHERE role_id = $1", roleID)
 		if err != nil {
-			_ = tx.Rollback(ctx)
-			return fmt.Errorf("failed to assign permission: %w", err)
+			return fmt.Errorf("failed to remove existing permissions: %w", err)
 		}
-	}
 
-	return tx.Commit(ctx)
+		for _, permissionID := range permissionIDs {
+			_, err = tx.Exec(ctx,
+				"INSERT INTO role_permissions (role_id, permission_id, granted_at) VALUES ($1, $2, $3)",
+				roleID, permissionID, time.Now())
+			if err != nil {
+				return fmt.Errorf("failed to assign permission: %w", err)
+			}
+		}
+
+		return recordChange(ctx, tx, audit.ActionRolePermissionsGranted, audit.TargetRole, roleID.String(), role.OrganizationID,
+			&rolePermissionSet{PermissionIDs: before}, &rolePermissionSet{PermissionIDs: permissionIDs})
+	})
 }
 
 func (r *roleRepository) RemovePermissions(ctx context.Context, roleID uuid.UUID, permissionIDs []uuid.UUID) error {
@@ -242,13 +259,73 @@ func (r *roleRepository) RemovePermissions(ctx context.Context, roleID uuid.UUID
 		return nil
 	}
 
-	query := "DELETE FROM role_permissions WHERE role_id = $1 AND permission_id = ANY($2)"
-	_, err := r.db.Exec(ctx, query, roleID, permissionIDs)
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		role, err := lockRole(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
+
+		query := "DELETE FROM role_permissions WHERE role_id = $1 AND permission_id = ANY($2) RETURNING permission_id"
+		rows, err := tx.Query(ctx, query, roleID, permissionIDs)
+		if err != nil {
+			return fmt.Errorf("failed to remove permissions: %w", err)
+		}
+		removed, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+		if err != nil {
+			return fmt.Errorf("failed to remove permissions: %w", err)
+		}
+
+		if len(removed) == 0 {
+			return nil
+		}
+
+		return recordChange(ctx, tx, audit.ActionRolePermissionsRevoked, audit.TargetRole, roleID.String(), role.OrganizationID,
+			&rolePermissionSet{PermissionIDs: removed}, nil)
+	})
+}
+
+func lockRole(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.Role, error) {
+	role := &models.Role{}
+
+	query := `
+		SELECT id, name, description, organization_id, is_system_role, created_at, updated_at
+		FROM roles
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	err := tx.QueryRow(ctx, query, id).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.OrganizationID,
+		&role.IsSystemRole,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+
+	return role, nil
+}
+
+func rolePermissionIDs(ctx context.Context, q querier, roleID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.Query(ctx, "SELECT permission_id FROM role_permissions WHERE role_id = $1 ORDER BY permission_id", roleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role permissions: %w", err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
 	if err != nil {
-		return fmt.Errorf("failed to remove permissions: %w", err)
+		return nil, fmt.Errorf("failed to scan permission: %w", err)
 	}
 
-	return nil
+	return ids, nil
 }
 
 func (r *roleRepository) GetRolePermissions(ctx context.Context, roleID uuid.UUID) ([]models.Permission, error) {
@@ -0,0 +1,349 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var (
+	ErrSAMLConnectionNotFound = errors.New("SAML connection not found")
+	// ErrSAMLInvalidRole is returned when the default role or a mapped role
+	// is not a role of the connection's organization.
+	ErrSAMLInvalidRole = errors.New("role does not belong to the organization")
+	// ErrSAMLAssertionReplayed is returned for assertions that were
+	// already accepted.
+	ErrSAMLAssertionReplayed = errors.New("SAML assertion was already used")
+)
+
+type samlRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSAMLRepository(db *pgxpool.Pool) SAMLRepository {
+	return &samlRepository{db: db}
+}
+
+func (r *samlRepository) GetConnection(ctx context.Context, organizationID uuid.UUID) (*models.SAMLConnection, error) {
+	return getSAMLConnection(ctx, r.db, organizationID)
+}
+
+// SaveConnection creates or replaces the organization's connection,
+// together with its role mappings.
+func (r *samlRepository) SaveConnection(ctx context.Context, connection *models.SAMLConnection) error {
+	query := `
+		INSERT INTO saml_connections (organization_id, idp_metadata, idp_entity_id, attribute_mapping, default_role_id,
+		                              allow_idp_initiated, create_users, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			idp_metadata = EXCLUDED.idp_metadata,
+			idp_entity_id = EXCLUDED.idp_entity_id,
+			attribute_mapping = EXCLUDED.attribute_mapping,
+			default_role_id = EXCLUDED.default_role_id,
+			allow_idp_initiated = EXCLUDED.allow_idp_initiated,
+			create_users = EXCLUDED.create_users,
+			enabled = EXCLUDED.enabled,
+			updated_at = EXCLUDED.updated_at
+		RETURNING created_at, updated_at
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		roles := map[uuid.UUID]bool{}
+		for _, mapping := range connection.RoleMappings {
+			roles[mapping.RoleID] = true
+		}
+		if connection.DefaultRoleID != nil {
+			roles[*connection.DefaultRoleID] = true
+		}
+		if len(roles) > 0 {
+			roleIDs := make([]uuid.UUID, 0, len(roles))
+			for roleID := range roles {
+				roleIDs = append(roleIDs, roleID)
+			}
+			var count int
+			err := tx.QueryRow(ctx,
+				"SELECT COUNT(*) FROM roles WHERE organization_id = $1 AND id = ANY($2)",
+				connection.OrganizationID, roleIDs).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("failed to check roles: %w", err)
+			}
+			if count != len(roleIDs) {
+				return ErrSAMLInvalidRole
+			}
+		}
+
+		before, err := getSAMLConnection(ctx, tx, connection.OrganizationID)
+		if err != nil && !errors.Is(err, ErrSAMLConnectionNotFound) {
+			return err
+		}
+
+		err = tx.QueryRow(ctx, query,
+			connection.OrganizationID,
+			connection.IdPMetadata,
+			connection.IdPEntityID,
+			connection.AttributeMapping,
+			connection.DefaultRoleID,
+			connection.AllowIdPInitiated,
+			connection.CreateUsers,
+			connection.Enabled,
+			time.Now(),
+		).Scan(&connection.CreatedAt, &connection.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save SAML connection: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM saml_role_mappings WHERE organization_id = $1", connection.OrganizationID); err != nil {
+			return fmt.Errorf("failed to update SAML role mappings: %w", err)
+		}
+		for _, mapping := range connection.RoleMappings {
+			_, err := tx.Exec(ctx,
+				"INSERT INTO saml_role_mappings (organization_id, group_name, role_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+				connection.OrganizationID, mapping.Group, mapping.RoleID)
+			if err != nil {
+				return fmt.Errorf("failed to update SAML role mappings: %w", err)
+			}
+		}
+
+		action := audit.ActionSAMLConnectionUpdated
+		if before == nil {
+			action = audit.ActionSAMLConnectionCreated
+		}
+		return recordChange(ctx, tx, action, audit.TargetOrganization, connection.OrganizationID.String(),
+			connection.OrganizationID, before, connection)
+	})
+}
+
+func (r *samlRepository) DeleteConnection(ctx context.Context, organizationID uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := getSAMLConnection(ctx, tx, organizationID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM saml_connections WHERE organization_id = $1", organizationID); err != nil {
+			return fmt.Errorf("failed to delete SAML connection: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionSAMLConnectionDeleted, audit.TargetOrganization, organizationID.String(),
+			organizationID, before, nil)
+	})
+}
+
+// UseAssertion records an accepted assertion until it expires, and removes
+// expired ones. An assertion that was already recorded is replayed.
+func (r *samlRepository) UseAssertion(ctx context.Context, organizationID uuid.UUID, assertionID string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO saml_assertions (organization_id, assertion_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, assertion_id) DO NOTHING
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM saml_assertions WHERE expires_at < $1", time.Now()); err != nil {
+			return fmt.Errorf("failed to delete expired SAML assertions: %w", err)
+		}
+
+		result, err := tx.Exec(ctx, query, organizationID, assertionID, expiresAt)
+		if err != nil {
+			return fmt.Errorf("failed to record SAML assertion: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrSAMLAssertionReplayed
+		}
+		return nil
+	})
+}
+
+// Provision applies a login through the organization's connection. It
+// creates the user when user.ID is unset, and links identity when its ID
+// is unset or records its login otherwise. Since the organization's IdP can
+// assert any address, a new identity is only linked to an existing user
+// that the organization manages, as for SCIM, and ErrEmailTaken is returned
+// for other users. A user who is not a member of
+// the organization yet becomes one, with the connection's default role.
+// Finally, the mapped roles of groups are granted, and those of the other
+// mapped groups revoked.
+func (r *samlRepository) Provision(ctx context.Context, organizationID uuid.UUID, user *models.User, identity *models.Identity, groups []string) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if user.ID == uuid.Nil {
+			if err := insertUser(ctx, tx, user, organizationID); err != nil {
+				return err
+			}
+		} else if identity.ID == uuid.Nil {
+			if _, err := lockUser(ctx, tx, user.ID); err != nil {
+				return err
+			}
+			managed, err := managesUser(ctx, tx, organizationID, user.ID)
+			if err != nil {
+				return err
+			}
+			if !managed {
+				return ErrEmailTaken
+			}
+		}
+
+		identity.UserID = user.ID
+		if identity.ID == uuid.Nil {
+			if err := insertIdentity(ctx, tx, identity); err != nil {
+				return err
+			}
+		} else {
+			_, err := tx.Exec(ctx, "UPDATE identities SET email = $2, last_login_at = $3 WHERE id = $1",
+				identity.ID, identity.Email, identity.LastLoginAt)
+			if err != nil {
+				return fmt.Errorf("failed to update identity: %w", err)
+			}
+		}
+
+		now := time.Now()
+		result, err := tx.Exec(ctx, `
+			INSERT INTO user_organizations (user_id, organization_id, joined_at, status)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, organization_id) DO NOTHING
+		`, user.ID, organizationID, now, membershipActive)
+		if err != nil {
+			return fmt.Errorf("failed to add organization member: %w", err)
+		}
+		joined := result.RowsAffected() > 0
+		if joined {
+			if err := recordChange(ctx, tx, audit.ActionMemberAdded, audit.TargetUser, user.ID.String(), organizationID,
+				nil, &membership{Status: membershipActive}); err != nil {
+				return err
+			}
+		}
+
+		return syncSAMLRoles(ctx, tx, organizationID, user.ID, groups, joined, now)
+	})
+}
+
+// syncSAMLRoles grants a member the roles mapped to their groups, plus the
+// default role when they just joined, and revokes the other mapped roles.
+// Roles that no group maps to are left to administrators.
+func syncSAMLRoles(ctx context.Context, tx pgx.Tx, organizationID, userID uuid.UUID, groups []string, joined bool, now time.Time) error {
+	if groups == nil {
+		groups = []string{}
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT role_id, bool_or(group_name = ANY($2))
+		FROM saml_role_mappings
+		WHERE organization_id = $1
+		GROUP BY role_id
+	`, organizationID, groups)
+	if err != nil {
+		return fmt.Errorf("failed to get SAML role mappings: %w", err)
+	}
+	wanted := map[uuid.UUID]bool{}
+	var roleID uuid.UUID
+	var asserted bool
+	_, err = pgx.ForEachRow(rows, []any{&roleID, &asserted}, func() error {
+		wanted[roleID] = asserted
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get SAML role mappings: %w", err)
+	}
+
+	if joined {
+		var defaultRoleID *uuid.UUID
+		err := tx.QueryRow(ctx, "SELECT default_role_id FROM saml_connections WHERE organization_id = $1", organizationID).Scan(&defaultRoleID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to get SAML connection: %w", err)
+		}
+		if defaultRoleID != nil {
+			wanted[*defaultRoleID] = true
+		}
+	}
+
+	rows, err = tx.Query(ctx, "SELECT role_id FROM user_roles WHERE user_id = $1 AND organization_id = $2 FOR UPDATE", userID, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to get user roles: %w", err)
+	}
+	current, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return fmt.Errorf("failed to get user roles: %w", err)
+	}
+	held := make(map[uuid.UUID]bool, len(current))
+	for _, id := range current {
+		held[id] = true
+	}
+
+	for roleID, grant := range wanted {
+		switch {
+		case grant && !held[roleID]:
+			_, err := tx.Exec(ctx,
+				"INSERT INTO user_roles (user_id, role_id, organization_id, assigned_at) VALUES ($1, $2, $3, $4)",
+				userID, roleID, organizationID, now)
+			if err != nil {
+				return fmt.Errorf("failed to assign role: %w", err)
+			}
+			if err := recordChange(ctx, tx, audit.ActionRoleAssigned, audit.TargetUser, userID.String(), organizationID,
+				nil, &assignedRole{RoleID: roleID}); err != nil {
+				return err
+			}
+		case !grant && held[roleID]:
+			_, err := tx.Exec(ctx,
+				"DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2 AND organization_id = $3",
+				userID, roleID, organizationID)
+			if err != nil {
+				return fmt.Errorf("failed to remove role: %w", err)
+			}
+			if err := recordChange(ctx, tx, audit.ActionRoleUnassigned, audit.TargetUser, userID.String(), organizationID,
+				&assignedRole{RoleID: roleID}, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func getSAMLConnection(ctx context.Context, q querier, organizationID uuid.UUID) (*models.SAMLConnection, error) {
+	query := `
+		SELECT organization_id, idp_metadata, idp_entity_id, attribute_mapping, default_role_id,
+		       allow_idp_initiated, create_users, enabled, created_at, updated_at
+		FROM saml_connections
+		WHERE organization_id = $1
+	`
+
+	connection := &models.SAMLConnection{}
+	err := q.QueryRow(ctx, query, organizationID).Scan(
+		&connection.OrganizationID,
+		&connection.IdPMetadata,
+		&connection.IdPEntityID,
+		&connection.AttributeMapping,
+		&connection.DefaultRoleID,
+		&connection.AllowIdPInitiated,
+		&connection.CreateUsers,
+		&connection.Enabled,
+		&connection.CreatedAt,
+		&connection.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSAMLConnectionNotFound
+		}
+		return nil, fmt.Errorf("failed to get SAML connection: %w", err)
+	}
+
+	rows, err := q.Query(ctx,
+		"SELECT group_name, role_id FROM saml_role_mappings WHERE organization_id = $1 ORDER BY group_name, role_id",
+		organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SAML role mappings: %w", err)
+	}
+	connection.RoleMappings, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.SAMLRoleMapping, error) {
+		var mapping models.SAMLRoleMapping
+		err := row.Scan(&mapping.Group, &mapping.RoleID)
+		return mapping, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SAML role mappings: %w", err)
+	}
+
+	return connection, nil
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"user-management/internal/models"
+)
+
+func (suite *UserRepositoryTestSuite) TestSAMLProvisionLinksOnlyManagedUsers() {
+	t := suite.T()
+	repo := NewSAMLRepository(suite.db)
+	org, other := suite.createOrganization(), suite.createOrganization()
+	identity := func(subject string) *models.Identity {
+		return &models.Identity{Provider: "saml:" + org.String(), Subject: subject, Email: subject + "@example.com"}
+	}
+
+	// The organization manages the accounts its IdP created, so further
+	// identities may be linked to them.
+	created := &models.User{Email: "ada@example.com", FirstName: "Ada"}
+	require.NoError(t, repo.Provision(suite.ctx, org, created, identity("ada"), nil))
+	require.NoError(t, repo.Provision(suite.ctx, org, created, identity("ada-2"), nil))
+
+	// Once the account joins another organization, it no longer does.
+	_, err := suite.db.Exec(suite.ctx,
+		"INSERT INTO user_organizations (user_id, organization_id, status) VALUES ($1, $2, 'active')", created.ID, other)
+	require.NoError(t, err)
+	require.ErrorIs(t, repo.Provision(suite.ctx, org, created, identity("ada-3"), nil), ErrEmailTaken)
+
+	// Nor does it manage members it did not create.
+	user := newUser(uuid.New(), "grace@example.com")
+	require.NoError(t, suite.repo.Create(suite.ctx, user))
+	_, err = suite.db.Exec(suite.ctx,
+		"INSERT INTO user_organizations (user_id, organization_id, status) VALUES ($1, $2, 'active')", user.ID, org)
+	require.NoError(t, err)
+	require.ErrorIs(t, repo.Provision(suite.ctx, org, user, identity("grace"), nil), ErrEmailTaken)
+}
@@ -0,0 +1,847 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+	"user-management/internal/scim"
+)
+
+var (
+	ErrSCIMNotFound = errors.New("resource not found")
+	ErrSCIMConflict = errors.New("resource already exists")
+	// ErrSCIMInvalidMember is returned when a group member is not a member
+	// of the group's organization.
+	ErrSCIMInvalidMember = errors.New("group member is not a member of the organization")
+	ErrSCIMImmutable     = errors.New("system roles cannot be renamed or deleted")
+	// ErrSCIMNotManaged is returned when a provisioning client changes the
+	// email address or password of an account its organization does not
+	// manage.
+	ErrSCIMNotManaged = errors.New("the account is not managed by the organization")
+)
+
+const (
+	membershipActive    = "active"
+	membershipSuspended = "suspended"
+)
+
+// scimUserAttributes maps filterable User attributes onto the columns of
+// scimUserFrom.
+var scimUserAttributes = map[string]scim.Attribute{
+	"id":                 {Expr: "u.id::text"},
+	"username":           {Expr: "u.email"},
+	"emails":             {Expr: "u.email"},
+	"emails.value":       {Expr: "u.email"},
+	"emails.type":        {Expr: "'work'"},
+	"externalid":         {Expr: "x.external_id", CaseExact: true},
+	"name.givenname":     {Expr: "u.first_name"},
+	"name.familyname":    {Expr: "u.last_name"},
+	"displayname":        {Expr: "(u.first_name || ' ' || u.last_name)"},
+	"phonenumbers":       {Expr: "u.phone_number"},
+	"phonenumbers.value": {Expr: "u.phone_number"},
+	"active":             {Expr: "(uo.status = 'active' AND u.is_active)", Type: scim.TypeBoolean},
+	"meta.created":       {Expr: "u.created_at", Type: scim.TypeDateTime},
+	"meta.lastmodified":  {Expr: "u.updated_at", Type: scim.TypeDateTime},
+}
+
+var scimGroupAttributes = map[string]scim.Attribute{
+	"id":                {Expr: "r.id::text"},
+	"displayname":       {Expr: "r.name"},
+	"externalid":        {Expr: "x.external_id", CaseExact: true},
+	"members":           {Expr: "SELECT m.user_id::text FROM user_roles m WHERE m.role_id = r.id", Type: scim.TypeReference},
+	"members.value":     {Expr: "SELECT m.user_id::text FROM user_roles m WHERE m.role_id = r.id", Type: scim.TypeReference},
+	"meta.created":      {Expr: "r.created_at", Type: scim.TypeDateTime},
+	"meta.lastmodified": {Expr: "r.updated_at", Type: scim.TypeDateTime},
+}
+
+const scimUserColumns = `
+	u.id, u.email, u.password, u.first_name, u.last_name, u.bio, u.phone_number,
+	u.email_verified, u.is_active, u.last_login_at, u.created_at, u.updated_at,
+	uo.organization_id, COALESCE(x.external_id, ''), (uo.status = 'active' AND u.is_active)
+`
+
+const scimUserFrom = `
+	FROM users u
+	INNER JOIN user_organizations uo ON uo.user_id = u.id
+	LEFT JOIN scim_external_ids x
+	       ON x.organization_id = uo.organization_id AND x.resource_type = 'User' AND x.resource_id = u.id
+	WHERE uo.organization_id = $1
+`
+
+const scimGroupColumns = `
+	r.id, r.name, r.description, r.organization_id, r.is_system_role, r.created_at, r.updated_at,
+	COALESCE(x.external_id, '')
+`
+
+const scimGroupFrom = `
+	FROM roles r
+	LEFT JOIN scim_external_ids x
+	       ON x.organization_id = r.organization_id AND x.resource_type = 'Group' AND x.resource_id = r.id
+	WHERE r.organization_id = $1
+`
+
+type membership struct {
+	Status string `json:"status"`
+}
+
+type scimRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSCIMRepository(db *pgxpool.Pool) SCIMRepository {
+	return &scimRepository{db: db}
+}
+
+func (r *scimRepository) CreateToken(ctx context.Context, token *models.SCIMToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	token.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO scim_tokens (id, organization_id, name, token_hash, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			token.ID,
+			token.OrganizationID,
+			token.Name,
+			token.TokenHash,
+			token.CreatedBy,
+			token.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create SCIM token: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionSCIMTokenCreated, audit.TargetSCIMToken, token.ID.String(), token.OrganizationID, nil, token)
+	})
+}
+
+func (r *scimRepository) ListTokens(ctx context.Context, organizationID uuid.UUID) ([]models.SCIMToken, error) {
+	query := `
+		SELECT id, organization_id, name, token_hash, created_by, last_used_at, revoked_at, created_at
+		FROM scim_tokens
+		WHERE organization_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SCIM tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []models.SCIMToken
+	for rows.Next() {
+		token, err := scanSCIMToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate SCIM tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+func (r *scimRepository) RevokeToken(ctx context.Context, organizationID, id uuid.UUID) error {
+	query := `
+		UPDATE scim_tokens
+		SET revoked_at = $3
+		WHERE id = $1 AND organization_id = $2 AND revoked_at IS NULL
+		RETURNING id, organization_id, name, token_hash, created_by, last_used_at, revoked_at, created_at
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		token, err := scanSCIMToken(tx.QueryRow(ctx, query, id, organizationID, time.Now()))
+		if err != nil {
+			return err
+		}
+
+		before := *token
+		before.RevokedAt = nil
+		return recordChange(ctx, tx, audit.ActionSCIMTokenRevoked, audit.TargetSCIMToken, id.String(), organizationID, &before, token)
+	})
+}
+
+// AuthenticateToken returns the unrevoked token with the given hash and
+// records its use.
+func (r *scimRepository) AuthenticateToken(ctx context.Context, tokenHash string) (*models.SCIMToken, error) {
+	query := `
+		UPDATE scim_tokens
+		SET last_used_at = $2
+		WHERE token_hash = $1 AND revoked_at IS NULL
+		RETURNING id, organization_id, name, token_hash, created_by, last_used_at, revoked_at, created_at
+	`
+
+	return scanSCIMToken(r.db.QueryRow(ctx, query, tokenHash, time.Now()))
+}
+
+func (r *scimRepository) ListUsers(ctx context.Context, organizationID uuid.UUID, filter scim.Expr, offset, limit int) ([]models.SCIMUser, int, error) {
+	where, args, err := scimWhere(filter, scimUserAttributes, organizationID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.QueryRow(ctx, "SELECT COUNT(*) "+scimUserFrom+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count SCIM users: %w", err)
+	}
+
+	args = append(args, limit, offset)
+	query := "SELECT " + scimUserColumns + scimUserFrom + where +
+		fmt.Sprintf(" ORDER BY u.created_at, u.id LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list SCIM users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.SCIMUser
+	for rows.Next() {
+		user, err := scanSCIMUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate SCIM users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+func (r *scimRepository) GetUser(ctx context.Context, organizationID, id uuid.UUID) (*models.SCIMUser, error) {
+	query := "SELECT " + scimUserColumns + scimUserFrom + " AND u.id = $2"
+	return scanSCIMUser(r.db.QueryRow(ctx, query, organizationID, id))
+}
+
+// CreateUser creates a user and their membership of the organization, which
+// becomes the organization that provisioned the account. Users are only
+// ever created, never linked: an email address that already belongs to an
+// account is a conflict, so a provisioning client cannot take over accounts
+// it does not own.
+func (r *scimRepository) CreateUser(ctx context.Context, member *models.SCIMUser) error {
+	user := &member.User
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	now := time.Now()
+	user.IsActive = true
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	query := `
+		INSERT INTO users (id, email, password, first_name, last_name, bio, phone_number, email_verified, is_active,
+		                   created_at, updated_at, provisioned_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			user.ID,
+			user.Email,
+			user.Password,
+			user.FirstName,
+			user.LastName,
+			user.Bio,
+			user.PhoneNumber,
+			user.EmailVerified,
+			user.IsActive,
+			user.CreatedAt,
+			user.UpdatedAt,
+			member.OrganizationID,
+		)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrSCIMConflict
+			}
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		if err := recordChange(ctx, tx, audit.ActionUserCreated, audit.TargetUser, user.ID.String(), member.OrganizationID, nil, user); err != nil {
+			return err
+		}
+
+		status := membershipStatus(member.Active)
+		_, err = tx.Exec(ctx,
+			"INSERT INTO user_organizations (user_id, organization_id, joined_at, status) VALUES ($1, $2, $3, $4)",
+			user.ID, member.OrganizationID, now, status)
+		if err != nil {
+			return fmt.Errorf("failed to add organization member: %w", err)
+		}
+
+		if err := recordChange(ctx, tx, audit.ActionMemberAdded, audit.TargetUser, user.ID.String(), member.OrganizationID,
+			nil, &membership{Status: status}); err != nil {
+			return err
+		}
+
+		return setExternalID(ctx, tx, member.OrganizationID, scim.ResourceTypeUser, user.ID, member.ExternalID)
+	})
+}
+
+// UpdateUser saves the profile, the membership status and the external ID of
+// a member. The password is only changed when member.User.Password is set.
+// The account itself is shared by every organization it belongs to, so its
+// profile is only changed when the organization manages it: when the
+// organization provisioned it and it belongs to no other organization.
+// Otherwise changing the email address or password is ErrSCIMNotManaged,
+// and the rest of the profile is kept.
+func (r *scimRepository) UpdateUser(ctx context.Context, member *models.SCIMUser) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx,
+			"SELECT status FROM user_organizations WHERE user_id = $1 AND organization_id = $2 FOR UPDATE",
+			member.User.ID, member.OrganizationID).Scan(&status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrSCIMNotFound
+			}
+			return fmt.Errorf("failed to get organization member: %w", err)
+		}
+
+		before, err := lockUser(ctx, tx, member.User.ID)
+		if err != nil {
+			return err
+		}
+
+		managed, err := managesUser(ctx, tx, member.OrganizationID, before.ID)
+		if err != nil {
+			return err
+		}
+
+		after := *before
+		if managed {
+			after.Email = member.User.Email
+			after.FirstName = member.User.FirstName
+			after.LastName = member.User.LastName
+			after.PhoneNumber = member.User.PhoneNumber
+			if member.User.Password != "" {
+				after.Password = member.User.Password
+			}
+			after.UpdatedAt = time.Now()
+
+			_, err = tx.Exec(ctx, `
+				UPDATE users
+				SET email = $2, password = $3, first_name = $4, last_name = $5, phone_number = $6, updated_at = $7
+				WHERE id = $1
+			`, after.ID, after.Email, after.Password, after.FirstName, after.LastName, after.PhoneNumber, after.UpdatedAt)
+			if err != nil {
+				if isUniqueViolation(err) {
+					return ErrSCIMConflict
+				}
+				return fmt.Errorf("failed to update user: %w", err)
+			}
+
+			if err := recordChange(ctx, tx, audit.ActionUserUpdated, audit.TargetUser, after.ID.String(), member.OrganizationID, before, &after); err != nil {
+				return err
+			}
+		} else if !strings.EqualFold(member.User.Email, before.Email) || member.User.Password != "" {
+			return ErrSCIMNotManaged
+		}
+		member.User = after
+
+		if newStatus := membershipStatus(member.Active); newStatus != status {
+			_, err = tx.Exec(ctx,
+				"UPDATE user_organizations SET status = $3 WHERE user_id = $1 AND organization_id = $2",
+				after.ID, member.OrganizationID, newStatus)
+			if err != nil {
+				return fmt.Errorf("failed to update organization member: %w", err)
+			}
+
+			if err := recordChange(ctx, tx, audit.ActionMemberUpdated, audit.TargetUser, after.ID.String(), member.OrganizationID,
+				&membership{Status: status}, &membership{Status: newStatus}); err != nil {
+				return err
+			}
+		}
+		member.Active = member.Active && after.IsActive
+
+		return setExternalID(ctx, tx, member.OrganizationID, scim.ResourceTypeUser, after.ID, member.ExternalID)
+	})
+}
+
+// managesUser reports whether organizationID provisioned the account of
+// userID and is its only organization. The caller must hold the lock of the
+// user row, which keeps other organizations from adding the account.
+func managesUser(ctx context.Context, tx pgx.Tx, organizationID, userID uuid.UUID) (bool, error) {
+	var managed bool
+	err := tx.QueryRow(ctx, `
+		SELECT u.provisioned_by IS NOT DISTINCT FROM $1 AND NOT EXISTS (
+			SELECT 1 FROM user_organizations o WHERE o.user_id = u.id AND o.organization_id <> $1
+		)
+		FROM users u
+		WHERE u.id = $2
+	`, organizationID, userID).Scan(&managed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check account management: %w", err)
+	}
+	return managed, nil
+}
+
+// DeleteUser removes a user from the organization together with the roles
+// they held in it. The account itself remains, since it may belong to other
+// organizations.
+func (r *scimRepository) DeleteUser(ctx context.Context, organizationID, id uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx,
+			"DELETE FROM user_organizations WHERE user_id = $1 AND organization_id = $2 RETURNING status",
+			id, organizationID).Scan(&status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrSCIMNotFound
+			}
+			return fmt.Errorf("failed to remove organization member: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM user_roles WHERE user_id = $1 AND organization_id = $2", id, organizationID)
+		if err != nil {
+			return fmt.Errorf("failed to remove member roles: %w", err)
+		}
+
+		if err := setExternalID(ctx, tx, organizationID, scim.ResourceTypeUser, id, ""); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionMemberRemoved, audit.TargetUser, id.String(), organizationID,
+			&membership{Status: status}, nil)
+	})
+}
+
+// ListGroups returns a page of the organization's roles as groups. Members
+// are only loaded when withMembers is set, since clients commonly exclude
+// them from list requests.
+func (r *scimRepository) ListGroups(ctx context.Context, organizationID uuid.UUID, filter scim.Expr, offset, limit int, withMembers bool) ([]models.SCIMGroup, int, error) {
+	where, args, err := scimWhere(filter, scimGroupAttributes, organizationID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.QueryRow(ctx, "SELECT COUNT(*) "+scimGroupFrom+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count SCIM groups: %w", err)
+	}
+
+	args = append(args, limit, offset)
+	query := "SELECT " + scimGroupColumns + scimGroupFrom + where +
+		fmt.Sprintf(" ORDER BY r.created_at, r.id LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list SCIM groups: %w", err)
+	}
+
+	var groups []models.SCIMGroup
+	for rows.Next() {
+		group, err := scanSCIMGroup(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		groups = append(groups, *group)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate SCIM groups: %w", err)
+	}
+
+	if withMembers && len(groups) > 0 {
+		if err := loadGroupMembers(ctx, r.db, groups); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return groups, total, nil
+}
+
+func (r *scimRepository) GetGroup(ctx context.Context, organizationID, id uuid.UUID) (*models.SCIMGroup, error) {
+	query := "SELECT " + scimGroupColumns + scimGroupFrom + " AND r.id = $2"
+	group, err := scanSCIMGroup(r.db.QueryRow(ctx, query, organizationID, id))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []models.SCIMGroup{*group}
+	if err := loadGroupMembers(ctx, r.db, groups); err != nil {
+		return nil, err
+	}
+	return &groups[0], nil
+}
+
+func (r *scimRepository) CreateGroup(ctx context.Context, group *models.SCIMGroup) error {
+	role := &group.Role
+	if role.ID == uuid.Nil {
+		role.ID = uuid.New()
+	}
+	now := time.Now()
+	role.IsSystemRole = false
+	role.CreatedAt = now
+	role.UpdatedAt = now
+
+	query := `
+		INSERT INTO roles (id, name, description, organization_id, is_system_role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			role.ID,
+			role.Name,
+			role.Description,
+			role.OrganizationID,
+			role.IsSystemRole,
+			role.CreatedAt,
+			role.UpdatedAt,
+		)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrSCIMConflict
+			}
+			return fmt.Errorf("failed to create role: %w", err)
+		}
+
+		if err := recordChange(ctx, tx, audit.ActionRoleCreated, audit.TargetRole, role.ID.String(), role.OrganizationID, nil, role); err != nil {
+			return err
+		}
+
+		if err := setExternalID(ctx, tx, role.OrganizationID, scim.ResourceTypeGroup, role.ID, group.ExternalID); err != nil {
+			return err
+		}
+
+		return setGroupMembers(ctx, tx, group)
+	})
+}
+
+// UpdateGroup saves the name, external ID and member list of a group,
+// assigning and unassigning the role as needed.
+func (r *scimRepository) UpdateGroup(ctx context.Context, group *models.SCIMGroup) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockRole(ctx, tx, group.Role.ID)
+		if err != nil || before.OrganizationID != group.Role.OrganizationID {
+			return ErrSCIMNotFound
+		}
+
+		if before.Name != group.Role.Name {
+			if before.IsSystemRole {
+				return ErrSCIMImmutable
+			}
+
+			after := *before
+			after.Name = group.Role.Name
+			after.UpdatedAt = time.Now()
+
+			_, err := tx.Exec(ctx, "UPDATE roles SET name = $2, updated_at = $3 WHERE id = $1", after.ID, after.Name, after.UpdatedAt)
+			if err != nil {
+				if isUniqueViolation(err) {
+					return ErrSCIMConflict
+				}
+				return fmt.Errorf("failed to update role: %w", err)
+			}
+
+			if err := recordChange(ctx, tx, audit.ActionRoleUpdated, audit.TargetRole, after.ID.String(), after.OrganizationID, before, &after); err != nil {
+				return err
+			}
+			before = &after
+		}
+		group.Role = *before
+
+		if err := setExternalID(ctx, tx, group.Role.OrganizationID, scim.ResourceTypeGroup, group.Role.ID, group.ExternalID); err != nil {
+			return err
+		}
+
+		return setGroupMembers(ctx, tx, group)
+	})
+}
+
+func (r *scimRepository) DeleteGroup(ctx context.Context, organizationID, id uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockRole(ctx, tx, id)
+		if err != nil || before.OrganizationID != organizationID {
+			return ErrSCIMNotFound
+		}
+		if before.IsSystemRole {
+			return ErrSCIMImmutable
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM roles WHERE id = $1", id); err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+
+		if err := setExternalID(ctx, tx, organizationID, scim.ResourceTypeGroup, id, ""); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionRoleDeleted, audit.TargetRole, id.String(), organizationID, before, nil)
+	})
+}
+
+// setGroupMembers makes the holders of the group's role match group.Members.
+// New members must belong to the organization.
+func setGroupMembers(ctx context.Context, tx pgx.Tx, group *models.SCIMGroup) error {
+	role := group.Role
+
+	rows, err := tx.Query(ctx, "SELECT user_id FROM user_roles WHERE role_id = $1 FOR UPDATE", role.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get role members: %w", err)
+	}
+	current, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return fmt.Errorf("failed to get role members: %w", err)
+	}
+
+	existing := make(map[uuid.UUID]bool, len(current))
+	for _, id := range current {
+		existing[id] = true
+	}
+	desired := make(map[uuid.UUID]bool, len(group.Members))
+	var added []uuid.UUID
+	for _, member := range group.Members {
+		desired[member.UserID] = true
+		if !existing[member.UserID] {
+			added = append(added, member.UserID)
+		}
+	}
+
+	if len(added) > 0 {
+		rows, err := tx.Query(ctx,
+			"SELECT user_id FROM user_organizations WHERE organization_id = $1 AND user_id = ANY($2)",
+			role.OrganizationID, added)
+		if err != nil {
+			return fmt.Errorf("failed to check organization members: %w", err)
+		}
+		members, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+		if err != nil {
+			return fmt.Errorf("failed to check organization members: %w", err)
+		}
+		if len(members) != len(added) {
+			known := make(map[uuid.UUID]bool, len(members))
+			for _, id := range members {
+				known[id] = true
+			}
+			for _, id := range added {
+				if !known[id] {
+					return fmt.Errorf("%w: %s", ErrSCIMInvalidMember, id)
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, userID := range added {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO user_roles (user_id, role_id, organization_id, assigned_at) VALUES ($1, $2, $3, $4)",
+			userID, role.ID, role.OrganizationID, now)
+		if err != nil {
+			return fmt.Errorf("failed to assign role: %w", err)
+		}
+		if err := recordChange(ctx, tx, audit.ActionRoleAssigned, audit.TargetUser, userID.String(), role.OrganizationID,
+			nil, &assignedRole{RoleID: role.ID}); err != nil {
+			return err
+		}
+	}
+
+	for _, userID := range current {
+		if desired[userID] {
+			continue
+		}
+		_, err := tx.Exec(ctx, "DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2", userID, role.ID)
+		if err != nil {
+			return fmt.Errorf("failed to remove role: %w", err)
+		}
+		if err := recordChange(ctx, tx, audit.ActionRoleUnassigned, audit.TargetUser, userID.String(), role.OrganizationID,
+			&assignedRole{RoleID: role.ID}, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadGroupMembers(ctx context.Context, q querier, groups []models.SCIMGroup) error {
+	index := make(map[uuid.UUID]int, len(groups))
+	ids := make([]uuid.UUID, len(groups))
+	for i := range groups {
+		index[groups[i].Role.ID] = i
+		ids[i] = groups[i].Role.ID
+		groups[i].Members = []models.SCIMGroupMember{}
+	}
+
+	query := `
+		SELECT ur.role_id, ur.user_id, u.email
+		FROM user_roles ur
+		INNER JOIN users u ON u.id = ur.user_id
+		WHERE ur.role_id = ANY($1)
+		ORDER BY u.email
+	`
+
+	rows, err := q.Query(ctx, query, ids)
+	if err != nil {
+		return fmt.Errorf("failed to get group members: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roleID uuid.UUID
+		var member models.SCIMGroupMember
+		if err := rows.Scan(&roleID, &member.UserID, &member.Display); err != nil {
+			return fmt.Errorf("failed to scan group member: %w", err)
+		}
+		i := index[roleID]
+		groups[i].Members = append(groups[i].Members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate group members: %w", err)
+	}
+
+	return nil
+}
+
+func setExternalID(ctx context.Context, tx pgx.Tx, organizationID uuid.UUID, resourceType string, resourceID uuid.UUID, externalID string) error {
+	if externalID == "" {
+		_, err := tx.Exec(ctx,
+			"DELETE FROM scim_external_ids WHERE organization_id = $1 AND resource_type = $2 AND resource_id = $3",
+			organizationID, resourceType, resourceID)
+		if err != nil {
+			return fmt.Errorf("failed to clear external ID: %w", err)
+		}
+		return nil
+	}
+
+	query := `
+		INSERT INTO scim_external_ids (organization_id, resource_type, resource_id, external_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, resource_type, resource_id) DO UPDATE SET external_id = EXCLUDED.external_id
+	`
+
+	if _, err := tx.Exec(ctx, query, organizationID, resourceType, resourceID, externalID); err != nil {
+		if isUniqueViolation(err) {
+			return ErrSCIMConflict
+		}
+		return fmt.Errorf("failed to set external ID: %w", err)
+	}
+	return nil
+}
+
+// scimWhere compiles filter into a condition appended to a FROM clause whose
+// $1 is the organization ID.
+func scimWhere(filter scim.Expr, attributes map[string]scim.Attribute, organizationID uuid.UUID) (string, []interface{}, error) {
+	args := []interface{}{organizationID}
+	if filter == nil {
+		return "", args, nil
+	}
+
+	clause, filterArgs, err := scim.Compile(filter, attributes, 2)
+	if err != nil {
+		return "", nil, err
+	}
+	return " AND " + clause, append(args, filterArgs...), nil
+}
+
+func membershipStatus(active bool) string {
+	if active {
+		return membershipActive
+	}
+	return membershipSuspended
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func scanSCIMToken(row pgx.Row) (*models.SCIMToken, error) {
+	token := &models.SCIMToken{}
+	err := row.Scan(
+		&token.ID,
+		&token.OrganizationID,
+		&token.Name,
+		&token.TokenHash,
+		&token.CreatedBy,
+		&token.LastUsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSCIMNotFound
+		}
+		return nil, fmt.Errorf("failed to get SCIM token: %w", err)
+	}
+
+	return token, nil
+}
+
+func scanSCIMUser(row pgx.Row) (*models.SCIMUser, error) {
+	member := &models.SCIMUser{}
+	user := &member.User
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.FirstName,
+		&user.LastName,
+		&user.Bio,
+		&user.PhoneNumber,
+		&user.EmailVerified,
+		&user.IsActive,
+		&user.LastLoginAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&member.OrganizationID,
+		&member.ExternalID,
+		&member.Active,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSCIMNotFound
+		}
+		return nil, fmt.Errorf("failed to get SCIM user: %w", err)
+	}
+
+	return member, nil
+}
+
+func scanSCIMGroup(row pgx.Row) (*models.SCIMGroup, error) {
+	group := &models.SCIMGroup{}
+	role := &group.Role
+	err := row.Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.OrganizationID,
+		&role.IsSystemRole,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+		&group.ExternalID,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSCIMNotFound
+		}
+		return nil, fmt.Errorf("failed to get SCIM group: %w", err)
+	}
+
+	return group, nil
+}
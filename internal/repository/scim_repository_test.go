@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"user-management/internal/models"
+)
+
+func (suite *UserRepositoryTestSuite) createOrganization() uuid.UUID {
+	id := uuid.New()
+	_, err := suite.db.Exec(suite.ctx, "INSERT INTO organizations (id, name, slug) VALUES ($1, $2, $3)",
+		id, "Org "+id.String(), id.String())
+	require.NoError(suite.T(), err)
+	return id
+}
+
+func (suite *UserRepositoryTestSuite) TestSCIMUpdateUserOfSeveralOrganizations() {
+	t := suite.T()
+	repo := NewSCIMRepository(suite.db)
+	org, other := suite.createOrganization(), suite.createOrganization()
+
+	member := &models.SCIMUser{
+		User:           models.User{Email: "jane@example.com", Password: "hash", FirstName: "Jane", LastName: "Doe"},
+		OrganizationID: org,
+		Active:         true,
+	}
+	require.NoError(t, repo.CreateUser(suite.ctx, member))
+	userID := member.User.ID
+
+	// The organization manages the accounts it provisioned.
+	member.User.Email = "jane.doe@example.com"
+	member.User.Password = "new hash"
+	require.NoError(t, repo.UpdateUser(suite.ctx, member))
+
+	// Once the account joins another organization, it no longer does.
+	_, err := suite.db.Exec(suite.ctx,
+		"INSERT INTO user_organizations (user_id, organization_id, status) VALUES ($1, $2, 'active')", userID, other)
+	require.NoError(t, err)
+
+	for _, update := range []func(*models.User){
+		func(user *models.User) { user.Email = "attacker@example.com" },
+		func(user *models.User) { user.Password = "attacker hash" },
+	} {
+		changed := &models.SCIMUser{User: models.User{ID: userID, Email: "jane.doe@example.com", FirstName: "Jane"}, OrganizationID: org, Active: true}
+		update(&changed.User)
+		require.ErrorIs(t, repo.UpdateUser(suite.ctx, changed), ErrSCIMNotManaged)
+	}
+
+	changed := &models.SCIMUser{
+		User:           models.User{ID: userID, Email: "Jane.Doe@example.com", FirstName: "Mallory"},
+		OrganizationID: org,
+		ExternalID:     "00u1",
+		Active:         false,
+	}
+	require.NoError(t, repo.UpdateUser(suite.ctx, changed), "the membership can still be changed")
+	require.False(t, changed.Active)
+	require.Equal(t, "Jane", changed.User.FirstName, "the profile is kept")
+
+	stored, err := NewUserRepository(suite.db).GetByID(suite.ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, "jane.doe@example.com", stored.Email)
+	require.Equal(t, "new hash", stored.Password)
+	require.Equal(t, "Jane", stored.FirstName)
+
+	// Members the organization did not provision are not managed either.
+	user := newUser(uuid.New(), "ada@example.com")
+	require.NoError(t, suite.repo.Create(suite.ctx, user))
+	_, err = suite.db.Exec(suite.ctx,
+		"INSERT INTO user_organizations (user_id, organization_id, status) VALUES ($1, $2, 'active')", user.ID, org)
+	require.NoError(t, err)
+	invited := &models.SCIMUser{User: models.User{ID: user.ID, Email: "mallory@example.com"}, OrganizationID: org, Active: true}
+	require.ErrorIs(t, repo.UpdateUser(suite.ctx, invited), ErrSCIMNotManaged)
+}
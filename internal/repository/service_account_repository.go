@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var ErrServiceAccountNotFound = errors.New("service account not found")
+
+// PrincipalTypeServiceAccount marks the users rows of service accounts.
+const PrincipalTypeServiceAccount = "service_account"
+
+const serviceAccountColumns = `sa.id, sa.organization_id, sa.name, sa.description, sa.client_id, sa.secret_hash,
+			sa.previous_secret_hash, sa.previous_secret_expires_at, u.is_active, sa.secret_rotated_at,
+			sa.last_used_at, sa.last_used_ip, sa.created_by, sa.created_at, sa.updated_at`
+
+const serviceAccountFrom = `FROM service_accounts sa INNER JOIN users u ON u.id = sa.id`
+
+type serviceAccountRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewServiceAccountRepository(db *pgxpool.Pool) ServiceAccountRepository {
+	return &serviceAccountRepository{db: db}
+}
+
+// Create stores the account and its users row. The row gets an address
+// under the reserved .invalid domain, since users require a unique email,
+// and no password, so it cannot log in.
+func (r *serviceAccountRepository) Create(ctx context.Context, account *models.ServiceAccount) error {
+	if account.ID == uuid.Nil {
+		account.ID = uuid.New()
+	}
+	if account.OrganizationID == uuid.Nil {
+		return fmt.Errorf("organization ID is required")
+	}
+
+	now := time.Now()
+	account.IsActive = true
+	account.SecretRotatedAt = now
+	account.CreatedAt = now
+	account.UpdatedAt = now
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO users (id, email, password, first_name, last_name, is_active, principal_type, created_at, updated_at)
+			VALUES ($1, $2, '', $3, '', TRUE, $4, $5, $5)
+		`, account.ID, account.ID.String()+"@service-accounts.invalid", truncate(account.Name, 100), PrincipalTypeServiceAccount, now)
+		if err != nil {
+			return fmt.Errorf("failed to create service account user: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO service_accounts (id, organization_id, name, description, client_id, secret_hash,
+			                              secret_rotated_at, created_by, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`,
+			account.ID,
+			account.OrganizationID,
+			account.Name,
+			account.Description,
+			account.ClientID,
+			account.SecretHash,
+			account.SecretRotatedAt,
+			account.CreatedBy,
+			account.CreatedAt,
+			account.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create service account: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionServiceAccountCreated, audit.TargetServiceAccount, account.ID.String(), account.OrganizationID, nil, account)
+	})
+}
+
+func (r *serviceAccountRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.ServiceAccount, error) {
+	query := "SELECT " + serviceAccountColumns + " " + serviceAccountFrom + " WHERE sa.id = $1"
+	return scanServiceAccount(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *serviceAccountRepository) GetByClientID(ctx context.Context, clientID string) (*models.ServiceAccount, error) {
+	query := "SELECT " + serviceAccountColumns + " " + serviceAccountFrom + " WHERE sa.client_id = $1"
+	return scanServiceAccount(r.db.QueryRow(ctx, query, clientID))
+}
+
+func (r *serviceAccountRepository) List(ctx context.Context, organizationID uuid.UUID) ([]models.ServiceAccount, error) {
+	query := "SELECT " + serviceAccountColumns + " " + serviceAccountFrom + " WHERE sa.organization_id = $1 ORDER BY sa.name"
+
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []models.ServiceAccount
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate service accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// Update saves the name, description and active flag of an account.
+func (r *serviceAccountRepository) Update(ctx context.Context, account *models.ServiceAccount) error {
+	account.UpdatedAt = time.Now()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockServiceAccount(ctx, tx, account.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			"UPDATE service_accounts SET name = $2, description = $3, updated_at = $4 WHERE id = $1",
+			account.ID, account.Name, account.Description, account.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to update service account: %w", err)
+		}
+		_, err = tx.Exec(ctx,
+			"UPDATE users SET first_name = $2, is_active = $3, updated_at = $4 WHERE id = $1",
+			account.ID, truncate(account.Name, 100), account.IsActive, account.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to update service account user: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionServiceAccountUpdated, audit.TargetServiceAccount, account.ID.String(), before.OrganizationID, before, account)
+	})
+}
+
+// RotateSecret replaces the secret of an account. The current secret
+// becomes the previous one and keeps working until previousExpiresAt.
+func (r *serviceAccountRepository) RotateSecret(ctx context.Context, id uuid.UUID, secretHash string, previousExpiresAt time.Time) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockServiceAccount(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		_, err = tx.Exec(ctx, `
+			UPDATE service_accounts
+			SET previous_secret_hash = secret_hash, previous_secret_expires_at = $3, secret_hash = $2,
+			    secret_rotated_at = $4, updated_at = $4
+			WHERE id = $1
+		`, id, secretHash, previousExpiresAt, now)
+		if err != nil {
+			return fmt.Errorf("failed to rotate service account secret: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionServiceAccountRotated, audit.TargetServiceAccount, id.String(), before.OrganizationID, nil, nil)
+	})
+}
+
+// RecordUse stores when and from where an account last obtained a token.
+// It is not audited.
+func (r *serviceAccountRepository) RecordUse(ctx context.Context, id uuid.UUID, usedAt time.Time, ip string) error {
+	_, err := r.db.Exec(ctx,
+		"UPDATE service_accounts SET last_used_at = $2, last_used_ip = $3 WHERE id = $1",
+		id, usedAt, ip)
+	if err != nil {
+		return fmt.Errorf("failed to record service account use: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the account together with its users row and roles.
+func (r *serviceAccountRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockServiceAccount(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", id); err != nil {
+			return fmt.Errorf("failed to delete service account: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionServiceAccountDeleted, audit.TargetServiceAccount, id.String(), before.OrganizationID, before, nil)
+	})
+}
+
+func lockServiceAccount(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.ServiceAccount, error) {
+	query := "SELECT " + serviceAccountColumns + " " + serviceAccountFrom + " WHERE sa.id = $1 FOR UPDATE"
+	return scanServiceAccount(tx.QueryRow(ctx, query, id))
+}
+
+func scanServiceAccount(row pgx.Row) (*models.ServiceAccount, error) {
+	account := &models.ServiceAccount{}
+	var previousSecretHash *string
+	err := row.Scan(
+		&account.ID,
+		&account.OrganizationID,
+		&account.Name,
+		&account.Description,
+		&account.ClientID,
+		&account.SecretHash,
+		&previousSecretHash,
+		&account.PreviousSecretExpiresAt,
+		&account.IsActive,
+		&account.SecretRotatedAt,
+		&account.LastUsedAt,
+		&account.LastUsedIP,
+		&account.CreatedBy,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service account: %w", err)
+	}
+	account.PreviousSecretHash = models.StringValue(previousSecretHash)
+	return account, nil
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,371 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+// ErrSessionNotFound is returned for unknown sessions and, where only
+// active sessions qualify, for revoked and expired ones.
+var ErrSessionNotFound = errors.New("session not found")
+
+type sessionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSessionRepository(db *pgxpool.Pool) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+// Create stores a new session. When the session's organization limits the
+// sessions per user, the user's least recently used sessions in it are
+// revoked to make room, and their IDs returned.
+func (r *sessionRepository) Create(ctx context.Context, session *models.Session) ([]uuid.UUID, error) {
+	if session.ID == uuid.Nil {
+		session.ID = uuid.New()
+	}
+	if session.AMR == nil {
+		session.AMR = []string{}
+	}
+	if session.Scopes == nil {
+		session.Scopes = []string{}
+	}
+	session.CreatedAt = time.Now()
+	session.LastSeenAt = session.CreatedAt
+
+	query := `
+		INSERT INTO sessions (
+			id, user_id, organization_id, refresh_token_hash, device, user_agent, ip_address, amr,
+			client_id, scopes, grant_type, created_at, last_seen_at, expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+
+	var evicted []uuid.UUID
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		now := session.CreatedAt
+		_, err := tx.Exec(ctx,
+			"DELETE FROM sessions WHERE user_id = $1 AND (expires_at < $2 OR revoked_at IS NOT NULL)",
+			session.UserID, now)
+		if err != nil {
+			return fmt.Errorf("failed to delete ended sessions: %w", err)
+		}
+
+		if session.OrganizationID != nil {
+			evicted, err = r.evict(ctx, tx, session.UserID, *session.OrganizationID, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, query,
+			session.ID,
+			session.UserID,
+			session.OrganizationID,
+			session.RefreshTokenHash,
+			session.Device,
+			session.UserAgent,
+			session.IPAddress,
+			session.AMR,
+			session.ClientID,
+			session.Scopes,
+			session.GrantType,
+			session.CreatedAt,
+			session.LastSeenAt,
+			session.ExpiresAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return evicted, nil
+}
+
+// evict revokes the least recently used sessions of the user in the
+// organization so that one more fits within its policy.
+func (r *sessionRepository) evict(ctx context.Context, tx pgx.Tx, userID, organizationID uuid.UUID, now time.Time) ([]uuid.UUID, error) {
+	policy, err := getSessionPolicy(ctx, tx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if policy.MaxSessionsPerUser <= 0 {
+		return nil, nil
+	}
+
+	// Serializes concurrent logins of the user, which could otherwise both
+	// see room for one more session.
+	if _, err := lockUser(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE sessions
+		SET revoked_at = $4
+		WHERE id IN (
+			SELECT id
+			FROM sessions
+			WHERE user_id = $1 AND organization_id = $2 AND revoked_at IS NULL AND expires_at > $4
+			ORDER BY last_seen_at DESC
+			OFFSET $3
+		)
+		RETURNING ` + sessionColumns
+
+	return revokeSessions(ctx, tx, query, userID, organizationID, policy.MaxSessionsPerUser-1, now)
+}
+
+func (r *sessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE id = $1
+	`
+
+	return scanSession(r.db.QueryRow(ctx, query, id))
+}
+
+// ListActive returns the unrevoked, unexpired sessions of a user, most
+// recently used first.
+func (r *sessionRepository) ListActive(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// Rotate replaces the refresh token of an active session and records its
+// use, provided the current token is still previousHash. Otherwise it
+// returns ErrSessionNotFound, so that a token is exchanged at most once.
+func (r *sessionRepository) Rotate(ctx context.Context, session *models.Session, previousHash string) error {
+	query := `
+		UPDATE sessions
+		SET refresh_token_hash = $2, last_seen_at = $3, ip_address = $4, user_agent = $5
+		WHERE id = $1 AND refresh_token_hash = $6 AND revoked_at IS NULL AND expires_at > $3
+	`
+
+	result, err := r.db.Exec(ctx, query,
+		session.ID,
+		session.RefreshTokenHash,
+		session.LastSeenAt,
+		session.IPAddress,
+		session.UserAgent,
+		previousHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Touch records that a session was used.
+func (r *sessionRepository) Touch(ctx context.Context, id uuid.UUID, lastSeenAt time.Time, ipAddress string) error {
+	_, err := r.db.Exec(ctx,
+		"UPDATE sessions SET last_seen_at = $2, ip_address = $3 WHERE id = $1",
+		id, lastSeenAt, ipAddress)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// Revoke ends an active session of the user.
+func (r *sessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > $3
+		RETURNING ` + sessionColumns
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		revoked, err := revokeSessions(ctx, tx, query, id, userID, time.Now())
+		if err != nil {
+			return err
+		}
+		if len(revoked) == 0 {
+			return ErrSessionNotFound
+		}
+		return nil
+	})
+}
+
+// RevokeAll ends the active sessions of the user other than except, which
+// may be uuid.Nil, and returns their IDs.
+func (r *sessionRepository) RevokeAll(ctx context.Context, userID, except uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $3
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > $3
+		RETURNING ` + sessionColumns
+
+	var revoked []uuid.UUID
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var err error
+		revoked, err = revokeSessions(ctx, tx, query, userID, except, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+// RevokeClient ends the active sessions of a client, only those of userID
+// unless it is uuid.Nil, and returns their IDs.
+func (r *sessionRepository) RevokeClient(ctx context.Context, clientID, userID uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $3
+		WHERE client_id = $1 AND ($2 = $4 OR user_id = $2) AND revoked_at IS NULL AND expires_at > $3
+		RETURNING ` + sessionColumns
+
+	var revoked []uuid.UUID
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var err error
+		revoked, err = revokeSessions(ctx, tx, query, clientID, userID, time.Now(), uuid.Nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+func (r *sessionRepository) GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.SessionPolicy, error) {
+	return getSessionPolicy(ctx, r.db, organizationID)
+}
+
+func (r *sessionRepository) SetPolicy(ctx context.Context, policy *models.SessionPolicy) error {
+	query := `
+		INSERT INTO session_policies (organization_id, max_sessions_per_user, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			max_sessions_per_user = EXCLUDED.max_sessions_per_user,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := getSessionPolicy(ctx, tx, policy.OrganizationID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, query, policy.OrganizationID, policy.MaxSessionsPerUser, time.Now()); err != nil {
+			return fmt.Errorf("failed to update session policy: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionSessionPolicyUpdated, audit.TargetOrganization, policy.OrganizationID.String(),
+			policy.OrganizationID, before, policy)
+	})
+}
+
+func getSessionPolicy(ctx context.Context, q querier, organizationID uuid.UUID) (*models.SessionPolicy, error) {
+	policy := &models.SessionPolicy{OrganizationID: organizationID}
+	err := q.QueryRow(ctx,
+		"SELECT max_sessions_per_user FROM session_policies WHERE organization_id = $1",
+		organizationID).Scan(&policy.MaxSessionsPerUser)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get session policy: %w", err)
+	}
+	return policy, nil
+}
+
+// revokeSessions runs an UPDATE ... RETURNING query that revokes sessions,
+// audits each and returns their IDs.
+func revokeSessions(ctx context.Context, tx pgx.Tx, query string, args ...any) ([]uuid.UUID, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	sessions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*models.Session, error) {
+		return scanSession(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(sessions))
+	for _, session := range sessions {
+		before := *session
+		before.RevokedAt = nil
+
+		organizationID := uuid.Nil
+		if session.OrganizationID != nil {
+			organizationID = *session.OrganizationID
+		}
+		err := recordChange(ctx, tx, audit.ActionSessionRevoked, audit.TargetSession, session.ID.String(), organizationID, &before, session)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, session.ID)
+	}
+	return ids, nil
+}
+
+const sessionColumns = `id, user_id, organization_id, refresh_token_hash, device, user_agent, ip_address, amr,
+			client_id, scopes, grant_type, created_at, last_seen_at, expires_at, revoked_at`
+
+func scanSession(row pgx.Row) (*models.Session, error) {
+	session := &models.Session{}
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.OrganizationID,
+		&session.RefreshTokenHash,
+		&session.Device,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.AMR,
+		&session.ClientID,
+		&session.Scopes,
+		&session.GrantType,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return session, nil
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+const signingKeyColumns = `id, algorithm, state, private_key, created_at, activated_at, retired_at, expires_at`
+
+type signingKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSigningKeyRepository(db *pgxpool.Pool) SigningKeyRepository {
+	return &signingKeyRepository{db: db}
+}
+
+// ListValid returns the next and active keys and the retired keys that
+// have not expired, newest first.
+func (r *signingKeyRepository) ListValid(ctx context.Context) ([]models.SigningKey, error) {
+	query := `
+		SELECT ` + signingKeyColumns + `
+		FROM signing_keys
+		WHERE state <> $1 OR expires_at > $2
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, models.SigningKeyStateRetired, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.SigningKey, error) {
+		var key models.SigningKey
+		err := row.Scan(&key.ID, &key.Algorithm, &key.State, &key.PrivateKey, &key.CreatedAt,
+			&key.ActivatedAt, &key.RetiredAt, &key.ExpiresAt)
+		if err != nil {
+			return key, fmt.Errorf("failed to get signing key: %w", err)
+		}
+		return key, nil
+	})
+}
+
+// Rotate retires the active key and activates the next one, storing key as
+// the new next key. Without a next key, key is activated itself. Nothing
+// changes when the active key was activated at or after activatedBefore,
+// which lets instances that find rotation due at the same time rotate
+// once. Retired keys expire after retention, and expired keys are
+// deleted. It reports whether the keys were rotated.
+func (r *signingKeyRepository) Rotate(ctx context.Context, key *models.SigningKey, activatedBefore time.Time, retention time.Duration) (bool, error) {
+	rotated := false
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := lockSigningKeys(ctx, tx); err != nil {
+			return err
+		}
+
+		var current int
+		err := tx.QueryRow(ctx,
+			"SELECT COUNT(*) FROM signing_keys WHERE state = $1 AND activated_at >= $2",
+			models.SigningKeyStateActive, activatedBefore).Scan(&current)
+		if err != nil {
+			return fmt.Errorf("failed to check signing keys: %w", err)
+		}
+		if current > 0 {
+			return nil
+		}
+
+		now := time.Now()
+		_, err = tx.Exec(ctx,
+			"UPDATE signing_keys SET state = $1, retired_at = $2, expires_at = $3 WHERE state = $4",
+			models.SigningKeyStateRetired, now, now.Add(retention), models.SigningKeyStateActive)
+		if err != nil {
+			return fmt.Errorf("failed to retire signing key: %w", err)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM signing_keys WHERE expires_at < $1", now); err != nil {
+			return fmt.Errorf("failed to delete expired signing keys: %w", err)
+		}
+
+		var activated string
+		err = tx.QueryRow(ctx,
+			"UPDATE signing_keys SET state = $1, activated_at = $2 WHERE state = $3 RETURNING id",
+			models.SigningKeyStateActive, now, models.SigningKeyStateNext).Scan(&activated)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			activated = key.ID
+			key.State = models.SigningKeyStateActive
+			key.ActivatedAt = &now
+		case err != nil:
+			return fmt.Errorf("failed to activate signing key: %w", err)
+		default:
+			key.State = models.SigningKeyStateNext
+		}
+
+		key.CreatedAt = now
+		if err := insertSigningKey(ctx, tx, key); err != nil {
+			return err
+		}
+
+		rotated = true
+		return recordChange(ctx, tx, audit.ActionSigningKeyRotated, audit.TargetSigningKey, activated, uuid.Nil, nil, key)
+	})
+	if err != nil {
+		return false, err
+	}
+	return rotated, nil
+}
+
+// CreateNext stores key as the next key unless there is one already. It
+// reports whether key was stored.
+func (r *signingKeyRepository) CreateNext(ctx context.Context, key *models.SigningKey) (bool, error) {
+	created := false
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := lockSigningKeys(ctx, tx); err != nil {
+			return err
+		}
+
+		var next int
+		err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM signing_keys WHERE state = $1", models.SigningKeyStateNext).Scan(&next)
+		if err != nil {
+			return fmt.Errorf("failed to check signing keys: %w", err)
+		}
+		if next > 0 {
+			return nil
+		}
+
+		key.State = models.SigningKeyStateNext
+		key.CreatedAt = time.Now()
+		if err := insertSigningKey(ctx, tx, key); err != nil {
+			return err
+		}
+
+		created = true
+		return recordChange(ctx, tx, audit.ActionSigningKeyCreated, audit.TargetSigningKey, key.ID, uuid.Nil, nil, key)
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// lockSigningKeys serializes changes to the keys across instances.
+func lockSigningKeys(ctx context.Context, tx pgx.Tx) error {
+	if _, err := tx.Exec(ctx, "LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return fmt.Errorf("failed to lock signing keys: %w", err)
+	}
+	return nil
+}
+
+func insertSigningKey(ctx context.Context, tx pgx.Tx, key *models.SigningKey) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO signing_keys ("+signingKeyColumns+") VALUES ($1, $2, $3, $4, $5, $6, NULL, NULL)",
+		key.ID, key.Algorithm, key.State, key.PrivateKey, key.CreatedAt, key.ActivatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create signing key: %w", err)
+	}
+	return nil
+}
@@ -7,9 +7,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
 	"user-management/internal/models"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
 type userRepository struct {
 	db *pgxpool.Pool
 }
@@ -20,9 +24,10 @@ func NewUserRepository(db *pgxpool.Pool) UserRepository {
 
 func (r *userRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	query := `
-		SELECT *
-		FROM users 
-		WHERE id = $1 AND is_active = true
+		SELECT id, email, password, first_name, last_name, bio, phone_number,
+		       email_verified, is_active, last_login_at, created_at, updated_at
+		FROM users
+		WHERE id = $1 AND is_active = true AND principal_type = 'user'
 	`
 
 	var user models.User
@@ -43,7 +48,7 @@ func (r *userRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Use
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -56,7 +61,7 @@ func (r *userRepository) GetByEmail(ctx context.Context, email string) (*models.
 		SELECT id, email, password, first_name, last_name, bio, phone_number, 
 		       email_verified, is_active, last_login_at, created_at, updated_at
 		FROM users 
-		WHERE email = $1
+		WHERE email = $1 AND principal_type = 'user'
 	`
 
 	var user models.User
@@ -77,7 +82,7 @@ func (r *userRepository) GetByEmail(ctx context.Context, email string) (*models.
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -90,25 +95,28 @@ func (r *userRepository) Create(ctx context.Context, user *models.User) error {
 		INSERT INTO users (id, email, password, first_name, last_name, bio, phone_number, email_verified, is_active, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 	`
-	_, err := r.db.Exec(ctx, query,
-		user.ID,
-		user.Email,
-		user.Password,
-		user.FirstName,
-		user.LastName,
-		user.Bio,
-		user.PhoneNumber,
-		user.EmailVerified,
-		user.IsActive,
-		user.CreatedAt,
-		user.UpdatedAt,
-	)
 
-	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
-	}
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			user.ID,
+			user.Email,
+			user.Password,
+			user.FirstName,
+			user.LastName,
+			user.Bio,
+			user.PhoneNumber,
+			user.EmailVerified,
+			user.IsActive,
+			user.CreatedAt,
+			user.UpdatedAt,
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
 
-	return nil
+		return recordChange(ctx, tx, audit.ActionUserCreated, audit.TargetUser, user.ID.String(), uuid.Nil, nil, user)
+	})
 }
 
 func (r *userRepository) Update(ctx context.Context, user *models.User) error {
@@ -119,45 +127,154 @@ func (r *userRepository) Update(ctx context.Context, user *models.User) error {
 		WHERE id = $1
 	`
 
-	user.UpdatedAt = user.UpdatedAt
-
-	result, err := r.db.Exec(ctx, query,
-		user.ID,
-		user.FirstName,
-		user.LastName,
-		user.Bio,
-		user.PhoneNumber,
-		user.EmailVerified,
-		user.IsActive,
-		user.UpdatedAt,
-	)
+	user.UpdatedAt = time.Now()
 
-	if err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
-	}
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockUser(ctx, tx, user.ID)
+		if err != nil {
+			return err
+		}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
-	}
+		_, err = tx.Exec(ctx, query,
+			user.ID,
+			user.FirstName,
+			user.LastName,
+			user.Bio,
+			user.PhoneNumber,
+			user.EmailVerified,
+			user.IsActive,
+			user.UpdatedAt,
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
 
-	return nil
+		after := *before
+		after.FirstName = user.FirstName
+		after.LastName = user.LastName
+		after.Bio = user.Bio
+		after.PhoneNumber = user.PhoneNumber
+		after.EmailVerified = user.EmailVerified
+		after.IsActive = user.IsActive
+		after.UpdatedAt = user.UpdatedAt
+
+		return recordChange(ctx, tx, audit.ActionUserUpdated, audit.TargetUser, user.ID.String(), uuid.Nil, before, &after)
+	})
 }
 
 func (r *userRepository) Delete(ctx context.Context, id uuid.UUID) error {
-	query := `UPDATE users SET is_active = false, updated_at = NOW() WHERE id = $1`
+	query := `UPDATE users SET is_active = false, updated_at = $2 WHERE id = $1`
 
-	result, err := r.db.Exec(ctx, query, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
-	}
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		after := *before
+		after.IsActive = false
+		after.UpdatedAt = time.Now()
+
+		_, err = tx.Exec(ctx, query, id, after.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionUserDeleted, audit.TargetUser, id.String(), uuid.Nil, before, &after)
+	})
+}
+
+// SetPassword replaces the password hash of an active user.
+func (r *userRepository) SetPassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	query := `UPDATE users SET password = $2, updated_at = $3 WHERE id = $1`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !before.IsActive {
+			return ErrUserNotFound
+		}
+
+		after := *before
+		after.Password = passwordHash
+		after.UpdatedAt = time.Now()
+
+		if _, err := tx.Exec(ctx, query, id, passwordHash, after.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to set password: %w", err)
+		}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return recordChange(ctx, tx, audit.ActionUserPasswordChanged, audit.TargetUser, id.String(), uuid.Nil, before, &after)
+	})
+}
+
+// MarkEmailVerified sets email_verified when the user's address is still
+// email. It returns ErrUserNotFound when the user is inactive or changed
+// their address since.
+func (r *userRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID, email string) error {
+	query := `UPDATE users SET email_verified = true, updated_at = $2 WHERE id = $1`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !before.IsActive || before.Email != email {
+			return ErrUserNotFound
+		}
+		if before.EmailVerified {
+			return nil
+		}
+
+		after := *before
+		after.EmailVerified = true
+		after.UpdatedAt = time.Now()
+
+		if _, err := tx.Exec(ctx, query, id, after.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to verify email: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionUserEmailVerified, audit.TargetUser, id.String(), uuid.Nil, before, &after)
+	})
+}
+
+// lockUser loads a user row and locks it for the rest of the transaction so
+// the audit record reflects the state that was actually replaced.
+func lockUser(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.User, error) {
+	query := `
+		SELECT id, email, password, first_name, last_name, bio, phone_number,
+		       email_verified, is_active, last_login_at, created_at, updated_at
+		FROM users
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	var user models.User
+	err := tx.QueryRow(ctx, query, id).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.FirstName,
+		&user.LastName,
+		&user.Bio,
+		&user.PhoneNumber,
+		&user.EmailVerified,
+		&user.IsActive,
+		&user.LastLoginAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return nil
+	return &user, nil
 }
 
 func (r *userRepository) GetAll(ctx context.Context, limit, offset int) ([]*models.User, error) {
@@ -165,7 +282,7 @@ func (r *userRepository) GetAll(ctx context.Context, limit, offset int) ([]*mode
 		SELECT id, email, password, first_name, last_name, bio, phone_number, 
 		       email_verified, is_active, last_login_at, created_at, updated_at
 		FROM users 
-		WHERE is_active = true
+		WHERE is_active = true AND principal_type = 'user'
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2
 	`
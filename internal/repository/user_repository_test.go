@@ -2,13 +2,16 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"testing"
+	"time"
 	"user-management/internal/config"
 	"user-management/internal/database"
+	"user-management/internal/models"
 )
 
 type UserRepositoryTestSuite struct {
@@ -38,9 +41,7 @@ func (suite *UserRepositoryTestSuite) SetupSuite() {
 	suite.repo = repo
 
 	err = runSQLFiles(suite.ctx, suite.db, "../database/migrations")
-	if err != nil {
-		return
-	}
+	require.NoError(suite.T(), err)
 }
 
 func (suite *UserRepositoryTestSuite) TearDownSuite() {
@@ -53,7 +54,7 @@ func (suite *UserRepositoryTestSuite) TearDownSuite() {
 
 func (suite *UserRepositoryTestSuite) SetupTest() {
 	ctx := context.Background()
-	_, err := suite.db.Exec(ctx, "TRUNCATE TABLE users CASCADE")
+	_, err := suite.db.Exec(ctx, "TRUNCATE TABLE users, outbox_events CASCADE")
 	require.NoError(suite.T(), err)
 }
 
@@ -68,10 +69,21 @@ func (suite *UserRepositoryTestSuite) TestGetUserById() {
 	require.NoError(suite.T(), err)
 
 	user, err := suite.repo.GetByID(suite.ctx, id)
-	require.NotNil(suite.T(), user)
+	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), "fake@email", user.Email)
 }
 
+func (suite *UserRepositoryTestSuite) TestGetUserByIdSkipsServiceAccounts() {
+	id := uuid.New()
+	_, err := suite.db.Exec(suite.ctx,
+		"INSERT INTO users (id, email, password, first_name, last_name, principal_type) VALUES ($1, $2, '', 'Deploy', 'bot', 'service_account')",
+		id, id.String()+"@service-accounts.local")
+	require.NoError(suite.T(), err)
+
+	_, err = suite.repo.GetByID(suite.ctx, id)
+	require.ErrorIs(suite.T(), err, ErrUserNotFound)
+}
+
 func (suite *UserRepositoryTestSuite) TestGetAllUsers() {
 	err := suite.repo.Create(suite.ctx, newUser(uuid.New(), "fake1@email"))
 	require.NoError(suite.T(), err)
@@ -91,3 +103,78 @@ func (suite *UserRepositoryTestSuite) TestGetUserByEmail() {
 	user, err := suite.repo.GetByEmail(suite.ctx, "fake2@email")
 	require.Equal(suite.T(), "fake2@email", user.Email)
 }
+
+func (suite *UserRepositoryTestSuite) TestOutboxPreservesAggregateOrder() {
+	first, second := uuid.New(), uuid.New()
+	require.NoError(suite.T(), suite.repo.Create(suite.ctx, newUser(first, "first@email")))
+	require.NoError(suite.T(), suite.repo.Create(suite.ctx, newUser(second, "second@email")))
+	user, err := suite.repo.GetByID(suite.ctx, first)
+	require.NoError(suite.T(), err)
+	bio := "updated"
+	user.Bio = &bio
+	require.NoError(suite.T(), suite.repo.Update(suite.ctx, user))
+
+	outbox := NewOutboxRepository(suite.db)
+	noDelay := func(int) time.Duration { return 0 }
+
+	// The first user's creation fails, which must hold back its update while
+	// the second user's creation goes through.
+	var published []string
+	count, err := outbox.ProcessPending(suite.ctx, 10, noDelay, func(ctx context.Context, event models.OutboxEvent) error {
+		if event.Event.AggregateID == first.String() && event.Attempts == 0 {
+			return errors.New("unavailable")
+		}
+		published = append(published, event.Event.Type+" "+event.Event.AggregateID)
+		return nil
+	})
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 1, count)
+	require.Equal(suite.T(), []string{"user.created " + second.String()}, published)
+
+	published = nil
+	count, err = outbox.ProcessPending(suite.ctx, 10, noDelay, func(ctx context.Context, event models.OutboxEvent) error {
+		published = append(published, event.Event.Type+" "+event.Event.AggregateID)
+		return nil
+	})
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 2, count)
+	require.Equal(suite.T(), []string{"user.created " + first.String(), "user.updated " + first.String()}, published)
+}
+
+func (suite *UserRepositoryTestSuite) TestUserChangeFeedOnlyPublishesActivation() {
+	t := suite.T()
+	id := uuid.New()
+	require.NoError(t, suite.repo.Create(suite.ctx, newUser(id, "feed@email")))
+
+	conn, err := suite.db.Acquire(suite.ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+	_, err = conn.Exec(suite.ctx, "LISTEN user_management_changes")
+	require.NoError(t, err)
+	next := func() (string, error) {
+		ctx, cancel := context.WithTimeout(suite.ctx, time.Second)
+		defer cancel()
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return "", err
+		}
+		return notification.Payload, nil
+	}
+
+	_, err = suite.db.Exec(suite.ctx, "UPDATE users SET last_login_at = NOW() WHERE id = $1", id)
+	require.NoError(t, err)
+	_, err = next()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = suite.db.Exec(suite.ctx, "UPDATE users SET is_active = false WHERE id = $1", id)
+	require.NoError(t, err)
+	payload, err := next()
+	require.NoError(t, err)
+	require.Contains(t, payload, id.String())
+
+	// The user is already inactive, so deleting it changes no permissions.
+	_, err = suite.db.Exec(suite.ctx, "DELETE FROM users WHERE id = $1", id)
+	require.NoError(t, err)
+	_, err = next()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
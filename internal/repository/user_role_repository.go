@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+// suspendedMembership matches when the user's membership of the organization
+// of ur has been suspended. Roles held through a suspended membership grant
+// nothing; users without a membership row keep their roles.
+const suspendedMembership = `
+	SELECT 1 FROM user_organizations uo
+	WHERE uo.user_id = ur.user_id AND uo.organization_id = ur.organization_id AND uo.status <> 'active'
+`
+
+var ErrUserRoleNotFound = errors.New("user role not found")
+
+type userRoleRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewUserRoleRepository(db *pgxpool.Pool) UserRoleRepository {
+	return &userRoleRepository{db: db}
+}
+
+func (r *userRoleRepository) AssignRole(ctx context.Context, userRole *models.UserRole) error {
+	if userRole.UserID == uuid.Nil {
+		return fmt.Errorf("user ID is required")
+	}
+	if userRole.RoleID == uuid.Nil {
+		return fmt.Errorf("role ID is required")
+	}
+	if userRole.OrganizationID == uuid.Nil {
+		return fmt.Errorf("organization ID is required")
+	}
+
+	if userRole.AssignedAt.IsZero() {
+		userRole.AssignedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO user_roles (user_id, role_id, organization_id, assigned_at, assigned_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, role_id, organization_id) DO NOTHING
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, query,
+			userRole.UserID,
+			userRole.RoleID,
+			userRole.OrganizationID,
+			userRole.AssignedAt,
+			userRole.AssignedBy,
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to assign role: %w", err)
+		}
+
+		if result.RowsAffected() == 0 {
+			return nil
+		}
+
+		return recordChange(ctx, tx, audit.ActionRoleAssigned, audit.TargetUser, userRole.UserID.String(), userRole.OrganizationID,
+			nil, &assignedRole{RoleID: userRole.RoleID})
+	})
+}
+
+type assignedRole struct {
+	RoleID uuid.UUID `json:"role_id"`
+}
+
+func (r *userRoleRepository) RemoveRole(ctx context.Context, userID, roleID, organizationID uuid.UUID) error {
+	query := "DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2 AND organization_id = $3"
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, query, userID, roleID, organizationID)
+		if err != nil {
+			return fmt.Errorf("failed to remove role: %w", err)
+		}
+
+		if result.RowsAffected() == 0 {
+			return ErrUserRoleNotFound
+		}
+
+		return recordChange(ctx, tx, audit.ActionRoleUnassigned, audit.TargetUser, userID.String(), organizationID,
+			&assignedRole{RoleID: roleID}, nil)
+	})
+}
+
+func (r *userRoleRepository) GetUserRoles(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Role, error) {
+	query := `
+		SELECT r.id, r.name, r.description, r.organization_id, r.is_system_role,
+		       r.created_at, r.updated_at
+		FROM roles r
+		INNER JOIN user_roles ur ON r.id = ur.role_id
+		WHERE ur.user_id = $1 AND ur.organization_id = $2
+		ORDER BY r.name
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.OrganizationID,
+			&role.IsSystemRole,
+			&role.CreatedAt,
+			&role.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+func (r *userRoleRepository) GetRoleUsers(ctx context.Context, roleID uuid.UUID) ([]models.User, error) {
+	query := `
+		SELECT u.id, u.email, u.password, u.first_name, u.last_name, u.bio, u.phone_number,
+		       u.email_verified, u.is_active, u.last_login_at, u.created_at, u.updated_at
+		FROM users u
+		INNER JOIN user_roles ur ON u.id = ur.user_id
+		WHERE ur.role_id = $1 AND u.is_active = true
+		ORDER BY u.email
+	`
+
+	rows, err := r.db.Query(ctx, query, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.Password,
+			&user.FirstName,
+			&user.LastName,
+			&user.Bio,
+			&user.PhoneNumber,
+			&user.EmailVerified,
+			&user.IsActive,
+			&user.LastLoginAt,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate users: %w", err)
+	}
+
+	return users, nil
+}
+
+func (r *userRoleRepository) GetRoleHolderIDs(ctx context.Context, roleID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.db.Query(ctx, `SELECT DISTINCT user_id FROM user_roles WHERE role_id = $1`, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role holders: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan role holders: %w", err)
+	}
+	return ids, nil
+}
+
+func (r *userRoleRepository) GetUserPermissions(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, error) {
+	query := `
+		SELECT DISTINCT p.id, p.name, p.resource, p.action, p.description, p.created_at
+		FROM permissions p
+		INNER JOIN role_permissions rp ON p.id = rp.permission_id
+		INNER JOIN user_roles ur ON rp.role_id = ur.role_id
+		WHERE ur.user_id = $1 AND ur.organization_id = $2
+		  AND NOT EXISTS (` + suspendedMembership + `)
+		ORDER BY p.resource, p.action
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user permissions: %w", err)
+	}
+	defer rows.Close()
+
+	var permissions []models.Permission
+	for rows.Next() {
+		var permission models.Permission
+		err := rows.Scan(
+			&permission.ID,
+			&permission.Name,
+			&permission.Resource,
+			&permission.Action,
+			&permission.Description,
+			&permission.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate permissions: %w", err)
+	}
+
+	return permissions, nil
+}
+
+func (r *userRoleRepository) HasPermission(ctx context.Context, userID uuid.UUID, organizationID uuid.UUID, permission string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM permissions p
+			INNER JOIN role_permissions rp ON p.id = rp.permission_id
+			INNER JOIN user_roles ur ON rp.role_id = ur.role_id
+			WHERE ur.user_id = $1 AND ur.organization_id = $2 AND p.name = $3
+			  AND NOT EXISTS (` + suspendedMembership + `)
+		)
+	`
+
+	var exists bool
+	err := r.db.QueryRow(ctx, query, userID, organizationID, permission).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check permission: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (r *userRoleRepository) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]models.Organization, error) {
+	query := `
+		SELECT o.id, o.name, o.slug, o.description, o.is_active, o.created_at, o.updated_at
+		FROM organizations o
+		INNER JOIN user_organizations uo ON o.id = uo.organization_id
+		WHERE uo.user_id = $1 AND uo.status = 'active'
+		ORDER BY o.name
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var organizations []models.Organization
+	for rows.Next() {
+		var organization models.Organization
+		err := rows.Scan(
+			&organization.ID,
+			&organization.Name,
+			&organization.Slug,
+			&organization.Description,
+			&organization.IsActive,
+			&organization.CreatedAt,
+			&organization.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		organizations = append(organizations, organization)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate organizations: %w", err)
+	}
+
+	return organizations, nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/models"
+)
+
+// ErrUserTokenInvalid is returned for unknown, expired and already used
+// tokens alike.
+var ErrUserTokenInvalid = errors.New("invalid or expired token")
+
+type userTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewUserTokenRepository(db *pgxpool.Pool) UserTokenRepository {
+	return &userTokenRepository{db: db}
+}
+
+// Create stores a token and deletes the unused tokens the user had for the
+// same purpose, so that only the latest link mailed works.
+func (r *userTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	token.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO user_tokens (id, user_id, purpose, token_hash, email, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			"DELETE FROM user_tokens WHERE user_id = $1 AND (purpose = $2 AND used_at IS NULL OR expires_at < $3)",
+			token.UserID, token.Purpose, token.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to delete previous tokens: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, query,
+			token.ID,
+			token.UserID,
+			token.Purpose,
+			token.TokenHash,
+			token.Email,
+			token.ExpiresAt,
+			token.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create token: %w", err)
+		}
+		return nil
+	})
+}
+
+// Consume marks the unexpired, unused token with the given hash and purpose
+// as used and returns it. Concurrent calls with the same token succeed at
+// most once.
+func (r *userTokenRepository) Consume(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	query := `
+		UPDATE user_tokens
+		SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING id, user_id, purpose, token_hash, email, expires_at, used_at, created_at
+	`
+
+	var token models.UserToken
+	err := r.db.QueryRow(ctx, query, tokenHash, purpose, time.Now()).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.TokenHash,
+		&token.Email,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to use token: %w", err)
+	}
+
+	return &token, nil
+}
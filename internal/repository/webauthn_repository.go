@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var (
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	ErrPasskeyExists   = errors.New("passkey is already registered")
+	// ErrWebAuthnSessionNotFound is returned for unknown, expired and
+	// already finished ceremonies.
+	ErrWebAuthnSessionNotFound = errors.New("WebAuthn session not found or expired")
+)
+
+type webAuthnRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewWebAuthnRepository(db *pgxpool.Pool) WebAuthnRepository {
+	return &webAuthnRepository{db: db}
+}
+
+func (r *webAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	if credential.ID == uuid.Nil {
+		credential.ID = uuid.New()
+	}
+	if credential.Transports == nil {
+		credential.Transports = []string{}
+	}
+	credential.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO webauthn_credentials (
+			id, user_id, credential_id, public_key, attestation_type, attestation_format, transports,
+			aaguid, sign_count, clone_warning, backup_eligible, backup_state, name, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			credential.ID,
+			credential.UserID,
+			credential.CredentialID,
+			credential.PublicKey,
+			credential.AttestationType,
+			credential.AttestationFormat,
+			credential.Transports,
+			credential.AAGUID,
+			credential.SignCount,
+			credential.CloneWarning,
+			credential.BackupEligible,
+			credential.BackupState,
+			credential.Name,
+			credential.CreatedAt,
+		)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrPasskeyExists
+			}
+			return fmt.Errorf("failed to create passkey: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionPasskeyRegistered, audit.TargetPasskey, credential.ID.String(), uuid.Nil, nil, credential)
+	})
+}
+
+func (r *webAuthnRepository) ListCredentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	query := `
+		SELECT ` + webAuthnCredentialColumns + `
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, *credential)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate passkeys: %w", err)
+	}
+
+	return credentials, nil
+}
+
+// GetCredential looks a passkey up by the credential ID of the
+// authenticator.
+func (r *webAuthnRepository) GetCredential(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	query := `
+		SELECT ` + webAuthnCredentialColumns + `
+		FROM webauthn_credentials
+		WHERE credential_id = $1
+	`
+
+	return scanWebAuthnCredential(r.db.QueryRow(ctx, query, credentialID))
+}
+
+// UpdateCredentialUse stores the signature counter and flags reported by the
+// last assertion of a passkey.
+func (r *webAuthnRepository) UpdateCredentialUse(ctx context.Context, credential *models.WebAuthnCredential) error {
+	query := `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, clone_warning = $3, backup_state = $4, last_used_at = $5
+		WHERE id = $1
+	`
+
+	now := time.Now()
+	result, err := r.db.Exec(ctx, query,
+		credential.ID,
+		credential.SignCount,
+		credential.CloneWarning,
+		credential.BackupState,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrPasskeyNotFound
+	}
+
+	credential.LastUsedAt = &now
+	return nil
+}
+
+func (r *webAuthnRepository) DeleteCredential(ctx context.Context, userID, id uuid.UUID) error {
+	query := `
+		DELETE FROM webauthn_credentials
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + webAuthnCredentialColumns
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		credential, err := scanWebAuthnCredential(tx.QueryRow(ctx, query, id, userID))
+		if err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, audit.ActionPasskeyDeleted, audit.TargetPasskey, id.String(), uuid.Nil, credential, nil)
+	})
+}
+
+// SaveSession stores the state of a ceremony and removes expired ones.
+func (r *webAuthnRepository) SaveSession(ctx context.Context, session *models.WebAuthnSession) error {
+	if session.ID == uuid.Nil {
+		session.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO webauthn_sessions (id, user_id, ceremony, data, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		now := time.Now()
+		if _, err := tx.Exec(ctx, "DELETE FROM webauthn_sessions WHERE expires_at < $1", now); err != nil {
+			return fmt.Errorf("failed to delete expired WebAuthn sessions: %w", err)
+		}
+
+		_, err := tx.Exec(ctx, query, session.ID, session.UserID, session.Ceremony, session.Data, session.ExpiresAt, now)
+		if err != nil {
+			return fmt.Errorf("failed to save WebAuthn session: %w", err)
+		}
+		return nil
+	})
+}
+
+// ConsumeSession deletes and returns an unexpired session of the given
+// ceremony, so that each session can be finished only once.
+func (r *webAuthnRepository) ConsumeSession(ctx context.Context, id uuid.UUID, ceremony string) (*models.WebAuthnSession, error) {
+	query := `
+		DELETE FROM webauthn_sessions
+		WHERE id = $1 AND ceremony = $2 AND expires_at > $3
+		RETURNING id, user_id, ceremony, data, expires_at
+	`
+
+	var session models.WebAuthnSession
+	err := r.db.QueryRow(ctx, query, id, ceremony, time.Now()).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Ceremony,
+		&session.Data,
+		&session.ExpiresAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebAuthnSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get WebAuthn session: %w", err)
+	}
+
+	return &session, nil
+}
+
+const webAuthnCredentialColumns = `id, user_id, credential_id, public_key, attestation_type, attestation_format, transports,
+			aaguid, sign_count, clone_warning, backup_eligible, backup_state, name, last_used_at, created_at`
+
+func scanWebAuthnCredential(row pgx.Row) (*models.WebAuthnCredential, error) {
+	credential := &models.WebAuthnCredential{}
+	err := row.Scan(
+		&credential.ID,
+		&credential.UserID,
+		&credential.CredentialID,
+		&credential.PublicKey,
+		&credential.AttestationType,
+		&credential.AttestationFormat,
+		&credential.Transports,
+		&credential.AAGUID,
+		&credential.SignCount,
+		&credential.CloneWarning,
+		&credential.BackupEligible,
+		&credential.BackupState,
+		&credential.Name,
+		&credential.LastUsedAt,
+		&credential.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPasskeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+
+	return credential, nil
+}
@@ -0,0 +1,452 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type WebhookDeliveryFilter struct {
+	SubscriptionID uuid.UUID
+	Status         string
+	// BeforeCreatedAt and BeforeID page backwards through the history, which
+	// is returned newest first.
+	BeforeCreatedAt *time.Time
+	BeforeID        uuid.UUID
+	Limit           int
+}
+
+const webhookColumns = `
+	id, organization_id, url, description, event_types, secret, is_active,
+	consecutive_failures, disabled_at, disabled_reason, created_at, updated_at
+`
+
+const webhookDeliveryColumns = `
+	id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	last_attempt_at, response_status, error, duration_ms, created_at
+`
+
+type webhookRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewWebhookRepository(db *pgxpool.Pool) WebhookRepository {
+	return &webhookRepository{db: db}
+}
+
+func (r *webhookRepository) Create(ctx context.Context, subscription *models.WebhookSubscription) error {
+	if subscription.ID == uuid.Nil {
+		subscription.ID = uuid.New()
+	}
+	if subscription.OrganizationID == uuid.Nil {
+		return fmt.Errorf("organization ID is required")
+	}
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+
+	now := time.Now()
+	subscription.CreatedAt = now
+	subscription.UpdatedAt = now
+
+	query := `
+		INSERT INTO webhook_subscriptions (id, organization_id, url, description, event_types, secret, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			subscription.ID,
+			subscription.OrganizationID,
+			subscription.URL,
+			subscription.Description,
+			subscription.EventTypes,
+			subscription.Secret,
+			subscription.IsActive,
+			subscription.CreatedAt,
+			subscription.UpdatedAt,
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to create webhook: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionWebhookCreated, audit.TargetWebhook, subscription.ID.String(), subscription.OrganizationID, nil, subscription)
+	})
+}
+
+func (r *webhookRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	query := "SELECT " + webhookColumns + " FROM webhook_subscriptions WHERE id = $1"
+	return scanWebhook(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *webhookRepository) List(ctx context.Context, organizationID uuid.UUID) ([]models.WebhookSubscription, error) {
+	query := "SELECT " + webhookColumns + " FROM webhook_subscriptions WHERE organization_id = $1 ORDER BY created_at"
+	return r.list(ctx, query, organizationID)
+}
+
+func (r *webhookRepository) ListActive(ctx context.Context, organizationID uuid.UUID) ([]models.WebhookSubscription, error) {
+	query := "SELECT " + webhookColumns + " FROM webhook_subscriptions WHERE organization_id = $1 AND is_active = true"
+	return r.list(ctx, query, organizationID)
+}
+
+func (r *webhookRepository) list(ctx context.Context, query string, organizationID uuid.UUID) ([]models.WebhookSubscription, error) {
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []models.WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate webhooks: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+// Update saves the URL, description, filters, secret and active flag.
+// Re-activating a subscription clears its failure count and disable reason.
+func (r *webhookRepository) Update(ctx context.Context, subscription *models.WebhookSubscription) error {
+	subscription.UpdatedAt = time.Now()
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockWebhook(ctx, tx, subscription.ID)
+		if err != nil {
+			return err
+		}
+
+		if subscription.IsActive && !before.IsActive {
+			subscription.ConsecutiveFailures = 0
+			subscription.DisabledAt = nil
+			subscription.DisabledReason = ""
+		}
+
+		query := `
+			UPDATE webhook_subscriptions
+			SET url = $2, description = $3, event_types = $4, secret = $5, is_active = $6,
+			    consecutive_failures = $7, disabled_at = $8, disabled_reason = $9, updated_at = $10
+			WHERE id = $1
+		`
+
+		_, err = tx.Exec(ctx, query,
+			subscription.ID,
+			subscription.URL,
+			subscription.Description,
+			subscription.EventTypes,
+			subscription.Secret,
+			subscription.IsActive,
+			subscription.ConsecutiveFailures,
+			subscription.DisabledAt,
+			subscription.DisabledReason,
+			subscription.UpdatedAt,
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to update webhook: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionWebhookUpdated, audit.TargetWebhook, subscription.ID.String(), before.OrganizationID, before, subscription)
+	})
+}
+
+func (r *webhookRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := lockWebhook(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id); err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+
+		return recordChange(ctx, tx, audit.ActionWebhookDeleted, audit.TargetWebhook, id.String(), before.OrganizationID, before, nil)
+	})
+}
+
+// EnqueueDeliveries stores pending deliveries. A delivery of an event that
+// is already queued for the same subscription is ignored, so republishing
+// an event does not notify an endpoint twice.
+func (r *webhookRepository) EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+	`
+
+	batch := &pgx.Batch{}
+	now := time.Now()
+	for _, delivery := range deliveries {
+		batch.Queue(query, delivery.SubscriptionID, delivery.EventID, delivery.EventType, delivery.Payload, now)
+	}
+
+	if err := r.db.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+
+	return nil
+}
+
+// CreateDelivery stores a delivery that has already been attempted, such as
+// a test event.
+func (r *webhookRepository) CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if delivery.ID == uuid.Nil {
+		delivery.ID = uuid.New()
+	}
+	if delivery.CreatedAt.IsZero() {
+		delivery.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (id, subscription_id, event_id, event_type, payload, status, attempts,
+		                                next_attempt_at, last_attempt_at, response_status, error,
+		                                duration_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`
+
+	_, err := r.db.Exec(ctx, query,
+		delivery.ID,
+		delivery.SubscriptionID,
+		delivery.EventID,
+		delivery.EventType,
+		delivery.Payload,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastAttemptAt,
+		delivery.ResponseStatus,
+		delivery.Error,
+		delivery.DurationMs,
+		delivery.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimDueDeliveries returns up to limit pending deliveries of active
+// subscriptions that are due, and pushes their next attempt back by lease
+// so that other workers skip them while they are being sent.
+func (r *webhookRepository) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET next_attempt_at = $3
+		WHERE id IN (
+			SELECT d.id
+			FROM webhook_deliveries d
+			INNER JOIN webhook_subscriptions s ON s.id = d.subscription_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= $2 AND s.is_active = true
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING ` + webhookDeliveryColumns
+
+	now := time.Now()
+	rows, err := r.db.Query(ctx, query, limit, now, now.Add(lease))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	return collectWebhookDeliveries(rows)
+}
+
+// CompleteDelivery records the outcome of an attempt. Failed attempts count
+// against the subscription, which is disabled once disableAfter attempts in
+// a row have failed; a successful attempt resets the count. Failures of
+// subscriptions that are already disabled do not count. It reports whether
+// the subscription was disabled.
+func (r *webhookRepository) CompleteDelivery(ctx context.Context, delivery *models.WebhookDelivery, disableAfter int) (bool, error) {
+	disabled := false
+
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		query := `
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = $5,
+			    response_status = $6, error = $7, duration_ms = $8
+			WHERE id = $1
+		`
+
+		_, err := tx.Exec(ctx, query,
+			delivery.ID,
+			delivery.Status,
+			delivery.Attempts,
+			delivery.NextAttemptAt,
+			delivery.LastAttemptAt,
+			delivery.ResponseStatus,
+			delivery.Error,
+			delivery.DurationMs,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery: %w", err)
+		}
+
+		if delivery.Status == models.WebhookDeliverySucceeded {
+			_, err := tx.Exec(ctx, "UPDATE webhook_subscriptions SET consecutive_failures = 0 WHERE id = $1", delivery.SubscriptionID)
+			if err != nil {
+				return fmt.Errorf("failed to reset webhook failures: %w", err)
+			}
+			return nil
+		}
+
+		before, err := lockWebhook(ctx, tx, delivery.SubscriptionID)
+		if err != nil {
+			return err
+		}
+		if !before.IsActive {
+			return nil
+		}
+
+		after := *before
+		after.ConsecutiveFailures++
+		if disableAfter > 0 && after.ConsecutiveFailures >= disableAfter {
+			now := time.Now()
+			after.IsActive = false
+			after.DisabledAt = &now
+			after.DisabledReason = fmt.Sprintf("%d consecutive failed deliveries", after.ConsecutiveFailures)
+			after.UpdatedAt = now
+			disabled = true
+		}
+
+		query = `
+			UPDATE webhook_subscriptions
+			SET consecutive_failures = $2, is_active = $3, disabled_at = $4, disabled_reason = $5, updated_at = $6
+			WHERE id = $1
+		`
+
+		_, err = tx.Exec(ctx, query,
+			after.ID,
+			after.ConsecutiveFailures,
+			after.IsActive,
+			after.DisabledAt,
+			after.DisabledReason,
+			after.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record webhook failure: %w", err)
+		}
+
+		if !disabled {
+			return nil
+		}
+		return recordChange(ctx, tx, audit.ActionWebhookDisabled, audit.TargetWebhook, after.ID.String(), after.OrganizationID, before, &after)
+	})
+
+	if err != nil {
+		return false, err
+	}
+	return disabled, nil
+}
+
+func (r *webhookRepository) ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	args := []interface{}{filter.SubscriptionID}
+	query := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE subscription_id = $1"
+
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if filter.BeforeCreatedAt != nil {
+		args = append(args, *filter.BeforeCreatedAt, filter.BeforeID)
+		query += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	return collectWebhookDeliveries(rows)
+}
+
+func lockWebhook(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.WebhookSubscription, error) {
+	query := "SELECT " + webhookColumns + " FROM webhook_subscriptions WHERE id = $1 FOR UPDATE"
+	return scanWebhook(tx.QueryRow(ctx, query, id))
+}
+
+func scanWebhook(row pgx.Row) (*models.WebhookSubscription, error) {
+	subscription := &models.WebhookSubscription{}
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.OrganizationID,
+		&subscription.URL,
+		&subscription.Description,
+		&subscription.EventTypes,
+		&subscription.Secret,
+		&subscription.IsActive,
+		&subscription.ConsecutiveFailures,
+		&subscription.DisabledAt,
+		&subscription.DisabledReason,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	return subscription, nil
+}
+
+func collectWebhookDeliveries(rows pgx.Rows) ([]models.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.SubscriptionID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.LastAttemptAt,
+			&delivery.ResponseStatus,
+			&delivery.Error,
+			&delivery.DurationMs,
+			&delivery.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
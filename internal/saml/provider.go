@@ -0,0 +1,211 @@
+// Package saml is the SAML 2.0 service provider of organizations' single
+// sign-on: SP metadata, authentication requests with the HTTP-Redirect
+// binding and the validation of responses posted to the assertion consumer
+// service.
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	gosaml "github.com/crewjam/saml"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Paths of an organization's SP endpoints, relative to the base URL of the
+// service.
+const (
+	MetadataPath = "/saml/:organization_id/metadata"
+	ACSPath      = "/saml/:organization_id/acs"
+)
+
+var (
+	ErrInvalidMetadata = errors.New("invalid IdP metadata")
+	// ErrInvalidResponse is returned for responses that do not verify: a
+	// bad signature, another audience or recipient, an expired assertion or
+	// an unexpected InResponseTo.
+	ErrInvalidResponse = errors.New("invalid SAML response")
+)
+
+// EndpointURL returns the URL of the endpoint at path for the SP of an
+// organization.
+func EndpointURL(baseURL, path, organizationID string) string {
+	return strings.TrimSuffix(baseURL, "/") + strings.Replace(path, ":organization_id", url.PathEscape(organizationID), 1)
+}
+
+// Assertion is what an IdP asserts about a user.
+type Assertion struct {
+	ID     string
+	NameID string
+	// Attributes holds the values of each attribute under both its name and
+	// its friendly name.
+	Attributes map[string][]string
+	// ExpiresAt is when the assertion can no longer be presented, allowing
+	// for clock skew. Until then, it must not be accepted twice.
+	ExpiresAt time.Time
+}
+
+// Attribute returns the first value of the attribute name, or "".
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ServiceProvider is the SP of one organization's connection to its IdP.
+type ServiceProvider struct {
+	sp gosaml.ServiceProvider
+}
+
+// NewServiceProvider returns the SP with entityID and the assertion
+// consumer service acsURL, which trusts the IdP of idpMetadata.
+func NewServiceProvider(entityID, acsURL string, idpMetadata []byte) (*ServiceProvider, error) {
+	descriptor, err := ParseMetadata(idpMetadata)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := newServiceProvider(entityID, acsURL)
+	if err != nil {
+		return nil, err
+	}
+	sp.IDPMetadata = descriptor
+	return &ServiceProvider{sp: *sp}, nil
+}
+
+func newServiceProvider(entityID, acsURL string) (*gosaml.ServiceProvider, error) {
+	acs, err := url.Parse(acsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid assertion consumer service URL: %w", err)
+	}
+	metadataURL, err := url.Parse(entityID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entity ID: %w", err)
+	}
+	return &gosaml.ServiceProvider{
+		EntityID:          entityID,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acs,
+		AuthnNameIDFormat: gosaml.UnspecifiedNameIDFormat,
+	}, nil
+}
+
+// ParseMetadata parses the metadata of an IdP. It must have an SSO service
+// with the HTTP-Redirect binding and a signing certificate.
+func ParseMetadata(metadata []byte) (*gosaml.EntityDescriptor, error) {
+	var descriptor gosaml.EntityDescriptor
+	if err := xml.Unmarshal(metadata, &descriptor); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if descriptor.EntityID == "" {
+		return nil, fmt.Errorf("%w: no entity ID", ErrInvalidMetadata)
+	}
+
+	sp := &gosaml.ServiceProvider{IDPMetadata: &descriptor}
+	if sp.GetSSOBindingLocation(gosaml.HTTPRedirectBinding) == "" {
+		return nil, fmt.Errorf("%w: no SSO service with the HTTP-Redirect binding", ErrInvalidMetadata)
+	}
+	if !hasSigningCertificate(&descriptor) {
+		return nil, fmt.Errorf("%w: no signing certificate", ErrInvalidMetadata)
+	}
+	return &descriptor, nil
+}
+
+func hasSigningCertificate(descriptor *gosaml.EntityDescriptor) bool {
+	for _, idp := range descriptor.IDPSSODescriptors {
+		for _, key := range idp.KeyDescriptors {
+			if (key.Use == "" || key.Use == "signing") && len(key.KeyInfo.X509Data.X509Certificates) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Metadata returns the SP metadata of entityID to register with the IdP:
+// responses are posted to acsURL and assertions must be signed.
+func Metadata(entityID, acsURL string) ([]byte, error) {
+	sp, err := newServiceProvider(entityID, acsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor := sp.Metadata()
+	// Responses are only accepted with the HTTP-POST binding, not resolved
+	// from artifacts.
+	for i := range descriptor.SPSSODescriptors {
+		services := descriptor.SPSSODescriptors[i].AssertionConsumerServices
+		descriptor.SPSSODescriptors[i].AssertionConsumerServices = services[:1]
+	}
+
+	metadata, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SP metadata: %w", err)
+	}
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+// AuthenticationRequest returns the ID of a new authentication request and
+// the URL of the IdP to send the user to with it. relayState comes back
+// with the response.
+func (p *ServiceProvider) AuthenticationRequest(relayState string) (string, string, error) {
+	req, err := p.sp.MakeAuthenticationRequest(p.sp.GetSSOBindingLocation(gosaml.HTTPRedirectBinding), gosaml.HTTPRedirectBinding, gosaml.HTTPPostBinding)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create authentication request: %w", err)
+	}
+	redirect, err := req.Redirect(url.QueryEscape(relayState), &p.sp)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create authentication request: %w", err)
+	}
+	return req.ID, redirect.String(), nil
+}
+
+// ParseResponse verifies the base64 encoded response that the IdP posted
+// and returns its assertion. requestID is the ID of the authentication
+// request the response answers, or "" for responses the IdP initiated.
+func (p *ServiceProvider) ParseResponse(samlResponse, requestID string) (*Assertion, error) {
+	response, err := base64.StdEncoding.DecodeString(strings.TrimSpace(samlResponse))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	sp := p.sp
+	sp.AllowIDPInitiated = requestID == ""
+	var requestIDs []string
+	if requestID != "" {
+		requestIDs = []string{requestID}
+	}
+
+	assertion, err := sp.ParseXMLResponse(response, requestIDs)
+	if err != nil {
+		var invalid *gosaml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidResponse)
+	}
+
+	result := &Assertion{
+		ID:         assertion.ID,
+		NameID:     assertion.Subject.NameID.Value,
+		Attributes: map[string][]string{},
+		ExpiresAt:  assertion.IssueInstant.Add(gosaml.MaxIssueDelay + gosaml.MaxClockSkew),
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			for _, value := range attribute.Values {
+				result.Attributes[attribute.Name] = append(result.Attributes[attribute.Name], value.Value)
+				if attribute.FriendlyName != "" && attribute.FriendlyName != attribute.Name {
+					result.Attributes[attribute.FriendlyName] = append(result.Attributes[attribute.FriendlyName], value.Value)
+				}
+			}
+		}
+	}
+	return result, nil
+}
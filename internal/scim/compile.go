@@ -0,0 +1,178 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type AttributeType int
+
+const (
+	TypeString AttributeType = iota
+	TypeBoolean
+	TypeDateTime
+	// TypeReference attributes are multi-valued: Expr is a subquery that
+	// returns the text values, and only "eq" and "pr" are supported.
+	TypeReference
+)
+
+// Attribute maps a filterable SCIM attribute onto a SQL expression.
+type Attribute struct {
+	Expr      string
+	Type      AttributeType
+	CaseExact bool
+}
+
+// Compile translates expr into a SQL condition over attributes, keyed by
+// normalized path. Arguments are numbered from $firstArg. Attributes that
+// are not in the map are rejected, so only trusted expressions reach SQL.
+func Compile(expr Expr, attributes map[string]Attribute, firstArg int) (string, []interface{}, error) {
+	c := &compiler{attributes: attributes, next: firstArg}
+	clause, err := c.compile(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	return clause, c.args, nil
+}
+
+type compiler struct {
+	attributes map[string]Attribute
+	args       []interface{}
+	next       int
+}
+
+func (c *compiler) arg(value interface{}) string {
+	c.args = append(c.args, value)
+	placeholder := fmt.Sprintf("$%d", c.next)
+	c.next++
+	return placeholder
+}
+
+func (c *compiler) compile(expr Expr) (string, error) {
+	switch e := expr.(type) {
+	case *Logical:
+		left, err := c.compile(e.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := c.compile(e.Right)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + strings.ToUpper(e.Operator) + " " + right + ")", nil
+	case *Not:
+		inner, err := c.compile(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	case *Comparison:
+		return c.comparison(e)
+	default:
+		return "", fmt.Errorf("%w: unsupported expression", ErrInvalidFilter)
+	}
+}
+
+func (c *compiler) comparison(e *Comparison) (string, error) {
+	attribute, ok := c.attributes[e.Path]
+	if !ok {
+		return "", fmt.Errorf("%w: attribute %q is not filterable", ErrInvalidFilter, e.Path)
+	}
+	unsupported := func() (string, error) {
+		return "", fmt.Errorf("%w: operator %s is not supported for %s", ErrInvalidFilter, e.Operator, e.Path)
+	}
+
+	if e.Operator == OpPresent || (e.Operator == OpEqual && e.Value == nil) {
+		switch attribute.Type {
+		case TypeReference:
+			return "EXISTS (" + attribute.Expr + ")", nil
+		case TypeString:
+			present := "(" + attribute.Expr + " IS NOT NULL AND " + attribute.Expr + " <> '')"
+			if e.Operator == OpEqual {
+				return "NOT " + present, nil
+			}
+			return present, nil
+		default:
+			if e.Operator == OpEqual {
+				return attribute.Expr + " IS NULL", nil
+			}
+			return attribute.Expr + " IS NOT NULL", nil
+		}
+	}
+
+	switch attribute.Type {
+	case TypeBoolean:
+		value, ok := e.Value.(bool)
+		if !ok {
+			return "", fmt.Errorf("%w: %s requires a boolean", ErrInvalidFilter, e.Path)
+		}
+		switch e.Operator {
+		case OpEqual:
+			return attribute.Expr + " = " + c.arg(value), nil
+		case OpNotEqual:
+			return attribute.Expr + " <> " + c.arg(value), nil
+		}
+		return unsupported()
+
+	case TypeDateTime:
+		text, ok := e.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("%w: %s requires a dateTime string", ErrInvalidFilter, e.Path)
+		}
+		value, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid dateTime %q", ErrInvalidFilter, text)
+		}
+		operator, ok := sqlOperators[e.Operator]
+		if !ok {
+			return unsupported()
+		}
+		return attribute.Expr + " " + operator + " " + c.arg(value.UTC()), nil
+
+	case TypeReference:
+		text, ok := e.Value.(string)
+		if !ok || e.Operator != OpEqual {
+			return unsupported()
+		}
+		return c.arg(strings.ToLower(text)) + " IN (" + attribute.Expr + ")", nil
+	}
+
+	text, ok := e.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s requires a string", ErrInvalidFilter, e.Path)
+	}
+	column := attribute.Expr
+	if !attribute.CaseExact {
+		column = "LOWER(" + column + ")"
+		text = strings.ToLower(text)
+	}
+
+	switch e.Operator {
+	case OpContains:
+		return column + " LIKE " + c.arg("%"+escapeLike(text)+"%"), nil
+	case OpStartsWith:
+		return column + " LIKE " + c.arg(escapeLike(text)+"%"), nil
+	case OpEndsWith:
+		return column + " LIKE " + c.arg("%"+escapeLike(text)), nil
+	}
+
+	operator, ok := sqlOperators[e.Operator]
+	if !ok {
+		return unsupported()
+	}
+	return column + " " + operator + " " + c.arg(text), nil
+}
+
+var sqlOperators = map[string]string{
+	OpEqual:          "=",
+	OpNotEqual:       "<>",
+	OpGreater:        ">",
+	OpGreaterOrEqual: ">=",
+	OpLess:           "<",
+	OpLessOrEqual:    "<=",
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
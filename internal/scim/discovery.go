@@ -0,0 +1,175 @@
+package scim
+
+// MaxResults is the largest page returned by list endpoints.
+const MaxResults = 200
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupport            `json:"bulk"`
+	Filter                filterSupport          `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+	Meta                  Meta                   `json:"meta"`
+}
+
+func NewServiceProviderConfig(baseURL string) ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas:        []string{ServiceProviderConfigSchema},
+		Patch:          supported{Supported: true},
+		Filter:         filterSupport{Supported: true, MaxResults: MaxResults},
+		ChangePassword: supported{Supported: false},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "Authentication with a SCIM token issued to the organization",
+			Primary:     true,
+		}},
+		Meta: Meta{ResourceType: "ServiceProviderConfig", Location: baseURL + "/ServiceProviderConfig"},
+	}
+}
+
+type ResourceType struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Schema   string   `json:"schema"`
+	Meta     Meta     `json:"meta"`
+}
+
+func NewResourceTypes(baseURL string) []ResourceType {
+	return []ResourceType{
+		{
+			Schemas:  []string{ResourceTypeSchema},
+			ID:       ResourceTypeUser,
+			Name:     ResourceTypeUser,
+			Endpoint: "/Users",
+			Schema:   UserSchema,
+			Meta:     Meta{ResourceType: "ResourceType", Location: baseURL + "/ResourceTypes/" + ResourceTypeUser},
+		},
+		{
+			Schemas:  []string{ResourceTypeSchema},
+			ID:       ResourceTypeGroup,
+			Name:     ResourceTypeGroup,
+			Endpoint: "/Groups",
+			Schema:   GroupSchema,
+			Meta:     Meta{ResourceType: "ResourceType", Location: baseURL + "/ResourceTypes/" + ResourceTypeGroup},
+		},
+	}
+}
+
+type SchemaAttribute struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	MultiValued   bool              `json:"multiValued"`
+	Required      bool              `json:"required"`
+	CaseExact     bool              `json:"caseExact"`
+	Mutability    string            `json:"mutability"`
+	Returned      string            `json:"returned"`
+	Uniqueness    string            `json:"uniqueness"`
+	SubAttributes []SchemaAttribute `json:"subAttributes,omitempty"`
+}
+
+type Schema struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Attributes  []SchemaAttribute `json:"attributes"`
+	Meta        Meta              `json:"meta"`
+}
+
+func attribute(name, kind string, options ...func(*SchemaAttribute)) SchemaAttribute {
+	a := SchemaAttribute{
+		Name:       name,
+		Type:       kind,
+		Mutability: "readWrite",
+		Returned:   "default",
+		Uniqueness: "none",
+	}
+	for _, option := range options {
+		option(&a)
+	}
+	return a
+}
+
+func required(a *SchemaAttribute)    { a.Required = true }
+func multiValued(a *SchemaAttribute) { a.MultiValued = true }
+func unique(a *SchemaAttribute)      { a.Uniqueness = "server" }
+func writeOnly(a *SchemaAttribute)   { a.Mutability = "writeOnly"; a.Returned = "never" }
+
+func subAttributes(attributes ...SchemaAttribute) func(*SchemaAttribute) {
+	return func(a *SchemaAttribute) { a.SubAttributes = attributes }
+}
+
+func NewSchemas(baseURL string) []Schema {
+	multiValuedString := subAttributes(
+		attribute("value", "string"),
+		attribute("type", "string"),
+		attribute("primary", "boolean"),
+	)
+
+	return []Schema{
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          UserSchema,
+			Name:        ResourceTypeUser,
+			Description: "User Account",
+			Attributes: []SchemaAttribute{
+				attribute("userName", "string", required, unique),
+				attribute("name", "complex", subAttributes(
+					attribute("formatted", "string"),
+					attribute("givenName", "string"),
+					attribute("familyName", "string"),
+				)),
+				attribute("displayName", "string"),
+				attribute("emails", "complex", multiValued, multiValuedString),
+				attribute("phoneNumbers", "complex", multiValued, multiValuedString),
+				attribute("active", "boolean"),
+				attribute("password", "string", writeOnly),
+			},
+			Meta: Meta{ResourceType: "Schema", Location: baseURL + "/Schemas/" + UserSchema},
+		},
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          GroupSchema,
+			Name:        ResourceTypeGroup,
+			Description: "Group",
+			Attributes: []SchemaAttribute{
+				attribute("displayName", "string", required, unique),
+				attribute("members", "complex", multiValued, subAttributes(
+					attribute("value", "string"),
+					attribute("display", "string"),
+					attribute("$ref", "reference"),
+					attribute("type", "string"),
+				)),
+			},
+			Meta: Meta{ResourceType: "Schema", Location: baseURL + "/Schemas/" + GroupSchema},
+		},
+	}
+}
@@ -0,0 +1,323 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidFilter is wrapped by every filter parsing and compilation error.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Comparison operators of RFC 7644 section 3.4.2.2.
+const (
+	OpEqual          = "eq"
+	OpNotEqual       = "ne"
+	OpContains       = "co"
+	OpStartsWith     = "sw"
+	OpEndsWith       = "ew"
+	OpPresent        = "pr"
+	OpGreater        = "gt"
+	OpGreaterOrEqual = "ge"
+	OpLess           = "lt"
+	OpLessOrEqual    = "le"
+)
+
+// Expr is a parsed filter expression: a *Comparison, *Logical or *Not.
+type Expr interface {
+	expr()
+}
+
+// Comparison compares the attribute at Path with Value. Path is lower case
+// with any schema URN removed, and sub-attributes joined with dots. Value is
+// a string, bool, float64 or nil, and is unused for "pr".
+type Comparison struct {
+	Path     string
+	Operator string
+	Value    interface{}
+}
+
+// Logical joins two expressions with "and" or "or".
+type Logical struct {
+	Operator string
+	Left     Expr
+	Right    Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+func (*Comparison) expr() {}
+func (*Logical) expr()    {}
+func (*Not) expr()        {}
+
+// ParseFilter parses a SCIM filter such as
+// `userName sw "j" and (emails[type eq "work"] pr or active eq true)`.
+// Value path filters are flattened, so emails[type eq "work"] becomes the
+// comparison emails.type eq "work".
+func ParseFilter(filter string) (Expr, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	return expr, nil
+}
+
+// NormalizePath lower-cases an attribute path and strips the core User or
+// Group schema URN from it.
+func NormalizePath(path string) string {
+	lower := strings.ToLower(path)
+	for _, schema := range []string{UserSchema, GroupSchema, EnterpriseUserSchema} {
+		prefix := strings.ToLower(schema) + ":"
+		if strings.HasPrefix(lower, prefix) {
+			return lower[len(prefix):]
+		}
+	}
+	return lower
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	runes := []rune(filter)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{kind: tokenOpenBracket, text: "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{kind: tokenCloseBracket, text: "]"})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			var value string
+			if err := json.Unmarshal([]byte(string(runes[i:end+1])), &value); err != nil {
+				return nil, fmt.Errorf("%w: invalid string %s", ErrInvalidFilter, string(runes[i:end+1]))
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`()[]"`, runes[end]) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[i:end])})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() token {
+	if p.done() {
+		return token{kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, args...))
+}
+
+// Within a value path filter, prefix is the parent attribute that the
+// nested attribute names are relative to.
+func (p *filterParser) parseOr(prefix string) (Expr, error) {
+	left, err := p.parseAnd(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd(prefix)
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Operator: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd(prefix string) (Expr, error) {
+	left, err := p.parseUnary(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary(prefix)
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Operator: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary(prefix string) (Expr, error) {
+	if p.keyword("not") {
+		if p.next().kind != tokenOpen {
+			return nil, p.errorf("expected ( after not")
+		}
+		expr, err := p.parseGroup(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: expr}, nil
+	}
+
+	if p.peek().kind == tokenOpen {
+		p.pos++
+		return p.parseGroup(prefix)
+	}
+
+	return p.parseAttribute(prefix)
+}
+
+func (p *filterParser) parseGroup(prefix string) (Expr, error) {
+	expr, err := p.parseOr(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if p.next().kind != tokenClose {
+		return nil, p.errorf("expected )")
+	}
+	return expr, nil
+}
+
+func (p *filterParser) parseAttribute(prefix string) (Expr, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, p.errorf("expected attribute path")
+	}
+	path := NormalizePath(t.text)
+	if prefix != "" {
+		path = prefix + "." + path
+	}
+
+	if p.peek().kind == tokenOpenBracket {
+		if prefix != "" {
+			return nil, p.errorf("nested value filters are not supported")
+		}
+		p.pos++
+		expr, err := p.parseOr(path)
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenCloseBracket {
+			return nil, p.errorf("expected ]")
+		}
+		return expr, nil
+	}
+
+	op := p.next()
+	if op.kind != tokenWord {
+		return nil, p.errorf("expected operator after %s", t.text)
+	}
+	operator := strings.ToLower(op.text)
+
+	switch operator {
+	case OpPresent:
+		return &Comparison{Path: path, Operator: operator}, nil
+	case OpEqual, OpNotEqual, OpContains, OpStartsWith, OpEndsWith,
+		OpGreater, OpGreaterOrEqual, OpLess, OpLessOrEqual:
+	default:
+		return nil, p.errorf("unknown operator %q", op.text)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &Comparison{Path: path, Operator: operator, Value: value}, nil
+}
+
+func (p *filterParser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenWord:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		var number float64
+		if err := json.Unmarshal([]byte(t.text), &number); err == nil {
+			return number, nil
+		}
+	}
+	return nil, p.errorf("invalid comparison value %q", t.text)
+}
@@ -0,0 +1,395 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations" validate:"required,min=1,dive"`
+}
+
+// PatchOperation is a single operation of a PATCH request. Op is matched
+// case-insensitively since some clients send "Replace".
+type PatchOperation struct {
+	Op    string          `json:"op" validate:"required"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchPath is a parsed operation path such as
+// `emails[type eq "work"].value`: Attribute "emails", Filter the bracketed
+// expression and SubAttribute "value".
+type patchPath struct {
+	Attribute    string
+	Filter       Expr
+	SubAttribute string
+}
+
+func parsePatchPath(path string) (*patchPath, error) {
+	invalid := func() (*patchPath, error) {
+		return nil, &PatchError{Type: ErrorInvalidPath, Detail: fmt.Sprintf("invalid path %q", path)}
+	}
+
+	normalized := NormalizePath(path)
+	if open := strings.IndexByte(normalized, '['); open >= 0 {
+		end := strings.LastIndexByte(path, ']')
+		if end < 0 {
+			return invalid()
+		}
+		// Parse the original text so string values keep their case.
+		offset := len(path) - len(normalized)
+		filter, err := ParseFilter(path[offset : end+1])
+		if err != nil {
+			return invalid()
+		}
+		result := &patchPath{Attribute: normalized[:open], Filter: filter}
+		rest := normalized[end-offset+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return invalid()
+			}
+			result.SubAttribute = rest[1:]
+		}
+		return result, nil
+	}
+
+	attribute, sub, _ := strings.Cut(normalized, ".")
+	return &patchPath{Attribute: attribute, SubAttribute: sub}, nil
+}
+
+func operation(op PatchOperation) (string, error) {
+	switch name := strings.ToLower(op.Op); name {
+	case "add", "replace", "remove":
+		return name, nil
+	default:
+		return "", &PatchError{Type: ErrorInvalidSyntax, Detail: fmt.Sprintf("unknown operation %q", op.Op)}
+	}
+}
+
+func invalidValue(path string) error {
+	return &PatchError{Type: ErrorInvalidValue, Detail: fmt.Sprintf("invalid value for %q", path)}
+}
+
+// Patch applies the operations of a PATCH request to u. Attributes this
+// server does not store, such as enterprise extension attributes, are
+// ignored rather than rejected so that IdP defaults keep working.
+func (u *User) Patch(operations []PatchOperation) error {
+	for _, op := range operations {
+		name, err := operation(op)
+		if err != nil {
+			return err
+		}
+
+		if op.Path == "" {
+			if name == "remove" {
+				return &PatchError{Type: ErrorNoTarget, Detail: "remove requires a path"}
+			}
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return invalidValue("")
+			}
+			for key, value := range values {
+				if err := u.patchPath(name, key, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := u.patchPath(name, op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *User) patchPath(op, rawPath string, value json.RawMessage) error {
+	path, err := parsePatchPath(rawPath)
+	if err != nil {
+		return err
+	}
+
+	if op == "remove" {
+		switch path.Attribute {
+		case "externalid":
+			u.ExternalID = ""
+		case "phonenumbers":
+			u.PhoneNumbers = nil
+		case "name":
+			if u.Name == nil {
+				return nil
+			}
+			switch path.SubAttribute {
+			case "givenname":
+				u.Name.GivenName = ""
+			case "familyname":
+				u.Name.FamilyName = ""
+			case "":
+				u.Name = nil
+			}
+		case "username", "emails", "active":
+			return &PatchError{Type: ErrorMutability, Detail: fmt.Sprintf("%q cannot be removed", rawPath)}
+		}
+		return nil
+	}
+
+	switch path.Attribute {
+	case "active":
+		active, ok := parseBool(value)
+		if !ok {
+			return invalidValue(rawPath)
+		}
+		u.Active = &active
+	case "username":
+		return decodeString(value, &u.UserName, rawPath)
+	case "externalid":
+		return decodeString(value, &u.ExternalID, rawPath)
+	case "password":
+		return decodeString(value, &u.Password, rawPath)
+	case "name":
+		if u.Name == nil {
+			u.Name = &Name{}
+		}
+		switch path.SubAttribute {
+		case "givenname":
+			return decodeString(value, &u.Name.GivenName, rawPath)
+		case "familyname":
+			return decodeString(value, &u.Name.FamilyName, rawPath)
+		case "formatted":
+			return nil
+		case "":
+			var name Name
+			if err := json.Unmarshal(value, &name); err != nil {
+				return invalidValue(rawPath)
+			}
+			if name.GivenName != "" {
+				u.Name.GivenName = name.GivenName
+			}
+			if name.FamilyName != "" {
+				u.Name.FamilyName = name.FamilyName
+			}
+		}
+	case "emails":
+		return patchMultiValued(&u.Emails, path, value, rawPath)
+	case "phonenumbers":
+		return patchMultiValued(&u.PhoneNumbers, path, value, rawPath)
+	}
+	return nil
+}
+
+// patchMultiValued sets the single value this server keeps for a
+// multi-valued attribute, from either a list or a `[filter].value` path.
+func patchMultiValued(target *[]MultiValued, path *patchPath, value json.RawMessage, rawPath string) error {
+	if path.SubAttribute == "value" || (path.Filter != nil && path.SubAttribute == "") {
+		var text string
+		if path.SubAttribute == "" {
+			var item MultiValued
+			if err := json.Unmarshal(value, &item); err != nil {
+				return invalidValue(rawPath)
+			}
+			text = item.Value
+		} else if err := json.Unmarshal(value, &text); err != nil {
+			return invalidValue(rawPath)
+		}
+		*target = []MultiValued{{Value: text, Type: "work", Primary: true}}
+		return nil
+	}
+	if path.SubAttribute != "" {
+		return nil
+	}
+
+	var items []MultiValued
+	if err := json.Unmarshal(value, &items); err != nil {
+		return invalidValue(rawPath)
+	}
+	*target = items
+	return nil
+}
+
+// Patch applies the operations of a PATCH request to g.
+func (g *Group) Patch(operations []PatchOperation) error {
+	for _, op := range operations {
+		name, err := operation(op)
+		if err != nil {
+			return err
+		}
+
+		if op.Path == "" {
+			if name == "remove" {
+				return &PatchError{Type: ErrorNoTarget, Detail: "remove requires a path"}
+			}
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return invalidValue("")
+			}
+			for key, value := range values {
+				if err := g.patchPath(name, key, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := g.patchPath(name, op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Group) patchPath(op, rawPath string, value json.RawMessage) error {
+	path, err := parsePatchPath(rawPath)
+	if err != nil {
+		return err
+	}
+
+	switch path.Attribute {
+	case "displayname":
+		if op == "remove" {
+			return &PatchError{Type: ErrorMutability, Detail: "displayName cannot be removed"}
+		}
+		return decodeString(value, &g.DisplayName, rawPath)
+	case "externalid":
+		if op == "remove" {
+			g.ExternalID = ""
+			return nil
+		}
+		return decodeString(value, &g.ExternalID, rawPath)
+	case "members":
+		return g.patchMembers(op, path, value, rawPath)
+	}
+	return nil
+}
+
+func (g *Group) patchMembers(op string, path *patchPath, value json.RawMessage, rawPath string) error {
+	var members []Member
+	if len(value) > 0 && string(value) != "null" {
+		if err := json.Unmarshal(value, &members); err != nil {
+			var member Member
+			if err := json.Unmarshal(value, &member); err != nil {
+				return invalidValue(rawPath)
+			}
+			members = []Member{member}
+		}
+	}
+
+	switch op {
+	case "replace":
+		if path.Filter != nil {
+			return &PatchError{Type: ErrorInvalidPath, Detail: "replace does not support member filters"}
+		}
+		g.Members = members
+	case "add":
+		existing := make(map[string]bool, len(g.Members))
+		for _, member := range g.Members {
+			existing[strings.ToLower(member.Value)] = true
+		}
+		for _, member := range members {
+			if !existing[strings.ToLower(member.Value)] {
+				existing[strings.ToLower(member.Value)] = true
+				g.Members = append(g.Members, member)
+			}
+		}
+	case "remove":
+		remove := func(member Member) bool {
+			if path.Filter != nil {
+				return evaluate(path.Filter, func(attribute string) (string, bool) {
+					switch attribute {
+					case "members.value":
+						return member.Value, true
+					case "members.display":
+						return member.Display, true
+					}
+					return "", false
+				})
+			}
+			if len(members) == 0 {
+				return true
+			}
+			for _, candidate := range members {
+				if strings.EqualFold(candidate.Value, member.Value) {
+					return true
+				}
+			}
+			return false
+		}
+
+		kept := g.Members[:0]
+		for _, member := range g.Members {
+			if !remove(member) {
+				kept = append(kept, member)
+			}
+		}
+		g.Members = kept
+	}
+	return nil
+}
+
+// evaluate applies a filter to a single value using case-insensitive string
+// comparisons, as used by value path filters.
+func evaluate(expr Expr, lookup func(path string) (string, bool)) bool {
+	switch e := expr.(type) {
+	case *Logical:
+		if e.Operator == "and" {
+			return evaluate(e.Left, lookup) && evaluate(e.Right, lookup)
+		}
+		return evaluate(e.Left, lookup) || evaluate(e.Right, lookup)
+	case *Not:
+		return !evaluate(e.Expr, lookup)
+	case *Comparison:
+		actual, ok := lookup(e.Path)
+		if !ok {
+			return false
+		}
+		if e.Operator == OpPresent {
+			return actual != ""
+		}
+		expected, ok := e.Value.(string)
+		if !ok {
+			return false
+		}
+		actual, expected = strings.ToLower(actual), strings.ToLower(expected)
+		switch e.Operator {
+		case OpEqual:
+			return actual == expected
+		case OpNotEqual:
+			return actual != expected
+		case OpContains:
+			return strings.Contains(actual, expected)
+		case OpStartsWith:
+			return strings.HasPrefix(actual, expected)
+		case OpEndsWith:
+			return strings.HasSuffix(actual, expected)
+		}
+	}
+	return false
+}
+
+func decodeString(value json.RawMessage, target *string, path string) error {
+	if err := json.Unmarshal(value, target); err != nil {
+		return invalidValue(path)
+	}
+	return nil
+}
+
+// parseBool accepts JSON booleans and, for Entra ID, the strings "True" and
+// "False".
+func parseBool(value json.RawMessage) (bool, bool) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, true
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
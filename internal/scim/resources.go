@@ -0,0 +1,211 @@
+package scim
+
+import (
+	"github.com/google/uuid"
+	"strings"
+	"time"
+	"user-management/internal/models"
+)
+
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	EnterpriseUserSchema        = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+
+	ContentType = "application/scim+json"
+
+	ResourceTypeUser  = "User"
+	ResourceTypeGroup = "Group"
+)
+
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type MultiValued struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type User struct {
+	Schemas      []string      `json:"schemas"`
+	ID           string        `json:"id,omitempty"`
+	ExternalID   string        `json:"externalId,omitempty"`
+	UserName     string        `json:"userName"`
+	Name         *Name         `json:"name,omitempty"`
+	DisplayName  string        `json:"displayName,omitempty"`
+	Emails       []MultiValued `json:"emails,omitempty"`
+	PhoneNumbers []MultiValued `json:"phoneNumbers,omitempty"`
+	Active       *bool         `json:"active,omitempty"`
+	// Password is write-only and never returned.
+	Password string `json:"password,omitempty"`
+	Meta     *Meta  `json:"meta,omitempty"`
+}
+
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+func NewListResponse(resources []interface{}, total, startIndex int) ListResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// NewUser renders a provisioned user. baseURL is the SCIM root used to build
+// meta.location.
+func NewUser(member *models.SCIMUser, baseURL string) *User {
+	user := member.User
+	active := member.Active
+	id := user.ID.String()
+
+	resource := &User{
+		Schemas:     []string{UserSchema},
+		ID:          id,
+		ExternalID:  member.ExternalID,
+		UserName:    user.Email,
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Name: &Name{
+			Formatted:  strings.TrimSpace(user.FirstName + " " + user.LastName),
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		Emails: []MultiValued{{Value: user.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Meta: &Meta{
+			ResourceType: ResourceTypeUser,
+			Created:      models.TimePtr(user.CreatedAt.UTC()),
+			LastModified: models.TimePtr(user.UpdatedAt.UTC()),
+			Location:     baseURL + "/Users/" + id,
+		},
+	}
+	if user.PhoneNumber != nil {
+		resource.PhoneNumbers = []MultiValued{{Value: *user.PhoneNumber, Type: "work"}}
+	}
+	return resource
+}
+
+// Email returns the address the user signs in with: userName when it is an
+// email address, otherwise the primary (or first) email.
+func (u *User) Email() string {
+	if strings.Contains(u.UserName, "@") {
+		return u.UserName
+	}
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// Apply copies the attributes of u onto member. Attributes the SCIM
+// resource does not carry, such as the bio, are left untouched.
+func (u *User) Apply(member *models.SCIMUser) {
+	member.User.Email = strings.ToLower(u.Email())
+	member.ExternalID = u.ExternalID
+	if u.Name != nil {
+		member.User.FirstName = u.Name.GivenName
+		member.User.LastName = u.Name.FamilyName
+	}
+	member.User.PhoneNumber = nil
+	if len(u.PhoneNumbers) > 0 {
+		member.User.PhoneNumber = models.StringPtr(u.PhoneNumbers[0].Value)
+	}
+	member.Active = u.Active == nil || *u.Active
+}
+
+func NewGroup(group *models.SCIMGroup, baseURL string) *Group {
+	id := group.Role.ID.String()
+	resource := &Group{
+		Schemas:     []string{GroupSchema},
+		ID:          id,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.Role.Name,
+		Meta: &Meta{
+			ResourceType: ResourceTypeGroup,
+			Created:      models.TimePtr(group.Role.CreatedAt.UTC()),
+			LastModified: models.TimePtr(group.Role.UpdatedAt.UTC()),
+			Location:     baseURL + "/Groups/" + id,
+		},
+	}
+	for _, member := range group.Members {
+		value := member.UserID.String()
+		resource.Members = append(resource.Members, Member{
+			Value:   value,
+			Display: member.Display,
+			Ref:     baseURL + "/Users/" + value,
+			Type:    ResourceTypeUser,
+		})
+	}
+	return resource
+}
+
+// Apply copies the attributes of g onto group. Member values that are not
+// user IDs are returned so the caller can reject them.
+func (g *Group) Apply(group *models.SCIMGroup) []string {
+	group.Role.Name = g.DisplayName
+	group.ExternalID = g.ExternalID
+	group.Members = nil
+
+	var invalid []string
+	seen := make(map[uuid.UUID]bool)
+	for _, member := range g.Members {
+		id, err := uuid.Parse(member.Value)
+		if err != nil {
+			invalid = append(invalid, member.Value)
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			group.Members = append(group.Members, models.SCIMGroupMember{UserID: id})
+		}
+	}
+	return invalid
+}
@@ -0,0 +1,132 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"user-management/internal/models"
+)
+
+var testAttributes = map[string]Attribute{
+	"username":      {Expr: "u.email"},
+	"externalid":    {Expr: "x.external_id", CaseExact: true},
+	"emails.type":   {Expr: "'work'"},
+	"active":        {Expr: "u.active", Type: TypeBoolean},
+	"meta.created":  {Expr: "u.created_at", Type: TypeDateTime},
+	"members.value": {Expr: "SELECT user_id::text FROM user_roles", Type: TypeReference},
+}
+
+func TestParseFilterPrecedenceAndValuePaths(t *testing.T) {
+	expr, err := ParseFilter(`userName sw "J" and (emails[type eq "work"] or not (active eq false))`)
+	require.NoError(t, err)
+
+	and, ok := expr.(*Logical)
+	require.True(t, ok)
+	require.Equal(t, "and", and.Operator)
+	require.Equal(t, &Comparison{Path: "username", Operator: OpStartsWith, Value: "J"}, and.Left)
+
+	or, ok := and.Right.(*Logical)
+	require.True(t, ok)
+	require.Equal(t, "or", or.Operator)
+	require.Equal(t, &Comparison{Path: "emails.type", Operator: OpEqual, Value: "work"}, or.Left)
+	require.Equal(t, &Not{Expr: &Comparison{Path: "active", Operator: OpEqual, Value: false}}, or.Right)
+
+	expr, err = ParseFilter(`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "a\"b"`)
+	require.NoError(t, err)
+	require.Equal(t, &Comparison{Path: "username", Operator: OpEqual, Value: `a"b`}, expr)
+
+	for _, filter := range []string{`userName`, `userName eq`, `userName xx "a"`, `(userName eq "a"`, `userName eq "a" extra`, `userName eq "a`} {
+		_, err := ParseFilter(filter)
+		require.ErrorIs(t, err, ErrInvalidFilter, filter)
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	expr, err := ParseFilter(`userName co "50%_" or externalId eq "Abc" and active eq true`)
+	require.NoError(t, err)
+
+	clause, args, err := Compile(expr, testAttributes, 2)
+	require.NoError(t, err)
+	require.Equal(t, "(LOWER(u.email) LIKE $2 OR (x.external_id = $3 AND u.active = $4))", clause)
+	require.Equal(t, []interface{}{`%50\%\_%`, "Abc", true}, args)
+
+	expr, err = ParseFilter(`members[value eq "ABC"] and meta.created gt "2024-01-01T00:00:00Z" and externalId pr`)
+	require.NoError(t, err)
+	clause, _, err = Compile(expr, testAttributes, 1)
+	require.NoError(t, err)
+	require.Equal(t, "(($1 IN (SELECT user_id::text FROM user_roles) AND u.created_at > $2) AND "+
+		"(x.external_id IS NOT NULL AND x.external_id <> ''))", clause)
+
+	for _, filter := range []string{`password eq "x"`, `active eq "yes"`, `meta.created gt "yesterday"`, `members.value co "a"`} {
+		expr, err := ParseFilter(filter)
+		require.NoError(t, err)
+		_, _, err = Compile(expr, testAttributes, 1)
+		require.ErrorIs(t, err, ErrInvalidFilter, filter)
+	}
+}
+
+func patch(t *testing.T, operations string) []PatchOperation {
+	var req PatchRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"Operations":`+operations+`}`), &req))
+	return req.Operations
+}
+
+func TestUserPatch(t *testing.T) {
+	member := &models.SCIMUser{
+		User:   models.User{ID: uuid.New(), Email: "jane@example.com", FirstName: "Jane", LastName: "Doe"},
+		Active: true,
+	}
+	user := NewUser(member, "https://id.example.com/scim/v2")
+
+	err := user.Patch(patch(t, `[
+		{"op":"Replace","path":"name.givenName","value":"Janet"},
+		{"op":"replace","path":"emails[type eq \"work\"].value","value":"Janet@Example.com"},
+		{"op":"replace","value":{"active":"False","externalId":"ext-1"}},
+		{"op":"add","path":"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department","value":"Sales"}
+	]`))
+	require.NoError(t, err)
+
+	user.UserName = "janet"
+	user.Apply(member)
+	require.Equal(t, "janet@example.com", member.User.Email)
+	require.Equal(t, "Janet", member.User.FirstName)
+	require.Equal(t, "ext-1", member.ExternalID)
+	require.False(t, member.Active)
+
+	var patchErr *PatchError
+	err = user.Patch(patch(t, `[{"op":"remove","path":"userName"}]`))
+	require.True(t, errors.As(err, &patchErr))
+	require.Equal(t, ErrorMutability, patchErr.Type)
+
+	err = user.Patch(patch(t, `[{"op":"move","path":"userName"}]`))
+	require.True(t, errors.As(err, &patchErr))
+	require.Equal(t, ErrorInvalidSyntax, patchErr.Type)
+}
+
+func TestGroupPatchMembers(t *testing.T) {
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+	group := NewGroup(&models.SCIMGroup{
+		Role:    models.Role{ID: uuid.New(), Name: "Engineering"},
+		Members: []models.SCIMGroupMember{{UserID: first}, {UserID: second}},
+	}, "")
+
+	err := group.Patch(patch(t, `[
+		{"op":"add","path":"members","value":[{"value":"`+third.String()+`"},{"value":"`+first.String()+`"}]},
+		{"op":"remove","path":"members[value eq \"`+second.String()+`\"]"},
+		{"op":"replace","path":"displayName","value":"Platform"}
+	]`))
+	require.NoError(t, err)
+
+	var result models.SCIMGroup
+	require.Empty(t, group.Apply(&result))
+	require.Equal(t, "Platform", result.Role.Name)
+	require.Equal(t, []models.SCIMGroupMember{{UserID: first}, {UserID: third}}, result.Members)
+
+	require.NoError(t, group.Patch(patch(t, `[{"op":"remove","path":"members","value":[{"value":"`+first.String()+`"}]}]`)))
+	require.Len(t, group.Members, 1)
+
+	require.NoError(t, group.Patch(patch(t, `[{"op":"add","path":"members","value":[{"value":"not-a-user"}]}]`)))
+	require.Equal(t, []string{"not-a-user"}, group.Apply(&result))
+}
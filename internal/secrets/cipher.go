@@ -0,0 +1,84 @@
+// Package secrets encrypts secrets that are stored in the database.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of encryption keys, which select AES-256.
+const KeySize = 32
+
+// version prefixes ciphertexts, so that the format can change later.
+const version = "v1:"
+
+var ErrDecrypt = errors.New("failed to decrypt secret")
+
+// Cipher encrypts with AES-256-GCM. Ciphertexts are bound to associated
+// data, such as the ID of the row they are stored in, so that they cannot
+// be moved between rows.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a Cipher for a base64 encoded key of KeySize bytes.
+func NewCipher(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d base64 encoded bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// GenerateKey returns a new base64 encoded key for NewCipher.
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func (c *Cipher) Encrypt(plaintext, associatedData []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, associatedData)
+	return version + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext string, associatedData []byte) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(ciphertext, version)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, associatedData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// IsEncrypted reports whether s was returned by Encrypt.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, version)
+}
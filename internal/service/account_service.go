@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/mail"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+var ErrEmailAlreadyVerified = errors.New("email address is already verified")
+
+const (
+	defaultVerificationTokenTTL = 24 * time.Hour
+	defaultResetTokenTTL        = time.Hour
+	mailSendTimeout             = time.Minute
+)
+
+// AccountService runs the email verification and password reset flows. Both
+// mail a link with a single-use token of which only the hash is stored.
+type AccountService struct {
+	users            repository.UserRepository
+	tokens           repository.UserTokenRepository
+	sessions         repository.SessionRepository
+	apiKeys          repository.APIKeyRepository
+	mailer           mail.Mailer
+	templates        *mail.Templates
+	verifyEmailURL   string
+	resetPasswordURL string
+	verificationTTL  time.Duration
+	resetTTL         time.Duration
+	now              func() time.Time
+	sending          sync.WaitGroup
+}
+
+func NewAccountService(conf config.AccountConfig, users repository.UserRepository, tokens repository.UserTokenRepository, sessions repository.SessionRepository, apiKeys repository.APIKeyRepository, mailer mail.Mailer, templates *mail.Templates) *AccountService {
+	verificationTTL := conf.VerificationTokenTTL
+	if verificationTTL <= 0 {
+		verificationTTL = defaultVerificationTokenTTL
+	}
+	resetTTL := conf.ResetTokenTTL
+	if resetTTL <= 0 {
+		resetTTL = defaultResetTokenTTL
+	}
+
+	return &AccountService{
+		users:            users,
+		tokens:           tokens,
+		sessions:         sessions,
+		apiKeys:          apiKeys,
+		mailer:           mailer,
+		templates:        templates,
+		verifyEmailURL:   conf.VerifyEmailURL,
+		resetPasswordURL: conf.ResetPasswordURL,
+		verificationTTL:  verificationTTL,
+		resetTTL:         resetTTL,
+		now:              time.Now,
+	}
+}
+
+// SendVerificationEmail mails the user a link to verify their address,
+// invalidating earlier links. locale is an Accept-Language value.
+func (s *AccountService) SendVerificationEmail(ctx context.Context, userID uuid.UUID, locale string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	msg, err := s.issue(ctx, user, models.TokenPurposeEmailVerification, s.verificationTTL, s.verifyEmailURL, mail.TemplateVerifyEmail, locale)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, msg)
+}
+
+// VerifyEmail marks the address a verification token was sent to as
+// verified, unless the user has changed address since.
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	userToken, err := s.tokens.Consume(ctx, models.TokenPurposeEmailVerification, hashUserToken(token))
+	if err != nil {
+		return err
+	}
+
+	err = s.users.MarkEmailVerified(ctx, userToken.UserID, userToken.Email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return repository.ErrUserTokenInvalid
+	}
+	return err
+}
+
+// RequestPasswordReset mails a reset link when email belongs to an active
+// user. It returns the same result either way, and the link is issued and
+// mailed in the background so that the response time does not tell whether
+// the address has an account either.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email, locale string) error {
+	user, err := s.users.GetByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.IsActive {
+		return nil
+	}
+
+	s.sending.Add(1)
+	go func() {
+		defer s.sending.Done()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+		defer cancel()
+
+		msg, err := s.issue(ctx, user, models.TokenPurposePasswordReset, s.resetTTL, s.resetPasswordURL, mail.TemplateResetPassword, locale)
+		if err == nil {
+			err = s.mailer.Send(ctx, msg)
+		}
+		if err != nil {
+			log.Printf("failed to send password reset email: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token. Whoever knew the
+// old password may have logged in or created API keys with it, so every
+// session, refresh token and API key of the user is revoked as well.
+func (s *AccountService) ResetPassword(ctx context.Context, token, password string) error {
+	userToken, err := s.tokens.Consume(ctx, models.TokenPurposePasswordReset, hashUserToken(token))
+	if err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = s.users.SetPassword(ctx, userToken.UserID, hash)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return repository.ErrUserTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.sessions.RevokeAll(ctx, userToken.UserID, uuid.Nil); err != nil {
+		return err
+	}
+	_, err = s.apiKeys.DeleteAll(ctx, userToken.UserID)
+	return err
+}
+
+// Wait blocks until the emails sent in the background are done.
+func (s *AccountService) Wait() {
+	s.sending.Wait()
+}
+
+// issue stores a new token for user and renders the email carrying its
+// link.
+func (s *AccountService) issue(ctx context.Context, user *models.User, purpose string, ttl time.Duration, baseURL, template, locale string) (*mail.Message, error) {
+	token, err := newUserToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.tokens.Create(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashUserToken(token),
+		Email:     user.Email,
+		ExpiresAt: s.now().Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link URL %q: %w", baseURL, err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	msg, err := s.templates.Render(locale, template, mail.TemplateData{
+		Name:      user.FirstName,
+		Link:      link.String(),
+		ExpiresIn: ttl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.To = user.Email
+	return msg, nil
+}
+
+func newUserToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
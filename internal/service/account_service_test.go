@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/mail"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+func (f *fakeUserRepository) SetPassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	user, ok := f.users[id]
+	if !ok || !user.IsActive {
+		return repository.ErrUserNotFound
+	}
+	user.Password = passwordHash
+	return nil
+}
+
+func (f *fakeUserRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID, email string) error {
+	user, ok := f.users[id]
+	if !ok || !user.IsActive || user.Email != email {
+		return repository.ErrUserNotFound
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+type fakeUserTokenRepository struct {
+	repository.UserTokenRepository
+	tokens []*models.UserToken
+}
+
+func (f *fakeUserTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	kept := f.tokens[:0]
+	for _, existing := range f.tokens {
+		if existing.UserID != token.UserID || existing.Purpose != token.Purpose || existing.UsedAt != nil {
+			kept = append(kept, existing)
+		}
+	}
+	copied := *token
+	f.tokens = append(kept, &copied)
+	return nil
+}
+
+func (f *fakeUserTokenRepository) Consume(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	for _, token := range f.tokens {
+		if token.TokenHash == tokenHash && token.Purpose == purpose && token.UsedAt == nil && token.ExpiresAt.After(time.Now()) {
+			now := time.Now()
+			token.UsedAt = &now
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrUserTokenInvalid
+}
+
+type fakeAPIKeyRepository struct {
+	repository.APIKeyRepository
+	keys map[uuid.UUID]*models.APIKey
+}
+
+func (f *fakeAPIKeyRepository) DeleteAll(ctx context.Context, userID uuid.UUID) (int, error) {
+	deleted := 0
+	for id, key := range f.keys {
+		if key.UserID == userID {
+			delete(f.keys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+var linkPattern = regexp.MustCompile(`https://app\.example\.com/\S+`)
+
+// mailedToken returns the token of the link in the last message sent.
+func mailedToken(t *testing.T, mailer *mail.MemoryMailer) string {
+	messages := mailer.Messages()
+	require.NotEmpty(t, messages)
+	link, err := url.Parse(linkPattern.FindString(messages[len(messages)-1].Text))
+	require.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+func newTestAccountService(t *testing.T, users map[uuid.UUID]*models.User) (*AccountService, *mail.MemoryMailer, *fakeUserTokenRepository) {
+	templates, err := mail.LoadTemplates()
+	require.NoError(t, err)
+	mailer := mail.NewMemoryMailer()
+	tokens := &fakeUserTokenRepository{}
+	svc := NewAccountService(config.AccountConfig{
+		VerifyEmailURL:   "https://app.example.com/verify-email",
+		ResetPasswordURL: "https://app.example.com/reset-password?source=email",
+	}, &fakeUserRepository{users: users}, tokens,
+		&fakeSessionRepository{sessions: map[uuid.UUID]*models.Session{}},
+		&fakeAPIKeyRepository{keys: map[uuid.UUID]*models.APIKey{}},
+		mailer, templates)
+	return svc, mailer, tokens
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", FirstName: "Ada", IsActive: true}
+	svc, mailer, tokens := newTestAccountService(t, map[uuid.UUID]*models.User{user.ID: user})
+
+	require.NoError(t, svc.RequestPasswordReset(ctx, "nobody@example.com", "en"))
+	svc.Wait()
+	require.Empty(t, mailer.Messages(), "unknown addresses get no mail but the same response")
+
+	require.NoError(t, svc.RequestPasswordReset(ctx, " Ada@Example.com ", "de-DE"))
+	svc.Wait()
+	first := mailedToken(t, mailer)
+	require.NoError(t, svc.RequestPasswordReset(ctx, "ada@example.com", "de-DE"))
+	svc.Wait()
+	token := mailedToken(t, mailer)
+
+	messages := mailer.Messages()
+	require.Len(t, messages, 2)
+	require.Equal(t, "ada@example.com", messages[1].To)
+	require.Equal(t, "Setze dein Passwort zurück", messages[1].Subject)
+	require.Contains(t, messages[1].Text, "source=email")
+	require.Len(t, tokens.tokens, 1)
+	require.NotEqual(t, token, tokens.tokens[0].TokenHash, "only the hash is stored")
+
+	require.ErrorIs(t, svc.ResetPassword(ctx, first, "new password 1"), repository.ErrUserTokenInvalid, "a newer link replaces older ones")
+	require.NoError(t, svc.ResetPassword(ctx, token, "new password 1"))
+	require.True(t, auth.CheckPassword(user.Password, "new password 1"))
+	require.ErrorIs(t, svc.ResetPassword(ctx, token, "new password 2"), repository.ErrUserTokenInvalid, "tokens are single-use")
+
+	require.NoError(t, svc.RequestPasswordReset(ctx, "ada@example.com", ""))
+	svc.Wait()
+	tokens.tokens[len(tokens.tokens)-1].ExpiresAt = time.Now().Add(-time.Second)
+	require.ErrorIs(t, svc.ResetPassword(ctx, mailedToken(t, mailer), "new password 3"), repository.ErrUserTokenInvalid)
+}
+
+func TestPasswordResetRevokesAccess(t *testing.T) {
+	ctx := context.Background()
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", FirstName: "Ada", IsActive: true}
+	other := uuid.New()
+	svc, mailer, _ := newTestAccountService(t, map[uuid.UUID]*models.User{user.ID: user})
+
+	sessions := &fakeSessionRepository{sessions: map[uuid.UUID]*models.Session{}}
+	for _, userID := range []uuid.UUID{user.ID, user.ID, other} {
+		id := uuid.New()
+		sessions.sessions[id] = &models.Session{ID: id, UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}
+	}
+	keys := &fakeAPIKeyRepository{keys: map[uuid.UUID]*models.APIKey{}}
+	for _, userID := range []uuid.UUID{user.ID, other} {
+		id := uuid.New()
+		keys.keys[id] = &models.APIKey{ID: id, UserID: userID}
+	}
+	svc.sessions, svc.apiKeys = sessions, keys
+
+	require.NoError(t, svc.RequestPasswordReset(ctx, "ada@example.com", ""))
+	svc.Wait()
+	require.NoError(t, svc.ResetPassword(ctx, mailedToken(t, mailer), "new password"))
+
+	for _, session := range sessions.sessions {
+		require.Equal(t, session.UserID == user.ID, session.RevokedAt != nil, "only the user's sessions are revoked")
+	}
+	require.Len(t, keys.keys, 1)
+	for _, key := range keys.keys {
+		require.Equal(t, other, key.UserID, "only the user's API keys are revoked")
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", IsActive: true}
+	svc, mailer, _ := newTestAccountService(t, map[uuid.UUID]*models.User{user.ID: user})
+
+	require.NoError(t, svc.SendVerificationEmail(ctx, user.ID, "es"))
+	require.Equal(t, "Verifica tu dirección de correo", mailer.Messages()[0].Subject)
+	require.ErrorIs(t, svc.VerifyEmail(ctx, "not-a-token"), repository.ErrUserTokenInvalid)
+	require.NoError(t, svc.VerifyEmail(ctx, mailedToken(t, mailer)))
+	require.True(t, user.EmailVerified)
+	require.ErrorIs(t, svc.SendVerificationEmail(ctx, user.ID, "es"), ErrEmailAlreadyVerified)
+
+	user.EmailVerified = false
+	require.NoError(t, svc.SendVerificationEmail(ctx, user.ID, ""))
+	user.Email = "ada@other.example"
+	require.ErrorIs(t, svc.VerifyEmail(ctx, mailedToken(t, mailer)), repository.ErrUserTokenInvalid,
+		"a link verifies only the address it was sent to")
+	require.False(t, user.EmailVerified)
+}
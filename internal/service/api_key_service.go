@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"log"
+	"strings"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+var (
+	ErrScopeNotHeld  = errors.New("scope exceeds the permissions of the user")
+	ErrInvalidExpiry = errors.New("expiry must be in the future")
+)
+
+const (
+	defaultAPIKeyTouchInterval = time.Minute
+
+	// apiKeyPrefixLength is the length of the public part of new keys:
+	// auth.APIKeyPrefix and 16 hex characters. Keys created before it grew
+	// have 8.
+	apiKeyPrefixLength = len(auth.APIKeyPrefix) + 16
+)
+
+// APIKeyService manages personal API keys and implements
+// auth.APIKeyValidator. A key is its prefix, an underscore and a secret.
+type APIKeyService struct {
+	keys          repository.APIKeyRepository
+	userRoles     repository.UserRoleRepository
+	touchInterval time.Duration
+	now           func() time.Time
+}
+
+func NewAPIKeyService(conf config.APIKeyConfig, keys repository.APIKeyRepository, userRoles repository.UserRoleRepository) *APIKeyService {
+	touchInterval := conf.TouchInterval
+	if touchInterval <= 0 {
+		touchInterval = defaultAPIKeyTouchInterval
+	}
+
+	return &APIKeyService{
+		keys:          keys,
+		userRoles:     userRoles,
+		touchInterval: touchInterval,
+		now:           time.Now,
+	}
+}
+
+// Create stores a key for the user and organization of key and returns
+// it, which is only shown once. Scopes must be permissions the user holds
+// in the organization.
+func (s *APIKeyService) Create(ctx context.Context, key *models.APIKey) (string, error) {
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(s.now()) {
+		return "", ErrInvalidExpiry
+	}
+
+	key.Scopes = oidc.ParseScope(strings.Join(key.Scopes, " "))
+	if len(key.Scopes) > 0 {
+		permissions, err := s.userRoles.GetUserPermissions(ctx, key.UserID, key.OrganizationID)
+		if err != nil {
+			return "", err
+		}
+		held := make([]string, len(permissions))
+		for i, permission := range permissions {
+			held[i] = permission.Name
+		}
+		if !oidc.Subset(key.Scopes, held) {
+			return "", ErrScopeNotHeld
+		}
+	}
+
+	raw := make([]byte, (apiKeyPrefixLength-len(auth.APIKeyPrefix))/2)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate api key prefix: %w", err)
+	}
+	secret, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+
+	key.Prefix = auth.APIKeyPrefix + hex.EncodeToString(raw)
+	token := key.Prefix + "_" + secret
+	key.KeyHash = hashUserToken(token)
+	if err := s.keys.Create(ctx, key); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
+	return s.keys.ListByUser(ctx, userID)
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	return s.keys.Delete(ctx, userID, id)
+}
+
+// ValidateAPIKey implements auth.APIKeyValidator. The key must be
+// unexpired, belong to an active user and be used from an allowed address.
+// It also records when the key was last used.
+func (s *APIKeyService) ValidateAPIKey(ctx context.Context, token string) (*auth.Claims, error) {
+	key, err := s.find(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	ip := audit.ActorFrom(ctx).IPAddress
+	if !key.AllowsIP(ip) {
+		return nil, fmt.Errorf("%w: api key not allowed from %s", auth.ErrInvalidToken, ip)
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= s.touchInterval {
+		if err := s.keys.Touch(ctx, key.ID, now, ip); err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+
+	return apiKeyClaims(key), nil
+}
+
+// find returns the key of token when it is unexpired and belongs to an
+// active user.
+func (s *APIKeyService) find(ctx context.Context, token string) (*models.APIKey, error) {
+	invalid := fmt.Errorf("%w: unknown api key", auth.ErrInvalidToken)
+	if !auth.IsAPIKey(token) {
+		return nil, invalid
+	}
+	// The prefix is hex, so the first underscore after auth.APIKeyPrefix
+	// ends it; the secret may contain more.
+	end := strings.IndexByte(token[len(auth.APIKeyPrefix):], '_')
+	if end <= 0 {
+		return nil, invalid
+	}
+
+	key, err := s.keys.GetByPrefix(ctx, token[:len(auth.APIKeyPrefix)+end])
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashUserToken(token)), []byte(key.KeyHash)) != 1 {
+		return nil, invalid
+	}
+
+	if key.Expired(s.now()) {
+		return nil, fmt.Errorf("%w: api key expired", auth.ErrInvalidToken)
+	}
+	if !key.UserActive {
+		return nil, fmt.Errorf("%w: user is inactive", auth.ErrInvalidToken)
+	}
+	return key, nil
+}
+
+// apiKeyClaims returns the claims a key stands for. Their ID is the ID of
+// the key.
+func apiKeyClaims(key *models.APIKey) *auth.Claims {
+	claims := &auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:      key.ID.String(),
+			Subject: key.UserID.String(),
+		},
+		OrganizationID: key.OrganizationID.String(),
+		Principal:      auth.PrincipalAPIKey,
+		Scope:          strings.Join(key.Scopes, " "),
+	}
+	if key.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*key.ExpiresAt)
+	}
+	return claims
+}
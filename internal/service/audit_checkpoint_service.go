@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+// AuditCheckpointService periodically signs the head of every audit chain
+// that has grown since its last checkpoint.
+type AuditCheckpointService struct {
+	audit  repository.AuditRepository
+	signer *audit.Signer
+}
+
+func NewAuditCheckpointService(audit repository.AuditRepository, signer *audit.Signer) *AuditCheckpointService {
+	return &AuditCheckpointService{audit: audit, signer: signer}
+}
+
+func (s *AuditCheckpointService) Checkpoint(ctx context.Context) (int, error) {
+	heads, err := s.audit.ChainHeads(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, head := range heads {
+		checkpoint := &models.AuditCheckpoint{
+			OrganizationID: head.OrganizationID,
+			EventID:        head.ID,
+			Hash:           head.Hash,
+		}
+		s.signer.Sign(checkpoint)
+
+		if err := s.audit.CreateCheckpoint(ctx, checkpoint); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(heads), nil
+}
+
+// Run creates checkpoints every interval until ctx is cancelled.
+func (s *AuditCheckpointService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Checkpoint(ctx); err != nil {
+				log.Printf("failed to create audit checkpoints: %v\n", err)
+			}
+		}
+	}
+}
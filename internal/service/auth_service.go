@@ -0,0 +1,528 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/mfa"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrNotMember          = errors.New("user is not a member of the organization")
+	ErrInvalidMFACode     = errors.New("invalid verification code")
+	ErrMFANotEnabled      = errors.New("MFA is not enabled")
+	// ErrReauthenticationFailed is returned when a logged in user confirms
+	// a sensitive change with a wrong password or second factor, without
+	// telling which was wrong.
+	ErrReauthenticationFailed = errors.New("invalid password or verification code")
+	// ErrLoginMethodNotAllowed is returned for logins to an organization
+	// with a method its login policy does not allow.
+	ErrLoginMethodNotAllowed = errors.New("login method is not allowed by the organization")
+)
+
+// amrLoginMethods maps the first factors recorded in amr to the login
+// methods of organizations' login policies.
+var amrLoginMethods = map[string]string{
+	auth.AMRPassword:    models.LoginMethodPassword,
+	auth.AMREmail:       models.LoginMethodMagicLink,
+	auth.AMRHardwareKey: models.LoginMethodPasskey,
+	auth.AMRFederated:   models.LoginMethodSSO,
+}
+
+// dummyPasswordHash is compared against when the email is unknown, so that
+// the response time does not reveal which addresses have accounts.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("not-a-real-password")
+	return hash
+})
+
+// LoginResult is the outcome of a login. Either AccessToken is set, along
+// with the RefreshToken of the session when sessions are kept, or MFAToken
+// is, when a second factor (or, with MFAEnrollmentRequired, enrollment) is
+// still needed.
+type LoginResult struct {
+	AccessToken           string
+	RefreshToken          string
+	MFAToken              string
+	MFAEnrollmentRequired bool
+}
+
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+	QRCode []byte
+}
+
+type MFAStatus struct {
+	Enabled                bool
+	RecoveryCodesRemaining int
+}
+
+type AuthService struct {
+	users         repository.UserRepository
+	userRoles     repository.UserRoleRepository
+	mfa           repository.MFARepository
+	loginPolicies repository.LoginPolicyRepository
+	tokens        *auth.TokenManager
+	throttle      *LoginThrottle
+	sessions      *SessionService
+	now           func() time.Time
+}
+
+// NewAuthService creates the service. loginPolicies may be nil to allow
+// every login method, throttle nil to not limit failed logins, and sessions
+// nil to issue access tokens without sessions.
+func NewAuthService(users repository.UserRepository, userRoles repository.UserRoleRepository, mfaRepo repository.MFARepository, loginPolicies repository.LoginPolicyRepository, tokens *auth.TokenManager, throttle *LoginThrottle, sessions *SessionService) *AuthService {
+	return &AuthService{
+		users:         users,
+		userRoles:     userRoles,
+		mfa:           mfaRepo,
+		loginPolicies: loginPolicies,
+		tokens:        tokens,
+		throttle:      throttle,
+		sessions:      sessions,
+		now:           time.Now,
+	}
+}
+
+// Login checks the password of a user and, when organizationID is set, that
+// they are an active member of it. Users with MFA enabled get an MFA token
+// to complete with VerifyMFA; users the organization requires MFA from who
+// have not enrolled get an enrollment token instead.
+//
+// Failed attempts count against the account and the client address, and
+// once either is throttled Login fails with a *LoginThrottledError without
+// checking the password.
+func (s *AuthService) Login(ctx context.Context, email, password string, organizationID uuid.UUID) (*LoginResult, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	ip := audit.ActorFrom(ctx).IPAddress
+	if err := s.throttle.Check(ctx, email, ip); err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		auth.CheckPassword(dummyPasswordHash(), password)
+		return nil, s.loginFailed(ctx, email, nil, ip)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !auth.CheckPassword(user.Password, password) || !user.IsActive {
+		return nil, s.loginFailed(ctx, email, &user.ID, ip)
+	}
+
+	result, err := s.finishLogin(ctx, user, organizationID, auth.AMRPassword)
+	if err != nil {
+		return nil, err
+	}
+	if result.MFAToken == "" {
+		if err := s.throttle.Succeed(ctx, email); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// finishLogin takes a user who passed the first factor of a login, with
+// the methods in amr, through the rest of it: membership of organizationID
+// when it is set and its login policy, then the second factor or the MFA
+// enrollment the organization requires, or else the tokens.
+func (s *AuthService) finishLogin(ctx context.Context, user *models.User, organizationID uuid.UUID, amr ...string) (*LoginResult, error) {
+	if organizationID != uuid.Nil {
+		member, err := isMember(ctx, s.userRoles, user.ID, organizationID)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			return nil, ErrNotMember
+		}
+		if err := checkLoginMethod(ctx, s.loginPolicies, organizationID, amr...); err != nil {
+			return nil, err
+		}
+	}
+
+	settings, err := s.mfa.Get(ctx, user.ID)
+	if err != nil && !errors.Is(err, repository.ErrMFANotFound) {
+		return nil, err
+	}
+	if settings != nil && settings.Enabled() {
+		token, err := s.tokens.GenerateMFAToken(user, organizationID, auth.TokenUseMFA, amr...)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: token}, nil
+	}
+
+	if organizationID != uuid.Nil {
+		required, err := s.mfa.IsRequired(ctx, user.ID, organizationID)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			token, err := s.tokens.GenerateMFAToken(user, organizationID, auth.TokenUseMFAEnrollment, amr...)
+			if err != nil {
+				return nil, err
+			}
+			return &LoginResult{MFAToken: token, MFAEnrollmentRequired: true}, nil
+		}
+	}
+
+	return startSession(ctx, s.sessions, s.tokens, user, organizationID, amr...)
+}
+
+// VerifyMFA completes a login started by Login with a TOTP code or a
+// recovery code. Invalid codes count as failed logins of the account.
+func (s *AuthService) VerifyMFA(ctx context.Context, mfaToken, code string) (*LoginResult, error) {
+	claims, err := s.tokens.ParseMFAToken(mfaToken, auth.TokenUseMFA)
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := claims.UserID()
+
+	ip := audit.ActorFrom(ctx).IPAddress
+	if err := s.throttle.Check(ctx, claims.Email, ip); err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(ctx, userID, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if err := s.throttle.Fail(ctx, claims.Email, &userID, ip); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	if err := s.throttle.Succeed(ctx, claims.Email); err != nil {
+		return nil, err
+	}
+
+	organizationID, _ := uuid.Parse(claims.OrganizationID)
+	return s.CompleteLogin(ctx, userID, organizationID, claims.AMR...)
+}
+
+// UnlockUser lifts the lockout of the account of a member of the
+// organization.
+func (s *AuthService) UnlockUser(ctx context.Context, organizationID, userID uuid.UUID) error {
+	member, err := isMember(ctx, s.userRoles, userID, organizationID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return repository.ErrUserNotFound
+	}
+	return s.throttle.Unlock(ctx, userID)
+}
+
+// CompleteLogin issues the tokens of a user who authenticated with a first
+// factor, the methods in amr or the password when empty, and a second
+// factor.
+func (s *AuthService) CompleteLogin(ctx context.Context, userID, organizationID uuid.UUID, amr ...string) (*LoginResult, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(amr) == 0 {
+		amr = []string{auth.AMRPassword}
+	}
+	amr = append(slices.Clone(amr), auth.AMROTP, auth.AMRMFA)
+	return startSession(ctx, s.sessions, s.tokens, user, organizationID, amr...)
+}
+
+func (s *AuthService) MFAStatus(ctx context.Context, userID uuid.UUID) (*MFAStatus, error) {
+	settings, err := s.mfa.Get(ctx, userID)
+	if errors.Is(err, repository.ErrMFANotFound) || (err == nil && !settings.Enabled()) {
+		return &MFAStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remaining, err := s.mfa.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAStatus{Enabled: true, RecoveryCodesRemaining: remaining}, nil
+}
+
+// BeginTOTPEnrollment generates a new secret for the user. MFA is enabled
+// once a code of the secret is confirmed with ConfirmTOTPEnrollment.
+func (s *AuthService) BeginTOTPEnrollment(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.SavePendingSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+
+	uri := mfa.URI(s.tokens.Issuer(), user.Email, secret)
+	qrCode, err := mfa.QRCode(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: uri, QRCode: qrCode}, nil
+}
+
+// ConfirmTOTPEnrollment enables MFA when code matches the pending secret and
+// returns the recovery codes, which are not retrievable afterwards.
+func (s *AuthService) ConfirmTOTPEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	settings, err := s.mfa.Get(ctx, userID)
+	if errors.Is(err, repository.ErrMFANotFound) {
+		return nil, ErrInvalidMFACode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if settings.Enabled() {
+		return nil, repository.ErrMFAAlreadyEnabled
+	}
+
+	step, ok := mfa.Validate(settings.TOTPSecret, code, s.now(), settings.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.Enable(ctx, userID, step, mfa.HashRecoveryCodes(codes)); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMFA turns MFA off after re-authenticating the user with their
+// password and a second factor.
+func (s *AuthService) DisableMFA(ctx context.Context, userID uuid.UUID, password, code string) error {
+	if err := s.requireMFA(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.reauthenticate(ctx, userID, password, code); err != nil {
+		return err
+	}
+
+	err := s.mfa.Disable(ctx, userID)
+	if errors.Is(err, repository.ErrMFANotFound) {
+		return ErrMFANotEnabled
+	}
+	return err
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after re-authenticating
+// the user.
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, password, code string) ([]string, error) {
+	if err := s.requireMFA(ctx, userID); err != nil {
+		return nil, err
+	}
+	if err := s.reauthenticate(ctx, userID, password, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.ReplaceRecoveryCodes(ctx, userID, mfa.HashRecoveryCodes(codes)); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// requireMFA returns ErrMFANotEnabled unless the user has MFA enabled. The
+// MFA changes check it before re-authenticating, so that their answer does
+// not depend on the password.
+func (s *AuthService) requireMFA(ctx context.Context, userID uuid.UUID) error {
+	settings, err := s.mfa.Get(ctx, userID)
+	if errors.Is(err, repository.ErrMFANotFound) || (err == nil && !settings.Enabled()) {
+		return ErrMFANotEnabled
+	}
+	return err
+}
+
+// reauthenticate checks the password and second factor of a logged in user
+// confirming a sensitive change. Failures count as failed logins of the
+// account and return ErrReauthenticationFailed whichever was wrong. It
+// returns ErrMFANotEnabled after a correct password when the user has no
+// second factor.
+func (s *AuthService) reauthenticate(ctx context.Context, userID uuid.UUID, password, code string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrReauthenticationFailed
+	}
+	if err != nil {
+		return err
+	}
+
+	ip := audit.ActorFrom(ctx).IPAddress
+	if err := s.throttle.Check(ctx, user.Email, ip); err != nil {
+		return err
+	}
+	err = ErrReauthenticationFailed
+	if auth.CheckPassword(user.Password, password) {
+		err = s.verifySecondFactor(ctx, userID, code)
+	}
+	switch {
+	case errors.Is(err, ErrReauthenticationFailed), errors.Is(err, ErrInvalidMFACode):
+		if err := s.throttle.Fail(ctx, user.Email, &user.ID, ip); err != nil {
+			return err
+		}
+		return ErrReauthenticationFailed
+	case err != nil && !errors.Is(err, ErrMFANotEnabled):
+		return err
+	}
+
+	// The password was right, and err is nil or ErrMFANotEnabled.
+	if err := s.throttle.Succeed(ctx, user.Email); err != nil {
+		return err
+	}
+	return err
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code. Each is accepted only once.
+func (s *AuthService) verifySecondFactor(ctx context.Context, userID uuid.UUID, code string) error {
+	settings, err := s.mfa.Get(ctx, userID)
+	if errors.Is(err, repository.ErrMFANotFound) || (err == nil && !settings.Enabled()) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == mfa.Digits {
+		step, ok := mfa.Validate(settings.TOTPSecret, code, s.now(), settings.LastUsedStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		used, err := s.mfa.UseStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := s.mfa.UseRecoveryCode(ctx, userID, mfa.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// loginFailed records a failed login and returns ErrInvalidCredentials.
+func (s *AuthService) loginFailed(ctx context.Context, email string, userID *uuid.UUID, ip string) error {
+	if err := s.throttle.Fail(ctx, email, userID, ip); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+func (s *AuthService) GetLoginPolicy(ctx context.Context, organizationID uuid.UUID) (*models.LoginPolicy, error) {
+	return s.loginPolicies.GetPolicy(ctx, organizationID)
+}
+
+// SetLoginPolicy changes the login methods the organization allows. Members
+// keep the sessions they opened with other methods.
+func (s *AuthService) SetLoginPolicy(ctx context.Context, policy *models.LoginPolicy) error {
+	var methods []string
+	for _, method := range models.LoginMethods {
+		if slices.Contains(policy.Methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	policy.Methods = methods
+	return s.loginPolicies.SetPolicy(ctx, policy)
+}
+
+// checkLoginMethod returns ErrLoginMethodNotAllowed when the login policy
+// of the organization does not allow the first factor in amr.
+func checkLoginMethod(ctx context.Context, policies repository.LoginPolicyRepository, organizationID uuid.UUID, amr ...string) error {
+	if policies == nil || len(amr) == 0 {
+		return nil
+	}
+	policy, err := policies.GetPolicy(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+	if !policy.Allows(amrLoginMethods[amr[0]]) {
+		return ErrLoginMethodNotAllowed
+	}
+	return nil
+}
+
+// CheckOrganizationPolicy checks a login that was not scoped to an
+// organization when it is used in organizationID, as finishLogin checks
+// logins to the organization: the user must be a member, its login policy
+// must allow the method of the login, and when the organization requires
+// MFA from the user, the login must have used a second factor.
+func (s *AuthService) CheckOrganizationPolicy(ctx context.Context, claims *auth.Claims, organizationID uuid.UUID) error {
+	userID, err := claims.UserID()
+	if err != nil {
+		return fmt.Errorf("%w: invalid subject", auth.ErrNotOrganizationMember)
+	}
+	member, err := isMember(ctx, s.userRoles, userID, organizationID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return auth.ErrNotOrganizationMember
+	}
+
+	err = checkLoginMethod(ctx, s.loginPolicies, organizationID, claims.AMR...)
+	if errors.Is(err, ErrLoginMethodNotAllowed) {
+		return fmt.Errorf("%w: %w", auth.ErrOrganizationPolicy, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(claims.AMR, auth.AMRMFA) {
+		return nil
+	}
+	required, err := s.mfa.IsRequired(ctx, userID, organizationID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("%w: MFA is required", auth.ErrOrganizationPolicy)
+	}
+	return nil
+}
+
+func isMember(ctx context.Context, userRoles repository.UserRoleRepository, userID, organizationID uuid.UUID) (bool, error) {
+	organizations, err := userRoles.ListUserOrganizations(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, organization := range organizations {
+		if organization.ID == organizationID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
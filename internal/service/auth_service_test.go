@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/mfa"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeUserRepository struct {
+	repository.UserRepository
+	users map[uuid.UUID]*models.User
+}
+
+func (f *fakeUserRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	if user, ok := f.users[id]; ok && user.IsActive {
+		return user, nil
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (f *fakeUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+type fakeMembershipRepository struct {
+	repository.UserRoleRepository
+	organizations map[uuid.UUID][]models.Organization
+}
+
+func (f *fakeMembershipRepository) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]models.Organization, error) {
+	return f.organizations[userID], nil
+}
+
+type fakeMFARepository struct {
+	repository.MFARepository
+	settings map[uuid.UUID]*models.UserMFA
+	codes    map[uuid.UUID]map[string]bool
+	required map[uuid.UUID]bool
+}
+
+func (f *fakeMFARepository) Get(ctx context.Context, userID uuid.UUID) (*models.UserMFA, error) {
+	settings, ok := f.settings[userID]
+	if !ok {
+		return nil, repository.ErrMFANotFound
+	}
+	copied := *settings
+	return &copied, nil
+}
+
+func (f *fakeMFARepository) SavePendingSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	if settings, ok := f.settings[userID]; ok && settings.Enabled() {
+		return repository.ErrMFAAlreadyEnabled
+	}
+	f.settings[userID] = &models.UserMFA{UserID: userID, TOTPSecret: secret}
+	return nil
+}
+
+func (f *fakeMFARepository) Enable(ctx context.Context, userID uuid.UUID, step int64, codeHashes []string) error {
+	now := time.Now()
+	f.settings[userID].EnabledAt = &now
+	f.settings[userID].LastUsedStep = step
+	return f.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+}
+
+func (f *fakeMFARepository) UseStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	settings := f.settings[userID]
+	if settings.LastUsedStep >= step {
+		return false, nil
+	}
+	settings.LastUsedStep = step
+	return true, nil
+}
+
+func (f *fakeMFARepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	if !f.codes[userID][codeHash] {
+		return false, nil
+	}
+	delete(f.codes[userID], codeHash)
+	return true, nil
+}
+
+func (f *fakeMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	f.codes[userID] = make(map[string]bool)
+	for _, hash := range codeHashes {
+		f.codes[userID][hash] = true
+	}
+	return nil
+}
+
+func (f *fakeMFARepository) Disable(ctx context.Context, userID uuid.UUID) error {
+	delete(f.settings, userID)
+	delete(f.codes, userID)
+	return nil
+}
+
+func (f *fakeMFARepository) IsRequired(ctx context.Context, userID, organizationID uuid.UUID) (bool, error) {
+	return f.required[organizationID], nil
+}
+
+func TestLoginWithMFAPolicyEnrollmentAndSecondFactor(t *testing.T) {
+	ctx := context.Background()
+	org := uuid.New()
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Email: "admin@example.com", Password: hash, IsActive: true}
+
+	mfaRepo := &fakeMFARepository{
+		settings: make(map[uuid.UUID]*models.UserMFA),
+		codes:    make(map[uuid.UUID]map[string]bool),
+		required: map[uuid.UUID]bool{org: true},
+	}
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	svc := NewAuthService(
+		&fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user}},
+		&fakeMembershipRepository{organizations: map[uuid.UUID][]models.Organization{user.ID: {{ID: org}}}},
+		mfaRepo,
+		nil,
+		tokens,
+		nil,
+		nil,
+	)
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	_, err = svc.Login(ctx, "admin@example.com", "wrong", org)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = svc.Login(ctx, "nobody@example.com", "correct horse", org)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = svc.Login(ctx, "admin@example.com", "correct horse", uuid.New())
+	require.ErrorIs(t, err, ErrNotMember)
+
+	// Without an organization the policy does not apply.
+	result, err := svc.Login(ctx, "Admin@Example.com", "correct horse", uuid.Nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.AccessToken)
+
+	// ...but the login cannot be used in the organization either.
+	claims, err := tokens.ParseAccessToken(result.AccessToken)
+	require.NoError(t, err)
+	require.ErrorIs(t, svc.CheckOrganizationPolicy(ctx, claims, org), auth.ErrOrganizationPolicy)
+	require.ErrorIs(t, svc.CheckOrganizationPolicy(ctx, claims, uuid.New()), auth.ErrNotOrganizationMember,
+		"nor in an organization the user is not a member of")
+
+	// The policy holds the login back until the user enrolls; the
+	// enrollment token is not an access token.
+	result, err = svc.Login(ctx, "admin@example.com", "correct horse", org)
+	require.NoError(t, err)
+	require.True(t, result.MFAEnrollmentRequired)
+	require.Empty(t, result.AccessToken)
+	_, err = tokens.ParseAccessToken(result.MFAToken)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	enrollment, err := svc.BeginTOTPEnrollment(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = svc.ConfirmTOTPEnrollment(ctx, user.ID, "000000")
+	require.ErrorIs(t, err, ErrInvalidMFACode)
+
+	code, err := mfa.Code(enrollment.Secret, mfa.Step(now))
+	require.NoError(t, err)
+	recoveryCodes, err := svc.ConfirmTOTPEnrollment(ctx, user.ID, code)
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, mfa.RecoveryCodeCount)
+
+	_, err = svc.BeginTOTPEnrollment(ctx, user.ID)
+	require.ErrorIs(t, err, repository.ErrMFAAlreadyEnabled)
+
+	// Enrolled users always need the second factor.
+	result, err = svc.Login(ctx, "admin@example.com", "correct horse", org)
+	require.NoError(t, err)
+	require.False(t, result.MFAEnrollmentRequired)
+	require.NotEmpty(t, result.MFAToken)
+
+	// The code that confirmed enrollment cannot be replayed.
+	_, err = svc.VerifyMFA(ctx, result.MFAToken, code)
+	require.ErrorIs(t, err, ErrInvalidMFACode)
+
+	now = now.Add(mfa.Period)
+	code, err = mfa.Code(enrollment.Secret, mfa.Step(now))
+	require.NoError(t, err)
+	verified, err := svc.VerifyMFA(ctx, result.MFAToken, code)
+	require.NoError(t, err)
+	claims, err = tokens.ParseAccessToken(verified.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, org.String(), claims.OrganizationID)
+	require.Contains(t, claims.AMR, auth.AMRMFA)
+	require.NoError(t, svc.CheckOrganizationPolicy(ctx, claims, org))
+
+	// Recovery codes are single-use.
+	_, err = svc.VerifyMFA(ctx, result.MFAToken, recoveryCodes[0])
+	require.NoError(t, err)
+	_, err = svc.VerifyMFA(ctx, result.MFAToken, recoveryCodes[0])
+	require.ErrorIs(t, err, ErrInvalidMFACode)
+
+	// Disabling requires the password and a second factor.
+	require.ErrorIs(t, svc.DisableMFA(ctx, user.ID, "wrong", recoveryCodes[1]), ErrReauthenticationFailed)
+	require.ErrorIs(t, svc.DisableMFA(ctx, user.ID, "correct horse", "000000"), ErrReauthenticationFailed,
+		"a wrong code fails the same as a wrong password")
+	require.NoError(t, svc.DisableMFA(ctx, user.ID, "correct horse", recoveryCodes[1]))
+	status, err := svc.MFAStatus(ctx, user.ID)
+	require.NoError(t, err)
+	require.False(t, status.Enabled)
+}
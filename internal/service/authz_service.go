@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"log"
+	"user-management/internal/repository"
+)
+
+type AuthzCheck struct {
+	UserID         uuid.UUID
+	OrganizationID uuid.UUID
+	Permission     string
+	Resource       string
+}
+
+type AuthzDecision struct {
+	Allowed bool
+	Reason  string
+}
+
+const (
+	ReasonGranted    = "granted"
+	ReasonNotGranted = "not_granted"
+	ReasonError      = "resolution_failed"
+)
+
+type AuthzService struct {
+	userRoles repository.UserRoleRepository
+}
+
+func NewAuthzService(userRoles repository.UserRoleRepository) *AuthzService {
+	return &AuthzService{userRoles: userRoles}
+}
+
+// CheckBatch evaluates every check in order. Permissions are resolved once
+// per distinct user/organization pair, so a batch costs as many queries as
+// it has distinct pairs rather than one per check.
+//
+// When Resource is empty, Permission is matched against permission names.
+// When Resource is set, Permission is treated as an action on that resource.
+func (s *AuthzService) CheckBatch(ctx context.Context, checks []AuthzCheck) []AuthzDecision {
+	type subject struct {
+		userID         uuid.UUID
+		organizationID uuid.UUID
+	}
+
+	sets := make(map[subject]*PermissionSet)
+	failed := make(map[subject]bool)
+	decisions := make([]AuthzDecision, len(checks))
+
+	for i, check := range checks {
+		key := subject{userID: check.UserID, organizationID: check.OrganizationID}
+
+		if failed[key] {
+			decisions[i] = AuthzDecision{Allowed: false, Reason: ReasonError}
+			continue
+		}
+
+		set, ok := sets[key]
+		if !ok {
+			permissions, err := s.userRoles.GetUserPermissions(ctx, check.UserID, check.OrganizationID)
+			if err != nil {
+				log.Printf("failed to resolve permissions for user %s in organization %s: %v\n",
+					check.UserID, check.OrganizationID, err)
+				failed[key] = true
+				decisions[i] = AuthzDecision{Allowed: false, Reason: ReasonError}
+				continue
+			}
+			set = NewPermissionSet(permissions)
+			sets[key] = set
+		}
+
+		decisions[i] = evaluate(set, check)
+	}
+
+	return decisions
+}
+
+func evaluate(set *PermissionSet, check AuthzCheck) AuthzDecision {
+	var allowed bool
+	if check.Resource == "" {
+		allowed = set.Has(check.Permission)
+	} else {
+		allowed = set.Allows(check.Resource, check.Permission)
+	}
+
+	if allowed {
+		return AuthzDecision{Allowed: true, Reason: ReasonGranted}
+	}
+	return AuthzDecision{Allowed: false, Reason: ReasonNotGranted}
+}
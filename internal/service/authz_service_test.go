@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeUserRoleRepository struct {
+	repository.UserRoleRepository
+	permissions map[uuid.UUID][]models.Permission
+	failFor     uuid.UUID
+	calls       int
+}
+
+func (f *fakeUserRoleRepository) GetUserPermissions(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, error) {
+	f.calls++
+	if userID == f.failFor {
+		return nil, errors.New("boom")
+	}
+	return f.permissions[userID], nil
+}
+
+func TestCheckBatchResolvesOncePerSubject(t *testing.T) {
+	alice, bob, org := uuid.New(), uuid.New(), uuid.New()
+	repo := &fakeUserRoleRepository{
+		permissions: map[uuid.UUID][]models.Permission{
+			alice: {
+				{Name: "user:read", Resource: "user", Action: "read"},
+				{Name: "role:create", Resource: "role", Action: "create"},
+			},
+		},
+	}
+	svc := NewAuthzService(repo)
+
+	decisions := svc.CheckBatch(context.Background(), []AuthzCheck{
+		{UserID: alice, OrganizationID: org, Permission: "user:read"},
+		{UserID: alice, OrganizationID: org, Permission: "user:delete"},
+		{UserID: alice, OrganizationID: org, Permission: "create", Resource: "role"},
+		{UserID: bob, OrganizationID: org, Permission: "user:read"},
+	})
+
+	require.Equal(t, 2, repo.calls)
+	require.Equal(t, []AuthzDecision{
+		{Allowed: true, Reason: ReasonGranted},
+		{Allowed: false, Reason: ReasonNotGranted},
+		{Allowed: true, Reason: ReasonGranted},
+		{Allowed: false, Reason: ReasonNotGranted},
+	}, decisions)
+}
+
+func TestCheckBatchReportsResolutionFailure(t *testing.T) {
+	alice, org := uuid.New(), uuid.New()
+	repo := &fakeUserRoleRepository{failFor: alice}
+	svc := NewAuthzService(repo)
+
+	decisions := svc.CheckBatch(context.Background(), []AuthzCheck{
+		{UserID: alice, OrganizationID: org, Permission: "user:read"},
+		{UserID: alice, OrganizationID: org, Permission: "user:update"},
+	})
+
+	require.Equal(t, 1, repo.calls)
+	for _, decision := range decisions {
+		require.False(t, decision.Allowed)
+		require.Equal(t, ReasonError, decision.Reason)
+	}
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/federation"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrFederatedLoginFailed is returned when the provider refuses the
+	// code, its ID token does not verify, or the linked account is
+	// disabled.
+	ErrFederatedLoginFailed = errors.New("login with the identity provider failed")
+	// ErrNoAccount is returned for identities that no account matches, when
+	// the provider does not create accounts.
+	ErrNoAccount = errors.New("no account for this identity")
+	// ErrAccountNotLinked is returned for identities whose email address
+	// has an account that they cannot be linked to automatically. The user
+	// logs in to that account and links the identity.
+	ErrAccountNotLinked = errors.New("an account with this email address exists, log in to link the identity")
+	ErrLastLoginMethod  = errors.New("the only way to log in cannot be removed")
+)
+
+const defaultFederationStateTTL = 10 * time.Minute
+
+// FederationProvider describes an upstream provider to the login page.
+type FederationProvider struct {
+	Name        string
+	DisplayName string
+}
+
+// FederationRedirect starts a login at a provider: the user is sent to URL,
+// and the provider sends them back to the redirect URL with a code and
+// State. The frontend keeps State to check that the user returns from the
+// login it started.
+type FederationRedirect struct {
+	URL   string
+	State string
+}
+
+type federatedProvider struct {
+	*federation.Provider
+	createUsers bool
+	linkByEmail bool
+}
+
+type FederationService struct {
+	providers  []*federatedProvider
+	identities repository.IdentityRepository
+	users      repository.UserRepository
+	passkeys   repository.WebAuthnRepository
+	auth       *AuthService
+	stateTTL   time.Duration
+	now        func() time.Time
+}
+
+// NewFederationService creates the service for the providers of conf.
+// Logins go through auth for membership and MFA like password logins.
+func NewFederationService(conf config.FederationConfig, identities repository.IdentityRepository, users repository.UserRepository, passkeys repository.WebAuthnRepository, authService *AuthService) (*FederationService, error) {
+	stateTTL := conf.StateTTL
+	if stateTTL <= 0 {
+		stateTTL = defaultFederationStateTTL
+	}
+
+	s := &FederationService{
+		identities: identities,
+		users:      users,
+		passkeys:   passkeys,
+		auth:       authService,
+		stateTTL:   stateTTL,
+		now:        time.Now,
+	}
+	for _, providerConf := range conf.Providers {
+		if s.provider(providerConf.Name) != nil {
+			return nil, fmt.Errorf("duplicate provider %s", providerConf.Name)
+		}
+		provider, err := federation.NewProvider(providerConf, conf.RedirectURL)
+		if err != nil {
+			return nil, err
+		}
+		s.providers = append(s.providers, &federatedProvider{
+			Provider:    provider,
+			createUsers: providerConf.CreateUsers,
+			linkByEmail: providerConf.LinkByEmail,
+		})
+	}
+	return s, nil
+}
+
+// Providers lists the configured providers in the order of the
+// configuration.
+func (s *FederationService) Providers() []FederationProvider {
+	providers := make([]FederationProvider, 0, len(s.providers))
+	for _, provider := range s.providers {
+		providers = append(providers, FederationProvider{Name: provider.Name(), DisplayName: provider.DisplayName()})
+	}
+	return providers
+}
+
+// BeginLogin starts a login with a provider, scoped to organizationID when
+// it is set.
+func (s *FederationService) BeginLogin(ctx context.Context, providerName string, organizationID uuid.UUID) (*FederationRedirect, error) {
+	state := &models.FederationState{Purpose: models.FederationPurposeLogin}
+	if organizationID != uuid.Nil {
+		state.OrganizationID = &organizationID
+	}
+	return s.begin(ctx, providerName, state)
+}
+
+// BeginLink starts linking an account at a provider to the logged in user.
+func (s *FederationService) BeginLink(ctx context.Context, providerName string, userID uuid.UUID) (*FederationRedirect, error) {
+	return s.begin(ctx, providerName, &models.FederationState{Purpose: models.FederationPurposeLink, UserID: &userID})
+}
+
+func (s *FederationService) begin(ctx context.Context, providerName string, state *models.FederationState) (*FederationRedirect, error) {
+	provider := s.provider(providerName)
+	if provider == nil {
+		return nil, ErrUnknownProvider
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := federation.NewNonce()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	stateValue, nonce, codeVerifier := values[0], values[1], values[2]
+
+	authorizationURL, err := provider.AuthorizationURL(ctx, stateValue, nonce, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	state.StateHash = hashUserToken(stateValue)
+	state.Provider = provider.Name()
+	state.Nonce = nonce
+	state.CodeVerifier = codeVerifier
+	state.ExpiresAt = s.now().Add(s.stateTTL)
+	if err := s.identities.SaveState(ctx, state); err != nil {
+		return nil, err
+	}
+	return &FederationRedirect{URL: authorizationURL, State: stateValue}, nil
+}
+
+// FinishLogin completes a login with the code and state the provider sent
+// the user back with. The identity logs in to the account it is linked to.
+// An identity that is not linked yet is linked to the account with its
+// email address, when the provider links by email, or gets a new account,
+// when the provider creates users.
+//
+// The login then continues like a password login: the user may still need
+// to be a member of the organization, and to pass their second factor.
+func (s *FederationService) FinishLogin(ctx context.Context, stateValue, code string) (*LoginResult, error) {
+	state, provider, identity, err := s.finish(ctx, stateValue, code, models.FederationPurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.resolveUser(ctx, provider, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := uuid.Nil
+	if state.OrganizationID != nil {
+		organizationID = *state.OrganizationID
+	}
+	return s.auth.finishLogin(ctx, user, organizationID, auth.AMRFederated)
+}
+
+// FinishLink links the identity the user returned with to the logged in
+// user who started linking it.
+func (s *FederationService) FinishLink(ctx context.Context, stateValue, code string, userID uuid.UUID) (*models.Identity, error) {
+	state, provider, identity, err := s.finish(ctx, stateValue, code, models.FederationPurposeLink)
+	if err != nil {
+		return nil, err
+	}
+	if state.UserID == nil || *state.UserID != userID {
+		return nil, repository.ErrFederationStateNotFound
+	}
+
+	linked := &models.Identity{
+		UserID:   userID,
+		Provider: provider.Name(),
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := s.identities.Create(ctx, linked); err != nil {
+		return nil, err
+	}
+	return linked, nil
+}
+
+// Unlink removes an identity of the user, unless it is the only way they
+// can log in: they have neither a password, a passkey nor another
+// identity.
+func (s *FederationService) Unlink(ctx context.Context, userID, id uuid.UUID) error {
+	identities, err := s.identities.ListByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(identities, func(identity models.Identity) bool { return identity.ID == id }) {
+		return repository.ErrIdentityNotFound
+	}
+
+	if len(identities) == 1 {
+		user, err := s.users.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.Password == "" {
+			passkeys, err := s.passkeys.ListCredentials(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if len(passkeys) == 0 {
+				return ErrLastLoginMethod
+			}
+		}
+	}
+
+	return s.identities.Delete(ctx, userID, id)
+}
+
+// finish consumes the state of an authorization request and redeems the
+// code at its provider.
+func (s *FederationService) finish(ctx context.Context, stateValue, code, purpose string) (*models.FederationState, *federatedProvider, *federation.Identity, error) {
+	state, err := s.identities.ConsumeState(ctx, hashUserToken(stateValue), purpose)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	provider := s.provider(state.Provider)
+	if provider == nil {
+		return nil, nil, nil, ErrUnknownProvider
+	}
+
+	identity, err := provider.Exchange(ctx, code, state.CodeVerifier, state.Nonce)
+	var oauthErr *oidc.Error
+	if errors.As(err, &oauthErr) || errors.Is(err, federation.ErrInvalidIDToken) {
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrFederatedLoginFailed, err)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return state, provider, identity, nil
+}
+
+// resolveUser returns the account an identity logs in to, linking or
+// creating it on the identity's first login.
+func (s *FederationService) resolveUser(ctx context.Context, provider *federatedProvider, identity *federation.Identity) (*models.User, error) {
+	linked, err := s.identities.GetBySubject(ctx, provider.Name(), identity.Subject)
+	if err == nil {
+		user, err := s.users.GetByID(ctx, linked.UserID)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrFederatedLoginFailed
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.identities.RecordLogin(ctx, linked.ID, identity.Email); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	// Without an address the provider vouches for, the identity cannot be
+	// matched to an account, nor create one that others could match.
+	if !identity.EmailVerified {
+		return nil, ErrNoAccount
+	}
+
+	now := s.now()
+	newIdentity := &models.Identity{
+		Provider:    provider.Name(),
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: &now,
+	}
+
+	user, err := s.users.GetByEmail(ctx, identity.Email)
+	if err == nil {
+		// An address the account has not verified may have been taken by
+		// someone else, waiting for its owner to link their identity.
+		if !provider.linkByEmail || !user.EmailVerified {
+			return nil, ErrAccountNotLinked
+		}
+		if !user.IsActive {
+			return nil, ErrFederatedLoginFailed
+		}
+		newIdentity.UserID = user.ID
+		if err := s.identities.Create(ctx, newIdentity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+
+	if !provider.createUsers {
+		return nil, ErrNoAccount
+	}
+	user = &models.User{
+		Email:         identity.Email,
+		FirstName:     identity.GivenName,
+		LastName:      identity.FamilyName,
+		EmailVerified: true,
+	}
+	err = s.identities.CreateWithUser(ctx, user, newIdentity)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		return nil, ErrAccountNotLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *FederationService) provider(name string) *federatedProvider {
+	for _, provider := range s.providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+type fakeIdentityRepository struct {
+	repository.IdentityRepository
+	users      *fakeUserRepository
+	identities []*models.Identity
+	states     map[string]*models.FederationState
+}
+
+func (f *fakeIdentityRepository) Create(ctx context.Context, identity *models.Identity) error {
+	for _, existing := range f.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return repository.ErrIdentityExists
+		}
+	}
+	identity.ID = uuid.New()
+	copied := *identity
+	f.identities = append(f.identities, &copied)
+	return nil
+}
+
+func (f *fakeIdentityRepository) CreateWithUser(ctx context.Context, user *models.User, identity *models.Identity) error {
+	if _, err := f.users.GetByEmail(ctx, user.Email); err == nil {
+		return repository.ErrEmailTaken
+	}
+	user.ID = uuid.New()
+	user.IsActive = true
+	f.users.users[user.ID] = user
+	identity.UserID = user.ID
+	return f.Create(ctx, identity)
+}
+
+func (f *fakeIdentityRepository) GetBySubject(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	for _, identity := range f.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			copied := *identity
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrIdentityNotFound
+}
+
+func (f *fakeIdentityRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Identity, error) {
+	var identities []models.Identity
+	for _, identity := range f.identities {
+		if identity.UserID == userID {
+			identities = append(identities, *identity)
+		}
+	}
+	return identities, nil
+}
+
+func (f *fakeIdentityRepository) RecordLogin(ctx context.Context, id uuid.UUID, email string) error {
+	for _, identity := range f.identities {
+		if identity.ID == id {
+			identity.Email = email
+			identity.LastLoginAt = models.TimePtr(time.Now())
+			return nil
+		}
+	}
+	return repository.ErrIdentityNotFound
+}
+
+func (f *fakeIdentityRepository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	for i, identity := range f.identities {
+		if identity.ID == id && identity.UserID == userID {
+			f.identities = slices.Delete(f.identities, i, i+1)
+			return nil
+		}
+	}
+	return repository.ErrIdentityNotFound
+}
+
+func (f *fakeIdentityRepository) SaveState(ctx context.Context, state *models.FederationState) error {
+	f.states[state.StateHash] = state
+	return nil
+}
+
+func (f *fakeIdentityRepository) ConsumeState(ctx context.Context, stateHash, purpose string) (*models.FederationState, error) {
+	state, ok := f.states[stateHash]
+	if !ok || state.Purpose != purpose || state.ExpiresAt.Before(time.Now()) {
+		return nil, repository.ErrFederationStateNotFound
+	}
+	delete(f.states, stateHash)
+	return state, nil
+}
+
+// mockAccount is an account at the mock provider.
+type mockAccount struct {
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+// mockProvider is an OpenID Connect provider that logs in whichever
+// account is set as its user, without asking.
+type mockProvider struct {
+	t        *testing.T
+	server   *httptest.Server
+	key      *oidc.SigningKey
+	clientID string
+	secret   string
+	user     mockAccount
+	// nonce replaces the nonce of the next ID token when set.
+	nonce string
+	codes map[string]mockCode
+}
+
+type mockCode struct {
+	account     mockAccount
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := oidc.GenerateSigningKey(oidc.AlgorithmES256)
+	require.NoError(t, err)
+
+	p := &mockProvider{t: t, key: key, clientID: "client", secret: "s3cret", codes: make(map[string]mockCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidc.DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		issuer := p.server.URL
+		json.NewEncoder(w).Encode(oidc.Discovery{
+			Issuer:                issuer,
+			AuthorizationEndpoint: issuer + oidc.AuthorizePath,
+			TokenEndpoint:         issuer + oidc.TokenPath,
+			JWKSURI:               issuer + oidc.JWKSPath,
+		})
+	})
+	mux.HandleFunc(oidc.JWKSPath, func(w http.ResponseWriter, r *http.Request) {
+		jwk, err := oidc.NewJWK(key.ID, key.Public())
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(oidc.JWKS{Keys: []oidc.JWK{jwk}})
+	})
+	mux.HandleFunc(oidc.AuthorizePath, p.authorize)
+	mux.HandleFunc(oidc.TokenPath, p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	require.Equal(p.t, oidc.ResponseTypeCode, query.Get("response_type"))
+	require.Equal(p.t, p.clientID, query.Get("client_id"))
+	require.Equal(p.t, oidc.CodeChallengeMethodS256, query.Get("code_challenge_method"))
+	require.NotEmpty(p.t, query.Get("nonce"))
+
+	code := uuid.NewString()
+	p.codes[code] = mockCode{
+		account:     p.user,
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	code, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	if clientID != p.clientID || secret != p.secret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(oidc.NewError(oidc.ErrorInvalidClient, ""))
+		return
+	}
+	if !ok || !oidc.VerifyCodeChallenge(r.PostFormValue("code_verifier"), code.challenge) ||
+		r.PostFormValue("redirect_uri") != code.redirectURI {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oidc.NewError(oidc.ErrorInvalidGrant, ""))
+		return
+	}
+
+	nonce := code.nonce
+	if p.nonce != "" {
+		nonce = p.nonce
+	}
+	now := time.Now()
+	verified := code.account.emailVerified
+	idToken, err := p.key.Sign(&oidc.IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.server.URL,
+			Subject:   code.account.subject,
+			Audience:  jwt.ClaimStrings{p.clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		Profile: oidc.Profile{Email: code.account.email, EmailVerified: &verified, GivenName: "Ada", FamilyName: "Lovelace"},
+		Nonce:   nonce,
+	}, "")
+	require.NoError(p.t, err)
+	json.NewEncoder(w).Encode(oidc.TokenResponse{AccessToken: "upstream", TokenType: "Bearer", IDToken: idToken})
+}
+
+// login follows a redirect to the provider as the browser would, and
+// returns the state and code the provider sends the user back with.
+func (p *mockProvider) login(redirect *FederationRedirect) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(redirect.URL)
+	require.NoError(p.t, err)
+	resp.Body.Close()
+	require.Equal(p.t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(p.t, err)
+	require.Equal(p.t, "/login/callback", location.Path)
+	require.Equal(p.t, redirect.State, location.Query().Get("state"))
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestFederatedLoginLinkingAndUnlinking(t *testing.T) {
+	ctx := context.Background()
+	provider := newMockProvider(t)
+
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	existing := &models.User{ID: uuid.New(), Email: "grace@example.com", Password: hash, EmailVerified: true, IsActive: true}
+	unverified := &models.User{ID: uuid.New(), Email: "mallory@example.com", IsActive: true}
+	users := &fakeUserRepository{users: map[uuid.UUID]*models.User{existing.ID: existing, unverified.ID: unverified}}
+	identities := &fakeIdentityRepository{users: users, states: make(map[string]*models.FederationState)}
+
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	authService := NewAuthService(users, &fakeMembershipRepository{}, &fakeMFARepository{settings: map[uuid.UUID]*models.UserMFA{}}, nil, tokens, nil, nil)
+	svc, err := NewFederationService(config.FederationConfig{
+		RedirectURL: "http://localhost:3000/login/callback",
+		Providers: []config.FederationProviderConfig{{
+			Name:         "corp",
+			DisplayName:  "Corp SSO",
+			Issuer:       provider.server.URL,
+			ClientID:     provider.clientID,
+			ClientSecret: provider.secret,
+			CreateUsers:  true,
+			LinkByEmail:  true,
+		}},
+	}, identities, users, &fakeWebAuthnRepository{}, authService)
+	require.NoError(t, err)
+	require.Equal(t, []FederationProvider{{Name: "corp", DisplayName: "Corp SSO"}}, svc.Providers())
+
+	_, err = svc.BeginLogin(ctx, "unknown", uuid.Nil)
+	require.ErrorIs(t, err, ErrUnknownProvider)
+
+	loginAs := func(account mockAccount) (*LoginResult, error) {
+		provider.user = account
+		redirect, err := svc.BeginLogin(ctx, "corp", uuid.Nil)
+		require.NoError(t, err)
+		state, code := provider.login(redirect)
+		return svc.FinishLogin(ctx, state, code)
+	}
+	userOf := func(result *LoginResult) uuid.UUID {
+		claims, err := tokens.ParseAccessToken(result.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, []string{auth.AMRFederated}, claims.AMR)
+		userID, _ := claims.UserID()
+		return userID
+	}
+
+	// The first login of an unknown address creates the user.
+	ada := mockAccount{subject: "ada", email: "Ada@Example.com", emailVerified: true}
+	result, err := loginAs(ada)
+	require.NoError(t, err)
+	adaID := userOf(result)
+	created := users.users[adaID]
+	require.Equal(t, "ada@example.com", created.Email)
+	require.Equal(t, "Ada", created.FirstName)
+	require.True(t, created.EmailVerified)
+	require.Empty(t, created.Password)
+
+	// Later logins find the user by subject, whatever the address.
+	ada.email = "ada@new.example.com"
+	result, err = loginAs(ada)
+	require.NoError(t, err)
+	require.Equal(t, adaID, userOf(result))
+	require.Len(t, users.users, 3)
+
+	// A verified address links the identity to the account that verified
+	// it.
+	result, err = loginAs(mockAccount{subject: "grace", email: "grace@example.com", emailVerified: true})
+	require.NoError(t, err)
+	require.Equal(t, existing.ID, userOf(result))
+
+	// Unverified addresses on either side link nothing.
+	_, err = loginAs(mockAccount{subject: "mallory", email: "mallory@example.com", emailVerified: true})
+	require.ErrorIs(t, err, ErrAccountNotLinked)
+	_, err = loginAs(mockAccount{subject: "eve", email: "eve@example.com"})
+	require.ErrorIs(t, err, ErrNoAccount)
+
+	// A state is good for one callback, and codes are bound to the nonce
+	// and PKCE verifier of their request.
+	provider.user = ada
+	redirect, err := svc.BeginLogin(ctx, "corp", uuid.Nil)
+	require.NoError(t, err)
+	state, code := provider.login(redirect)
+	_, err = svc.FinishLogin(ctx, "made-up", code)
+	require.ErrorIs(t, err, repository.ErrFederationStateNotFound)
+	provider.nonce = "replayed"
+	_, err = svc.FinishLogin(ctx, state, code)
+	require.ErrorIs(t, err, ErrFederatedLoginFailed)
+	provider.nonce = ""
+	_, err = svc.FinishLogin(ctx, state, code)
+	require.ErrorIs(t, err, repository.ErrFederationStateNotFound)
+
+	// Logins scoped to an organization need membership.
+	redirect, err = svc.BeginLogin(ctx, "corp", uuid.New())
+	require.NoError(t, err)
+	state, code = provider.login(redirect)
+	_, err = svc.FinishLogin(ctx, state, code)
+	require.ErrorIs(t, err, ErrNotMember)
+
+	// A logged in user links another account; a link state cannot log in,
+	// nor link to someone else.
+	provider.user = mockAccount{subject: "grace-work", email: "grace@work.example.com"}
+	redirect, err = svc.BeginLink(ctx, "corp", existing.ID)
+	require.NoError(t, err)
+	state, code = provider.login(redirect)
+	_, err = svc.FinishLogin(ctx, state, code)
+	require.ErrorIs(t, err, repository.ErrFederationStateNotFound)
+	_, err = svc.FinishLink(ctx, state, code, adaID)
+	require.ErrorIs(t, err, repository.ErrFederationStateNotFound)
+
+	redirect, err = svc.BeginLink(ctx, "corp", existing.ID)
+	require.NoError(t, err)
+	state, code = provider.login(redirect)
+	linked, err := svc.FinishLink(ctx, state, code, existing.ID)
+	require.NoError(t, err)
+	require.Equal(t, "grace-work", linked.Subject)
+
+	provider.user = ada
+	redirect, err = svc.BeginLink(ctx, "corp", existing.ID)
+	require.NoError(t, err)
+	state, code = provider.login(redirect)
+	_, err = svc.FinishLink(ctx, state, code, existing.ID)
+	require.ErrorIs(t, err, repository.ErrIdentityExists)
+
+	// The identity of a user without a password or passkey is their only
+	// way to log in.
+	adaIdentities, err := identities.ListByUser(ctx, adaID)
+	require.NoError(t, err)
+	require.Len(t, adaIdentities, 1)
+	require.ErrorIs(t, svc.Unlink(ctx, adaID, adaIdentities[0].ID), ErrLastLoginMethod)
+	require.ErrorIs(t, svc.Unlink(ctx, existing.ID, adaIdentities[0].ID), repository.ErrIdentityNotFound)
+
+	graceIdentities, err := identities.ListByUser(ctx, existing.ID)
+	require.NoError(t, err)
+	require.Len(t, graceIdentities, 2)
+	for _, identity := range graceIdentities {
+		require.NoError(t, svc.Unlink(ctx, existing.ID, identity.ID))
+	}
+}
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"strings"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+// PermissionRevokeTokens lets a service account revoke any token of its
+// organization, not only its own.
+const PermissionRevokeTokens = "tokens:revoke"
+
+// TokenClient is an authenticated caller of the introspection and
+// revocation endpoints: a service account or an OAuth client.
+type TokenClient struct {
+	ServiceAccount *models.ServiceAccount
+	OAuthClient    *models.OAuthClient
+}
+
+func (c *TokenClient) organizationID() uuid.UUID {
+	if c.ServiceAccount != nil {
+		return c.ServiceAccount.OrganizationID
+	}
+	return c.OAuthClient.OrganizationID
+}
+
+// IntrospectionService answers introspection (RFC 7662) and revocation
+// (RFC 7009) requests for every kind of token: the access tokens of logins,
+// service accounts and OAuth clients, refresh tokens and API keys. Callers
+// only see the tokens of their own organization.
+type IntrospectionService struct {
+	tokens          *auth.TokenManager
+	sessions        *SessionService
+	apiKeys         *APIKeyService
+	serviceAccounts *ServiceAccountService
+	oauth           *OAuthService
+	userRoles       repository.UserRoleRepository
+	revoked         repository.RevokedTokenRepository
+}
+
+// NewIntrospectionService returns the service. oauth may be nil when the
+// OpenID Connect provider is disabled, in which case only service accounts
+// can call it.
+func NewIntrospectionService(tokens *auth.TokenManager, sessions *SessionService, apiKeys *APIKeyService, serviceAccounts *ServiceAccountService, oauth *OAuthService, userRoles repository.UserRoleRepository, revoked repository.RevokedTokenRepository) *IntrospectionService {
+	return &IntrospectionService{
+		tokens:          tokens,
+		sessions:        sessions,
+		apiKeys:         apiKeys,
+		serviceAccounts: serviceAccounts,
+		oauth:           oauth,
+		userRoles:       userRoles,
+		revoked:         revoked,
+	}
+}
+
+// AuthenticateClient checks the credentials of a service account or, when
+// the provider is enabled, an OAuth client.
+func (s *IntrospectionService) AuthenticateClient(ctx context.Context, clientID, secret string) (*TokenClient, error) {
+	if strings.HasPrefix(clientID, serviceAccountClientIDPrefix) {
+		account, err := s.serviceAccounts.Authenticate(ctx, clientID, secret)
+		if err != nil {
+			return nil, err
+		}
+		return &TokenClient{ServiceAccount: account}, nil
+	}
+
+	if s.oauth == nil {
+		return nil, oidc.NewError(oidc.ErrorInvalidClient, "client authentication failed")
+	}
+	client, err := s.oauth.AuthenticateClient(ctx, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenClient{OAuthClient: client}, nil
+}
+
+// introspectedToken is a valid token and what it takes to revoke it.
+type introspectedToken struct {
+	oidc.Introspection
+	// organizationID is uuid.Nil for login tokens that are not scoped to
+	// an organization.
+	organizationID uuid.UUID
+	subjectID      uuid.UUID
+	// clientID is the OAuth client the token was issued to, if any.
+	clientID uuid.UUID
+	revoke   func(ctx context.Context) error
+}
+
+// Introspect describes a token to a confidential client. Tokens that are
+// invalid, expired, revoked or of another organization are inactive.
+func (s *IntrospectionService) Introspect(ctx context.Context, client *TokenClient, token string) (*oidc.Introspection, error) {
+	if client.OAuthClient != nil && client.OAuthClient.Public {
+		return nil, oidc.NewError(oidc.ErrorUnauthorizedClient, "public clients may not introspect tokens")
+	}
+
+	info, err := s.resolve(ctx, token)
+	if err != nil || info == nil {
+		return &oidc.Introspection{}, err
+	}
+	visible, err := s.visible(ctx, client, info)
+	if err != nil || !visible {
+		return &oidc.Introspection{}, err
+	}
+
+	info.Active = true
+	return &info.Introspection, nil
+}
+
+// Revoke revokes a token. OAuth clients may revoke the tokens issued to
+// them, and service accounts their own tokens or, with the tokens:revoke
+// permission, any token of their organization. Invalid tokens and tokens of
+// other organizations are ignored, as RFC 7009 requires.
+//
+// Access tokens go on the revocation list until they expire. Refresh tokens
+// end their session, which also ends its access tokens, and API keys are
+// deleted.
+func (s *IntrospectionService) Revoke(ctx context.Context, client *TokenClient, token string) error {
+	info, err := s.resolve(ctx, token)
+	if err != nil || info == nil {
+		return err
+	}
+	visible, err := s.visible(ctx, client, info)
+	if err != nil || !visible {
+		return err
+	}
+
+	allowed, err := s.mayRevoke(ctx, client, info)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return oidc.NewError(oidc.ErrorUnauthorizedClient, "the client may not revoke this token")
+	}
+	return info.revoke(ctx)
+}
+
+func (s *IntrospectionService) mayRevoke(ctx context.Context, client *TokenClient, info *introspectedToken) (bool, error) {
+	if client.OAuthClient != nil {
+		return info.clientID == client.OAuthClient.ID, nil
+	}
+	if info.subjectID == client.ServiceAccount.ID {
+		return true, nil
+	}
+	return s.userRoles.HasPermission(ctx, client.ServiceAccount.ID, client.ServiceAccount.OrganizationID, PermissionRevokeTokens)
+}
+
+// visible reports whether the token belongs to the client's organization.
+// Tokens that are not scoped to one belong to the organizations their
+// subject is a member of.
+func (s *IntrospectionService) visible(ctx context.Context, client *TokenClient, info *introspectedToken) (bool, error) {
+	if info.organizationID != uuid.Nil {
+		return info.organizationID == client.organizationID(), nil
+	}
+	return isMember(ctx, s.userRoles, info.subjectID, client.organizationID())
+}
+
+// resolve recognizes a token by its format and returns it, or nil when it
+// is not valid.
+func (s *IntrospectionService) resolve(ctx context.Context, token string) (*introspectedToken, error) {
+	switch {
+	case auth.IsAPIKey(token):
+		return s.resolveAPIKey(ctx, token)
+	case strings.Count(token, ".") == 1:
+		return s.resolveRefreshToken(ctx, token)
+	default:
+		return s.resolveAccessToken(ctx, token)
+	}
+}
+
+func (s *IntrospectionService) resolveAPIKey(ctx context.Context, token string) (*introspectedToken, error) {
+	key, err := s.apiKeys.find(ctx, token)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &introspectedToken{
+		Introspection: oidc.Introspection{
+			Scope:          strings.Join(key.Scopes, " "),
+			TokenType:      "Bearer",
+			IssuedAt:       key.CreatedAt.Unix(),
+			Subject:        key.UserID.String(),
+			TokenID:        key.ID.String(),
+			OrganizationID: key.OrganizationID.String(),
+			Principal:      auth.PrincipalAPIKey,
+		},
+		organizationID: key.OrganizationID,
+		subjectID:      key.UserID,
+		revoke: func(ctx context.Context) error {
+			err := s.apiKeys.Revoke(ctx, key.UserID, key.ID)
+			if errors.Is(err, repository.ErrAPIKeyNotFound) {
+				return nil
+			}
+			return err
+		},
+	}
+	if key.ExpiresAt != nil {
+		info.ExpiresAt = key.ExpiresAt.Unix()
+	}
+	return info, nil
+}
+
+func (s *IntrospectionService) resolveRefreshToken(ctx context.Context, token string) (*introspectedToken, error) {
+	session, err := s.sessions.Lookup(ctx, token)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &introspectedToken{
+		Introspection: oidc.Introspection{
+			Scope:     oidc.FormatScope(session.Scopes),
+			ExpiresAt: session.ExpiresAt.Unix(),
+			IssuedAt:  session.CreatedAt.Unix(),
+			Subject:   session.UserID.String(),
+			Issuer:    s.tokens.Issuer(),
+			SessionID: session.ID.String(),
+		},
+		subjectID: session.UserID,
+		revoke: func(ctx context.Context) error {
+			err := s.sessions.Revoke(ctx, session.UserID, session.ID)
+			if errors.Is(err, repository.ErrSessionNotFound) {
+				return nil
+			}
+			return err
+		},
+	}
+	if session.OrganizationID != nil {
+		info.organizationID = *session.OrganizationID
+		info.OrganizationID = session.OrganizationID.String()
+	}
+	if session.ClientID != nil {
+		info.clientID = *session.ClientID
+		info.ClientID = session.ClientID.String()
+		info.Issuer = s.oauthIssuer()
+	}
+	return info, nil
+}
+
+func (s *IntrospectionService) resolveAccessToken(ctx context.Context, token string) (*introspectedToken, error) {
+	// The caller is a resource server, not the holder of the token, so its
+	// address is not recorded as that of the token's session.
+	ctx = audit.WithActor(ctx, audit.Actor{})
+
+	claims, err := s.tokens.ValidateAccessToken(ctx, token)
+	if err == nil {
+		return s.loginOrServiceAccountToken(ctx, claims)
+	}
+	if !errors.Is(err, auth.ErrInvalidToken) {
+		return nil, err
+	}
+	if s.oauth == nil {
+		return nil, nil
+	}
+
+	clientClaims, err := s.oauth.ParseAccessToken(ctx, token)
+	var oauthErr *oidc.Error
+	if errors.As(err, &oauthErr) && oauthErr.Code == oidc.ErrorInvalidToken {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.clientToken(clientClaims)
+}
+
+func (s *IntrospectionService) loginOrServiceAccountToken(ctx context.Context, claims *auth.Claims) (*introspectedToken, error) {
+	subjectID, _ := claims.UserID()
+	info := &introspectedToken{
+		Introspection: newAccessTokenIntrospection(claims.RegisteredClaims, claims.Scope, claims.OrganizationID, claims.SessionID),
+		subjectID:     subjectID,
+	}
+	info.Principal = claims.Principal
+	info.Username = claims.Email
+	info.organizationID, _ = uuid.Parse(claims.OrganizationID)
+
+	if claims.Principal == auth.PrincipalServiceAccount {
+		account, err := s.serviceAccounts.Get(ctx, info.organizationID, subjectID)
+		if errors.Is(err, repository.ErrServiceAccountNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !account.IsActive {
+			return nil, nil
+		}
+		info.ClientID = account.ClientID
+	}
+
+	info.revoke = s.revokeAccessToken(claims.RegisteredClaims, subjectID, info.organizationID)
+	return info, nil
+}
+
+func (s *IntrospectionService) clientToken(claims *oidc.AccessTokenClaims) (*introspectedToken, error) {
+	subjectID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, nil
+	}
+	info := &introspectedToken{
+		Introspection: newAccessTokenIntrospection(claims.RegisteredClaims, claims.Scope, claims.OrganizationID, claims.SessionID),
+		subjectID:     subjectID,
+	}
+	info.ClientID = claims.ClientID
+	info.clientID, _ = uuid.Parse(claims.ClientID)
+	info.organizationID, _ = uuid.Parse(claims.OrganizationID)
+	info.revoke = s.revokeAccessToken(claims.RegisteredClaims, subjectID, info.organizationID)
+	return info, nil
+}
+
+// revokeAccessToken returns a function that puts an access token on the
+// revocation list until it expires.
+func (s *IntrospectionService) revokeAccessToken(claims jwt.RegisteredClaims, subjectID, organizationID uuid.UUID) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		id, err := uuid.Parse(claims.ID)
+		if err != nil || claims.ExpiresAt == nil {
+			return nil
+		}
+		revoked := &models.RevokedToken{
+			ID:        id,
+			SubjectID: subjectID,
+			ExpiresAt: claims.ExpiresAt.Time,
+		}
+		if organizationID != uuid.Nil {
+			revoked.OrganizationID = &organizationID
+		}
+		return s.revoked.Revoke(ctx, revoked)
+	}
+}
+
+func (s *IntrospectionService) oauthIssuer() string {
+	if s.oauth == nil {
+		return ""
+	}
+	return s.oauth.Issuer()
+}
+
+func newAccessTokenIntrospection(claims jwt.RegisteredClaims, scope, organizationID, sessionID string) oidc.Introspection {
+	info := oidc.Introspection{
+		Scope:          scope,
+		TokenType:      "Bearer",
+		Subject:        claims.Subject,
+		Audience:       claims.Audience,
+		Issuer:         claims.Issuer,
+		TokenID:        claims.ID,
+		OrganizationID: organizationID,
+		SessionID:      sessionID,
+	}
+	if claims.ExpiresAt != nil {
+		info.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = claims.IssuedAt.Unix()
+	}
+	return info
+}
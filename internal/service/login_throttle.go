@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+	"user-management/internal/config"
+	"user-management/internal/repository"
+)
+
+var ErrLoginThrottled = errors.New("too many failed login attempts")
+
+const (
+	defaultLoginWindow        = 15 * time.Minute
+	defaultLoginBaseDelay     = time.Second
+	defaultLoginMaxDelay      = 30 * time.Second
+	defaultLockoutDuration    = 15 * time.Minute
+	defaultMaxLockoutDuration = 24 * time.Hour
+)
+
+// LoginThrottledError rejects a login attempt made before RetryAfter has
+// passed. Locked is set when the account or address is locked out rather
+// than only delayed. It matches ErrLoginThrottled.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("login locked out for %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("login delayed for %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginThrottledError) Is(target error) bool {
+	return target == ErrLoginThrottled
+}
+
+// LoginThrottle slows down and locks out repeated failed logins of an
+// account and of a client address. A nil LoginThrottle allows everything.
+type LoginThrottle struct {
+	attempts repository.LoginAttemptRepository
+	conf     config.LoginProtectionConfig
+	now      func() time.Time
+}
+
+// NewLoginThrottle returns nil when login protection is disabled.
+func NewLoginThrottle(conf config.LoginProtectionConfig, attempts repository.LoginAttemptRepository) *LoginThrottle {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Window <= 0 {
+		conf.Window = defaultLoginWindow
+	}
+	if conf.BaseDelay <= 0 {
+		conf.BaseDelay = defaultLoginBaseDelay
+	}
+	if conf.MaxDelay <= 0 {
+		conf.MaxDelay = defaultLoginMaxDelay
+	}
+	if conf.LockoutDuration <= 0 {
+		conf.LockoutDuration = defaultLockoutDuration
+	}
+	if conf.MaxLockoutDuration <= 0 {
+		conf.MaxLockoutDuration = defaultMaxLockoutDuration
+	}
+
+	return &LoginThrottle{attempts: attempts, conf: conf, now: time.Now}
+}
+
+// Check returns a *LoginThrottledError when email or ip is locked out or
+// has to wait before its next attempt. ip may be empty.
+func (t *LoginThrottle) Check(ctx context.Context, email, ip string) error {
+	if t == nil {
+		return nil
+	}
+
+	attempts, err := t.attempts.List(ctx, loginAttemptKeys(email, ip))
+	if err != nil {
+		return err
+	}
+
+	now := t.now()
+	var throttled *LoginThrottledError
+	for _, attempt := range attempts {
+		var retryAfter time.Duration
+		locked := attempt.Locked(now)
+		if locked {
+			retryAfter = attempt.LockedUntil.Sub(now)
+		} else if t.conf.DelayAfter > 0 && attempt.Failures >= t.conf.DelayAfter {
+			retryAfter = attempt.LastFailedAt.Add(t.delay(attempt.Failures)).Sub(now)
+		}
+
+		if retryAfter > 0 && (throttled == nil || retryAfter > throttled.RetryAfter) {
+			throttled = &LoginThrottledError{RetryAfter: retryAfter, Locked: locked}
+		}
+	}
+
+	if throttled != nil {
+		return throttled
+	}
+	return nil
+}
+
+// Fail records a failed login of email from ip and locks either out once it
+// reaches its threshold. userID is the account of email, if there is one.
+func (t *LoginThrottle) Fail(ctx context.Context, email string, userID *uuid.UUID, ip string) error {
+	if t == nil {
+		return nil
+	}
+
+	if err := t.fail(ctx, emailAttemptKey(email), userID, t.conf.AccountLockoutThreshold); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return t.fail(ctx, ipAttemptKey(ip), nil, t.conf.IPLockoutThreshold)
+}
+
+// Succeed forgets the failures of email after a complete login. Those of
+// the client address are kept, so that an attacker cannot reset them by
+// logging into an account of their own in between guesses.
+func (t *LoginThrottle) Succeed(ctx context.Context, email string) error {
+	if t == nil {
+		return nil
+	}
+	return t.attempts.Reset(ctx, emailAttemptKey(email))
+}
+
+// Unlock lifts the lockout of a user's account.
+func (t *LoginThrottle) Unlock(ctx context.Context, userID uuid.UUID) error {
+	if t == nil {
+		return repository.ErrUserNotLocked
+	}
+	return t.attempts.Unlock(ctx, userID)
+}
+
+func (t *LoginThrottle) fail(ctx context.Context, key string, userID *uuid.UUID, threshold int) error {
+	now := t.now()
+	attempt, err := t.attempts.RecordFailure(ctx, key, userID, now.Add(-t.conf.Window), now.Add(-t.conf.MaxLockoutDuration))
+	if err != nil {
+		return err
+	}
+	if threshold <= 0 || attempt.Failures < threshold {
+		return nil
+	}
+
+	_, err = t.attempts.Lock(ctx, key, now.Add(t.lockoutDuration(attempt.Lockouts)))
+	return err
+}
+
+// delay is the wait after the given number of failures: BaseDelay at
+// DelayAfter failures, doubling with each one after, up to MaxDelay.
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	return doubled(t.conf.BaseDelay, failures-t.conf.DelayAfter, t.conf.MaxDelay)
+}
+
+// lockoutDuration doubles with each lockout that preceded this one.
+func (t *LoginThrottle) lockoutDuration(lockouts int) time.Duration {
+	return doubled(t.conf.LockoutDuration, lockouts, t.conf.MaxLockoutDuration)
+}
+
+func doubled(base time.Duration, times int, limit time.Duration) time.Duration {
+	d := base
+	for i := 0; i < times && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+func loginAttemptKeys(email, ip string) []string {
+	keys := []string{emailAttemptKey(email)}
+	if ip != "" {
+		keys = append(keys, ipAttemptKey(ip))
+	}
+	return keys
+}
+
+// emailAttemptKey hashes the address, since users now and then type their
+// password into the email field.
+func emailAttemptKey(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "email:" + hex.EncodeToString(sum[:])
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeLoginAttemptRepository struct {
+	repository.LoginAttemptRepository
+	attempts map[string]*models.LoginAttempt
+	now      func() time.Time
+}
+
+func (f *fakeLoginAttemptRepository) List(ctx context.Context, keys []string) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	for _, key := range keys {
+		if attempt, ok := f.attempts[key]; ok {
+			attempts = append(attempts, *attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (f *fakeLoginAttemptRepository) RecordFailure(ctx context.Context, key string, userID *uuid.UUID, failuresSince, lockoutsSince time.Time) (*models.LoginAttempt, error) {
+	attempt, ok := f.attempts[key]
+	if !ok {
+		attempt = &models.LoginAttempt{Key: key}
+		f.attempts[key] = attempt
+	}
+	if userID != nil {
+		attempt.UserID = userID
+	}
+	if attempt.LastFailedAt.Before(failuresSince) {
+		attempt.Failures = 0
+	}
+	if attempt.LastFailedAt.Before(lockoutsSince) {
+		attempt.Lockouts = 0
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = f.now()
+	copied := *attempt
+	return &copied, nil
+}
+
+func (f *fakeLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) (*models.LoginAttempt, error) {
+	attempt := f.attempts[key]
+	attempt.Failures = 0
+	attempt.Lockouts++
+	attempt.LockedUntil = &until
+	copied := *attempt
+	return &copied, nil
+}
+
+func (f *fakeLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	delete(f.attempts, key)
+	return nil
+}
+
+func (f *fakeLoginAttemptRepository) Unlock(ctx context.Context, userID uuid.UUID) error {
+	for key, attempt := range f.attempts {
+		if attempt.UserID != nil && *attempt.UserID == userID {
+			delete(f.attempts, key)
+			return nil
+		}
+	}
+	return repository.ErrUserNotLocked
+}
+
+func TestLoginThrottleDelaysAndLocksOut(t *testing.T) {
+	org := uuid.New()
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", Password: hash, IsActive: true}
+
+	now := time.Now()
+	attempts := &fakeLoginAttemptRepository{
+		attempts: make(map[string]*models.LoginAttempt),
+		now:      func() time.Time { return now },
+	}
+	throttle := NewLoginThrottle(config.LoginProtectionConfig{
+		Enabled:                 true,
+		Window:                  time.Hour,
+		DelayAfter:              2,
+		BaseDelay:               time.Second,
+		MaxDelay:                4 * time.Second,
+		AccountLockoutThreshold: 5,
+		IPLockoutThreshold:      8,
+		LockoutDuration:         time.Minute,
+		MaxLockoutDuration:      3 * time.Minute,
+	}, attempts)
+	throttle.now = func() time.Time { return now }
+	svc := NewAuthService(
+		&fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user}},
+		&fakeMembershipRepository{organizations: map[uuid.UUID][]models.Organization{user.ID: {{ID: org}}}},
+		&fakeMFARepository{settings: make(map[uuid.UUID]*models.UserMFA)},
+		nil,
+		auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute}),
+		throttle,
+		nil,
+	)
+	ctx := audit.WithActor(context.Background(), audit.Actor{IPAddress: "192.0.2.1"})
+	login := func(password string) (*LoginResult, error) {
+		return svc.Login(ctx, "Ada@Example.com", password, uuid.Nil)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := login("wrong")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+
+	// From the second failure on each attempt waits, twice as long as the
+	// one before, even with the right password.
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		_, err := login("correct horse")
+		var throttled *LoginThrottledError
+		require.ErrorAs(t, err, &throttled)
+		require.ErrorIs(t, err, ErrLoginThrottled)
+		require.False(t, throttled.Locked)
+		require.Equal(t, delay, throttled.RetryAfter)
+
+		now = now.Add(delay)
+		_, err = login("wrong")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+
+	// The fifth failure locked the account.
+	_, err = login("correct horse")
+	var throttled *LoginThrottledError
+	require.ErrorAs(t, err, &throttled)
+	require.True(t, throttled.Locked)
+	require.Equal(t, time.Minute, throttled.RetryAfter)
+
+	_, err = svc.Login(context.Background(), "ada@example.com", "correct horse", uuid.Nil)
+	require.ErrorIs(t, err, ErrLoginThrottled, "account lockouts apply from any address")
+
+	require.ErrorIs(t, svc.UnlockUser(ctx, uuid.New(), user.ID), repository.ErrUserNotFound,
+		"only members of the organization can be unlocked")
+	require.NoError(t, svc.UnlockUser(ctx, org, user.ID))
+	require.ErrorIs(t, svc.UnlockUser(ctx, org, user.ID), repository.ErrUserNotLocked)
+
+	now = now.Add(4 * time.Second)
+	result, err := login("correct horse")
+	require.NoError(t, err)
+	require.NotEmpty(t, result.AccessToken)
+
+	// The address has kept its failures and is locked out at eight,
+	// unknown accounts included.
+	for i := 0; i < 3; i++ {
+		_, err = svc.Login(ctx, "nobody@example.com", "guess", uuid.Nil)
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+		now = now.Add(4 * time.Second)
+	}
+	_, err = login("correct horse")
+	require.ErrorAs(t, err, &throttled)
+	require.True(t, throttled.Locked)
+	require.Equal(t, attempts.attempts["ip:192.0.2.1"].LockedUntil.Sub(now), throttled.RetryAfter)
+}
+
+func TestReauthenticationIsThrottled(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", Password: hash, IsActive: true}
+	now := time.Now()
+	throttle := NewLoginThrottle(config.LoginProtectionConfig{
+		Enabled:                 true,
+		AccountLockoutThreshold: 2,
+		IPLockoutThreshold:      10,
+		LockoutDuration:         time.Minute,
+		MaxLockoutDuration:      time.Minute,
+	}, &fakeLoginAttemptRepository{
+		attempts: make(map[string]*models.LoginAttempt),
+		now:      func() time.Time { return now },
+	})
+	throttle.now = func() time.Time { return now }
+	svc := NewAuthService(
+		&fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user}},
+		&fakeMembershipRepository{},
+		&fakeMFARepository{settings: make(map[uuid.UUID]*models.UserMFA)},
+		nil,
+		auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute}),
+		throttle,
+		nil,
+	)
+	ctx := audit.WithActor(context.Background(), audit.Actor{IPAddress: "192.0.2.1"})
+
+	require.ErrorIs(t, svc.reauthenticate(ctx, user.ID, "correct horse", ""), ErrMFANotEnabled)
+	for range 2 {
+		require.ErrorIs(t, svc.reauthenticate(ctx, user.ID, "wrong", ""), ErrReauthenticationFailed)
+	}
+	require.ErrorIs(t, svc.reauthenticate(ctx, user.ID, "correct horse", ""), ErrLoginThrottled,
+		"failures lock the account out like failed logins")
+	_, err = svc.Login(ctx, "ada@example.com", "correct horse", uuid.Nil)
+	require.ErrorIs(t, err, ErrLoginThrottled)
+}
+
+func TestLoginThrottleLockoutsGrow(t *testing.T) {
+	now := time.Now()
+	attempts := &fakeLoginAttemptRepository{
+		attempts: make(map[string]*models.LoginAttempt),
+		now:      func() time.Time { return now },
+	}
+	throttle := NewLoginThrottle(config.LoginProtectionConfig{
+		Enabled:                 true,
+		AccountLockoutThreshold: 1,
+		LockoutDuration:         time.Minute,
+		MaxLockoutDuration:      3 * time.Minute,
+	}, attempts)
+	throttle.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, duration := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		require.NoError(t, throttle.Fail(ctx, "ada@example.com", nil, ""))
+		var throttled *LoginThrottledError
+		require.ErrorAs(t, throttle.Check(ctx, "ada@example.com", ""), &throttled)
+		require.Equal(t, duration, throttled.RetryAfter)
+		now = now.Add(duration)
+	}
+
+	now = now.Add(4 * time.Minute)
+	require.NoError(t, throttle.Fail(ctx, "ada@example.com", nil, ""))
+	var throttled *LoginThrottledError
+	require.ErrorAs(t, throttle.Check(ctx, "ada@example.com", ""), &throttled)
+	require.Equal(t, time.Minute, throttled.RetryAfter, "lockouts are forgotten after the longest one")
+
+	var disabled *LoginThrottle
+	require.NoError(t, disabled.Check(ctx, "ada@example.com", ""))
+	require.Nil(t, NewLoginThrottle(config.LoginProtectionConfig{}, attempts))
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"net/url"
+	"slices"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+// DeviceAuthorization is a pending device authorization request, as shown
+// to the user who entered its user code.
+type DeviceAuthorization struct {
+	Client *models.OAuthClient
+	Scopes []string
+}
+
+// AuthorizeDevice starts the device grant of RFC 8628 for a client: it
+// returns the device code the client polls the token endpoint with, and the
+// user code and verification URI to show to the user.
+func (s *OAuthService) AuthorizeDevice(ctx context.Context, client *models.OAuthClient, scope string) (*oidc.DeviceAuthorizationResponse, error) {
+	scopes := oidc.ParseScope(scope)
+	if !oidc.Subset(scopes, client.Scopes) {
+		return nil, oidc.NewError(oidc.ErrorInvalidScope, "scope not allowed for this client")
+	}
+
+	deviceCode, err := newUserToken()
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := oidc.NewUserCode()
+	if err != nil {
+		return nil, err
+	}
+	normalized, _ := oidc.NormalizeUserCode(userCode)
+
+	err = s.oauth.CreateDeviceCode(ctx, &models.OAuthDeviceCode{
+		DeviceCodeHash: hashUserToken(deviceCode),
+		UserCodeHash:   hashUserToken(normalized),
+		ClientID:       client.ID,
+		Scopes:         scopes,
+		Interval:       s.pollInterval,
+		ExpiresAt:      s.now().Add(s.deviceCodeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	verificationURI := s.issuer + oidc.DevicePath
+	return &oidc.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int(s.deviceCodeTTL.Seconds()),
+		Interval:                int(s.pollInterval.Seconds()),
+	}, nil
+}
+
+// DeviceRedirect returns the verification page URL for a visit of the
+// verification URI, passing on the user code it may carry.
+func (s *OAuthService) DeviceRedirect(userCode string) string {
+	page, err := url.Parse(s.deviceURL)
+	if err != nil || userCode == "" {
+		return s.deviceURL
+	}
+	query := page.Query()
+	query.Set("user_code", userCode)
+	page.RawQuery = query.Encode()
+	return page.String()
+}
+
+// CheckDevice returns the pending device authorization request of a user
+// code for the logged in user to approve or deny. Unknown, expired and
+// decided codes are reported as repository.ErrDeviceCodeNotFound.
+func (s *OAuthService) CheckDevice(ctx context.Context, userCode string, userID uuid.UUID) (*DeviceAuthorization, error) {
+	code, client, err := s.pendingDeviceCode(ctx, userCode, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceAuthorization{Client: client, Scopes: code.Scopes}, nil
+}
+
+// DecideDevice records the logged in user's answer to a device
+// authorization request. Approving it also records the user's consent to
+// the client, and the device gets tokens that act for the user.
+func (s *OAuthService) DecideDevice(ctx context.Context, userCode string, userID uuid.UUID, amr []string, approved bool) error {
+	code, client, err := s.pendingDeviceCode(ctx, userCode, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.oauth.DecideDeviceCode(ctx, code.UserCodeHash, userID, amr, approved); err != nil {
+		return err
+	}
+	if !approved {
+		return nil
+	}
+	return s.oauth.SaveConsent(ctx, &models.OAuthConsent{UserID: userID, ClientID: client.ID, Scopes: code.Scopes})
+}
+
+func (s *OAuthService) pendingDeviceCode(ctx context.Context, userCode string, userID uuid.UUID) (*models.OAuthDeviceCode, *models.OAuthClient, error) {
+	normalized, ok := oidc.NormalizeUserCode(userCode)
+	if !ok {
+		return nil, nil, repository.ErrDeviceCodeNotFound
+	}
+	code, err := s.oauth.GetDeviceCode(ctx, hashUserToken(normalized))
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := s.oauth.GetClient(ctx, code.ClientID)
+	if errors.Is(err, repository.ErrOAuthClientNotFound) {
+		return nil, nil, repository.ErrDeviceCodeNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkUser(ctx, client, userID); err != nil {
+		return nil, nil, err
+	}
+	return code, client, nil
+}
+
+// DeviceToken answers a token request of the device grant. Until the user
+// decides, it fails with authorization_pending, or slow_down when the
+// client polls more often than the interval, which then grows.
+//
+// The device gets access tokens of the API, like a login of the approving
+// user in the client's organization, so that they carry the user's roles
+// there. With offline_access it also gets a refresh token.
+func (s *OAuthService) DeviceToken(ctx context.Context, client *models.OAuthClient, deviceCode string) (*oidc.TokenResponse, error) {
+	invalid := oidc.NewError(oidc.ErrorInvalidGrant, "invalid device code")
+
+	now := s.now()
+	hash := hashUserToken(deviceCode)
+	code, err := s.oauth.PollDeviceCode(ctx, hash, now)
+	if errors.Is(err, repository.ErrDeviceCodeNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code.ClientID != client.ID {
+		return nil, invalid
+	}
+	if !now.Before(code.ExpiresAt) {
+		return nil, oidc.NewError(oidc.ErrorExpiredToken, "the device code has expired")
+	}
+
+	if code.LastPolledAt != nil && now.Sub(*code.LastPolledAt) < code.Interval {
+		if err := s.oauth.SlowDownDeviceCode(ctx, hash, code.Interval+oidc.DeviceSlowDown); err != nil {
+			return nil, err
+		}
+		return nil, oidc.NewError(oidc.ErrorSlowDown, "")
+	}
+
+	switch code.Status {
+	case models.DeviceCodeStatusPending:
+		return nil, oidc.NewError(oidc.ErrorAuthorizationPending, "")
+	case models.DeviceCodeStatusDenied:
+		return nil, oidc.NewError(oidc.ErrorAccessDenied, "the user denied the request")
+	case models.DeviceCodeStatusApproved:
+	default:
+		return nil, invalid
+	}
+
+	// A concurrent request got the tokens first.
+	err = s.oauth.UseDeviceCode(ctx, hash)
+	if errors.Is(err, repository.ErrDeviceCodeNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.authorizedUser(ctx, client, *code.UserID)
+	if errors.Is(err, ErrNotMember) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID := uuid.Nil
+	var refreshToken string
+	if slices.Contains(code.Scopes, oidc.ScopeOfflineAccess) {
+		sessionID = uuid.New()
+		refreshToken, err = s.sessions.StartDevice(ctx, sessionID, user, client, code.Scopes, code.AMR...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.issueDeviceTokens(ctx, client, user, code.Scopes, code.AMR, sessionID, refreshToken)
+}
+
+// issueDeviceTokens issues the tokens of a device grant: an access token of
+// the API for the user in the client's organization and, for the openid
+// scope, an ID token. sessionID is uuid.Nil for grants without a refresh
+// token.
+func (s *OAuthService) issueDeviceTokens(ctx context.Context, client *models.OAuthClient, user *models.User, scopes, amr []string, sessionID uuid.UUID, refreshToken string) (*oidc.TokenResponse, error) {
+	tokens := s.sessions.tokens
+	accessToken, err := tokens.GenerateClientAccessToken(user, client, sessionID, amr...)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &oidc.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokens.AccessTokenTTL().Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        oidc.FormatScope(scopes),
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		return resp, nil
+	}
+
+	key, err := s.keys.SigningKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var sid string
+	if sessionID != uuid.Nil {
+		sid = sessionID.String()
+	}
+	if err := s.addIDToken(resp, key, client, user, scopes, amr, sid, ""); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,605 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"log"
+	"net/url"
+	"slices"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+var (
+	// ErrInvalidClient is returned for authorization requests with an
+	// unknown client or an unregistered redirect URI. Unlike other errors
+	// of the authorization endpoint, it cannot be reported to the client
+	// through the redirect URI.
+	ErrInvalidClient = errors.New("unknown client or unregistered redirect URI")
+	ErrPublicClient  = errors.New("public clients have no secret")
+)
+
+const (
+	defaultAuthorizationCodeTTL = time.Minute
+	defaultOAuthAccessTokenTTL  = 15 * time.Minute
+	defaultIDTokenTTL           = time.Hour
+	defaultDeviceCodeTTL        = 10 * time.Minute
+	defaultDevicePollInterval   = 5 * time.Second
+
+	clientSecretPrefix = "ocs_"
+)
+
+// AuthorizationDecision is the outcome of an authorization request of a
+// logged in user: the redirect back to the client, or the client and
+// scopes the user has to consent to first.
+type AuthorizationDecision struct {
+	RedirectURI     string
+	ConsentRequired bool
+	Client          *models.OAuthClient
+	Scopes          []string
+}
+
+// OAuthService is the OpenID Connect provider. Users log in to clients
+// with the authorization code flow and PKCE; clients get ID tokens and
+// access tokens signed with the keys of SigningKeyService, and refresh
+// tokens held in sessions when they are granted offline_access. Devices
+// without a browser use the device grant instead.
+type OAuthService struct {
+	oauth          repository.OAuthRepository
+	users          repository.UserRepository
+	userRoles      repository.UserRoleRepository
+	sessions       *SessionService
+	keys           *SigningKeyService
+	issuer         string
+	loginURL       string
+	deviceURL      string
+	codeTTL        time.Duration
+	deviceCodeTTL  time.Duration
+	pollInterval   time.Duration
+	accessTokenTTL time.Duration
+	idTokenTTL     time.Duration
+	now            func() time.Time
+}
+
+func NewOAuthService(conf config.OIDCConfig, oauth repository.OAuthRepository, users repository.UserRepository, userRoles repository.UserRoleRepository, sessions *SessionService, keys *SigningKeyService) *OAuthService {
+	codeTTL := conf.AuthorizationCodeTTL
+	if codeTTL <= 0 {
+		codeTTL = defaultAuthorizationCodeTTL
+	}
+	accessTokenTTL := conf.AccessTokenTTL
+	if accessTokenTTL <= 0 {
+		accessTokenTTL = defaultOAuthAccessTokenTTL
+	}
+	idTokenTTL := conf.IDTokenTTL
+	if idTokenTTL <= 0 {
+		idTokenTTL = defaultIDTokenTTL
+	}
+	deviceCodeTTL := conf.DeviceCodeTTL
+	if deviceCodeTTL <= 0 {
+		deviceCodeTTL = defaultDeviceCodeTTL
+	}
+	pollInterval := conf.DevicePollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultDevicePollInterval
+	}
+	deviceURL := conf.DeviceVerificationURL
+	if deviceURL == "" {
+		deviceURL = conf.LoginURL
+	}
+
+	return &OAuthService{
+		oauth:          oauth,
+		users:          users,
+		userRoles:      userRoles,
+		sessions:       sessions,
+		keys:           keys,
+		issuer:         conf.Issuer,
+		loginURL:       conf.LoginURL,
+		deviceURL:      deviceURL,
+		codeTTL:        codeTTL,
+		deviceCodeTTL:  deviceCodeTTL,
+		pollInterval:   pollInterval,
+		accessTokenTTL: accessTokenTTL,
+		idTokenTTL:     idTokenTTL,
+		now:            time.Now,
+	}
+}
+
+func (s *OAuthService) Issuer() string {
+	return s.issuer
+}
+
+// LoginRedirect returns the login page URL for an authorization request.
+func (s *OAuthService) LoginRedirect(req *oidc.AuthorizationRequest) string {
+	login, err := url.Parse(s.loginURL)
+	if err != nil {
+		return s.loginURL
+	}
+	query := login.Query()
+	for name, values := range req.Values() {
+		query[name] = values
+	}
+	login.RawQuery = query.Encode()
+	return login.String()
+}
+
+// CheckAuthorization validates an authorization request and returns the
+// client and the requested scopes. Errors other than ErrInvalidClient are
+// *oidc.Error values to report through the redirect URI.
+func (s *OAuthService) CheckAuthorization(ctx context.Context, req *oidc.AuthorizationRequest) (*models.OAuthClient, []string, error) {
+	clientID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		return nil, nil, ErrInvalidClient
+	}
+	client, err := s.oauth.GetClient(ctx, clientID)
+	if errors.Is(err, repository.ErrOAuthClientNotFound) {
+		return nil, nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, nil, ErrInvalidClient
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, nil, err
+	}
+	scopes := oidc.ParseScope(req.Scope)
+	if !oidc.Subset(scopes, client.Scopes) {
+		return nil, nil, oidc.NewError(oidc.ErrorInvalidScope, "scope not allowed for this client")
+	}
+	return client, scopes, nil
+}
+
+// Authorize decides an authorization request of a logged in user, who
+// authenticated with amr. Clients the user has already consented to, for
+// the requested scopes, get a code right away.
+func (s *OAuthService) Authorize(ctx context.Context, req *oidc.AuthorizationRequest, userID uuid.UUID, amr []string) (*AuthorizationDecision, error) {
+	client, scopes, err := s.CheckAuthorization(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkUser(ctx, client, userID); err != nil {
+		return nil, err
+	}
+
+	consented := client.SkipConsent
+	if !consented && !req.HasPrompt(oidc.PromptConsent) {
+		consent, err := s.oauth.GetConsent(ctx, userID, client.ID)
+		if err != nil && !errors.Is(err, repository.ErrOAuthConsentNotFound) {
+			return nil, err
+		}
+		consented = err == nil && oidc.Subset(scopes, consent.Scopes)
+	}
+	if consented {
+		return s.issueCode(ctx, client, req, userID, scopes, amr)
+	}
+
+	if req.HasPrompt(oidc.PromptNone) {
+		return nil, oidc.NewError(oidc.ErrorConsentRequired, "")
+	}
+	return &AuthorizationDecision{ConsentRequired: true, Client: client, Scopes: scopes}, nil
+}
+
+// Consent records the user's answer to the consent prompt of an
+// authorization request, and returns the redirect to the client.
+func (s *OAuthService) Consent(ctx context.Context, req *oidc.AuthorizationRequest, userID uuid.UUID, amr []string, approved bool) (*AuthorizationDecision, error) {
+	client, scopes, err := s.CheckAuthorization(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkUser(ctx, client, userID); err != nil {
+		return nil, err
+	}
+	if !approved {
+		return nil, oidc.NewError(oidc.ErrorAccessDenied, "the user denied the request")
+	}
+
+	consent := &models.OAuthConsent{UserID: userID, ClientID: client.ID, Scopes: scopes}
+	if err := s.oauth.SaveConsent(ctx, consent); err != nil {
+		return nil, err
+	}
+	return s.issueCode(ctx, client, req, userID, scopes, amr)
+}
+
+func (s *OAuthService) issueCode(ctx context.Context, client *models.OAuthClient, req *oidc.AuthorizationRequest, userID uuid.UUID, scopes, amr []string) (*AuthorizationDecision, error) {
+	code, err := newUserToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.oauth.CreateAuthorizationCode(ctx, &models.OAuthAuthorizationCode{
+		CodeHash:      hashUserToken(code),
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AMR:           amr,
+		ExpiresAt:     s.now().Add(s.codeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthorizationDecision{RedirectURI: req.Redirect(url.Values{"code": {code}})}, nil
+}
+
+// checkUser rejects users who are inactive or not members of the client's
+// organization.
+func (s *OAuthService) checkUser(ctx context.Context, client *models.OAuthClient, userID uuid.UUID) error {
+	_, err := s.authorizedUser(ctx, client, userID)
+	if errors.Is(err, ErrNotMember) {
+		return oidc.NewError(oidc.ErrorAccessDenied, "the user may not use this client")
+	}
+	return err
+}
+
+func (s *OAuthService) authorizedUser(ctx context.Context, client *models.OAuthClient, userID uuid.UUID) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := isMember(ctx, s.userRoles, userID, client.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, ErrNotMember
+	}
+	return user, nil
+}
+
+// AuthenticateClient checks the credentials of a client at the token
+// endpoint. Public clients present no secret.
+func (s *OAuthService) AuthenticateClient(ctx context.Context, clientID, secret string) (*models.OAuthClient, error) {
+	invalid := oidc.NewError(oidc.ErrorInvalidClient, "client authentication failed")
+
+	id, err := uuid.Parse(clientID)
+	if err != nil {
+		return nil, invalid
+	}
+	client, err := s.oauth.GetClient(ctx, id)
+	if errors.Is(err, repository.ErrOAuthClientNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public {
+		if secret != "" {
+			return nil, invalid
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashUserToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalid
+	}
+	return client, nil
+}
+
+// Exchange redeems an authorization code. A code presented twice also
+// revokes the session opened by its first exchange.
+func (s *OAuthService) Exchange(ctx context.Context, client *models.OAuthClient, code, redirectURI, verifier string) (*oidc.TokenResponse, error) {
+	invalid := oidc.NewError(oidc.ErrorInvalidGrant, "invalid authorization code")
+
+	sessionID := uuid.New()
+	stored, err := s.oauth.ConsumeAuthorizationCode(ctx, hashUserToken(code), sessionID)
+	if errors.Is(err, repository.ErrAuthorizationCodeReused) {
+		log.Printf("authorization code reused by client %s\n", stored.ClientID)
+		if stored.SessionID != nil {
+			err := s.sessions.Revoke(ctx, stored.UserID, *stored.SessionID)
+			if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+				return nil, err
+			}
+		}
+		return nil, invalid
+	}
+	if errors.Is(err, repository.ErrAuthorizationCodeInvalid) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.ClientID != client.ID || stored.RedirectURI != redirectURI {
+		return nil, invalid
+	}
+	if !oidc.VerifyCodeChallenge(verifier, stored.CodeChallenge) {
+		return nil, oidc.NewError(oidc.ErrorInvalidGrant, "code_verifier does not match the code challenge")
+	}
+
+	user, err := s.authorizedUser(ctx, client, stored.UserID)
+	if errors.Is(err, ErrNotMember) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var refreshToken string
+	if slices.Contains(stored.Scopes, oidc.ScopeOfflineAccess) {
+		refreshToken, err = s.sessions.StartClient(ctx, sessionID, user, client, stored.Scopes, stored.AMR...)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sessionID = uuid.Nil
+	}
+
+	return s.issueTokens(ctx, client, user, stored.Scopes, stored.AMR, sessionID, stored.Nonce, refreshToken)
+}
+
+// Refresh exchanges a refresh token of the client. scope may narrow the
+// scopes of the new access token; the refresh token keeps the original
+// grant. Sessions of the device grant refresh to access tokens of the API.
+func (s *OAuthService) Refresh(ctx context.Context, client *models.OAuthClient, refreshToken, scope string) (*oidc.TokenResponse, error) {
+	session, user, newToken, err := s.sessions.Rotate(ctx, refreshToken, client.ID)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil, oidc.NewError(oidc.ErrorInvalidGrant, "invalid refresh token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := session.Scopes
+	if scope != "" {
+		scopes = oidc.ParseScope(scope)
+		if !oidc.Subset(scopes, session.Scopes) {
+			return nil, oidc.NewError(oidc.ErrorInvalidScope, "scope exceeds the original grant")
+		}
+	}
+
+	if session.GrantType == oidc.GrantTypeDeviceCode {
+		return s.issueDeviceTokens(ctx, client, user, scopes, session.AMR, session.ID, newToken)
+	}
+	return s.issueTokens(ctx, client, user, scopes, session.AMR, session.ID, "", newToken)
+}
+
+// issueTokens signs the access token and, for the openid scope, the ID
+// token of a grant. sessionID is uuid.Nil for grants without a refresh
+// token.
+func (s *OAuthService) issueTokens(ctx context.Context, client *models.OAuthClient, user *models.User, scopes, amr []string, sessionID uuid.UUID, nonce, refreshToken string) (*oidc.TokenResponse, error) {
+	key, err := s.keys.SigningKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	var sid string
+	if sessionID != uuid.Nil {
+		sid = sessionID.String()
+	}
+
+	accessToken, err := key.Sign(oidc.AccessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    s.issuer,
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{s.issuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTokenTTL)),
+		},
+		ClientID:       client.ID.String(),
+		Scope:          oidc.FormatScope(scopes),
+		OrganizationID: client.OrganizationID.String(),
+		SessionID:      sid,
+	}, oidc.TokenTypeAccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &oidc.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        oidc.FormatScope(scopes),
+	}
+	if err := s.addIDToken(resp, key, client, user, scopes, amr, sid, nonce); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// addIDToken signs the ID token of a grant with the openid scope into resp.
+func (s *OAuthService) addIDToken(resp *oidc.TokenResponse, key *oidc.SigningKey, client *models.OAuthClient, user *models.User, scopes, amr []string, sid, nonce string) error {
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		return nil
+	}
+
+	now := s.now()
+	var err error
+	resp.IDToken, err = key.Sign(oidc.IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{client.ID.String()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.idTokenTTL)),
+		},
+		Profile:         oidc.NewProfile(user, scopes),
+		Nonce:           nonce,
+		AuthorizedParty: client.ID.String(),
+		AMR:             amr,
+		SessionID:       sid,
+		OrganizationID:  client.OrganizationID.String(),
+	}, "")
+	return err
+}
+
+// ParseAccessToken verifies an access token issued to a client, that it
+// has not been revoked and, when it has a session, that the session is
+// still active.
+func (s *OAuthService) ParseAccessToken(ctx context.Context, token string) (*oidc.AccessTokenClaims, error) {
+	invalid := oidc.NewError(oidc.ErrorInvalidToken, "invalid access token")
+
+	claims := &oidc.AccessTokenClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		keyID, _ := t.Header["kid"].(string)
+		return s.keys.PublicKey(ctx, keyID)
+	},
+		jwt.WithValidMethods(oidc.SigningAlgorithms),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if errors.Is(err, jwt.ErrTokenUnverifiable) && !errors.Is(err, ErrSigningKeyNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, invalid
+	}
+	if typ, _ := parsed.Header["typ"].(string); typ != oidc.TokenTypeAccessToken {
+		return nil, invalid
+	}
+
+	err = s.sessions.tokens.CheckRevoked(ctx, claims.ID)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.SessionID != "" {
+		err := s.sessions.ValidateSession(ctx, &auth.Claims{RegisteredClaims: claims.RegisteredClaims, SessionID: claims.SessionID})
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, invalid
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// UserInfo returns the claims about the user of an access token that its
+// scopes grant.
+func (s *OAuthService) UserInfo(ctx context.Context, accessToken string) (*oidc.UserInfo, error) {
+	claims, err := s.ParseAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	scopes := oidc.ParseScope(claims.Scope)
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		return nil, oidc.NewError(oidc.ErrorInsufficientScope, "the openid scope is required")
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, oidc.NewError(oidc.ErrorInvalidToken, "invalid access token")
+	}
+	user, err := s.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, oidc.NewError(oidc.ErrorInvalidToken, "invalid access token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidc.UserInfo{Subject: user.ID.String(), Profile: oidc.NewProfile(user, scopes)}, nil
+}
+
+// CreateClient registers a client and returns its secret, which is only
+// shown once, or "" for a public client.
+func (s *OAuthService) CreateClient(ctx context.Context, client *models.OAuthClient, public bool) (string, error) {
+	var secret string
+	if !public {
+		var err error
+		secret, client.SecretHash, err = newClientSecret()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.oauth.CreateClient(ctx, client); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetClient returns a client of the organization. Clients of other
+// organizations are reported as missing.
+func (s *OAuthService) GetClient(ctx context.Context, organizationID, id uuid.UUID) (*models.OAuthClient, error) {
+	client, err := s.oauth.GetClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if client.OrganizationID != organizationID {
+		return nil, repository.ErrOAuthClientNotFound
+	}
+	return client, nil
+}
+
+func (s *OAuthService) ListClients(ctx context.Context, organizationID uuid.UUID) ([]models.OAuthClient, error) {
+	return s.oauth.ListClients(ctx, organizationID)
+}
+
+func (s *OAuthService) UpdateClient(ctx context.Context, client *models.OAuthClient) error {
+	return s.oauth.UpdateClient(ctx, client)
+}
+
+// RotateClientSecret replaces the secret of a confidential client and
+// returns the new one. The previous secret stops working at once.
+func (s *OAuthService) RotateClientSecret(ctx context.Context, client *models.OAuthClient) (string, error) {
+	if client.Public {
+		return "", ErrPublicClient
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return "", err
+	}
+	client.SecretHash = hash
+	if err := s.oauth.UpdateClient(ctx, client); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// DeleteClient removes a client after ending the sessions that hold its
+// refresh tokens.
+func (s *OAuthService) DeleteClient(ctx context.Context, client *models.OAuthClient) error {
+	if _, err := s.sessions.RevokeClient(ctx, client.ID, uuid.Nil); err != nil {
+		return err
+	}
+	return s.oauth.DeleteClient(ctx, client.ID)
+}
+
+func (s *OAuthService) ListConsents(ctx context.Context, userID uuid.UUID) ([]models.OAuthConsent, error) {
+	return s.oauth.ListConsents(ctx, userID)
+}
+
+// RevokeConsent withdraws the user's consent to a client and ends the
+// user's sessions of it, so that its refresh tokens stop working.
+func (s *OAuthService) RevokeConsent(ctx context.Context, userID, clientID uuid.UUID) error {
+	if err := s.oauth.DeleteConsent(ctx, userID, clientID); err != nil {
+		return err
+	}
+	_, err := s.sessions.RevokeClient(ctx, clientID, userID)
+	return err
+}
+
+// newClientSecret returns a client secret and its hash.
+func newClientSecret() (string, string, error) {
+	token, err := newUserToken()
+	if err != nil {
+		return "", "", err
+	}
+	secret := clientSecretPrefix + token
+	return secret, hashUserToken(secret), nil
+}
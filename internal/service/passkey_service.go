@@ -0,0 +1,432 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+// ErrInvalidPasskey is returned when a registration or assertion response
+// does not verify, or names an unknown passkey or user.
+var ErrInvalidPasskey = errors.New("passkey verification failed")
+
+const (
+	defaultPasskeyTimeout = 5 * time.Minute
+	defaultPasskeyName    = "Passkey"
+)
+
+// PasskeyRegistration is the first half of a registration ceremony: the
+// options for navigator.credentials.create() and the session to finish it
+// with.
+type PasskeyRegistration struct {
+	SessionID uuid.UUID
+	Options   *protocol.CredentialCreation
+}
+
+// PasskeyReauthentication proves that the user is present before a passkey
+// is added to their account: either an assertion of a passkey they already
+// have, from a ceremony started with BeginLogin, or their password with a
+// TOTP or recovery code. Users without either second factor confirm with
+// their password alone.
+type PasskeyReauthentication struct {
+	Password   string
+	Code       string
+	SessionID  uuid.UUID
+	Credential []byte
+}
+
+// PasskeyAssertion is the first half of a passkey login: the options for
+// navigator.credentials.get() and the session to finish it with.
+type PasskeyAssertion struct {
+	SessionID uuid.UUID
+	Options   *protocol.CredentialAssertion
+}
+
+// PasskeyService registers passkeys and signs users in with them. Passkeys
+// are discoverable credentials that require user verification, so a passkey
+// login counts as multi-factor and skips the TOTP step.
+type PasskeyService struct {
+	webauthn      *webauthn.WebAuthn
+	users         repository.UserRepository
+	userRoles     repository.UserRoleRepository
+	passkeys      repository.WebAuthnRepository
+	loginPolicies repository.LoginPolicyRepository
+	tokens        *auth.TokenManager
+	sessions      *SessionService
+	auth          *AuthService
+	timeout       time.Duration
+	now           func() time.Time
+}
+
+// NewPasskeyService creates the service. loginPolicies may be nil to allow
+// passkey logins to every organization, and sessions nil to issue access
+// tokens without sessions. authService checks the passwords and second
+// factors that registrations are confirmed with.
+func NewPasskeyService(conf config.WebAuthnConfig, users repository.UserRepository, userRoles repository.UserRoleRepository, passkeys repository.WebAuthnRepository, loginPolicies repository.LoginPolicyRepository, tokens *auth.TokenManager, sessions *SessionService, authService *AuthService) (*PasskeyService, error) {
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultPasskeyTimeout
+	}
+
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          conf.RPID,
+		RPDisplayName: conf.RPDisplayName,
+		RPOrigins:     conf.RPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebAuthn config: %w", err)
+	}
+
+	return &PasskeyService{
+		webauthn:      relyingParty,
+		users:         users,
+		userRoles:     userRoles,
+		passkeys:      passkeys,
+		loginPolicies: loginPolicies,
+		tokens:        tokens,
+		sessions:      sessions,
+		auth:          authService,
+		timeout:       timeout,
+		now:           time.Now,
+	}, nil
+}
+
+// BeginRegistration starts registering a passkey for the user once proof
+// shows they are present, so that a stolen access token cannot add one.
+// Passkeys the user already has are excluded so that an authenticator is
+// not registered twice.
+func (s *PasskeyService) BeginRegistration(ctx context.Context, userID uuid.UUID, proof PasskeyReauthentication) (*PasskeyRegistration, error) {
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reauthenticate(ctx, user, proof); err != nil {
+		return nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	options, session, err := s.webauthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey registration: %w", err)
+	}
+
+	sessionID, err := s.saveSession(ctx, &userID, models.WebAuthnCeremonyRegistration, session)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyRegistration{SessionID: sessionID, Options: options}, nil
+}
+
+// FinishRegistration verifies the attestation response of the
+// authenticator and stores the passkey under name.
+func (s *PasskeyService) FinishRegistration(ctx context.Context, userID, sessionID uuid.UUID, name string, response []byte) (*models.WebAuthnCredential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	session, err := s.consumeSession(ctx, sessionID, models.WebAuthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == nil || *session.UserID != userID {
+		return nil, repository.ErrWebAuthnSessionNotFound
+	}
+
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.webauthn.CreateCredential(user, session.data, parsed)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	passkey := &models.WebAuthnCredential{
+		UserID:            userID,
+		CredentialID:      credential.ID,
+		PublicKey:         credential.PublicKey,
+		AttestationType:   credential.AttestationType,
+		AttestationFormat: credential.AttestationFormat,
+		Transports:        transports,
+		AAGUID:            credential.Authenticator.AAGUID,
+		SignCount:         credential.Authenticator.SignCount,
+		BackupEligible:    credential.Flags.BackupEligible,
+		BackupState:       credential.Flags.BackupState,
+		Name:              name,
+	}
+	if err := s.passkeys.CreateCredential(ctx, passkey); err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+// BeginLogin starts a passkey login. The user is not named: the
+// authenticator offers the passkeys it holds for this relying party.
+func (s *PasskeyService) BeginLogin(ctx context.Context) (*PasskeyAssertion, error) {
+	options, session, err := s.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	sessionID, err := s.saveSession(ctx, nil, models.WebAuthnCeremonyLogin, session)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyAssertion{SessionID: sessionID, Options: options}, nil
+}
+
+// FinishLogin verifies the assertion response and issues an access token,
+// scoped to organizationID when it is set.
+func (s *PasskeyService) FinishLogin(ctx context.Context, sessionID uuid.UUID, response []byte, organizationID uuid.UUID) (*LoginResult, error) {
+	user, err := s.verifyAssertion(ctx, sessionID, response)
+	if err != nil {
+		return nil, err
+	}
+
+	if organizationID != uuid.Nil {
+		member, err := isMember(ctx, s.userRoles, user.user.ID, organizationID)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			return nil, ErrNotMember
+		}
+		if err := checkLoginMethod(ctx, s.loginPolicies, organizationID, auth.AMRHardwareKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return startSession(ctx, s.sessions, s.tokens, user.user, organizationID, auth.AMRHardwareKey, auth.AMRMFA)
+}
+
+// reauthenticate checks the proof of presence of user.
+func (s *PasskeyService) reauthenticate(ctx context.Context, user *passkeyUser, proof PasskeyReauthentication) error {
+	if len(proof.Credential) > 0 {
+		asserted, err := s.verifyAssertion(ctx, proof.SessionID, proof.Credential)
+		if err != nil {
+			return err
+		}
+		if asserted.user.ID != user.user.ID {
+			return ErrInvalidPasskey
+		}
+		return nil
+	}
+
+	err := s.auth.reauthenticate(ctx, user.user.ID, proof.Password, proof.Code)
+	if errors.Is(err, ErrMFANotEnabled) && len(user.credentials) == 0 {
+		return nil
+	}
+	return err
+}
+
+// verifyAssertion verifies the assertion response of a login ceremony and
+// returns the user of the passkey. A passkey whose signature counter went
+// backwards is flagged as possibly cloned and rejected.
+func (s *PasskeyService) verifyAssertion(ctx context.Context, sessionID uuid.UUID, response []byte) (*passkeyUser, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	session, err := s.consumeSession(ctx, sessionID, models.WebAuthnCeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lookup failures other than an unknown user surface as they are,
+	// rather than as a failed verification.
+	var lookupErr error
+	discovered, credential, err := s.webauthn.ValidatePasskeyLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, ErrInvalidPasskey
+		}
+		user, err := s.loadUser(ctx, userID)
+		if err != nil && !errors.Is(err, ErrInvalidPasskey) {
+			lookupErr = err
+		}
+		return user, err
+	}, session.data, parsed)
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+	user := discovered.(*passkeyUser)
+
+	passkey := user.credential(credential.ID)
+	if passkey == nil {
+		return nil, ErrInvalidPasskey
+	}
+	passkey.SignCount = credential.Authenticator.SignCount
+	passkey.CloneWarning = passkey.CloneWarning || credential.Authenticator.CloneWarning
+	passkey.BackupState = credential.Flags.BackupState
+	if err := s.passkeys.UpdateCredentialUse(ctx, passkey); err != nil {
+		return nil, err
+	}
+	if credential.Authenticator.CloneWarning {
+		return nil, ErrInvalidPasskey
+	}
+	return user, nil
+}
+
+// loadUser returns an active user with their passkeys. Unknown and inactive
+// users are reported as ErrInvalidPasskey.
+func (s *PasskeyService) loadUser(ctx context.Context, userID uuid.UUID) (*passkeyUser, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidPasskey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrInvalidPasskey
+	}
+
+	credentials, err := s.passkeys.ListCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+// ceremonySession is a stored session with its decoded WebAuthn state.
+type ceremonySession struct {
+	*models.WebAuthnSession
+	data webauthn.SessionData
+}
+
+func (s *PasskeyService) saveSession(ctx context.Context, userID *uuid.UUID, ceremony string, data *webauthn.SessionData) (uuid.UUID, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to encode WebAuthn session: %w", err)
+	}
+
+	session := &models.WebAuthnSession{
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Data:      encoded,
+		ExpiresAt: s.now().Add(s.timeout),
+	}
+	if err := s.passkeys.SaveSession(ctx, session); err != nil {
+		return uuid.Nil, err
+	}
+	return session.ID, nil
+}
+
+func (s *PasskeyService) consumeSession(ctx context.Context, sessionID uuid.UUID, ceremony string) (*ceremonySession, error) {
+	session, err := s.passkeys.ConsumeSession(ctx, sessionID, ceremony)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ceremonySession{WebAuthnSession: session}
+	if err := json.Unmarshal(session.Data, &result.data); err != nil {
+		return nil, fmt.Errorf("failed to decode WebAuthn session: %w", err)
+	}
+	return result, nil
+}
+
+// passkeyUser adapts a user and their passkeys to webauthn.User. The user
+// handle is the 16 bytes of the user ID.
+type passkeyUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	id := u.user.ID
+	return id[:]
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	name := strings.TrimSpace(u.user.FirstName + " " + u.user.LastName)
+	if name == "" {
+		return u.user.Email
+	}
+	return name
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, passkey := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(passkey.Transports))
+		for _, transport := range passkey.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:                passkey.CredentialID,
+			PublicKey:         passkey.PublicKey,
+			AttestationType:   passkey.AttestationType,
+			AttestationFormat: passkey.AttestationFormat,
+			Transport:         transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				UserVerified:   true,
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       passkey.AAGUID,
+				SignCount:    passkey.SignCount,
+				CloneWarning: passkey.CloneWarning,
+			},
+		})
+	}
+	return credentials
+}
+
+func (u *passkeyUser) credential(credentialID []byte) *models.WebAuthnCredential {
+	for i := range u.credentials {
+		if string(u.credentials[i].CredentialID) == string(credentialID) {
+			return &u.credentials[i]
+		}
+	}
+	return nil
+}
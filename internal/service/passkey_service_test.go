@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeWebAuthnRepository struct {
+	repository.WebAuthnRepository
+	credentials []*models.WebAuthnCredential
+	sessions    map[uuid.UUID]*models.WebAuthnSession
+}
+
+func (f *fakeWebAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	for _, existing := range f.credentials {
+		if string(existing.CredentialID) == string(credential.CredentialID) {
+			return repository.ErrPasskeyExists
+		}
+	}
+	credential.ID = uuid.New()
+	copied := *credential
+	f.credentials = append(f.credentials, &copied)
+	return nil
+}
+
+func (f *fakeWebAuthnRepository) ListCredentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	for _, credential := range f.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, *credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (f *fakeWebAuthnRepository) UpdateCredentialUse(ctx context.Context, credential *models.WebAuthnCredential) error {
+	for _, existing := range f.credentials {
+		if existing.ID == credential.ID {
+			existing.SignCount = credential.SignCount
+			existing.CloneWarning = credential.CloneWarning
+			existing.BackupState = credential.BackupState
+			return nil
+		}
+	}
+	return repository.ErrPasskeyNotFound
+}
+
+func (f *fakeWebAuthnRepository) SaveSession(ctx context.Context, session *models.WebAuthnSession) error {
+	session.ID = uuid.New()
+	f.sessions[session.ID] = session
+	return nil
+}
+
+func (f *fakeWebAuthnRepository) ConsumeSession(ctx context.Context, id uuid.UUID, ceremony string) (*models.WebAuthnSession, error) {
+	session, ok := f.sessions[id]
+	if !ok || session.Ceremony != ceremony || session.ExpiresAt.Before(time.Now()) {
+		return nil, repository.ErrWebAuthnSessionNotFound
+	}
+	delete(f.sessions, id)
+	return session, nil
+}
+
+// softAuthenticator is a software platform authenticator holding a single
+// ES256 passkey. It answers the options of the relying party the way a
+// browser and authenticator would, with "none" attestation.
+type softAuthenticator struct {
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, origin string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credentialID := make([]byte, 32)
+	_, err = rand.Read(credentialID)
+	require.NoError(t, err)
+	return &softAuthenticator{origin: origin, key: key, credentialID: credentialID}
+}
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+func (a *softAuthenticator) authenticatorData(rpID string, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	require.NoError(t, err)
+	return data
+}
+
+// create answers navigator.credentials.create().
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	point, err := a.key.PublicKey.ECDH()
+	require.NoError(t, err)
+	uncompressed := point.Bytes()
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: uncompressed[1:33],
+		-3: uncompressed[33:],
+	})
+	require.NoError(t, err)
+
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+	authData := a.authenticatorData(options.Response.RelyingParty.ID, flagUserPresent|flagUserVerified|flagAttestedData, attested)
+
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	require.NoError(t, err)
+
+	return a.credential(t, map[string]interface{}{
+		"clientDataJSON":    a.encode(a.clientData(t, "webauthn.create", options.Response.Challenge)),
+		"attestationObject": a.encode(attestationObject),
+		"transports":        []string{"internal"},
+	})
+}
+
+// get answers navigator.credentials.get(), advancing the signature counter.
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	authData := a.authenticatorData(options.Response.RelyingPartyID, flagUserPresent|flagUserVerified, nil)
+	clientData := a.clientData(t, "webauthn.get", options.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	return a.credential(t, map[string]interface{}{
+		"clientDataJSON":    a.encode(clientData),
+		"authenticatorData": a.encode(authData),
+		"signature":         a.encode(signature),
+		"userHandle":        a.encode(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]interface{}) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       a.encode(a.credentialID),
+		"rawId":    a.encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func (a *softAuthenticator) encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	ctx := context.Background()
+	org := uuid.New()
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", FirstName: "Ada", Password: hash, IsActive: true}
+	outsider := &models.User{ID: uuid.New(), Email: "eve@example.com", Password: hash, IsActive: true}
+
+	users := &fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user, outsider.ID: outsider}}
+	memberships := &fakeMembershipRepository{organizations: map[uuid.UUID][]models.Organization{user.ID: {{ID: org}}}}
+	passkeys := &fakeWebAuthnRepository{sessions: make(map[uuid.UUID]*models.WebAuthnSession)}
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	authService := NewAuthService(users, memberships, &fakeMFARepository{settings: map[uuid.UUID]*models.UserMFA{}}, nil, tokens, nil, nil)
+	svc, err := NewPasskeyService(
+		config.WebAuthnConfig{RPID: "localhost", RPDisplayName: "Test", RPOrigins: []string{"https://localhost"}},
+		users,
+		memberships,
+		passkeys,
+		nil,
+		tokens,
+		nil,
+		authService,
+	)
+	require.NoError(t, err)
+	password := PasskeyReauthentication{Password: "correct horse"}
+
+	// Registering a passkey takes the password of users without a second
+	// factor.
+	_, err = svc.BeginRegistration(ctx, user.ID, PasskeyReauthentication{})
+	require.ErrorIs(t, err, ErrReauthenticationFailed)
+	_, err = svc.BeginRegistration(ctx, user.ID, PasskeyReauthentication{Password: "wrong"})
+	require.ErrorIs(t, err, ErrReauthenticationFailed)
+
+	authenticator := newSoftAuthenticator(t, "https://localhost")
+	registration, err := svc.BeginRegistration(ctx, user.ID, password)
+	require.NoError(t, err)
+	require.Equal(t, protocol.ResidentKeyRequirementRequired, registration.Options.Response.AuthenticatorSelection.ResidentKey)
+
+	response := authenticator.create(t, registration.Options)
+	_, err = svc.FinishRegistration(ctx, outsider.ID, registration.SessionID, "", response)
+	require.ErrorIs(t, err, repository.ErrWebAuthnSessionNotFound, "a session belongs to the user who began it")
+
+	registration, err = svc.BeginRegistration(ctx, user.ID, password)
+	require.NoError(t, err)
+	passkey, err := svc.FinishRegistration(ctx, user.ID, registration.SessionID, "", authenticator.create(t, registration.Options))
+	require.NoError(t, err)
+	require.Equal(t, "Passkey", passkey.Name)
+	require.Equal(t, authenticator.credentialID, passkey.CredentialID)
+	require.Equal(t, []string{"internal"}, passkey.Transports)
+
+	// Once the user has a passkey, the password alone no longer does; a
+	// passkey of the user does.
+	_, err = svc.BeginRegistration(ctx, user.ID, password)
+	require.ErrorIs(t, err, ErrMFANotEnabled)
+	assertion, err := svc.BeginLogin(ctx)
+	require.NoError(t, err)
+	confirmed := PasskeyReauthentication{SessionID: assertion.SessionID, Credential: authenticator.get(t, assertion.Options)}
+	_, err = svc.BeginRegistration(ctx, outsider.ID, confirmed)
+	require.ErrorIs(t, err, ErrInvalidPasskey, "the passkey must be the user's own")
+	assertion, err = svc.BeginLogin(ctx)
+	require.NoError(t, err)
+	confirmed = PasskeyReauthentication{SessionID: assertion.SessionID, Credential: authenticator.get(t, assertion.Options)}
+	registration, err = svc.BeginRegistration(ctx, user.ID, confirmed)
+	require.NoError(t, err)
+	require.Len(t, registration.Options.Response.CredentialExcludeList, 1)
+	_, err = svc.BeginRegistration(ctx, user.ID, confirmed)
+	require.ErrorIs(t, err, repository.ErrWebAuthnSessionNotFound)
+
+	assertion, err = svc.BeginLogin(ctx)
+	require.NoError(t, err)
+	response = authenticator.get(t, assertion.Options)
+	result, err := svc.FinishLogin(ctx, assertion.SessionID, response, org)
+	require.NoError(t, err)
+	claims, err := tokens.ParseAccessToken(result.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, user.ID.String(), claims.Subject)
+	require.Equal(t, org.String(), claims.OrganizationID)
+	require.Equal(t, []string{auth.AMRHardwareKey, auth.AMRMFA}, claims.AMR)
+	require.Equal(t, uint32(3), passkeys.credentials[0].SignCount)
+
+	_, err = svc.FinishLogin(ctx, assertion.SessionID, response, org)
+	require.ErrorIs(t, err, repository.ErrWebAuthnSessionNotFound, "a challenge is accepted once")
+
+	assertion, err = svc.BeginLogin(ctx)
+	require.NoError(t, err)
+	_, err = svc.FinishLogin(ctx, assertion.SessionID, authenticator.get(t, assertion.Options), uuid.New())
+	require.ErrorIs(t, err, ErrNotMember)
+
+	phished := newSoftAuthenticator(t, "https://evil.example")
+	phished.key, phished.credentialID, phished.userHandle = authenticator.key, authenticator.credentialID, authenticator.userHandle
+	phished.signCount = authenticator.signCount
+	assertion, err = svc.BeginLogin(ctx)
+	require.NoError(t, err)
+	_, err = svc.FinishLogin(ctx, assertion.SessionID, phished.get(t, assertion.Options), org)
+	require.ErrorIs(t, err, ErrInvalidPasskey)
+
+	stranger := newSoftAuthenticator(t, "https://localhost")
+	stranger.userHandle = authenticator.userHandle
+	assertion, err = svc.BeginLogin(ctx)
+	require.NoError(t, err)
+	_, err = svc.FinishLogin(ctx, assertion.SessionID, stranger.get(t, assertion.Options), org)
+	require.ErrorIs(t, err, ErrInvalidPasskey, "unregistered passkeys are rejected")
+}
+
+func TestPasskeyLoginRejectsClonedAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", Password: hash, IsActive: true}
+	users := &fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user}}
+	passkeys := &fakeWebAuthnRepository{sessions: make(map[uuid.UUID]*models.WebAuthnSession)}
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	svc, err := NewPasskeyService(
+		config.WebAuthnConfig{RPID: "localhost", RPDisplayName: "Test", RPOrigins: []string{"https://localhost"}},
+		users,
+		&fakeMembershipRepository{},
+		passkeys,
+		nil,
+		tokens,
+		nil,
+		NewAuthService(users, &fakeMembershipRepository{}, &fakeMFARepository{settings: map[uuid.UUID]*models.UserMFA{}}, nil, tokens, nil, nil),
+	)
+	require.NoError(t, err)
+
+	authenticator := newSoftAuthenticator(t, "https://localhost")
+	registration, err := svc.BeginRegistration(ctx, user.ID, PasskeyReauthentication{Password: "correct horse"})
+	require.NoError(t, err)
+	_, err = svc.FinishRegistration(ctx, user.ID, registration.SessionID, "Laptop", authenticator.create(t, registration.Options))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		assertion, err := svc.BeginLogin(ctx)
+		require.NoError(t, err)
+		_, err = svc.FinishLogin(ctx, assertion.SessionID, authenticator.get(t, assertion.Options), uuid.Nil)
+		require.NoError(t, err)
+	}
+
+	// A copy of the key that has not seen the last login reports a counter
+	// that is not ahead of the stored one.
+	authenticator.signCount = 0
+	assertion, err := svc.BeginLogin(ctx)
+	require.NoError(t, err)
+	_, err = svc.FinishLogin(ctx, assertion.SessionID, authenticator.get(t, assertion.Options), uuid.Nil)
+	require.ErrorIs(t, err, ErrInvalidPasskey)
+	require.True(t, passkeys.credentials[0].CloneWarning)
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/mail"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+// ErrInvalidLoginCode is returned for wrong codes and links, for those of
+// another device, and once a mail expired or ran out of attempts.
+var ErrInvalidLoginCode = errors.New("invalid or expired login code")
+
+const (
+	defaultPasswordlessCodeTTL     = 10 * time.Minute
+	defaultPasswordlessMaxAttempts = 5
+	defaultPasswordlessMaxMails    = 5
+	defaultPasswordlessMailWindow  = time.Hour
+	passwordlessCodeDigits         = 6
+)
+
+// PasswordlessLogin is a login that a code and link were mailed for, if the
+// address has an account. The requesting device keeps DeviceToken and
+// presents it along with either.
+type PasswordlessLogin struct {
+	DeviceToken string
+	ExpiresAt   time.Time
+}
+
+// PasswordlessService logs users in with a one-time code or link mailed to
+// them instead of a password.
+type PasswordlessService struct {
+	challenges  repository.PasswordlessRepository
+	users       repository.UserRepository
+	auth        *AuthService
+	mailer      mail.Mailer
+	templates   *mail.Templates
+	loginURL    string
+	codeTTL     time.Duration
+	maxAttempts int
+	maxMails    int
+	mailWindow  time.Duration
+	now         func() time.Time
+	sending     sync.WaitGroup
+}
+
+// NewPasswordlessService creates the service. Logins go through auth for
+// membership, the login policy and MFA like password logins.
+func NewPasswordlessService(conf config.PasswordlessConfig, challenges repository.PasswordlessRepository, users repository.UserRepository, authService *AuthService, mailer mail.Mailer, templates *mail.Templates) *PasswordlessService {
+	codeTTL := conf.CodeTTL
+	if codeTTL <= 0 {
+		codeTTL = defaultPasswordlessCodeTTL
+	}
+	maxAttempts := conf.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultPasswordlessMaxAttempts
+	}
+	maxMails := conf.MaxMails
+	if maxMails <= 0 {
+		maxMails = defaultPasswordlessMaxMails
+	}
+	mailWindow := conf.MailWindow
+	if mailWindow <= 0 {
+		mailWindow = defaultPasswordlessMailWindow
+	}
+
+	return &PasswordlessService{
+		challenges:  challenges,
+		users:       users,
+		auth:        authService,
+		mailer:      mailer,
+		templates:   templates,
+		loginURL:    conf.LoginURL,
+		codeTTL:     codeTTL,
+		maxAttempts: maxAttempts,
+		maxMails:    maxMails,
+		mailWindow:  mailWindow,
+		now:         time.Now,
+	}
+}
+
+// Start begins a passwordless login to organizationID, if set, and mails a
+// code and link when email belongs to an active user that was not mailed
+// too often already. It returns the same result either way, and mails in
+// the background so that the response time does not tell whether the
+// address has an account either; only an organization whose policy does
+// not allow the method is refused, and so is an address or client that is
+// throttled like for password logins. locale is an Accept-Language value.
+func (s *PasswordlessService) Start(ctx context.Context, email string, organizationID uuid.UUID, locale string) (*PasswordlessLogin, error) {
+	if organizationID != uuid.Nil {
+		if err := checkLoginMethod(ctx, s.auth.loginPolicies, organizationID, auth.AMREmail); err != nil {
+			return nil, err
+		}
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := s.auth.throttle.Check(ctx, email, audit.ActorFrom(ctx).IPAddress); err != nil {
+		return nil, err
+	}
+
+	deviceToken, err := newUserToken()
+	if err != nil {
+		return nil, err
+	}
+	login := &PasswordlessLogin{DeviceToken: deviceToken, ExpiresAt: s.now().Add(s.codeTTL)}
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return login, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return login, nil
+	}
+
+	s.sending.Add(1)
+	go func() {
+		defer s.sending.Done()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+		defer cancel()
+
+		msg, err := s.issue(ctx, user, organizationID, login, locale)
+		if err == nil {
+			err = s.mailer.Send(ctx, msg)
+		}
+		if err != nil {
+			log.Printf("failed to send passwordless login email: %v\n", err)
+		}
+	}()
+	return login, nil
+}
+
+// VerifyCode completes a login with the code that was mailed for the
+// device.
+func (s *PasswordlessService) VerifyCode(ctx context.Context, deviceToken, code string) (*LoginResult, error) {
+	return s.verify(ctx, deviceToken, func(challenge *models.PasswordlessChallenge) bool {
+		return matchesHash(hashLoginCode(challenge.ID, strings.TrimSpace(code)), challenge.CodeHash)
+	})
+}
+
+// VerifyLink completes a login with the token of the link that was mailed
+// for the device. A link opened on another device does not log in.
+func (s *PasswordlessService) VerifyLink(ctx context.Context, deviceToken, token string) (*LoginResult, error) {
+	return s.verify(ctx, deviceToken, func(challenge *models.PasswordlessChallenge) bool {
+		return matchesHash(hashUserToken(token), challenge.LinkHash)
+	})
+}
+
+// Wait blocks until the emails sent in the background are done.
+func (s *PasswordlessService) Wait() {
+	s.sending.Wait()
+}
+
+// verify counts an attempt at the challenge of the device and, when matches
+// accepts it, uses it up and continues the login. Wrong codes and links also
+// count as failed logins of the user, so that the login throttle limits the
+// guesses across mails.
+func (s *PasswordlessService) verify(ctx context.Context, deviceToken string, matches func(*models.PasswordlessChallenge) bool) (*LoginResult, error) {
+	challenge, err := s.challenges.Attempt(ctx, hashUserToken(deviceToken), s.maxAttempts)
+	if errors.Is(err, repository.ErrPasswordlessChallengeNotFound) {
+		return nil, ErrInvalidLoginCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetByID(ctx, challenge.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidLoginCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ip := audit.ActorFrom(ctx).IPAddress
+	if err := s.auth.throttle.Check(ctx, user.Email, ip); err != nil {
+		return nil, err
+	}
+	if !matches(challenge) {
+		if err := s.auth.throttle.Fail(ctx, user.Email, &user.ID, ip); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidLoginCode
+	}
+	err = s.challenges.Consume(ctx, challenge.ID)
+	if errors.Is(err, repository.ErrPasswordlessChallengeNotFound) {
+		return nil, ErrInvalidLoginCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrInvalidLoginCode
+	}
+
+	organizationID := uuid.Nil
+	if challenge.OrganizationID != nil {
+		organizationID = *challenge.OrganizationID
+	}
+	result, err := s.auth.finishLogin(ctx, user, organizationID, auth.AMREmail)
+	if err != nil {
+		return nil, err
+	}
+	if result.MFAToken == "" {
+		if err := s.auth.throttle.Succeed(ctx, user.Email); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// issue stores a new challenge of the login for user and renders the email
+// carrying its code and link.
+func (s *PasswordlessService) issue(ctx context.Context, user *models.User, organizationID uuid.UUID, login *PasswordlessLogin, locale string) (*mail.Message, error) {
+	code, err := newLoginCode()
+	if err != nil {
+		return nil, err
+	}
+	token, err := newUserToken()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := &models.PasswordlessChallenge{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		DeviceHash: hashUserToken(login.DeviceToken),
+		LinkHash:   hashUserToken(token),
+		ExpiresAt:  login.ExpiresAt,
+	}
+	challenge.CodeHash = hashLoginCode(challenge.ID, code)
+	if organizationID != uuid.Nil {
+		challenge.OrganizationID = &organizationID
+	}
+	if err := s.challenges.Create(ctx, challenge, s.now().Add(-s.mailWindow), s.maxMails); err != nil {
+		return nil, err
+	}
+
+	link, err := url.Parse(s.loginURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link URL %q: %w", s.loginURL, err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	msg, err := s.templates.Render(locale, mail.TemplatePasswordlessLogin, mail.TemplateData{
+		Name:      user.FirstName,
+		Link:      link.String(),
+		Code:      code,
+		ExpiresIn: s.codeTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.To = user.Email
+	return msg, nil
+}
+
+// newLoginCode returns a random code of passwordlessCodeDigits digits.
+func newLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(passwordlessCodeDigits), nil))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", passwordlessCodeDigits, n), nil
+}
+
+// hashLoginCode hashes a code with the ID of its challenge, so that the
+// hashes of the few possible codes differ between challenges.
+func hashLoginCode(challengeID uuid.UUID, code string) string {
+	return hashUserToken(challengeID.String() + ":" + code)
+}
+
+func matchesHash(hash, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+}
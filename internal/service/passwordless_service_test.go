@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/mail"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakePasswordlessRepository struct {
+	repository.PasswordlessRepository
+	challenges []*models.PasswordlessChallenge
+}
+
+func (f *fakePasswordlessRepository) Create(ctx context.Context, challenge *models.PasswordlessChallenge, since time.Time, maxMails int) error {
+	now := time.Now()
+	mails := 0
+	for _, existing := range f.challenges {
+		if existing.UserID == challenge.UserID && !existing.CreatedAt.Before(since) {
+			mails++
+		}
+	}
+	if mails >= maxMails {
+		return repository.ErrPasswordlessMailLimit
+	}
+	for _, existing := range f.challenges {
+		if existing.UserID == challenge.UserID && existing.UsedAt == nil && existing.ExpiresAt.After(now) {
+			existing.ExpiresAt = now
+		}
+	}
+	copied := *challenge
+	copied.CreatedAt = now
+	f.challenges = append(f.challenges, &copied)
+	return nil
+}
+
+func (f *fakePasswordlessRepository) Attempt(ctx context.Context, deviceHash string, maxAttempts int) (*models.PasswordlessChallenge, error) {
+	for _, challenge := range f.challenges {
+		if challenge.DeviceHash == deviceHash && challenge.UsedAt == nil && challenge.ExpiresAt.After(time.Now()) && challenge.Attempts < maxAttempts {
+			challenge.Attempts++
+			copied := *challenge
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrPasswordlessChallengeNotFound
+}
+
+func (f *fakePasswordlessRepository) Consume(ctx context.Context, id uuid.UUID) error {
+	for _, challenge := range f.challenges {
+		if challenge.ID == id && challenge.UsedAt == nil {
+			now := time.Now()
+			challenge.UsedAt = &now
+			return nil
+		}
+	}
+	return repository.ErrPasswordlessChallengeNotFound
+}
+
+type fakeLoginPolicyRepository struct {
+	repository.LoginPolicyRepository
+	methods map[uuid.UUID][]string
+}
+
+func (f *fakeLoginPolicyRepository) GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.LoginPolicy, error) {
+	methods, ok := f.methods[organizationID]
+	if !ok {
+		methods = models.LoginMethods
+	}
+	return &models.LoginPolicy{OrganizationID: organizationID, Methods: slices.Clone(methods)}, nil
+}
+
+func (f *fakeLoginPolicyRepository) SetPolicy(ctx context.Context, policy *models.LoginPolicy) error {
+	f.methods[policy.OrganizationID] = policy.Methods
+	return nil
+}
+
+var loginCodePattern = regexp.MustCompile(`(?m)^\d{6}$`)
+
+// mailedLogin returns the code and link token in the last message sent.
+func mailedLogin(t *testing.T, mailer *mail.MemoryMailer) (string, string) {
+	messages := mailer.Messages()
+	require.NotEmpty(t, messages)
+	return loginCodePattern.FindString(messages[len(messages)-1].Text), mailedToken(t, mailer)
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	ctx := context.Background()
+	org := uuid.New()
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", FirstName: "Ada", Password: hash, IsActive: true}
+	users := &fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user}}
+	memberships := &fakeMembershipRepository{organizations: map[uuid.UUID][]models.Organization{user.ID: {{ID: org}}}}
+	policies := &fakeLoginPolicyRepository{methods: make(map[uuid.UUID][]string)}
+	challenges := &fakePasswordlessRepository{}
+
+	templates, err := mail.LoadTemplates()
+	require.NoError(t, err)
+	mailer := mail.NewMemoryMailer()
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	authService := NewAuthService(users, memberships, &fakeMFARepository{settings: map[uuid.UUID]*models.UserMFA{}}, policies, tokens, nil, nil)
+	svc := NewPasswordlessService(config.PasswordlessConfig{
+		LoginURL:    "https://app.example.com/login/passwordless",
+		MaxAttempts: 3,
+		MaxMails:    20,
+	}, challenges, users, authService, mailer, templates)
+
+	start := func(email string, organizationID uuid.UUID) string {
+		login, err := svc.Start(ctx, email, organizationID, "en")
+		require.NoError(t, err)
+		require.NotEmpty(t, login.DeviceToken)
+		svc.Wait()
+		return login.DeviceToken
+	}
+	requireLoggedIn := func(result *LoginResult, err error) {
+		require.NoError(t, err)
+		claims, err := tokens.ParseAccessToken(result.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, []string{auth.AMREmail}, claims.AMR)
+		userID, _ := claims.UserID()
+		require.Equal(t, user.ID, userID)
+	}
+
+	start("nobody@example.com", uuid.Nil)
+	require.Empty(t, mailer.Messages(), "unknown addresses get no mail but the same response")
+
+	// The code logs in from the device that asked for it, once.
+	device := start(" Ada@Example.com ", org)
+	code, _ := mailedLogin(t, mailer)
+	require.Equal(t, "Your login code: "+code, mailer.Messages()[0].Subject)
+	_, err = svc.VerifyCode(ctx, start("ada@example.com", org), code)
+	require.ErrorIs(t, err, ErrInvalidLoginCode, "a newer mail replaces older ones")
+	_, err = svc.VerifyCode(ctx, device, code)
+	require.ErrorIs(t, err, ErrInvalidLoginCode)
+
+	device = start("ada@example.com", org)
+	code, token := mailedLogin(t, mailer)
+	_, err = svc.VerifyCode(ctx, "another-device", code)
+	require.ErrorIs(t, err, ErrInvalidLoginCode)
+	_, err = svc.VerifyLink(ctx, "another-device", token)
+	require.ErrorIs(t, err, ErrInvalidLoginCode, "links only work on the requesting device")
+	requireLoggedIn(svc.VerifyCode(ctx, device, code))
+	_, err = svc.VerifyLink(ctx, device, token)
+	require.ErrorIs(t, err, ErrInvalidLoginCode, "the code and link are used up together")
+
+	// The link works as well as the code.
+	device = start("ada@example.com", uuid.Nil)
+	_, token = mailedLogin(t, mailer)
+	requireLoggedIn(svc.VerifyLink(ctx, device, token))
+
+	// Wrong guesses use up the attempts of a mail.
+	device = start("ada@example.com", org)
+	code, _ = mailedLogin(t, mailer)
+	for range 3 {
+		_, err = svc.VerifyCode(ctx, device, "000000")
+		require.ErrorIs(t, err, ErrInvalidLoginCode)
+	}
+	_, err = svc.VerifyCode(ctx, device, code)
+	require.ErrorIs(t, err, ErrInvalidLoginCode)
+
+	// Codes expire.
+	device = start("ada@example.com", org)
+	code, _ = mailedLogin(t, mailer)
+	challenges.challenges[len(challenges.challenges)-1].ExpiresAt = time.Now().Add(-time.Second)
+	_, err = svc.VerifyCode(ctx, device, code)
+	require.ErrorIs(t, err, ErrInvalidLoginCode)
+
+	// The organization's policy chooses the login methods its members log
+	// in to it with.
+	require.NoError(t, authService.SetLoginPolicy(ctx, &models.LoginPolicy{OrganizationID: org, Methods: []string{models.LoginMethodSSO, models.LoginMethodMagicLink}}))
+	require.Equal(t, []string{models.LoginMethodMagicLink, models.LoginMethodSSO}, policies.methods[org])
+	_, err = authService.Login(ctx, "ada@example.com", "correct horse", org)
+	require.ErrorIs(t, err, ErrLoginMethodNotAllowed)
+	result, err := authService.Login(ctx, "ada@example.com", "correct horse", uuid.Nil)
+	require.NoError(t, err, "the policy applies to logins to the organization")
+	claims, err := tokens.ParseAccessToken(result.AccessToken)
+	require.NoError(t, err)
+	err = authService.CheckOrganizationPolicy(ctx, claims, org)
+	require.ErrorIs(t, err, auth.ErrOrganizationPolicy, "and to logins used in it")
+	require.ErrorIs(t, err, ErrLoginMethodNotAllowed)
+	device = start("ada@example.com", uuid.Nil)
+	code, _ = mailedLogin(t, mailer)
+	result, err = svc.VerifyCode(ctx, device, code)
+	require.NoError(t, err)
+	claims, err = tokens.ParseAccessToken(result.AccessToken)
+	require.NoError(t, err)
+	require.NoError(t, authService.CheckOrganizationPolicy(ctx, claims, org))
+
+	device = start("ada@example.com", org)
+	code, _ = mailedLogin(t, mailer)
+	require.NoError(t, authService.SetLoginPolicy(ctx, &models.LoginPolicy{OrganizationID: org, Methods: []string{models.LoginMethodSSO}}))
+	_, err = svc.Start(ctx, "ada@example.com", org, "en")
+	require.ErrorIs(t, err, ErrLoginMethodNotAllowed)
+	_, err = svc.VerifyCode(ctx, device, code)
+	require.ErrorIs(t, err, ErrLoginMethodNotAllowed, "mails sent before the policy changed no longer log in")
+}
+
+func TestPasswordlessLoginThrottling(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", FirstName: "Ada", IsActive: true}
+	users := &fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user}}
+	now := time.Now()
+	throttle := NewLoginThrottle(config.LoginProtectionConfig{
+		Enabled:                 true,
+		Window:                  time.Hour,
+		AccountLockoutThreshold: 4,
+		IPLockoutThreshold:      100,
+		LockoutDuration:         time.Minute,
+		MaxLockoutDuration:      time.Minute,
+	}, &fakeLoginAttemptRepository{
+		attempts: make(map[string]*models.LoginAttempt),
+		now:      func() time.Time { return now },
+	})
+	throttle.now = func() time.Time { return now }
+
+	templates, err := mail.LoadTemplates()
+	require.NoError(t, err)
+	mailer := mail.NewMemoryMailer()
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	authService := NewAuthService(users, &fakeMembershipRepository{}, &fakeMFARepository{settings: map[uuid.UUID]*models.UserMFA{}}, nil, tokens, throttle, nil)
+	svc := NewPasswordlessService(config.PasswordlessConfig{
+		LoginURL:    "https://app.example.com/login/passwordless",
+		MaxAttempts: 3,
+		MaxMails:    2,
+	}, &fakePasswordlessRepository{}, users, authService, mailer, templates)
+
+	ctx := audit.WithActor(context.Background(), audit.Actor{IPAddress: "192.0.2.1"})
+	start := func() (string, error) {
+		login, err := svc.Start(ctx, "ada@example.com", uuid.Nil, "en")
+		svc.Wait()
+		if err != nil {
+			return "", err
+		}
+		return login.DeviceToken, nil
+	}
+
+	// Wrong codes count against the account across mails, so a new mail
+	// does not bring new guesses.
+	device, err := start()
+	require.NoError(t, err)
+	for range 3 {
+		_, err = svc.VerifyCode(ctx, device, "000000")
+		require.ErrorIs(t, err, ErrInvalidLoginCode)
+	}
+	device, err = start()
+	require.NoError(t, err)
+	code, _ := mailedLogin(t, mailer)
+	_, err = svc.VerifyCode(ctx, device, "000000")
+	require.ErrorIs(t, err, ErrInvalidLoginCode)
+	_, err = svc.VerifyCode(ctx, device, code)
+	require.ErrorIs(t, err, ErrLoginThrottled, "the account is locked out")
+	_, err = start()
+	require.ErrorIs(t, err, ErrLoginThrottled)
+
+	// An address is mailed a limited number of times.
+	now = now.Add(2 * time.Minute)
+	_, err = start()
+	require.NoError(t, err, "the response does not tell")
+	require.Len(t, mailer.Messages(), 2)
+}
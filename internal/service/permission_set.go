@@ -0,0 +1,42 @@
+package service
+
+import "user-management/internal/models"
+
+// PermissionSet is the resolved set of permissions a user holds in a single
+// organization, indexed both by permission name and by resource/action pair.
+type PermissionSet struct {
+	names   map[string]struct{}
+	actions map[string]struct{}
+}
+
+func NewPermissionSet(permissions []models.Permission) *PermissionSet {
+	set := &PermissionSet{
+		names:   make(map[string]struct{}, len(permissions)),
+		actions: make(map[string]struct{}, len(permissions)),
+	}
+	for _, permission := range permissions {
+		set.names[permission.Name] = struct{}{}
+		set.actions[resourceActionKey(permission.Resource, permission.Action)] = struct{}{}
+	}
+	return set
+}
+
+// Has reports whether the set contains the permission with the given name.
+func (s *PermissionSet) Has(name string) bool {
+	_, ok := s.names[name]
+	return ok
+}
+
+// Allows reports whether the set grants action on resource.
+func (s *PermissionSet) Allows(resource, action string) bool {
+	_, ok := s.actions[resourceActionKey(resource, action)]
+	return ok
+}
+
+func (s *PermissionSet) Len() int {
+	return len(s.names)
+}
+
+func resourceActionKey(resource, action string) string {
+	return resource + "\x00" + action
+}
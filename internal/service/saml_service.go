@@ -0,0 +1,385 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/url"
+	"strings"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/saml"
+)
+
+// ErrSAMLNotConfigured is returned for organizations without an enabled
+// SAML connection.
+var ErrSAMLNotConfigured = errors.New("SAML single sign-on is not configured for the organization")
+
+const (
+	defaultSAMLRequestTTL = 10 * time.Minute
+	// samlLoginCodeTTL bounds the time between a verified response and the
+	// frontend redeeming the code it was sent back with.
+	samlLoginCodeTTL = time.Minute
+)
+
+// SAMLLogin is a login that a verified response started. The frontend
+// redeems Code for the login result. State is the state of the login the
+// frontend started, and empty for logins the IdP initiated.
+type SAMLLogin struct {
+	Code  string
+	State string
+}
+
+type SAMLService struct {
+	saml        repository.SAMLRepository
+	identities  repository.IdentityRepository
+	users       repository.UserRepository
+	userRoles   repository.UserRoleRepository
+	auth        *AuthService
+	baseURL     string
+	redirectURL string
+	requestTTL  time.Duration
+	now         func() time.Time
+}
+
+// NewSAMLService creates the service of organizations' SAML connections.
+// Logins go through auth for membership and MFA like password logins.
+func NewSAMLService(conf config.SAMLConfig, samlRepo repository.SAMLRepository, identities repository.IdentityRepository, users repository.UserRepository, userRoles repository.UserRoleRepository, authService *AuthService) *SAMLService {
+	requestTTL := conf.RequestTTL
+	if requestTTL <= 0 {
+		requestTTL = defaultSAMLRequestTTL
+	}
+
+	return &SAMLService{
+		saml:        samlRepo,
+		identities:  identities,
+		users:       users,
+		userRoles:   userRoles,
+		auth:        authService,
+		baseURL:     conf.BaseURL,
+		redirectURL: conf.RedirectURL,
+		requestTTL:  requestTTL,
+		now:         time.Now,
+	}
+}
+
+// EntityID returns the entity ID of the organization's SP, which is the
+// URL of its metadata.
+func (s *SAMLService) EntityID(organizationID uuid.UUID) string {
+	return saml.EndpointURL(s.baseURL, saml.MetadataPath, organizationID.String())
+}
+
+// ACSURL returns the URL of the organization's assertion consumer service.
+func (s *SAMLService) ACSURL(organizationID uuid.UUID) string {
+	return saml.EndpointURL(s.baseURL, saml.ACSPath, organizationID.String())
+}
+
+// Metadata returns the SP metadata that the organization registers with
+// its IdP. It does not depend on the connection, so that it can be
+// registered before the connection is configured.
+func (s *SAMLService) Metadata(organizationID uuid.UUID) ([]byte, error) {
+	return saml.Metadata(s.EntityID(organizationID), s.ACSURL(organizationID))
+}
+
+func (s *SAMLService) GetConnection(ctx context.Context, organizationID uuid.UUID) (*models.SAMLConnection, error) {
+	return s.saml.GetConnection(ctx, organizationID)
+}
+
+// SaveConnection checks the IdP metadata of a connection and saves it.
+func (s *SAMLService) SaveConnection(ctx context.Context, connection *models.SAMLConnection) error {
+	descriptor, err := saml.ParseMetadata([]byte(connection.IdPMetadata))
+	if err != nil {
+		return err
+	}
+	connection.IdPEntityID = descriptor.EntityID
+	if connection.RoleMappings == nil {
+		connection.RoleMappings = []models.SAMLRoleMapping{}
+	}
+	return s.saml.SaveConnection(ctx, connection)
+}
+
+func (s *SAMLService) DeleteConnection(ctx context.Context, organizationID uuid.UUID) error {
+	return s.saml.DeleteConnection(ctx, organizationID)
+}
+
+// BeginLogin starts a login at the organization's IdP. The frontend sends
+// the user to the URL and keeps the state, which the user returns with.
+func (s *SAMLService) BeginLogin(ctx context.Context, organizationID uuid.UUID) (*FederationRedirect, error) {
+	_, sp, err := s.serviceProvider(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := newUserToken()
+	if err != nil {
+		return nil, err
+	}
+	requestID, redirectURL, err := sp.AuthenticationRequest(state)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.identities.SaveState(ctx, &models.FederationState{
+		StateHash:      hashUserToken(state),
+		Provider:       samlProvider(organizationID),
+		Purpose:        models.FederationPurposeSAMLRequest,
+		Nonce:          requestID,
+		OrganizationID: &organizationID,
+		ExpiresAt:      s.now().Add(s.requestTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &FederationRedirect{URL: redirectURL, State: state}, nil
+}
+
+// HandleResponse verifies a response that the IdP posted to the
+// organization's assertion consumer service, and resolves the user it
+// logs in. A response with the relay state of a login we started must
+// answer its request; other responses are only accepted when the
+// connection allows logins the IdP initiates.
+//
+// The user is resolved as for upstream OpenID Connect providers: by the
+// identity linked to the NameID, then by email address among the members
+// of the organization, or as a new user when the connection creates users.
+// Their membership and mapped roles are then updated from the assertion.
+func (s *SAMLService) HandleResponse(ctx context.Context, organizationID uuid.UUID, samlResponse, relayState string) (*SAMLLogin, error) {
+	connection, sp, err := s.serviceProvider(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var requestID, state string
+	if relayState != "" {
+		request, err := s.identities.ConsumeState(ctx, hashUserToken(relayState), models.FederationPurposeSAMLRequest)
+		if err != nil && !errors.Is(err, repository.ErrFederationStateNotFound) {
+			return nil, err
+		}
+		// IdPs may send a relay state of their own with the logins they
+		// initiate.
+		if err == nil && request.OrganizationID != nil && *request.OrganizationID == organizationID {
+			requestID, state = request.Nonce, relayState
+		}
+	}
+	if requestID == "" && !connection.AllowIdPInitiated {
+		return nil, fmt.Errorf("%w: response to no login of ours", ErrFederatedLoginFailed)
+	}
+
+	assertion, err := sp.ParseResponse(samlResponse, requestID)
+	if errors.Is(err, saml.ErrInvalidResponse) {
+		return nil, fmt.Errorf("%w: %v", ErrFederatedLoginFailed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.saml.UseAssertion(ctx, organizationID, assertion.ID, assertion.ExpiresAt)
+	if errors.Is(err, repository.ErrSAMLAssertionReplayed) {
+		return nil, fmt.Errorf("%w: %v", ErrFederatedLoginFailed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.provision(ctx, connection, assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := newUserToken()
+	if err != nil {
+		return nil, err
+	}
+	err = s.identities.SaveState(ctx, &models.FederationState{
+		StateHash:      hashUserToken(code),
+		Provider:       samlProvider(organizationID),
+		Purpose:        models.FederationPurposeSAMLLogin,
+		UserID:         &user.ID,
+		OrganizationID: &organizationID,
+		ExpiresAt:      s.now().Add(samlLoginCodeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SAMLLogin{Code: code, State: state}, nil
+}
+
+// LoginRedirect returns the frontend URL that the assertion consumer
+// service sends the user to with a login.
+func (s *SAMLService) LoginRedirect(login *SAMLLogin) string {
+	query := url.Values{"code": {login.Code}}
+	if login.State != "" {
+		query.Set("state", login.State)
+	}
+	return s.redirect(query)
+}
+
+// ErrorRedirect returns the frontend URL that the assertion consumer
+// service sends the user to when their login failed.
+func (s *SAMLService) ErrorRedirect(code string) string {
+	return s.redirect(url.Values{"error": {code}})
+}
+
+func (s *SAMLService) redirect(values url.Values) string {
+	redirect, err := url.Parse(s.redirectURL)
+	if err != nil {
+		return s.redirectURL
+	}
+	query := redirect.Query()
+	for name, value := range values {
+		query[name] = value
+	}
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+// FinishLogin redeems the code of a login. The login then continues like a
+// password login scoped to the organization, for its second factor.
+func (s *SAMLService) FinishLogin(ctx context.Context, code string) (*LoginResult, error) {
+	login, err := s.identities.ConsumeState(ctx, hashUserToken(code), models.FederationPurposeSAMLLogin)
+	if err != nil {
+		return nil, err
+	}
+	if login.UserID == nil || login.OrganizationID == nil {
+		return nil, repository.ErrFederationStateNotFound
+	}
+
+	user, err := s.users.GetByID(ctx, *login.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrFederatedLoginFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrFederatedLoginFailed
+	}
+	return s.auth.finishLogin(ctx, user, *login.OrganizationID, auth.AMRFederated)
+}
+
+// serviceProvider returns the enabled connection of an organization and
+// its SP.
+func (s *SAMLService) serviceProvider(ctx context.Context, organizationID uuid.UUID) (*models.SAMLConnection, *saml.ServiceProvider, error) {
+	connection, err := s.saml.GetConnection(ctx, organizationID)
+	if errors.Is(err, repository.ErrSAMLConnectionNotFound) {
+		return nil, nil, ErrSAMLNotConfigured
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !connection.Enabled {
+		return nil, nil, ErrSAMLNotConfigured
+	}
+
+	sp, err := saml.NewServiceProvider(s.EntityID(organizationID), s.ACSURL(organizationID), []byte(connection.IdPMetadata))
+	if err != nil {
+		return nil, nil, err
+	}
+	return connection, sp, nil
+}
+
+// provision resolves the user an assertion logs in and applies it to their
+// account.
+func (s *SAMLService) provision(ctx context.Context, connection *models.SAMLConnection, assertion *saml.Assertion) (*models.User, error) {
+	mapping := connection.AttributeMapping
+	email := assertion.NameID
+	if mapping.Email != "" {
+		email = assertion.Attribute(mapping.Email)
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	var groups []string
+	if mapping.Groups != "" {
+		groups = assertion.Attributes[mapping.Groups]
+	}
+
+	now := s.now()
+	identity := &models.Identity{
+		Provider:    samlProvider(connection.OrganizationID),
+		Subject:     assertion.NameID,
+		Email:       email,
+		LastLoginAt: &now,
+	}
+
+	var user *models.User
+	linked, err := s.identities.GetBySubject(ctx, identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		identity.ID = linked.ID
+		user, err = s.users.GetByID(ctx, linked.UserID)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrFederatedLoginFailed
+		}
+		if err != nil {
+			return nil, err
+		}
+	case errors.Is(err, repository.ErrIdentityNotFound):
+		user, err = s.matchUser(ctx, connection, email)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			// An IdP can assert any address, so it does not count as
+			// verified for logins other than through the connection.
+			user = &models.User{
+				Email:     email,
+				FirstName: assertion.Attribute(mapping.FirstName),
+				LastName:  assertion.Attribute(mapping.LastName),
+			}
+		}
+	default:
+		return nil, err
+	}
+	if user.ID != uuid.Nil && !user.IsActive {
+		return nil, ErrFederatedLoginFailed
+	}
+
+	err = s.saml.Provision(ctx, connection.OrganizationID, user, identity, groups)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		return nil, ErrAccountNotLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// matchUser returns the account that a new identity with email is linked
+// to: the account with the address, if it is a member of the organization
+// and verified the address. It returns nil when a new account is to be
+// created. Provision links the identity only if the organization also
+// manages the account, so that its IdP cannot take over accounts that
+// belong to other organizations too.
+func (s *SAMLService) matchUser(ctx context.Context, connection *models.SAMLConnection, email string) (*models.User, error) {
+	if !strings.Contains(email, "@") {
+		return nil, ErrNoAccount
+	}
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		if !connection.CreateUsers {
+			return nil, ErrNoAccount
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := isMember(ctx, s.userRoles, user.ID, connection.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	if !member || !user.EmailVerified {
+		return nil, ErrAccountNotLinked
+	}
+	return user, nil
+}
+
+// samlProvider is the provider of the identities linked through an
+// organization's connection.
+func samlProvider(organizationID uuid.UUID) string {
+	return "saml:" + organizationID.String()
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	gosaml "github.com/crewjam/saml"
+	"github.com/crewjam/saml/logger"
+	"github.com/google/uuid"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/require"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+	"user-management/internal/saml"
+)
+
+type fakeSAMLRepository struct {
+	repository.SAMLRepository
+	users       *fakeUserRepository
+	identities  *fakeIdentityRepository
+	memberships *fakeMembershipRepository
+	connections map[uuid.UUID]*models.SAMLConnection
+	assertions  map[string]bool
+	groups      map[uuid.UUID][]string
+	provisioned map[uuid.UUID]uuid.UUID
+}
+
+func (f *fakeSAMLRepository) GetConnection(ctx context.Context, organizationID uuid.UUID) (*models.SAMLConnection, error) {
+	connection, ok := f.connections[organizationID]
+	if !ok {
+		return nil, repository.ErrSAMLConnectionNotFound
+	}
+	copied := *connection
+	return &copied, nil
+}
+
+func (f *fakeSAMLRepository) SaveConnection(ctx context.Context, connection *models.SAMLConnection) error {
+	copied := *connection
+	f.connections[connection.OrganizationID] = &copied
+	return nil
+}
+
+func (f *fakeSAMLRepository) UseAssertion(ctx context.Context, organizationID uuid.UUID, assertionID string, expiresAt time.Time) error {
+	key := organizationID.String() + "/" + assertionID
+	if f.assertions[key] {
+		return repository.ErrSAMLAssertionReplayed
+	}
+	f.assertions[key] = true
+	return nil
+}
+
+func (f *fakeSAMLRepository) Provision(ctx context.Context, organizationID uuid.UUID, user *models.User, identity *models.Identity, groups []string) error {
+	if user.ID == uuid.Nil {
+		if _, err := f.users.GetByEmail(ctx, user.Email); err == nil {
+			return repository.ErrEmailTaken
+		}
+		user.ID = uuid.New()
+		user.IsActive = true
+		f.users.users[user.ID] = user
+		f.provisioned[user.ID] = organizationID
+	} else if identity.ID == uuid.Nil {
+		organizations := f.memberships.organizations[user.ID]
+		if f.provisioned[user.ID] != organizationID || len(organizations) > 1 ||
+			len(organizations) == 1 && organizations[0].ID != organizationID {
+			return repository.ErrEmailTaken
+		}
+	}
+	if identity.ID == uuid.Nil {
+		identity.UserID = user.ID
+		if err := f.identities.Create(ctx, identity); err != nil {
+			return err
+		}
+	} else if err := f.identities.RecordLogin(ctx, identity.ID, identity.Email); err != nil {
+		return err
+	}
+
+	member, _ := isMember(ctx, f.memberships, user.ID, organizationID)
+	if !member {
+		f.memberships.organizations[user.ID] = append(f.memberships.organizations[user.ID], models.Organization{ID: organizationID})
+	}
+	f.groups[user.ID] = groups
+	return nil
+}
+
+// mockIdP is a SAML IdP that logs in whichever session is set as its user,
+// without asking.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	idp    *gosaml.IdentityProvider
+	sp     *gosaml.EntityDescriptor
+	user   gosaml.Session
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mock idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	p := &mockIdP{t: t}
+	mux := http.NewServeMux()
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	base, _ := url.Parse(p.server.URL)
+	p.idp = &gosaml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		Logger:                  logger.DefaultLogger,
+		MetadataURL:             *base.JoinPath("/metadata"),
+		SSOURL:                  *base.JoinPath("/sso"),
+		ServiceProviderProvider: p,
+		SessionProvider:         p,
+		SignatureMethod:         dsig.RSASHA256SignatureMethod,
+	}
+	mux.HandleFunc("/sso", p.idp.ServeSSO)
+	return p
+}
+
+func (p *mockIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*gosaml.EntityDescriptor, error) {
+	if p.sp == nil || p.sp.EntityID != serviceProviderID {
+		return nil, errors.New("unknown service provider")
+	}
+	return p.sp, nil
+}
+
+func (p *mockIdP) GetSession(w http.ResponseWriter, r *http.Request, req *gosaml.IdpAuthnRequest) *gosaml.Session {
+	session := p.user
+	session.ID = uuid.NewString()
+	session.CreateTime = time.Now()
+	session.ExpireTime = time.Now().Add(time.Hour)
+	return &session
+}
+
+// metadata returns the metadata of the IdP, and registers the SP of
+// spMetadata with it.
+func (p *mockIdP) metadata(spMetadata []byte) string {
+	p.sp = &gosaml.EntityDescriptor{}
+	require.NoError(p.t, xml.Unmarshal(spMetadata, p.sp))
+
+	metadata, err := xml.Marshal(p.idp.Metadata())
+	require.NoError(p.t, err)
+	return string(metadata)
+}
+
+var samlResponseInput = regexp.MustCompile(`name="SAMLResponse" value="([^"]*)"`)
+
+// login follows a redirect to the IdP as the browser would, and returns the
+// response and relay state that the browser posts to the ACS.
+func (p *mockIdP) login(redirect *FederationRedirect) (string, string) {
+	resp, err := http.Get(redirect.URL)
+	require.NoError(p.t, err)
+	defer resp.Body.Close()
+	require.Equal(p.t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(p.t, err)
+	match := samlResponseInput.FindSubmatch(body)
+	require.NotNil(p.t, match)
+	return html.UnescapeString(string(match[1])), redirect.State
+}
+
+// initiate returns a response to no request, as IdPs post for logins users
+// start at the IdP.
+func (p *mockIdP) initiate() string {
+	w := httptest.NewRecorder()
+	p.idp.ServeIDPInitiated(w, httptest.NewRequest(http.MethodGet, "/sso", nil), p.sp.EntityID, "")
+	require.Equal(p.t, http.StatusOK, w.Code)
+	match := samlResponseInput.FindStringSubmatch(w.Body.String())
+	require.NotNil(p.t, match)
+	return html.UnescapeString(match[1])
+}
+
+func TestSAMLLoginProvisioningAndReplay(t *testing.T) {
+	ctx := context.Background()
+	org := uuid.New()
+	idp := newMockIdP(t)
+
+	existing := &models.User{ID: uuid.New(), Email: "grace@example.com", EmailVerified: true, IsActive: true}
+	outsider := &models.User{ID: uuid.New(), Email: "mallory@example.com", EmailVerified: true, IsActive: true}
+	users := &fakeUserRepository{users: map[uuid.UUID]*models.User{existing.ID: existing, outsider.ID: outsider}}
+	memberships := &fakeMembershipRepository{organizations: map[uuid.UUID][]models.Organization{existing.ID: {{ID: org}}}}
+	identities := &fakeIdentityRepository{users: users, states: make(map[string]*models.FederationState)}
+	samlRepo := &fakeSAMLRepository{
+		users:       users,
+		identities:  identities,
+		memberships: memberships,
+		connections: make(map[uuid.UUID]*models.SAMLConnection),
+		assertions:  make(map[string]bool),
+		groups:      make(map[uuid.UUID][]string),
+		provisioned: map[uuid.UUID]uuid.UUID{existing.ID: org},
+	}
+
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	authService := NewAuthService(users, memberships, &fakeMFARepository{settings: map[uuid.UUID]*models.UserMFA{}}, nil, tokens, nil, nil)
+	svc := NewSAMLService(config.SAMLConfig{
+		BaseURL:     "http://localhost:9999",
+		RedirectURL: "http://localhost:3000/login/saml",
+	}, samlRepo, identities, users, memberships, authService)
+
+	_, err := svc.BeginLogin(ctx, org)
+	require.ErrorIs(t, err, ErrSAMLNotConfigured)
+
+	spMetadata, err := svc.Metadata(org)
+	require.NoError(t, err)
+	require.ErrorIs(t, svc.SaveConnection(ctx, &models.SAMLConnection{OrganizationID: org, IdPMetadata: "<nope/>"}), saml.ErrInvalidMetadata)
+	connection := &models.SAMLConnection{
+		OrganizationID: org,
+		IdPMetadata:    idp.metadata(spMetadata),
+		AttributeMapping: models.SAMLAttributeMapping{
+			Email:     "eduPersonPrincipalName",
+			FirstName: "givenName",
+			LastName:  "sn",
+			Groups:    "eduPersonAffiliation",
+		},
+		CreateUsers: true,
+		Enabled:     true,
+	}
+	require.NoError(t, svc.SaveConnection(ctx, connection))
+	require.Equal(t, idp.server.URL+"/metadata", connection.IdPEntityID)
+
+	loginAs := func(session gosaml.Session) (*LoginResult, error) {
+		idp.user = session
+		redirect, err := svc.BeginLogin(ctx, org)
+		require.NoError(t, err)
+		response, relayState := idp.login(redirect)
+		login, err := svc.HandleResponse(ctx, org, response, relayState)
+		if err != nil {
+			return nil, err
+		}
+		require.Equal(t, redirect.State, login.State)
+		return svc.FinishLogin(ctx, login.Code)
+	}
+	userOf := func(result *LoginResult) uuid.UUID {
+		claims, err := tokens.ParseAccessToken(result.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, []string{auth.AMRFederated}, claims.AMR)
+		require.Equal(t, org.String(), claims.OrganizationID)
+		userID, _ := claims.UserID()
+		return userID
+	}
+
+	// The first login of an unknown address creates the user, a member of
+	// the organization with the groups the IdP asserts.
+	ada := gosaml.Session{
+		NameID:        "ada-1815",
+		UserEmail:     "Ada@Example.com",
+		UserGivenName: "Ada",
+		UserSurname:   "Lovelace",
+		Groups:        []string{"engineering", "admins"},
+	}
+	result, err := loginAs(ada)
+	require.NoError(t, err)
+	adaID := userOf(result)
+	created := users.users[adaID]
+	require.Equal(t, "ada@example.com", created.Email)
+	require.Equal(t, "Lovelace", created.LastName)
+	require.False(t, created.EmailVerified)
+	require.Equal(t, []string{"engineering", "admins"}, samlRepo.groups[adaID])
+
+	// Later logins find the user by NameID and update their groups.
+	ada.UserEmail = "ada@new.example.com"
+	ada.Groups = []string{"engineering"}
+	result, err = loginAs(ada)
+	require.NoError(t, err)
+	require.Equal(t, adaID, userOf(result))
+	require.Equal(t, []string{"engineering"}, samlRepo.groups[adaID])
+
+	// An address links to the account of a member who verified it, if the
+	// organization manages it, never to an account outside the
+	// organization or one that belongs to other organizations too.
+	result, err = loginAs(gosaml.Session{NameID: "grace", UserEmail: "grace@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, existing.ID, userOf(result))
+	_, err = loginAs(gosaml.Session{NameID: "mallory", UserEmail: "mallory@example.com"})
+	require.ErrorIs(t, err, ErrAccountNotLinked)
+	memberships.organizations[outsider.ID] = []models.Organization{{ID: org}}
+	_, err = loginAs(gosaml.Session{NameID: "mallory", UserEmail: "mallory@example.com"})
+	require.ErrorIs(t, err, ErrAccountNotLinked, "members the organization did not provision are not linked")
+	memberships.organizations[existing.ID] = append(memberships.organizations[existing.ID], models.Organization{ID: uuid.New()})
+	_, err = loginAs(gosaml.Session{NameID: "grace-2", UserEmail: "grace@example.com"})
+	require.ErrorIs(t, err, ErrAccountNotLinked, "accounts of several organizations are not linked")
+
+	// A response answers one request: tampered with, or posted again, it is
+	// refused.
+	idp.user = ada
+	redirect, err := svc.BeginLogin(ctx, org)
+	require.NoError(t, err)
+	response, relayState := idp.login(redirect)
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	require.NoError(t, err)
+	tampered := strings.Replace(string(decoded), "ada-1815", "grace", 1)
+	_, err = svc.HandleResponse(ctx, org, base64.StdEncoding.EncodeToString([]byte(tampered)), relayState)
+	require.ErrorIs(t, err, ErrFederatedLoginFailed)
+
+	redirect, err = svc.BeginLogin(ctx, org)
+	require.NoError(t, err)
+	response, relayState = idp.login(redirect)
+	_, err = svc.HandleResponse(ctx, org, response, relayState)
+	require.NoError(t, err)
+	_, err = svc.HandleResponse(ctx, org, response, relayState)
+	require.ErrorIs(t, err, ErrFederatedLoginFailed)
+
+	// Another organization's login does not answer this one's request.
+	_, err = svc.HandleResponse(ctx, uuid.New(), response, relayState)
+	require.ErrorIs(t, err, ErrSAMLNotConfigured)
+
+	// Logins the IdP initiates need the connection to allow them.
+	_, err = svc.HandleResponse(ctx, org, idp.initiate(), "")
+	require.ErrorIs(t, err, ErrFederatedLoginFailed)
+	connection.AllowIdPInitiated = true
+	require.NoError(t, svc.SaveConnection(ctx, connection))
+	response = idp.initiate()
+	login, err := svc.HandleResponse(ctx, org, response, "")
+	require.NoError(t, err)
+	require.Empty(t, login.State)
+	require.Equal(t, "http://localhost:3000/login/saml?code="+url.QueryEscape(login.Code), svc.LoginRedirect(login))
+	result, err = svc.FinishLogin(ctx, login.Code)
+	require.NoError(t, err)
+	require.Equal(t, adaID, userOf(result))
+	_, err = svc.FinishLogin(ctx, login.Code)
+	require.ErrorIs(t, err, repository.ErrFederationStateNotFound)
+	_, err = svc.HandleResponse(ctx, org, response, "")
+	require.ErrorIs(t, err, ErrFederatedLoginFailed)
+
+	// Without user creation, unknown addresses have no account.
+	connection.CreateUsers = false
+	require.NoError(t, svc.SaveConnection(ctx, connection))
+	_, err = loginAs(gosaml.Session{NameID: "eve", UserEmail: "eve@example.com"})
+	require.ErrorIs(t, err, ErrNoAccount)
+
+	connection.Enabled = false
+	require.NoError(t, svc.SaveConnection(ctx, connection))
+	_, err = svc.BeginLogin(ctx, org)
+	require.ErrorIs(t, err, ErrSAMLNotConfigured)
+}
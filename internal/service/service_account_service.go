@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+var ErrRoleNotInOrganization = errors.New("role belongs to another organization")
+
+const (
+	defaultServiceAccountTokenTTL = time.Hour
+	defaultSecretGracePeriod      = 24 * time.Hour
+
+	serviceAccountClientIDPrefix = "sa_"
+	serviceAccountSecretPrefix   = "sas_"
+)
+
+// ServiceAccountService manages service accounts and issues their access
+// tokens through the client_credentials grant.
+type ServiceAccountService struct {
+	accounts    repository.ServiceAccountRepository
+	roles       repository.RoleRepository
+	userRoles   repository.UserRoleRepository
+	tokens      *auth.TokenManager
+	tokenTTL    time.Duration
+	gracePeriod time.Duration
+	now         func() time.Time
+}
+
+func NewServiceAccountService(conf config.ServiceAccountConfig, accounts repository.ServiceAccountRepository, roles repository.RoleRepository, userRoles repository.UserRoleRepository, tokens *auth.TokenManager) *ServiceAccountService {
+	tokenTTL := conf.TokenTTL
+	if tokenTTL <= 0 {
+		tokenTTL = defaultServiceAccountTokenTTL
+	}
+	gracePeriod := conf.SecretGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = defaultSecretGracePeriod
+	}
+
+	return &ServiceAccountService{
+		accounts:    accounts,
+		roles:       roles,
+		userRoles:   userRoles,
+		tokens:      tokens,
+		tokenTTL:    tokenTTL,
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+	}
+}
+
+// Create registers an account with a new client ID and returns its secret,
+// which is only shown once.
+func (s *ServiceAccountService) Create(ctx context.Context, account *models.ServiceAccount) (string, error) {
+	clientID, err := newServiceAccountClientID()
+	if err != nil {
+		return "", err
+	}
+	secret, hash, err := newServiceAccountSecret()
+	if err != nil {
+		return "", err
+	}
+
+	account.ClientID = clientID
+	account.SecretHash = hash
+	if err := s.accounts.Create(ctx, account); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Get returns an account of the organization. Accounts of other
+// organizations are reported as missing.
+func (s *ServiceAccountService) Get(ctx context.Context, organizationID, id uuid.UUID) (*models.ServiceAccount, error) {
+	account, err := s.accounts.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if account.OrganizationID != organizationID {
+		return nil, repository.ErrServiceAccountNotFound
+	}
+	return account, nil
+}
+
+func (s *ServiceAccountService) List(ctx context.Context, organizationID uuid.UUID) ([]models.ServiceAccount, error) {
+	return s.accounts.List(ctx, organizationID)
+}
+
+// Update saves the name, description and active flag of an account.
+// Deactivated accounts get no new tokens; issued ones run out within the
+// token lifetime.
+func (s *ServiceAccountService) Update(ctx context.Context, account *models.ServiceAccount) error {
+	return s.accounts.Update(ctx, account)
+}
+
+func (s *ServiceAccountService) Delete(ctx context.Context, account *models.ServiceAccount) error {
+	return s.accounts.Delete(ctx, account.ID)
+}
+
+// RotateSecret issues a new secret for an account and returns it. The
+// previous secret keeps working for the grace period.
+func (s *ServiceAccountService) RotateSecret(ctx context.Context, account *models.ServiceAccount) (string, error) {
+	secret, hash, err := newServiceAccountSecret()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.accounts.RotateSecret(ctx, account.ID, hash, s.now().Add(s.gracePeriod)); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (s *ServiceAccountService) ListRoles(ctx context.Context, account *models.ServiceAccount) ([]models.Role, error) {
+	return s.userRoles.GetUserRoles(ctx, account.ID, account.OrganizationID)
+}
+
+// AssignRole grants an account a role of its organization.
+func (s *ServiceAccountService) AssignRole(ctx context.Context, account *models.ServiceAccount, roleID uuid.UUID, assignedBy uuid.UUID) error {
+	role, err := s.roles.GetByID(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	if role.OrganizationID != account.OrganizationID {
+		return ErrRoleNotInOrganization
+	}
+
+	return s.userRoles.AssignRole(ctx, &models.UserRole{
+		UserID:         account.ID,
+		RoleID:         roleID,
+		OrganizationID: account.OrganizationID,
+		AssignedBy:     &assignedBy,
+	})
+}
+
+func (s *ServiceAccountService) RemoveRole(ctx context.Context, account *models.ServiceAccount, roleID uuid.UUID) error {
+	return s.userRoles.RemoveRole(ctx, account.ID, roleID, account.OrganizationID)
+}
+
+// Token is the client_credentials grant. scope, when given, lists the
+// permissions the token is limited to, each of which the account must hold.
+func (s *ServiceAccountService) Token(ctx context.Context, clientID, secret, scope string) (*oidc.TokenResponse, error) {
+	account, err := s.Authenticate(ctx, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := oidc.ParseScope(scope)
+	if len(scopes) > 0 {
+		permissions, err := s.userRoles.GetUserPermissions(ctx, account.ID, account.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		held := make([]string, len(permissions))
+		for i, permission := range permissions {
+			held[i] = permission.Name
+		}
+		if !oidc.Subset(scopes, held) {
+			return nil, oidc.NewError(oidc.ErrorInvalidScope, "scope exceeds the permissions of the service account")
+		}
+	}
+
+	accessToken, err := s.tokens.GenerateServiceAccountToken(account, scopes, s.tokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.accounts.RecordUse(ctx, account.ID, s.now(), audit.ActorFrom(ctx).IPAddress); err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	return &oidc.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.tokenTTL.Seconds()),
+		Scope:       oidc.FormatScope(scopes),
+	}, nil
+}
+
+// Authenticate checks the credentials of an active account against its
+// secret, or the previous one during its grace period.
+func (s *ServiceAccountService) Authenticate(ctx context.Context, clientID, secret string) (*models.ServiceAccount, error) {
+	invalid := oidc.NewError(oidc.ErrorInvalidClient, "client authentication failed")
+
+	account, err := s.accounts.GetByClientID(ctx, clientID)
+	if errors.Is(err, repository.ErrServiceAccountNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !account.IsActive {
+		return nil, invalid
+	}
+
+	hash := []byte(hashUserToken(secret))
+	if subtle.ConstantTimeCompare(hash, []byte(account.SecretHash)) == 1 {
+		return account, nil
+	}
+	if account.PreviousSecretHash != "" && account.PreviousSecretExpiresAt != nil &&
+		s.now().Before(*account.PreviousSecretExpiresAt) &&
+		subtle.ConstantTimeCompare(hash, []byte(account.PreviousSecretHash)) == 1 {
+		return account, nil
+	}
+	return nil, invalid
+}
+
+func newServiceAccountClientID() (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate client ID: %w", err)
+	}
+	return serviceAccountClientIDPrefix + hex.EncodeToString(raw), nil
+}
+
+// newServiceAccountSecret returns a secret and its hash.
+func newServiceAccountSecret() (string, string, error) {
+	token, err := newUserToken()
+	if err != nil {
+		return "", "", err
+	}
+	secret := serviceAccountSecretPrefix + token
+	return secret, hashUserToken(secret), nil
+}
@@ -0,0 +1,431 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"strings"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+const (
+	defaultRefreshTokenTTL      = 30 * 24 * time.Hour
+	defaultSessionTouchInterval = time.Minute
+)
+
+// SessionService keeps the sessions of logged in users. A session is a
+// refresh token family: each refresh replaces the refresh token, and
+// presenting a replaced one revokes the session, since it means the token
+// was copied. Access tokens carry the session ID and stop being accepted
+// when the session is revoked.
+type SessionService struct {
+	sessions      repository.SessionRepository
+	users         repository.UserRepository
+	userRoles     repository.UserRoleRepository
+	tokens        *auth.TokenManager
+	refreshTTL    time.Duration
+	touchInterval time.Duration
+	now           func() time.Time
+}
+
+func NewSessionService(conf config.SessionConfig, sessions repository.SessionRepository, users repository.UserRepository, userRoles repository.UserRoleRepository, tokens *auth.TokenManager) *SessionService {
+	refreshTTL := conf.RefreshTokenTTL
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTokenTTL
+	}
+	touchInterval := conf.TouchInterval
+	if touchInterval <= 0 {
+		touchInterval = defaultSessionTouchInterval
+	}
+
+	return &SessionService{
+		sessions:      sessions,
+		users:         users,
+		userRoles:     userRoles,
+		tokens:        tokens,
+		refreshTTL:    refreshTTL,
+		touchInterval: touchInterval,
+		now:           time.Now,
+	}
+}
+
+// Start opens a session for a completed login from the client in the audit
+// actor of ctx, and issues its access and refresh tokens.
+func (s *SessionService) Start(ctx context.Context, user *models.User, organizationID uuid.UUID, amr ...string) (*LoginResult, error) {
+	actor := audit.ActorFrom(ctx)
+	session := &models.Session{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Device:    describeDevice(actor.UserAgent),
+		UserAgent: actor.UserAgent,
+		IPAddress: actor.IPAddress,
+		AMR:       amr,
+		ExpiresAt: s.now().Add(s.refreshTTL),
+	}
+	if organizationID != uuid.Nil {
+		session.OrganizationID = &organizationID
+	}
+
+	refreshToken, err := newRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshTokenHash = hashUserToken(refreshToken)
+
+	if _, err := s.sessions.Create(ctx, session); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.tokens.GenerateSessionAccessToken(user, organizationID, session.ID, amr...)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// StartClient opens the session of an OAuth client that holds its refresh
+// tokens, with the given ID, and returns its refresh token.
+func (s *SessionService) StartClient(ctx context.Context, id uuid.UUID, user *models.User, client *models.OAuthClient, scopes []string, amr ...string) (string, error) {
+	return s.startClient(ctx, id, user, client, scopes, "", amr)
+}
+
+// StartDevice opens the session of a device that an OAuth client got access
+// for with the device grant, with the given ID, and returns its refresh
+// token.
+func (s *SessionService) StartDevice(ctx context.Context, id uuid.UUID, user *models.User, client *models.OAuthClient, scopes []string, amr ...string) (string, error) {
+	return s.startClient(ctx, id, user, client, scopes, oidc.GrantTypeDeviceCode, amr)
+}
+
+func (s *SessionService) startClient(ctx context.Context, id uuid.UUID, user *models.User, client *models.OAuthClient, scopes []string, grantType string, amr []string) (string, error) {
+	actor := audit.ActorFrom(ctx)
+	session := &models.Session{
+		ID:             id,
+		UserID:         user.ID,
+		OrganizationID: &client.OrganizationID,
+		Device:         client.Name,
+		UserAgent:      actor.UserAgent,
+		IPAddress:      actor.IPAddress,
+		AMR:            amr,
+		ClientID:       &client.ID,
+		Scopes:         scopes,
+		GrantType:      grantType,
+		ExpiresAt:      s.now().Add(s.refreshTTL),
+	}
+
+	refreshToken, err := newRefreshToken(session.ID)
+	if err != nil {
+		return "", err
+	}
+	session.RefreshTokenHash = hashUserToken(refreshToken)
+
+	if _, err := s.sessions.Create(ctx, session); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh
+// token.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (*LoginResult, error) {
+	session, user, newToken, err := s.Rotate(ctx, refreshToken, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := uuid.Nil
+	if session.OrganizationID != nil {
+		organizationID = *session.OrganizationID
+	}
+	accessToken, err := s.tokens.GenerateSessionAccessToken(user, organizationID, session.ID, session.AMR...)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{AccessToken: accessToken, RefreshToken: newToken}, nil
+}
+
+// Rotate replaces a refresh token of the OAuth client clientID, or of a
+// login when it is uuid.Nil, and returns the session, its user and the new
+// token. The session ends when the user is deactivated or has left its
+// organization.
+func (s *SessionService) Rotate(ctx context.Context, refreshToken string, clientID uuid.UUID) (*models.Session, *models.User, string, error) {
+	sessionID, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, nil, "", ErrInvalidRefreshToken
+	}
+
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil, nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	now := s.now()
+	if !session.Active(now) {
+		return nil, nil, "", ErrInvalidRefreshToken
+	}
+	if session.ClientID == nil && clientID != uuid.Nil || session.ClientID != nil && *session.ClientID != clientID {
+		return nil, nil, "", ErrInvalidRefreshToken
+	}
+
+	if hashUserToken(refreshToken) != session.RefreshTokenHash {
+		log.Printf("refresh token reused, revoking session %s\n", session.ID)
+		return nil, nil, "", s.end(ctx, session)
+	}
+
+	user, err := s.users.GetByID(ctx, session.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, nil, "", s.end(ctx, session)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if session.OrganizationID != nil {
+		member, err := isMember(ctx, s.userRoles, user.ID, *session.OrganizationID)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if !member {
+			return nil, nil, "", s.end(ctx, session)
+		}
+	}
+
+	newToken, err := newRefreshToken(session.ID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	previousHash := session.RefreshTokenHash
+	session.RefreshTokenHash = hashUserToken(newToken)
+	session.LastSeenAt = now
+	if actor := audit.ActorFrom(ctx); actor.IPAddress != "" {
+		session.IPAddress = actor.IPAddress
+		session.UserAgent = actor.UserAgent
+	}
+
+	// A concurrent refresh with the same token got there first.
+	err = s.sessions.Rotate(ctx, session, previousHash)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil, nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return session, user, newToken, nil
+}
+
+// Lookup returns the active session of a refresh token, without rotating
+// it. A replaced token is reported as invalid but, unlike in Rotate, does
+// not end its session.
+func (s *SessionService) Lookup(ctx context.Context, refreshToken string) (*models.Session, error) {
+	sessionID, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !session.Active(s.now()) || subtle.ConstantTimeCompare([]byte(hashUserToken(refreshToken)), []byte(session.RefreshTokenHash)) != 1 {
+		return nil, ErrInvalidRefreshToken
+	}
+	return session, nil
+}
+
+// ValidateSession implements auth.SessionValidator. It also records when
+// the session was last used.
+func (s *SessionService) ValidateSession(ctx context.Context, claims *auth.Claims) error {
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid session", auth.ErrInvalidToken)
+	}
+
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return fmt.Errorf("%w: session ended", auth.ErrInvalidToken)
+	}
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	if !session.Active(now) || session.UserID.String() != claims.Subject {
+		return fmt.Errorf("%w: session ended", auth.ErrInvalidToken)
+	}
+
+	if now.Sub(session.LastSeenAt) >= s.touchInterval {
+		ip := audit.ActorFrom(ctx).IPAddress
+		if ip == "" {
+			ip = session.IPAddress
+		}
+		if err := s.sessions.Touch(ctx, session.ID, now, ip); err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+	return nil
+}
+
+// List returns the active sessions of a user, most recently used first.
+func (s *SessionService) List(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	return s.sessions.ListActive(ctx, userID)
+}
+
+// Revoke ends one of the user's sessions.
+func (s *SessionService) Revoke(ctx context.Context, userID, sessionID uuid.UUID) error {
+	return s.sessions.Revoke(ctx, userID, sessionID)
+}
+
+// RevokeOthers ends every session of the user except current, and returns
+// how many were ended.
+func (s *SessionService) RevokeOthers(ctx context.Context, userID, current uuid.UUID) (int, error) {
+	revoked, err := s.sessions.RevokeAll(ctx, userID, current)
+	return len(revoked), err
+}
+
+// Logout ends the session of an access token. Tokens without a session
+// have nothing to end.
+func (s *SessionService) Logout(ctx context.Context, claims *auth.Claims) error {
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return nil
+	}
+	userID, _ := claims.UserID()
+
+	err = s.sessions.Revoke(ctx, userID, sessionID)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ForceLogout ends every session of a member of the organization, and
+// returns how many were ended.
+func (s *SessionService) ForceLogout(ctx context.Context, organizationID, userID uuid.UUID) (int, error) {
+	member, err := isMember(ctx, s.userRoles, userID, organizationID)
+	if err != nil {
+		return 0, err
+	}
+	if !member {
+		return 0, repository.ErrUserNotFound
+	}
+
+	revoked, err := s.sessions.RevokeAll(ctx, userID, uuid.Nil)
+	return len(revoked), err
+}
+
+// RevokeClient ends the sessions of an OAuth client, only those of userID
+// unless it is uuid.Nil, and returns how many were ended.
+func (s *SessionService) RevokeClient(ctx context.Context, clientID, userID uuid.UUID) (int, error) {
+	revoked, err := s.sessions.RevokeClient(ctx, clientID, userID)
+	return len(revoked), err
+}
+
+func (s *SessionService) GetPolicy(ctx context.Context, organizationID uuid.UUID) (*models.SessionPolicy, error) {
+	return s.sessions.GetPolicy(ctx, organizationID)
+}
+
+// SetPolicy changes the organization's limit of sessions per user. Members
+// above a lowered limit keep their sessions until they next log in.
+func (s *SessionService) SetPolicy(ctx context.Context, policy *models.SessionPolicy) error {
+	return s.sessions.SetPolicy(ctx, policy)
+}
+
+// end revokes a session whose refresh token may no longer be used and
+// returns ErrInvalidRefreshToken.
+func (s *SessionService) end(ctx context.Context, session *models.Session) error {
+	err := s.sessions.Revoke(ctx, session.UserID, session.ID)
+	if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		return err
+	}
+	return ErrInvalidRefreshToken
+}
+
+// startSession issues the tokens of a completed login: a session with a
+// refresh token when sessions is set, and an access token alone otherwise.
+func startSession(ctx context.Context, sessions *SessionService, tokens *auth.TokenManager, user *models.User, organizationID uuid.UUID, amr ...string) (*LoginResult, error) {
+	if sessions != nil {
+		return sessions.Start(ctx, user, organizationID, amr...)
+	}
+
+	token, err := tokens.GenerateAccessToken(user, organizationID, amr...)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{AccessToken: token}, nil
+}
+
+// newRefreshToken returns "<session ID>.<secret>", so that a replaced
+// token still identifies the session to revoke.
+func newRefreshToken(sessionID uuid.UUID) (string, error) {
+	secret, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+	return sessionID.String() + "." + secret, nil
+}
+
+func parseRefreshToken(token string) (uuid.UUID, bool) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return uuid.Nil, false
+	}
+	sessionID, err := uuid.Parse(id)
+	return sessionID, err == nil
+}
+
+// describeDevice names the browser and operating system of a user agent,
+// such as "Firefox on Windows".
+func describeDevice(userAgent string) string {
+	browser := firstMatch(userAgent, [][2]string{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	})
+	os := firstMatch(userAgent, [][2]string{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	})
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+func firstMatch(s string, candidates [][2]string) string {
+	for _, candidate := range candidates {
+		if strings.Contains(s, candidate[0]) {
+			return candidate[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeSessionRepository struct {
+	repository.SessionRepository
+	sessions map[uuid.UUID]*models.Session
+	touches  int
+}
+
+func (f *fakeSessionRepository) Create(ctx context.Context, session *models.Session) ([]uuid.UUID, error) {
+	copied := *session
+	copied.CreatedAt = time.Now()
+	copied.LastSeenAt = copied.CreatedAt
+	f.sessions[session.ID] = &copied
+	return nil, nil
+}
+
+func (f *fakeSessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil, repository.ErrSessionNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (f *fakeSessionRepository) Rotate(ctx context.Context, session *models.Session, previousHash string) error {
+	stored, ok := f.sessions[session.ID]
+	if !ok || stored.RevokedAt != nil || stored.RefreshTokenHash != previousHash {
+		return repository.ErrSessionNotFound
+	}
+	copied := *session
+	f.sessions[session.ID] = &copied
+	return nil
+}
+
+func (f *fakeSessionRepository) Touch(ctx context.Context, id uuid.UUID, lastSeenAt time.Time, ip string) error {
+	f.touches++
+	f.sessions[id].LastSeenAt = lastSeenAt
+	f.sessions[id].IPAddress = ip
+	return nil
+}
+
+func (f *fakeSessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	session, ok := f.sessions[id]
+	if !ok || session.UserID != userID || session.RevokedAt != nil {
+		return repository.ErrSessionNotFound
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	return nil
+}
+
+func (f *fakeSessionRepository) RevokeAll(ctx context.Context, userID, except uuid.UUID) ([]uuid.UUID, error) {
+	var revoked []uuid.UUID
+	for id, session := range f.sessions {
+		if session.UserID == userID && id != except && session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+			revoked = append(revoked, id)
+		}
+	}
+	return revoked, nil
+}
+
+func newTestSessionService(user *models.User, org uuid.UUID) (*SessionService, *fakeSessionRepository, *fakeMembershipRepository) {
+	sessions := &fakeSessionRepository{sessions: make(map[uuid.UUID]*models.Session)}
+	memberships := &fakeMembershipRepository{organizations: map[uuid.UUID][]models.Organization{user.ID: {{ID: org}}}}
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+	svc := NewSessionService(
+		config.SessionConfig{RefreshTokenTTL: time.Hour, TouchInterval: time.Minute},
+		sessions,
+		&fakeUserRepository{users: map[uuid.UUID]*models.User{user.ID: user}},
+		memberships,
+		tokens,
+	)
+	tokens.SetSessionValidator(svc)
+	return svc, sessions, memberships
+}
+
+func TestSessionRefreshRotatesAndDetectsReuse(t *testing.T) {
+	ctx := audit.WithActor(context.Background(), audit.Actor{
+		IPAddress: "203.0.113.7",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0",
+	})
+	org := uuid.New()
+	user := &models.User{ID: uuid.New(), Email: "admin@example.com", IsActive: true}
+	svc, sessions, _ := newTestSessionService(user, org)
+
+	started, err := svc.Start(ctx, user, org, auth.AMRPassword)
+	require.NoError(t, err)
+	claims, err := svc.tokens.ValidateAccessToken(ctx, started.AccessToken)
+	require.NoError(t, err)
+	sessionID := uuid.MustParse(claims.SessionID)
+	require.Equal(t, "Firefox on Windows", sessions.sessions[sessionID].Device)
+	require.Equal(t, "203.0.113.7", sessions.sessions[sessionID].IPAddress)
+
+	refreshed, err := svc.Refresh(ctx, started.RefreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, started.RefreshToken, refreshed.RefreshToken)
+	claims, err = svc.tokens.ValidateAccessToken(ctx, refreshed.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, sessionID.String(), claims.SessionID)
+	require.Equal(t, org.String(), claims.OrganizationID)
+	require.Contains(t, claims.AMR, auth.AMRPassword)
+
+	// Presenting the replaced token ends the session, including for the
+	// holder of the current one.
+	_, err = svc.Refresh(ctx, started.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidRefreshToken)
+	_, err = svc.Refresh(ctx, refreshed.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidRefreshToken)
+	_, err = svc.tokens.ValidateAccessToken(ctx, refreshed.AccessToken)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	_, err = svc.Refresh(ctx, "not-a-token")
+	require.ErrorIs(t, err, ErrInvalidRefreshToken)
+	_, err = svc.Refresh(ctx, uuid.NewString()+".secret")
+	require.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestSessionEndsWhenMembershipEnds(t *testing.T) {
+	ctx := context.Background()
+	org := uuid.New()
+	user := &models.User{ID: uuid.New(), Email: "admin@example.com", IsActive: true}
+	svc, sessions, memberships := newTestSessionService(user, org)
+
+	started, err := svc.Start(ctx, user, org)
+	require.NoError(t, err)
+
+	delete(memberships.organizations, user.ID)
+	_, err = svc.Refresh(ctx, started.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidRefreshToken)
+	for _, session := range sessions.sessions {
+		require.NotNil(t, session.RevokedAt)
+	}
+}
+
+func TestSessionValidationAndRevocation(t *testing.T) {
+	ctx := context.Background()
+	org := uuid.New()
+	user := &models.User{ID: uuid.New(), Email: "admin@example.com", IsActive: true}
+	svc, sessions, _ := newTestSessionService(user, org)
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	first, err := svc.Start(ctx, user, org)
+	require.NoError(t, err)
+	second, err := svc.Start(ctx, user, org)
+	require.NoError(t, err)
+	third, err := svc.Start(ctx, user, org)
+	require.NoError(t, err)
+
+	// Last-seen times are written at most once per touch interval.
+	claims, err := svc.tokens.ValidateAccessToken(ctx, first.AccessToken)
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = svc.tokens.ValidateAccessToken(ctx, first.AccessToken)
+	require.NoError(t, err)
+	_, err = svc.tokens.ValidateAccessToken(ctx, first.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, 1, sessions.touches)
+
+	current := uuid.MustParse(claims.SessionID)
+	revoked, err := svc.RevokeOthers(ctx, user.ID, current)
+	require.NoError(t, err)
+	require.Equal(t, 2, revoked)
+	_, err = svc.tokens.ValidateAccessToken(ctx, second.AccessToken)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+	_, err = svc.Refresh(ctx, third.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	_, err = svc.ForceLogout(ctx, uuid.New(), user.ID)
+	require.ErrorIs(t, err, repository.ErrUserNotFound)
+	_, err = svc.tokens.ValidateAccessToken(ctx, first.AccessToken)
+	require.NoError(t, err)
+
+	revoked, err = svc.ForceLogout(ctx, org, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, revoked)
+	_, err = svc.tokens.ValidateAccessToken(ctx, first.AccessToken)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	// Logging out of an ended session is not an error.
+	require.NoError(t, svc.Logout(ctx, claims))
+}
+
+func TestDescribeDevice(t *testing.T) {
+	require.Equal(t, "Chrome on macOS", describeDevice("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"))
+	require.Equal(t, "Safari on iOS", describeDevice("Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"))
+	require.Equal(t, "Edge on Windows", describeDevice("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0"))
+	require.Equal(t, "curl", describeDevice("curl/8.8.0"))
+	require.Equal(t, "Unknown device", describeDevice(""))
+}
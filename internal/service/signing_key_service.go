@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+	"user-management/internal/secrets"
+)
+
+var ErrSigningKeyNotFound = errors.New("signing key not found")
+
+const (
+	defaultKeyAlgorithm        = oidc.AlgorithmRS256
+	defaultKeyRotationInterval = 30 * 24 * time.Hour
+	defaultKeyRetention        = 48 * time.Hour
+	// signingKeyReloadInterval bounds how long an instance signs with a
+	// key that another instance has retired.
+	signingKeyReloadInterval = time.Minute
+)
+
+// SigningKeyService keeps the keys that tokens are signed with. The active
+// key signs. It, the next key and retired keys that have not expired are
+// published, so that verifiers know a key before it signs and tokens signed
+// before a rotation verify until they expire. Private keys are encrypted
+// at rest. Keys are cached in memory and reloaded periodically, so that
+// instances pick up rotations made by others.
+type SigningKeyService struct {
+	keys             repository.SigningKeyRepository
+	cipher           *secrets.Cipher
+	algorithm        string
+	rotationInterval time.Duration
+	retention        time.Duration
+	now              func() time.Time
+
+	mu          sync.RWMutex
+	signing     *oidc.SigningKey
+	activatedAt time.Time
+	hasNext     bool
+	jwks        *oidc.JWKS
+	loadedAt    time.Time
+	// missLoadAt is when an unknown key ID last caused a reload.
+	missLoadAt time.Time
+}
+
+func NewSigningKeyService(conf config.SigningKeysConfig, keys repository.SigningKeyRepository) (*SigningKeyService, error) {
+	algorithm := conf.Algorithm
+	if algorithm == "" {
+		algorithm = defaultKeyAlgorithm
+	}
+	if !slices.Contains(oidc.SigningAlgorithms, algorithm) {
+		return nil, fmt.Errorf("unsupported signing key algorithm %q", algorithm)
+	}
+	cipher, err := secrets.NewCipher(conf.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key encryption key: %w", err)
+	}
+	rotationInterval := conf.RotationInterval
+	if rotationInterval <= 0 {
+		rotationInterval = defaultKeyRotationInterval
+	}
+	retention := conf.Retention
+	if retention <= 0 {
+		retention = defaultKeyRetention
+	}
+
+	return &SigningKeyService{
+		keys:             keys,
+		cipher:           cipher,
+		algorithm:        algorithm,
+		rotationInterval: rotationInterval,
+		retention:        retention,
+		now:              time.Now,
+	}, nil
+}
+
+// SigningKey returns the key to sign with, creating the first key when
+// there is none.
+func (s *SigningKeyService) SigningKey(ctx context.Context) (*oidc.SigningKey, error) {
+	if err := s.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.signing, nil
+}
+
+// JWKS returns the public keys that tokens may be signed with.
+func (s *SigningKeyService) JWKS(ctx context.Context) (*oidc.JWKS, error) {
+	if err := s.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.jwks, nil
+}
+
+// PublicKey returns the published key with the given ID. Unknown IDs cause
+// a reload, since another instance may have rotated, but at most one per
+// reload interval, so that tokens with made-up key IDs cannot make every
+// request read the keys.
+func (s *SigningKeyService) PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	jwks, err := s.JWKS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := jwks.Key(keyID); ok {
+		return key, nil
+	}
+
+	s.mu.Lock()
+	due := s.now().Sub(s.missLoadAt) >= signingKeyReloadInterval
+	if due {
+		s.missLoadAt = s.now()
+	}
+	s.mu.Unlock()
+	if !due {
+		return nil, ErrSigningKeyNotFound
+	}
+
+	if err := s.Load(ctx); err != nil {
+		return nil, err
+	}
+	jwks, _ = s.JWKS(ctx)
+	if key, ok := jwks.Key(keyID); ok {
+		return key, nil
+	}
+	return nil, ErrSigningKeyNotFound
+}
+
+// List returns the keys that are next, active or retired but still
+// published, newest first.
+func (s *SigningKeyService) List(ctx context.Context) ([]models.SigningKey, error) {
+	return s.keys.ListValid(ctx)
+}
+
+// Rotate activates the next key when the active one is older than the
+// rotation interval, or right away when force is set, and creates a new
+// next key. It reports whether the keys were rotated.
+func (s *SigningKeyService) Rotate(ctx context.Context, force bool) (bool, error) {
+	activatedBefore := s.now().Add(-s.rotationInterval)
+	if force {
+		activatedBefore = s.now()
+	}
+	return s.rotate(ctx, activatedBefore)
+}
+
+func (s *SigningKeyService) rotate(ctx context.Context, activatedBefore time.Time) (bool, error) {
+	key, err := s.generate()
+	if err != nil {
+		return false, err
+	}
+	rotated, err := s.keys.Rotate(ctx, key, activatedBefore, s.retention)
+	if err != nil {
+		return false, err
+	}
+	if err := s.ensureNext(ctx); err != nil {
+		return false, err
+	}
+
+	return rotated, s.Load(ctx)
+}
+
+// ensureNext creates the next key when there is none.
+func (s *SigningKeyService) ensureNext(ctx context.Context) error {
+	key, err := s.generate()
+	if err != nil {
+		return err
+	}
+	_, err = s.keys.CreateNext(ctx, key)
+	return err
+}
+
+// generate creates a key with the configured algorithm, encrypted for
+// storage.
+func (s *SigningKeyService) generate() (*models.SigningKey, error) {
+	generated, err := oidc.GenerateSigningKey(s.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := generated.EncodePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.cipher.Encrypt([]byte(encoded), []byte(generated.ID))
+	if err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{ID: generated.ID, Algorithm: generated.Algorithm, PrivateKey: encrypted}, nil
+}
+
+// Load reads the keys from the repository.
+func (s *SigningKeyService) Load(ctx context.Context) error {
+	keys, err := s.keys.ListValid(ctx)
+	if err != nil {
+		return err
+	}
+
+	var signing *oidc.SigningKey
+	var activatedAt time.Time
+	hasNext := false
+	jwks := &oidc.JWKS{Keys: []oidc.JWK{}}
+	for _, key := range keys {
+		decoded, err := s.decode(&key)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.ID, err)
+		}
+		switch key.State {
+		case models.SigningKeyStateActive:
+			signing = decoded
+			if key.ActivatedAt != nil {
+				activatedAt = *key.ActivatedAt
+			}
+		case models.SigningKeyStateNext:
+			hasNext = true
+		}
+		jwk, err := oidc.NewJWK(key.ID, decoded.Public())
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.ID, err)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signing = signing
+	s.activatedAt = activatedAt
+	s.hasNext = hasNext
+	s.jwks = jwks
+	s.loadedAt = s.now()
+	return nil
+}
+
+// decode decrypts a stored key. Keys stored before encryption was
+// introduced are plain PEM.
+func (s *SigningKeyService) decode(key *models.SigningKey) (*oidc.SigningKey, error) {
+	encoded := key.PrivateKey
+	if secrets.IsEncrypted(encoded) {
+		decrypted, err := s.cipher.Decrypt(encoded, []byte(key.ID))
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(decrypted)
+	}
+	return oidc.DecodeSigningKey(key.ID, key.Algorithm, encoded)
+}
+
+// Run rotates the keys when it is due and reloads them every interval
+// until ctx is cancelled.
+func (s *SigningKeyService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Load(ctx); err != nil {
+				log.Printf("failed to load signing keys: %v\n", err)
+				continue
+			}
+			s.mu.RLock()
+			due := s.signing == nil || s.now().Sub(s.activatedAt) >= s.rotationInterval
+			hasNext := s.hasNext
+			s.mu.RUnlock()
+			if due {
+				if _, err := s.Rotate(ctx, false); err != nil {
+					log.Printf("failed to rotate signing keys: %v\n", err)
+				}
+				continue
+			}
+			if !hasNext {
+				if err := s.ensureNext(ctx); err != nil {
+					log.Printf("failed to create next signing key: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *SigningKeyService) ensureLoaded(ctx context.Context) error {
+	s.mu.RLock()
+	fresh := s.signing != nil && s.now().Sub(s.loadedAt) < signingKeyReloadInterval
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	if err := s.Load(ctx); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	missing := s.signing == nil
+	s.mu.RUnlock()
+	if missing {
+		// Any active key that another instance created meanwhile will do.
+		_, err := s.rotate(ctx, time.Time{})
+		return err
+	}
+	return nil
+}
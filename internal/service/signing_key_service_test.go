@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+	"user-management/internal/secrets"
+)
+
+// fakeSigningKeyRepository keeps keys in memory with the state transitions
+// of the real repository.
+type fakeSigningKeyRepository struct {
+	repository.SigningKeyRepository
+	keys  []models.SigningKey
+	now   func() time.Time
+	loads int
+}
+
+func (f *fakeSigningKeyRepository) ListValid(ctx context.Context) ([]models.SigningKey, error) {
+	f.loads++
+	var valid []models.SigningKey
+	for _, key := range f.keys {
+		if key.State != models.SigningKeyStateRetired || key.ExpiresAt.After(f.now()) {
+			valid = append(valid, key)
+		}
+	}
+	return valid, nil
+}
+
+func (f *fakeSigningKeyRepository) Rotate(ctx context.Context, key *models.SigningKey, activatedBefore time.Time, retention time.Duration) (bool, error) {
+	for _, existing := range f.keys {
+		if existing.State == models.SigningKeyStateActive && !existing.ActivatedAt.Before(activatedBefore) {
+			return false, nil
+		}
+	}
+
+	now := f.now()
+	expiresAt := now.Add(retention)
+	key.State = models.SigningKeyStateActive
+	key.ActivatedAt = &now
+	for i := range f.keys {
+		switch f.keys[i].State {
+		case models.SigningKeyStateActive:
+			f.keys[i].State = models.SigningKeyStateRetired
+			f.keys[i].RetiredAt = &now
+			f.keys[i].ExpiresAt = &expiresAt
+		case models.SigningKeyStateNext:
+			f.keys[i].State = models.SigningKeyStateActive
+			f.keys[i].ActivatedAt = &now
+			key.State = models.SigningKeyStateNext
+			key.ActivatedAt = nil
+		}
+	}
+	key.CreatedAt = now
+	f.keys = append([]models.SigningKey{*key}, f.keys...)
+	return true, nil
+}
+
+func (f *fakeSigningKeyRepository) CreateNext(ctx context.Context, key *models.SigningKey) (bool, error) {
+	for _, existing := range f.keys {
+		if existing.State == models.SigningKeyStateNext {
+			return false, nil
+		}
+	}
+	key.State = models.SigningKeyStateNext
+	key.CreatedAt = f.now()
+	f.keys = append([]models.SigningKey{*key}, f.keys...)
+	return true, nil
+}
+
+func (f *fakeSigningKeyRepository) inState(state string) *models.SigningKey {
+	for i := range f.keys {
+		if f.keys[i].State == state {
+			return &f.keys[i]
+		}
+	}
+	return nil
+}
+
+func newTestSigningKeyService(t *testing.T, algorithm string) (*SigningKeyService, *fakeSigningKeyRepository, *time.Time) {
+	encryptionKey, err := secrets.GenerateKey()
+	require.NoError(t, err)
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+	repo := &fakeSigningKeyRepository{now: clock}
+	keys, err := NewSigningKeyService(config.SigningKeysConfig{
+		Algorithm:        algorithm,
+		RotationInterval: 24 * time.Hour,
+		Retention:        time.Hour,
+		EncryptionKey:    encryptionKey,
+	}, repo)
+	require.NoError(t, err)
+	keys.now = clock
+	return keys, repo, &now
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	for _, algorithm := range oidc.SigningAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			ctx := context.Background()
+			keys, repo, now := newTestSigningKeyService(t, algorithm)
+			tokens := auth.NewTokenManager(config.JWTConfig{Issuer: "test", AccessTokenTTL: 48 * time.Hour})
+			tokens.SetKeySource(keys)
+
+			// The first use creates the active key and publishes the next.
+			first, err := keys.SigningKey(ctx)
+			require.NoError(t, err)
+			require.Equal(t, algorithm, first.Algorithm)
+			next := repo.inState(models.SigningKeyStateNext)
+			require.NotNil(t, next)
+			jwks, err := keys.JWKS(ctx)
+			require.NoError(t, err)
+			require.Len(t, jwks.Keys, 2)
+			for _, key := range repo.keys {
+				require.True(t, secrets.IsEncrypted(key.PrivateKey))
+				require.NotContains(t, key.PrivateKey, "PRIVATE KEY")
+			}
+
+			token, err := tokens.GenerateAccessToken(&models.User{ID: [16]byte{1}}, [16]byte{2})
+			require.NoError(t, err)
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+			require.NoError(t, err)
+			require.Equal(t, algorithm, parsed.Method.Alg())
+			require.Equal(t, first.ID, parsed.Header["kid"])
+
+			// Rotation is not due yet.
+			rotated, err := keys.Rotate(ctx, false)
+			require.NoError(t, err)
+			require.False(t, rotated)
+
+			// Forcing it activates the published next key and keeps the
+			// retired key verifying.
+			*now = now.Add(time.Minute)
+			rotated, err = keys.Rotate(ctx, true)
+			require.NoError(t, err)
+			require.True(t, rotated)
+			second, err := keys.SigningKey(ctx)
+			require.NoError(t, err)
+			require.Equal(t, next.ID, second.ID)
+			require.NotNil(t, repo.inState(models.SigningKeyStateNext))
+			jwks, err = keys.JWKS(ctx)
+			require.NoError(t, err)
+			require.Len(t, jwks.Keys, 3)
+			_, err = tokens.ValidateAccessToken(ctx, token)
+			require.NoError(t, err)
+
+			// Once the retired key expires, its tokens no longer verify.
+			*now = now.Add(2 * time.Hour)
+			require.NoError(t, keys.Load(ctx))
+			_, err = tokens.ValidateAccessToken(ctx, token)
+			require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+			// Scheduled rotation happens once the active key is due.
+			*now = now.Add(24 * time.Hour)
+			rotated, err = keys.Rotate(ctx, false)
+			require.NoError(t, err)
+			require.True(t, rotated)
+		})
+	}
+}
+
+func TestSigningKeyUnknownIDReloads(t *testing.T) {
+	ctx := context.Background()
+	keys, repo, now := newTestSigningKeyService(t, oidc.AlgorithmES256)
+	_, err := keys.SigningKey(ctx)
+	require.NoError(t, err)
+
+	key, err := keys.generate()
+	require.NoError(t, err)
+
+	loads := repo.loads
+	_, err = keys.PublicKey(ctx, "unknown")
+	require.ErrorIs(t, err, ErrSigningKeyNotFound)
+	require.Equal(t, loads+1, repo.loads)
+
+	// Another instance creates a key after this one loaded.
+	key.State = models.SigningKeyStateNext
+	repo.keys = append(repo.keys, *key)
+	for range 3 {
+		_, err = keys.PublicKey(ctx, key.ID)
+		require.ErrorIs(t, err, ErrSigningKeyNotFound, "unknown IDs reload once per interval")
+	}
+	require.Equal(t, loads+1, repo.loads)
+
+	*now = now.Add(signingKeyReloadInterval)
+	_, err = keys.PublicKey(ctx, key.ID)
+	require.NoError(t, err)
+}
+
+func TestSigningKeysAreNotInterchangeable(t *testing.T) {
+	ctx := context.Background()
+	keys, _, _ := newTestSigningKeyService(t, oidc.AlgorithmES256)
+	tokens := auth.NewTokenManager(config.JWTConfig{Secret: "test", Issuer: "test", AccessTokenTTL: time.Minute})
+
+	// HMAC tokens are no longer accepted once the manager uses the keys.
+	token, err := tokens.GenerateAccessToken(&models.User{ID: [16]byte{1}}, [16]byte{2})
+	require.NoError(t, err)
+	tokens.SetKeySource(keys)
+	_, err = tokens.ValidateAccessToken(ctx, token)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	// Tokens with an audience or type, as the OpenID Connect provider
+	// issues with the same keys, cannot stand in for a login.
+	key, err := keys.SigningKey(ctx)
+	require.NoError(t, err)
+	claims := jwt.RegisteredClaims{
+		Issuer:    "test",
+		Subject:   "00000000-0000-0000-0000-000000000001",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+	accessToken, err := key.Sign(claims, oidc.TokenTypeAccessToken)
+	require.NoError(t, err)
+	_, err = tokens.ValidateAccessToken(ctx, accessToken)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+	claims.Audience = jwt.ClaimStrings{"client"}
+	idToken, err := key.Sign(claims, "")
+	require.NoError(t, err)
+	_, err = tokens.ValidateAccessToken(ctx, idToken)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	claims.Audience = nil
+	login, err := key.Sign(claims, "")
+	require.NoError(t, err)
+	_, err = tokens.ValidateAccessToken(ctx, login)
+	require.NoError(t, err)
+}
+
+func TestSigningKeyLegacyAndConfig(t *testing.T) {
+	ctx := context.Background()
+	keys, repo, _ := newTestSigningKeyService(t, oidc.AlgorithmRS256)
+
+	// Keys stored before encryption are plain PEM.
+	legacy, err := oidc.GenerateSigningKey(oidc.AlgorithmRS256)
+	require.NoError(t, err)
+	encoded, err := legacy.EncodePrivateKey()
+	require.NoError(t, err)
+	activatedAt := time.Now()
+	repo.keys = []models.SigningKey{{ID: legacy.ID, Algorithm: oidc.AlgorithmRS256, State: models.SigningKeyStateActive, PrivateKey: encoded, ActivatedAt: &activatedAt}}
+	key, err := keys.SigningKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, legacy.ID, key.ID)
+
+	_, err = NewSigningKeyService(config.SigningKeysConfig{Algorithm: "HS256", EncryptionKey: strings.Repeat("A", 44)}, repo)
+	require.Error(t, err)
+	_, err = NewSigningKeyService(config.SigningKeysConfig{}, repo)
+	require.Error(t, err)
+}
@@ -0,0 +1,175 @@
+package siem
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"user-management/internal/audit"
+	"user-management/internal/models"
+)
+
+const (
+	FormatJSONLines = "jsonl"
+	FormatCEF       = "cef"
+	FormatSyslog    = "syslog"
+
+	vendor  = "user-management"
+	product = "user-management"
+	version = "1.0"
+)
+
+// Formatter renders a single audit event as one record, without a trailing
+// newline.
+type Formatter interface {
+	Format(event *models.AuditEvent) ([]byte, error)
+	ContentType() string
+	FileExtension() string
+}
+
+func NewFormatter(format string) (Formatter, error) {
+	switch format {
+	case FormatJSONLines:
+		return jsonLinesFormatter{}, nil
+	case FormatCEF:
+		return cefFormatter{}, nil
+	case FormatSyslog:
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "-"
+		}
+		return syslogFormatter{hostname: hostname}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+type jsonLinesFormatter struct{}
+
+func (jsonLinesFormatter) Format(event *models.AuditEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonLinesFormatter) ContentType() string   { return "application/x-ndjson" }
+func (jsonLinesFormatter) FileExtension() string { return "jsonl" }
+
+// cefFormatter renders ArcSight Common Event Format records.
+type cefFormatter struct{}
+
+func (cefFormatter) Format(event *models.AuditEvent) ([]byte, error) {
+	header := strings.Join([]string{
+		"CEF:0",
+		cefHeader(vendor),
+		cefHeader(product),
+		cefHeader(version),
+		cefHeader(event.Action),
+		cefHeader(event.Action + " " + event.TargetType),
+		strconv.Itoa(severity(event.Action)),
+	}, "|")
+
+	extensions := []string{
+		"externalId=" + cefValue(strconv.FormatInt(event.ID, 10)),
+		"rt=" + strconv.FormatInt(event.CreatedAt.UnixMilli(), 10),
+		"suser=" + cefValue(audit.ChainKey(event.ActorID)),
+		"cs1Label=organizationId",
+		"cs1=" + cefValue(audit.ChainKey(event.OrganizationID)),
+		"cs2Label=targetType",
+		"cs2=" + cefValue(event.TargetType),
+		"cs3Label=targetId",
+		"cs3=" + cefValue(event.TargetID),
+		"cs4Label=hash",
+		"cs4=" + cefValue(event.Hash),
+	}
+	if event.IPAddress != "" {
+		extensions = append(extensions, "src="+cefValue(event.IPAddress))
+	}
+	if event.UserAgent != "" {
+		extensions = append(extensions, "requestClientApplication="+cefValue(event.UserAgent))
+	}
+	if len(event.Changes) > 0 {
+		extensions = append(extensions, "msg="+cefValue(string(event.Changes)))
+	}
+
+	return []byte(header + "|" + strings.Join(extensions, " ")), nil
+}
+
+func (cefFormatter) ContentType() string   { return "text/plain; charset=utf-8" }
+func (cefFormatter) FileExtension() string { return "cef" }
+
+func cefHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+func cefValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+// syslogFormatter renders RFC 5424 messages with the event metadata as
+// structured data and the change set as the message body.
+type syslogFormatter struct {
+	hostname string
+}
+
+// Facility 13 is "log audit"; severity 5 is notice.
+const syslogPriority = 13*8 + 5
+
+func (f syslogFormatter) Format(event *models.AuditEvent) ([]byte, error) {
+	params := []string{
+		sdParam("id", strconv.FormatInt(event.ID, 10)),
+		sdParam("org", audit.ChainKey(event.OrganizationID)),
+		sdParam("actor", audit.ChainKey(event.ActorID)),
+		sdParam("target_type", event.TargetType),
+		sdParam("target_id", event.TargetID),
+		sdParam("hash", event.Hash),
+	}
+	if event.IPAddress != "" {
+		params = append(params, sdParam("ip", event.IPAddress))
+	}
+
+	message := fmt.Sprintf("<%d>1 %s %s %s - %s [audit@32473 %s]",
+		syslogPriority,
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		f.hostname,
+		vendor,
+		syslogToken(event.Action),
+		strings.Join(params, " "),
+	)
+	if len(event.Changes) > 0 {
+		message += " " + string(event.Changes)
+	}
+
+	return []byte(message), nil
+}
+
+func (syslogFormatter) ContentType() string   { return "text/plain; charset=utf-8" }
+func (syslogFormatter) FileExtension() string { return "log" }
+
+func sdParam(name, value string) string {
+	return name + `="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "]", `\]`).Replace(value) + `"`
+}
+
+// syslogToken restricts value to the printable US-ASCII characters allowed in
+// RFC 5424 header fields such as MSGID.
+func syslogToken(value string) string {
+	if value == "" {
+		return "-"
+	}
+	token := []byte(value)
+	for i, c := range token {
+		if c < 33 || c > 126 {
+			token[i] = '_'
+		}
+	}
+	if len(token) > 32 {
+		token = token[:32]
+	}
+	return string(token)
+}
+
+func severity(action string) int {
+	if strings.HasSuffix(action, ".deleted") || strings.HasSuffix(action, ".revoked") {
+		return 7
+	}
+	return 5
+}
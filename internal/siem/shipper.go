@@ -0,0 +1,86 @@
+package siem
+
+import (
+	"context"
+	"log"
+	"time"
+	"user-management/internal/repository"
+)
+
+const shipBatchSize = 500
+
+// Shipper continuously forwards audit events to a sink. Its position is
+// persisted under name after every batch, so a restart resumes after the
+// last batch that was written rather than resending the backlog; records of
+// a batch that failed part way are sent again.
+type Shipper struct {
+	name      string
+	audit     repository.AuditRepository
+	formatter Formatter
+	sink      Sink
+}
+
+func NewShipper(name string, audit repository.AuditRepository, formatter Formatter, sink Sink) *Shipper {
+	return &Shipper{
+		name:      name,
+		audit:     audit,
+		formatter: formatter,
+		sink:      sink,
+	}
+}
+
+// Ship forwards every event recorded since the last call and returns how
+// many were sent. Events of transactions that are still in progress, and of
+// transactions that started after them, wait for a later call.
+func (s *Shipper) Ship(ctx context.Context) (int, error) {
+	cursor, err := s.audit.GetExportCursor(ctx, s.name)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for {
+		events, next, err := s.audit.ListForExport(ctx, cursor, shipBatchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		for i := range events {
+			record, err := s.formatter.Format(&events[i])
+			if err != nil {
+				return sent, err
+			}
+			if err := s.sink.Write(record); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+
+		if len(events) > 0 {
+			cursor = next
+			if err := s.audit.SaveExportCursor(ctx, s.name, cursor); err != nil {
+				return sent, err
+			}
+		}
+		if len(events) < shipBatchSize {
+			return sent, nil
+		}
+	}
+}
+
+func (s *Shipper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Ship(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to ship audit events to %s: %v\n", s.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package siem
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+type fakeAuditRepository struct {
+	repository.AuditRepository
+	events []models.AuditEvent
+	// transactions maps events to the transactions that recorded them, 1
+	// when not set. Events of running transactions are not visible.
+	transactions map[int64]uint64
+	running      map[uint64]bool
+	cursors      map[string]models.AuditExportCursor
+	saves        int
+}
+
+func (f *fakeAuditRepository) transaction(event models.AuditEvent) uint64 {
+	if transactionID, ok := f.transactions[event.ID]; ok {
+		return transactionID
+	}
+	return 1
+}
+
+func (f *fakeAuditRepository) ListForExport(ctx context.Context, after models.AuditExportCursor, limit int) ([]models.AuditEvent, models.AuditExportCursor, error) {
+	horizon := uint64(math.MaxUint64)
+	for transactionID := range f.running {
+		horizon = min(horizon, transactionID)
+	}
+
+	var exportable []models.AuditEvent
+	for _, event := range f.events {
+		transactionID := f.transaction(event)
+		if transactionID >= horizon {
+			continue
+		}
+		if transactionID > after.TransactionID || transactionID == after.TransactionID && event.ID > after.EventID {
+			exportable = append(exportable, event)
+		}
+	}
+	slices.SortFunc(exportable, func(a, b models.AuditEvent) int {
+		return cmp.Or(cmp.Compare(f.transaction(a), f.transaction(b)), cmp.Compare(a.ID, b.ID))
+	})
+
+	exportable = exportable[:min(limit, len(exportable))]
+	cursor := after
+	if len(exportable) > 0 {
+		last := exportable[len(exportable)-1]
+		cursor = models.AuditExportCursor{TransactionID: f.transaction(last), EventID: last.ID}
+	}
+	return exportable, cursor, nil
+}
+
+func (f *fakeAuditRepository) GetExportCursor(ctx context.Context, name string) (models.AuditExportCursor, error) {
+	return f.cursors[name], nil
+}
+
+func (f *fakeAuditRepository) SaveExportCursor(ctx context.Context, name string, cursor models.AuditExportCursor) error {
+	f.cursors[name] = cursor
+	f.saves++
+	return nil
+}
+
+func testEvent(id int64) models.AuditEvent {
+	org := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	return models.AuditEvent{
+		ID:             id,
+		OrganizationID: &org,
+		Action:         "user.updated",
+		TargetType:     "user",
+		TargetID:       "a|b=c",
+		Changes:        json.RawMessage(`{"bio":{"new":"x]y"}}`),
+		IPAddress:      "10.0.0.1",
+		CreatedAt:      time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	event := testEvent(7)
+
+	cef, err := NewFormatter(FormatCEF)
+	require.NoError(t, err)
+	record, err := cef.Format(&event)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(record), "CEF:0|user-management|user-management|1.0|user.updated|user.updated user|5|externalId=7 "))
+	require.Contains(t, string(record), `cs3=a|b\=c`)
+
+	syslog, err := NewFormatter(FormatSyslog)
+	require.NoError(t, err)
+	record, err = syslog.Format(&event)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(record), "<109>1 2026-01-02T03:04:05Z "))
+	require.Contains(t, string(record), ` user-management - user.updated [audit@32473 id="7" org="11111111-1111-1111-1111-111111111111"`)
+
+	jsonl, err := NewFormatter(FormatJSONLines)
+	require.NoError(t, err)
+	record, err = jsonl.Format(&event)
+	require.NoError(t, err)
+	require.NotContains(t, string(record), "\n")
+
+	_, err = NewFormatter("xml")
+	require.Error(t, err)
+}
+
+func TestShipperResumesFromCursorAndRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewRotatingFileSink(path, 300, 2)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	repo := &fakeAuditRepository{cursors: map[string]models.AuditExportCursor{}}
+	formatter, _ := NewFormatter(FormatJSONLines)
+	shipper := NewShipper("siem", repo, formatter, sink)
+	ctx := context.Background()
+
+	repo.events = []models.AuditEvent{testEvent(1), testEvent(2)}
+	sent, err := shipper.Ship(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+	require.Equal(t, models.AuditExportCursor{TransactionID: 1, EventID: 2}, repo.cursors["siem"])
+	require.Equal(t, 1, repo.saves, "the cursor is saved once per batch")
+
+	sent, err = shipper.Ship(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, sent)
+
+	repo.events = append(repo.events, testEvent(3))
+	sent, err = shipper.Ship(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+
+	var ids []string
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var event models.AuditEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &event))
+			ids = append(ids, event.TargetID)
+		}
+	}
+	require.Len(t, ids, 3)
+}
+
+func TestShipperWaitsForTransactionsInProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewRotatingFileSink(path, 1<<20, 1)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	// Event 1 was recorded by a transaction that has not committed yet
+	// when event 2 of another chain is committed.
+	repo := &fakeAuditRepository{
+		events:       []models.AuditEvent{testEvent(1), testEvent(2), testEvent(3)},
+		transactions: map[int64]uint64{1: 11, 2: 10, 3: 12},
+		running:      map[uint64]bool{11: true},
+		cursors:      map[string]models.AuditExportCursor{},
+	}
+	formatter, _ := NewFormatter(FormatJSONLines)
+	shipper := NewShipper("siem", repo, formatter, sink)
+	ctx := context.Background()
+
+	sent, err := shipper.Ship(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent, "events of later transactions wait for the one in progress")
+
+	delete(repo.running, 11)
+	sent, err = shipper.Ship(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var ids []int64
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event models.AuditEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		ids = append(ids, event.ID)
+	}
+	require.Equal(t, []int64{2, 1, 3}, ids)
+}
@@ -0,0 +1,127 @@
+package siem
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// Sink receives formatted audit records one at a time.
+type Sink interface {
+	Write(record []byte) error
+	Close() error
+}
+
+type socketSink struct {
+	conn net.Conn
+}
+
+// NewSocketSink sends every record as a single datagram, for example to a
+// syslog daemon over "udp" or to the local "/dev/log" over "unixgram".
+func NewSocketSink(network, address string) (Sink, error) {
+	if network != "udp" && network != "unixgram" {
+		return nil, fmt.Errorf("unsupported sink network %q", network)
+	}
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s %s: %w", network, address, err)
+	}
+	return &socketSink{conn: conn}, nil
+}
+
+func (s *socketSink) Write(record []byte) error {
+	if _, err := s.conn.Write(record); err != nil {
+		return fmt.Errorf("failed to send audit record: %w", err)
+	}
+	return nil
+}
+
+func (s *socketSink) Close() error {
+	return s.conn.Close()
+}
+
+// RotatingFileSink appends newline terminated records to a file, rotating it
+// to path.1, path.2, ... once it would grow beyond maxSize bytes.
+type RotatingFileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewRotatingFileSink(path string, maxSize int64, maxBackups int) (*RotatingFileSink, error) {
+	sink := &RotatingFileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *RotatingFileSink) Write(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line := make([]byte, len(record)+1)
+	copy(line, record)
+	line[len(record)] = '\n'
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *RotatingFileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open export file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat export file: %w", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *RotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close export file: %w", err)
+	}
+
+	if s.maxBackups > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate export file: %w", err)
+		}
+	} else if err := os.Truncate(s.path, 0); err != nil {
+		return fmt.Errorf("failed to truncate export file: %w", err)
+	}
+
+	return s.open()
+}
@@ -0,0 +1,238 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+const (
+	// TestEventType is the type of events sent by SendTest.
+	TestEventType = "webhook.test"
+
+	minRetryDelay   = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+	claimLease      = 2 * time.Minute
+	maxResponseBody = 1024
+)
+
+// RetryDelay returns how long to wait after the given failed attempt: thirty
+// seconds doubling up to six hours.
+func RetryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+type DelivererOptions struct {
+	// MaxAttempts is how many times a delivery is tried before it is marked
+	// failed.
+	MaxAttempts int
+	// DisableAfter is how many failed attempts in a row disable a
+	// subscription. Zero never disables.
+	DisableAfter int
+	BatchSize    int
+	Timeout      time.Duration
+	// AllowPrivateNetworks lets deliveries reach loopback, private and other
+	// non-public addresses, which are refused by default.
+	AllowPrivateNetworks bool
+}
+
+// Deliverer sends pending webhook deliveries, signed with the secret of
+// their subscription, and records every attempt.
+type Deliverer struct {
+	webhooks repository.WebhookRepository
+	client   *http.Client
+	options  DelivererOptions
+}
+
+func NewDeliverer(webhooks repository.WebhookRepository, options DelivererOptions) *Deliverer {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 10
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	return &Deliverer{
+		webhooks: webhooks,
+		client:   newClient(options.Timeout, options.AllowPrivateNetworks),
+		options:  options,
+	}
+}
+
+// DeliverDue attempts every delivery that is due and returns how many
+// succeeded.
+func (d *Deliverer) DeliverDue(ctx context.Context) (int, error) {
+	succeeded := 0
+	for {
+		deliveries, err := d.webhooks.ClaimDueDeliveries(ctx, d.options.BatchSize, claimLease)
+		if err != nil {
+			return succeeded, err
+		}
+
+		subscriptions := make(map[uuid.UUID]*models.WebhookSubscription)
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			subscription, ok := subscriptions[delivery.SubscriptionID]
+			if !ok {
+				if subscription, err = d.webhooks.GetByID(ctx, delivery.SubscriptionID); err != nil {
+					return succeeded, err
+				}
+				subscriptions[delivery.SubscriptionID] = subscription
+			}
+			if !subscription.IsActive {
+				// The subscription was disabled after the delivery was
+				// claimed, so it is given up without an attempt.
+				delivery.Status = models.WebhookDeliveryFailed
+				delivery.Error = "subscription is disabled"
+				if _, err := d.webhooks.CompleteDelivery(ctx, delivery, d.options.DisableAfter); err != nil {
+					return succeeded, err
+				}
+				continue
+			}
+
+			d.attempt(ctx, subscription, delivery)
+			if delivery.Status == models.WebhookDeliveryPending {
+				delivery.NextAttemptAt = time.Now().Add(RetryDelay(delivery.Attempts))
+			}
+
+			disabled, err := d.webhooks.CompleteDelivery(ctx, delivery, d.options.DisableAfter)
+			if err != nil {
+				return succeeded, err
+			}
+			if disabled {
+				subscription.IsActive = false
+				log.Printf("webhook %s disabled after repeated failures\n", subscription.ID)
+			}
+			if delivery.Status == models.WebhookDeliverySucceeded {
+				succeeded++
+			}
+		}
+
+		if len(deliveries) < d.options.BatchSize {
+			return succeeded, nil
+		}
+	}
+}
+
+func (d *Deliverer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to deliver webhooks: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendTest immediately sends a webhook.test event to subscription, whether
+// or not it is active, and records the attempt in its delivery history.
+// Test deliveries are not retried and do not count towards disabling.
+func (d *Deliverer) SendTest(ctx context.Context, subscription *models.WebhookSubscription) (*models.WebhookDelivery, error) {
+	event := models.DomainEvent{
+		ID:             uuid.New(),
+		Type:           TestEventType,
+		AggregateType:  "webhook",
+		AggregateID:    subscription.ID.String(),
+		OrganizationID: models.UUIDPtr(subscription.OrganizationID),
+		Data:           json.RawMessage(`{"message":"This is a test event."}`),
+		OccurredAt:     time.Now().UTC(),
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	delivery := &models.WebhookDelivery{
+		SubscriptionID: subscription.ID,
+		EventID:        event.ID,
+		EventType:      event.Type,
+		Payload:        payload,
+	}
+	d.attempt(ctx, subscription, delivery)
+	if delivery.Status == models.WebhookDeliveryPending {
+		delivery.Status = models.WebhookDeliveryFailed
+	}
+
+	if err := d.webhooks.CreateDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// attempt sends delivery once and fills in its outcome. A failed attempt
+// leaves the delivery pending until it has used up its attempts.
+func (d *Deliverer) attempt(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) {
+	start := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &start
+	delivery.NextAttemptAt = start
+	delivery.ResponseStatus = 0
+	delivery.Error = ""
+
+	err := d.send(ctx, subscription, delivery, start)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+
+	switch {
+	case err == nil:
+		delivery.Status = models.WebhookDeliverySucceeded
+	case delivery.Attempts >= d.options.MaxAttempts:
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.Error = err.Error()
+	}
+}
+
+func (d *Deliverer) send(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery, now time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user-management-webhooks/1.0")
+	req.Header.Set(HeaderID, delivery.EventID.String())
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(now.Unix()))
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, now, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The body is not kept, so that endpoints cannot be read through the
+	// delivery history; reading a little of it lets the connection be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+	delivery.ResponseStatus = resp.StatusCode
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+// Matches reports whether a subscription filtered on eventTypes receives
+// eventType. Filters are exact types, "<prefix>.*" or "*"; an empty filter
+// list receives every event.
+func Matches(eventTypes []string, eventType string) bool {
+	if len(eventTypes) == 0 {
+		return true
+	}
+	for _, filter := range eventTypes {
+		if filter == "*" || filter == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(filter, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher is an outbox publisher that fans domain events out into a
+// pending delivery per matching subscription of the event's organization.
+// Deliveries are sent by a Deliverer, so a slow or failing endpoint never
+// holds up the outbox.
+type Dispatcher struct {
+	webhooks repository.WebhookRepository
+}
+
+func NewDispatcher(webhooks repository.WebhookRepository) *Dispatcher {
+	return &Dispatcher{webhooks: webhooks}
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, event models.DomainEvent) error {
+	if event.OrganizationID == nil {
+		return nil
+	}
+
+	subscriptions, err := d.webhooks.ListActive(ctx, *event.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	var payload json.RawMessage
+	var deliveries []models.WebhookDelivery
+	for _, subscription := range subscriptions {
+		if !Matches(subscription.EventTypes, event.Type) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return d.webhooks.EnqueueDeliveries(ctx, deliveries)
+}
@@ -0,0 +1,316 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+	"user-management/internal/models"
+	"user-management/internal/repository"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := time.Unix(1767225600, 0)
+	header := Sign("whsec_test", now, body)
+	require.Regexp(t, `^t=1767225600,v1=[0-9a-f]{64}$`, header)
+
+	require.NoError(t, Verify("whsec_test", header, body, 5*time.Minute, now.Add(time.Minute)))
+	require.ErrorIs(t, Verify("whsec_other", header, body, 5*time.Minute, now), ErrInvalidSignature)
+	require.ErrorIs(t, Verify("whsec_test", header, []byte(`{"id":"2"}`), 5*time.Minute, now), ErrInvalidSignature)
+	require.ErrorIs(t, Verify("whsec_test", header, body, 5*time.Minute, now.Add(10*time.Minute)), ErrInvalidSignature)
+}
+
+func TestMatches(t *testing.T) {
+	require.True(t, Matches(nil, "user.created"))
+	require.True(t, Matches([]string{"*"}, "user.created"))
+	require.True(t, Matches([]string{"role.assigned", "user.*"}, "user.created"))
+	require.False(t, Matches([]string{"user.*"}, "role.created"))
+	require.False(t, Matches([]string{"user.created"}, "user.updated"))
+}
+
+type fakeWebhookRepository struct {
+	repository.WebhookRepository
+	subscriptions map[uuid.UUID]*models.WebhookSubscription
+	deliveries    []*models.WebhookDelivery
+	history       []models.WebhookDelivery
+}
+
+func newFakeWebhookRepository(subscriptions ...*models.WebhookSubscription) *fakeWebhookRepository {
+	f := &fakeWebhookRepository{subscriptions: make(map[uuid.UUID]*models.WebhookSubscription)}
+	for _, subscription := range subscriptions {
+		f.subscriptions[subscription.ID] = subscription
+	}
+	return f
+}
+
+func (f *fakeWebhookRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	subscription := *f.subscriptions[id]
+	return &subscription, nil
+}
+
+func (f *fakeWebhookRepository) ListActive(ctx context.Context, organizationID uuid.UUID) ([]models.WebhookSubscription, error) {
+	var result []models.WebhookSubscription
+	for _, subscription := range f.subscriptions {
+		if subscription.OrganizationID == organizationID && subscription.IsActive {
+			result = append(result, *subscription)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeWebhookRepository) EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	for i := range deliveries {
+		delivery := deliveries[i]
+		delivery.ID = uuid.New()
+		delivery.Status = models.WebhookDeliveryPending
+		f.deliveries = append(f.deliveries, &delivery)
+	}
+	return nil
+}
+
+func (f *fakeWebhookRepository) CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	f.history = append(f.history, *delivery)
+	return nil
+}
+
+// ClaimDueDeliveries ignores next_attempt_at so tests can drive retries
+// without waiting.
+func (f *fakeWebhookRepository) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var result []models.WebhookDelivery
+	for _, delivery := range f.deliveries {
+		if delivery.Status == models.WebhookDeliveryPending && f.subscriptions[delivery.SubscriptionID].IsActive && len(result) < limit {
+			result = append(result, *delivery)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeWebhookRepository) CompleteDelivery(ctx context.Context, delivery *models.WebhookDelivery, disableAfter int) (bool, error) {
+	for _, stored := range f.deliveries {
+		if stored.ID == delivery.ID {
+			*stored = *delivery
+		}
+	}
+	f.history = append(f.history, *delivery)
+
+	subscription := f.subscriptions[delivery.SubscriptionID]
+	if delivery.Status == models.WebhookDeliverySucceeded {
+		subscription.ConsecutiveFailures = 0
+		return false, nil
+	}
+	if !subscription.IsActive {
+		return false, nil
+	}
+	subscription.ConsecutiveFailures++
+	if subscription.ConsecutiveFailures >= disableAfter {
+		subscription.IsActive = false
+		return true, nil
+	}
+	return false, nil
+}
+
+func testSubscription(url string, eventTypes ...string) *models.WebhookSubscription {
+	return &models.WebhookSubscription{
+		ID:             uuid.New(),
+		OrganizationID: uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		URL:            url,
+		EventTypes:     eventTypes,
+		Secret:         "whsec_test",
+		IsActive:       true,
+	}
+}
+
+func TestDispatcherFansOutToMatchingSubscriptions(t *testing.T) {
+	users := testSubscription("http://users", "user.*")
+	roles := testSubscription("http://roles", "role.*")
+	repo := newFakeWebhookRepository(users, roles)
+	dispatcher := NewDispatcher(repo)
+
+	event := models.DomainEvent{ID: uuid.New(), Type: "user.created", OrganizationID: &users.OrganizationID}
+	require.NoError(t, dispatcher.Publish(context.Background(), event))
+	require.Len(t, repo.deliveries, 1)
+	require.Equal(t, users.ID, repo.deliveries[0].SubscriptionID)
+	require.Equal(t, event.ID, repo.deliveries[0].EventID)
+
+	// Events outside any organization have no subscribers.
+	require.NoError(t, dispatcher.Publish(context.Background(), models.DomainEvent{ID: uuid.New(), Type: "user.created"}))
+	require.Len(t, repo.deliveries, 1)
+}
+
+func TestDelivererSignsRetriesAndDisables(t *testing.T) {
+	status := http.StatusInternalServerError
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, Verify("whsec_test", r.Header.Get(HeaderSignature), body, time.Minute, time.Now()))
+		received = append(received, r.Header.Get(HeaderID))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	subscription := testSubscription(server.URL)
+	repo := newFakeWebhookRepository(subscription)
+	require.NoError(t, repo.EnqueueDeliveries(context.Background(), []models.WebhookDelivery{{
+		SubscriptionID: subscription.ID,
+		EventID:        uuid.New(),
+		EventType:      "user.created",
+		Payload:        json.RawMessage(`{"type":"user.created"}`),
+	}}))
+	deliverer := NewDeliverer(repo, DelivererOptions{MaxAttempts: 3, DisableAfter: 5, AllowPrivateNetworks: true})
+
+	succeeded, err := deliverer.DeliverDue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, succeeded)
+	require.Equal(t, models.WebhookDeliveryPending, repo.deliveries[0].Status)
+	require.Equal(t, 500, repo.deliveries[0].ResponseStatus)
+	require.True(t, repo.deliveries[0].NextAttemptAt.After(time.Now().Add(20*time.Second)))
+
+	_, err = deliverer.DeliverDue(context.Background())
+	require.NoError(t, err)
+	_, err = deliverer.DeliverDue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, models.WebhookDeliveryFailed, repo.deliveries[0].Status)
+	require.Equal(t, 3, repo.deliveries[0].Attempts)
+	require.Len(t, received, 3)
+	require.Equal(t, received[0], received[2], "retries keep the delivery ID")
+
+	// Two more failing events reach the disable threshold.
+	status = http.StatusGone
+	for i := 0; i < 2; i++ {
+		require.NoError(t, repo.EnqueueDeliveries(context.Background(), []models.WebhookDelivery{{
+			SubscriptionID: subscription.ID,
+			EventID:        uuid.New(),
+			EventType:      "user.updated",
+			Payload:        json.RawMessage(`{}`),
+		}}))
+	}
+	_, err = deliverer.DeliverDue(context.Background())
+	require.NoError(t, err)
+	require.False(t, subscription.IsActive)
+	require.Len(t, received, 5)
+
+	status = http.StatusNoContent
+	succeeded, err = deliverer.DeliverDue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, succeeded, "disabled subscriptions receive nothing")
+
+	subscription.IsActive = true
+	succeeded, err = deliverer.DeliverDue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, succeeded)
+	require.Zero(t, subscription.ConsecutiveFailures)
+}
+
+func TestDelivererFailsClaimedDeliveriesOfDisabledSubscriptions(t *testing.T) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	subscription := testSubscription(server.URL)
+	repo := newFakeWebhookRepository(subscription)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, repo.EnqueueDeliveries(context.Background(), []models.WebhookDelivery{{
+			SubscriptionID: subscription.ID,
+			EventID:        uuid.New(),
+			EventType:      "user.updated",
+			Payload:        json.RawMessage(`{}`),
+		}}))
+	}
+	deliverer := NewDeliverer(repo, DelivererOptions{DisableAfter: 1, AllowPrivateNetworks: true})
+
+	// Both deliveries are claimed, and the first failure disables the
+	// subscription before the second is sent.
+	_, err := deliverer.DeliverDue(context.Background())
+	require.NoError(t, err)
+	require.False(t, subscription.IsActive)
+	require.Equal(t, 1, received)
+	require.Equal(t, models.WebhookDeliveryFailed, repo.deliveries[1].Status)
+	require.Equal(t, "subscription is disabled", repo.deliveries[1].Error)
+	require.Zero(t, repo.deliveries[1].Attempts)
+	require.Equal(t, 1, subscription.ConsecutiveFailures)
+}
+
+func TestSendTest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, TestEventType, r.Header.Get(HeaderEvent))
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	subscription := testSubscription(server.URL, "user.created")
+	subscription.IsActive = false
+	repo := newFakeWebhookRepository(subscription)
+
+	delivery, err := NewDeliverer(repo, DelivererOptions{AllowPrivateNetworks: true}).SendTest(context.Background(), subscription)
+	require.NoError(t, err)
+	require.Equal(t, models.WebhookDeliverySucceeded, delivery.Status)
+	require.Equal(t, http.StatusOK, delivery.ResponseStatus)
+	require.Len(t, repo.history, 1)
+}
+
+func TestDelivererRefusesPrivateAddresses(t *testing.T) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer server.Close()
+
+	subscription := testSubscription(server.URL, "user.created")
+	localhost := testSubscription(strings.Replace(server.URL, "127.0.0.1", "localhost", 1), "user.created")
+	for _, subscription := range []*models.WebhookSubscription{subscription, localhost} {
+		delivery, err := NewDeliverer(newFakeWebhookRepository(subscription), DelivererOptions{}).SendTest(context.Background(), subscription)
+		require.NoError(t, err)
+		require.Equal(t, models.WebhookDeliveryFailed, delivery.Status)
+		require.Contains(t, delivery.Error, ErrAddressNotAllowed.Error())
+	}
+	require.Zero(t, received)
+
+	for address, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+	} {
+		require.Equal(t, public, publicAddress(netip.MustParseAddr(address)), address)
+	}
+}
+
+func TestDelivererDoesNotFollowRedirects(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	subscription := testSubscription(server.URL, "user.created")
+	delivery, err := NewDeliverer(newFakeWebhookRepository(subscription), DelivererOptions{AllowPrivateNetworks: true}).SendTest(context.Background(), subscription)
+	require.NoError(t, err)
+	require.Equal(t, models.WebhookDeliveryFailed, delivery.Status)
+	require.Equal(t, http.StatusTemporaryRedirect, delivery.ResponseStatus)
+	require.False(t, redirected)
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// MaxChecks is the most checks CheckBatch accepts in one call.
+const MaxChecks = 100
+
+// Reasons of a Decision.
+const (
+	ReasonGranted    = "granted"
+	ReasonNotGranted = "not_granted"
+	// ReasonError is given when the permissions of the subject could not
+	// be resolved. Such decisions deny, but should not be cached.
+	ReasonError = "resolution_failed"
+)
+
+// Check asks whether a user or service account holds a permission in an
+// organization. When Resource is set, Permission is an action on it.
+type Check struct {
+	UserID         uuid.UUID `json:"user_id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Permission     string    `json:"permission"`
+	Resource       string    `json:"resource,omitempty"`
+}
+
+// Decision answers a Check.
+type Decision struct {
+	Check
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+type checkRequest struct {
+	Checks []Check `json:"checks"`
+}
+
+type checkResponse struct {
+	Decisions []Decision `json:"decisions"`
+}
+
+// Check evaluates a single check.
+func (c *Client) Check(ctx context.Context, check Check) (*Decision, error) {
+	decisions, err := c.CheckBatch(ctx, []Check{check})
+	if err != nil {
+		return nil, err
+	}
+	return &decisions[0], nil
+}
+
+// CheckBatch evaluates up to MaxChecks checks in one request. Decisions are
+// returned in the order of the checks.
+func (c *Client) CheckBatch(ctx context.Context, checks []Check) ([]Decision, error) {
+	if len(checks) == 0 || len(checks) > MaxChecks {
+		return nil, fmt.Errorf("between 1 and %d checks are allowed, got %d", MaxChecks, len(checks))
+	}
+
+	var resp checkResponse
+	if err := c.do(ctx, http.MethodPost, "/authz/check", checkRequest{Checks: checks}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Decisions) != len(checks) {
+		return nil, fmt.Errorf("expected %d decisions, got %d", len(checks), len(resp.Decisions))
+	}
+	return resp.Decisions, nil
+}
@@ -0,0 +1,283 @@
+// Package client is a typed client for the HTTP API of the user management
+// service, for the services that depend on it.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"user-management/internal/oidc"
+)
+
+const (
+	apiPrefix = "/api"
+
+	headerAPIKey         = "X-API-Key"
+	headerOrganizationID = "X-Organization-ID"
+
+	defaultTimeout = 10 * time.Second
+	// tokenExpiryMargin renews client credentials tokens this long before
+	// they expire, so that they do not expire in flight.
+	tokenExpiryMargin = 30 * time.Second
+)
+
+// Config configures a Client. At most one of Token, APIKey and ClientID
+// may be set; the authz endpoints need the credentials of a service
+// account.
+type Config struct {
+	// BaseURL is the root of the service, such as https://users.example.com.
+	BaseURL string
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// Token is a bearer access token sent with every request.
+	Token string
+	// APIKey is a personal API key sent in the X-API-Key header.
+	APIKey string
+	// ClientID and ClientSecret are the credentials of a service account.
+	// The client fetches tokens with the client_credentials grant and
+	// renews them before they expire.
+	ClientID     string
+	ClientSecret string
+	// Scopes limit the tokens of the service account to these permissions.
+	Scopes []string
+	// OrganizationID is sent in the X-Organization-ID header, for tokens
+	// that are not scoped to an organization.
+	OrganizationID string
+}
+
+// Client calls the API of the service. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	conf    Config
+
+	mu    sync.Mutex
+	token *Token
+	now   func() time.Time
+}
+
+// New returns a client for the service at conf.BaseURL.
+func New(conf Config) (*Client, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(conf.BaseURL, "/"))
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", conf.BaseURL)
+	}
+
+	credentials := 0
+	for _, set := range []bool{conf.Token != "", conf.APIKey != "", conf.ClientID != ""} {
+		if set {
+			credentials++
+		}
+	}
+	if credentials > 1 {
+		return nil, errors.New("only one of token, API key and client credentials may be set")
+	}
+
+	httpClient := conf.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	return &Client{
+		baseURL: baseURL,
+		http:    httpClient,
+		conf:    conf,
+		now:     time.Now,
+	}, nil
+}
+
+// Error is a response of the API with a status of 400 or above.
+type Error struct {
+	StatusCode int
+	Message    string
+	// Errors holds the failed validations of a request, keyed by field.
+	Errors map[string]interface{}
+	// Code is the OAuth error code of the token endpoint.
+	Code string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("user management: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("user management: %d %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 response.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsForbidden reports whether err is a 403 response.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsUnauthorized reports whether err is a 401 response.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+func hasStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+type errorBody struct {
+	Message          string                 `json:"message"`
+	Errors           map[string]interface{} `json:"errors"`
+	Code             string                 `json:"error"`
+	ErrorDescription string                 `json:"error_description"`
+}
+
+type successBody struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// Token is an access token of the client_credentials grant.
+type Token struct {
+	AccessToken string
+	Scope       string
+	ExpiresAt   time.Time
+}
+
+// Token returns an access token for the service account of the
+// configuration, fetching a new one when the last is about to expire.
+func (c *Client) Token(ctx context.Context) (*Token, error) {
+	if c.conf.ClientID == "" {
+		return nil, errors.New("no client credentials configured")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != nil && c.now().Add(tokenExpiryMargin).Before(c.token.ExpiresAt) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {oidc.GrantTypeClientCredentials}}
+	if len(c.conf.Scopes) > 0 {
+		form.Set("scope", oidc.FormatScope(c.conf.Scopes))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(oidc.TokenPath), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(c.conf.ClientSecret))
+
+	var resp oidc.TokenResponse
+	if err := c.send(req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch access token: %w", err)
+	}
+
+	c.token = &Token{
+		AccessToken: resp.AccessToken,
+		Scope:       resp.Scope,
+		ExpiresAt:   c.now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	return c.token, nil
+}
+
+func (c *Client) url(path string) string {
+	return c.baseURL.String() + path
+}
+
+// do sends a request to the API and decodes the data of its response into
+// out, when out is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url(apiPrefix+path), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := c.authenticate(ctx, req); err != nil {
+		return err
+	}
+
+	if out == nil {
+		return c.send(req, nil)
+	}
+	var envelope successBody
+	if err := c.send(req, &envelope); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) authenticate(ctx context.Context, req *http.Request) error {
+	switch {
+	case c.conf.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.conf.Token)
+	case c.conf.APIKey != "":
+		req.Header.Set(headerAPIKey, c.conf.APIKey)
+	case c.conf.ClientID != "":
+		token, err := c.Token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+	if c.conf.OrganizationID != "" {
+		req.Header.Set(headerOrganizationID, c.conf.OrganizationID)
+	}
+	return nil
+}
+
+// send performs req and decodes the JSON response into out, when out is
+// not nil, or returns an *Error for unsuccessful responses.
+func (c *Client) send(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var body errorBody
+		if json.NewDecoder(resp.Body).Decode(&body) == nil {
+			switch {
+			case body.Code != "":
+				apiErr.Code = body.Code
+				apiErr.Message = body.ErrorDescription
+			case body.Message != "":
+				apiErr.Message = body.Message
+				apiErr.Errors = body.Errors
+			}
+		}
+		return apiErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func success(data interface{}) map[string]interface{} {
+	return map[string]interface{}{"status": "success", "data": data}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	_, err := New(Config{BaseURL: "users.example.com"})
+	require.Error(t, err)
+
+	_, err = New(Config{BaseURL: "https://users.example.com", Token: "token", APIKey: "pat_key"})
+	require.Error(t, err)
+
+	_, err = New(Config{BaseURL: "https://users.example.com/", APIKey: "pat_key"})
+	require.NoError(t, err)
+}
+
+func TestClientCredentials(t *testing.T) {
+	var issued atomic.Int32
+	accountID, roleID := uuid.New(), uuid.New()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, ok := r.BasicAuth()
+		if !ok || clientID != "sa_client" || secret != "sa_secret" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": "client authentication failed"})
+			return
+		}
+		require.Equal(t, "client_credentials", r.PostFormValue("grant_type"))
+		require.Equal(t, "roles:read", r.PostFormValue("scope"))
+		n := issued.Add(1)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "token-" + strconv.Itoa(int(n)),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"scope":        "roles:read",
+		})
+	})
+	mux.HandleFunc("GET /api/service-accounts/{id}/roles", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-"+strconv.Itoa(int(issued.Load())) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "error", "message": "Invalid token"})
+			return
+		}
+		if r.PathValue("id") != accountID.String() {
+			writeJSON(w, http.StatusNotFound, map[string]string{"status": "error", "message": "Service account not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, success([]Role{{ID: roleID, Name: "reader"}}))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c, err := New(Config{BaseURL: server.URL, ClientID: "sa_client", ClientSecret: "sa_secret", Scopes: []string{"roles:read"}})
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	roles, err := c.ListServiceAccountRoles(ctx, accountID)
+	require.NoError(t, err)
+	require.Equal(t, []Role{{ID: roleID, Name: "reader"}}, roles)
+
+	_, err = c.ListServiceAccountRoles(ctx, accountID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, issued.Load(), "tokens are reused until they expire")
+
+	now = now.Add(time.Hour - tokenExpiryMargin)
+	_, err = c.ListServiceAccountRoles(ctx, accountID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, issued.Load())
+
+	_, err = c.ListServiceAccountRoles(ctx, uuid.New())
+	require.True(t, IsNotFound(err))
+	require.Equal(t, "Service account not found", err.(*Error).Message)
+
+	c, err = New(Config{BaseURL: server.URL, ClientID: "sa_client", ClientSecret: "wrong"})
+	require.NoError(t, err)
+	_, err = c.ListServiceAccountRoles(ctx, accountID)
+	require.True(t, IsUnauthorized(err))
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, "invalid_client", apiErr.Code)
+}
+
+func TestUsers(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users/me/sessions", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "pat_key", r.Header.Get("X-API-Key"))
+		require.Equal(t, "org", r.Header.Get("X-Organization-ID"))
+		writeJSON(w, http.StatusOK, success([]Session{{ID: sessionID, Device: "Firefox on Linux", Current: true}}))
+	})
+	mux.HandleFunc("DELETE /api/users/me/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, sessionID.String(), r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/users/{id}/logout", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, userID.String(), r.PathValue("id"))
+		writeJSON(w, http.StatusOK, success(map[string]int{"revoked": 3}))
+	})
+	mux.HandleFunc("POST /api/users/{id}/unlock", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"status": "error", "message": "Permission denied"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c, err := New(Config{BaseURL: server.URL, APIKey: "pat_key", OrganizationID: "org"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	sessions, err := c.ListSessions(ctx)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.True(t, sessions[0].Current)
+
+	require.NoError(t, c.RevokeSession(ctx, sessionID))
+
+	revoked, err := c.LogoutUser(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, 3, revoked)
+
+	err = c.UnlockUser(ctx, userID)
+	require.True(t, IsForbidden(err))
+}
+
+func TestCheckBatch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/authz/check", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var req checkRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		resp := checkResponse{}
+		for _, check := range req.Checks {
+			allowed := check.Permission == "user:read"
+			reason := ReasonNotGranted
+			if allowed {
+				reason = ReasonGranted
+			}
+			resp.Decisions = append(resp.Decisions, Decision{Check: check, Allowed: allowed, Reason: reason})
+		}
+		writeJSON(w, http.StatusOK, success(resp))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c, err := New(Config{BaseURL: server.URL, Token: "token"})
+	require.NoError(t, err)
+	ctx := context.Background()
+	check := Check{UserID: uuid.New(), OrganizationID: uuid.New(), Permission: "user:read"}
+
+	decision, err := c.Check(ctx, check)
+	require.NoError(t, err)
+	require.True(t, decision.Allowed)
+	require.Equal(t, check, decision.Check)
+
+	denied := check
+	denied.Permission = "user:write"
+	decisions, err := c.CheckBatch(ctx, []Check{check, denied})
+	require.NoError(t, err)
+	require.True(t, decisions[0].Allowed)
+	require.False(t, decisions[1].Allowed)
+	require.Equal(t, ReasonNotGranted, decisions[1].Reason)
+
+	_, err = c.CheckBatch(ctx, nil)
+	require.Error(t, err)
+}
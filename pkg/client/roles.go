@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+// Role is a role of an organization.
+type Role struct {
+	ID             uuid.UUID `json:"id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	IsSystemRole   bool      `json:"is_system_role"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type assignRoleRequest struct {
+	RoleID uuid.UUID `json:"role_id"`
+}
+
+// ListServiceAccountRoles returns the roles of a service account. Managing
+// service accounts requires the service_accounts:manage permission.
+func (c *Client) ListServiceAccountRoles(ctx context.Context, accountID uuid.UUID) ([]Role, error) {
+	var roles []Role
+	if err := c.do(ctx, http.MethodGet, serviceAccountRolesPath(accountID), nil, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// AssignServiceAccountRole gives a service account a role of its
+// organization.
+func (c *Client) AssignServiceAccountRole(ctx context.Context, accountID, roleID uuid.UUID) error {
+	return c.do(ctx, http.MethodPost, serviceAccountRolesPath(accountID), assignRoleRequest{RoleID: roleID}, nil)
+}
+
+// RemoveServiceAccountRole takes a role from a service account.
+func (c *Client) RemoveServiceAccountRole(ctx context.Context, accountID, roleID uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, serviceAccountRolesPath(accountID)+"/"+roleID.String(), nil, nil)
+}
+
+func serviceAccountRolesPath(accountID uuid.UUID) string {
+	return "/service-accounts/" + accountID.String() + "/roles"
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+// Session is a login of the current user. Current marks the session of the
+// token the request was made with.
+type Session struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+	Device         string     `json:"device"`
+	UserAgent      string     `json:"user_agent"`
+	IPAddress      string     `json:"ip_address"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastSeenAt     time.Time  `json:"last_seen_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	Current        bool       `json:"current"`
+}
+
+type revokedSessions struct {
+	Revoked int `json:"revoked"`
+}
+
+// ListSessions returns the active sessions of the current user.
+func (c *Client) ListSessions(ctx context.Context) ([]Session, error) {
+	var sessions []Session
+	if err := c.do(ctx, http.MethodGet, "/users/me/sessions", nil, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the current user's sessions.
+func (c *Client) RevokeSession(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/users/me/sessions/"+id.String(), nil, nil)
+}
+
+// RevokeOtherSessions ends every session of the current user except the
+// one of the client's token, returning how many were ended.
+func (c *Client) RevokeOtherSessions(ctx context.Context) (int, error) {
+	var resp revokedSessions
+	if err := c.do(ctx, http.MethodDelete, "/users/me/sessions", nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Revoked, nil
+}
+
+// LogoutUser ends every session of a member of the organization, returning
+// how many were ended. It requires the user:logout permission.
+func (c *Client) LogoutUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	var resp revokedSessions
+	if err := c.do(ctx, http.MethodPost, "/users/"+userID.String()+"/logout", nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Revoked, nil
+}
+
+// UnlockUser lifts the lockout of a user after failed logins. It requires
+// the user:unlock permission.
+func (c *Client) UnlockUser(ctx context.Context, userID uuid.UUID) error {
+	return c.do(ctx, http.MethodPost, "/users/"+userID.String()+"/unlock", nil, nil)
+}
@@ -0,0 +1,61 @@
+package verifier
+
+import (
+	"sync"
+	"time"
+	"user-management/pkg/client"
+)
+
+// decisionCache holds permission decisions until they expire. Once it
+// holds max decisions, expired ones are dropped, and all of them when none
+// have expired.
+type decisionCache struct {
+	ttl     time.Duration
+	max     int
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[client.Check]decision
+}
+
+type decision struct {
+	allowed   bool
+	expiresAt time.Time
+}
+
+func newDecisionCache(ttl time.Duration, max int, now func() time.Time) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		max:     max,
+		now:     now,
+		entries: make(map[client.Check]decision),
+	}
+}
+
+func (c *decisionCache) get(check client.Check) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[check]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		return false, false
+	}
+	return entry.allowed, true
+}
+
+func (c *decisionCache) put(check client.Check, allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.max {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.max {
+			clear(c.entries)
+		}
+	}
+	c.entries[check] = decision{allowed: allowed, expiresAt: now.Add(c.ttl)}
+}
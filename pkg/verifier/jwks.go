@@ -0,0 +1,121 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"user-management/internal/oidc"
+)
+
+// keySet caches the public keys of the service's JWKS. It is refreshed
+// when it is older than refreshInterval, and on a key ID it does not know.
+// Fetches are attempted at most once per minRefreshInterval, so neither
+// tokens with made-up key IDs nor an unreachable service cause a fetch per
+// request.
+type keySet struct {
+	url                string
+	http               *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	// fetch serializes refreshes, so that concurrent misses fetch once.
+	fetch       sync.Mutex
+	mu          sync.RWMutex
+	keys        map[string]jwk
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	err         error
+}
+
+type jwk struct {
+	algorithm string
+	key       crypto.PublicKey
+}
+
+// key returns the public key with keyID and the algorithm it is for. Stale
+// keys keep verifying while the key set cannot be fetched.
+func (s *keySet) key(ctx context.Context, keyID string) (jwk, error) {
+	key, found, fresh := s.cached(keyID)
+	if found && fresh {
+		return key, nil
+	}
+
+	err := s.refresh(ctx)
+	if key, found, _ = s.cached(keyID); found {
+		return key, nil
+	}
+	if err != nil {
+		return jwk{}, err
+	}
+	return jwk{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, keyID)
+}
+
+func (s *keySet) cached(keyID string) (jwk, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[keyID]
+	return key, ok, s.keys != nil && s.now().Sub(s.fetchedAt) < s.refreshInterval
+}
+
+// refresh fetches the key set, unless the last attempt was less than
+// minRefreshInterval ago, in which case it returns that attempt's error.
+func (s *keySet) refresh(ctx context.Context) error {
+	s.fetch.Lock()
+	defer s.fetch.Unlock()
+
+	s.mu.RLock()
+	recent, lastErr := s.now().Sub(s.attemptedAt) < s.minRefreshInterval, s.err
+	s.mu.RUnlock()
+	if recent {
+		return lastErr
+	}
+
+	keys, err := s.load(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = s.now()
+	s.err = err
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	s.fetchedAt = s.attemptedAt
+	return nil
+}
+
+func (s *keySet) load(ctx context.Context) (map[string]jwk, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch signing keys: status %d", resp.StatusCode)
+	}
+
+	var set oidc.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode signing keys: %w", err)
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, key := range set.Keys {
+		publicKey, err := key.PublicKey()
+		if err != nil {
+			// Keys of types this version does not know are skipped.
+			continue
+		}
+		keys[key.KeyID] = jwk{algorithm: key.Algorithm, key: publicKey}
+	}
+	return keys, nil
+}
@@ -0,0 +1,162 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// HeaderOrganizationID names the organization of requests whose token is
+// not scoped to one.
+const HeaderOrganizationID = "X-Organization-ID"
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims the middleware stored in the context
+// of an authenticated request.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// OrganizationID returns the organization of an authenticated request: the
+// one of its token, or that of the X-Organization-ID header for tokens that
+// are not scoped to one.
+func OrganizationID(r *http.Request) (uuid.UUID, bool) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		return uuid.Nil, false
+	}
+	value := claims.OrganizationID
+	if value == "" {
+		value = r.Header.Get(HeaderOrganizationID)
+	}
+	id, err := uuid.Parse(value)
+	return id, err == nil
+}
+
+// Middleware rejects requests without a valid bearer access token and
+// stores the claims of the token in the request context.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, status, message := v.authenticate(r)
+		if status != 0 {
+			writeError(w, status, message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission must wrap handlers behind Middleware. It rejects callers
+// that do not hold permission in their current organization, or whose token
+// is scoped to other permissions.
+func (v *Verifier) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if status, message := v.authorize(r, permission); status != 0 {
+				writeError(w, status, message)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Gin is Middleware for Gin.
+func (v *Verifier) Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, status, message := v.authenticate(c.Request)
+		if status != 0 {
+			abort(c, status, message)
+			return
+		}
+		c.Request = r
+		c.Next()
+	}
+}
+
+// GinRequirePermission is RequirePermission for Gin. It must run after Gin.
+func (v *Verifier) GinRequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if status, message := v.authorize(c.Request, permission); status != 0 {
+			abort(c, status, message)
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticate returns r with the claims of its token, or the status and
+// message to reject it with.
+func (v *Verifier) authenticate(r *http.Request) (*http.Request, int, string) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return r, http.StatusUnauthorized, "Missing bearer token"
+	}
+
+	claims, err := v.Verify(r.Context(), token)
+	if errors.Is(err, ErrInvalidToken) {
+		return r, http.StatusUnauthorized, "Invalid token"
+	}
+	if err != nil {
+		log.Printf("failed to verify token: %v\n", err)
+		return r, http.StatusServiceUnavailable, "Failed to verify token"
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)), 0, ""
+}
+
+func (v *Verifier) authorize(r *http.Request, permission string) (int, string) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		return http.StatusUnauthorized, "Missing bearer token"
+	}
+	organizationID, ok := OrganizationID(r)
+	if !ok {
+		return http.StatusForbidden, "Organization required"
+	}
+	if !claims.AllowsPermission(permission) {
+		return http.StatusForbidden, "Insufficient scope"
+	}
+
+	allowed, err := v.HasPermission(r.Context(), claims, organizationID, permission)
+	if err != nil {
+		log.Printf("%v\n", err)
+		return http.StatusServiceUnavailable, "Failed to check permission"
+	}
+	if !allowed {
+		return http.StatusForbidden, "Permission denied"
+	}
+	return 0, ""
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[7:])
+	return token, token != ""
+}
+
+// errorResponse has the shape of the error responses of the service.
+type errorResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Status: "error", Message: message})
+}
+
+func abort(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, errorResponse{Status: "error", Message: message})
+}
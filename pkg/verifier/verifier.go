@@ -0,0 +1,238 @@
+// Package verifier verifies the access tokens of the user management
+// service in the services that accept them, and checks the permissions of
+// their callers.
+//
+// Tokens are verified locally against the public keys the service publishes
+// at /.well-known/jwks.json, which are cached. Permission checks call the
+// authz API of the service as a service account and cache its decisions.
+// Neither notices a revoked session or a removed role before the cache or
+// the token expires, and API keys are not accepted, as they cannot be
+// verified locally.
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"user-management/internal/oidc"
+	"user-management/pkg/client"
+)
+
+// ErrInvalidToken is returned for tokens that do not verify. Other errors
+// mean the keys of the service could not be fetched.
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	defaultRefreshInterval    = 5 * time.Minute
+	defaultMinRefreshInterval = 30 * time.Second
+	defaultDecisionTTL        = time.Minute
+	defaultMaxDecisions       = 10000
+	defaultTimeout            = 10 * time.Second
+)
+
+// Config configures a Verifier. Durations of zero or less use the defaults.
+type Config struct {
+	// URL is the root of the service, such as https://users.example.com.
+	URL string
+	// Issuer is the iss claim of the tokens, the jwt.issuer of the
+	// service's configuration.
+	Issuer string
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// ClientID and ClientSecret are the credentials of the service account
+	// that permission checks are made as. Only the permissions of its own
+	// organization can be checked.
+	ClientID     string
+	ClientSecret string
+	// RefreshInterval is how long the key set is cached. Defaults to 5m,
+	// the max-age the service sends with it.
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits fetches of the key set on tokens signed
+	// with a key that is not cached. Defaults to 30s.
+	MinRefreshInterval time.Duration
+	// DecisionTTL is how long permission decisions are cached. Defaults
+	// to 1m.
+	DecisionTTL time.Duration
+	// MaxDecisions bounds the decision cache. Defaults to 10000.
+	MaxDecisions int
+	// Leeway is the clock skew tolerated when checking expiry.
+	Leeway time.Duration
+}
+
+// Verifier verifies access tokens and checks the permissions of their
+// subjects. It is safe for concurrent use.
+type Verifier struct {
+	issuer    string
+	leeway    time.Duration
+	keys      *keySet
+	decisions *decisionCache
+	authz     *client.Client
+	now       func() time.Time
+}
+
+// New returns a verifier for the tokens of the service at conf.URL.
+func New(conf Config) (*Verifier, error) {
+	if conf.Issuer == "" {
+		return nil, errors.New("issuer is required")
+	}
+
+	httpClient := conf.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	authz, err := client.New(client.Config{
+		BaseURL:      conf.URL,
+		HTTPClient:   httpClient,
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshInterval := conf.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+	minRefreshInterval := conf.MinRefreshInterval
+	if minRefreshInterval <= 0 {
+		minRefreshInterval = defaultMinRefreshInterval
+	}
+	decisionTTL := conf.DecisionTTL
+	if decisionTTL <= 0 {
+		decisionTTL = defaultDecisionTTL
+	}
+	maxDecisions := conf.MaxDecisions
+	if maxDecisions <= 0 {
+		maxDecisions = defaultMaxDecisions
+	}
+
+	v := &Verifier{
+		issuer: conf.Issuer,
+		leeway: conf.Leeway,
+		authz:  authz,
+		now:    time.Now,
+	}
+	v.keys = &keySet{
+		url:                strings.TrimSuffix(conf.URL, "/") + oidc.JWKSPath,
+		http:               httpClient,
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minRefreshInterval,
+		now:                func() time.Time { return v.now() },
+	}
+	v.decisions = newDecisionCache(decisionTTL, maxDecisions, func() time.Time { return v.now() })
+	return v, nil
+}
+
+// Principals of tokens that do not come from a user's login.
+const (
+	PrincipalServiceAccount = "service_account"
+)
+
+// Claims are the claims of an access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Email          string   `json:"email,omitempty"`
+	OrganizationID string   `json:"org_id,omitempty"`
+	AMR            []string `json:"amr,omitempty"`
+	TokenUse       string   `json:"token_use,omitempty"`
+	SessionID      string   `json:"sid,omitempty"`
+	// Principal is empty for the tokens of logins.
+	Principal string `json:"principal,omitempty"`
+	// Scope lists the permissions a token is limited to, space separated.
+	// Tokens without one carry every permission of their subject's roles.
+	Scope string `json:"scope,omitempty"`
+}
+
+// UserID returns the subject of the token, a user or a service account.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// AllowsPermission reports whether the scope of the token admits
+// permission. The subject must still hold it through its roles.
+func (c *Claims) AllowsPermission(permission string) bool {
+	return c.Scope == "" || slices.Contains(strings.Fields(c.Scope), permission)
+}
+
+// Verify checks the signature, issuer and expiry of an access token. Tokens
+// other than access tokens, such as ID tokens and the access tokens of
+// OpenID Connect clients, are rejected.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	var keyErr error
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, err := v.keys.key(ctx, keyID)
+		if err != nil {
+			keyErr = err
+			return nil, err
+		}
+		if key.algorithm != "" && key.algorithm != token.Method.Alg() {
+			return nil, errors.New("algorithm does not match the key")
+		}
+		return key.key, nil
+	}
+
+	claims := &Claims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, keyFunc,
+		jwt.WithValidMethods(oidc.SigningAlgorithms),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.leeway),
+		jwt.WithTimeFunc(v.now),
+	)
+	if keyErr != nil && !errors.Is(keyErr, ErrInvalidToken) {
+		return nil, keyErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if typ, ok := parsed.Header["typ"]; ok && typ != "JWT" {
+		return nil, fmt.Errorf("%w: unexpected token type", ErrInvalidToken)
+	}
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	if claims.TokenUse != "" {
+		return nil, fmt.Errorf("%w: not an access token", ErrInvalidToken)
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, fmt.Errorf("%w: invalid subject", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// HasPermission reports whether the subject of claims holds permission in
+// the organization, and the scope of its token admits it. Decisions are
+// cached for the DecisionTTL of the configuration.
+func (v *Verifier) HasPermission(ctx context.Context, claims *Claims, organizationID uuid.UUID, permission string) (bool, error) {
+	if !claims.AllowsPermission(permission) {
+		return false, nil
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid subject", ErrInvalidToken)
+	}
+
+	check := client.Check{UserID: userID, OrganizationID: organizationID, Permission: permission}
+	if allowed, ok := v.decisions.get(check); ok {
+		return allowed, nil
+	}
+
+	decision, err := v.authz.Check(ctx, check)
+	if err != nil {
+		return false, fmt.Errorf("failed to check permission %s: %w", permission, err)
+	}
+	if decision.Reason == client.ReasonError {
+		return false, fmt.Errorf("failed to check permission %s: %s", permission, decision.Reason)
+	}
+
+	v.decisions.put(check, decision.Allowed)
+	return decision.Allowed, nil
+}
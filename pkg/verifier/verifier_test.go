@@ -0,0 +1,319 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user-management/internal/api/handler"
+	"user-management/internal/api/route"
+	"user-management/internal/auth"
+	"user-management/internal/config"
+	"user-management/internal/models"
+	"user-management/internal/oidc"
+	"user-management/internal/repository"
+	"user-management/internal/service"
+	"user-management/pkg/client"
+)
+
+const testIssuer = "user-management"
+
+// fakeKeySource signs with one key at a time and publishes all of them.
+type fakeKeySource struct {
+	mu     sync.Mutex
+	active *oidc.SigningKey
+	keys   []*oidc.SigningKey
+}
+
+func (s *fakeKeySource) rotate(t *testing.T, algorithm string) *oidc.SigningKey {
+	key, err := oidc.GenerateSigningKey(algorithm)
+	require.NoError(t, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = key
+	s.keys = append(s.keys, key)
+	return key
+}
+
+func (s *fakeKeySource) SigningKey(ctx context.Context) (*oidc.SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, nil
+}
+
+func (s *fakeKeySource) PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if key.ID == keyID {
+			return key.Public(), nil
+		}
+	}
+	return nil, errors.New("unknown key")
+}
+
+func (s *fakeKeySource) jwks(t *testing.T) oidc.JWKS {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := oidc.JWKS{}
+	for _, key := range s.keys {
+		jwk, err := oidc.NewJWK(key.ID, key.Public())
+		require.NoError(t, err)
+		jwk.Algorithm = key.Algorithm
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+type fakeUserRoleRepository struct {
+	repository.UserRoleRepository
+	permissions map[uuid.UUID][]string
+	calls       atomic.Int32
+}
+
+func (r *fakeUserRoleRepository) GetUserPermissions(ctx context.Context, userID, organizationID uuid.UUID) ([]models.Permission, error) {
+	r.calls.Add(1)
+	var permissions []models.Permission
+	for _, name := range r.permissions[userID] {
+		permissions = append(permissions, models.Permission{Name: name})
+	}
+	return permissions, nil
+}
+
+type testService struct {
+	// account is the service account the verifier checks permissions as.
+	account     *models.ServiceAccount
+	keys        *fakeKeySource
+	tokens      *auth.TokenManager
+	userRoles   *fakeUserRoleRepository
+	jwksFetches atomic.Int32
+	server      *httptest.Server
+}
+
+func newTestService(t *testing.T) *testService {
+	gin.SetMode(gin.TestMode)
+
+	s := &testService{
+		account:   &models.ServiceAccount{ID: uuid.New(), OrganizationID: uuid.New()},
+		keys:      &fakeKeySource{},
+		userRoles: &fakeUserRoleRepository{permissions: make(map[uuid.UUID][]string)},
+	}
+	s.keys.rotate(t, oidc.AlgorithmES256)
+	s.tokens = auth.NewTokenManager(config.JWTConfig{Secret: "secret", Issuer: testIssuer, AccessTokenTTL: time.Hour})
+	s.tokens.SetKeySource(s.keys)
+
+	router := gin.New()
+	router.GET(oidc.JWKSPath, func(c *gin.Context) {
+		s.jwksFetches.Add(1)
+		c.JSON(http.StatusOK, s.keys.jwks(t))
+	})
+	router.POST(oidc.TokenPath, func(c *gin.Context) {
+		if clientID, secret, _ := c.Request.BasicAuth(); clientID != "sa_client" || secret != "sa_secret" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": oidc.ErrorInvalidClient})
+			return
+		}
+		token, err := s.tokens.GenerateServiceAccountToken(s.account, nil, time.Hour)
+		require.NoError(t, err)
+		c.JSON(http.StatusOK, oidc.TokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: 3600})
+	})
+	route.SetupAuthzRoutes(router.Group("/api"), handler.NewAuthzHandler(validator.New(), service.NewAuthzService(s.userRoles)), s.tokens)
+
+	s.server = httptest.NewServer(router)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *testService) token(t *testing.T, userID, organizationID uuid.UUID) string {
+	token, err := s.tokens.GenerateAccessToken(&models.User{ID: userID, Email: "user@example.com"}, organizationID, auth.AMRPassword)
+	require.NoError(t, err)
+	return token
+}
+
+func newTestVerifier(t *testing.T, s *testService) *Verifier {
+	v, err := New(Config{URL: s.server.URL, Issuer: testIssuer, ClientID: "sa_client", ClientSecret: "sa_secret"})
+	require.NoError(t, err)
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestService(t)
+	v := newTestVerifier(t, s)
+	ctx := context.Background()
+	userID, organizationID := uuid.New(), uuid.New()
+
+	claims, err := v.Verify(ctx, s.token(t, userID, organizationID))
+	require.NoError(t, err)
+	require.Equal(t, userID.String(), claims.Subject)
+	require.Equal(t, organizationID.String(), claims.OrganizationID)
+	require.Equal(t, "user@example.com", claims.Email)
+
+	_, err = v.Verify(ctx, s.token(t, userID, organizationID))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, s.jwksFetches.Load(), "the key set is cached")
+
+	mfaToken, err := s.tokens.GenerateMFAToken(&models.User{ID: userID}, organizationID, auth.TokenUseMFA)
+	require.NoError(t, err)
+	_, err = v.Verify(ctx, mfaToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	hmac := auth.NewTokenManager(config.JWTConfig{Secret: "secret", Issuer: testIssuer, AccessTokenTTL: time.Hour})
+	hmacToken, err := hmac.GenerateAccessToken(&models.User{ID: userID}, organizationID)
+	require.NoError(t, err)
+	_, err = v.Verify(ctx, hmacToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	other, err := New(Config{URL: s.server.URL, Issuer: "someone-else"})
+	require.NoError(t, err)
+	_, err = other.Verify(ctx, s.token(t, userID, organizationID))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	v.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = v.Verify(ctx, s.token(t, userID, organizationID))
+	require.ErrorIs(t, err, ErrInvalidToken, "expired")
+}
+
+func TestVerifyRefreshesKeys(t *testing.T) {
+	s := newTestService(t)
+	v := newTestVerifier(t, s)
+	ctx := context.Background()
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	userID, organizationID := uuid.New(), uuid.New()
+
+	_, err := v.Verify(ctx, s.token(t, userID, organizationID))
+	require.NoError(t, err)
+
+	// A key published after the last fetch is picked up on first use once
+	// the minimum refresh interval has passed.
+	s.keys.rotate(t, oidc.AlgorithmEdDSA)
+	token := s.token(t, userID, organizationID)
+	_, err = v.Verify(ctx, token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.EqualValues(t, 1, s.jwksFetches.Load())
+
+	now = now.Add(defaultMinRefreshInterval)
+	_, err = v.Verify(ctx, token)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, s.jwksFetches.Load())
+
+	// Stale keys keep verifying while the key set cannot be fetched.
+	s.server.Close()
+	now = now.Add(defaultRefreshInterval)
+	_, err = v.Verify(ctx, token)
+	require.NoError(t, err)
+
+	_, err = newTestVerifier(t, s).Verify(ctx, token)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestMiddleware(t *testing.T) {
+	s := newTestService(t)
+	v := newTestVerifier(t, s)
+	userID, organizationID := uuid.New(), s.account.OrganizationID
+	s.userRoles.permissions[userID] = []string{"invoice:read"}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, found := ClaimsFromContext(r.Context())
+		require.True(t, found)
+		require.Equal(t, userID.String(), claims.Subject)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/read", v.Middleware(v.RequirePermission("invoice:read")(ok)))
+	mux.Handle("/write", v.Middleware(v.RequirePermission("invoice:write")(ok)))
+	mux.Handle("/", v.Middleware(ok))
+
+	router := gin.New()
+	router.Use(v.Gin())
+	router.GET("/read", v.GinRequirePermission("invoice:read"), gin.WrapH(ok))
+	router.GET("/write", v.GinRequirePermission("invoice:write"), gin.WrapH(ok))
+	router.GET("/", gin.WrapH(ok))
+
+	for name, h := range map[string]http.Handler{"net/http": mux, "gin": router} {
+		t.Run(name, func(t *testing.T) {
+			serve := func(path, token string, header http.Header) int {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				for key, values := range header {
+					for _, value := range values {
+						req.Header.Add(key, value)
+					}
+				}
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			token := s.token(t, userID, organizationID)
+			require.Equal(t, http.StatusUnauthorized, serve("/", "", nil))
+			require.Equal(t, http.StatusUnauthorized, serve("/", "not-a-token", nil))
+			require.Equal(t, http.StatusOK, serve("/", token, nil))
+			require.Equal(t, http.StatusOK, serve("/read", token, nil))
+			require.Equal(t, http.StatusForbidden, serve("/write", token, nil))
+
+			unscoped := s.token(t, userID, uuid.Nil)
+			require.Equal(t, http.StatusForbidden, serve("/read", unscoped, nil))
+			require.Equal(t, http.StatusOK, serve("/read", unscoped, http.Header{HeaderOrganizationID: {organizationID.String()}}))
+		})
+	}
+
+	// Decisions are cached across both middlewares.
+	require.EqualValues(t, 2, s.userRoles.calls.Load())
+
+	v.now = func() time.Time { return time.Now().Add(defaultDecisionTTL) }
+	allowed, err := v.HasPermission(context.Background(), &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID.String()}}, organizationID, "invoice:read")
+	require.NoError(t, err)
+	require.True(t, allowed)
+	require.EqualValues(t, 3, s.userRoles.calls.Load(), "expired decisions are checked again")
+}
+
+func TestScopedTokens(t *testing.T) {
+	s := newTestService(t)
+	v := newTestVerifier(t, s)
+	account := &models.ServiceAccount{ID: uuid.New(), OrganizationID: s.account.OrganizationID}
+	s.userRoles.permissions[account.ID] = []string{"invoice:read", "invoice:write"}
+
+	token, err := s.tokens.GenerateServiceAccountToken(account, []string{"invoice:read"}, time.Hour)
+	require.NoError(t, err)
+	claims, err := v.Verify(context.Background(), token)
+	require.NoError(t, err)
+	require.Equal(t, PrincipalServiceAccount, claims.Principal)
+
+	allowed, err := v.HasPermission(context.Background(), claims, account.OrganizationID, "invoice:read")
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	allowed, err = v.HasPermission(context.Background(), claims, account.OrganizationID, "invoice:write")
+	require.NoError(t, err)
+	require.False(t, allowed, "held, but outside the scope of the token")
+	require.EqualValues(t, 1, s.userRoles.calls.Load())
+}
+
+func TestPermissionsOfOtherOrganizations(t *testing.T) {
+	s := newTestService(t)
+	userID := uuid.New()
+	s.userRoles.permissions[userID] = []string{"invoice:read"}
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID.String()}}
+
+	_, err := newTestVerifier(t, s).HasPermission(context.Background(), claims, uuid.New(), "invoice:read")
+	require.True(t, client.IsForbidden(errors.Unwrap(err)), "only the organization of the service account can be checked")
+
+	anonymous, err := New(Config{URL: s.server.URL, Issuer: testIssuer})
+	require.NoError(t, err)
+	_, err = anonymous.HasPermission(context.Background(), claims, s.account.OrganizationID, "invoice:read")
+	require.True(t, client.IsUnauthorized(errors.Unwrap(err)))
+	require.Zero(t, s.userRoles.calls.Load())
+}